@@ -0,0 +1,215 @@
+package main
+
+import (
+	"encoding/json"
+	"flag"
+	"fmt"
+	"net/http"
+	"net/url"
+	"strings"
+	"text/tabwriter"
+	"time"
+
+	"github.com/mussietl/csv-sales-api/internal/services"
+)
+
+// httpClient is used for admin API requests
+var httpClient = &http.Client{Timeout: 5 * time.Minute}
+
+// call sends an admin API request and decodes the JSON response into out.
+// Error responses are returned as errors carrying the server's message.
+func (app *cli) call(method, path string, out interface{}) error {
+	if app.apiKey == "" {
+		return fmt.Errorf("an admin API key is required: set -key or ADMIN_API_KEY")
+	}
+	req, err := http.NewRequest(method, strings.TrimRight(app.server, "/")+"/api/v1/admin"+path, nil)
+	if err != nil {
+		return fmt.Errorf("failed to build request: %w", err)
+	}
+	req.Header.Set("X-API-Key", app.apiKey)
+
+	resp, err := httpClient.Do(req)
+	if err != nil {
+		return fmt.Errorf("request to %s failed: %w", app.server, err)
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode >= 300 {
+		var apiErr struct {
+			Error string `json:"error"`
+		}
+		if json.NewDecoder(resp.Body).Decode(&apiErr) == nil && apiErr.Error != "" {
+			return fmt.Errorf("%s %s: %s (%d)", method, path, apiErr.Error, resp.StatusCode)
+		}
+		return fmt.Errorf("%s %s: %s", method, path, resp.Status)
+	}
+	if err := json.NewDecoder(resp.Body).Decode(out); err != nil {
+		return fmt.Errorf("failed to decode response: %w", err)
+	}
+	return nil
+}
+
+// listJobs prints the intake log entries, optionally only those in a state
+func (app *cli) listJobs(args []string) error {
+	flags := flag.NewFlagSet("jobs list", flag.ExitOnError)
+	state := flags.String("state", "", "processing, completed or failed")
+	flags.Parse(args)
+
+	entries, err := app.fetchJobs(*state)
+	if err != nil {
+		return err
+	}
+	w := tabwriter.NewWriter(app.out, 0, 4, 2, ' ', 0)
+	fmt.Fprintln(w, "UPLOAD ID\tSTATE\tAT\tTENANT\tFILENAME\tRESULT / ERROR")
+	for _, entry := range entries {
+		detail := entry.ResultID
+		if entry.Error != "" {
+			detail = entry.Error
+		}
+		fmt.Fprintf(w, "%s\t%s\t%s\t%s\t%s\t%s\n", entry.UploadID, entry.State, entry.At.Format(time.RFC3339), entry.Tenant, entry.Filename, detail)
+	}
+	return w.Flush()
+}
+
+// requeueJobs reprocesses the given failed uploads, or every failed upload
+func (app *cli) requeueJobs(args []string) error {
+	flags := flag.NewFlagSet("jobs requeue", flag.ExitOnError)
+	allFailed := flags.Bool("failed", false, "requeue every failed upload")
+	flags.Parse(args)
+
+	ids := flags.Args()
+	if *allFailed {
+		if len(ids) > 0 {
+			return fmt.Errorf("give either upload IDs or -failed, not both")
+		}
+		entries, err := app.fetchJobs(services.IntakeFailed)
+		if err != nil {
+			return err
+		}
+		for _, entry := range entries {
+			ids = append(ids, entry.UploadID)
+		}
+	}
+	if len(ids) == 0 {
+		return fmt.Errorf("no uploads to requeue")
+	}
+
+	failed := 0
+	for _, id := range ids {
+		var resp struct {
+			Upload services.IntakeEntry `json:"upload"`
+		}
+		if err := app.call(http.MethodPost, "/intake/"+url.PathEscape(id)+"/requeue", &resp); err != nil {
+			fmt.Fprintf(app.out, "%s: %v\n", id, err)
+			failed++
+			continue
+		}
+		fmt.Fprintf(app.out, "%s: completed as result %s\n", id, resp.Upload.ResultID)
+	}
+	if failed > 0 {
+		return fmt.Errorf("%d of %d uploads could not be requeued", failed, len(ids))
+	}
+	return nil
+}
+
+// fetchJobs lists intake log entries through the admin API
+func (app *cli) fetchJobs(state string) ([]services.IntakeEntry, error) {
+	path := "/intake"
+	if state != "" {
+		path += "?state=" + url.QueryEscape(state)
+	}
+	var resp struct {
+		Uploads []services.IntakeEntry `json:"uploads"`
+	}
+	if err := app.call(http.MethodGet, path, &resp); err != nil {
+		return nil, err
+	}
+	return resp.Uploads, nil
+}
+
+// listKeys prints the API keys without their secrets
+func (app *cli) listKeys(args []string) error {
+	flags := flag.NewFlagSet("keys list", flag.ExitOnError)
+	flags.Parse(args)
+
+	var resp struct {
+		APIKeys []services.APIKey `json:"api_keys"`
+	}
+	if err := app.call(http.MethodGet, "/api-keys", &resp); err != nil {
+		return err
+	}
+	w := tabwriter.NewWriter(app.out, 0, 4, 2, ' ', 0)
+	fmt.Fprintln(w, "ID\tNAME\tPREFIX\tSCOPES\tTENANT\tDISABLED\tLAST USED")
+	for _, key := range resp.APIKeys {
+		lastUsed := "-"
+		if key.LastUsedAt != nil {
+			lastUsed = key.LastUsedAt.Format(time.RFC3339)
+		}
+		tenant := key.Tenant
+		if tenant == "" {
+			tenant = "-"
+		}
+		fmt.Fprintf(w, "%s\t%s\t%s\t%s\t%s\t%t\t%s\n", key.ID, key.Name, key.Prefix, strings.Join(key.Scopes, ","), tenant, key.Disabled, lastUsed)
+	}
+	return w.Flush()
+}
+
+// rotateKey issues a new secret for a key and prints it once
+func (app *cli) rotateKey(args []string) error {
+	flags := flag.NewFlagSet("keys rotate", flag.ExitOnError)
+	flags.Parse(args)
+	if flags.NArg() != 1 {
+		return fmt.Errorf("usage: keys rotate <key-id>")
+	}
+
+	var resp struct {
+		Key string `json:"key"`
+	}
+	if err := app.call(http.MethodPost, "/api-keys/"+url.PathEscape(flags.Arg(0))+"/rotate", &resp); err != nil {
+		return err
+	}
+	fmt.Fprintf(app.out, "new secret for %s (shown once): %s\n", flags.Arg(0), resp.Key)
+	return nil
+}
+
+// listPins prints the pinned results, optionally only a tenant's
+func (app *cli) listPins(args []string) error {
+	flags := flag.NewFlagSet("pins list", flag.ExitOnError)
+	tenant := flags.String("tenant", "", "only list this tenant's pins")
+	flags.Parse(args)
+
+	path := "/pins"
+	if *tenant != "" {
+		path += "?tenant=" + url.QueryEscape(*tenant)
+	}
+	var resp struct {
+		Pins []services.Pin `json:"pins"`
+	}
+	if err := app.call(http.MethodGet, path, &resp); err != nil {
+		return err
+	}
+	w := tabwriter.NewWriter(app.out, 0, 4, 2, ' ', 0)
+	fmt.Fprintln(w, "RESULT ID\tTENANT\tPINNED AT\tPINNED BY\tREASON")
+	for _, pin := range resp.Pins {
+		fmt.Fprintf(w, "%s\t%s\t%s\t%s\t%s\n", pin.ResultID, pin.Tenant, pin.PinnedAt.Format(time.RFC3339), pin.PinnedBy, pin.Reason)
+	}
+	return w.Flush()
+}
+
+// unpinResults makes results subject to retention cleanup again
+func (app *cli) unpinResults(args []string) error {
+	flags := flag.NewFlagSet("pins unpin", flag.ExitOnError)
+	flags.Parse(args)
+	if flags.NArg() == 0 {
+		return fmt.Errorf("usage: pins unpin <result-id>...")
+	}
+
+	for _, id := range flags.Args() {
+		var resp struct{}
+		if err := app.call(http.MethodDelete, "/pins/"+url.PathEscape(id), &resp); err != nil {
+			return err
+		}
+		fmt.Fprintf(app.out, "%s: unpinned\n", id)
+	}
+	return nil
+}
@@ -0,0 +1,133 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/mussietl/csv-sales-api/internal/services"
+	"github.com/mussietl/csv-sales-api/pkg/utils"
+	"github.com/sirupsen/logrus"
+)
+
+// intSettings are the integer environment variables read by the server, with
+// their minimum value
+var intSettings = map[string]int{
+	"PORT":                       1,
+	"TENANT_MAX_CONCURRENCY":     0,
+	"IN_MEMORY_MAX_BYTES":        0,
+	"PARALLEL_MIN_BYTES":         0,
+	"PARALLEL_WORKERS":           1,
+	"MAX_CSV_COLUMNS":            1,
+	"MAX_HEADER_BYTES":           1,
+	"SLO_LATENCY_THRESHOLD_MS":   1,
+	"SUPPORT_SAMPLE_ROWS":        0,
+	"EXCHANGE_RATES_TTL_MINUTES": 1,
+	"MAX_UPLOAD_BYTES":           0,
+	"JOB_WORKERS":                1,
+	"FILE_RETENTION_HOURS":       0,
+	"CLAMAV_TIMEOUT_SECONDS":     1,
+	"TENANT_STORAGE_QUOTA_BYTES": 0,
+	"TENANT_MONTHLY_ROW_QUOTA":   0,
+	"UPLOAD_SESSION_TTL_HOURS":   1,
+}
+
+// boolSettings are the boolean environment variables read by the server
+var boolSettings = []string{"REQUIRE_API_KEY", "SUPPORT_RECORDING", "DEV_MODE", "CALLBACK_ALLOW_PRIVATE_NETWORKS"}
+
+// validateConfig checks the server's environment configuration and that its
+// data files can be loaded. The server silently falls back to defaults for
+// malformed numbers and booleans, so those are reported here.
+func (app *cli) validateConfig(args []string) error {
+	flags := flag.NewFlagSet("config validate", flag.ExitOnError)
+	flags.Parse(args)
+
+	var problems []string
+	check := func(setting string, err error) {
+		if err != nil {
+			problems = append(problems, fmt.Sprintf("%s: %v", setting, err))
+		}
+	}
+
+	for key, min := range intSettings {
+		if value := strings.TrimSpace(os.Getenv(key)); value != "" {
+			n, err := strconv.Atoi(value)
+			if err == nil && n < min {
+				err = fmt.Errorf("must be at least %d", min)
+			}
+			check(key, err)
+		}
+	}
+	if port := utils.GetEnvInt("PORT", 8080); port > 65535 {
+		check("PORT", fmt.Errorf("must be at most 65535"))
+	}
+	for _, key := range boolSettings {
+		if value := strings.TrimSpace(os.Getenv(key)); value != "" {
+			_, err := strconv.ParseBool(value)
+			check(key, err)
+		}
+	}
+
+	if level := utils.GetEnv("LOG_LEVEL", ""); level != "" {
+		_, err := logrus.ParseLevel(level)
+		check("LOG_LEVEL", err)
+	}
+	switch scanner := utils.GetEnv("UPLOAD_SCANNER", services.ScannerOff); scanner {
+	case services.ScannerOff, services.ScannerNoop, services.ScannerClamAV:
+	default:
+		check("UPLOAD_SCANNER", fmt.Errorf("must be one of off, noop, clamav, got %q", scanner))
+	}
+	check("NOTIFY_CHANNELS", services.NewNotificationService(app.logger).Configure(utils.GetEnv("NOTIFY_CHANNELS", "")))
+	calendar, err := services.ParseFiscalCalendar(utils.GetEnv("FISCAL_CALENDAR", ""))
+	check("FISCAL_CALENDAR", err)
+	if rates := utils.GetEnv("EXCHANGE_RATES", ""); rates != "" {
+		_, err := services.ParseExchangeRates(rates)
+		check("EXCHANGE_RATES", err)
+	}
+	check("SLO", services.SLOObjectives{
+		Availability:       utils.GetEnvFloat("SLO_AVAILABILITY", services.DefaultSLOObjectives.Availability),
+		LatencyThresholdMs: int64(utils.GetEnvInt("SLO_LATENCY_THRESHOLD_MS", int(services.DefaultSLOObjectives.LatencyThresholdMs))),
+		LatencyTarget:      utils.GetEnvFloat("SLO_LATENCY_TARGET", services.DefaultSLOObjectives.LatencyTarget),
+	}.Validate())
+	if url := utils.GetEnv("HISTORY_DATABASE_URL", ""); url != "" {
+		_, err := services.HistoryDSN(url)
+		check("HISTORY_DATABASE_URL", err)
+	}
+	if policy := utils.GetEnv("INTAKE_RECOVERY", services.RecoveryFail); policy != services.RecoveryFail && policy != services.RecoveryRetry {
+		check("INTAKE_RECOVERY", fmt.Errorf("expected fail or retry, got %q", policy))
+	}
+	if config := objectIntakeConfig(); config.QueueURL != "" {
+		_, err := services.NewObjectIntake(config, nil, app.logger)
+		check("S3_INTAKE_QUEUE_URL", err)
+	}
+
+	// The data files are only read; a missing file is valid and created on startup
+	// Keys are in the history database; a file left by earlier versions is
+	// imported on startup
+	_, err = services.ReadAPIKeyFile(filepath.Join(app.dataDir, "api_keys.json"))
+	check("api_keys.json", err)
+	_, err = services.NewTenantLimiter(filepath.Join(app.dataDir, "tenant_limits.json"), 0, app.logger)
+	check("tenant_limits.json", err)
+	_, err = services.NewFiscalCalendarStore(filepath.Join(app.dataDir, "fiscal_calendars.json"), calendar, app.logger)
+	check("fiscal_calendars.json", err)
+	_, err = services.NewSchemaDriftService(filepath.Join(app.dataDir, "schemas.json"), services.DefaultCSVLimits, app.logger)
+	check("schemas.json", err)
+	_, err = services.NewDepartmentStore(filepath.Join(app.dataDir, "departments.json"), app.logger)
+	check("departments.json", err)
+	_, err = services.ReadIntakeEntries(app.intakePath(), app.logger)
+	check("intake.log", err)
+
+	if len(problems) > 0 {
+		sort.Strings(problems)
+		for _, problem := range problems {
+			fmt.Fprintln(app.out, problem)
+		}
+		return fmt.Errorf("%d configuration problems found", len(problems))
+	}
+	fmt.Fprintln(app.out, "configuration is valid")
+	return nil
+}
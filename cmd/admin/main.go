@@ -0,0 +1,117 @@
+// Command admin runs maintenance tasks against a CSV Sales API deployment.
+// Commands that change server state (jobs, keys) go through the admin API so
+// the running server sees them; storage commands work on the files directly.
+package main
+
+import (
+	"flag"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+
+	"github.com/mussietl/csv-sales-api/internal/services"
+	"github.com/mussietl/csv-sales-api/pkg/utils"
+	"github.com/sirupsen/logrus"
+)
+
+const usage = `Usage: admin [flags] <command> [args]
+
+Storage (run on the server host):
+  uploads list [-from DATE] [-to DATE]       List stored uploads with their intake state
+  uploads expire -older-than DURATION [-dry-run]
+                                             Delete uploads older than DURATION (e.g. 720h)
+  storage check                              Check intake log records against stored files
+  config validate                            Validate environment configuration and data files
+
+Admin API (requires -server and -key):
+  jobs list [-state STATE]                   List intake log entries
+  jobs requeue <upload-id>... | -failed      Reprocess failed uploads
+  keys list                                  List API keys
+  keys rotate <key-id>                       Issue a new secret for an API key
+  pins list [-tenant TENANT]                 List results pinned against retention cleanup
+  pins unpin <result-id>...                  Unpin results
+
+Flags:
+`
+
+// cli holds the global flags shared by all commands
+type cli struct {
+	server     string
+	apiKey     string
+	dataDir    string
+	uploadsDir string
+	out        io.Writer
+	logger     *logrus.Logger
+}
+
+func main() {
+	logger := logrus.New()
+	logger.SetLevel(logrus.WarnLevel)
+
+	app := &cli{out: os.Stdout, logger: logger}
+	flags := flag.NewFlagSet("admin", flag.ExitOnError)
+	flags.StringVar(&app.server, "server", utils.GetEnv("ADMIN_SERVER_URL", "http://localhost:8080"), "base URL of the API server")
+	flags.StringVar(&app.apiKey, "key", os.Getenv("ADMIN_API_KEY"), "API key with the admin scope")
+	flags.StringVar(&app.dataDir, "data-dir", utils.GetEnv("DATA_DIR", "data"), "server data directory")
+	flags.StringVar(&app.uploadsDir, "uploads-dir", utils.GetEnv("UPLOADS_DIR", "public/uploads"), "server uploads directory")
+	flags.Usage = func() {
+		fmt.Fprint(flags.Output(), usage)
+		flags.PrintDefaults()
+	}
+	flags.Parse(os.Args[1:])
+
+	args := flags.Args()
+	if len(args) < 2 {
+		flags.Usage()
+		os.Exit(2)
+	}
+
+	var err error
+	switch args[0] + " " + args[1] {
+	case "uploads list":
+		err = app.listUploads(args[2:])
+	case "uploads expire":
+		err = app.expireUploads(args[2:])
+	case "storage check":
+		err = app.checkStorage(args[2:])
+	case "config validate":
+		err = app.validateConfig(args[2:])
+	case "jobs list":
+		err = app.listJobs(args[2:])
+	case "jobs requeue":
+		err = app.requeueJobs(args[2:])
+	case "keys list":
+		err = app.listKeys(args[2:])
+	case "keys rotate":
+		err = app.rotateKey(args[2:])
+	case "pins list":
+		err = app.listPins(args[2:])
+	case "pins unpin":
+		err = app.unpinResults(args[2:])
+	default:
+		fmt.Fprintf(os.Stderr, "unknown command %q\n\n", args[0]+" "+args[1])
+		flags.Usage()
+		os.Exit(2)
+	}
+	if err != nil {
+		fmt.Fprintln(os.Stderr, "error:", err)
+		os.Exit(1)
+	}
+}
+
+// intakePath is the intake log inside the data directory
+func (app *cli) intakePath() string {
+	return filepath.Join(app.dataDir, "intake.log")
+}
+
+// fileService opens the uploads directory with the server's storage backend
+func (app *cli) fileService() (*services.FileService, error) {
+	fileService := services.NewFileService(app.uploadsDir, app.logger)
+	storage, err := services.NewStorage(storageConfig(), app.uploadsDir)
+	if err != nil {
+		return nil, fmt.Errorf("invalid storage configuration: %w", err)
+	}
+	fileService.SetStorage(storage, 0)
+	return fileService, nil
+}
@@ -0,0 +1,203 @@
+package main
+
+import (
+	"errors"
+	"flag"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+	"text/tabwriter"
+	"time"
+
+	"github.com/mussietl/csv-sales-api/internal/services"
+)
+
+// listUploads prints the stored uploads, oldest first, with their intake state
+func (app *cli) listUploads(args []string) error {
+	flags := flag.NewFlagSet("uploads list", flag.ExitOnError)
+	from := flags.String("from", "", "only uploads from this date (YYYY-MM-DD or RFC 3339)")
+	to := flags.String("to", "", "only uploads up to this date (YYYY-MM-DD or RFC 3339)")
+	flags.Parse(args)
+
+	fromTime, err := parseTime(*from, false)
+	if err != nil {
+		return fmt.Errorf("invalid -from: %w", err)
+	}
+	toTime, err := parseTime(*to, true)
+	if err != nil {
+		return fmt.Errorf("invalid -to: %w", err)
+	}
+	fileService, err := app.fileService()
+	if err != nil {
+		return err
+	}
+	uploads, err := fileService.ListUploads(fromTime, toTime)
+	if err != nil {
+		return err
+	}
+	entries, err := app.intakeEntries()
+	if err != nil {
+		return err
+	}
+
+	w := tabwriter.NewWriter(app.out, 0, 4, 2, ' ', 0)
+	fmt.Fprintln(w, "UPLOAD ID\tUPLOADED AT\tSIZE\tSTATE\tTENANT\tFILENAME")
+	for _, upload := range uploads {
+		size := int64(0)
+		if info, err := os.Stat(upload.Path); err == nil {
+			size = info.Size()
+		}
+		entry, ok := entries[upload.ID]
+		state := "-"
+		if ok {
+			state = entry.State
+		}
+		fmt.Fprintf(w, "%s\t%s\t%d\t%s\t%s\t%s\n", upload.ID, upload.UploadedAt.Format(time.RFC3339), size, state, entry.Tenant, entry.Filename)
+	}
+	return w.Flush()
+}
+
+// expireUploads deletes uploads older than a duration. Uploads still being
+// processed are kept.
+func (app *cli) expireUploads(args []string) error {
+	flags := flag.NewFlagSet("uploads expire", flag.ExitOnError)
+	olderThan := flags.Duration("older-than", 0, "delete uploads older than this, e.g. 720h")
+	dryRun := flags.Bool("dry-run", false, "only print what would be deleted")
+	flags.Parse(args)
+	if *olderThan <= 0 {
+		return fmt.Errorf("-older-than is required and must be positive")
+	}
+
+	fileService, err := app.fileService()
+	if err != nil {
+		return err
+	}
+	uploads, err := fileService.ListUploads(time.Time{}, time.Now().Add(-*olderThan))
+	if err != nil {
+		return err
+	}
+	entries, err := app.intakeEntries()
+	if err != nil {
+		return err
+	}
+
+	deleted := 0
+	for _, upload := range uploads {
+		if entries[upload.ID].State == services.IntakeProcessing {
+			fmt.Fprintf(app.out, "skipping %s: still processing\n", upload.ID)
+			continue
+		}
+		if *dryRun {
+			fmt.Fprintf(app.out, "would delete %s (uploaded %s)\n", upload.ID, upload.UploadedAt.Format(time.RFC3339))
+			continue
+		}
+		if err := fileService.DeleteUpload(upload.ID); err != nil {
+			return err
+		}
+		fmt.Fprintf(app.out, "deleted %s\n", upload.ID)
+		deleted++
+	}
+	if !*dryRun {
+		fmt.Fprintf(app.out, "%d uploads deleted\n", deleted)
+	}
+	return nil
+}
+
+// checkStorage compares the intake log with the files in the uploads
+// directory and reports records pointing at missing files and files no
+// record knows about
+func (app *cli) checkStorage(args []string) error {
+	flags := flag.NewFlagSet("storage check", flag.ExitOnError)
+	flags.Parse(args)
+
+	fileService, err := app.fileService()
+	if err != nil {
+		return err
+	}
+	entries, err := app.intakeEntries()
+	if err != nil {
+		return err
+	}
+	uploads, err := fileService.ListUploads(time.Time{}, time.Time{})
+	if err != nil {
+		return err
+	}
+
+	problems, warnings := 0, 0
+	report := func(level, format string, args ...interface{}) {
+		if level == "ERROR" {
+			problems++
+		} else {
+			warnings++
+		}
+		fmt.Fprintf(app.out, "%-5s "+format+"\n", append([]interface{}{level}, args...)...)
+	}
+
+	for _, entry := range entries {
+		_, statErr := os.Stat(entry.Path)
+		uploadMissing := errors.Is(statErr, os.ErrNotExist)
+		switch entry.State {
+		case services.IntakeProcessing:
+			report("WARN", "%s is still processing since %s; it is recovered on the next server start", entry.UploadID, entry.At.Format(time.RFC3339))
+			if uploadMissing {
+				report("ERROR", "%s is processing but its upload file %s is missing", entry.UploadID, entry.Path)
+			}
+		case services.IntakeFailed:
+			if uploadMissing {
+				report("WARN", "%s failed and its upload file is gone, so it cannot be requeued", entry.UploadID)
+			}
+		case services.IntakeCompleted:
+			if entry.ResultID == "" {
+				report("ERROR", "%s is completed without a result ID", entry.UploadID)
+			} else if _, err := fileService.ResultPath(entry.ResultID); err != nil {
+				report("ERROR", "%s is completed but its result %s is missing", entry.UploadID, entry.ResultID)
+			}
+		}
+	}
+
+	for _, upload := range uploads {
+		if _, ok := entries[upload.ID]; !ok {
+			report("WARN", "upload file %s has no intake log record", filepath.Base(upload.Path))
+		}
+	}
+
+	fmt.Fprintf(app.out, "checked %d intake records and %d upload files: %d errors, %d warnings\n", len(entries), len(uploads), problems, warnings)
+	if problems > 0 {
+		return fmt.Errorf("storage is inconsistent")
+	}
+	return nil
+}
+
+// intakeEntries reads the intake log by upload ID; a missing log is empty
+func (app *cli) intakeEntries() (map[string]services.IntakeEntry, error) {
+	list, err := services.ReadIntakeEntries(app.intakePath(), app.logger)
+	if err != nil {
+		return nil, err
+	}
+	entries := make(map[string]services.IntakeEntry, len(list))
+	for _, entry := range list {
+		entries[entry.UploadID] = entry
+	}
+	return entries, nil
+}
+
+// parseTime parses an RFC 3339 timestamp or a YYYY-MM-DD date. A date used
+// as an upper bound covers the whole day.
+func parseTime(value string, upper bool) (time.Time, error) {
+	value = strings.TrimSpace(value)
+	if value == "" {
+		return time.Time{}, nil
+	}
+	if t, err := time.Parse(time.RFC3339, value); err == nil {
+		return t, nil
+	}
+	t, err := time.ParseInLocation("2006-01-02", value, time.Local)
+	if err != nil {
+		return time.Time{}, fmt.Errorf("expected RFC 3339 timestamp or YYYY-MM-DD date, got %q", value)
+	}
+	if upper {
+		t = t.Add(24*time.Hour - time.Nanosecond)
+	}
+	return t, nil
+}
@@ -0,0 +1,34 @@
+package main
+
+import (
+	"github.com/mussietl/csv-sales-api/internal/services"
+	"github.com/mussietl/csv-sales-api/pkg/utils"
+)
+
+// storageConfig reads the storage backend settings from the environment
+func storageConfig() services.StorageConfig {
+	return services.StorageConfig{
+		Backend: utils.GetEnv("STORAGE_BACKEND", services.StorageLocal),
+		S3: services.S3Config{
+			Bucket:          utils.GetEnv("S3_BUCKET", ""),
+			Region:          utils.GetEnv("S3_REGION", utils.GetEnv("AWS_REGION", "")),
+			Endpoint:        utils.GetEnv("S3_ENDPOINT", ""),
+			PathStyle:       utils.GetEnvBool("S3_PATH_STYLE", false),
+			Prefix:          utils.GetEnv("S3_PREFIX", ""),
+			AccessKeyID:     utils.GetEnv("AWS_ACCESS_KEY_ID", ""),
+			SecretAccessKey: utils.GetEnv("AWS_SECRET_ACCESS_KEY", ""),
+			SessionToken:    utils.GetEnv("AWS_SESSION_TOKEN", ""),
+		},
+	}
+}
+
+// objectIntakeConfig reads the bucket notification intake settings from the
+// environment
+func objectIntakeConfig() services.ObjectIntakeConfig {
+	return services.ObjectIntakeConfig{
+		QueueURL: utils.GetEnv("S3_INTAKE_QUEUE_URL", ""),
+		Prefix:   utils.GetEnv("S3_INTAKE_PREFIX", ""),
+		Tenant:   utils.GetEnv("S3_INTAKE_TENANT", services.DefaultTenantID),
+		S3:       storageConfig().S3,
+	}
+}
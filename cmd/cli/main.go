@@ -0,0 +1,163 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"github.com/mussietl/csv-sales-api/internal/services"
+	"github.com/mussietl/csv-sales-api/pkg/utils"
+	"github.com/sirupsen/logrus"
+)
+
+const usage = `Usage: csv-sales <command> [flags]
+
+Commands:
+  process -in FILE [-out FILE] [flags]       Aggregate a sales file without the API server
+
+Run "csv-sales process -h" for the flags of process.
+`
+
+func main() {
+	if len(os.Args) < 2 {
+		fmt.Fprint(os.Stderr, usage)
+		os.Exit(2)
+	}
+
+	var err error
+	switch os.Args[1] {
+	case "process":
+		err = process(os.Args[2:], os.Stdin, os.Stdout, os.Stderr)
+	case "-h", "-help", "--help", "help":
+		fmt.Fprint(os.Stdout, usage)
+		return
+	default:
+		fmt.Fprintf(os.Stderr, "unknown command %q\n\n", os.Args[1])
+		fmt.Fprint(os.Stderr, usage)
+		os.Exit(2)
+	}
+	if err != nil {
+		fmt.Fprintln(os.Stderr, "error:", err)
+		os.Exit(1)
+	}
+}
+
+// process aggregates a local file with the same services the server uses and
+// writes the result to a file or stdout
+func process(args []string, stdin io.Reader, stdout, stderr io.Writer) error {
+	flags := flag.NewFlagSet("process", flag.ExitOnError)
+	in := flags.String("in", "", `sales file to process: CSV, XLSX, gzip or ZIP; "-" reads CSV from stdin`)
+	out := flags.String("out", "-", `result file; "-" writes to stdout`)
+	groupBy := flags.String("group-by", "", "comma-separated columns to aggregate by instead of department totals")
+	agg := flags.String("agg", services.AggregationDefault, "aggregation function with -group-by: sum, avg, count, min or max")
+	format := flags.String("format", "", "result format: csv, json, ndjson or xlsx (default: from the -out extension, else csv)")
+	order := flags.String("order", services.OrderDepartment, "department order: department, total_desc or first_seen")
+	departmentColumn := flags.String("department-column", "", "department column name or index (default: detected)")
+	salesColumn := flags.String("sales-column", "", "sales column name or index (default: detected)")
+	parser := flags.String("parser", utils.GetEnv("CSV_PARSER", services.ParserStandard), "row parser: standard or fast")
+	verbose := flags.Bool("v", false, "log processing details to stderr")
+	flags.Parse(args)
+
+	if *in == "" {
+		flags.Usage()
+		return fmt.Errorf("-in is required")
+	}
+	output := *format
+	if output == "" {
+		output = strings.TrimPrefix(filepath.Ext(*out), ".")
+		if services.ValidateOutputFormat(output) != nil || output == services.OutputFormatIntegrationJSON || *out == "-" {
+			output = services.OutputFormatCSV
+		}
+	}
+	if _, err := services.NewResultWriter(output); err != nil {
+		return err
+	}
+	if err := services.ValidateOrder(*order); err != nil {
+		return err
+	}
+	if err := services.ValidateAggregation(*agg); err != nil {
+		return err
+	}
+
+	logger := logrus.New()
+	logger.SetOutput(stderr)
+	logger.SetLevel(logrus.ErrorLevel)
+	if *verbose {
+		logger.SetLevel(logrus.InfoLevel)
+	}
+	csvService := services.NewCSVService(logger)
+	if err := csvService.SetParser(*parser); err != nil {
+		return err
+	}
+
+	opts := services.ProcessOptions{
+		Order:            *order,
+		DepartmentColumn: *departmentColumn,
+		SalesColumn:      *salesColumn,
+		Aggregation:      *agg,
+	}
+	for _, column := range strings.Split(*groupBy, ",") {
+		if column = strings.TrimSpace(column); column != "" {
+			opts.GroupBy = append(opts.GroupBy, column)
+		}
+	}
+
+	var result *services.ProcessResult
+	var err error
+	if *in == "-" {
+		result, err = csvService.ProcessStream(stdin, opts)
+	} else {
+		result, err = csvService.Process(*in, opts)
+	}
+	if err != nil {
+		return err
+	}
+
+	write := func(w io.Writer) error {
+		if result.Aggregation != nil {
+			services.RoundAggregation(result.Aggregation, services.DefaultNumberFormat)
+			return services.WriteAggregationResult(w, result.Aggregation, services.DefaultNumberFormat, output)
+		}
+		return services.WriteResult(w, result.Summaries, services.DefaultNumberFormat, services.MetricDefault, output)
+	}
+	if *out == "-" {
+		err = write(stdout)
+	} else {
+		err = writeFileAtomically(*out, write)
+	}
+	if err != nil {
+		return fmt.Errorf("failed to write result: %w", err)
+	}
+
+	groups := fmt.Sprintf("%d departments", len(result.Summaries))
+	if result.Aggregation != nil {
+		groups = fmt.Sprintf("%d groups", len(result.Aggregation.Groups))
+	}
+	fmt.Fprintf(stderr, "processed %d rows (%d skipped) into %s\n", result.Rows.Total, result.Rows.Skipped, groups)
+	return nil
+}
+
+// writeFileAtomically writes a file through a temporary file in the same
+// directory, so a job reading the result never sees it half written
+func writeFileAtomically(path string, write func(io.Writer) error) error {
+	tmp, err := os.CreateTemp(filepath.Dir(path), "."+filepath.Base(path)+".*")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(tmp.Name())
+	if err := tmp.Chmod(0644); err != nil {
+		tmp.Close()
+		return err
+	}
+	err = write(tmp)
+	if closeErr := tmp.Close(); err == nil {
+		err = closeErr
+	}
+	if err != nil {
+		return err
+	}
+	return os.Rename(tmp.Name(), path)
+}
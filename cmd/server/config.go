@@ -0,0 +1,185 @@
+package main
+
+import (
+	"bufio"
+	_ "embed"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"os"
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/sirupsen/logrus"
+	"gopkg.in/yaml.v3"
+)
+
+// defaultConfig is the default value of every setting, in env file syntax
+//
+//go:embed default.env
+var defaultConfig string
+
+// secretSettings are masked when the configuration is printed
+var secretSettings = map[string]bool{
+	"ID_SECRET":             true,
+	"AWS_SECRET_ACCESS_KEY": true,
+	"AWS_SESSION_TOKEN":     true,
+	"CALLBACK_SECRET":       true,
+	"ADMIN_API_KEY":         true,
+	"API_KEYS":              true,
+}
+
+// settingFlags are the command-line flags that override a setting
+var settingFlags = []struct{ name, setting, usage string }{
+	{"uploads-dir", "UPLOADS_DIR", "directory uploaded, result and output files are written to"},
+	{"max-upload-bytes", "MAX_UPLOAD_BYTES", "largest upload request body accepted (0 = unlimited)"},
+	{"log-level", "LOG_LEVEL", "log level: debug, info, warn or error"},
+	{"storage-backend", "STORAGE_BACKEND", "where files are kept: local or s3"},
+	{"workers", "JOB_WORKERS", "number of background jobs processed at a time"},
+	{"retention-hours", "FILE_RETENTION_HOURS", "age in hours after which files are deleted (0 = never)"},
+}
+
+// registerSettingFlags defines the flags of settingFlags
+func registerSettingFlags() {
+	for _, f := range settingFlags {
+		flag.String(f.name, "", fmt.Sprintf("%s (sets %s)", f.usage, f.setting))
+	}
+}
+
+// applySettingFlags sets the settings of the flags given on the command line,
+// which take precedence over the environment
+func applySettingFlags() {
+	settings := make(map[string]string, len(settingFlags))
+	for _, f := range settingFlags {
+		settings[f.name] = f.setting
+	}
+	flag.Visit(func(f *flag.Flag) {
+		if setting, ok := settings[f.Name]; ok {
+			os.Setenv(setting, f.Value.String())
+		}
+	})
+}
+
+// loadConfigFile sets the settings of a YAML configuration file that the
+// environment leaves empty. Keys are setting names, matched
+// case-insensitively so uploads_dir works as well as UPLOADS_DIR.
+func loadConfigFile(path string) error {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return fmt.Errorf("failed to read config file: %w", err)
+	}
+	var settings map[string]any
+	if err := yaml.Unmarshal(data, &settings); err != nil {
+		return fmt.Errorf("failed to parse config file %s: %w", path, err)
+	}
+
+	known := make(map[string]bool)
+	scanner := bufio.NewScanner(strings.NewReader(defaultConfig))
+	for scanner.Scan() {
+		if key, _, ok := configSetting(scanner.Text()); ok {
+			known[key] = true
+		}
+	}
+	var problems []string
+	for name, value := range settings {
+		key := strings.ToUpper(name)
+		if !known[key] {
+			problems = append(problems, fmt.Sprintf("unknown setting %q", name))
+			continue
+		}
+		switch value.(type) {
+		case string, int, float64, bool, nil:
+		default:
+			problems = append(problems, fmt.Sprintf("%s must be a single value", name))
+			continue
+		}
+		if value != nil && os.Getenv(key) == "" {
+			os.Setenv(key, fmt.Sprint(value))
+		}
+	}
+	if len(problems) > 0 {
+		sort.Strings(problems)
+		return fmt.Errorf("invalid config file %s: %s", path, strings.Join(problems, "; "))
+	}
+	return nil
+}
+
+// minimumSettings are integer settings checked at startup, with their
+// minimum value. Other integer settings fall back to their default when
+// malformed.
+var minimumSettings = map[string]int{
+	"MAX_UPLOAD_BYTES":     0,
+	"JOB_WORKERS":          1,
+	"FILE_RETENTION_HOURS": 0,
+}
+
+// validateConfig checks the settings that can be given as flags, so a
+// mistyped value stops the server instead of being ignored
+func validateConfig() error {
+	var problems []error
+	keys := make([]string, 0, len(minimumSettings))
+	for key := range minimumSettings {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+	for _, key := range keys {
+		value := strings.TrimSpace(os.Getenv(key))
+		if value == "" {
+			continue
+		}
+		if n, err := strconv.Atoi(value); err != nil {
+			problems = append(problems, fmt.Errorf("%s: expected a whole number, got %q", key, value))
+		} else if n < minimumSettings[key] {
+			problems = append(problems, fmt.Errorf("%s: must be at least %d", key, minimumSettings[key]))
+		}
+	}
+	if _, err := logrus.ParseLevel(os.Getenv("LOG_LEVEL")); err != nil {
+		problems = append(problems, fmt.Errorf("LOG_LEVEL: %w", err))
+	}
+	return errors.Join(problems...)
+}
+
+// applyDefaultConfig sets the settings the environment leaves empty to
+// their value in the default configuration
+func applyDefaultConfig() {
+	scanner := bufio.NewScanner(strings.NewReader(defaultConfig))
+	for scanner.Scan() {
+		key, value, ok := configSetting(scanner.Text())
+		if ok && value != "" && os.Getenv(key) == "" {
+			os.Setenv(key, value)
+		}
+	}
+}
+
+// printConfig writes the effective configuration in env file syntax: the
+// default configuration with the environment's values, secrets masked
+func printConfig(w io.Writer) {
+	scanner := bufio.NewScanner(strings.NewReader(defaultConfig))
+	for scanner.Scan() {
+		line := scanner.Text()
+		key, value, ok := configSetting(line)
+		if !ok {
+			fmt.Fprintln(w, line)
+			continue
+		}
+		if env := os.Getenv(key); env != "" {
+			value = env
+		}
+		if secretSettings[key] && value != "" {
+			value = "********"
+		}
+		fmt.Fprintf(w, "%s=%s\n", key, value)
+	}
+}
+
+// configSetting splits a KEY=value line of the default configuration;
+// comments and blank lines are not settings
+func configSetting(line string) (string, string, bool) {
+	if strings.HasPrefix(line, "#") {
+		return "", "", false
+	}
+	key, value, ok := strings.Cut(line, "=")
+	return key, value, ok
+}
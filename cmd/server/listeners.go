@@ -0,0 +1,133 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"net"
+	"net/http"
+	"os"
+	"strings"
+
+	"github.com/gin-gonic/gin"
+	"github.com/sirupsen/logrus"
+)
+
+// Listener roles decide which routes and middleware a listener serves
+const (
+	// roleAll serves the public API, downloads and the admin API and dashboard
+	roleAll = "all"
+	// roleAPI serves the public API and downloads
+	roleAPI = "api"
+	// roleAdmin serves the admin API and dashboard
+	roleAdmin = "admin"
+)
+
+// unixPrefix marks a listener address as a unix socket path
+const unixPrefix = "unix:"
+
+// listenerConfig is one address the server listens on
+type listenerConfig struct {
+	Role    string
+	Network string
+	Address string
+}
+
+// String formats the listener for logs
+func (l listenerConfig) String() string {
+	if l.Network == "unix" {
+		return l.Role + "=" + unixPrefix + l.Address
+	}
+	return l.Role + "=" + l.Address
+}
+
+// parseListeners parses a comma-separated list of [role=]address entries,
+// e.g. "api=:8080,admin=127.0.0.1:9090,api=unix:/run/csv-sales.sock".
+// Addresses are host:port, with IPv6 hosts in brackets, or unix:path. An
+// entry without a role serves everything. An empty spec listens on port
+// for everything.
+func parseListeners(spec, port string) ([]listenerConfig, error) {
+	if strings.TrimSpace(spec) == "" {
+		return []listenerConfig{{Role: roleAll, Network: "tcp", Address: ":" + port}}, nil
+	}
+
+	var listeners []listenerConfig
+	for _, entry := range strings.Split(spec, ",") {
+		entry = strings.TrimSpace(entry)
+		if entry == "" {
+			continue
+		}
+		l := listenerConfig{Role: roleAll, Network: "tcp", Address: entry}
+		if role, address, ok := strings.Cut(entry, "="); ok {
+			l.Role, l.Address = strings.ToLower(strings.TrimSpace(role)), strings.TrimSpace(address)
+		}
+		switch l.Role {
+		case roleAll, roleAPI, roleAdmin:
+		default:
+			return nil, fmt.Errorf("invalid role %q in %q: expected all, api or admin", l.Role, entry)
+		}
+
+		if path, ok := strings.CutPrefix(l.Address, unixPrefix); ok {
+			if path == "" {
+				return nil, fmt.Errorf("missing socket path in %q", entry)
+			}
+			l.Network, l.Address = "unix", path
+		} else if _, _, err := net.SplitHostPort(l.Address); err != nil {
+			return nil, fmt.Errorf("invalid address in %q: %v", entry, err)
+		}
+		listeners = append(listeners, l)
+	}
+	if len(listeners) == 0 {
+		return nil, errors.New("no listeners configured")
+	}
+	return listeners, nil
+}
+
+// listen opens the listener. A stale unix socket left by a previous run is
+// replaced, and the new one is only accessible to the owner and group.
+func (l listenerConfig) listen() (net.Listener, error) {
+	if l.Network != "unix" {
+		return net.Listen(l.Network, l.Address)
+	}
+	if info, err := os.Lstat(l.Address); err == nil {
+		if info.Mode()&os.ModeSocket == 0 {
+			return nil, fmt.Errorf("%s exists and is not a socket", l.Address)
+		}
+		if err := os.Remove(l.Address); err != nil {
+			return nil, fmt.Errorf("failed to remove stale socket: %w", err)
+		}
+	}
+	ln, err := net.Listen("unix", l.Address)
+	if err != nil {
+		return nil, err
+	}
+	if err := os.Chmod(l.Address, 0660); err != nil {
+		ln.Close()
+		return nil, fmt.Errorf("failed to set socket permissions: %w", err)
+	}
+	return ln, nil
+}
+
+// serveListeners opens every listener, each with a router built for its
+// role, and serves until one of them fails
+func serveListeners(listeners []listenerConfig, newRouter func(role string) *gin.Engine, logger *logrus.Logger) error {
+	routers := make(map[string]*gin.Engine)
+	errs := make(chan error, len(listeners))
+	for _, l := range listeners {
+		ln, err := l.listen()
+		if err != nil {
+			return fmt.Errorf("failed to listen on %s: %w", l, err)
+		}
+		router, ok := routers[l.Role]
+		if !ok {
+			router = newRouter(l.Role)
+			routers[l.Role] = router
+		}
+
+		logger.Infof("Server listening on %s", l)
+		server := &http.Server{Handler: router}
+		go func(l listenerConfig) {
+			errs <- fmt.Errorf("listener %s stopped: %w", l, server.Serve(ln))
+		}(l)
+	}
+	return <-errs
+}
@@ -1,69 +1,584 @@
 package main
 
 import (
+	"flag"
+	"fmt"
 	"log"
 	"os"
+	"path/filepath"
+	"time"
 
 	"github.com/gin-gonic/gin"
 	"github.com/mussietl/csv-sales-api/internal/handlers"
+	"github.com/mussietl/csv-sales-api/internal/middleware"
 	"github.com/mussietl/csv-sales-api/internal/services"
 	"github.com/mussietl/csv-sales-api/pkg/utils"
 	"github.com/sirupsen/logrus"
 )
 
 func main() {
+	printDefaultConfig := flag.Bool("print-default-config", false, "print the effective configuration in env file syntax and exit")
+	configFile := flag.String("config", "", "YAML file of settings, used where the environment and flags leave them unset")
+	registerSettingFlags()
+	flag.Parse()
+	// Flags override the environment, which overrides the config file
+	applySettingFlags()
+	if *configFile != "" {
+		if err := loadConfigFile(*configFile); err != nil {
+			log.Fatal(err)
+		}
+	}
+	applyDefaultConfig()
+	if *printDefaultConfig {
+		printConfig(os.Stdout)
+		return
+	}
+	if err := validateConfig(); err != nil {
+		log.Fatalf("Invalid configuration:\n%v", err)
+	}
+
 	// Initialize logger
 	logger := logrus.New()
 	logger.SetFormatter(&logrus.JSONFormatter{})
-	logger.SetLevel(logrus.InfoLevel)
+	level, _ := logrus.ParseLevel(utils.GetEnv("LOG_LEVEL", "info"))
+	logger.SetLevel(level)
+	build := buildInfo()
+	logger.AddHook(versionHook{version: build.Version})
+	logger.Infof("Starting csv-sales-api %s (commit %s, built %s)", build.Version, build.Commit, build.BuildDate)
+	enforceOffline(logger)
 
 	// Create uploads directory if it doesn't exist
-	uploadsDir := "public/uploads"
+	uploadsDir := utils.GetEnv("UPLOADS_DIR", "public/uploads")
 	if err := os.MkdirAll(uploadsDir, 0755); err != nil {
 		logger.Fatalf("Failed to create uploads directory: %v", err)
 	}
 
+	// Create data directory for private state (API keys etc.)
+	dataDir := utils.GetEnv("DATA_DIR", "data")
+	if err := os.MkdirAll(dataDir, 0700); err != nil {
+		logger.Fatalf("Failed to create data directory: %v", err)
+	}
+
 	// Initialize services
 	fileService := services.NewFileService(uploadsDir, logger)
+	storage, err := services.NewStorage(storageConfig(), uploadsDir)
+	if err != nil {
+		logger.Fatalf("Invalid storage configuration: %v", err)
+	}
+	fileService.SetStorage(storage, time.Duration(utils.GetEnvInt("DOWNLOAD_URL_EXPIRY_SECONDS", int(services.DefaultSignedURLExpiry.Seconds())))*time.Second)
+	ids, err := services.NewIDGenerator(services.IDConfig{
+		Scheme: utils.GetEnv("ID_SCHEME", services.IDSchemeUUID),
+		Secret: utils.GetEnv("ID_SECRET", ""),
+	}, filepath.Join(dataDir, "id_sequence"))
+	if err != nil {
+		logger.Fatalf("Invalid ID configuration: %v", err)
+	}
+	fileService.SetIDGenerator(ids)
+	// Hold uploads in quarantine until the scanner passes them
+	var scanner services.Scanner
+	switch kind := utils.GetEnv("UPLOAD_SCANNER", services.ScannerOff); kind {
+	case services.ScannerOff:
+	case services.ScannerNoop:
+		scanner = services.NoopScanner{}
+	case services.ScannerClamAV:
+		timeout := time.Duration(utils.GetEnvInt("CLAMAV_TIMEOUT_SECONDS", 30)) * time.Second
+		scanner = services.NewClamAVScanner(utils.GetEnv("CLAMAV_ADDRESS", "127.0.0.1:3310"), timeout)
+	default:
+		logger.Fatalf("Invalid UPLOAD_SCANNER: %q", kind)
+	}
+	if scanner != nil {
+		if err := fileService.SetScanner(scanner, utils.GetEnv("QUARANTINE_DIR", filepath.Join(dataDir, "quarantine"))); err != nil {
+			logger.Fatalf("Failed to set up upload scanning: %v", err)
+		}
+	}
+	// Assemble resumable uploads in UPLOAD_SESSIONS_DIR until they are committed
+	sessionsDir := utils.GetEnv("UPLOAD_SESSIONS_DIR", filepath.Join(dataDir, "upload_sessions"))
+	if err := fileService.SetUploadSessions(sessionsDir, time.Duration(utils.GetEnvInt("UPLOAD_SESSION_TTL_HOURS", 24))*time.Hour); err != nil {
+		logger.Fatalf("Failed to set up resumable uploads: %v", err)
+	}
+	// Save uploads to the storage backend in the background rather than before processing
+	if utils.GetEnvBool("ARCHIVE_ASYNC", false) {
+		archiver := services.NewArchiver(fileService.Persist, utils.GetEnvInt("ARCHIVE_WORKERS", 2), utils.GetEnvInt("ARCHIVE_QUEUE_SIZE", 1000), logger)
+		defer archiver.Close()
+		fileService.SetArchiver(archiver)
+	}
 	csvService := services.NewCSVService(logger)
+	csvLimits := services.CSVLimits{
+		MaxColumns:           utils.GetEnvInt("MAX_CSV_COLUMNS", services.DefaultCSVLimits.MaxColumns),
+		MaxHeaderBytes:       utils.GetEnvInt("MAX_HEADER_BYTES", services.DefaultCSVLimits.MaxHeaderBytes),
+		MaxDecompressedBytes: int64(utils.GetEnvInt("MAX_DECOMPRESSED_BYTES", int(services.DefaultCSVLimits.MaxDecompressedBytes))),
+	}
+	csvService.SetLimits(csvLimits)
+	thresholds := services.DefaultStrategyThresholds()
+	csvService.SetStrategyThresholds(services.StrategyThresholds{
+		InMemoryMaxBytes: int64(utils.GetEnvInt("IN_MEMORY_MAX_BYTES", int(thresholds.InMemoryMaxBytes))),
+		ParallelMinBytes: int64(utils.GetEnvInt("PARALLEL_MIN_BYTES", int(thresholds.ParallelMinBytes))),
+		Workers:          utils.GetEnvInt("PARALLEL_WORKERS", thresholds.Workers),
+	})
+	if err := csvService.SetParser(utils.GetEnv("CSV_PARSER", services.ParserStandard)); err != nil {
+		logger.Fatalf("Invalid CSV_PARSER: %v", err)
+	}
+	// Exchange rates for base_currency come from a static table or an
+	// HTTP endpoint, never both
+	staticRates, ratesURL := utils.GetEnv("EXCHANGE_RATES", ""), utils.GetEnv("EXCHANGE_RATES_URL", "")
+	switch {
+	case staticRates != "" && ratesURL != "":
+		logger.Fatalf("EXCHANGE_RATES and EXCHANGE_RATES_URL cannot both be set")
+	case staticRates != "":
+		rates, err := services.ParseExchangeRates(staticRates)
+		if err != nil {
+			logger.Fatalf("Invalid EXCHANGE_RATES: %v", err)
+		}
+		csvService.SetExchangeRates(rates)
+	case ratesURL != "":
+		ttl := time.Duration(utils.GetEnvInt("EXCHANGE_RATES_TTL_MINUTES", 60)) * time.Minute
+		csvService.SetExchangeRates(services.NewRemoteExchangeRates(ratesURL, ttl, logger))
+	}
+	notificationService := services.NewNotificationService(logger)
+	if err := notificationService.Configure(utils.GetEnv("NOTIFY_CHANNELS", "")); err != nil {
+		logger.Fatalf("Invalid NOTIFY_CHANNELS: %v", err)
+	}
+
+	// Integrations subscribe to upload lifecycle events instead of being called by handlers
+	events := services.NewEventBus(logger)
+	events.Subscribe(notificationService.HandleEvent, services.EventProcessingCompleted)
+	if natsURL := utils.GetEnv("NATS_URL", ""); natsURL != "" {
+		natsPublisher, err := services.NewNATSPublisher(natsURL, utils.GetEnv("NATS_SUBJECT_PREFIX", "csv_sales"), logger)
+		if err != nil {
+			logger.Fatalf("Invalid NATS configuration: %v", err)
+		}
+		defer natsPublisher.Close()
+		events.Subscribe(natsPublisher.Handle)
+	}
+
+	// Record every stored upload in the history database; SQLite in DATA_DIR unless configured
+	history, err := services.NewHistoryRepository(utils.GetEnv("HISTORY_DATABASE_URL", filepath.Join(dataDir, "history.db")), logger)
+	if err != nil {
+		logger.Fatalf("Failed to open history database: %v", err)
+	}
+	defer history.Close()
+	events.Subscribe(history.Handle, services.EventUploadReceived, services.EventProcessingStarted, services.EventProcessingCompleted)
+	// Count skipped rows and failed uploads per tenant for /api/v1/admin/metrics
+	qualityMetrics := services.NewQualityMetrics()
+	events.Subscribe(qualityMetrics.Handle, services.EventProcessingCompleted)
+
+	reportService := services.NewReportService(logger)
+	var supportService *services.SupportService
+	if utils.GetEnvBool("SUPPORT_RECORDING", false) {
+		supportService = services.NewSupportService(filepath.Join(dataDir, "support"), utils.GetEnvInt("SUPPORT_SAMPLE_ROWS", 20), logger)
+	}
+	// API keys live in the history database; keys of an api_keys.json left by
+	// earlier versions are imported on first start
+	apiKeyService, err := services.NewAPIKeyService(history, filepath.Join(dataDir, "api_keys.json"), logger)
+	if err != nil {
+		logger.Fatalf("Failed to load API keys: %v", err)
+	}
+	if err := seedAPIKeys(apiKeyService); err != nil {
+		logger.Fatalf("Failed to import API keys from environment: %v", err)
+	}
+	requireAPIKey := utils.GetEnvBool("REQUIRE_API_KEY", false)
+	uploadRateLimiter := services.NewRateLimiter(utils.GetEnvFloat("UPLOAD_RATE_LIMIT", 0), utils.GetEnvInt("UPLOAD_RATE_BURST", 5))
+	tenantLimiter, err := services.NewTenantLimiter(filepath.Join(dataDir, "tenant_limits.json"), utils.GetEnvInt("TENANT_MAX_CONCURRENCY", 0), logger)
+	if err != nil {
+		logger.Fatalf("Failed to load tenant limits: %v", err)
+	}
+	tenantQuotas, err := services.NewTenantQuotas(filepath.Join(dataDir, "tenant_quotas.json"), services.TenantQuota{
+		StorageBytes: int64(utils.GetEnvInt("TENANT_STORAGE_QUOTA_BYTES", 0)),
+		MonthlyRows:  int64(utils.GetEnvInt("TENANT_MONTHLY_ROW_QUOTA", 0)),
+	}, fileService, logger)
+	if err != nil {
+		logger.Fatalf("Failed to load tenant quotas: %v", err)
+	}
+	events.Subscribe(tenantQuotas.HandleEvent, services.EventProcessingCompleted)
+	defaultCalendar, err := services.ParseFiscalCalendar(utils.GetEnv("FISCAL_CALENDAR", ""))
+	if err != nil {
+		logger.Fatalf("Invalid FISCAL_CALENDAR: %v", err)
+	}
+	fiscalCalendars, err := services.NewFiscalCalendarStore(filepath.Join(dataDir, "fiscal_calendars.json"), defaultCalendar, logger)
+	if err != nil {
+		logger.Fatalf("Failed to load fiscal calendars: %v", err)
+	}
+
+	sloObjectives := services.SLOObjectives{
+		Availability:       utils.GetEnvFloat("SLO_AVAILABILITY", services.DefaultSLOObjectives.Availability),
+		LatencyThresholdMs: int64(utils.GetEnvInt("SLO_LATENCY_THRESHOLD_MS", int(services.DefaultSLOObjectives.LatencyThresholdMs))),
+		LatencyTarget:      utils.GetEnvFloat("SLO_LATENCY_TARGET", services.DefaultSLOObjectives.LatencyTarget),
+	}
+	if err := sloObjectives.Validate(); err != nil {
+		logger.Fatalf("Invalid SLO objectives: %v", err)
+	}
+	sloTracker := services.NewSLOTracker(sloObjectives)
+	schemaDrift, err := services.NewSchemaDriftService(filepath.Join(dataDir, "schemas.json"), csvLimits, logger)
+	if err != nil {
+		logger.Fatalf("Failed to load schema fingerprints: %v", err)
+	}
+	departments, err := services.NewDepartmentStore(filepath.Join(dataDir, "departments.json"), logger)
+	if err != nil {
+		logger.Fatalf("Failed to load departments: %v", err)
+	}
+	trends, err := services.NewTrendStore(filepath.Join(dataDir, "trends.json"), logger)
+	if err != nil {
+		logger.Fatalf("Failed to load trends: %v", err)
+	}
+	intakeLog, err := services.NewIntakeLog(filepath.Join(dataDir, "intake.log"), logger)
+	if err != nil {
+		logger.Fatalf("Failed to open intake log: %v", err)
+	}
+	defer intakeLog.Close()
+	recoveryPolicy := utils.GetEnv("INTAKE_RECOVERY", services.RecoveryFail)
+	if recoveryPolicy != services.RecoveryFail && recoveryPolicy != services.RecoveryRetry {
+		logger.Fatalf("Invalid INTAKE_RECOVERY %q: expected fail or retry", recoveryPolicy)
+	}
+	// Jobs left by the previous run are run again once the handlers exist;
+	// intake recovery leaves their uploads alone
+	jobStore, err := services.NewJobStore(filepath.Join(dataDir, "jobs.json"), logger)
+	if err != nil {
+		logger.Fatalf("Failed to load jobs: %v", err)
+	}
+	jobQueue := services.NewJobQueue(jobStore, utils.GetEnvInt("JOB_WORKERS", 2), utils.GetEnvInt("JOB_QUEUE_SIZE", 100), logger)
+	defer jobQueue.Close()
+	jobQueue.SetLease(time.Duration(utils.GetEnvInt("JOB_LEASE_SECONDS", int(services.DefaultJobLease.Seconds())))*time.Second, utils.GetEnvInt("JOB_MAX_ATTEMPTS", services.DefaultJobMaxAttempts))
+	jobQueue.SetTenantLimiter(tenantLimiter)
+	resumed := make(map[string]bool)
+	for _, job := range jobQueue.Resumable() {
+		resumed[job.UploadID] = true
+	}
+	annotationService := services.NewAnnotationService(filepath.Join(dataDir, "annotations"), logger)
+	pins, err := services.NewPinStore(filepath.Join(dataDir, "pins.json"), logger)
+	if err != nil {
+		logger.Fatalf("Failed to load pinned results: %v", err)
+	}
 
 	// Initialize handlers
-	uploadHandler := handlers.NewUploadHandler(fileService, csvService, logger)
+	uploadHandler := handlers.NewUploadHandler(fileService, csvService, events, reportService, supportService, schemaDrift, departments, trends, intakeLog, jobQueue, logger)
+	uploadHandler.SetFileFields(utils.GetEnvList("UPLOAD_FILE_FIELDS"))
+	uploadHandler.SetAsyncDefault(utils.GetEnvBool("ASYNC_UPLOADS", false))
+	// Post outcomes to a request's callback_url, signed with CALLBACK_SECRET; disabled without one
+	callbacks := services.NewCallbackService(utils.GetEnv("CALLBACK_SECRET", ""), filepath.Join(dataDir, "callbacks_dead_letter.jsonl"), logger)
+	callbacks.SetAttempts(utils.GetEnvInt("CALLBACK_MAX_ATTEMPTS", services.DefaultCallbackAttempts))
+	callbacks.SetAllowedHosts(utils.GetEnvList("CALLBACK_ALLOWED_HOSTS"))
+	callbacks.SetAllowPrivateNetworks(utils.GetEnvBool("CALLBACK_ALLOW_PRIVATE_NETWORKS", false))
+	defer callbacks.Close()
+	uploadHandler.SetCallbackService(callbacks)
+	uploadHandler.SetTenantQuotas(tenantQuotas)
+	uploadHandler.SetTenantLimiter(tenantLimiter)
+	uploadHandler.SetFiscalCalendars(fiscalCalendars)
+	piiMode := utils.GetEnv("PII_MODE", services.PIIModeOff)
+	if err := services.ValidatePIIMode(piiMode); err != nil {
+		logger.Fatalf("Invalid PII_MODE: %v", err)
+	}
+	uploadHandler.SetPIIMode(piiMode)
+	// Let uploads name a schema profile from SCHEMA_DIR to be validated against
+	var schemaProfiles *services.SchemaRegistry
+	if schemaDir := utils.GetEnv("SCHEMA_DIR", ""); schemaDir != "" {
+		if schemaProfiles, err = services.LoadSchemaProfiles(schemaDir); err != nil {
+			logger.Fatalf("Failed to load schema profiles: %v", err)
+		}
+		logger.Infof("Loaded %d schema profiles from %s", len(schemaProfiles.List()), schemaDir)
+		uploadHandler.SetSchemaProfiles(schemaProfiles)
+	}
+	// Combine micro_batch uploads per tenant and feed over MICRO_BATCH_WINDOW_MINUTES; 0 disables
+	var microBatcher *services.MicroBatcher
+	if window := utils.GetEnvInt("MICRO_BATCH_WINDOW_MINUTES", 0); window > 0 {
+		microBatcher, err = services.NewMicroBatcher(filepath.Join(dataDir, "micro_batches.json"), time.Duration(window)*time.Minute, fileService, events, logger)
+		if err != nil {
+			logger.Fatalf("Failed to load micro-batch windows: %v", err)
+		}
+		microBatcher.Start()
+		uploadHandler.SetMicroBatcher(microBatcher)
+	}
+	uploadHandler.SetMaxInlineResults(utils.GetEnvInt("MAX_INLINE_RESULTS", handlers.DefaultMaxInlineResults))
+	uploadHandler.SetBatchMaxFiles(utils.GetEnvInt("BATCH_MAX_FILES", handlers.DefaultBatchMaxFiles))
+	uploadHandler.SetJSONUploadMaxBytes(int64(utils.GetEnvInt("JSON_UPLOAD_MAX_BYTES", handlers.DefaultJSONUploadMaxBytes)))
+	// Turn away synchronous processing above MEMORY_SHED_THRESHOLD_MB of memory in use
+	loadShedder := services.NewLoadShedder(uint64(utils.GetEnvInt("MEMORY_SHED_THRESHOLD_MB", 0)) << 20)
+	if loadShedder.Enabled() {
+		logger.Infof("Load shedding above %d MB of memory in use", loadShedder.Threshold()>>20)
+	}
+	uploadHandler.SetLoadShedder(loadShedder)
+	uploadHandler.SetUploadDeadline(time.Duration(utils.GetEnvInt("UPLOAD_DEADLINE_SECONDS", 0)) * time.Second)
+	uploadHandler.SetMaxUploadBytes(int64(utils.GetEnvInt("MAX_UPLOAD_BYTES", 0)))
+	apiKeyHandler := handlers.NewAPIKeyHandler(apiKeyService, logger)
+	downloadHandler := handlers.NewDownloadHandler(uploadsDir, logger)
+	tenantHandler := handlers.NewTenantHandler(tenantLimiter, fiscalCalendars, tenantQuotas, logger)
+	aggregateHandler := handlers.NewAggregateHandler(fileService, csvService, departments, history, logger)
+	sloHandler := handlers.NewSLOHandler(sloTracker)
+	metricsHandler := handlers.NewMetricsHandler(qualityMetrics, logger)
+	departmentHandler := handlers.NewDepartmentHandler(departments, logger)
+	intakeHandler := handlers.NewIntakeHandler(intakeLog, uploadHandler.RetryUpload, logger)
+	historyHandler := handlers.NewHistoryHandler(history, fileService, logger)
+	resultHandler := handlers.NewResultHandler(fileService, annotationService, pins, intakeLog, events, logger)
+	jobHandler := handlers.NewJobHandler(jobStore, jobQueue, logger)
 
-	// Setup router
-	router := gin.Default()
+	// Interrupted uploads are retried by the upload handler, which rebuilds
+	// the options they were sent with
+	if n := intakeLog.Recover(recoveryPolicy, uploadHandler.RetryUpload, resumed); n > 0 {
+		logger.Warnf("Recovered %d uploads interrupted by the previous shutdown", n)
+	}
+	if err := intakeLog.Compact(); err != nil {
+		logger.Fatalf("Failed to compact intake log: %v", err)
+	}
+	jobQueue.Recover(uploadHandler.RedeliverJob)
 
-	// Add CORS middleware
-	router.Use(func(c *gin.Context) {
-		c.Header("Access-Control-Allow-Origin", "*")
-		c.Header("Access-Control-Allow-Methods", "GET, POST, OPTIONS")
-		c.Header("Access-Control-Allow-Headers", "Content-Type")
+	// Delete uploaded and result files after FILE_RETENTION_HOURS; 0 keeps them forever
+	if retention := utils.GetEnvInt("FILE_RETENTION_HOURS", 0); retention > 0 {
+		janitor := services.NewJanitor(fileService, intakeLog, annotationService, pins, events, time.Duration(retention)*time.Hour, logger)
+		janitor.Start(time.Duration(utils.GetEnvInt("JANITOR_INTERVAL_MINUTES", int(services.DefaultJanitorInterval.Minutes()))) * time.Minute)
+		defer janitor.Close()
+	}
 
-		if c.Request.Method == "OPTIONS" {
-			c.AbortWithStatus(204)
-			return
+	// Process files dropped into S3 buckets, read from the bucket
+	// notifications sent to S3_INTAKE_QUEUE_URL
+	if config := objectIntakeConfig(); config.QueueURL != "" {
+		objectIntake, err := services.NewObjectIntake(config, uploadHandler.ProcessObject, logger)
+		if err != nil {
+			logger.Fatalf("Failed to configure S3 intake: %v", err)
 		}
+		objectIntake.Start()
+		defer objectIntake.Close()
+	}
 
-		c.Next()
-	})
+	dashboardHandler := handlers.NewDashboardHandler(fileService, intakeLog, tenantLimiter, events, logger)
+	schemaHandler := handlers.NewSchemaHandler()
+	schemaProfileHandler := handlers.NewSchemaProfileHandler(schemaProfiles)
+	microBatchHandler := handlers.NewMicroBatchHandler(microBatcher)
+	bulkHandler := handlers.NewBulkHandler(services.NewBulkOperations(fileService, intakeLog, events, uploadHandler.RetryUpload, logger), logger)
+
+	// Inject failures for client integration testing, only ever in dev mode
+	chaos := middleware.ChaosConfig{
+		DelayRate:        utils.GetEnvFloat("CHAOS_DELAY_RATE", 0),
+		MaxDelay:         time.Duration(utils.GetEnvInt("CHAOS_MAX_DELAY_MS", 5000)) * time.Millisecond,
+		StorageErrorRate: utils.GetEnvFloat("CHAOS_STORAGE_ERROR_RATE", 0),
+		PartialReadRate:  utils.GetEnvFloat("CHAOS_PARTIAL_READ_RATE", 0),
+		Seed:             int64(utils.GetEnvInt("CHAOS_SEED", 0)),
+		SkipPaths:        []string{"/api/v1/health"},
+	}
+	if chaos.Enabled() {
+		if !utils.GetEnvBool("DEV_MODE", false) {
+			logger.Fatalf("Failure injection (CHAOS_*) requires DEV_MODE")
+		}
+		if err := chaos.Validate(); err != nil {
+			logger.Fatalf("Invalid failure injection settings: %v", err)
+		}
+		logger.Warnf("Failure injection is enabled: %+v", chaos)
+	}
+	devMode := utils.GetEnvBool("DEV_MODE", false)
+	if devMode {
+		logger.Warn("Dev mode is enabled; development endpoints are served under /api/v1/dev")
+	}
 
-	// Routes
-	api := router.Group("/api/v1")
-	{
-		api.POST("/upload", uploadHandler.UploadCSV)
+	// Each listener role gets its own router: api listeners serve the public
+	// API and downloads with CORS and failure injection, admin listeners serve
+	// the admin API and dashboard without them
+	newRouter := func(role string) *gin.Engine {
+		public := role != roleAdmin
+		private := role != roleAPI
+
+		router := gin.New()
+		router.Use(gin.Recovery())
+		// Every request gets an ID its log messages carry, and one
+		// structured access log line once it has been served
+		router.Use(middleware.RequestID(logger))
+		router.Use(middleware.AccessLog(logger))
+		// Tenant IDs name storage directories, so malformed ones are refused early
+		router.Use(middleware.ValidTenant())
+		// Client IPs identify anonymous clients for rate limiting, so forwarding
+		// headers are only believed from configured proxies
+		if err := router.SetTrustedProxies(utils.GetEnvList("TRUSTED_PROXIES")); err != nil {
+			logger.Fatalf("Invalid TRUSTED_PROXIES: %v", err)
+		}
+
+		// Add security headers middleware
+		router.Use(middleware.SecurityHeaders())
+		router.Use(middleware.VersionHeader(build.Version))
+
+		if public {
+			// Add CORS middleware
+			router.Use(func(c *gin.Context) {
+				c.Header("Access-Control-Allow-Origin", "*")
+				c.Header("Access-Control-Allow-Methods", "GET, HEAD, POST, PUT, PATCH, DELETE, OPTIONS")
+				c.Header("Access-Control-Allow-Headers", "Content-Type, Authorization, X-API-Key, X-Tenant-ID, X-Request-ID, Range, If-Range, If-None-Match, Upload-Offset")
+				c.Header("Access-Control-Expose-Headers", "Retry-After, X-Request-ID, X-Support-Bundle-ID, Location, X-Sample-Seed, X-Chaos-Injected, X-Server-Version, Accept-Ranges, Content-Range, ETag, Upload-Offset")
+
+				if c.Request.Method == "OPTIONS" {
+					c.AbortWithStatus(204)
+					return
+				}
+
+				c.Next()
+			})
+
+			if chaos.Enabled() {
+				router.Use(middleware.Chaos(chaos, logger))
+			}
+		}
+
+		// Routes
+		api := router.Group("/api/v1")
 		api.GET("/health", func(c *gin.Context) {
+			c.Header("Cache-Control", "no-store")
 			c.JSON(200, gin.H{"status": "ok"})
 		})
-	}
+		api.GET("/version", func(c *gin.Context) {
+			c.Header("Cache-Control", "public, max-age=60")
+			c.JSON(200, build)
+		})
+		api.GET("/schemas/:format", schemaHandler.GetSchema)
+		if public {
+			api.POST("/upload",
+				middleware.APIKeyAuth(apiKeyService, services.ScopeUpload, requireAPIKey, logger),
+				middleware.RateLimit(uploadRateLimiter, logger),
+				middleware.TenantQuota(tenantQuotas, logger),
+				middleware.SLOTracking(sloTracker),
+				uploadHandler.UploadCSV,
+			)
+			api.POST("/upload-json",
+				middleware.APIKeyAuth(apiKeyService, services.ScopeUpload, requireAPIKey, logger),
+				middleware.RateLimit(uploadRateLimiter, logger),
+				middleware.TenantQuota(tenantQuotas, logger),
+				middleware.SLOTracking(sloTracker),
+				uploadHandler.UploadJSON,
+			)
+			// Resumable uploads: create a session, send chunks, then commit it
+			// to be processed like an upload to /upload
+			sessionAuth := middleware.APIKeyAuth(apiKeyService, services.ScopeUpload, requireAPIKey, logger)
+			api.POST("/uploads", sessionAuth, middleware.RateLimit(uploadRateLimiter, logger), uploadHandler.CreateUploadSession)
+			api.GET("/uploads/:id", sessionAuth, uploadHandler.GetUploadSession)
+			api.HEAD("/uploads/:id", sessionAuth, uploadHandler.GetUploadSession)
+			api.PATCH("/uploads/:id", sessionAuth, uploadHandler.UploadChunk)
+			api.DELETE("/uploads/:id", sessionAuth, uploadHandler.DeleteUploadSession)
+			api.POST("/uploads/:id/commit",
+				sessionAuth,
+				middleware.SLOTracking(sloTracker),
+				uploadHandler.CommitUploadSession,
+			)
+			api.POST("/preview",
+				middleware.APIKeyAuth(apiKeyService, services.ScopeUpload, requireAPIKey, logger),
+				middleware.RateLimit(uploadRateLimiter, logger),
+				uploadHandler.PreviewCSV,
+			)
+			api.POST("/upload/batch",
+				middleware.APIKeyAuth(apiKeyService, services.ScopeUpload, requireAPIKey, logger),
+				middleware.RateLimit(uploadRateLimiter, logger),
+				middleware.LoadShedding(loadShedder, logger),
+				middleware.TenantConcurrency(tenantLimiter, logger),
+				middleware.TenantQuota(tenantQuotas, logger),
+				middleware.SLOTracking(sloTracker),
+				uploadHandler.UploadBatch,
+			)
+			api.POST("/aggregate",
+				middleware.APIKeyAuth(apiKeyService, services.ScopeUpload, requireAPIKey, logger),
+				middleware.LoadShedding(loadShedder, logger),
+				middleware.TenantConcurrency(tenantLimiter, logger),
+				middleware.SLOTracking(sloTracker),
+				aggregateHandler.Aggregate,
+			)
+			api.GET("/jobs/:id", middleware.APIKeyAuth(apiKeyService, services.ScopeRead, requireAPIKey, logger), jobHandler.GetJob)
+			api.GET("/jobs/:id/progress", middleware.APIKeyAuth(apiKeyService, services.ScopeRead, requireAPIKey, logger), jobHandler.StreamProgress)
+			api.GET("/quota", middleware.APIKeyAuth(apiKeyService, services.ScopeRead, requireAPIKey, logger), tenantHandler.GetOwnQuota)
+			api.GET("/history", middleware.APIKeyAuth(apiKeyService, services.ScopeRead, requireAPIKey, logger), historyHandler.ListHistory)
+			api.GET("/history/:id", middleware.APIKeyAuth(apiKeyService, services.ScopeRead, requireAPIKey, logger), historyHandler.GetHistory)
+			api.GET("/schema-profiles", middleware.APIKeyAuth(apiKeyService, services.ScopeRead, requireAPIKey, logger), schemaProfileHandler.ListProfiles)
+			api.GET("/schema-profiles/:name", middleware.APIKeyAuth(apiKeyService, services.ScopeRead, requireAPIKey, logger), schemaProfileHandler.GetProfile)
+			api.GET("/micro-batches", middleware.APIKeyAuth(apiKeyService, services.ScopeRead, requireAPIKey, logger), microBatchHandler.ListWindows)
+			api.GET("/micro-batches/:id", middleware.APIKeyAuth(apiKeyService, services.ScopeRead, requireAPIKey, logger), microBatchHandler.GetWindow)
+			results := api.Group("/results")
+			{
+				results.GET("/search", middleware.APIKeyAuth(apiKeyService, services.ScopeRead, requireAPIKey, logger), historyHandler.SearchResults)
+				results.GET("/:id", middleware.APIKeyAuth(apiKeyService, services.ScopeRead, requireAPIKey, logger), resultHandler.GetResult)
+				results.GET("/:id/download", middleware.APIKeyAuth(apiKeyService, services.ScopeRead, requireAPIKey, logger), resultHandler.DownloadResult)
+				results.HEAD("/:id/download", middleware.APIKeyAuth(apiKeyService, services.ScopeRead, requireAPIKey, logger), resultHandler.DownloadResult)
+				results.GET("/:id/annotations", middleware.APIKeyAuth(apiKeyService, services.ScopeRead, requireAPIKey, logger), resultHandler.ListAnnotations)
+				results.POST("/:id/annotations", middleware.APIKeyAuth(apiKeyService, services.ScopeUpload, requireAPIKey, logger), resultHandler.AddAnnotation)
+				results.DELETE("/:id", middleware.APIKeyAuth(apiKeyService, services.ScopeUpload, requireAPIKey, logger), resultHandler.DeleteResult)
+				results.POST("/:id/pin", middleware.APIKeyAuth(apiKeyService, services.ScopeUpload, requireAPIKey, logger), resultHandler.PinResult)
+				results.DELETE("/:id/pin", middleware.APIKeyAuth(apiKeyService, services.ScopeUpload, requireAPIKey, logger), resultHandler.UnpinResult)
+			}
+			if devMode {
+				devHandler := handlers.NewDevHandler(logger)
+				api.GET("/dev/sample", devHandler.Sample)
+			}
+
+			// Serve other output files as downloads; no uploads, directory listing or other file types
+			api.GET("/files/:filename", middleware.APIKeyAuth(apiKeyService, services.ScopeRead, requireAPIKey, logger), downloadHandler.DownloadFile)
+			api.HEAD("/files/:filename", middleware.APIKeyAuth(apiKeyService, services.ScopeRead, requireAPIKey, logger), downloadHandler.DownloadFile)
+		}
 
-	// Serve static files
-	router.Static("/public", "./public")
+		if private {
+			admin := api.Group("/admin", middleware.APIKeyAuth(apiKeyService, services.ScopeAdmin, true, logger))
+			{
+				admin.GET("/api-keys", apiKeyHandler.ListKeys)
+				admin.POST("/api-keys", apiKeyHandler.CreateKey)
+				admin.POST("/api-keys/:id/rotate", apiKeyHandler.RotateKey)
+				admin.POST("/api-keys/:id/disable", apiKeyHandler.DisableKey)
+				admin.POST("/api-keys/:id/enable", apiKeyHandler.EnableKey)
+				if supportService != nil {
+					supportHandler := handlers.NewSupportHandler(supportService, logger)
+					admin.GET("/support-bundles", supportHandler.ListBundles)
+					admin.GET("/support-bundles/:id", supportHandler.GetBundle)
+				}
+				admin.GET("/slo", sloHandler.GetSLO)
+				admin.GET("/jobs/workers", jobHandler.GetWorkers)
+				admin.PUT("/jobs/workers", jobHandler.SetWorkers)
+				admin.GET("/intake", intakeHandler.ListIntake)
+				admin.POST("/intake/:id/requeue", intakeHandler.RequeueUpload)
+				admin.GET("/dashboard", dashboardHandler.Summary)
+				admin.GET("/metrics", metricsHandler.GetMetrics)
+				admin.DELETE("/uploads/:id", dashboardHandler.DeleteUpload)
+				admin.GET("/pins", resultHandler.ListPins)
+				admin.DELETE("/pins/:id", resultHandler.UnpinResult)
+				admin.GET("/tenants/concurrency", tenantHandler.ListConcurrency)
+				admin.GET("/tenants/:tenant/concurrency", tenantHandler.GetConcurrency)
+				admin.PUT("/tenants/:tenant/concurrency", tenantHandler.SetConcurrency)
+				admin.DELETE("/tenants/:tenant/concurrency", tenantHandler.ResetConcurrency)
+				admin.GET("/tenants/:tenant/quota", tenantHandler.GetQuota)
+				admin.PUT("/tenants/:tenant/quota", tenantHandler.SetQuota)
+				admin.DELETE("/tenants/:tenant/quota", tenantHandler.ResetQuota)
+				admin.GET("/tenants/:tenant/fiscal-calendar", tenantHandler.GetFiscalCalendar)
+				admin.PUT("/tenants/:tenant/fiscal-calendar", tenantHandler.SetFiscalCalendar)
+				admin.DELETE("/tenants/:tenant/fiscal-calendar", tenantHandler.ResetFiscalCalendar)
+				admin.GET("/tenants/:tenant/departments", departmentHandler.ListDepartments)
+				admin.GET("/tenants/:tenant/departments/:name", departmentHandler.GetDepartment)
+				admin.PUT("/tenants/:tenant/departments/:name", departmentHandler.UpdateDepartment)
+				admin.DELETE("/tenants/:tenant/departments/:name", departmentHandler.DeleteDepartment)
+			}
+			// Bulk operations act on uploads of every tenant, so they are admin-only
+			uploads := api.Group("/uploads", middleware.APIKeyAuth(apiKeyService, services.ScopeAdmin, true, logger))
+			{
+				uploads.POST("/bulk-delete", bulkHandler.BulkDelete)
+				uploads.POST("/bulk-reprocess", bulkHandler.BulkReprocess)
+			}
 
-	// Get port from environment or use default
-	port := utils.GetEnv("PORT", "8080")
+			// The admin dashboard page is static; its data comes from the admin API
+			router.GET("/admin", dashboardHandler.Page)
+			router.GET("/admin/:asset", dashboardHandler.Page)
+		}
+		return router
+	}
 
-	logger.Infof("Server starting on port %s", port)
-	if err := router.Run(":" + port); err != nil {
+	// Listen on LISTENERS, or on PORT for everything
+	listeners, err := parseListeners(utils.GetEnv("LISTENERS", ""), utils.GetEnv("PORT", "8080"))
+	if err != nil {
+		logger.Fatalf("Invalid LISTENERS: %v", err)
+	}
+	if err := serveListeners(listeners, newRouter, logger); err != nil {
 		log.Fatalf("Failed to start server: %v", err)
 	}
 }
+
+// seedAPIKeys imports bootstrap keys from the environment. ADMIN_API_KEY gets
+// the admin scope; each key in API_KEYS gets upload and read scopes.
+func seedAPIKeys(apiKeyService *services.APIKeyService) error {
+	if adminKey := utils.GetEnv("ADMIN_API_KEY", ""); adminKey != "" {
+		if err := apiKeyService.Import("env-admin", adminKey, []string{services.ScopeAdmin}); err != nil {
+			return err
+		}
+	}
+	for i, key := range utils.GetEnvList("API_KEYS") {
+		name := fmt.Sprintf("env-%d", i+1)
+		if err := apiKeyService.Import(name, key, []string{services.ScopeUpload, services.ScopeRead}); err != nil {
+			return err
+		}
+	}
+	return nil
+}
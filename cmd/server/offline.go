@@ -0,0 +1,48 @@
+package main
+
+import (
+	"net/http"
+	"strings"
+
+	"github.com/mussietl/csv-sales-api/internal/services"
+	"github.com/mussietl/csv-sales-api/pkg/utils"
+	"github.com/sirupsen/logrus"
+)
+
+// networkFeatures lists the configured features that make outbound
+// connections, each by the setting that enables it
+func networkFeatures() []string {
+	var features []string
+	if utils.GetEnv("NOTIFY_CHANNELS", "") != "" {
+		features = append(features, "NOTIFY_CHANNELS")
+	}
+	if utils.GetEnv("NATS_URL", "") != "" {
+		features = append(features, "NATS_URL")
+	}
+	if utils.GetEnv("CALLBACK_SECRET", "") != "" {
+		features = append(features, "CALLBACK_SECRET")
+	}
+	if utils.GetEnv("EXCHANGE_RATES_URL", "") != "" {
+		features = append(features, "EXCHANGE_RATES_URL")
+	}
+	if backend := storageConfig().Backend; backend != services.StorageLocal {
+		features = append(features, "STORAGE_BACKEND="+backend)
+	}
+	if utils.GetEnv("S3_INTAKE_QUEUE_URL", "") != "" {
+		features = append(features, "S3_INTAKE_QUEUE_URL")
+	}
+	return features
+}
+
+// enforceOffline refuses to start with OFFLINE=true while any network
+// feature is configured, and makes every outbound HTTP request fail
+func enforceOffline(logger *logrus.Logger) {
+	if !utils.GetEnvBool("OFFLINE", false) {
+		return
+	}
+	if features := networkFeatures(); len(features) > 0 {
+		logger.Fatalf("OFFLINE=true but network features are configured: %s", strings.Join(features, ", "))
+	}
+	http.DefaultTransport = services.OfflineTransport{}
+	logger.Info("Running offline: outbound network access is disabled")
+}
@@ -0,0 +1,70 @@
+package main
+
+import (
+	"runtime"
+	"runtime/debug"
+	"strings"
+
+	"github.com/mussietl/csv-sales-api/internal/models"
+	"github.com/sirupsen/logrus"
+)
+
+// Build information, injected at build time with
+//
+//	go build -ldflags "-X main.version=1.4.0 -X main.commit=$(git rev-parse --short HEAD) \
+//	  -X main.buildDate=$(date -u +%Y-%m-%dT%H:%M:%SZ) -X main.features=s3,nats" ./cmd/server
+//
+// features is a comma-separated list of feature flags
+var (
+	version   = "0.0.0-dev"
+	commit    = ""
+	buildDate = ""
+	features  = ""
+)
+
+// buildInfo describes this build. The commit falls back to the VCS revision
+// Go embeds when it wasn't injected.
+func buildInfo() models.BuildInfo {
+	info := models.BuildInfo{
+		Version:   version,
+		Commit:    commit,
+		BuildDate: buildDate,
+		GoVersion: runtime.Version(),
+		Features:  []string{},
+	}
+	if bi, ok := debug.ReadBuildInfo(); ok && info.Commit == "" {
+		for _, setting := range bi.Settings {
+			if setting.Key == "vcs.revision" {
+				info.Commit = setting.Value
+			}
+		}
+	}
+	if info.Commit == "" {
+		info.Commit = "unknown"
+	}
+	if info.BuildDate == "" {
+		info.BuildDate = "unknown"
+	}
+	for _, feature := range strings.Split(features, ",") {
+		if feature = strings.TrimSpace(feature); feature != "" {
+			info.Features = append(info.Features, feature)
+		}
+	}
+	return info
+}
+
+// versionHook adds the server version to every log entry
+type versionHook struct {
+	version string
+}
+
+func (h versionHook) Levels() []logrus.Level {
+	return logrus.AllLevels
+}
+
+func (h versionHook) Fire(entry *logrus.Entry) error {
+	if _, ok := entry.Data["version"]; !ok {
+		entry.Data["version"] = h.version
+	}
+	return nil
+}
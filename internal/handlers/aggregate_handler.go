@@ -0,0 +1,264 @@
+package handlers
+
+import (
+	"errors"
+	"fmt"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/mussietl/csv-sales-api/internal/middleware"
+	"github.com/mussietl/csv-sales-api/internal/models"
+	"github.com/mussietl/csv-sales-api/internal/services"
+	"github.com/sirupsen/logrus"
+)
+
+// maxAggregateUploads bounds how many stored uploads one request may combine
+const maxAggregateUploads = 1000
+
+// AggregateHandler handles aggregation across stored uploads
+type AggregateHandler struct {
+	fileService *services.FileService
+	csvService  *services.CSVService
+	departments *services.DepartmentStore
+	history     *services.HistoryRepository
+	logger      *logrus.Logger
+}
+
+// NewAggregateHandler creates a new AggregateHandler instance
+func NewAggregateHandler(fileService *services.FileService, csvService *services.CSVService, departments *services.DepartmentStore, history *services.HistoryRepository, logger *logrus.Logger) *AggregateHandler {
+	return &AggregateHandler{
+		fileService: fileService,
+		csvService:  csvService,
+		departments: departments,
+		history:     history,
+		logger:      logger,
+	}
+}
+
+// aggregateRequest selects stored uploads either by ID or by upload time
+// range and tags
+type aggregateRequest struct {
+	UploadIDs []string `json:"upload_ids"`
+	From      string   `json:"from"`
+	To        string   `json:"to"`
+	// Tags must all be on a selected upload
+	Tags []string `json:"tags"`
+	// JoinDepartments adds department table attributes to the result
+	JoinDepartments bool `json:"join_departments"`
+	// Precision and Rounding set how totals are written to the result file
+	Precision *int   `json:"precision"`
+	Rounding  string `json:"rounding"`
+	// Order sets the order of departments in the result; default by name
+	Order string `json:"order"`
+	// Metric selects sales row counts, summed amounts or both
+	Metric string `json:"metric"`
+	// OutputFormat is the result file's format: csv, json, ndjson or xlsx
+	OutputFormat string `json:"output_format"`
+}
+
+// Aggregate merges the rows of several stored uploads into one aggregation
+func (h *AggregateHandler) Aggregate(c *gin.Context) {
+	var req aggregateRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		h.respondError(c, http.StatusBadRequest, "Invalid request body: "+err.Error())
+		return
+	}
+
+	precision := ""
+	if req.Precision != nil {
+		precision = strconv.Itoa(*req.Precision)
+	}
+	format, err := services.ParseNumberFormat(precision, req.Rounding)
+	if err != nil {
+		h.respondError(c, http.StatusBadRequest, err.Error())
+		return
+	}
+
+	if err := services.ValidateOrder(req.Order); err != nil {
+		h.respondError(c, http.StatusBadRequest, err.Error())
+		return
+	}
+	req.Metric = strings.ToLower(req.Metric)
+	if err := services.ValidateMetric(req.Metric); err != nil {
+		h.respondError(c, http.StatusBadRequest, err.Error())
+		return
+	}
+	req.OutputFormat = strings.ToLower(req.OutputFormat)
+	if _, err := services.NewResultWriter(req.OutputFormat); err != nil {
+		h.respondError(c, http.StatusBadRequest, err.Error())
+		return
+	}
+
+	files := h.fileService.ForTenant(middleware.TenantID(c))
+	uploads, err := h.selectUploads(files, middleware.TenantID(c), req)
+	if err != nil {
+		code := http.StatusBadRequest
+		if errors.Is(err, services.ErrUploadNotFound) {
+			code = http.StatusNotFound
+		} else if errors.Is(err, errHistoryUnavailable) {
+			code = http.StatusInternalServerError
+		}
+		h.respondError(c, code, err.Error())
+		return
+	}
+	if len(uploads) == 0 {
+		h.respondError(c, http.StatusNotFound, "No uploads match the request")
+		return
+	}
+	if len(uploads) > maxAggregateUploads {
+		h.respondError(c, http.StatusBadRequest, fmt.Sprintf("Too many uploads selected: %d (maximum %d)", len(uploads), maxAggregateUploads))
+		return
+	}
+
+	// Process every upload independently, then merge
+	results := make([]*services.ProcessResult, 0, len(uploads))
+	ids := make([]string, 0, len(uploads))
+	for _, upload := range uploads {
+		result, err := h.csvService.Process(upload.Path, services.ProcessOptions{Order: services.OrderFirstSeen})
+		if err != nil {
+			h.logger.Errorf("Failed to process upload %s: %v", upload.ID, err)
+			h.respondError(c, http.StatusUnprocessableEntity, fmt.Sprintf("Failed to process upload %s: %v", upload.ID, err))
+			return
+		}
+		results = append(results, result)
+		ids = append(ids, upload.ID)
+	}
+	merged := services.MergeResults(results)
+	services.SortSummaries(merged.Summaries, req.Order)
+	if req.JoinDepartments {
+		h.departments.Join(middleware.TenantID(c), merged.Summaries)
+	}
+
+	resultFilePath, err := files.SaveFormattedResultFile(merged.Summaries, format, req.Metric, nil, req.OutputFormat)
+	if err != nil {
+		h.logger.Errorf("Failed to save result file: %v", err)
+		h.respondError(c, http.StatusInternalServerError, "Failed to save result file")
+		return
+	}
+
+	var totalSales models.Amount
+	for _, summary := range merged.Summaries {
+		totalSales = totalSales.Add(summary.TotalSales)
+	}
+
+	h.logger.Infof("Aggregated %d uploads into %s", len(ids), resultFilePath)
+	response := models.AggregateResponse{
+		Success:          true,
+		Message:          fmt.Sprintf("Aggregated %d uploads", len(ids)),
+		UploadIDs:        ids,
+		ResultID:         h.fileService.ResultID(resultFilePath),
+		DownloadURL:      h.fileService.GetDownloadURL(resultFilePath),
+		TotalDepartments: len(merged.Summaries),
+		TotalSales:       totalSales,
+		Rows:             merged.Rows,
+		ProcessedAt:      time.Now().Format(time.RFC3339),
+	}
+	if services.MetricIncludesCount(req.Metric) {
+		response.SalesCount = salesCount(merged.Summaries)
+	}
+	c.JSON(http.StatusOK, response)
+}
+
+// errHistoryUnavailable is returned when tagged uploads can't be looked up
+var errHistoryUnavailable = errors.New("failed to search upload history")
+
+// selectUploads resolves the request to the tenant's stored uploads
+func (h *AggregateHandler) selectUploads(files *services.FileService, tenant string, req aggregateRequest) ([]services.StoredUpload, error) {
+	if len(req.UploadIDs) > 0 {
+		if req.From != "" || req.To != "" || len(req.Tags) > 0 {
+			return nil, fmt.Errorf("specify either upload_ids or a from/to range and tags, not both")
+		}
+		seen := make(map[string]bool)
+		var uploads []services.StoredUpload
+		for _, id := range req.UploadIDs {
+			id = strings.TrimSpace(id)
+			if seen[id] {
+				continue
+			}
+			seen[id] = true
+			path, err := files.UploadPath(id)
+			if err != nil {
+				return nil, err
+			}
+			uploads = append(uploads, services.StoredUpload{ID: id, Path: path})
+		}
+		return uploads, nil
+	}
+
+	tags, err := services.ParseTags(strings.Join(req.Tags, ","))
+	if err != nil {
+		return nil, fmt.Errorf("invalid tags: %w", err)
+	}
+	if req.From == "" && req.To == "" && len(tags) == 0 {
+		return nil, fmt.Errorf("upload_ids, a from/to range or tags are required")
+	}
+	from, err := parseTimeBound(req.From, false)
+	if err != nil {
+		return nil, fmt.Errorf("invalid from: %w", err)
+	}
+	to, err := parseTimeBound(req.To, true)
+	if err != nil {
+		return nil, fmt.Errorf("invalid to: %w", err)
+	}
+	if len(tags) > 0 {
+		return h.taggedUploads(files, tenant, services.ResultSearch{Tags: tags, From: from, To: to})
+	}
+	return files.ListUploads(from, to)
+}
+
+// taggedUploads returns the stored uploads whose history records match
+// search, oldest first. Tags are only kept in the history, where the range
+// applies to the time uploads were received. Uploads whose files have
+// expired are left out.
+func (h *AggregateHandler) taggedUploads(files *services.FileService, tenant string, search services.ResultSearch) ([]services.StoredUpload, error) {
+	// One match more than allowed lets Aggregate refuse the request
+	matches, _, err := h.history.Search(tenant, search, maxAggregateUploads+1, 0)
+	if err != nil {
+		h.logger.Errorf("Failed to find tagged uploads: %v", err)
+		return nil, errHistoryUnavailable
+	}
+	uploads := make([]services.StoredUpload, 0, len(matches))
+	for i := len(matches) - 1; i >= 0; i-- {
+		match := matches[i]
+		path, err := files.UploadPath(match.UploadID)
+		if errors.Is(err, services.ErrUploadNotFound) {
+			h.logger.Warnf("Leaving out tagged upload %s: its file no longer exists", match.UploadID)
+			continue
+		}
+		if err != nil {
+			return nil, err
+		}
+		uploads = append(uploads, services.StoredUpload{ID: match.UploadID, Path: path, UploadedAt: match.ReceivedAt})
+	}
+	return uploads, nil
+}
+
+// parseTimeBound parses an RFC 3339 timestamp or a YYYY-MM-DD date. A date
+// used as an upper bound covers the whole day.
+func parseTimeBound(value string, upper bool) (time.Time, error) {
+	if value == "" {
+		return time.Time{}, nil
+	}
+	if t, err := time.Parse(time.RFC3339, value); err == nil {
+		return t, nil
+	}
+	t, err := time.Parse("2006-01-02", value)
+	if err != nil {
+		return time.Time{}, fmt.Errorf("expected RFC 3339 timestamp or YYYY-MM-DD date, got %q", value)
+	}
+	if upper {
+		t = t.Add(24*time.Hour - time.Nanosecond)
+	}
+	return t, nil
+}
+
+func (h *AggregateHandler) respondError(c *gin.Context, code int, message string) {
+	c.JSON(code, models.ErrorResponse{
+		Success: false,
+		Error:   message,
+		Code:    code,
+	})
+}
@@ -0,0 +1,78 @@
+package handlers
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/mussietl/csv-sales-api/internal/models"
+	"github.com/mussietl/csv-sales-api/internal/services"
+	"github.com/sirupsen/logrus"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestAggregateByTags(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+	logger := logrus.New()
+	logger.SetLevel(logrus.FatalLevel)
+	files := services.NewFileService(t.TempDir(), logger)
+	history, err := services.NewHistoryRepository(filepath.Join(t.TempDir(), "history.db"), logger)
+	require.NoError(t, err)
+	t.Cleanup(func() { history.Close() })
+	departments, err := services.NewDepartmentStore(filepath.Join(t.TempDir(), "departments.json"), logger)
+	require.NoError(t, err)
+
+	at := time.Date(2024, 7, 1, 9, 0, 0, 0, time.UTC)
+	upload := func(day int, content string, tags ...string) string {
+		path, err := files.SaveUpload(context.Background(), "sales.csv", strings.NewReader(content))
+		require.NoError(t, err)
+		id := files.UploadID(path)
+		received := at.AddDate(0, 0, day)
+		history.Handle(services.Event{Type: services.EventUploadReceived, At: received, Tenant: services.DefaultTenantID, UploadID: id, Filename: "sales.csv"})
+		history.Handle(services.Event{
+			Type: services.EventProcessingCompleted, At: received, Tenant: services.DefaultTenantID, UploadID: id, Filename: "sales.csv",
+			Success: true, ResultID: "r-" + id, Tags: tags,
+		})
+		return id
+	}
+	first := upload(0, "Department Name,Number of Sales\nBooks,10\n", "q3", "emea")
+	second := upload(1, "Department Name,Number of Sales\nBooks,5\nToys,2\n", "q3", "emea")
+	upload(2, "Department Name,Number of Sales\nBooks,100\n", "q3")
+	expired := upload(3, "Department Name,Number of Sales\nBooks,1000\n", "q3", "emea")
+	require.NoError(t, files.DeleteUpload(expired))
+
+	h := NewAggregateHandler(files, services.NewCSVService(logger), departments, history, logger)
+	router := gin.New()
+	router.POST("/aggregate", h.Aggregate)
+	post := func(body string) *httptest.ResponseRecorder {
+		req := httptest.NewRequest(http.MethodPost, "/aggregate", strings.NewReader(body))
+		req.Header.Set("Content-Type", "application/json")
+		w := httptest.NewRecorder()
+		router.ServeHTTP(w, req)
+		return w
+	}
+
+	w := post(`{"tags": ["Q3", "emea"]}`)
+	require.Equal(t, http.StatusOK, w.Code, w.Body.String())
+	var response models.AggregateResponse
+	require.NoError(t, json.Unmarshal(w.Body.Bytes(), &response))
+	assert.Equal(t, []string{first, second}, response.UploadIDs)
+	assert.Equal(t, models.WholeAmount(17), response.TotalSales)
+
+	// The range applies to when tagged uploads were received
+	w = post(`{"tags": ["emea"], "from": "2024-07-02"}`)
+	require.Equal(t, http.StatusOK, w.Code, w.Body.String())
+	require.NoError(t, json.Unmarshal(w.Body.Bytes(), &response))
+	assert.Equal(t, []string{second}, response.UploadIDs)
+
+	assert.Equal(t, http.StatusNotFound, post(`{"tags": ["q4"]}`).Code)
+	assert.Equal(t, http.StatusBadRequest, post(`{"tags": ["no spaces"]}`).Code)
+	assert.Equal(t, http.StatusBadRequest, post(`{"tags": ["q3"], "upload_ids": ["`+first+`"]}`).Code)
+}
@@ -0,0 +1,128 @@
+package handlers
+
+import (
+	"errors"
+	"net/http"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/mussietl/csv-sales-api/internal/models"
+	"github.com/mussietl/csv-sales-api/internal/services"
+	"github.com/sirupsen/logrus"
+)
+
+// APIKeyHandler handles API key management requests
+type APIKeyHandler struct {
+	apiKeyService *services.APIKeyService
+	logger        *logrus.Logger
+}
+
+// NewAPIKeyHandler creates a new APIKeyHandler instance
+func NewAPIKeyHandler(apiKeyService *services.APIKeyService, logger *logrus.Logger) *APIKeyHandler {
+	return &APIKeyHandler{
+		apiKeyService: apiKeyService,
+		logger:        logger,
+	}
+}
+
+// createAPIKeyRequest is the body of a create request
+type createAPIKeyRequest struct {
+	Name      string     `json:"name" binding:"required"`
+	Scopes    []string   `json:"scopes" binding:"required"`
+	Tenant    string     `json:"tenant"`
+	ExpiresAt *time.Time `json:"expires_at"`
+}
+
+// apiKeySecretResponse returns a key together with its plaintext secret
+type apiKeySecretResponse struct {
+	Success bool             `json:"success"`
+	Key     string           `json:"key"`
+	APIKey  *services.APIKey `json:"api_key"`
+}
+
+// ListKeys returns all API keys without their secrets
+func (h *APIKeyHandler) ListKeys(c *gin.Context) {
+	c.JSON(http.StatusOK, gin.H{
+		"success":  true,
+		"api_keys": h.apiKeyService.List(),
+	})
+}
+
+// CreateKey issues a new API key
+func (h *APIKeyHandler) CreateKey(c *gin.Context) {
+	var req createAPIKeyRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		h.respondError(c, http.StatusBadRequest, "Invalid request body: "+err.Error())
+		return
+	}
+	if req.ExpiresAt != nil && req.ExpiresAt.Before(time.Now()) {
+		h.respondError(c, http.StatusBadRequest, "expires_at must be in the future")
+		return
+	}
+	if err := services.ValidateScopes(req.Scopes); err != nil {
+		h.respondError(c, http.StatusBadRequest, err.Error())
+		return
+	}
+	if err := services.ValidateKeyTenant(req.Tenant, req.Scopes); err != nil {
+		h.respondError(c, http.StatusBadRequest, err.Error())
+		return
+	}
+
+	plaintext, key, err := h.apiKeyService.Create(req.Name, req.Tenant, req.Scopes, req.ExpiresAt)
+	if err != nil {
+		h.logger.Errorf("Failed to create API key: %v", err)
+		h.respondError(c, http.StatusInternalServerError, "Failed to create API key")
+		return
+	}
+
+	c.JSON(http.StatusCreated, apiKeySecretResponse{Success: true, Key: plaintext, APIKey: key})
+}
+
+// RotateKey replaces the secret of an existing key
+func (h *APIKeyHandler) RotateKey(c *gin.Context) {
+	plaintext, key, err := h.apiKeyService.Rotate(c.Param("id"))
+	if err != nil {
+		h.respondServiceError(c, "Failed to rotate API key", err)
+		return
+	}
+
+	c.JSON(http.StatusOK, apiKeySecretResponse{Success: true, Key: plaintext, APIKey: key})
+}
+
+// DisableKey disables an API key
+func (h *APIKeyHandler) DisableKey(c *gin.Context) {
+	h.setDisabled(c, true)
+}
+
+// EnableKey re-enables a disabled API key
+func (h *APIKeyHandler) EnableKey(c *gin.Context) {
+	h.setDisabled(c, false)
+}
+
+func (h *APIKeyHandler) setDisabled(c *gin.Context, disabled bool) {
+	key, err := h.apiKeyService.SetDisabled(c.Param("id"), disabled)
+	if err != nil {
+		h.respondServiceError(c, "Failed to update API key", err)
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{"success": true, "api_key": key})
+}
+
+// respondServiceError maps service errors to HTTP responses
+func (h *APIKeyHandler) respondServiceError(c *gin.Context, message string, err error) {
+	if errors.Is(err, services.ErrAPIKeyNotFound) {
+		h.respondError(c, http.StatusNotFound, err.Error())
+		return
+	}
+	h.logger.Errorf("%s: %v", message, err)
+	h.respondError(c, http.StatusInternalServerError, message)
+}
+
+func (h *APIKeyHandler) respondError(c *gin.Context, code int, message string) {
+	c.JSON(code, models.ErrorResponse{
+		Success: false,
+		Error:   message,
+		Code:    code,
+	})
+}
@@ -0,0 +1,282 @@
+package handlers
+
+import (
+	"errors"
+	"fmt"
+	"mime/multipart"
+	"net/http"
+	"reflect"
+	"sort"
+	"strconv"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/mussietl/csv-sales-api/internal/middleware"
+	"github.com/mussietl/csv-sales-api/internal/models"
+	"github.com/mussietl/csv-sales-api/internal/services"
+)
+
+// DefaultBatchMaxFiles is the number of files a batch upload may hold unless configured
+const DefaultBatchMaxFiles = 20
+
+// SetBatchMaxFiles sets the number of files a batch upload may hold
+func (h *UploadHandler) SetBatchMaxFiles(n int) {
+	if n > 0 {
+		h.batchMaxFiles = n
+	}
+}
+
+// UploadBatch processes several files, such as one per store, with the same
+// options. Each file is stored and processed like a single upload, and the
+// department totals of the processed files are reconciled against each
+// other so departments missing from some files stand out.
+func (h *UploadHandler) UploadBatch(c *gin.Context) {
+	log := middleware.RequestLogger(c, h.logger)
+	h.guardUploadBody(c)
+	ephemeral, err := isEphemeral(c)
+	if err != nil {
+		h.respondError(c, http.StatusBadRequest, err.Error())
+		return
+	}
+	if ephemeral {
+		c.JSON(http.StatusBadRequest, invalidRequestResponse(fieldError("ephemeral", errors.New("cannot be used with batch uploads"))))
+		return
+	}
+
+	files, err := h.batchFiles(c)
+	if err != nil {
+		if h.respondUploadReadError(c, err) {
+			return
+		}
+		log.Errorf("Failed to get batch files: %v", err)
+		h.respondError(c, http.StatusBadRequest, err.Error())
+		return
+	}
+
+	opts, err := h.parseUploadOptions(c)
+	if err != nil {
+		log.Errorf("Invalid upload options: %v", err)
+		c.JSON(http.StatusBadRequest, invalidRequestResponse(err))
+		return
+	}
+	merge, err := mergeBatch(c)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, invalidRequestResponse(err))
+		return
+	}
+	var unsupported fieldErrors
+	if opts.Async {
+		unsupported = append(unsupported, models.FieldError{Field: "async", Error: "cannot be used with batch uploads"})
+	}
+	if opts.SupportRecord {
+		unsupported = append(unsupported, models.FieldError{Field: "support_record", Error: "cannot be used with batch uploads"})
+	}
+	if opts.CallbackURL != "" {
+		unsupported = append(unsupported, models.FieldError{Field: "callback_url", Error: "cannot be used with batch uploads"})
+	}
+	if opts.MicroBatch {
+		unsupported = append(unsupported, models.FieldError{Field: "micro_batch", Error: "cannot be used with batch uploads"})
+	}
+	if len(unsupported) > 0 {
+		c.JSON(http.StatusBadRequest, invalidRequestResponse(unsupported))
+		return
+	}
+
+	response := models.BatchUploadResponse{Files: make([]models.BatchFileResult, len(files))}
+	var processed []services.BatchFileTotals
+	var results []*services.ProcessResult
+	for i, file := range files {
+		result, summaries := h.processBatchFile(c, file, opts)
+		response.Files[i] = result
+		if result.Success {
+			processed = append(processed, services.BatchFileTotals{
+				File:      models.BatchFile{Filename: file.Filename, UploadID: result.Result.UploadID},
+				Summaries: summaries,
+			})
+			results = append(results, &services.ProcessResult{Summaries: summaries, Rows: result.Result.Rows})
+		}
+	}
+
+	if len(processed) == 0 {
+		response.Message = fmt.Sprintf("None of the %d files could be processed", len(files))
+		c.JSON(http.StatusUnprocessableEntity, response)
+		return
+	}
+
+	response.Reconciliation = services.ReconcileBatch(processed)
+	reconciliationPath, err := h.resultFiles(middleware.TenantID(c), opts).SaveBatchReconciliationFile(response.Reconciliation, opts.NumberFormat)
+	if err != nil {
+		log.Errorf("Failed to save batch reconciliation file: %v", err)
+		h.respondError(c, http.StatusInternalServerError, "Failed to save reconciliation file")
+		return
+	}
+	response.ReconciliationURL = h.fileService.GetDownloadURL(reconciliationPath)
+	if merge {
+		if response.Combined, err = h.combineBatch(middleware.TenantID(c), processed, results, opts); err != nil {
+			log.Errorf("Failed to save combined batch result: %v", err)
+			h.respondError(c, http.StatusInternalServerError, "Failed to save combined result file")
+			return
+		}
+	}
+
+	response.Success = len(processed) == len(files)
+	response.Message = fmt.Sprintf("Processed %d of %d files", len(processed), len(files))
+	incomplete := 0
+	for _, department := range response.Reconciliation.Departments {
+		if len(department.MissingFrom) > 0 {
+			incomplete++
+		}
+	}
+	if incomplete > 0 {
+		response.Message += fmt.Sprintf("; %d departments are missing from some files", incomplete)
+	}
+	c.JSON(http.StatusOK, response)
+}
+
+// mergeBatch reads the merge parameter of a batch upload
+func mergeBatch(c *gin.Context) (bool, error) {
+	value, ok := requestValue(c.Request, "merge")
+	if !ok || value == "" {
+		return false, nil
+	}
+	merge, err := strconv.ParseBool(value)
+	if err != nil {
+		return false, fieldError("merge", errors.New(kindMessage(reflect.Bool)))
+	}
+	return merge, nil
+}
+
+// combineBatch merges the department totals of the processed files of a
+// tenant's batch into one result file
+func (h *UploadHandler) combineBatch(tenant string, processed []services.BatchFileTotals, results []*services.ProcessResult, opts uploadOptions) (*models.BatchCombined, error) {
+	merged := services.MergeResults(results)
+	services.SortSummaries(merged.Summaries, opts.Process.Order)
+	files := h.resultFiles(tenant, opts)
+	var resultFilePath string
+	var err error
+	if opts.OutputFormat == services.OutputFormatIntegrationJSON {
+		resultFilePath, err = files.SaveIntegrationResultFile(services.ResultMetadata{ProcessedAt: time.Now()}, merged.Summaries, nil, opts.NumberFormat)
+	} else {
+		resultFilePath, err = files.SaveFormattedResultFile(merged.Summaries, opts.NumberFormat, opts.Metric, nil, opts.OutputFormat)
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	combined := &models.BatchCombined{
+		UploadIDs:        make([]string, len(processed)),
+		ResultID:         h.fileService.ResultID(resultFilePath),
+		DownloadURL:      h.fileService.GetDownloadURL(resultFilePath),
+		TotalDepartments: len(merged.Summaries),
+		Rows:             merged.Rows,
+		Departments:      departmentTotals(merged.Summaries, opts.Metric),
+	}
+	for i, file := range processed {
+		combined.UploadIDs[i] = file.File.UploadID
+	}
+	for _, summary := range merged.Summaries {
+		combined.TotalSales = combined.TotalSales.Add(summary.TotalSales)
+	}
+	if services.MetricIncludesCount(opts.Metric) {
+		combined.SalesCount = salesCount(merged.Summaries)
+	}
+	return combined, nil
+}
+
+// processBatchFile stores and processes one file of a batch, recording it
+// in the intake log like a single upload
+func (h *UploadHandler) processBatchFile(c *gin.Context, file *multipart.FileHeader, opts uploadOptions) (models.BatchFileResult, []services.DepartmentSummary) {
+	log := middleware.RequestLogger(c, h.logger)
+	result := models.BatchFileResult{Filename: file.Filename}
+	fail := func(code int, message string) (models.BatchFileResult, []services.DepartmentSummary) {
+		result.Error = &models.ErrorResponse{Success: false, Error: message, Code: code}
+		return result, nil
+	}
+
+	if err := h.fileService.ValidateFile(file); err != nil {
+		return fail(http.StatusBadRequest, err.Error())
+	}
+	filePath, err := h.fileService.ForTenant(middleware.TenantID(c)).SaveUploadedFile(c.Request.Context(), file)
+	if errors.Is(err, services.ErrUploadInterrupted) {
+		return fail(http.StatusBadRequest, "Upload interrupted before the file was saved")
+	}
+	if failure := scanErrorResponse(err); failure != nil {
+		result.Error = failure
+		return result, nil
+	}
+	if err != nil {
+		log.Errorf("Failed to save uploaded file %s: %v", file.Filename, err)
+		return fail(http.StatusInternalServerError, "Failed to save uploaded file")
+	}
+	if failure := h.checkUploadContent(filePath, file.Filename); failure != nil {
+		result.Error = failure
+		return result, nil
+	}
+
+	job := uploadJob{
+		UploadID: h.fileService.UploadID(filePath),
+		FilePath: filePath,
+		Filename: file.Filename,
+		Tenant:   middleware.TenantID(c),
+		BaseURL:  publicBaseURL(c),
+		Options:  opts,
+	}
+	if err := h.beginIntake(c, job); err != nil {
+		log.Errorf("Failed to record upload in intake log: %v", err)
+		return fail(http.StatusInternalServerError, "Failed to record uploaded file")
+	}
+	h.events.Publish(services.Event{
+		Type:     services.EventUploadReceived,
+		Tenant:   job.Tenant,
+		UploadID: job.UploadID,
+		Filename: file.Filename,
+		Size:     file.Size,
+	})
+
+	response, summaries, failure := h.processUploadSummaries(job)
+	if failure != nil {
+		err = h.intake.Fail(job.UploadID, failure.Error)
+		result.Error = failure
+	} else {
+		err = h.intake.Complete(job.UploadID, response.ResultID)
+		result.Success, result.Result = true, response
+	}
+	if err != nil {
+		log.Errorf("Failed to record outcome of upload %s in intake log: %v", job.UploadID, err)
+	}
+	return result, summaries
+}
+
+// batchFiles returns every file part of a batch upload: those in the
+// configured file fields first, then any other fields by name
+func (h *UploadHandler) batchFiles(c *gin.Context) ([]*multipart.FileHeader, error) {
+	form, err := c.MultipartForm()
+	if err != nil {
+		return nil, fmt.Errorf("Expected a multipart/form-data upload: %w", err)
+	}
+
+	fields := append([]string(nil), h.fileFields...)
+	var others []string
+	for field := range form.File {
+		known := false
+		for _, f := range h.fileFields {
+			known = known || f == field
+		}
+		if !known {
+			others = append(others, field)
+		}
+	}
+	sort.Strings(others)
+
+	var files []*multipart.FileHeader
+	for _, field := range append(fields, others...) {
+		files = append(files, form.File[field]...)
+	}
+	if len(files) == 0 {
+		return nil, errors.New("No files uploaded")
+	}
+	if len(files) > h.batchMaxFiles {
+		return nil, fmt.Errorf("Batch has %d files, maximum is %d", len(files), h.batchMaxFiles)
+	}
+	return files, nil
+}
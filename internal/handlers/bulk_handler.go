@@ -0,0 +1,111 @@
+package handlers
+
+import (
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/mussietl/csv-sales-api/internal/middleware"
+	"github.com/mussietl/csv-sales-api/internal/models"
+	"github.com/mussietl/csv-sales-api/internal/services"
+	"github.com/sirupsen/logrus"
+)
+
+// bulkRequest selects the uploads of a bulk operation by ID, by filter or both
+type bulkRequest struct {
+	UploadIDs []string `json:"upload_ids"`
+	Filter    struct {
+		// OlderThan is an RFC 3339 time or a YYYY-MM-DD date (UTC midnight)
+		OlderThan string `json:"older_than"`
+		Tenant    string `json:"tenant"`
+		State     string `json:"state" binding:"omitempty,oneof=processing completed failed deleted"`
+	} `json:"filter"`
+	DryRun bool `json:"dry_run"`
+}
+
+// BulkHandler deletes and reprocesses many uploads in one request
+type BulkHandler struct {
+	bulk   *services.BulkOperations
+	logger *logrus.Logger
+}
+
+// NewBulkHandler creates a new BulkHandler instance
+func NewBulkHandler(bulk *services.BulkOperations, logger *logrus.Logger) *BulkHandler {
+	return &BulkHandler{bulk: bulk, logger: logger}
+}
+
+// BulkDelete deletes the selected uploads, or with dry_run reports which
+// would be deleted
+func (h *BulkHandler) BulkDelete(c *gin.Context) {
+	sel, dryRun, ok := h.bindSelector(c)
+	if !ok {
+		return
+	}
+	reason := "bulk deleted"
+	if value, ok := c.Get(middleware.ContextAPIKey); ok {
+		reason = "bulk deleted by " + value.(*services.APIKey).Name
+	}
+	result, err := h.bulk.Delete(sel, dryRun, reason)
+	if err != nil {
+		h.logger.Errorf("Bulk delete failed: %v", err)
+		h.respondError(c, http.StatusInternalServerError, "Failed to select uploads")
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{"success": true, "result": result})
+}
+
+// BulkReprocess reprocesses the selected failed uploads, or with dry_run
+// reports which would be reprocessed
+func (h *BulkHandler) BulkReprocess(c *gin.Context) {
+	sel, dryRun, ok := h.bindSelector(c)
+	if !ok {
+		return
+	}
+	result, err := h.bulk.Reprocess(sel, dryRun)
+	if err != nil {
+		h.logger.Errorf("Bulk reprocess failed: %v", err)
+		h.respondError(c, http.StatusInternalServerError, "Failed to select uploads")
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{"success": true, "result": result})
+}
+
+// bindSelector reads the request body, responding with an error if it is
+// invalid or selects every upload
+func (h *BulkHandler) bindSelector(c *gin.Context) (services.BulkSelector, bool, bool) {
+	var req bulkRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		h.respondError(c, http.StatusBadRequest, "Invalid request body: "+err.Error())
+		return services.BulkSelector{}, false, false
+	}
+	if len(req.UploadIDs) > services.MaxBulkItems {
+		h.respondError(c, http.StatusBadRequest, "at most "+strconv.Itoa(services.MaxBulkItems)+" upload_ids may be listed")
+		return services.BulkSelector{}, false, false
+	}
+
+	sel := services.BulkSelector{UploadIDs: req.UploadIDs, Tenant: req.Filter.Tenant, State: req.Filter.State}
+	if req.Filter.OlderThan != "" {
+		olderThan, err := time.Parse(time.RFC3339, req.Filter.OlderThan)
+		if err != nil {
+			if olderThan, err = time.Parse(time.DateOnly, req.Filter.OlderThan); err != nil {
+				h.respondError(c, http.StatusBadRequest, "filter.older_than must be an RFC 3339 time or a YYYY-MM-DD date")
+				return services.BulkSelector{}, false, false
+			}
+		}
+		sel.OlderThan = olderThan
+	}
+	if sel.Empty() {
+		h.respondError(c, http.StatusBadRequest, "upload_ids or a filter is required")
+		return services.BulkSelector{}, false, false
+	}
+	return sel, req.DryRun, true
+}
+
+func (h *BulkHandler) respondError(c *gin.Context, code int, message string) {
+	c.JSON(code, models.ErrorResponse{
+		Success: false,
+		Error:   message,
+		Code:    code,
+	})
+}
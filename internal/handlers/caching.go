@@ -0,0 +1,57 @@
+package handlers
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"net/http"
+	"strings"
+
+	"github.com/gin-gonic/gin"
+)
+
+// cacheRevalidate lets clients keep a response but check it with its ETag on
+// every use; for polled resources such as results and jobs
+const cacheRevalidate = "private, no-cache"
+
+// contentETag returns a weak ETag of value's JSON form. It is weak because
+// responses tagged by part of their content, such as results without their
+// signed download URLs, are equivalent rather than byte-identical.
+func contentETag(value any) (string, error) {
+	data, err := json.Marshal(value)
+	if err != nil {
+		return "", err
+	}
+	sum := sha256.Sum256(data)
+	return `W/"` + hex.EncodeToString(sum[:16]) + `"`, nil
+}
+
+// etagMatches reports whether an If-None-Match header lists etag, using the
+// weak comparison required for GET requests
+func etagMatches(header, etag string) bool {
+	for _, candidate := range strings.Split(header, ",") {
+		candidate = strings.TrimSpace(candidate)
+		if candidate == "*" || strings.TrimPrefix(candidate, "W/") == strings.TrimPrefix(etag, "W/") {
+			return true
+		}
+	}
+	return false
+}
+
+// respondCached sends body as JSON with the given Cache-Control and an ETag
+// derived from tagged, or 304 Not Modified when the client's copy has that
+// ETag already. tagged is usually body itself.
+func respondCached(c *gin.Context, cacheControl string, tagged, body any) {
+	c.Header("Cache-Control", cacheControl)
+	etag, err := contentETag(tagged)
+	if err != nil {
+		c.JSON(http.StatusOK, body)
+		return
+	}
+	c.Header("ETag", etag)
+	if match := c.GetHeader("If-None-Match"); match != "" && etagMatches(match, etag) {
+		c.Status(http.StatusNotModified)
+		return
+	}
+	c.JSON(http.StatusOK, body)
+}
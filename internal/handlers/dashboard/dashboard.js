@@ -0,0 +1,184 @@
+// Operator dashboard: polls the admin API with a key kept in sessionStorage.
+"use strict";
+
+const API = "/api/v1/admin";
+const REFRESH_MS = 15000;
+let timer = null;
+
+const $ = (id) => document.getElementById(id);
+
+function apiKey() {
+  return sessionStorage.getItem("adminKey") || "";
+}
+
+async function api(method, path) {
+  const response = await fetch(API + path, {
+    method,
+    headers: { Authorization: "Bearer " + apiKey() },
+  });
+  const body = await response.json().catch(() => ({}));
+  if (!response.ok) {
+    const error = new Error(body.error || response.statusText);
+    error.status = response.status;
+    throw error;
+  }
+  return body;
+}
+
+function formatBytes(bytes) {
+  const units = ["B", "KB", "MB", "GB", "TB"];
+  let i = 0;
+  while (bytes >= 1024 && i < units.length - 1) {
+    bytes /= 1024;
+    i++;
+  }
+  return (i === 0 ? bytes : bytes.toFixed(1)) + " " + units[i];
+}
+
+function formatRate(stats) {
+  const finished = stats.completed + stats.failed;
+  if (finished === 0) {
+    return "–";
+  }
+  return (stats.failure_rate * 100).toFixed(1) + "% (" + stats.failed + "/" + finished + ")";
+}
+
+function cell(row, text, className) {
+  const td = row.insertCell();
+  td.textContent = text;
+  if (className) {
+    td.className = className;
+  }
+  return td;
+}
+
+function button(parent, label, onClick) {
+  const b = document.createElement("button");
+  b.type = "button";
+  b.textContent = label;
+  b.addEventListener("click", onClick);
+  parent.appendChild(b);
+}
+
+function showMessage(text) {
+  $("message").textContent = text;
+}
+
+async function action(label, method, path) {
+  try {
+    await api(method, path);
+    showMessage(label + " succeeded");
+  } catch (err) {
+    showMessage(label + " failed: " + err.message);
+  }
+  refresh();
+}
+
+function renderUploads(uploads) {
+  const body = $("uploads");
+  body.replaceChildren();
+  for (const upload of uploads) {
+    const row = body.insertRow();
+    cell(row, new Date(upload.at).toLocaleString());
+    cell(row, upload.tenant);
+    cell(row, upload.filename);
+    cell(row, upload.state, "state-" + upload.state);
+    cell(row, upload.error || "", "error");
+    const actions = row.insertCell();
+    if (upload.download_url) {
+      const link = document.createElement("a");
+      link.href = upload.download_url;
+      link.textContent = "Download";
+      actions.appendChild(link);
+    }
+    if (upload.state === "failed") {
+      button(actions, "Retry", () => action("Retry of " + upload.filename, "POST", "/intake/" + encodeURIComponent(upload.upload_id) + "/requeue"));
+    }
+    if (upload.state !== "processing") {
+      button(actions, "Delete", () => {
+        if (confirm("Delete the stored upload " + upload.filename + "?")) {
+          action("Delete of " + upload.filename, "DELETE", "/uploads/" + encodeURIComponent(upload.upload_id));
+        }
+      });
+    }
+  }
+}
+
+function render(data) {
+  $("queue").textContent = data.queue.processing;
+  $("failures-hour").textContent = formatRate(data.failures.last_hour);
+  $("failures-day").textContent = formatRate(data.failures.last_day);
+  $("storage").textContent = formatBytes(data.storage.bytes) + " in " + data.storage.files + " files";
+  $("updated").textContent = "Updated " + new Date(data.generated_at).toLocaleTimeString();
+
+  renderUploads(data.uploads);
+
+  const tenants = $("tenants");
+  tenants.replaceChildren();
+  for (const tenant of data.queue.tenants) {
+    const row = tenants.insertRow();
+    cell(row, tenant.tenant);
+    cell(row, tenant.active);
+    cell(row, tenant.limit === 0 ? "unlimited" : tenant.limit);
+  }
+
+  const kinds = $("kinds");
+  kinds.replaceChildren();
+  for (const [kind, usage] of Object.entries(data.storage.kinds).sort()) {
+    const row = kinds.insertRow();
+    cell(row, kind);
+    cell(row, usage.files);
+    cell(row, formatBytes(usage.bytes));
+  }
+}
+
+async function refresh() {
+  try {
+    render(await api("GET", "/dashboard"));
+  } catch (err) {
+    if (err.status === 401 || err.status === 403) {
+      signOut("Sign-in failed: " + err.message);
+      return;
+    }
+    showMessage("Failed to load dashboard: " + err.message);
+  }
+}
+
+function signIn() {
+  $("signin").hidden = true;
+  $("dashboard").hidden = false;
+  $("refresh").hidden = false;
+  $("signout").hidden = false;
+  refresh();
+  timer = setInterval(refresh, REFRESH_MS);
+}
+
+function signOut(reason) {
+  sessionStorage.removeItem("adminKey");
+  clearInterval(timer);
+  $("dashboard").hidden = true;
+  $("refresh").hidden = true;
+  $("signout").hidden = true;
+  $("signin").hidden = false;
+  if (reason) {
+    alert(reason);
+  }
+}
+
+document.addEventListener("DOMContentLoaded", () => {
+  $("connect").addEventListener("click", () => {
+    sessionStorage.setItem("adminKey", $("key").value.trim());
+    $("key").value = "";
+    signIn();
+  });
+  $("key").addEventListener("keydown", (e) => {
+    if (e.key === "Enter") {
+      $("connect").click();
+    }
+  });
+  $("refresh").addEventListener("click", refresh);
+  $("signout").addEventListener("click", () => signOut());
+  if (apiKey()) {
+    signIn();
+  }
+});
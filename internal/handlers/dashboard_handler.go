@@ -0,0 +1,190 @@
+package handlers
+
+import (
+	"embed"
+	"errors"
+	"net/http"
+	"path"
+	"strconv"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/mussietl/csv-sales-api/internal/middleware"
+	"github.com/mussietl/csv-sales-api/internal/models"
+	"github.com/mussietl/csv-sales-api/internal/services"
+	"github.com/sirupsen/logrus"
+)
+
+//go:embed dashboard/*
+var dashboardAssets embed.FS
+
+// dashboardContentTypes maps the dashboard's asset extensions to content types
+var dashboardContentTypes = map[string]string{
+	".html": "text/html; charset=utf-8",
+	".js":   "text/javascript; charset=utf-8",
+	".css":  "text/css; charset=utf-8",
+}
+
+// dashboardContentSecurityPolicy lets the dashboard load its own scripts and
+// styles and call the API, and nothing else
+const dashboardContentSecurityPolicy = "default-src 'none'; script-src 'self'; style-src 'self'; connect-src 'self'; frame-ancestors 'none'; base-uri 'none'; form-action 'none'"
+
+// Limits on the recent uploads listed by the dashboard
+const (
+	defaultDashboardUploads = 25
+	maxDashboardUploads     = 200
+)
+
+// DashboardHandler serves the operator dashboard page and its data
+type DashboardHandler struct {
+	fileService *services.FileService
+	intake      *services.IntakeLog
+	limiter     *services.TenantLimiter
+	events      *services.EventBus
+	logger      *logrus.Logger
+}
+
+// NewDashboardHandler creates a new DashboardHandler instance
+func NewDashboardHandler(fileService *services.FileService, intake *services.IntakeLog, limiter *services.TenantLimiter, events *services.EventBus, logger *logrus.Logger) *DashboardHandler {
+	return &DashboardHandler{
+		fileService: fileService,
+		intake:      intake,
+		limiter:     limiter,
+		events:      events,
+		logger:      logger,
+	}
+}
+
+// Page serves the dashboard's static files. They hold no data: the page asks
+// for an admin API key and uses it to call the admin API.
+func (h *DashboardHandler) Page(c *gin.Context) {
+	name := c.Param("asset")
+	if name == "" || name == "/" {
+		name = "index.html"
+	}
+	name = path.Base(name)
+	contentType, ok := dashboardContentTypes[path.Ext(name)]
+	if !ok {
+		c.Status(http.StatusNotFound)
+		return
+	}
+	data, err := dashboardAssets.ReadFile("dashboard/" + name)
+	if err != nil {
+		c.Status(http.StatusNotFound)
+		return
+	}
+	c.Header("Content-Security-Policy", dashboardContentSecurityPolicy)
+	c.Header("Cache-Control", "no-cache")
+	c.Data(http.StatusOK, contentType, data)
+}
+
+// dashboardUpload is an intake log entry with a link to its result
+type dashboardUpload struct {
+	services.IntakeEntry
+	DownloadURL string `json:"download_url,omitempty"`
+}
+
+// Summary returns recent uploads, failure rates, the processing queue and
+// storage usage. ?limit= sets the number of recent uploads.
+func (h *DashboardHandler) Summary(c *gin.Context) {
+	limit := defaultDashboardUploads
+	if value := c.Query("limit"); value != "" {
+		n, err := strconv.Atoi(value)
+		if err != nil || n < 1 || n > maxDashboardUploads {
+			h.respondError(c, http.StatusBadRequest, "limit must be an integer between 1 and "+strconv.Itoa(maxDashboardUploads))
+			return
+		}
+		limit = n
+	}
+
+	entries := h.intake.List("")
+	if len(entries) > limit {
+		entries = entries[:limit]
+	}
+	uploads := make([]dashboardUpload, len(entries))
+	for i, entry := range entries {
+		uploads[i] = dashboardUpload{IntakeEntry: entry}
+		if entry.ResultID == "" {
+			continue
+		}
+		if resultPath, err := h.fileService.ResultPath(entry.ResultID); err == nil {
+			uploads[i].DownloadURL = h.fileService.GetDownloadURL(resultPath)
+		}
+	}
+
+	storage, err := h.fileService.StorageUsage()
+	if err != nil {
+		h.logger.Errorf("Failed to measure storage usage: %v", err)
+		h.respondError(c, http.StatusInternalServerError, "Failed to measure storage usage")
+		return
+	}
+
+	now := time.Now()
+	c.JSON(http.StatusOK, gin.H{
+		"success":      true,
+		"generated_at": now.UTC().Format(time.RFC3339),
+		"uploads":      uploads,
+		"failures": gin.H{
+			"last_hour": h.intake.Stats(now.Add(-time.Hour)),
+			"last_day":  h.intake.Stats(now.Add(-24 * time.Hour)),
+		},
+		"queue": gin.H{
+			"processing": h.intake.Stats(time.Time{}).Processing,
+			"tenants":    h.limiter.List(),
+		},
+		"storage": storage,
+	})
+}
+
+// DeleteUpload removes a stored upload. Uploads still being processed are kept.
+func (h *DashboardHandler) DeleteUpload(c *gin.Context) {
+	id := c.Param("id")
+	var entry services.IntakeEntry
+	for _, e := range h.intake.List("") {
+		if e.UploadID == id {
+			entry = e
+			break
+		}
+	}
+	if entry.State == services.IntakeProcessing {
+		h.respondError(c, http.StatusConflict, "upload is still being processed")
+		return
+	}
+
+	uploadPath, err := h.fileService.UploadPath(id)
+	if err != nil {
+		code := http.StatusBadRequest
+		if errors.Is(err, services.ErrUploadNotFound) {
+			code = http.StatusNotFound
+		}
+		h.respondError(c, code, err.Error())
+		return
+	}
+	if err := h.fileService.DeleteUpload(id); err != nil {
+		h.logger.Errorf("Failed to delete upload %s: %v", id, err)
+		h.respondError(c, http.StatusInternalServerError, "Failed to delete upload")
+		return
+	}
+
+	reason := "deleted from the dashboard"
+	if value, ok := c.Get(middleware.ContextAPIKey); ok {
+		reason = "deleted by " + value.(*services.APIKey).Name
+	}
+	h.events.Publish(services.Event{
+		Type:     services.EventCleanup,
+		Tenant:   entry.Tenant,
+		UploadID: id,
+		Filename: entry.Filename,
+		Paths:    []string{uploadPath},
+		Reason:   reason,
+	})
+	c.JSON(http.StatusOK, gin.H{"success": true, "upload_id": id})
+}
+
+func (h *DashboardHandler) respondError(c *gin.Context, code int, message string) {
+	c.JSON(code, models.ErrorResponse{
+		Success: false,
+		Error:   message,
+		Code:    code,
+	})
+}
@@ -0,0 +1,87 @@
+package handlers
+
+import (
+	"errors"
+	"net/http"
+
+	"github.com/gin-gonic/gin"
+	"github.com/mussietl/csv-sales-api/internal/models"
+	"github.com/mussietl/csv-sales-api/internal/services"
+	"github.com/sirupsen/logrus"
+)
+
+// DepartmentHandler handles department dimension table requests
+type DepartmentHandler struct {
+	departments *services.DepartmentStore
+	logger      *logrus.Logger
+}
+
+// NewDepartmentHandler creates a new DepartmentHandler instance
+func NewDepartmentHandler(departments *services.DepartmentStore, logger *logrus.Logger) *DepartmentHandler {
+	return &DepartmentHandler{
+		departments: departments,
+		logger:      logger,
+	}
+}
+
+// ListDepartments returns every department of a tenant
+func (h *DepartmentHandler) ListDepartments(c *gin.Context) {
+	tenant := c.Param("tenant")
+	c.JSON(http.StatusOK, gin.H{"success": true, "tenant": tenant, "departments": h.departments.List(tenant)})
+}
+
+// GetDepartment returns a department by name or alias
+func (h *DepartmentHandler) GetDepartment(c *gin.Context) {
+	department, err := h.departments.Get(c.Param("tenant"), c.Param("name"))
+	if err != nil {
+		h.respondError(c, http.StatusNotFound, err.Error())
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{"success": true, "department": department})
+}
+
+// UpdateDepartment sets a department's region, aliases or attributes
+func (h *DepartmentHandler) UpdateDepartment(c *gin.Context) {
+	var update services.DepartmentUpdate
+	if err := c.ShouldBindJSON(&update); err != nil {
+		h.respondError(c, http.StatusBadRequest, "Invalid request body: "+err.Error())
+		return
+	}
+
+	tenant, name := c.Param("tenant"), c.Param("name")
+	department, err := h.departments.Update(tenant, name, update)
+	if err != nil {
+		h.logger.Errorf("Failed to update department %s of tenant %s: %v", name, tenant, err)
+		code := http.StatusBadRequest
+		if errors.Is(err, services.ErrDepartmentConflict) {
+			code = http.StatusConflict
+		}
+		h.respondError(c, code, err.Error())
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{"success": true, "department": department})
+}
+
+// DeleteDepartment removes a department and its history
+func (h *DepartmentHandler) DeleteDepartment(c *gin.Context) {
+	tenant, name := c.Param("tenant"), c.Param("name")
+	if err := h.departments.Delete(tenant, name); err != nil {
+		code := http.StatusInternalServerError
+		if errors.Is(err, services.ErrDepartmentNotFound) {
+			code = http.StatusNotFound
+		} else {
+			h.logger.Errorf("Failed to delete department %s of tenant %s: %v", name, tenant, err)
+		}
+		h.respondError(c, code, err.Error())
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{"success": true})
+}
+
+func (h *DepartmentHandler) respondError(c *gin.Context, code int, message string) {
+	c.JSON(code, models.ErrorResponse{
+		Success: false,
+		Error:   message,
+		Code:    code,
+	})
+}
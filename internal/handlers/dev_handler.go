@@ -0,0 +1,70 @@
+package handlers
+
+import (
+	"fmt"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/mussietl/csv-sales-api/internal/models"
+	"github.com/mussietl/csv-sales-api/internal/services"
+	"github.com/sirupsen/logrus"
+)
+
+// DevHandler serves development and demo helpers; it is only routed in dev mode
+type DevHandler struct {
+	logger *logrus.Logger
+}
+
+// NewDevHandler creates a new DevHandler instance
+func NewDevHandler(logger *logrus.Logger) *DevHandler {
+	return &DevHandler{logger: logger}
+}
+
+// Sample streams a generated sales CSV. Query parameters: rows (default
+// 1000), departments (default 10) and seed (default random).
+func (h *DevHandler) Sample(c *gin.Context) {
+	opts := services.SampleOptions{Rows: 1000, Departments: 10, Seed: time.Now().UnixNano()}
+	for _, param := range []struct {
+		name   string
+		target *int
+	}{{"rows", &opts.Rows}, {"departments", &opts.Departments}} {
+		if value := c.Query(param.name); value != "" {
+			n, err := strconv.Atoi(value)
+			if err != nil {
+				h.respondError(c, http.StatusBadRequest, fmt.Sprintf("%s must be an integer", param.name))
+				return
+			}
+			*param.target = n
+		}
+	}
+	if value := c.Query("seed"); value != "" {
+		seed, err := strconv.ParseInt(value, 10, 64)
+		if err != nil {
+			h.respondError(c, http.StatusBadRequest, "seed must be an integer")
+			return
+		}
+		opts.Seed = seed
+	}
+	if err := opts.Validate(); err != nil {
+		h.respondError(c, http.StatusBadRequest, err.Error())
+		return
+	}
+
+	c.Header("Content-Type", "text/csv")
+	c.Header("Content-Disposition", fmt.Sprintf(`attachment; filename="sample_%d_rows.csv"`, opts.Rows))
+	c.Header("X-Sample-Seed", strconv.FormatInt(opts.Seed, 10))
+	c.Status(http.StatusOK)
+	if err := services.GenerateSampleCSV(c.Writer, opts); err != nil {
+		h.logger.Errorf("Failed to write sample CSV: %v", err)
+	}
+}
+
+func (h *DevHandler) respondError(c *gin.Context, code int, message string) {
+	c.JSON(code, models.ErrorResponse{
+		Success: false,
+		Error:   message,
+		Code:    code,
+	})
+}
@@ -0,0 +1,101 @@
+package handlers
+
+import (
+	"fmt"
+	"mime"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"github.com/gin-gonic/gin"
+	"github.com/mussietl/csv-sales-api/internal/middleware"
+	"github.com/mussietl/csv-sales-api/internal/models"
+	"github.com/mussietl/csv-sales-api/internal/services"
+	"github.com/sirupsen/logrus"
+)
+
+// downloadContentTypes maps the file extensions that may be downloaded to their content types
+var downloadContentTypes = map[string]string{
+	".csv":    "text/csv; charset=utf-8",
+	".json":   "application/json; charset=utf-8",
+	".ndjson": "application/x-ndjson; charset=utf-8",
+	".xlsx":   "application/vnd.openxmlformats-officedocument.spreadsheetml.sheet",
+	".md":     "text/markdown; charset=utf-8",
+	".html":   "text/html; charset=utf-8",
+	".txt":    "text/plain; charset=utf-8",
+}
+
+// DownloadHandler serves stored files as attachments
+type DownloadHandler struct {
+	uploadsDir string
+	logger     *logrus.Logger
+}
+
+// NewDownloadHandler creates a new DownloadHandler instance
+func NewDownloadHandler(uploadsDir string, logger *logrus.Logger) *DownloadHandler {
+	return &DownloadHandler{
+		uploadsDir: uploadsDir,
+		logger:     logger,
+	}
+}
+
+// DownloadFile serves a single output file, such as a report or heatmap,
+// from the directory of the request's tenant. Only known file types are
+// served, always as a download rather than inline. Uploads are never served,
+// and results only through the results API, which checks who owns them.
+func (h *DownloadHandler) DownloadFile(c *gin.Context) {
+	filename := c.Param("filename")
+	contentType, ok := downloadContentTypes[strings.ToLower(filepath.Ext(filename))]
+	if !ok || filename != filepath.Base(filename) || strings.HasPrefix(filename, ".") ||
+		strings.HasPrefix(filename, "upload_") || strings.HasPrefix(filename, "result_") {
+		h.notFound(c)
+		return
+	}
+
+	file, err := os.Open(filepath.Join(services.TenantDir(h.uploadsDir, middleware.TenantID(c)), filename))
+	if err != nil {
+		h.notFound(c)
+		return
+	}
+	defer file.Close()
+	info, err := file.Stat()
+	if err != nil || !info.Mode().IsRegular() {
+		h.notFound(c)
+		return
+	}
+	serveAttachment(c, file, info, filename, contentType)
+}
+
+// serveAttachment sends an open file as a download. Range requests are
+// answered with the requested bytes so interrupted downloads can resume;
+// If-Range with the ETag ensures a resumed download still refers to the
+// same file.
+func serveAttachment(c *gin.Context, file *os.File, info os.FileInfo, filename, contentType string) {
+	// Encrypted results keep their name on the server but are served as
+	// what they are, named like the age tool names its output
+	attachment := filename
+	if services.IsEncrypted(file) {
+		contentType, attachment = "application/octet-stream", filename+".age"
+	}
+	c.Header("Content-Type", contentType)
+	c.Header("Content-Disposition", mime.FormatMediaType("attachment", map[string]string{"filename": attachment}))
+	c.Header("Cache-Control", "private, no-store")
+	c.Header("ETag", fileETag(info))
+	// ServeContent sets Content-Length and Accept-Ranges and handles Range,
+	// If-Range and conditional requests by seeking in the file
+	http.ServeContent(c.Writer, c.Request, filename, info.ModTime(), file)
+}
+
+// fileETag is a strong validator of a file's size and modification time
+func fileETag(info os.FileInfo) string {
+	return fmt.Sprintf(`"%x-%x"`, info.Size(), info.ModTime().UnixNano())
+}
+
+func (h *DownloadHandler) notFound(c *gin.Context) {
+	c.JSON(http.StatusNotFound, models.ErrorResponse{
+		Success: false,
+		Error:   "File not found",
+		Code:    http.StatusNotFound,
+	})
+}
@@ -0,0 +1,101 @@
+package handlers
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/gin-gonic/gin"
+	"github.com/mussietl/csv-sales-api/internal/middleware"
+	"github.com/mussietl/csv-sales-api/internal/services"
+	"github.com/sirupsen/logrus"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+const testResultID = "0f8fad5b-d9cb-469f-a165-70867728950e"
+
+// newDownloadRouter serves a tenant's files and results from uploadsDir
+func newDownloadRouter(t *testing.T, uploadsDir string) *gin.Engine {
+	t.Helper()
+	gin.SetMode(gin.TestMode)
+	logger := logrus.New()
+	logger.SetLevel(logrus.FatalLevel)
+	intake, err := services.NewIntakeLog(filepath.Join(t.TempDir(), "intake.log"), logger)
+	require.NoError(t, err)
+	t.Cleanup(func() { intake.Close() })
+
+	downloads := NewDownloadHandler(uploadsDir, logger)
+	results := NewResultHandler(services.NewFileService(uploadsDir, logger), nil, nil, intake, nil, logger)
+	router := gin.New()
+	router.GET("/files/:filename", downloads.DownloadFile)
+	router.GET("/results/:id/download", results.DownloadResult)
+	return router
+}
+
+// writeTenantFile stores a file in a tenant's directory
+func writeTenantFile(t *testing.T, uploadsDir, tenant, name, content string) {
+	t.Helper()
+	dir := services.TenantDir(uploadsDir, tenant)
+	require.NoError(t, os.MkdirAll(dir, 0755))
+	require.NoError(t, os.WriteFile(filepath.Join(dir, name), []byte(content), 0644))
+}
+
+func get(router *gin.Engine, path, tenant string, header http.Header) *httptest.ResponseRecorder {
+	req := httptest.NewRequest(http.MethodGet, path, nil)
+	for name, values := range header {
+		req.Header[name] = values
+	}
+	if tenant != "" {
+		req.Header.Set(middleware.TenantHeader, tenant)
+	}
+	w := httptest.NewRecorder()
+	router.ServeHTTP(w, req)
+	return w
+}
+
+func TestDownloadResultTenants(t *testing.T) {
+	uploadsDir := t.TempDir()
+	router := newDownloadRouter(t, uploadsDir)
+	writeTenantFile(t, uploadsDir, "acme", "result_"+testResultID+".csv", "Department Name,Total Number of Sales\nBooks,10\n")
+
+	w := get(router, "/results/"+testResultID+"/download", "acme", nil)
+	assert.Equal(t, http.StatusOK, w.Code)
+	assert.Contains(t, w.Body.String(), "Books,10")
+
+	for _, tenant := range []string{"globex", ""} {
+		w = get(router, "/results/"+testResultID+"/download", tenant, nil)
+		assert.Equal(t, http.StatusNotFound, w.Code, "tenant %q can't download acme's result", tenant)
+		assert.NotContains(t, w.Body.String(), "Books")
+	}
+}
+
+func TestDownloadFile(t *testing.T) {
+	uploadsDir := t.TempDir()
+	router := newDownloadRouter(t, uploadsDir)
+	writeTenantFile(t, uploadsDir, "acme", "report_1.md", "# Sales report\n")
+	writeTenantFile(t, uploadsDir, "acme", "upload_1.csv", "Department,Sales\nBooks,10\n")
+	writeTenantFile(t, uploadsDir, "acme", "result_"+testResultID+".csv", "Department Name,Total Number of Sales\nBooks,10\n")
+
+	w := get(router, "/files/report_1.md", "acme", nil)
+	assert.Equal(t, http.StatusOK, w.Code)
+	assert.Contains(t, w.Header().Get("Content-Disposition"), "attachment")
+	assert.Equal(t, "# Sales report\n", w.Body.String())
+
+	// Uploads are never served, and results only through the results API
+	for _, name := range []string{"upload_1.csv", "result_" + testResultID + ".csv"} {
+		w = get(router, "/files/"+name, "acme", nil)
+		assert.Equal(t, http.StatusNotFound, w.Code, name)
+		assert.NotContains(t, w.Body.String(), "Books", name)
+	}
+	w = get(router, "/files/report_1.md", "globex", nil)
+	assert.Equal(t, http.StatusNotFound, w.Code, "files of other tenants are not found")
+
+	// Range requests resume a download
+	w = get(router, "/files/report_1.md", "acme", http.Header{"Range": {"bytes=2-6"}})
+	assert.Equal(t, http.StatusPartialContent, w.Code)
+	assert.Equal(t, "Sales", w.Body.String())
+	assert.Equal(t, "bytes 2-6/15", w.Header().Get("Content-Range"))
+}
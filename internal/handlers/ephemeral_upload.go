@@ -0,0 +1,288 @@
+package handlers
+
+import (
+	"bufio"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/mussietl/csv-sales-api/internal/middleware"
+	"github.com/mussietl/csv-sales-api/internal/models"
+	"github.com/mussietl/csv-sales-api/internal/services"
+)
+
+// maxEphemeralFieldBytes bounds the form fields read before the file part of
+// an ephemeral upload
+const maxEphemeralFieldBytes = 64 << 10
+
+// isEphemeral reports whether the request asked for ephemeral processing.
+// Only the query string is checked: reading the form would spool the upload
+// to disk before the handler can decide not to.
+func isEphemeral(c *gin.Context) (bool, error) {
+	value := strings.TrimSpace(c.Query("ephemeral"))
+	if value == "" {
+		return false, nil
+	}
+	ephemeral, err := strconv.ParseBool(value)
+	if err != nil {
+		return false, fmt.Errorf("ephemeral must be true or false")
+	}
+	return ephemeral, nil
+}
+
+// streamFilePart reads the form fields of a multipart request up to its
+// first file part and returns that part unread, so the file can be streamed
+// without being spooled to disk. The fields are set as the request's
+// PostForm for option parsing. It responds itself when it fails.
+func (h *UploadHandler) streamFilePart(c *gin.Context) (io.ReadCloser, string, bool) {
+	reader, err := c.Request.MultipartReader()
+	if err != nil {
+		h.respondError(c, http.StatusBadRequest, fmt.Sprintf("Expected a multipart/form-data upload: %v", err))
+		return nil, "", false
+	}
+
+	// Collect the fields preceding the file so the usual option parsing sees them
+	fields := url.Values{}
+	fieldBytes := 0
+	for {
+		p, err := reader.NextPart()
+		if errors.Is(err, io.EOF) {
+			break
+		}
+		if err != nil {
+			if !h.respondUploadReadError(c, err) {
+				h.respondError(c, http.StatusBadRequest, fmt.Sprintf("Failed to read multipart upload: %v", err))
+			}
+			return nil, "", false
+		}
+		if p.FileName() != "" {
+			c.Request.PostForm = fields
+			return p, p.FileName(), true
+		}
+		value, err := io.ReadAll(io.LimitReader(p, int64(maxEphemeralFieldBytes-fieldBytes+1)))
+		p.Close()
+		if err != nil {
+			if !h.respondUploadReadError(c, err) {
+				h.respondError(c, http.StatusBadRequest, fmt.Sprintf("Failed to read multipart upload: %v", err))
+			}
+			return nil, "", false
+		}
+		fieldBytes += len(value)
+		if fieldBytes > maxEphemeralFieldBytes {
+			h.respondError(c, http.StatusBadRequest, fmt.Sprintf("Form fields exceed %d bytes", maxEphemeralFieldBytes))
+			return nil, "", false
+		}
+		fields.Add(p.FormName(), string(value))
+	}
+	h.respondError(c, http.StatusBadRequest, "No file uploaded")
+	return nil, "", false
+}
+
+// uploadEphemeral processes an upload straight from the request body and
+// returns the department totals inline. Nothing is written to disk: the
+// upload, result file, intake log, department table and schema fingerprints
+// are all left untouched. Options must be sent in the query string or as
+// form fields before the file part. Uploads are never processed unscanned,
+// so ephemeral processing is refused while an upload scanner is set.
+func (h *UploadHandler) uploadEphemeral(c *gin.Context) {
+	log := middleware.RequestLogger(c, h.logger)
+	if h.fileService.Scanning() {
+		h.respondError(c, http.StatusBadRequest, "ephemeral processing is disabled because uploads are scanned before processing")
+		return
+	}
+	part, filename, ok := h.streamFilePart(c)
+	if !ok {
+		return
+	}
+	defer part.Close()
+
+	if ext := strings.ToLower(filepath.Ext(filename)); ext != ".csv" {
+		h.respondError(c, http.StatusBadRequest, fmt.Sprintf("only CSV files can be processed ephemerally, got: %s", ext))
+		return
+	}
+
+	opts, err := h.parseUploadOptions(c)
+	if err != nil {
+		log.Errorf("Invalid upload options: %v", err)
+		c.JSON(http.StatusBadRequest, invalidRequestResponse(err))
+		return
+	}
+	var unsupported fieldErrors
+	for _, option := range []struct {
+		field string
+		set   bool
+	}{
+		{"cleaned", opts.Cleaned},
+		{"report", opts.Report != nil},
+		{"support_record", opts.SupportRecord},
+		{"feed", opts.Feed != ""},
+		{"join_departments", opts.JoinDepartments},
+		{"metadata", opts.Metadata},
+		{"output_format", opts.OutputFormat != services.OutputFormatCSV},
+		{"callback_url", opts.CallbackURL != ""},
+		{"encrypt_to", opts.Encryptor != nil},
+		{"tags", len(opts.Tags) > 0},
+		{"micro_batch", opts.MicroBatch},
+	} {
+		if option.set {
+			unsupported = append(unsupported, models.FieldError{Field: option.field, Error: "cannot be used with ephemeral uploads"})
+		}
+	}
+	if len(unsupported) > 0 {
+		c.JSON(http.StatusBadRequest, invalidRequestResponse(unsupported))
+		return
+	}
+
+	// Sniff the content without consuming it, as nothing is stored to reread
+	content := bufio.NewReaderSize(part, services.ContentSniffBytes)
+	head, err := content.Peek(services.ContentSniffBytes)
+	if err != nil && !errors.Is(err, io.EOF) {
+		if h.respondUploadReadError(c, err) {
+			return
+		}
+		h.respondError(c, http.StatusBadRequest, "Failed to read uploaded file")
+		return
+	}
+	if err := services.CheckStreamContent(filepath.Ext(filename), head); err != nil {
+		log.Warnf("Rejected ephemeral upload %s: %v", filename, err)
+		c.JSON(http.StatusUnsupportedMediaType, models.ErrorResponse{
+			Success:   false,
+			Error:     err.Error(),
+			Code:      http.StatusUnsupportedMediaType,
+			ErrorCode: models.ErrorCodeContentMismatch,
+		})
+		return
+	}
+
+	tenant := middleware.TenantID(c)
+	h.events.Publish(services.Event{Type: services.EventUploadReceived, Tenant: tenant, Filename: filename, Ephemeral: true})
+	h.events.Publish(services.Event{Type: services.EventProcessingStarted, Tenant: tenant, Filename: filename, Ephemeral: true})
+	if opts.Process.Bucket != "" {
+		opts.Process.FiscalCalendar = h.fiscalCalendar(tenant)
+	}
+	result, err := h.csvService.ProcessStream(content, opts.Process)
+	if err != nil {
+		h.events.Publish(services.Event{
+			Type:      services.EventProcessingCompleted,
+			Tenant:    tenant,
+			Filename:  filename,
+			Ephemeral: true,
+			Error:     err.Error(),
+			ErrorType: services.FailureType(err),
+		})
+		if h.respondUploadReadError(c, err) {
+			return
+		}
+		log.Errorf("Failed to process ephemeral upload: %v", err)
+		code := processErrorCode(err)
+		response := models.ErrorResponse{
+			Success: false,
+			Error:   "Failed to process CSV file: " + err.Error(),
+			Code:    code,
+		}
+		var matchErr *services.ColumnMatchError
+		if opts.Process.TraceColumns && errors.As(err, &matchErr) {
+			response.Columns = matchErr.Columns
+		}
+		setSchemaViolations(&response, err)
+		c.JSON(code, response)
+		return
+	}
+
+	if opts.MaxSkippedRatio >= 0 && result.Rows.Total > 0 {
+		ratio := float64(result.Rows.Skipped) / float64(result.Rows.Total)
+		if ratio > opts.MaxSkippedRatio {
+			message := fmt.Sprintf("%d of %d rows were skipped, exceeding max_skipped_ratio %g", result.Rows.Skipped, result.Rows.Total, opts.MaxSkippedRatio)
+			h.events.Publish(services.Event{
+				Type:      services.EventProcessingCompleted,
+				Tenant:    tenant,
+				Filename:  filename,
+				Ephemeral: true,
+				Error:     message,
+				ErrorType: services.FailureSkippedRatio,
+				Rows:      &result.Rows,
+			})
+			c.JSON(http.StatusUnprocessableEntity, models.ErrorResponse{
+				Success: false,
+				Error:   message,
+				Code:    http.StatusUnprocessableEntity,
+				Rows:    &result.Rows,
+			})
+			return
+		}
+	}
+
+	var totalSales models.Amount
+	for _, summary := range result.Summaries {
+		totalSales = totalSales.Add(summary.TotalSales)
+	}
+
+	response := models.UploadResponse{
+		Success:          true,
+		Message:          "CSV file processed successfully",
+		TotalDepartments: len(result.Summaries),
+		TotalSales:       totalSales,
+		ProcessedAt:      time.Now().Format(time.RFC3339),
+		Partial:          result.Rows.Skipped > 0,
+		Rows:             result.Rows,
+		Schema:           result.Schema,
+		Strategy:         result.Strategy,
+		Ephemeral:        true,
+		Departments:      departmentTotals(result.Summaries, opts.Metric),
+		Aggregation:      result.Aggregation,
+		Aggregations:     result.Aggregations,
+		Heatmap:          result.Heatmap,
+		TimeSeries:       result.TimeSeries,
+		Currency:         result.Currency,
+		PII:              result.PII,
+	}
+	if result.Aggregation != nil {
+		services.RoundAggregation(result.Aggregation, opts.NumberFormat)
+	}
+	for _, aggregation := range result.Aggregations {
+		services.RoundAggregation(aggregation, opts.NumberFormat)
+	}
+	if opts.IncludeResults {
+		response.Results, response.ResultsTruncated = inlineResults(result.Summaries, h.maxInlineRows, opts.NestedResults)
+	}
+	if services.MetricIncludesCount(opts.Metric) {
+		response.SalesCount = salesCount(result.Summaries)
+	}
+	if response.Partial {
+		response.Message = fmt.Sprintf("CSV file processed with %d of %d rows skipped", result.Rows.Skipped, result.Rows.Total)
+	}
+	response.Reconciliation = services.Reconcile(totalSales, result.FooterTotal, opts.ExpectedTotal)
+	if response.Reconciliation != nil && !response.Reconciliation.Reconciled {
+		response.Message += "; totals do not reconcile"
+	}
+
+	h.events.Publish(services.Event{
+		Type:             services.EventProcessingCompleted,
+		Tenant:           tenant,
+		Filename:         filename,
+		Ephemeral:        true,
+		Success:          true,
+		TotalSales:       &totalSales,
+		TotalDepartments: len(result.Summaries),
+		Summaries:        result.Summaries,
+		Rows:             &response.Rows,
+	})
+
+	log.Infof("Processed ephemeral upload %s: %d departments", filename, len(result.Summaries))
+	c.JSON(http.StatusOK, response)
+}
+
+func (h *UploadHandler) respondError(c *gin.Context, code int, message string) {
+	c.JSON(code, models.ErrorResponse{
+		Success: false,
+		Error:   message,
+		Code:    code,
+	})
+}
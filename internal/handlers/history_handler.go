@@ -0,0 +1,170 @@
+package handlers
+
+import (
+	"errors"
+	"net/http"
+	"strconv"
+	"strings"
+
+	"github.com/gin-gonic/gin"
+	"github.com/mussietl/csv-sales-api/internal/middleware"
+	"github.com/mussietl/csv-sales-api/internal/models"
+	"github.com/mussietl/csv-sales-api/internal/services"
+	"github.com/sirupsen/logrus"
+)
+
+// Limits on the history records listed per request
+const (
+	defaultHistoryRecords = 50
+	maxHistoryRecords     = 200
+)
+
+// HistoryHandler serves the processing history of a tenant's uploads
+type HistoryHandler struct {
+	history     *services.HistoryRepository
+	fileService *services.FileService
+	logger      *logrus.Logger
+}
+
+// NewHistoryHandler creates a new HistoryHandler instance
+func NewHistoryHandler(history *services.HistoryRepository, fileService *services.FileService, logger *logrus.Logger) *HistoryHandler {
+	return &HistoryHandler{
+		history:     history,
+		fileService: fileService,
+		logger:      logger,
+	}
+}
+
+// ListHistory returns the tenant's uploads, newest first. ?limit= and
+// ?offset= page through them.
+func (h *HistoryHandler) ListHistory(c *gin.Context) {
+	limit, ok := h.queryInt(c, "limit", defaultHistoryRecords, 1, maxHistoryRecords)
+	if !ok {
+		return
+	}
+	offset, ok := h.queryInt(c, "offset", 0, 0, -1)
+	if !ok {
+		return
+	}
+
+	records, err := h.history.List(middleware.TenantID(c), limit, offset)
+	if err != nil {
+		h.logger.Errorf("Failed to list history: %v", err)
+		h.respondError(c, http.StatusInternalServerError, "Failed to load history")
+		return
+	}
+	for i := range records {
+		h.linkResult(&records[i])
+	}
+	c.JSON(http.StatusOK, gin.H{"success": true, "uploads": records, "limit": limit, "offset": offset})
+}
+
+// GetHistory returns the history of one upload. Uploads of other tenants
+// are reported as not found.
+func (h *HistoryHandler) GetHistory(c *gin.Context) {
+	record, err := h.history.Get(middleware.TenantID(c), c.Param("id"))
+	if err != nil {
+		if errors.Is(err, services.ErrHistoryNotFound) {
+			h.respondError(c, http.StatusNotFound, "upload not found")
+			return
+		}
+		h.logger.Errorf("Failed to load history of %s: %v", c.Param("id"), err)
+		h.respondError(c, http.StatusInternalServerError, "Failed to load history")
+		return
+	}
+	h.linkResult(&record)
+	c.JSON(http.StatusOK, gin.H{"success": true, "upload": record})
+}
+
+// SearchResults finds the tenant's completed uploads by ?tag= (repeated or
+// comma-separated; all must match), ?department= (a substring of a
+// department name), ?from= and ?to= (when received) and ?min_total= (of a
+// matching department, or of the upload without department). ?limit= and
+// ?offset= page through the matches, newest first.
+func (h *HistoryHandler) SearchResults(c *gin.Context) {
+	limit, ok := h.queryInt(c, "limit", defaultHistoryRecords, 1, maxHistoryRecords)
+	if !ok {
+		return
+	}
+	offset, ok := h.queryInt(c, "offset", 0, 0, -1)
+	if !ok {
+		return
+	}
+
+	search := services.ResultSearch{Department: strings.TrimSpace(c.Query("department"))}
+	tags, err := services.ParseTags(strings.Join(c.QueryArray("tag"), ","))
+	if err != nil {
+		h.respondError(c, http.StatusBadRequest, err.Error())
+		return
+	}
+	search.Tags = tags
+	if search.From, err = parseTimeBound(c.Query("from"), false); err != nil {
+		h.respondError(c, http.StatusBadRequest, "invalid from: "+err.Error())
+		return
+	}
+	if search.To, err = parseTimeBound(c.Query("to"), true); err != nil {
+		h.respondError(c, http.StatusBadRequest, "invalid to: "+err.Error())
+		return
+	}
+	if value := c.Query("min_total"); value != "" {
+		minTotal, err := models.ParseAmount(value)
+		if err != nil {
+			h.respondError(c, http.StatusBadRequest, "min_total must be a number")
+			return
+		}
+		search.MinTotal = &minTotal
+	}
+
+	matches, more, err := h.history.Search(middleware.TenantID(c), search, limit, offset)
+	if err != nil {
+		h.logger.Errorf("Failed to search results: %v", err)
+		h.respondError(c, http.StatusInternalServerError, "Failed to search results")
+		return
+	}
+	for i := range matches {
+		h.linkResult(&matches[i].HistoryRecord)
+	}
+	c.JSON(http.StatusOK, gin.H{"success": true, "results": matches, "limit": limit, "offset": offset, "has_more": more})
+}
+
+// linkResult sets a fresh download URL while the record's result still exists
+func (h *HistoryHandler) linkResult(record *services.HistoryRecord) {
+	if record.ResultID == "" {
+		return
+	}
+	path, err := h.fileService.ForTenant(record.Tenant).ResultPath(record.ResultID)
+	if err != nil {
+		if !errors.Is(err, services.ErrResultNotFound) {
+			h.logger.Warnf("Failed to look up result %s: %v", record.ResultID, err)
+		}
+		return
+	}
+	record.DownloadURL = h.fileService.GetDownloadURL(path)
+}
+
+// queryInt reads an integer query parameter between min and max (no
+// maximum when max is negative), responding with an error if it is invalid
+func (h *HistoryHandler) queryInt(c *gin.Context, name string, def, min, max int) (int, bool) {
+	value := c.Query(name)
+	if value == "" {
+		return def, true
+	}
+	n, err := strconv.Atoi(value)
+	if err != nil || n < min || (max >= 0 && n > max) {
+		message := name + " must be an integer of at least " + strconv.Itoa(min)
+		if max >= 0 {
+			message = name + " must be an integer between " + strconv.Itoa(min) + " and " + strconv.Itoa(max)
+		}
+		h.respondError(c, http.StatusBadRequest, message)
+		return 0, false
+	}
+	return n, true
+}
+
+func (h *HistoryHandler) respondError(c *gin.Context, code int, message string) {
+	c.JSON(code, models.ErrorResponse{
+		Success: false,
+		Error:   message,
+		Code:    code,
+	})
+}
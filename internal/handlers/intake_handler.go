@@ -0,0 +1,63 @@
+package handlers
+
+import (
+	"errors"
+	"net/http"
+
+	"github.com/gin-gonic/gin"
+	"github.com/mussietl/csv-sales-api/internal/models"
+	"github.com/mussietl/csv-sales-api/internal/services"
+	"github.com/sirupsen/logrus"
+)
+
+// IntakeHandler serves the intake log to administrators
+type IntakeHandler struct {
+	intake *services.IntakeLog
+	retry  services.RetryFunc
+	logger *logrus.Logger
+}
+
+// NewIntakeHandler creates a new IntakeHandler instance; retry reprocesses
+// requeued uploads
+func NewIntakeHandler(intake *services.IntakeLog, retry services.RetryFunc, logger *logrus.Logger) *IntakeHandler {
+	return &IntakeHandler{intake: intake, retry: retry, logger: logger}
+}
+
+// ListIntake returns the latest state of every logged upload, newest first.
+// ?state=processing|completed|failed|deleted filters by state.
+func (h *IntakeHandler) ListIntake(c *gin.Context) {
+	state := c.Query("state")
+	switch state {
+	case "", services.IntakeProcessing, services.IntakeCompleted, services.IntakeFailed, services.IntakeDeleted:
+	default:
+		h.respondError(c, http.StatusBadRequest, "state must be processing, completed, failed or deleted")
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{"success": true, "uploads": h.intake.List(state)})
+}
+
+// RequeueUpload reprocesses a failed upload with the options it was sent with
+func (h *IntakeHandler) RequeueUpload(c *gin.Context) {
+	entry, err := h.intake.Requeue(c.Param("id"), h.retry)
+	switch {
+	case errors.Is(err, services.ErrIntakeNotFound):
+		h.respondError(c, http.StatusNotFound, err.Error())
+	case errors.Is(err, services.ErrIntakeNotFailed), errors.Is(err, services.ErrIntakeEncrypted):
+		h.respondError(c, http.StatusConflict, err.Error())
+	case err != nil && entry.UploadID == "":
+		h.logger.Errorf("Failed to requeue upload %s: %v", c.Param("id"), err)
+		h.respondError(c, http.StatusInternalServerError, err.Error())
+	case err != nil:
+		c.JSON(http.StatusUnprocessableEntity, gin.H{"success": false, "error": err.Error(), "code": http.StatusUnprocessableEntity, "upload": entry})
+	default:
+		c.JSON(http.StatusOK, gin.H{"success": true, "upload": entry})
+	}
+}
+
+func (h *IntakeHandler) respondError(c *gin.Context, code int, message string) {
+	c.JSON(code, models.ErrorResponse{
+		Success: false,
+		Error:   message,
+		Code:    code,
+	})
+}
@@ -0,0 +1,144 @@
+package handlers
+
+import (
+	"errors"
+	"io"
+	"net/http"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/mussietl/csv-sales-api/internal/middleware"
+	"github.com/mussietl/csv-sales-api/internal/models"
+	"github.com/mussietl/csv-sales-api/internal/services"
+	"github.com/sirupsen/logrus"
+)
+
+// progressPollInterval is how often a progress stream checks its job
+const progressPollInterval = 500 * time.Millisecond
+
+// progressKeepAlive is how long a progress stream may go without sending
+// anything before it sends a comment, so proxies don't close it
+const progressKeepAlive = 15 * time.Second
+
+// JobHandler reports the state of background upload jobs and sizes the
+// worker pool running them
+type JobHandler struct {
+	store  *services.JobStore
+	queue  *services.JobQueue
+	logger *logrus.Logger
+}
+
+// NewJobHandler creates a new JobHandler instance
+func NewJobHandler(store *services.JobStore, queue *services.JobQueue, logger *logrus.Logger) *JobHandler {
+	return &JobHandler{
+		store:  store,
+		queue:  queue,
+		logger: logger,
+	}
+}
+
+// GetWorkers returns the size and load of the job queue, for autoscalers
+func (h *JobHandler) GetWorkers(c *gin.Context) {
+	c.JSON(http.StatusOK, gin.H{"success": true, "queue": h.queue.Stats()})
+}
+
+// SetWorkers resizes the worker pool. Removed workers finish their current
+// job first.
+func (h *JobHandler) SetWorkers(c *gin.Context) {
+	var req struct {
+		Workers int `json:"workers" binding:"required,min=1,max=256"`
+	}
+	if err := c.ShouldBindJSON(&req); err != nil {
+		h.respondError(c, http.StatusBadRequest, "workers must be between 1 and 256")
+		return
+	}
+	h.queue.SetWorkers(req.Workers)
+	h.logger.Infof("Job workers set to %d", req.Workers)
+	c.JSON(http.StatusOK, gin.H{"success": true, "queue": h.queue.Stats()})
+}
+
+// GetJob returns a job's state, and its result or error once it has
+// finished. Jobs of other tenants are reported as not found.
+func (h *JobHandler) GetJob(c *gin.Context) {
+	job, err := h.store.Get(c.Param("id"))
+	if err != nil && !errors.Is(err, services.ErrJobNotFound) {
+		h.logger.Errorf("Failed to load job %s: %v", c.Param("id"), err)
+		h.respondError(c, http.StatusInternalServerError, "Failed to load job")
+		return
+	}
+	if err != nil || job.Tenant != middleware.TenantID(c) {
+		h.respondError(c, http.StatusNotFound, "job not found")
+		return
+	}
+	body := gin.H{"success": true, "job": job}
+	respondCached(c, cacheRevalidate, body, body)
+}
+
+// StreamProgress streams a job's progress as server-sent events: a
+// "progress" event whenever it changes, then a "done" or "failed" event with
+// the job once it finishes, after which the stream ends
+func (h *JobHandler) StreamProgress(c *gin.Context) {
+	id := c.Param("id")
+	job, err := h.store.Get(id)
+	if err != nil && !errors.Is(err, services.ErrJobNotFound) {
+		h.logger.Errorf("Failed to load job %s: %v", id, err)
+		h.respondError(c, http.StatusInternalServerError, "Failed to load job")
+		return
+	}
+	tenant := middleware.TenantID(c)
+	if err != nil || job.Tenant != tenant {
+		h.respondError(c, http.StatusNotFound, "job not found")
+		return
+	}
+
+	c.Header("Content-Type", "text/event-stream")
+	c.Header("Cache-Control", "no-cache")
+	c.Header("X-Accel-Buffering", "no")
+	ticker := time.NewTicker(progressPollInterval)
+	defer ticker.Stop()
+	var state string
+	var progress *services.ProcessProgress
+	lastSent := time.Now()
+	c.Stream(func(w io.Writer) bool {
+		if state != "" {
+			select {
+			case <-c.Request.Context().Done():
+				return false
+			case <-ticker.C:
+			}
+			if job, err = h.store.Get(id); err != nil {
+				// The job was pruned while the stream was open
+				c.SSEvent(services.JobFailed, gin.H{"error": "job not found"})
+				return false
+			}
+		}
+
+		switch {
+		case job.State == services.JobDone || job.State == services.JobFailed:
+			c.SSEvent(job.State, job)
+			return false
+		case job.State != state:
+			c.SSEvent("state", gin.H{"state": job.State})
+			state = job.State
+		case job.Progress != progress:
+			c.SSEvent("progress", job.Progress)
+			progress = job.Progress
+		case time.Since(lastSent) >= progressKeepAlive:
+			if _, err := io.WriteString(w, ": keep-alive\n\n"); err != nil {
+				return false
+			}
+		default:
+			return true
+		}
+		lastSent = time.Now()
+		return true
+	})
+}
+
+func (h *JobHandler) respondError(c *gin.Context, code int, message string) {
+	c.JSON(code, models.ErrorResponse{
+		Success: false,
+		Error:   message,
+		Code:    code,
+	})
+}
@@ -0,0 +1,130 @@
+package handlers
+
+import (
+	"errors"
+	"fmt"
+	"net/http"
+	"net/url"
+	"reflect"
+
+	"github.com/gin-gonic/gin"
+	"github.com/mussietl/csv-sales-api/internal/services"
+)
+
+// jobBaseURLParam is the job param holding the base URL of the upload's
+// request, kept apart from the upload options
+const jobBaseURLParam = "_base_url"
+
+// jobParams returns the upload options a request sent, by name, along with
+// its base URL; RedeliverJob rebuilds the job's work from them
+func jobParams(c *gin.Context, upload uploadJob) map[string]string {
+	params := map[string]string{jobBaseURLParam: upload.BaseURL}
+	t := reflect.TypeOf(uploadRequest{})
+	for i := 0; i < t.NumField(); i++ {
+		key := t.Field(i).Tag.Get("form")
+		if value, ok := requestValue(c.Request, key); ok {
+			params[key] = value
+		}
+	}
+	return params
+}
+
+// intakeParams returns the job params of an upload without its encryption
+// options, which are not written to the intake log
+func intakeParams(c *gin.Context, upload uploadJob) map[string]string {
+	params := jobParams(c, upload)
+	delete(params, "encrypt_to")
+	delete(params, "encrypt_passphrase")
+	return params
+}
+
+// beginIntake records an upload in the intake log with its options, so
+// recovery and requeueing process it the way it was sent
+func (h *UploadHandler) beginIntake(c *gin.Context, upload uploadJob) error {
+	return h.intake.BeginEntry(services.IntakeEntry{
+		UploadID:  upload.UploadID,
+		Path:      upload.FilePath,
+		Filename:  upload.Filename,
+		Tenant:    upload.Tenant,
+		Params:    intakeParams(c, upload),
+		Encrypted: upload.Options.Encryptor != nil,
+	})
+}
+
+// RedeliverJob rebuilds the work of an async upload from its job's params,
+// so the job queue can run it again after its worker was lost. If that
+// fails, the upload is marked failed in the intake log.
+func (h *UploadHandler) RedeliverJob(job services.Job) (services.JobFunc, error) {
+	run, err := h.redeliverJob(job)
+	if err != nil {
+		if err := h.intake.Fail(job.UploadID, "could not redeliver job: "+err.Error()); err != nil {
+			h.logger.Errorf("Failed to record outcome of upload %s in intake log: %v", job.UploadID, err)
+		}
+		return nil, err
+	}
+	return run, nil
+}
+
+func (h *UploadHandler) redeliverJob(job services.Job) (services.JobFunc, error) {
+	filePath, err := h.fileService.ForTenant(job.Tenant).UploadPath(job.UploadID)
+	if err != nil {
+		return nil, err
+	}
+	opts, err := h.paramsOptions(job.Params)
+	if err != nil {
+		return nil, err
+	}
+	return h.jobFunc(uploadJob{
+		UploadID: job.UploadID,
+		FilePath: filePath,
+		Filename: job.Filename,
+		Tenant:   job.Tenant,
+		BaseURL:  job.Params[jobBaseURLParam],
+		Options:  opts,
+	}), nil
+}
+
+// RetryUpload processes an upload from the intake log again with the options
+// it was sent with, for startup recovery and requeueing, and returns its
+// result ID. Uploads logged without options are processed with the defaults.
+func (h *UploadHandler) RetryUpload(entry services.IntakeEntry) (string, error) {
+	if entry.Encrypted {
+		return "", services.ErrIntakeEncrypted
+	}
+	opts, err := h.paramsOptions(entry.Params)
+	if err != nil {
+		return "", err
+	}
+	opts.Async = false
+	response, failure := h.processUpload(uploadJob{
+		UploadID: entry.UploadID,
+		FilePath: entry.Path,
+		Filename: entry.Filename,
+		Tenant:   entry.Tenant,
+		BaseURL:  entry.Params[jobBaseURLParam],
+		Options:  opts,
+	})
+	if failure != nil {
+		return "", errors.New(failure.Error)
+	}
+	return response.ResultID, nil
+}
+
+// paramsOptions converts job params back to upload options
+func (h *UploadHandler) paramsOptions(params map[string]string) (uploadOptions, error) {
+	req := &http.Request{URL: &url.URL{}, PostForm: url.Values{}}
+	for key, value := range params {
+		if key != jobBaseURLParam {
+			req.PostForm.Set(key, value)
+		}
+	}
+	var request uploadRequest
+	if err := (formQueryBinding{}).Bind(req, &request); err != nil {
+		return uploadOptions{}, fmt.Errorf("invalid upload options: %w", err)
+	}
+	opts, err := h.uploadOptions(request)
+	if err != nil {
+		return uploadOptions{}, fmt.Errorf("invalid upload options: %w", err)
+	}
+	return opts, nil
+}
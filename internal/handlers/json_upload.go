@@ -0,0 +1,169 @@
+package handlers
+
+import (
+	"bytes"
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+
+	"github.com/gin-gonic/gin"
+	"github.com/mussietl/csv-sales-api/internal/middleware"
+	"github.com/mussietl/csv-sales-api/internal/models"
+	"github.com/mussietl/csv-sales-api/internal/services"
+)
+
+// DefaultJSONUploadMaxBytes is the largest decoded file accepted by the JSON
+// upload endpoint unless configured
+const DefaultJSONUploadMaxBytes = 10 << 20
+
+// SetJSONUploadMaxBytes sets the largest decoded file accepted by the JSON upload endpoint
+func (h *UploadHandler) SetJSONUploadMaxBytes(n int64) {
+	if n > 0 {
+		h.jsonMaxBytes = n
+	}
+}
+
+// jsonUploadRequest is the body of a JSON upload
+type jsonUploadRequest struct {
+	// Filename is the name of the file, whose extension selects how it is read
+	Filename string `json:"filename"`
+	// File is the file's contents, base64-encoded, optionally as a data URL
+	File string `json:"file"`
+	// Options holds the upload and processing parameters by name
+	Options map[string]any `json:"options"`
+}
+
+// UploadJSON accepts a file sent base64-encoded in a JSON body, for clients
+// that can't build multipart requests, and processes it like a multipart
+// upload. Options may be sent in the body's options object or the query string.
+func (h *UploadHandler) UploadJSON(c *gin.Context) {
+	log := middleware.RequestLogger(c, h.logger)
+	h.guardUploadBody(c)
+	ephemeral, err := isEphemeral(c)
+	if err != nil {
+		h.respondError(c, http.StatusBadRequest, err.Error())
+		return
+	}
+	if ephemeral {
+		c.JSON(http.StatusBadRequest, invalidRequestResponse(fieldError("ephemeral", errors.New("cannot be used with JSON uploads"))))
+		return
+	}
+
+	// Base64 takes 4 bytes for every 3, plus room for the filename and options
+	maxBody := base64.StdEncoding.EncodedLen(int(h.jsonMaxBytes)) + 64<<10
+	c.Request.Body = http.MaxBytesReader(c.Writer, c.Request.Body, int64(maxBody))
+	var req jsonUploadRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		if h.respondUploadReadError(c, err) {
+			return
+		}
+		var tooLarge *http.MaxBytesError
+		if errors.As(err, &tooLarge) {
+			h.respondError(c, http.StatusRequestEntityTooLarge, fmt.Sprintf("File exceeds %d bytes", h.jsonMaxBytes))
+			return
+		}
+		h.respondError(c, http.StatusBadRequest, fmt.Sprintf("Expected a JSON upload: %v", err))
+		return
+	}
+
+	var invalid fieldErrors
+	if strings.TrimSpace(req.Filename) == "" {
+		invalid = append(invalid, models.FieldError{Field: "filename", Error: "is required"})
+	}
+	data, err := decodeBase64File(req.File)
+	if err != nil {
+		invalid = append(invalid, models.FieldError{Field: "file", Error: err.Error()})
+	}
+	form, err := jsonOptionValues(req.Options)
+	if err != nil {
+		invalid = append(invalid, models.FieldError{Field: "options", Error: err.Error()})
+	}
+	if len(invalid) > 0 {
+		c.JSON(http.StatusBadRequest, invalidRequestResponse(invalid))
+		return
+	}
+	if int64(len(data)) > h.jsonMaxBytes {
+		h.respondError(c, http.StatusRequestEntityTooLarge, fmt.Sprintf("File exceeds %d bytes", h.jsonMaxBytes))
+		return
+	}
+	if err := services.ValidateFilename(req.Filename); err != nil {
+		h.respondError(c, http.StatusBadRequest, err.Error())
+		return
+	}
+
+	c.Request.PostForm = form
+	opts, err := h.parseUploadOptions(c)
+	if err != nil {
+		log.Errorf("Invalid upload options: %v", err)
+		c.JSON(http.StatusBadRequest, invalidRequestResponse(err))
+		return
+	}
+	h.asyncBySize(&opts, int64(len(data)))
+	if !opts.Async {
+		if h.shedLoad(c) {
+			return
+		}
+		release, ok := h.acquireTenantSlot(c)
+		if !ok {
+			return
+		}
+		defer release()
+	}
+
+	filePath, err := h.fileService.ForTenant(middleware.TenantID(c)).SaveUpload(c.Request.Context(), req.Filename, bytes.NewReader(data))
+	if failure := scanErrorResponse(err); failure != nil {
+		c.JSON(failure.Code, failure)
+		return
+	}
+	if err != nil {
+		log.Errorf("Failed to save uploaded file: %v", err)
+		h.respondError(c, http.StatusInternalServerError, "Failed to save uploaded file")
+		return
+	}
+	h.processSavedUpload(c, filePath, req.Filename, int64(len(data)), opts)
+}
+
+// decodeBase64File decodes a file sent as base64, either bare or as a
+// data URL such as data:text/csv;base64,...
+func decodeBase64File(encoded string) ([]byte, error) {
+	encoded = strings.TrimSpace(encoded)
+	if strings.HasPrefix(encoded, "data:") {
+		comma := strings.IndexByte(encoded, ',')
+		if comma < 0 || !strings.HasSuffix(encoded[:comma], ";base64") {
+			return nil, errors.New("must be a base64 data URL")
+		}
+		encoded = encoded[comma+1:]
+	}
+	if encoded == "" {
+		return nil, errors.New("is required")
+	}
+	data, err := base64.StdEncoding.DecodeString(encoded)
+	if err != nil {
+		return nil, errors.New("must be base64-encoded")
+	}
+	return data, nil
+}
+
+// jsonOptionValues converts the options of a JSON upload to form values;
+// options may be strings, numbers or booleans
+func jsonOptionValues(options map[string]any) (url.Values, error) {
+	form := url.Values{}
+	for name, value := range options {
+		switch v := value.(type) {
+		case string:
+			form.Set(name, v)
+		case bool:
+			form.Set(name, strconv.FormatBool(v))
+		case float64:
+			form.Set(name, strconv.FormatFloat(v, 'f', -1, 64))
+		case nil:
+		default:
+			return nil, fmt.Errorf("%s must be a string, number or boolean", name)
+		}
+	}
+	return form, nil
+}
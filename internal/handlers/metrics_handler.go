@@ -0,0 +1,27 @@
+package handlers
+
+import (
+	"github.com/gin-gonic/gin"
+	"github.com/mussietl/csv-sales-api/internal/services"
+	"github.com/sirupsen/logrus"
+)
+
+// MetricsHandler exposes upload quality metrics to Prometheus
+type MetricsHandler struct {
+	quality *services.QualityMetrics
+	logger  *logrus.Logger
+}
+
+// NewMetricsHandler creates a new MetricsHandler instance
+func NewMetricsHandler(quality *services.QualityMetrics, logger *logrus.Logger) *MetricsHandler {
+	return &MetricsHandler{quality: quality, logger: logger}
+}
+
+// GetMetrics writes the metrics in the Prometheus text exposition format
+func (h *MetricsHandler) GetMetrics(c *gin.Context) {
+	c.Header("Content-Type", "text/plain; version=0.0.4; charset=utf-8")
+	c.Header("Cache-Control", "no-store")
+	if err := h.quality.WritePrometheus(c.Writer); err != nil {
+		h.logger.Warnf("Failed to write metrics: %v", err)
+	}
+}
@@ -0,0 +1,52 @@
+package handlers
+
+import (
+	"net/http"
+
+	"github.com/gin-gonic/gin"
+	"github.com/mussietl/csv-sales-api/internal/middleware"
+	"github.com/mussietl/csv-sales-api/internal/models"
+	"github.com/mussietl/csv-sales-api/internal/services"
+)
+
+// MicroBatchHandler serves a tenant's micro-batch windows
+type MicroBatchHandler struct {
+	batcher *services.MicroBatcher
+}
+
+// NewMicroBatchHandler creates a new MicroBatchHandler instance; batcher is
+// nil when micro-batching is disabled
+func NewMicroBatchHandler(batcher *services.MicroBatcher) *MicroBatchHandler {
+	return &MicroBatchHandler{batcher: batcher}
+}
+
+// ListWindows returns the tenant's open and recently closed windows, newest first
+func (h *MicroBatchHandler) ListWindows(c *gin.Context) {
+	windows := []services.MicroBatch{}
+	if h.batcher != nil {
+		windows = h.batcher.List(middleware.TenantID(c))
+	}
+	c.JSON(http.StatusOK, gin.H{"success": true, "windows": windows})
+}
+
+// GetWindow returns one of the tenant's windows, with its result once closed
+func (h *MicroBatchHandler) GetWindow(c *gin.Context) {
+	if h.batcher == nil {
+		h.notFound(c, services.ErrMicroBatchNotFound.Error())
+		return
+	}
+	window, err := h.batcher.Get(middleware.TenantID(c), c.Param("id"))
+	if err != nil {
+		h.notFound(c, err.Error())
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{"success": true, "window": window})
+}
+
+func (h *MicroBatchHandler) notFound(c *gin.Context, message string) {
+	c.JSON(http.StatusNotFound, models.ErrorResponse{
+		Success: false,
+		Error:   message,
+		Code:    http.StatusNotFound,
+	})
+}
@@ -0,0 +1,75 @@
+package handlers
+
+import (
+	"context"
+	"errors"
+	"io"
+	"os"
+
+	"github.com/mussietl/csv-sales-api/internal/services"
+)
+
+// ProcessObject stores and processes a file from object storage as an
+// upload with the default options, for the bucket notification intake.
+// Files that are rejected or fail to process are logged and recorded in the
+// intake log like uploads; an error means the file could not be stored.
+func (h *UploadHandler) ProcessObject(tenant, filename string, r io.Reader) error {
+	if err := services.ValidateFilename(filename); err != nil {
+		h.logger.Warnf("Skipping object %s: %v", filename, err)
+		return nil
+	}
+	opts, err := h.paramsOptions(nil)
+	if err != nil {
+		return err
+	}
+	filePath, err := h.fileService.ForTenant(tenant).SaveUpload(context.Background(), filename, r)
+	if err != nil {
+		if errors.Is(err, services.ErrUploadInfected) {
+			h.logger.Warnf("Rejected object %s: %v", filename, err)
+			return nil
+		}
+		return err
+	}
+	if failure := h.checkUploadContent(filePath, filename); failure != nil {
+		return nil
+	}
+
+	job := uploadJob{
+		UploadID: h.fileService.UploadID(filePath),
+		FilePath: filePath,
+		Filename: filename,
+		Tenant:   tenant,
+		Options:  opts,
+	}
+	if err := h.intake.BeginEntry(services.IntakeEntry{
+		UploadID: job.UploadID,
+		Path:     job.FilePath,
+		Filename: job.Filename,
+		Tenant:   job.Tenant,
+	}); err != nil {
+		return err
+	}
+	var size int64
+	if info, err := os.Stat(filePath); err == nil {
+		size = info.Size()
+	}
+	h.events.Publish(services.Event{
+		Type:     services.EventUploadReceived,
+		Tenant:   job.Tenant,
+		UploadID: job.UploadID,
+		Filename: filename,
+		Size:     size,
+	})
+
+	response, failure := h.processUpload(job)
+	if failure != nil {
+		h.logger.Warnf("Failed to process object %s: %s", filename, failure.Error)
+		err = h.intake.Fail(job.UploadID, failure.Error)
+	} else {
+		err = h.intake.Complete(job.UploadID, response.ResultID)
+	}
+	if err != nil {
+		h.logger.Errorf("Failed to record outcome of upload %s in intake log: %v", job.UploadID, err)
+	}
+	return nil
+}
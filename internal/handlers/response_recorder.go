@@ -0,0 +1,38 @@
+package handlers
+
+import (
+	"bytes"
+
+	"github.com/gin-gonic/gin"
+)
+
+// responseRecorder tees the first limit bytes of a response body into a buffer
+type responseRecorder struct {
+	gin.ResponseWriter
+	body  bytes.Buffer
+	limit int
+}
+
+// newResponseRecorder wraps w, keeping up to limit bytes of the body
+func newResponseRecorder(w gin.ResponseWriter, limit int) *responseRecorder {
+	return &responseRecorder{ResponseWriter: w, limit: limit}
+}
+
+func (r *responseRecorder) Write(b []byte) (int, error) {
+	r.record(b)
+	return r.ResponseWriter.Write(b)
+}
+
+func (r *responseRecorder) WriteString(s string) (int, error) {
+	r.record([]byte(s))
+	return r.ResponseWriter.WriteString(s)
+}
+
+func (r *responseRecorder) record(b []byte) {
+	if room := r.limit - r.body.Len(); room > 0 {
+		if len(b) > room {
+			b = b[:room]
+		}
+		r.body.Write(b)
+	}
+}
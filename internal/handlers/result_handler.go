@@ -0,0 +1,278 @@
+package handlers
+
+import (
+	"errors"
+	"fmt"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/mussietl/csv-sales-api/internal/middleware"
+	"github.com/mussietl/csv-sales-api/internal/models"
+	"github.com/mussietl/csv-sales-api/internal/services"
+	"github.com/sirupsen/logrus"
+)
+
+// ResultHandler serves result metadata and annotations
+type ResultHandler struct {
+	fileService       *services.FileService
+	annotationService *services.AnnotationService
+	pins              *services.PinStore
+	intake            *services.IntakeLog
+	events            *services.EventBus
+	logger            *logrus.Logger
+}
+
+// NewResultHandler creates a new ResultHandler instance
+func NewResultHandler(fileService *services.FileService, annotationService *services.AnnotationService, pins *services.PinStore, intake *services.IntakeLog, events *services.EventBus, logger *logrus.Logger) *ResultHandler {
+	return &ResultHandler{
+		fileService:       fileService,
+		annotationService: annotationService,
+		pins:              pins,
+		intake:            intake,
+		events:            events,
+		logger:            logger,
+	}
+}
+
+// addAnnotationRequest is the body of an annotation request
+type addAnnotationRequest struct {
+	Note string `json:"note" binding:"required"`
+}
+
+// pinRequest is the optional body of a pin request
+type pinRequest struct {
+	Reason string `json:"reason"`
+}
+
+// GetResult returns a result's metadata and annotations
+func (h *ResultHandler) GetResult(c *gin.Context) {
+	resultID := c.Param("id")
+	path, ok := h.resolveResult(c, resultID)
+	if !ok {
+		return
+	}
+
+	info, err := os.Stat(path)
+	if err != nil {
+		h.logger.Errorf("Failed to stat result %s: %v", resultID, err)
+		h.respondError(c, http.StatusInternalServerError, "Failed to read result")
+		return
+	}
+	annotations, err := h.annotationService.List(resultID)
+	if err != nil {
+		h.logger.Errorf("Failed to load annotations for %s: %v", resultID, err)
+		h.respondError(c, http.StatusInternalServerError, "Failed to load annotations")
+		return
+	}
+
+	metadata := models.ResultMetadata{
+		ResultID:    resultID,
+		DownloadURL: h.fileService.GetDownloadURL(path),
+		CreatedAt:   info.ModTime().UTC().Format(time.RFC3339),
+		Size:        info.Size(),
+		Pinned:      h.pins.IsPinned(resultID),
+		Annotations: annotations,
+	}
+	// Signed download URLs change on every request, so they are left out of the ETag
+	tagged := metadata
+	tagged.DownloadURL = ""
+	respondCached(c, cacheRevalidate, tagged, gin.H{"success": true, "result": metadata})
+}
+
+// ListAnnotations returns the notes attached to a result
+func (h *ResultHandler) ListAnnotations(c *gin.Context) {
+	resultID := c.Param("id")
+	if _, ok := h.resolveResult(c, resultID); !ok {
+		return
+	}
+
+	annotations, err := h.annotationService.List(resultID)
+	if err != nil {
+		h.logger.Errorf("Failed to load annotations for %s: %v", resultID, err)
+		h.respondError(c, http.StatusInternalServerError, "Failed to load annotations")
+		return
+	}
+	body := gin.H{"success": true, "annotations": annotations}
+	respondCached(c, cacheRevalidate, body, body)
+}
+
+// AddAnnotation attaches a note to a result
+func (h *ResultHandler) AddAnnotation(c *gin.Context) {
+	var req addAnnotationRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		h.respondError(c, http.StatusBadRequest, "Invalid request body: "+err.Error())
+		return
+	}
+
+	resultID := c.Param("id")
+	if _, ok := h.resolveResult(c, resultID); !ok {
+		return
+	}
+
+	author := ""
+	if value, ok := c.Get(middleware.ContextAPIKey); ok {
+		author = value.(*services.APIKey).Name
+	}
+
+	annotation, err := h.annotationService.Add(resultID, req.Note, author, middleware.TenantID(c))
+	if err != nil {
+		h.logger.Errorf("Failed to annotate result %s: %v", resultID, err)
+		h.respondError(c, http.StatusBadRequest, err.Error())
+		return
+	}
+	c.JSON(http.StatusCreated, gin.H{"success": true, "annotation": annotation})
+}
+
+// DeleteResult removes a result file and its annotations before the
+// retention janitor would. The upload it was processed from is kept, and
+// pinned results are refused.
+func (h *ResultHandler) DeleteResult(c *gin.Context) {
+	resultID := c.Param("id")
+	if _, ok := h.resolveResult(c, resultID); !ok {
+		return
+	}
+	if h.pins.IsPinned(resultID) {
+		h.respondError(c, http.StatusConflict, services.ErrResultPinned.Error()+"; unpin it first")
+		return
+	}
+
+	path, err := h.fileService.ForTenant(middleware.TenantID(c)).DeleteResult(resultID)
+	if err != nil {
+		h.logger.Errorf("Failed to delete result %s: %v", resultID, err)
+		h.respondError(c, http.StatusInternalServerError, "Failed to delete result")
+		return
+	}
+	if err := h.annotationService.Delete(resultID); err != nil {
+		h.logger.Errorf("Failed to delete annotations of result %s: %v", resultID, err)
+	}
+
+	reason := "deleted through the API"
+	if value, ok := c.Get(middleware.ContextAPIKey); ok {
+		reason = "deleted by " + value.(*services.APIKey).Name
+	}
+	event := services.Event{
+		Type:     services.EventCleanup,
+		Tenant:   middleware.TenantID(c),
+		ResultID: resultID,
+		Paths:    []string{path},
+		Reason:   reason,
+	}
+	if entry, ok := h.intake.EntryByResult(resultID); ok {
+		event.Tenant, event.UploadID, event.Filename = entry.Tenant, entry.UploadID, entry.Filename
+		if err := h.intake.MarkDeleted(entry.UploadID); err != nil {
+			h.logger.Errorf("Failed to record deletion of result %s: %v", resultID, err)
+		}
+	}
+	h.events.Publish(event)
+	c.JSON(http.StatusOK, gin.H{"success": true, "result_id": resultID})
+}
+
+// PinResult exempts a result from retention cleanup, e.g. a year-end report
+func (h *ResultHandler) PinResult(c *gin.Context) {
+	var req pinRequest
+	if c.Request.ContentLength != 0 {
+		if err := c.ShouldBindJSON(&req); err != nil {
+			h.respondError(c, http.StatusBadRequest, "Invalid request body: "+err.Error())
+			return
+		}
+	}
+
+	resultID := c.Param("id")
+	if _, ok := h.resolveResult(c, resultID); !ok {
+		return
+	}
+
+	pinnedBy := ""
+	if value, ok := c.Get(middleware.ContextAPIKey); ok {
+		pinnedBy = value.(*services.APIKey).Name
+	}
+	pin, err := h.pins.Pin(resultID, middleware.TenantID(c), pinnedBy, req.Reason)
+	if err != nil {
+		h.logger.Errorf("Failed to pin result %s: %v", resultID, err)
+		h.respondError(c, http.StatusBadRequest, err.Error())
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{"success": true, "pin": pin})
+}
+
+// UnpinResult makes a result subject to retention cleanup again
+func (h *ResultHandler) UnpinResult(c *gin.Context) {
+	resultID := c.Param("id")
+	if err := h.pins.Unpin(resultID); err != nil {
+		if errors.Is(err, services.ErrPinNotFound) {
+			h.respondError(c, http.StatusNotFound, err.Error())
+			return
+		}
+		h.logger.Errorf("Failed to unpin result %s: %v", resultID, err)
+		h.respondError(c, http.StatusInternalServerError, "Failed to unpin result")
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{"success": true, "result_id": resultID})
+}
+
+// ListPins returns the pinned results, newest first. ?tenant= filters by tenant.
+func (h *ResultHandler) ListPins(c *gin.Context) {
+	c.JSON(http.StatusOK, gin.H{"success": true, "pins": h.pins.List(c.Query("tenant"))})
+}
+
+// DownloadResult serves a result file as an attachment, resuming with
+// range requests like other downloads
+func (h *ResultHandler) DownloadResult(c *gin.Context) {
+	resultID := c.Param("id")
+	path, ok := h.resolveResult(c, resultID)
+	if !ok {
+		return
+	}
+
+	file, err := os.Open(path)
+	if err != nil {
+		h.logger.Errorf("Failed to open result %s: %v", resultID, err)
+		h.respondError(c, http.StatusInternalServerError, "Failed to read result")
+		return
+	}
+	defer file.Close()
+	info, err := file.Stat()
+	if err != nil {
+		h.logger.Errorf("Failed to stat result %s: %v", resultID, err)
+		h.respondError(c, http.StatusInternalServerError, "Failed to read result")
+		return
+	}
+	contentType, ok := downloadContentTypes[strings.ToLower(filepath.Ext(path))]
+	if !ok {
+		contentType = "application/octet-stream"
+	}
+	serveAttachment(c, file, info, filepath.Base(path), contentType)
+}
+
+// resolveResult looks up a result file of the request's tenant, responding
+// with an error if it can't be found. Results of another tenant's uploads
+// are reported as not found.
+func (h *ResultHandler) resolveResult(c *gin.Context, resultID string) (string, bool) {
+	if entry, ok := h.intake.EntryByResult(resultID); ok && entry.Tenant != middleware.TenantID(c) {
+		h.respondError(c, http.StatusNotFound, fmt.Sprintf("%v: %s", services.ErrResultNotFound, resultID))
+		return "", false
+	}
+	path, err := h.fileService.ForTenant(middleware.TenantID(c)).ResultPath(resultID)
+	if err != nil {
+		if errors.Is(err, services.ErrResultNotFound) {
+			h.respondError(c, http.StatusNotFound, err.Error())
+		} else {
+			h.logger.Errorf("Failed to look up result %s: %v", resultID, err)
+			h.respondError(c, http.StatusInternalServerError, "Failed to look up result")
+		}
+		return "", false
+	}
+	return path, true
+}
+
+func (h *ResultHandler) respondError(c *gin.Context, code int, message string) {
+	c.JSON(code, models.ErrorResponse{
+		Success: false,
+		Error:   message,
+		Code:    code,
+	})
+}
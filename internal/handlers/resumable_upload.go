@@ -0,0 +1,182 @@
+package handlers
+
+import (
+	"errors"
+	"fmt"
+	"net/http"
+	"strconv"
+
+	"github.com/gin-gonic/gin"
+	"github.com/mussietl/csv-sales-api/internal/middleware"
+	"github.com/mussietl/csv-sales-api/internal/services"
+)
+
+// UploadOffsetHeader carries the offset of a resumable upload: where a
+// chunk starts in requests, and how many bytes were received in responses
+const UploadOffsetHeader = "Upload-Offset"
+
+// createUploadSessionRequest is the body of POST /uploads
+type createUploadSessionRequest struct {
+	Filename string `json:"filename" binding:"required"`
+	Size     int64  `json:"size" binding:"required,min=1"`
+}
+
+// CreateUploadSession starts a resumable upload whose content is then sent
+// in chunks with PATCH
+func (h *UploadHandler) CreateUploadSession(c *gin.Context) {
+	log := middleware.RequestLogger(c, h.logger)
+	var req createUploadSessionRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		h.respondError(c, http.StatusBadRequest, "Invalid request: "+err.Error())
+		return
+	}
+	if err := services.ValidateFilename(req.Filename); err != nil {
+		h.respondError(c, http.StatusBadRequest, err.Error())
+		return
+	}
+	if h.maxUploadBytes > 0 && req.Size > h.maxUploadBytes {
+		h.respondError(c, http.StatusRequestEntityTooLarge, fmt.Sprintf("Upload exceeds %d bytes", h.maxUploadBytes))
+		return
+	}
+	// The session's storage is reserved at its declared size
+	if h.quotas != nil && !middleware.CheckTenantQuota(c, h.quotas, req.Size, h.logger) {
+		return
+	}
+
+	session, err := h.fileService.ForTenant(middleware.TenantID(c)).CreateUploadSession(req.Filename, req.Size)
+	if err != nil {
+		log.Errorf("Failed to create upload session: %v", err)
+		h.respondError(c, http.StatusInternalServerError, "Failed to create upload session")
+		return
+	}
+	c.Header("Location", "/api/v1/uploads/"+session.ID)
+	c.Header(UploadOffsetHeader, "0")
+	c.JSON(http.StatusCreated, gin.H{"success": true, "session": session})
+}
+
+// GetUploadSession reports how much of a resumable upload was received
+func (h *UploadHandler) GetUploadSession(c *gin.Context) {
+	session, err := h.fileService.ForTenant(middleware.TenantID(c)).UploadSession(c.Param("id"))
+	if err != nil {
+		h.respondSessionError(c, err)
+		return
+	}
+	c.Header(UploadOffsetHeader, strconv.FormatInt(session.Offset, 10))
+	c.Header("Cache-Control", "no-store")
+	c.JSON(http.StatusOK, gin.H{"success": true, "session": session})
+}
+
+// UploadChunk appends the request body to a resumable upload at the offset
+// of the Upload-Offset header, which must match the bytes received so far
+func (h *UploadHandler) UploadChunk(c *gin.Context) {
+	log := middleware.RequestLogger(c, h.logger)
+	offset, err := strconv.ParseInt(c.GetHeader(UploadOffsetHeader), 10, 64)
+	if err != nil || offset < 0 {
+		h.respondError(c, http.StatusBadRequest, UploadOffsetHeader+" header must be a non-negative integer")
+		return
+	}
+	files := h.fileService.ForTenant(middleware.TenantID(c))
+	session, err := files.UploadSession(c.Param("id"))
+	if err != nil {
+		h.respondSessionError(c, err)
+		return
+	}
+	if c.Request.ContentLength > session.Size-offset {
+		h.respondError(c, http.StatusRequestEntityTooLarge, fmt.Sprintf("Chunk runs past the declared size of %d bytes", session.Size))
+		return
+	}
+
+	h.guardUploadBody(c)
+	session, err = files.WriteUploadChunk(c.Request.Context(), session.ID, offset, c.Request.Body)
+	if session != nil {
+		c.Header(UploadOffsetHeader, strconv.FormatInt(session.Offset, 10))
+	}
+	if err != nil {
+		if h.respondUploadReadError(c, err) {
+			return
+		}
+		if errors.Is(err, services.ErrUploadOffsetMismatch) || errors.Is(err, services.ErrUploadSessionNotFound) || errors.Is(err, services.ErrUploadSessionBusy) {
+			h.respondSessionError(c, err)
+			return
+		}
+		log.Errorf("Failed to write chunk of upload session %s: %v", c.Param("id"), err)
+		h.respondError(c, http.StatusInternalServerError, "Failed to write chunk")
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{"success": true, "session": session})
+}
+
+// CommitUploadSession processes a complete resumable upload like an upload
+// to /upload, taking the processing options from the query string or form
+func (h *UploadHandler) CommitUploadSession(c *gin.Context) {
+	log := middleware.RequestLogger(c, h.logger)
+	opts, err := h.parseUploadOptions(c)
+	if err != nil {
+		log.Errorf("Invalid upload options: %v", err)
+		c.JSON(http.StatusBadRequest, invalidRequestResponse(err))
+		return
+	}
+	if session, err := h.fileService.ForTenant(middleware.TenantID(c)).UploadSession(c.Param("id")); err == nil {
+		h.asyncBySize(&opts, session.Size)
+	}
+	if !opts.Async {
+		if h.shedLoad(c) {
+			return
+		}
+		release, ok := h.acquireTenantSlot(c)
+		if !ok {
+			return
+		}
+		defer release()
+	}
+	// The session already counts against storage; checking again catches
+	// quotas lowered and rows processed since it was created
+	if h.quotas != nil && !middleware.CheckTenantQuota(c, h.quotas, 0, h.logger) {
+		return
+	}
+
+	filePath, session, err := h.fileService.ForTenant(middleware.TenantID(c)).CommitUploadSession(c.Request.Context(), c.Param("id"))
+	if err != nil {
+		if failure := scanErrorResponse(err); failure != nil {
+			c.JSON(failure.Code, failure)
+			return
+		}
+		if errors.Is(err, services.ErrUploadIncomplete) || errors.Is(err, services.ErrUploadSessionNotFound) || errors.Is(err, services.ErrUploadSessionBusy) {
+			if session != nil {
+				c.Header(UploadOffsetHeader, strconv.FormatInt(session.Offset, 10))
+			}
+			h.respondSessionError(c, err)
+			return
+		}
+		log.Errorf("Failed to commit upload session %s: %v", c.Param("id"), err)
+		h.respondError(c, http.StatusInternalServerError, "Failed to save uploaded file")
+		return
+	}
+
+	h.processSavedUpload(c, filePath, session.Filename, session.Size, opts)
+}
+
+// DeleteUploadSession abandons a resumable upload
+func (h *UploadHandler) DeleteUploadSession(c *gin.Context) {
+	if err := h.fileService.ForTenant(middleware.TenantID(c)).DeleteUploadSession(c.Param("id")); err != nil {
+		h.respondSessionError(c, err)
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{"success": true, "message": "Upload session deleted"})
+}
+
+// respondSessionError responds to a request for a session that doesn't
+// exist or isn't in a state to handle it
+func (h *UploadHandler) respondSessionError(c *gin.Context, err error) {
+	switch {
+	case errors.Is(err, services.ErrUploadSessionNotFound):
+		h.respondError(c, http.StatusNotFound, "Upload session not found")
+	case errors.Is(err, services.ErrUploadSessionBusy):
+		h.respondError(c, http.StatusConflict, "Another request is writing to this upload session")
+	case errors.Is(err, services.ErrUploadOffsetMismatch), errors.Is(err, services.ErrUploadIncomplete):
+		h.respondError(c, http.StatusConflict, err.Error())
+	default:
+		h.logger.Errorf("Failed to read upload session %s: %v", c.Param("id"), err)
+		h.respondError(c, http.StatusInternalServerError, "Failed to read upload session")
+	}
+}
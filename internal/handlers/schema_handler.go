@@ -0,0 +1,35 @@
+package handlers
+
+import (
+	"net/http"
+
+	"github.com/gin-gonic/gin"
+	"github.com/mussietl/csv-sales-api/internal/models"
+	"github.com/mussietl/csv-sales-api/internal/services"
+)
+
+// cacheSchema lets shared caches keep a schema for a day; a schema only
+// changes with a new release
+const cacheSchema = "public, max-age=86400"
+
+// SchemaHandler serves the JSON Schemas of documented output formats
+type SchemaHandler struct{}
+
+// NewSchemaHandler creates a new SchemaHandler instance
+func NewSchemaHandler() *SchemaHandler {
+	return &SchemaHandler{}
+}
+
+// GetSchema returns the JSON Schema of the output format named in the path
+func (h *SchemaHandler) GetSchema(c *gin.Context) {
+	schema, ok := services.OutputSchema(c.Param("format"))
+	if !ok {
+		c.JSON(http.StatusNotFound, models.ErrorResponse{
+			Success: false,
+			Error:   "No schema for output format " + c.Param("format"),
+			Code:    http.StatusNotFound,
+		})
+		return
+	}
+	respondCached(c, cacheSchema, schema, schema)
+}
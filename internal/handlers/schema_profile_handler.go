@@ -0,0 +1,46 @@
+package handlers
+
+import (
+	"net/http"
+
+	"github.com/gin-gonic/gin"
+	"github.com/mussietl/csv-sales-api/internal/models"
+	"github.com/mussietl/csv-sales-api/internal/services"
+)
+
+// SchemaProfileHandler serves the schema profiles uploads can be validated against
+type SchemaProfileHandler struct {
+	schemas *services.SchemaRegistry
+}
+
+// NewSchemaProfileHandler creates a new SchemaProfileHandler instance;
+// schemas is nil when no profiles are configured
+func NewSchemaProfileHandler(schemas *services.SchemaRegistry) *SchemaProfileHandler {
+	return &SchemaProfileHandler{schemas: schemas}
+}
+
+// ListProfiles returns every schema profile, sorted by name
+func (h *SchemaProfileHandler) ListProfiles(c *gin.Context) {
+	profiles := []*services.SchemaProfile{}
+	if h.schemas != nil {
+		profiles = h.schemas.List()
+	}
+	c.JSON(http.StatusOK, gin.H{"success": true, "profiles": profiles})
+}
+
+// GetProfile returns one schema profile by name
+func (h *SchemaProfileHandler) GetProfile(c *gin.Context) {
+	var profile *services.SchemaProfile
+	if h.schemas != nil {
+		profile, _ = h.schemas.Get(c.Param("name"))
+	}
+	if profile == nil {
+		c.JSON(http.StatusNotFound, models.ErrorResponse{
+			Success: false,
+			Error:   "schema profile not found",
+			Code:    http.StatusNotFound,
+		})
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{"success": true, "profile": profile})
+}
@@ -0,0 +1,23 @@
+package handlers
+
+import (
+	"net/http"
+
+	"github.com/gin-gonic/gin"
+	"github.com/mussietl/csv-sales-api/internal/services"
+)
+
+// SLOHandler reports service level objective compliance
+type SLOHandler struct {
+	tracker *services.SLOTracker
+}
+
+// NewSLOHandler creates a new SLOHandler instance
+func NewSLOHandler(tracker *services.SLOTracker) *SLOHandler {
+	return &SLOHandler{tracker: tracker}
+}
+
+// GetSLO returns success rates, latency percentiles and burn rates per rolling window
+func (h *SLOHandler) GetSLO(c *gin.Context) {
+	c.JSON(http.StatusOK, gin.H{"success": true, "slo": h.tracker.Summary()})
+}
@@ -0,0 +1,122 @@
+package handlers
+
+import (
+	"encoding/json"
+	"errors"
+	"net/http"
+	"strings"
+
+	"github.com/gin-gonic/gin"
+	"github.com/mussietl/csv-sales-api/internal/middleware"
+	"github.com/mussietl/csv-sales-api/internal/models"
+	"github.com/mussietl/csv-sales-api/internal/services"
+	"github.com/sirupsen/logrus"
+)
+
+// SupportBundleHeader carries the ID of the support bundle recorded for a request
+const SupportBundleHeader = "X-Support-Bundle-ID"
+
+// maxRecordedResponseBytes bounds the response body kept in a support bundle
+const maxRecordedResponseBytes = 64 << 10
+
+// redactedHeaders are request headers never written to support bundles
+var redactedHeaders = map[string]bool{
+	"Authorization": true,
+	"X-Api-Key":     true,
+	"Cookie":        true,
+}
+
+// SupportHandler serves recorded support bundles to admins
+type SupportHandler struct {
+	supportService *services.SupportService
+	logger         *logrus.Logger
+}
+
+// NewSupportHandler creates a new SupportHandler instance
+func NewSupportHandler(supportService *services.SupportService, logger *logrus.Logger) *SupportHandler {
+	return &SupportHandler{
+		supportService: supportService,
+		logger:         logger,
+	}
+}
+
+// ListBundles lists recorded support bundles, newest first
+func (h *SupportHandler) ListBundles(c *gin.Context) {
+	bundles, err := h.supportService.List()
+	if err != nil {
+		h.logger.Errorf("Failed to list support bundles: %v", err)
+		c.JSON(http.StatusInternalServerError, models.ErrorResponse{
+			Success: false,
+			Error:   "Failed to list support bundles",
+			Code:    http.StatusInternalServerError,
+		})
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{"success": true, "bundles": bundles})
+}
+
+// GetBundle returns a single support bundle
+func (h *SupportHandler) GetBundle(c *gin.Context) {
+	bundle, err := h.supportService.Get(c.Param("id"))
+	if err != nil {
+		code := http.StatusInternalServerError
+		if errors.Is(err, services.ErrBundleNotFound) {
+			code = http.StatusNotFound
+		}
+		c.JSON(code, models.ErrorResponse{
+			Success: false,
+			Error:   err.Error(),
+			Code:    code,
+		})
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{"success": true, "bundle": bundle})
+}
+
+// recordSupportBundle writes a support bundle for a finished upload request
+func recordSupportBundle(c *gin.Context, supportService *services.SupportService, id, filename string, size int64, filePath, uploadID string, recorder *responseRecorder, logger *logrus.Logger) {
+	bundle := &services.SupportBundle{
+		ID:             id,
+		Tenant:         middleware.TenantID(c),
+		UploadID:       uploadID,
+		Filename:       filename,
+		Size:           size,
+		Params:         make(map[string]string),
+		Headers:        make(map[string]string),
+		ResponseStatus: recorder.Status(),
+	}
+
+	for key, values := range c.Request.URL.Query() {
+		bundle.Params[key] = strings.Join(values, ",")
+	}
+	if form := c.Request.MultipartForm; form != nil {
+		for key, values := range form.Value {
+			bundle.Params[key] = strings.Join(values, ",")
+		}
+	}
+	for key, values := range c.Request.Header {
+		if redactedHeaders[key] {
+			bundle.Headers[key] = "[redacted]"
+			continue
+		}
+		bundle.Headers[key] = strings.Join(values, ",")
+	}
+
+	// Workbooks and compressed files are binary, so only plain CSV uploads are sampled
+	if !services.IsXLSX(filePath) && !services.IsCompressed(filePath) {
+		sample, rows, err := supportService.Sample(filePath)
+		if err != nil {
+			logger.Warnf("Failed to sample upload for support bundle: %v", err)
+		}
+		bundle.Sample = sample
+		bundle.SampleRows = rows
+	}
+
+	if body := recorder.body.Bytes(); json.Valid(body) {
+		bundle.Response = json.RawMessage(body)
+	}
+
+	if err := supportService.Save(bundle); err != nil {
+		logger.Errorf("Failed to save support bundle: %v", err)
+	}
+}
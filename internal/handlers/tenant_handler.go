@@ -0,0 +1,208 @@
+package handlers
+
+import (
+	"net/http"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/mussietl/csv-sales-api/internal/middleware"
+	"github.com/mussietl/csv-sales-api/internal/models"
+	"github.com/mussietl/csv-sales-api/internal/services"
+	"github.com/sirupsen/logrus"
+)
+
+// TenantHandler handles tenant administration requests
+type TenantHandler struct {
+	limiter   *services.TenantLimiter
+	calendars *services.FiscalCalendarStore
+	quotas    *services.TenantQuotas
+	logger    *logrus.Logger
+}
+
+// NewTenantHandler creates a new TenantHandler instance
+func NewTenantHandler(limiter *services.TenantLimiter, calendars *services.FiscalCalendarStore, quotas *services.TenantQuotas, logger *logrus.Logger) *TenantHandler {
+	return &TenantHandler{
+		limiter:   limiter,
+		calendars: calendars,
+		quotas:    quotas,
+		logger:    logger,
+	}
+}
+
+// setConcurrencyRequest is the body of a concurrency limit update
+type setConcurrencyRequest struct {
+	Limit *int `json:"limit" binding:"required"`
+}
+
+// ListConcurrency returns the default cap and every tenant with an override or active jobs
+func (h *TenantHandler) ListConcurrency(c *gin.Context) {
+	c.JSON(http.StatusOK, gin.H{
+		"success":       true,
+		"default_limit": h.limiter.DefaultLimit(),
+		"tenants":       h.limiter.List(),
+	})
+}
+
+// GetConcurrency returns a single tenant's cap and usage
+func (h *TenantHandler) GetConcurrency(c *gin.Context) {
+	c.JSON(http.StatusOK, gin.H{"success": true, "tenant": h.limiter.Get(c.Param("tenant"))})
+}
+
+// SetConcurrency overrides a tenant's concurrency cap
+func (h *TenantHandler) SetConcurrency(c *gin.Context) {
+	var req setConcurrencyRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		h.respondError(c, http.StatusBadRequest, "Invalid request body: "+err.Error())
+		return
+	}
+
+	tenant := c.Param("tenant")
+	if err := h.limiter.SetLimit(tenant, *req.Limit); err != nil {
+		h.logger.Errorf("Failed to set concurrency limit for %s: %v", tenant, err)
+		h.respondError(c, http.StatusBadRequest, err.Error())
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{"success": true, "tenant": h.limiter.Get(tenant)})
+}
+
+// ResetConcurrency removes a tenant's override
+func (h *TenantHandler) ResetConcurrency(c *gin.Context) {
+	tenant := c.Param("tenant")
+	if err := h.limiter.ResetLimit(tenant); err != nil {
+		h.logger.Errorf("Failed to reset concurrency limit for %s: %v", tenant, err)
+		h.respondError(c, http.StatusInternalServerError, "Failed to reset concurrency limit")
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{"success": true, "tenant": h.limiter.Get(tenant)})
+}
+
+// GetFiscalCalendar returns a tenant's fiscal calendar. With ?date=YYYY-MM-DD
+// it also returns the fiscal period containing that date.
+func (h *TenantHandler) GetFiscalCalendar(c *gin.Context) {
+	calendar, override := h.calendars.Get(c.Param("tenant"))
+	response := gin.H{"success": true, "tenant": c.Param("tenant"), "calendar": calendar, "override": override}
+
+	if value := c.Query("date"); value != "" {
+		date, err := time.Parse("2006-01-02", value)
+		if err != nil {
+			h.respondError(c, http.StatusBadRequest, "Invalid date: expected YYYY-MM-DD")
+			return
+		}
+		period := calendar.PeriodOf(date)
+		response["period"] = period
+		response["quarter_label"] = period.QuarterLabel()
+	}
+
+	c.JSON(http.StatusOK, response)
+}
+
+// SetFiscalCalendar overrides a tenant's fiscal calendar
+func (h *TenantHandler) SetFiscalCalendar(c *gin.Context) {
+	calendar := services.DefaultFiscalCalendar
+	if err := c.ShouldBindJSON(&calendar); err != nil {
+		h.respondError(c, http.StatusBadRequest, "Invalid request body: "+err.Error())
+		return
+	}
+
+	tenant := c.Param("tenant")
+	if err := h.calendars.Set(tenant, calendar); err != nil {
+		h.logger.Errorf("Failed to set fiscal calendar for %s: %v", tenant, err)
+		h.respondError(c, http.StatusBadRequest, err.Error())
+		return
+	}
+
+	calendar, _ = h.calendars.Get(tenant)
+	c.JSON(http.StatusOK, gin.H{"success": true, "tenant": tenant, "calendar": calendar, "override": true})
+}
+
+// ResetFiscalCalendar removes a tenant's fiscal calendar override
+func (h *TenantHandler) ResetFiscalCalendar(c *gin.Context) {
+	tenant := c.Param("tenant")
+	if err := h.calendars.Reset(tenant); err != nil {
+		h.logger.Errorf("Failed to reset fiscal calendar for %s: %v", tenant, err)
+		h.respondError(c, http.StatusInternalServerError, "Failed to reset fiscal calendar")
+		return
+	}
+
+	calendar, _ := h.calendars.Get(tenant)
+	c.JSON(http.StatusOK, gin.H{"success": true, "tenant": tenant, "calendar": calendar, "override": false})
+}
+
+// GetOwnQuota returns the quotas and usage of the request's tenant
+func (h *TenantHandler) GetOwnQuota(c *gin.Context) {
+	h.respondQuota(c, middleware.TenantID(c))
+}
+
+// GetQuota returns a tenant's quotas and usage
+func (h *TenantHandler) GetQuota(c *gin.Context) {
+	tenant, ok := h.tenantParam(c)
+	if !ok {
+		return
+	}
+	h.respondQuota(c, tenant)
+}
+
+// SetQuota overrides a tenant's quotas
+func (h *TenantHandler) SetQuota(c *gin.Context) {
+	tenant, ok := h.tenantParam(c)
+	if !ok {
+		return
+	}
+	quota := h.quotas.Defaults()
+	if err := c.ShouldBindJSON(&quota); err != nil {
+		h.respondError(c, http.StatusBadRequest, "Invalid request body: "+err.Error())
+		return
+	}
+	if err := h.quotas.SetQuota(tenant, quota); err != nil {
+		h.logger.Errorf("Failed to set quotas for %s: %v", tenant, err)
+		h.respondError(c, http.StatusBadRequest, err.Error())
+		return
+	}
+	h.respondQuota(c, tenant)
+}
+
+// ResetQuota removes a tenant's quota override
+func (h *TenantHandler) ResetQuota(c *gin.Context) {
+	tenant, ok := h.tenantParam(c)
+	if !ok {
+		return
+	}
+	if err := h.quotas.ResetQuota(tenant); err != nil {
+		h.logger.Errorf("Failed to reset quotas for %s: %v", tenant, err)
+		h.respondError(c, http.StatusInternalServerError, "Failed to reset quotas")
+		return
+	}
+	h.respondQuota(c, tenant)
+}
+
+// respondQuota answers with a tenant's quotas and usage
+func (h *TenantHandler) respondQuota(c *gin.Context, tenant string) {
+	status, err := h.quotas.Get(tenant)
+	if err != nil {
+		h.logger.Errorf("Failed to read quotas of %s: %v", tenant, err)
+		h.respondError(c, http.StatusInternalServerError, "Failed to read quota usage")
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{"success": true, "quota": status})
+}
+
+// tenantParam reads the tenant of the path, which names its files'
+// directory, responding with 400 if it isn't a valid tenant ID
+func (h *TenantHandler) tenantParam(c *gin.Context) (string, bool) {
+	tenant := c.Param("tenant")
+	if !services.ValidTenantID(tenant) {
+		h.respondError(c, http.StatusBadRequest, "invalid tenant: must be 1-64 letters, digits, '.', '_' or '-'")
+		return "", false
+	}
+	return tenant, true
+}
+
+func (h *TenantHandler) respondError(c *gin.Context, code int, message string) {
+	c.JSON(code, models.ErrorResponse{
+		Success: false,
+		Error:   message,
+		Code:    code,
+	})
+}
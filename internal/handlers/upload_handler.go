@@ -1,40 +1,266 @@
 package handlers
 
 import (
+	"errors"
+	"fmt"
+	"mime/multipart"
 	"net/http"
+	"os"
+	"sort"
+	"strconv"
+	"strings"
 	"time"
 
 	"github.com/gin-gonic/gin"
+	"github.com/google/uuid"
+	"github.com/mussietl/csv-sales-api/internal/middleware"
 	"github.com/mussietl/csv-sales-api/internal/models"
 	"github.com/mussietl/csv-sales-api/internal/services"
+	"github.com/mussietl/csv-sales-api/pkg/utils"
 	"github.com/sirupsen/logrus"
 )
 
 // UploadHandler handles file upload requests
 type UploadHandler struct {
-	fileService *services.FileService
-	csvService  *services.CSVService
-	logger      *logrus.Logger
+	fileService    *services.FileService
+	csvService     *services.CSVService
+	events         *services.EventBus
+	reportService  *services.ReportService
+	supportService *services.SupportService
+	driftService   *services.SchemaDriftService
+	departments    *services.DepartmentStore
+	trends         *services.TrendStore
+	intake         *services.IntakeLog
+	jobs           *services.JobQueue
+	callbacks      *services.CallbackService
+	shedder        *services.LoadShedder
+	schemas        *services.SchemaRegistry
+	microBatches   *services.MicroBatcher
+	quotas         *services.TenantQuotas
+	limiter        *services.TenantLimiter
+	calendars      *services.FiscalCalendarStore
+	asyncDefault   bool
+	piiMode        string
+	fileFields     []string
+	batchMaxFiles  int
+	maxInlineRows  int
+	uploadDeadline time.Duration
+	maxUploadBytes int64
+	jsonMaxBytes   int64
+	logger         *logrus.Logger
 }
 
+// defaultFileFields are the multipart field names searched for the uploaded file, in order
+var defaultFileFields = []string{"file", "csv", "data", "upload"}
+
 // NewUploadHandler creates a new UploadHandler instance
-func NewUploadHandler(fileService *services.FileService, csvService *services.CSVService, logger *logrus.Logger) *UploadHandler {
+func NewUploadHandler(fileService *services.FileService, csvService *services.CSVService, events *services.EventBus, reportService *services.ReportService, supportService *services.SupportService, driftService *services.SchemaDriftService, departments *services.DepartmentStore, trends *services.TrendStore, intake *services.IntakeLog, jobs *services.JobQueue, logger *logrus.Logger) *UploadHandler {
 	return &UploadHandler{
-		fileService: fileService,
-		csvService:  csvService,
-		logger:      logger,
+		fileService:    fileService,
+		csvService:     csvService,
+		events:         events,
+		reportService:  reportService,
+		supportService: supportService,
+		driftService:   driftService,
+		departments:    departments,
+		trends:         trends,
+		intake:         intake,
+		jobs:           jobs,
+		fileFields:     defaultFileFields,
+		batchMaxFiles:  DefaultBatchMaxFiles,
+		maxInlineRows:  DefaultMaxInlineResults,
+		jsonMaxBytes:   DefaultJSONUploadMaxBytes,
+		logger:         logger,
+	}
+}
+
+// SetFileFields overrides the multipart field names searched for the uploaded file
+func (h *UploadHandler) SetFileFields(fields []string) {
+	if len(fields) > 0 {
+		h.fileFields = fields
+	}
+}
+
+// SetMaxInlineResults sets the number of department totals returned inline
+// with include_results; further rows are only in the result file
+func (h *UploadHandler) SetMaxInlineResults(n int) {
+	if n > 0 {
+		h.maxInlineRows = n
+	}
+}
+
+// SetLoadShedder turns away synchronous uploads while the shedder reports
+// memory pressure; async uploads are still accepted
+func (h *UploadHandler) SetLoadShedder(shedder *services.LoadShedder) {
+	h.shedder = shedder
+}
+
+// SetTenantLimiter counts synchronous uploads against their tenant's
+// concurrency cap. Async uploads take no slot until their job runs, since
+// the job queue holds them back under the same limiter.
+func (h *UploadHandler) SetTenantLimiter(limiter *services.TenantLimiter) {
+	h.limiter = limiter
+}
+
+// SetFiscalCalendars labels time series buckets with the fiscal periods of
+// the upload's tenant
+func (h *UploadHandler) SetFiscalCalendars(calendars *services.FiscalCalendarStore) {
+	h.calendars = calendars
+}
+
+// fiscalCalendar returns the calendar time series of a tenant's uploads are
+// labelled with, or nil without a store
+func (h *UploadHandler) fiscalCalendar(tenant string) *services.FiscalCalendar {
+	if h.calendars == nil {
+		return nil
 	}
+	calendar, _ := h.calendars.Get(tenant)
+	return &calendar
+}
+
+// SetCallbackService lets uploads name a callback_url that is sent the
+// outcome once processing finishes
+func (h *UploadHandler) SetCallbackService(callbacks *services.CallbackService) {
+	h.callbacks = callbacks
+}
+
+// SetSchemaProfiles lets uploads name a schema profile to be validated against
+func (h *UploadHandler) SetSchemaProfiles(schemas *services.SchemaRegistry) {
+	h.schemas = schemas
+}
+
+// SetMicroBatcher enables the micro_batch option, which adds uploads to
+// windows combined into one result when they close
+func (h *UploadHandler) SetMicroBatcher(batcher *services.MicroBatcher) {
+	h.microBatches = batcher
+}
+
+// SetAsyncDefault makes uploads processed in the background unless a request
+// sends async=false
+func (h *UploadHandler) SetAsyncDefault(async bool) {
+	h.asyncDefault = async
+}
+
+// SetPIIMode sets how personal data found outside the department and sales
+// columns is handled: one of the services.PIIMode values
+func (h *UploadHandler) SetPIIMode(mode string) {
+	h.piiMode = mode
+}
+
+// SetUploadDeadline limits the time a client has to send the request body of
+// an upload; zero leaves it unlimited
+func (h *UploadHandler) SetUploadDeadline(d time.Duration) {
+	h.uploadDeadline = d
+}
+
+// SetTenantQuotas checks resumable uploads against tenant quotas at their
+// declared size
+func (h *UploadHandler) SetTenantQuotas(quotas *services.TenantQuotas) {
+	h.quotas = quotas
+}
+
+// SetMaxUploadBytes limits the size of an upload's request body; zero leaves
+// it unlimited
+func (h *UploadHandler) SetMaxUploadBytes(n int64) {
+	h.maxUploadBytes = n
+}
+
+// guardUploadBody applies the upload deadline and size limit to the request
+// and wraps its body so a client that disconnects or runs out of time
+// mid-upload is reported as such instead of as a malformed file
+func (h *UploadHandler) guardUploadBody(c *gin.Context) {
+	log := middleware.RequestLogger(c, h.logger)
+	if h.uploadDeadline > 0 {
+		err := http.NewResponseController(c.Writer).SetReadDeadline(time.Now().Add(h.uploadDeadline))
+		if err != nil {
+			log.Warnf("Failed to set upload deadline: %v", err)
+		}
+	}
+	if h.maxUploadBytes > 0 {
+		c.Request.Body = http.MaxBytesReader(c.Writer, c.Request.Body, h.maxUploadBytes)
+	}
+	c.Request.Body = services.NewUploadBody(c.Request.Context(), c.Request.Body)
+}
+
+// shedLoad responds with 503 to a synchronous upload while the server is
+// under memory pressure, reporting whether it did
+func (h *UploadHandler) shedLoad(c *gin.Context) bool {
+	log := middleware.RequestLogger(c, h.logger)
+	overloaded, inUse := h.shedder.Overloaded()
+	if !overloaded {
+		return false
+	}
+	log.Warnf("Shedding synchronous upload: %d MB in use, threshold %d MB", inUse>>20, h.shedder.Threshold()>>20)
+	c.Header("Retry-After", strconv.Itoa(int(services.LoadShedRetryAfter.Seconds())))
+	h.respondError(c, http.StatusServiceUnavailable, "Server is under memory pressure; retry later or upload with async=true")
+	return true
+}
+
+// acquireTenantSlot takes a concurrency slot of the request's tenant for a
+// synchronous upload, responding with 429 and returning false when the
+// tenant has none left
+func (h *UploadHandler) acquireTenantSlot(c *gin.Context) (func(), bool) {
+	if h.limiter == nil {
+		return func() {}, true
+	}
+	return middleware.AcquireTenantSlot(c, h.limiter, h.logger)
+}
+
+// respondUploadReadError responds to an upload whose body could not be
+// received, reporting whether err was such a failure
+func (h *UploadHandler) respondUploadReadError(c *gin.Context, err error) bool {
+	log := middleware.RequestLogger(c, h.logger)
+	var tooLarge *http.MaxBytesError
+	switch {
+	case errors.As(err, &tooLarge) && tooLarge.Limit == h.maxUploadBytes:
+		log.Warnf("Upload rejected: %v", err)
+		h.respondError(c, http.StatusRequestEntityTooLarge, fmt.Sprintf("Upload exceeds %d bytes", h.maxUploadBytes))
+	case errors.Is(err, services.ErrUploadTimedOut):
+		log.Warnf("Upload timed out: %v", err)
+		h.respondError(c, http.StatusRequestTimeout, fmt.Sprintf("Upload was not received within %s", h.uploadDeadline))
+	case errors.Is(err, services.ErrUploadInterrupted):
+		log.Warnf("Client disconnected during upload: %v", err)
+		h.respondError(c, http.StatusBadRequest, "Upload interrupted before the file was received")
+	default:
+		return false
+	}
+	return true
 }
 
 // UploadCSV handles CSV file upload and processing
 func (h *UploadHandler) UploadCSV(c *gin.Context) {
+	log := middleware.RequestLogger(c, h.logger)
+	h.guardUploadBody(c)
+
+	// Ephemeral uploads are streamed and never touch the disk
+	ephemeral, err := isEphemeral(c)
+	if err != nil {
+		h.respondError(c, http.StatusBadRequest, err.Error())
+		return
+	}
+	if ephemeral {
+		if h.shedLoad(c) {
+			return
+		}
+		release, ok := h.acquireTenantSlot(c)
+		if !ok {
+			return
+		}
+		defer release()
+		h.uploadEphemeral(c)
+		return
+	}
+
 	// Get the uploaded file
-	file, err := c.FormFile("file")
+	file, err := h.uploadedFile(c)
 	if err != nil {
-		h.logger.Errorf("Failed to get uploaded file: %v", err)
+		if h.respondUploadReadError(c, err) {
+			return
+		}
+		log.Errorf("Failed to get uploaded file: %v", err)
 		c.JSON(http.StatusBadRequest, models.ErrorResponse{
 			Success: false,
-			Error:   "No file uploaded or invalid file format",
+			Error:   err.Error(),
 			Code:    http.StatusBadRequest,
 		})
 		return
@@ -42,7 +268,7 @@ func (h *UploadHandler) UploadCSV(c *gin.Context) {
 
 	// Validate the file
 	if err := h.fileService.ValidateFile(file); err != nil {
-		h.logger.Errorf("File validation failed: %v", err)
+		log.Errorf("File validation failed: %v", err)
 		c.JSON(http.StatusBadRequest, models.ErrorResponse{
 			Success: false,
 			Error:   err.Error(),
@@ -51,10 +277,36 @@ func (h *UploadHandler) UploadCSV(c *gin.Context) {
 		return
 	}
 
+	// Parse upload options
+	opts, err := h.parseUploadOptions(c)
+	if err != nil {
+		log.Errorf("Invalid upload options: %v", err)
+		c.JSON(http.StatusBadRequest, invalidRequestResponse(err))
+		return
+	}
+	h.asyncBySize(&opts, file.Size)
+	if !opts.Async {
+		if h.shedLoad(c) {
+			return
+		}
+		release, ok := h.acquireTenantSlot(c)
+		if !ok {
+			return
+		}
+		defer release()
+	}
+
 	// Save the uploaded file
-	filePath, err := h.fileService.SaveUploadedFile(file)
+	filePath, err := h.fileService.ForTenant(middleware.TenantID(c)).SaveUploadedFile(c.Request.Context(), file)
 	if err != nil {
-		h.logger.Errorf("Failed to save uploaded file: %v", err)
+		if h.respondUploadReadError(c, err) {
+			return
+		}
+		if failure := scanErrorResponse(err); failure != nil {
+			c.JSON(failure.Code, failure)
+			return
+		}
+		log.Errorf("Failed to save uploaded file: %v", err)
 		c.JSON(http.StatusInternalServerError, models.ErrorResponse{
 			Success: false,
 			Error:   "Failed to save uploaded file",
@@ -63,49 +315,955 @@ func (h *UploadHandler) UploadCSV(c *gin.Context) {
 		return
 	}
 
-	// Process the CSV file
-	departmentSummaries, err := h.csvService.ProcessSalesCSV(filePath)
-	if err != nil {
-		h.logger.Errorf("Failed to process CSV file: %v", err)
+	h.processSavedUpload(c, filePath, file.Filename, file.Size, opts)
+}
+
+// checkUploadContent sniffs a stored upload, deleting it and returning the
+// error to respond with when its content doesn't match its extension
+func (h *UploadHandler) checkUploadContent(filePath, filename string) *models.ErrorResponse {
+	err := h.csvService.CheckContent(filePath)
+	if err == nil {
+		return nil
+	}
+	if deleteErr := h.fileService.DeleteUpload(h.fileService.UploadID(filePath)); deleteErr != nil {
+		h.logger.Errorf("Failed to delete rejected upload %s: %v", filePath, deleteErr)
+	}
+	if errors.Is(err, services.ErrContentMismatch) {
+		h.logger.Warnf("Rejected upload %s: %v", filename, err)
+		return &models.ErrorResponse{
+			Success:   false,
+			Error:     err.Error(),
+			Code:      http.StatusUnsupportedMediaType,
+			ErrorCode: models.ErrorCodeContentMismatch,
+		}
+	}
+	h.logger.Errorf("Failed to check content of upload %s: %v", filename, err)
+	return &models.ErrorResponse{Success: false, Error: "Failed to read uploaded file", Code: http.StatusInternalServerError}
+}
+
+// scanErrorResponse returns the error to respond with when the upload
+// scanner rejected or couldn't scan an upload, or nil for other errors
+func scanErrorResponse(err error) *models.ErrorResponse {
+	switch {
+	case errors.Is(err, services.ErrUploadInfected):
+		return &models.ErrorResponse{
+			Success:   false,
+			Error:     err.Error(),
+			Code:      http.StatusUnprocessableEntity,
+			ErrorCode: models.ErrorCodeUploadInfected,
+		}
+	case errors.Is(err, services.ErrScanFailed):
+		return &models.ErrorResponse{Success: false, Error: "Upload could not be scanned, try again later", Code: http.StatusServiceUnavailable}
+	}
+	return nil
+}
+
+// processSavedUpload records a stored upload in the intake log and processes
+// it, or queues it when the upload is async
+func (h *UploadHandler) processSavedUpload(c *gin.Context, filePath, filename string, size int64, opts uploadOptions) {
+	log := middleware.RequestLogger(c, h.logger)
+	if failure := h.checkUploadContent(filePath, filename); failure != nil {
+		c.JSON(failure.Code, failure)
+		return
+	}
+
+	// Log the upload before processing so it can be recovered after a crash;
+	// the outcome is logged once processing has finished
+	job := uploadJob{
+		UploadID: h.fileService.UploadID(filePath),
+		FilePath: filePath,
+		Filename: filename,
+		Tenant:   middleware.TenantID(c),
+		BaseURL:  publicBaseURL(c),
+		Options:  opts,
+	}
+	if err := h.beginIntake(c, job); err != nil {
+		log.Errorf("Failed to record upload in intake log: %v", err)
 		c.JSON(http.StatusInternalServerError, models.ErrorResponse{
 			Success: false,
-			Error:   "Failed to process CSV file: " + err.Error(),
+			Error:   "Failed to record uploaded file",
 			Code:    http.StatusInternalServerError,
 		})
 		return
 	}
+	h.events.Publish(services.Event{
+		Type:     services.EventUploadReceived,
+		Tenant:   job.Tenant,
+		UploadID: job.UploadID,
+		Filename: filename,
+		Size:     size,
+	})
+
+	if opts.Async {
+		h.submitJob(c, job, size)
+		return
+	}
 
-	// Save the result file
-	resultFilePath, err := h.fileService.SaveResultFile(departmentSummaries)
+	completed := false
+	var resultID string
+	defer func() {
+		var err error
+		if completed {
+			err = h.intake.Complete(job.UploadID, resultID)
+		} else {
+			err = h.intake.Fail(job.UploadID, fmt.Sprintf("request finished with status %d", c.Writer.Status()))
+		}
+		if err != nil {
+			log.Errorf("Failed to record outcome of upload %s in intake log: %v", job.UploadID, err)
+		}
+	}()
+
+	// Record a support bundle once the response has been written, if requested
+	if opts.SupportRecord {
+		bundleID := uuid.New().String()
+		c.Header(SupportBundleHeader, bundleID)
+		recorder := newResponseRecorder(c.Writer, maxRecordedResponseBytes)
+		c.Writer = recorder
+		defer recordSupportBundle(c, h.supportService, bundleID, filename, size, filePath, job.UploadID, recorder, h.logger)
+	}
+
+	response, failure := h.processUpload(job)
+	h.sendCallback(job, response, failure)
+	if failure != nil {
+		c.JSON(failure.Code, failure)
+		return
+	}
+	completed = true
+	resultID = response.ResultID
+	c.JSON(http.StatusOK, response)
+}
+
+// uploadJob is a saved upload waiting to be processed
+type uploadJob struct {
+	UploadID string
+	FilePath string
+	Filename string
+	Tenant   string
+	// BaseURL turns download paths into absolute URLs for events
+	BaseURL string
+	Options uploadOptions
+}
+
+// submitJob queues an upload of size bytes for background processing and
+// answers with the job to poll
+func (h *UploadHandler) submitJob(c *gin.Context, upload uploadJob, size int64) {
+	log := middleware.RequestLogger(c, h.logger)
+	job, err := h.jobs.Submit(upload.Tenant, upload.UploadID, upload.Filename, jobParams(c, upload), h.jobFunc(upload))
 	if err != nil {
-		h.logger.Errorf("Failed to save result file: %v", err)
-		c.JSON(http.StatusInternalServerError, models.ErrorResponse{
+		log.Errorf("Failed to queue upload %s: %v", upload.UploadID, err)
+		if err := h.intake.Fail(upload.UploadID, "failed to queue: "+err.Error()); err != nil {
+			log.Errorf("Failed to record outcome of upload %s in intake log: %v", upload.UploadID, err)
+		}
+		code, message := http.StatusInternalServerError, "Failed to queue upload"
+		if errors.Is(err, services.ErrJobQueueFull) || errors.Is(err, services.ErrJobQueueClosed) {
+			code, message = http.StatusServiceUnavailable, "Too many uploads are waiting to be processed; try again later"
+			c.Header("Retry-After", "30")
+		}
+		h.respondError(c, code, message)
+		return
+	}
+
+	statusURL := "/api/v1/jobs/" + job.ID
+	c.Header("Location", statusURL)
+	c.JSON(http.StatusAccepted, models.JobAcceptedResponse{
+		Success:   true,
+		Message:   "CSV file queued for processing",
+		JobID:     job.ID,
+		UploadID:  job.UploadID,
+		State:     job.State,
+		StatusURL: statusURL,
+		Strategy:  h.csvService.StrategyFor(size, upload.Options.Process),
+	})
+}
+
+// asyncBySize queues uploads of files large enough for parallel processing
+// as async jobs, unless the request chose async itself or sent options only
+// synchronous uploads support
+func (h *UploadHandler) asyncBySize(opts *uploadOptions, size int64) {
+	if opts.Async || opts.AsyncChosen || opts.SupportRecord || opts.Encryptor != nil || !h.csvService.LargeFile(size) {
+		return
+	}
+	opts.Async = true
+}
+
+// jobFunc returns the work of an async upload, which records its outcome
+// in the intake log
+func (h *UploadHandler) jobFunc(upload uploadJob) services.JobFunc {
+	log := h.uploadLogger(upload.Options)
+	return func(job services.Job) (*models.UploadResponse, *models.ErrorResponse) {
+		upload.Options.Process.Progress = func(progress services.ProcessProgress) {
+			h.jobs.SetProgress(job.ID, progress)
+		}
+		response, failure := h.processUpload(upload)
+		h.sendCallback(upload, response, failure)
+		var err error
+		if failure != nil {
+			err = h.intake.Fail(upload.UploadID, failure.Error)
+		} else {
+			err = h.intake.Complete(upload.UploadID, response.ResultID)
+		}
+		if err != nil {
+			log.Errorf("Failed to record outcome of upload %s in intake log: %v", upload.UploadID, err)
+		}
+		return response, failure
+	}
+}
+
+// processUpload processes a saved upload into a result, returning the
+// response on success or the error response on failure
+func (h *UploadHandler) processUpload(job uploadJob) (*models.UploadResponse, *models.ErrorResponse) {
+	response, _, failure := h.processUploadSummaries(job)
+	return response, failure
+}
+
+// processUploadSummaries is processUpload that also returns the department
+// summaries behind the response
+func (h *UploadHandler) processUploadSummaries(job uploadJob) (*models.UploadResponse, []services.DepartmentSummary, *models.ErrorResponse) {
+	log := h.uploadLogger(job.Options)
+	opts := job.Options
+	uploadID := job.UploadID
+	files := h.resultFiles(job.Tenant, opts)
+	completed := false
+	var err error
+	if opts.Encryptor != nil {
+		// No plaintext copy of an encrypted upload is kept, whatever the outcome
+		defer func() {
+			if err := h.fileService.DeleteUpload(uploadID); err != nil && !errors.Is(err, services.ErrUploadNotFound) {
+				log.Errorf("Failed to delete encrypted upload %s: %v", uploadID, err)
+			}
+		}()
+	}
+
+	// Compare the header with the feed's previous upload before processing, so
+	// drift is reported even when it makes processing fail
+	var drift *models.SchemaDrift
+	if opts.Feed != "" {
+		drift, err = h.driftService.Check(job.Tenant, opts.Feed, uploadID, job.FilePath)
+		if err != nil {
+			log.Warnf("Failed to check schema drift for %s: %v", job.Filename, err)
+		}
+	}
+	notifiedDrift := drift
+	if drift != nil && !drift.Detected {
+		notifiedDrift = nil
+	}
+
+	// Open the cleaned output file if requested; it is removed unless processing completes
+	var cleanedFile *os.File
+	if opts.Cleaned {
+		cleanedFile, err = files.CreateOutputFile("cleaned", "csv")
+		if err != nil {
+			return nil, nil, &models.ErrorResponse{
+				Success: false,
+				Error:   "Failed to create cleaned output file",
+				Code:    http.StatusInternalServerError,
+			}
+		}
+		defer func() {
+			cleanedFile.Close()
+			if !completed {
+				os.Remove(cleanedFile.Name())
+				h.events.Publish(services.Event{
+					Type:     services.EventCleanup,
+					Tenant:   job.Tenant,
+					UploadID: uploadID,
+					Filename: job.Filename,
+					Paths:    []string{cleanedFile.Name()},
+					Reason:   "processing did not complete",
+				})
+			}
+		}()
+		opts.Process.CleanedOutput = cleanedFile
+	}
+
+	// Open the error report; it is kept only if processing completes with skipped rows
+	errorsFile, err := files.CreateOutputFile("errors", "csv")
+	if err != nil {
+		return nil, nil, &models.ErrorResponse{
 			Success: false,
-			Error:   "Failed to save result file",
+			Error:   "Failed to create error report file",
 			Code:    http.StatusInternalServerError,
+		}
+	}
+	keepErrors := false
+	defer func() {
+		errorsFile.Close()
+		if !completed || !keepErrors {
+			os.Remove(errorsFile.Name())
+		}
+		if !completed && keepErrors {
+			h.events.Publish(services.Event{
+				Type:     services.EventCleanup,
+				Tenant:   job.Tenant,
+				UploadID: uploadID,
+				Filename: job.Filename,
+				Paths:    []string{errorsFile.Name()},
+				Reason:   "processing did not complete",
+			})
+		}
+	}()
+	opts.Process.ErrorReport = errorsFile
+
+	// Process the CSV file
+	h.events.Publish(services.Event{
+		Type:     services.EventProcessingStarted,
+		Tenant:   job.Tenant,
+		UploadID: uploadID,
+		Filename: job.Filename,
+	})
+	if opts.Process.Bucket != "" {
+		opts.Process.FiscalCalendar = h.fiscalCalendar(job.Tenant)
+	}
+	result, err := h.csvService.Process(job.FilePath, opts.Process)
+	if err != nil {
+		log.Errorf("Failed to process CSV file: %v", err)
+		h.events.Publish(services.Event{
+			Type:        services.EventProcessingCompleted,
+			Tenant:      job.Tenant,
+			UploadID:    uploadID,
+			Filename:    job.Filename,
+			Error:       err.Error(),
+			ErrorType:   services.FailureType(err),
+			SchemaDrift: notifiedDrift,
 		})
-		return
+		if errors.Is(err, services.ErrPIIDetected) {
+			// A rejected file's personal data is not kept either
+			if err := h.fileService.DeleteUpload(uploadID); err != nil {
+				log.Errorf("Failed to delete upload %s holding personal data: %v", uploadID, err)
+			}
+		}
+		code := processErrorCode(err)
+		response := &models.ErrorResponse{
+			Success:     false,
+			Error:       "Failed to process CSV file: " + err.Error(),
+			Code:        code,
+			SchemaDrift: drift,
+		}
+		var matchErr *services.ColumnMatchError
+		if opts.Process.TraceColumns && errors.As(err, &matchErr) {
+			response.Columns = matchErr.Columns
+		}
+		setSchemaViolations(response, err)
+		return nil, nil, response
+	}
+
+	if result.PII != nil && result.PII.Mode == services.PIIModeRedact {
+		h.redactUpload(job, result.Schema.Delimiter, result.PII)
+	}
+
+	departmentSummaries := result.Summaries
+
+	// Reject files with too many skipped rows if the client asked for it
+	if opts.MaxSkippedRatio >= 0 && result.Rows.Total > 0 {
+		ratio := float64(result.Rows.Skipped) / float64(result.Rows.Total)
+		if ratio > opts.MaxSkippedRatio {
+			log.Warnf("Rejecting %s: %d of %d rows skipped", job.Filename, result.Rows.Skipped, result.Rows.Total)
+			message := fmt.Sprintf("%d of %d rows were skipped, exceeding max_skipped_ratio %g", result.Rows.Skipped, result.Rows.Total, opts.MaxSkippedRatio)
+			h.events.Publish(services.Event{
+				Type:        services.EventProcessingCompleted,
+				Tenant:      job.Tenant,
+				UploadID:    uploadID,
+				Filename:    job.Filename,
+				Error:       message,
+				ErrorType:   services.FailureSkippedRatio,
+				SchemaDrift: notifiedDrift,
+				Rows:        &result.Rows,
+			})
+			return nil, nil, &models.ErrorResponse{
+				Success: false,
+				Error:   message,
+				Code:    http.StatusUnprocessableEntity,
+				Rows:    &result.Rows,
+			}
+		}
+	}
+
+	// Update the department table, then join its attributes if requested
+	names := make([]string, len(departmentSummaries))
+	for i, summary := range departmentSummaries {
+		names[i] = summary.Department
+	}
+	if err := h.departments.Observe(job.Tenant, names, time.Now()); err != nil {
+		log.Warnf("Failed to update department table: %v", err)
+	}
+	if opts.JoinDepartments {
+		h.departments.Join(job.Tenant, departmentSummaries)
+	}
+
+	// Add this upload to the feed's history, then attach trends if requested
+	if opts.Feed != "" {
+		if err := h.trends.Record(job.Tenant, opts.Feed, uploadID, departmentSummaries, time.Now()); err != nil {
+			log.Warnf("Failed to record trend history for feed %s: %v", opts.Feed, err)
+		}
+		if opts.TrendPoints > 0 {
+			h.trends.Fill(job.Tenant, opts.Feed, departmentSummaries, opts.TrendPoints)
+		}
+	}
+
+	if opts.MicroBatch {
+		response, failure := h.addToMicroBatch(job, result, drift, notifiedDrift)
+		completed = failure == nil
+		return response, departmentSummaries, failure
+	}
+
+	// Save the result file; a configurable aggregation replaces the department totals
+	processedAt := time.Now()
+	var resultFilePath string
+	if opts.OutputFormat == services.OutputFormatIntegrationJSON {
+		summaries, aggregations := departmentSummaries, result.Aggregations
+		if result.Aggregation != nil {
+			services.RoundAggregation(result.Aggregation, opts.NumberFormat)
+			summaries, aggregations = nil, append([]*models.AggregationResult{result.Aggregation}, aggregations...)
+		}
+		metadata := services.ResultMetadata{UploadID: uploadID, ProcessedAt: processedAt}
+		resultFilePath, err = files.SaveIntegrationResultFile(metadata, summaries, aggregations, opts.NumberFormat)
+	} else if result.Aggregation != nil {
+		services.RoundAggregation(result.Aggregation, opts.NumberFormat)
+		resultFilePath, err = files.SaveAggregationResultFile(result.Aggregation, opts.NumberFormat, opts.OutputFormat)
+	} else {
+		var metadata *services.ResultMetadata
+		if opts.Metadata {
+			metadata = &services.ResultMetadata{UploadID: uploadID, ProcessedAt: processedAt}
+		}
+		resultFilePath, err = files.SaveFormattedResultFile(departmentSummaries, opts.NumberFormat, opts.Metric, metadata, opts.OutputFormat)
+	}
+	if err != nil {
+		log.Errorf("Failed to save result file: %v", err)
+		return nil, nil, &models.ErrorResponse{
+			Success: false,
+			Error:   "Failed to save result file",
+			Code:    http.StatusInternalServerError,
+		}
 	}
 
 	// Generate download URL
 	downloadURL := h.fileService.GetDownloadURL(resultFilePath)
 
 	// Calculate total sales across all departments
-	totalSales := 0
+	var totalSales models.Amount
 	for _, summary := range departmentSummaries {
-		totalSales += summary.TotalSales
+		totalSales = totalSales.Add(summary.TotalSales)
 	}
 
 	// Create response
-	response := models.UploadResponse{
+	response := &models.UploadResponse{
 		Success:          true,
 		Message:          "CSV file processed successfully",
+		UploadID:         uploadID,
+		ResultID:         h.fileService.ResultID(resultFilePath),
 		DownloadURL:      downloadURL,
 		TotalDepartments: len(departmentSummaries),
 		TotalSales:       totalSales,
-		ProcessedAt:      time.Now().Format(time.RFC3339),
+		ProcessedAt:      processedAt.Format(time.RFC3339),
+		Partial:          result.Rows.Skipped > 0,
+		Rows:             result.Rows,
+		Schema:           result.Schema,
+		Sheets:           result.Sheets,
+		Entries:          result.Entries,
+		Strategy:         result.Strategy,
+		SchemaDrift:      drift,
+		Aggregation:      result.Aggregation,
+		Aggregations:     result.Aggregations,
+		Heatmap:          result.Heatmap,
+		TimeSeries:       result.TimeSeries,
+		Currency:         result.Currency,
+		PII:              result.PII,
+		Encrypted:        opts.Encryptor != nil,
+	}
+	if services.MetricIncludesCount(opts.Metric) {
+		response.SalesCount = salesCount(departmentSummaries)
+	}
+	if opts.TrendPoints > 0 || opts.Process.Stats {
+		response.Departments = departmentTotals(departmentSummaries, opts.Metric)
+	}
+	if opts.IncludeResults {
+		response.Results, response.ResultsTruncated = inlineResults(departmentSummaries, h.maxInlineRows, opts.NestedResults)
+	}
+	if opts.Report != nil {
+		reportData := services.NewReportData(job.Filename, response.ProcessedAt, departmentSummaries, result.Rows)
+		reportData.Format = opts.NumberFormat
+		reportPath, err := h.saveReport(files, opts.Report, reportData)
+		if err != nil {
+			log.Errorf("Failed to save report: %v", err)
+			code := http.StatusInternalServerError
+			if errors.Is(err, services.ErrReportTooLarge) || errors.Is(err, services.ErrReportTimeout) {
+				code = http.StatusUnprocessableEntity
+			}
+			return nil, nil, &models.ErrorResponse{
+				Success: false,
+				Error:   "Failed to render report: " + err.Error(),
+				Code:    code,
+			}
+		}
+		response.ReportURL = h.fileService.GetDownloadURL(reportPath)
+	}
+	if result.Heatmap != nil {
+		heatmapPath, err := files.SaveHeatmapFile(result.Heatmap, opts.NumberFormat)
+		if err != nil {
+			log.Errorf("Failed to save heatmap file: %v", err)
+			return nil, nil, &models.ErrorResponse{
+				Success: false,
+				Error:   "Failed to save heatmap file",
+				Code:    http.StatusInternalServerError,
+			}
+		}
+		response.HeatmapURL = h.fileService.GetDownloadURL(heatmapPath)
+	}
+	if result.TimeSeries != nil {
+		timeSeriesPath, err := files.SaveTimeSeriesFile(result.TimeSeries, opts.NumberFormat)
+		if err != nil {
+			log.Errorf("Failed to save time series file: %v", err)
+			return nil, nil, &models.ErrorResponse{
+				Success: false,
+				Error:   "Failed to save time series file",
+				Code:    http.StatusInternalServerError,
+			}
+		}
+		response.TimeSeriesURL = h.fileService.GetDownloadURL(timeSeriesPath)
+	}
+	for _, aggregation := range result.Aggregations {
+		services.RoundAggregation(aggregation, opts.NumberFormat)
+		aggregationPath, err := files.SaveAggregationResultFile(aggregation, opts.NumberFormat, opts.tableFormat())
+		if err != nil {
+			log.Errorf("Failed to save aggregation result file: %v", err)
+			return nil, nil, &models.ErrorResponse{
+				Success: false,
+				Error:   "Failed to save result file",
+				Code:    http.StatusInternalServerError,
+			}
+		}
+		aggregation.DownloadURL = h.fileService.GetDownloadURL(aggregationPath)
+	}
+	if cleanedFile != nil {
+		if err := files.Persist(cleanedFile.Name()); err != nil {
+			log.Errorf("Failed to store cleaned output: %v", err)
+			return nil, nil, &models.ErrorResponse{
+				Success: false,
+				Error:   "Failed to store cleaned output file",
+				Code:    http.StatusInternalServerError,
+			}
+		}
+		response.CleanedURL = h.fileService.GetDownloadURL(cleanedFile.Name())
+	}
+	if result.Rows.Skipped > 0 {
+		keepErrors = true
+		if err := files.Persist(errorsFile.Name()); err != nil {
+			log.Errorf("Failed to store error report: %v", err)
+			return nil, nil, &models.ErrorResponse{
+				Success: false,
+				Error:   "Failed to store error report file",
+				Code:    http.StatusInternalServerError,
+			}
+		}
+		response.ErrorReportURL = h.fileService.GetDownloadURL(errorsFile.Name())
+	}
+	if response.Partial {
+		response.Message = fmt.Sprintf("CSV file processed with %d of %d rows skipped", result.Rows.Skipped, result.Rows.Total)
+	}
+	response.Reconciliation = services.Reconcile(totalSales, result.FooterTotal, opts.ExpectedTotal)
+	if response.Reconciliation != nil && !response.Reconciliation.Reconciled {
+		log.Warnf("Totals for %s do not reconcile: %+v", job.Filename, response.Reconciliation.Checks)
+		response.Message += "; totals do not reconcile"
+	}
+	if notifiedDrift != nil {
+		response.Message += "; schema changed since the previous upload"
 	}
 
-	h.logger.Infof("CSV processing completed successfully. Result file: %s", resultFilePath)
-	c.JSON(http.StatusOK, response)
+	completedEvent := services.Event{
+		Type:             services.EventProcessingCompleted,
+		Tenant:           job.Tenant,
+		UploadID:         uploadID,
+		Filename:         job.Filename,
+		Success:          true,
+		TotalSales:       &totalSales,
+		TotalDepartments: len(departmentSummaries),
+		Summaries:        departmentSummaries,
+		ResultID:         response.ResultID,
+		DownloadURL:      absoluteURL(job.BaseURL, downloadURL),
+		SchemaDrift:      notifiedDrift,
+		Rows:             &response.Rows,
+		ResultPath:       resultFilePath,
+		Tags:             opts.Tags,
+	}
+	if opts.Encryptor != nil {
+		// Encrypted results must not be readable elsewhere: the totals are
+		// left out of history and notifications
+		completedEvent.TotalSales, completedEvent.Summaries = nil, nil
+	}
+	h.events.Publish(completedEvent)
+
+	completed = true
+	log.Infof("CSV processing completed successfully. Result file: %s", resultFilePath)
+	return response, departmentSummaries, nil
+}
+
+// addToMicroBatch adds a processed upload's totals to its micro-batch window
+// rather than saving a result, responding with the window it joined
+func (h *UploadHandler) addToMicroBatch(job uploadJob, result *services.ProcessResult, drift, notifiedDrift *models.SchemaDrift) (*models.UploadResponse, *models.ErrorResponse) {
+	log := h.uploadLogger(job.Options)
+	opts := job.Options
+	batch, err := h.microBatches.Add(job.Tenant, opts.Feed, job.UploadID, result, services.MicroBatchOptions{
+		Order:        opts.Process.Order,
+		Metric:       opts.Metric,
+		OutputFormat: opts.OutputFormat,
+		Precision:    opts.NumberFormat.Precision,
+		Rounding:     opts.NumberFormat.Rounding,
+	})
+	if err != nil {
+		log.Errorf("Failed to add upload %s to a micro-batch window: %v", job.UploadID, err)
+		return nil, &models.ErrorResponse{
+			Success: false,
+			Error:   "Failed to add upload to micro-batch window",
+			Code:    http.StatusInternalServerError,
+		}
+	}
+
+	var totalSales models.Amount
+	for _, summary := range result.Summaries {
+		totalSales = totalSales.Add(summary.TotalSales)
+	}
+	processedAt := time.Now()
+	response := &models.UploadResponse{
+		Success:          true,
+		Message:          "CSV file processed and added to micro-batch window",
+		UploadID:         job.UploadID,
+		TotalDepartments: len(result.Summaries),
+		TotalSales:       totalSales,
+		ProcessedAt:      processedAt.Format(time.RFC3339),
+		Partial:          result.Rows.Skipped > 0,
+		Rows:             result.Rows,
+		Schema:           result.Schema,
+		Sheets:           result.Sheets,
+		Entries:          result.Entries,
+		Strategy:         result.Strategy,
+		SchemaDrift:      drift,
+		PII:              result.PII,
+		MicroBatch: &models.MicroBatchMembership{
+			ID:       batch.ID,
+			ClosesAt: batch.ClosesAt.Format(time.RFC3339),
+			Uploads:  len(batch.UploadIDs),
+		},
+	}
+	if services.MetricIncludesCount(opts.Metric) {
+		response.SalesCount = salesCount(result.Summaries)
+	}
+	if opts.TrendPoints > 0 {
+		response.Departments = departmentTotals(result.Summaries, opts.Metric)
+	}
+	if opts.IncludeResults {
+		response.Results, response.ResultsTruncated = inlineResults(result.Summaries, h.maxInlineRows, opts.NestedResults)
+	}
+	response.Reconciliation = services.Reconcile(totalSales, result.FooterTotal, opts.ExpectedTotal)
+	if response.Reconciliation != nil && !response.Reconciliation.Reconciled {
+		response.Message += "; totals do not reconcile"
+	}
+	if notifiedDrift != nil {
+		response.Message += "; schema changed since the previous upload"
+	}
+
+	h.events.Publish(services.Event{
+		Type:             services.EventProcessingCompleted,
+		Tenant:           job.Tenant,
+		UploadID:         job.UploadID,
+		Filename:         job.Filename,
+		Success:          true,
+		TotalSales:       &totalSales,
+		TotalDepartments: len(result.Summaries),
+		Summaries:        result.Summaries,
+		SchemaDrift:      notifiedDrift,
+		Rows:             &response.Rows,
+		Tags:             opts.Tags,
+		MicroBatchID:     batch.ID,
+	})
+	log.Infof("Added upload %s to micro-batch window %s", job.UploadID, batch.ID)
+	return response, nil
+}
+
+// uploadedFile returns the file in the first configured field that holds
+// one, or else the only file part of the request. The error lists the fields
+// that were received so clients can see which name they used.
+func (h *UploadHandler) uploadedFile(c *gin.Context) (*multipart.FileHeader, error) {
+	log := middleware.RequestLogger(c, h.logger)
+	form, err := c.MultipartForm()
+	if err != nil {
+		return nil, fmt.Errorf("Expected a multipart/form-data upload: %w", err)
+	}
+	for _, field := range h.fileFields {
+		if files := form.File[field]; len(files) > 0 {
+			return singleFile(field, files)
+		}
+	}
+
+	var fileFields, valueFields []string
+	for field, files := range form.File {
+		if len(files) > 0 {
+			fileFields = append(fileFields, field)
+		}
+	}
+	if len(fileFields) == 1 {
+		log.Debugf("Using file from multipart field %q", fileFields[0])
+		return singleFile(fileFields[0], form.File[fileFields[0]])
+	}
+	for field := range form.Value {
+		valueFields = append(valueFields, field)
+	}
+	sort.Strings(fileFields)
+	sort.Strings(valueFields)
+	return nil, fmt.Errorf("No file uploaded: expected a file in field %s; received file fields [%s] and form fields [%s]",
+		strings.Join(quoteAll(h.fileFields), " or "), strings.Join(quoteAll(fileFields), ", "), strings.Join(quoteAll(valueFields), ", "))
+}
+
+// singleFile returns the only file of a field, rejecting several files
+// rather than silently processing the first
+func singleFile(field string, files []*multipart.FileHeader) (*multipart.FileHeader, error) {
+	if len(files) > 1 {
+		return nil, fmt.Errorf("Field %q holds %d files; upload several files to /api/v1/upload/batch", field, len(files))
+	}
+	return files[0], nil
+}
+
+// redactUpload removes the personal data found in an upload from its stored
+// copy. Uploads that can't be rewritten are deleted instead.
+func (h *UploadHandler) redactUpload(job uploadJob, delimiter string, report *models.PIIReport) {
+	log := h.uploadLogger(job.Options)
+	columns := make([]int, len(report.Columns))
+	for i, column := range report.Columns {
+		columns[i] = column.Index
+	}
+	err := h.fileService.RedactUpload(job.FilePath, delimiter, columns)
+	if errors.Is(err, services.ErrCannotRedact) {
+		log.Warnf("Cannot redact %s, deleting the stored upload", job.Filename)
+		err = h.fileService.DeleteUpload(job.UploadID)
+	}
+	if err != nil {
+		log.Errorf("Failed to redact upload %s: %v", job.UploadID, err)
+		return
+	}
+	report.Redacted = true
+}
+
+// processErrorCode returns the status code of a file that failed processing:
+// files over a limit or lacking a requested column are bad requests, files failing a requested check,
+// holding personal data the server rejects or in currencies without a rate can't be processed, and
+// anything else is a server error
+func processErrorCode(err error) int {
+	switch {
+	case errors.Is(err, services.ErrCSVLimitExceeded), errors.Is(err, services.ErrColumnNotFound):
+		return http.StatusBadRequest
+	case errors.Is(err, services.ErrMissingValue), errors.Is(err, services.ErrPIIDetected), errors.Is(err, services.ErrSchemaViolation), errors.Is(err, services.ErrExchangeRate):
+		return http.StatusUnprocessableEntity
+	}
+	return http.StatusInternalServerError
+}
+
+// setSchemaViolations lists the violations of an upload that failed schema
+// validation in its error response
+func setSchemaViolations(response *models.ErrorResponse, err error) {
+	var schemaErr *services.SchemaError
+	if errors.As(err, &schemaErr) {
+		response.ErrorCode = models.ErrorCodeSchemaViolation
+		response.Violations = schemaErr.Violations
+	}
+}
+
+// departmentTotals lists the total and trend of every department for the
+// response, with sales counts when the metric includes them
+func departmentTotals(summaries []services.DepartmentSummary, metric string) []models.DepartmentTotal {
+	totals := make([]models.DepartmentTotal, len(summaries))
+	for i, summary := range summaries {
+		totals[i] = models.DepartmentTotal{Department: summary.Department, TotalSales: summary.TotalSales, Trend: summary.Trend, Stats: summary.Stats}
+		if services.MetricIncludesCount(metric) {
+			count := summary.SalesCount
+			totals[i].SalesCount = &count
+		}
+	}
+	return totals
+}
+
+// DefaultMaxInlineResults is the number of department totals returned inline
+// unless configured
+const DefaultMaxInlineResults = 1000
+
+// inlineResults returns the first max department totals in result order,
+// keyed by department when nested, and whether any were left out
+func inlineResults(summaries []services.DepartmentSummary, max int, nested bool) (any, bool) {
+	truncated := len(summaries) > max
+	if truncated {
+		summaries = summaries[:max]
+	}
+	if nested {
+		results := make(map[string]models.NestedResult, len(summaries))
+		for _, summary := range summaries {
+			results[summary.Department] = models.NestedResult{Total: summary.TotalSales, Count: summary.SalesCount}
+		}
+		return results, truncated
+	}
+	results := make([]models.DepartmentSummary, len(summaries))
+	for i, summary := range summaries {
+		results[i] = models.DepartmentSummary{Department: summary.Department, TotalSales: summary.TotalSales}
+	}
+	return results, truncated
+}
+
+// salesCount returns the number of sales rows across all departments
+func salesCount(summaries []services.DepartmentSummary) *int {
+	count := 0
+	for _, summary := range summaries {
+		count += summary.SalesCount
+	}
+	return &count
+}
+
+// quoteAll quotes every string of a list
+func quoteAll(values []string) []string {
+	quoted := make([]string, len(values))
+	for i, value := range values {
+		quoted[i] = strconv.Quote(value)
+	}
+	return quoted
+}
+
+// saveReport renders a report into a new output file and returns its path
+func (h *UploadHandler) saveReport(files *services.FileService, tmpl *services.ReportTemplate, data services.ReportData) (string, error) {
+	reportFile, err := files.CreateOutputFile("report", tmpl.Extension)
+	if err != nil {
+		return "", err
+	}
+	defer reportFile.Close()
+
+	if err := tmpl.Render(reportFile, data); err != nil {
+		os.Remove(reportFile.Name())
+		return "", err
+	}
+	if err := files.Persist(reportFile.Name()); err != nil {
+		os.Remove(reportFile.Name())
+		return "", err
+	}
+	return reportFile.Name(), nil
+}
+
+// uploadOptions holds the per-request options of an upload
+type uploadOptions struct {
+	Process services.ProcessOptions
+	// MaxSkippedRatio rejects files whose skipped/total row ratio exceeds it; negative disables
+	MaxSkippedRatio float64
+	// Cleaned requests a row-level cleaned output file
+	Cleaned bool
+	// ExpectedTotal is the client's stated sales total to reconcile against
+	ExpectedTotal *models.Amount
+	// Report renders a textual summary report alongside the CSV result
+	Report *services.ReportTemplate
+	// SupportRecord stores a support bundle for this request
+	SupportRecord bool
+	// Feed names the recurring feed whose header is checked for drift
+	Feed string
+	// JoinDepartments adds department table attributes to the result
+	JoinDepartments bool
+	// NumberFormat sets the precision and rounding of totals in the result file
+	NumberFormat services.NumberFormat
+	// TrendPoints is the number of feed uploads in each department's trend; 0 disables
+	TrendPoints int
+	// IncludeResults returns the department totals inline in the response
+	IncludeResults bool
+	// NestedResults keys the inline results by department instead of listing them
+	NestedResults bool
+	// Metric selects whether results hold sales row counts, summed amounts or both
+	Metric string
+	// Metadata adds the source upload, processing time and row counts to the result file
+	Metadata bool
+	// OutputFormat is the result file's format: csv, json, ndjson, xlsx or integration-json
+	OutputFormat string
+	// Async queues the upload as a background job instead of waiting for the result
+	Async bool
+	// AsyncChosen is set when the request sent async, which then overrides
+	// queueing large files by default
+	AsyncChosen bool
+	// CallbackURL is sent the upload or error response once processing finishes
+	CallbackURL string
+	// Encryptor encrypts the result files before they are stored
+	Encryptor *services.Encryptor
+	// Tags are recorded in the history, to find the result by
+	Tags []string
+	// MicroBatch adds the totals to the feed's micro-batch window instead of saving a result
+	MicroBatch bool
+}
+
+// resultFiles returns the file service to save a tenant's results with,
+// which encrypts them when the upload asked for it
+func (h *UploadHandler) resultFiles(tenant string, opts uploadOptions) *services.FileService {
+	files := h.fileService.ForTenant(tenant)
+	if opts.Encryptor != nil {
+		return files.WithEncryptor(opts.Encryptor)
+	}
+	return files
+}
+
+// tableFormat is the output format of result files beside the main one, such
+// as those of several aggregations. These are always tables, so they are
+// written as CSV when the main result uses the integration schema.
+func (o uploadOptions) tableFormat() string {
+	if o.OutputFormat == services.OutputFormatIntegrationJSON {
+		return services.OutputFormatCSV
+	}
+	return o.OutputFormat
+}
+
+// sendCallback posts the outcome of an upload to its callback URL, if it
+// has one. Download URLs are made absolute, since the receiver may not know
+// the server's address.
+func (h *UploadHandler) sendCallback(job uploadJob, response *models.UploadResponse, failure *models.ErrorResponse) {
+	if job.Options.CallbackURL == "" {
+		return
+	}
+	var payload any = failure
+	if failure == nil {
+		absolute := *response
+		for _, url := range []*string{&absolute.DownloadURL, &absolute.CleanedURL, &absolute.ReportURL, &absolute.HeatmapURL, &absolute.TimeSeriesURL, &absolute.ErrorReportURL} {
+			if *url != "" {
+				*url = absoluteURL(job.BaseURL, *url)
+			}
+		}
+		absolute.Aggregations = make([]*models.AggregationResult, len(response.Aggregations))
+		for i, aggregation := range response.Aggregations {
+			copied := *aggregation
+			copied.DownloadURL = absoluteURL(job.BaseURL, copied.DownloadURL)
+			absolute.Aggregations[i] = &copied
+		}
+		payload = absolute
+	}
+	h.callbacks.Deliver(services.Callback{URL: job.Options.CallbackURL, Tenant: job.Tenant, UploadID: job.UploadID, Payload: payload})
+}
+
+// absoluteURL resolves a server-relative download URL against base; signed
+// URLs of remote storage are already absolute
+func absoluteURL(base, url string) string {
+	if strings.HasPrefix(url, "/") {
+		return base + url
+	}
+	return url
+}
+
+// publicBaseURL returns the scheme and host that server-relative paths are
+// resolved against for use outside the API, e.g. in notification links
+func publicBaseURL(c *gin.Context) string {
+	if base := utils.GetEnv("PUBLIC_BASE_URL", ""); base != "" {
+		return strings.TrimRight(base, "/")
+	}
+	scheme := "http"
+	if c.Request.TLS != nil {
+		scheme = "https"
+	}
+	if proto := c.GetHeader("X-Forwarded-Proto"); proto != "" {
+		scheme = proto
+	}
+	return scheme + "://" + c.Request.Host
+}
+
+// uploadLogger returns the logger of the request that sent an upload, or
+// the handler's for uploads redelivered after a restart
+func (h *UploadHandler) uploadLogger(opts uploadOptions) logrus.FieldLogger {
+	if opts.Process.Logger != nil {
+		return opts.Process.Logger
+	}
+	return h.logger
 }
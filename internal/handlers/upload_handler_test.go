@@ -0,0 +1,224 @@
+package handlers
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"mime/multipart"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"testing"
+
+	"github.com/gin-gonic/gin"
+	"github.com/mussietl/csv-sales-api/internal/middleware"
+	"github.com/mussietl/csv-sales-api/internal/models"
+	"github.com/mussietl/csv-sales-api/internal/services"
+	"github.com/sirupsen/logrus"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// newTestUploadHandler returns an UploadHandler storing files in uploadsDir
+func newTestUploadHandler(t *testing.T, uploadsDir string) *UploadHandler {
+	t.Helper()
+	logger := logrus.New()
+	logger.SetLevel(logrus.FatalLevel)
+	departments, err := services.NewDepartmentStore(filepath.Join(t.TempDir(), "departments.json"), logger)
+	require.NoError(t, err)
+	intake, err := services.NewIntakeLog(filepath.Join(t.TempDir(), "intake.log"), logger)
+	require.NoError(t, err)
+	t.Cleanup(func() { intake.Close() })
+	return NewUploadHandler(services.NewFileService(uploadsDir, logger), services.NewCSVService(logger), services.NewEventBus(logger),
+		services.NewReportService(logger), nil, nil, departments, nil, intake, nil, logger)
+}
+
+func TestEncryptedUploadIsDeleted(t *testing.T) {
+	uploadsDir := t.TempDir()
+	h := newTestUploadHandler(t, uploadsDir)
+	encryptor, err := services.NewEncryptor(nil, "correct horse battery staple")
+	require.NoError(t, err)
+
+	for name, content := range map[string]string{
+		"failing":   "Region,Units\nNorth,3\n",
+		"succeeded": "Department Name,Number of Sales\nBooks,10\n",
+	} {
+		t.Run(name, func(t *testing.T) {
+			filePath, err := h.fileService.SaveUpload(context.Background(), "sales.csv", strings.NewReader(content))
+			require.NoError(t, err)
+			opts := uploadOptions{Encryptor: encryptor, MaxSkippedRatio: -1}
+			response, failure := h.processUpload(uploadJob{
+				UploadID: h.fileService.UploadID(filePath),
+				FilePath: filePath,
+				Filename: "sales.csv",
+				Tenant:   services.DefaultTenantID,
+				Options:  opts,
+			})
+			if name == "failing" {
+				require.NotNil(t, failure)
+				assert.Contains(t, failure.Error, "department column not found")
+			} else {
+				require.Nil(t, failure)
+				assert.True(t, response.Encrypted)
+			}
+			_, err = os.Stat(filePath)
+			assert.ErrorIs(t, err, os.ErrNotExist, "no plaintext copy of the upload is kept")
+		})
+	}
+
+	// Only encrypted files are left
+	entries, err := os.ReadDir(uploadsDir)
+	require.NoError(t, err)
+	for _, entry := range entries {
+		if entry.Type().IsRegular() {
+			data, err := os.ReadFile(filepath.Join(uploadsDir, entry.Name()))
+			require.NoError(t, err)
+			assert.NotContains(t, string(data), "Books", entry.Name())
+			assert.NotContains(t, string(data), "North", entry.Name())
+		}
+	}
+}
+
+func TestRetryUploadUsesLoggedOptions(t *testing.T) {
+	h := newTestUploadHandler(t, t.TempDir())
+	filePath, err := h.fileService.SaveUpload(context.Background(), "sales.csv", strings.NewReader("Region;Units\nNorth;3\nSouth;4\n"))
+	require.NoError(t, err)
+	entry := services.IntakeEntry{
+		UploadID: h.fileService.UploadID(filePath),
+		Path:     filePath,
+		Filename: "sales.csv",
+		Tenant:   services.DefaultTenantID,
+	}
+
+	_, err = h.RetryUpload(entry)
+	assert.Error(t, err, "the defaults cannot read the upload")
+
+	entry.Params = map[string]string{"delimiter": ";", "department_column": "Region", "sales_column": "Units"}
+	resultID, err := h.RetryUpload(entry)
+	require.NoError(t, err)
+	assert.NotEmpty(t, resultID)
+
+	entry.Encrypted = true
+	_, err = h.RetryUpload(entry)
+	assert.ErrorIs(t, err, services.ErrIntakeEncrypted)
+}
+
+func TestProcessObjectRecordsIntake(t *testing.T) {
+	h := newTestUploadHandler(t, t.TempDir())
+
+	require.NoError(t, h.ProcessObject(services.DefaultTenantID, "march.csv", strings.NewReader("Department Name,Number of Sales\nBooks,10\n")))
+	require.NoError(t, h.ProcessObject(services.DefaultTenantID, "april.csv", strings.NewReader("Region,Units\nNorth,3\n")))
+	// Files that aren't uploads are skipped without an intake entry
+	require.NoError(t, h.ProcessObject(services.DefaultTenantID, "notes.txt", strings.NewReader("hello")))
+
+	completed := h.intake.List(services.IntakeCompleted)
+	require.Len(t, completed, 1)
+	assert.Equal(t, "march.csv", completed[0].Filename)
+	assert.NotEmpty(t, completed[0].ResultID)
+	failed := h.intake.List(services.IntakeFailed)
+	require.Len(t, failed, 1)
+	assert.Equal(t, "april.csv", failed[0].Filename)
+	assert.Contains(t, failed[0].Error, "department column not found")
+}
+
+// postUpload posts a small CSV file with the given form fields to /upload
+func postUpload(t *testing.T, router *gin.Engine, tenant string, fields map[string]string) *httptest.ResponseRecorder {
+	t.Helper()
+	var body bytes.Buffer
+	form := multipart.NewWriter(&body)
+	part, err := form.CreateFormFile("file", "sales.csv")
+	require.NoError(t, err)
+	_, err = part.Write([]byte("Department Name,Number of Sales\nBooks,10\n"))
+	require.NoError(t, err)
+	for name, value := range fields {
+		require.NoError(t, form.WriteField(name, value))
+	}
+	require.NoError(t, form.Close())
+	req := httptest.NewRequest(http.MethodPost, "/upload", &body)
+	req.Header.Set("Content-Type", form.FormDataContentType())
+	req.Header.Set(middleware.TenantHeader, tenant)
+	w := httptest.NewRecorder()
+	router.ServeHTTP(w, req)
+	return w
+}
+
+func TestAsyncUploadsTakeNoTenantSlot(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+	h := newTestUploadHandler(t, t.TempDir())
+	limiter, err := services.NewTenantLimiter(filepath.Join(t.TempDir(), "tenant_limits.json"), 0, h.logger)
+	require.NoError(t, err)
+	require.NoError(t, limiter.SetLimit("acme", 1))
+	store, err := services.NewJobStore(filepath.Join(t.TempDir(), "jobs.json"), h.logger)
+	require.NoError(t, err)
+	queue := services.NewJobQueue(store, 2, 10, h.logger)
+	queue.SetTenantLimiter(limiter)
+	h.jobs = queue
+	h.SetTenantLimiter(limiter)
+
+	// A running async job holds acme's only slot
+	running, unblock := make(chan struct{}), make(chan struct{})
+	_, err = queue.Submit("acme", "running", "running.csv", nil, func(services.Job) (*models.UploadResponse, *models.ErrorResponse) {
+		close(running)
+		<-unblock
+		return &models.UploadResponse{Success: true}, nil
+	})
+	require.NoError(t, err)
+	<-running
+
+	router := gin.New()
+	router.POST("/upload", h.UploadCSV)
+	upload := func(async bool) *httptest.ResponseRecorder {
+		return postUpload(t, router, "acme", map[string]string{"async": strconv.FormatBool(async)})
+	}
+
+	var jobIDs []string
+	for i := 0; i < 2; i++ {
+		w := upload(true)
+		require.Equal(t, http.StatusAccepted, w.Code, w.Body.String())
+		var accepted models.JobAcceptedResponse
+		require.NoError(t, json.Unmarshal(w.Body.Bytes(), &accepted))
+		jobIDs = append(jobIDs, accepted.JobID)
+	}
+	assert.Equal(t, http.StatusTooManyRequests, upload(false).Code, "synchronous uploads still wait for a slot")
+
+	close(unblock)
+	queue.Close()
+	for _, id := range jobIDs {
+		job, err := store.Get(id)
+		require.NoError(t, err)
+		assert.Equal(t, services.JobDone, job.State)
+	}
+	assert.Equal(t, 0, limiter.Get("acme").Active)
+}
+
+func TestLargeUploadsAreQueued(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+	h := newTestUploadHandler(t, t.TempDir())
+	h.csvService.SetStrategyThresholds(services.StrategyThresholds{InMemoryMaxBytes: 8, ParallelMinBytes: 16, Workers: 2})
+	store, err := services.NewJobStore(filepath.Join(t.TempDir(), "jobs.json"), h.logger)
+	require.NoError(t, err)
+	queue := services.NewJobQueue(store, 1, 10, h.logger)
+	defer queue.Close()
+	h.jobs = queue
+	router := gin.New()
+	router.POST("/upload", h.UploadCSV)
+
+	w := postUpload(t, router, "acme", nil)
+	require.Equal(t, http.StatusAccepted, w.Code, w.Body.String())
+	var accepted models.JobAcceptedResponse
+	require.NoError(t, json.Unmarshal(w.Body.Bytes(), &accepted))
+	assert.Equal(t, services.StrategyParallel, accepted.Strategy)
+
+	// Asking for a synchronous upload, or options only those support, keeps it synchronous
+	w = postUpload(t, router, "acme", map[string]string{"async": "false"})
+	assert.Equal(t, http.StatusOK, w.Code, w.Body.String())
+	w = postUpload(t, router, "acme", map[string]string{"encrypt_passphrase": "correct horse battery staple"})
+	assert.Equal(t, http.StatusOK, w.Code, w.Body.String())
+
+	h.csvService.SetStrategyThresholds(services.StrategyThresholds{InMemoryMaxBytes: 8, ParallelMinBytes: 0, Workers: 2})
+	w = postUpload(t, router, "acme", nil)
+	assert.Equal(t, http.StatusOK, w.Code, "never queued with parallel processing disabled")
+}
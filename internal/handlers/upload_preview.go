@@ -0,0 +1,97 @@
+package handlers
+
+import (
+	"bufio"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"path/filepath"
+	"strconv"
+	"strings"
+
+	"github.com/gin-gonic/gin"
+	"github.com/mussietl/csv-sales-api/internal/middleware"
+	"github.com/mussietl/csv-sales-api/internal/models"
+	"github.com/mussietl/csv-sales-api/internal/services"
+)
+
+// PreviewCSV reads the header and first rows of an uploaded CSV file and
+// returns the detected delimiter and columns with what processing would take
+// from each row, so clients can confirm the column mapping before uploading
+// the whole file. Only the start of the file is read and nothing is stored.
+func (h *UploadHandler) PreviewCSV(c *gin.Context) {
+	log := middleware.RequestLogger(c, h.logger)
+	h.guardUploadBody(c)
+	part, filename, ok := h.streamFilePart(c)
+	if !ok {
+		return
+	}
+	defer part.Close()
+
+	if ext := strings.ToLower(filepath.Ext(filename)); ext != ".csv" {
+		h.respondError(c, http.StatusBadRequest, fmt.Sprintf("only CSV files can be previewed, got: %s", ext))
+		return
+	}
+	rows := services.DefaultPreviewRows
+	value := c.Query("rows")
+	if value == "" {
+		value = c.Request.PostForm.Get("rows")
+	}
+	if value != "" {
+		n, err := strconv.Atoi(value)
+		if err != nil || n < 1 || n > services.MaxPreviewRows {
+			c.JSON(http.StatusBadRequest, invalidRequestResponse(fieldError("rows", fmt.Errorf("must be between 1 and %d", services.MaxPreviewRows))))
+			return
+		}
+		rows = n
+	}
+	opts, err := h.parseUploadOptions(c)
+	if err != nil {
+		log.Errorf("Invalid preview options: %v", err)
+		c.JSON(http.StatusBadRequest, invalidRequestResponse(err))
+		return
+	}
+
+	content := bufio.NewReaderSize(part, services.ContentSniffBytes)
+	head, err := content.Peek(services.ContentSniffBytes)
+	if err != nil && !errors.Is(err, io.EOF) {
+		if h.respondUploadReadError(c, err) {
+			return
+		}
+		h.respondError(c, http.StatusBadRequest, "Failed to read uploaded file")
+		return
+	}
+	if err := services.CheckStreamContent(filepath.Ext(filename), head); err != nil {
+		log.Warnf("Rejected preview of %s: %v", filename, err)
+		c.JSON(http.StatusUnsupportedMediaType, models.ErrorResponse{
+			Success:   false,
+			Error:     err.Error(),
+			Code:      http.StatusUnsupportedMediaType,
+			ErrorCode: models.ErrorCodeContentMismatch,
+		})
+		return
+	}
+
+	preview, err := h.csvService.Preview(content, opts.Process, rows)
+	if err != nil {
+		if h.respondUploadReadError(c, err) {
+			return
+		}
+		log.Warnf("Failed to preview %s: %v", filename, err)
+		response := models.ErrorResponse{
+			Success: false,
+			Error:   "Failed to preview CSV file: " + err.Error(),
+			Code:    processErrorCode(err),
+		}
+		// The header is what a client needs to pick the columns itself
+		var matchErr *services.ColumnMatchError
+		if errors.As(err, &matchErr) {
+			response.Code = http.StatusUnprocessableEntity
+			response.Columns = matchErr.Columns
+		}
+		c.JSON(response.Code, response)
+		return
+	}
+	c.JSON(http.StatusOK, preview)
+}
@@ -0,0 +1,519 @@
+package handlers
+
+import (
+	"errors"
+	"fmt"
+	"net/http"
+	"reflect"
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/gin-gonic/gin"
+	"github.com/gin-gonic/gin/binding"
+	"github.com/go-playground/validator/v10"
+	"github.com/mussietl/csv-sales-api/internal/middleware"
+	"github.com/mussietl/csv-sales-api/internal/models"
+	"github.com/mussietl/csv-sales-api/internal/services"
+)
+
+// uploadRequest holds the upload and processing parameters, sent as
+// multipart form fields or in the query string. Allowed values are checked
+// by the binding tags; checks that need the services are made when the
+// request is converted to uploadOptions.
+type uploadRequest struct {
+	Distinct         string   `form:"distinct"`
+	DistinctMode     string   `form:"distinct_mode" binding:"omitempty,oneof=auto exact approx"`
+	Stats            bool     `form:"stats"`
+	GroupBy          string   `form:"group_by"`
+	Agg              string   `form:"agg" binding:"omitempty,oneof=sum avg count min max"`
+	Aggregations     string   `form:"aggregations" binding:"max=4096"`
+	Order            string   `form:"order" binding:"omitempty,oneof=department first_seen total_desc"`
+	KeepTotalRows    bool     `form:"keep_total_rows"`
+	Strategy         string   `form:"strategy" binding:"omitempty,oneof=in_memory streaming parallel"`
+	Parser           string   `form:"parser" binding:"omitempty,oneof=standard fast"`
+	Debug            bool     `form:"debug"`
+	Numbers          string   `form:"numbers" binding:"omitempty,oneof=strict lenient"`
+	Percent          string   `form:"percent" binding:"omitempty,oneof=fraction points"`
+	MissingValue     string   `form:"missing_value" binding:"omitempty,oneof=skip zero error"`
+	Sheets           string   `form:"sheets"`
+	Delimiter        string   `form:"delimiter"`
+	NormalizeHeaders *string  `form:"normalize_headers"`
+	NormalizeValues  *string  `form:"normalize_values"`
+	MaxSkippedRatio  *float64 `form:"max_skipped_ratio" binding:"omitempty,min=0,max=1"`
+	Cleaned          bool     `form:"cleaned"`
+	ExpectedTotal    string   `form:"expected_total"`
+	Report           string   `form:"report" binding:"omitempty,oneof=markdown html"`
+	ReportTemplate   string   `form:"report_template"`
+	ReportFormat     string   `form:"report_format" binding:"omitempty,oneof=markdown html text"`
+	SupportRecord    bool     `form:"support_record"`
+	Async            *bool    `form:"async"`
+	Precision        *int     `form:"precision" binding:"omitempty,min=0,max=10"`
+	Rounding         string   `form:"rounding" binding:"omitempty,oneof=half_up half_even bankers"`
+	Metric           string   `form:"metric" binding:"omitempty,oneof=sum count both"`
+	JoinDepartments  bool     `form:"join_departments"`
+	Feed             string   `form:"feed" binding:"max=128"`
+	Trend            *int     `form:"trend" binding:"omitempty,min=0,max=52"`
+	Heatmap          bool     `form:"heatmap"`
+	IncludeResults   bool     `form:"include_results"`
+	ResponseShape    string   `form:"response_shape" binding:"omitempty,oneof=array nested"`
+	Metadata         bool     `form:"metadata"`
+	OutputFormat     string   `form:"output_format" binding:"omitempty,oneof=csv json ndjson xlsx integration-json"`
+	TimestampColumn  string   `form:"timestamp_column"`
+	Bucket           string   `form:"bucket" binding:"omitempty,oneof=day week month quarter"`
+	DateColumn       string   `form:"date_column"`
+	Currency         bool     `form:"currency"`
+	CurrencyColumn   string   `form:"currency_column"`
+	BaseCurrency     string   `form:"base_currency"`
+	DepartmentColumn string   `form:"department_column"`
+	SalesColumn      string   `form:"sales_column"`
+	CallbackURL      string   `form:"callback_url" binding:"max=2048"`
+	Tags             string   `form:"tags" binding:"max=512"`
+	Schema           string   `form:"schema" binding:"max=128"`
+	SchemaMode       string   `form:"schema_mode" binding:"omitempty,oneof=strict lenient"`
+	MicroBatch       bool     `form:"micro_batch"`
+	// EncryptTo lists age public keys; EncryptPassphrase is the alternative
+	EncryptTo         string `form:"encrypt_to" binding:"max=2048"`
+	EncryptPassphrase string `form:"encrypt_passphrase" binding:"max=1024"`
+}
+
+// normalize lowercases the parameters whose values are keywords
+func (r *uploadRequest) normalize() {
+	for _, value := range []*string{
+		&r.DistinctMode, &r.Agg, &r.Order, &r.Strategy, &r.Parser, &r.Numbers,
+		&r.Percent, &r.MissingValue, &r.Report, &r.ReportFormat, &r.Metric,
+		&r.ResponseShape, &r.Delimiter, &r.OutputFormat, &r.Bucket, &r.SchemaMode,
+	} {
+		*value = strings.ToLower(*value)
+	}
+	r.Rounding = strings.ReplaceAll(strings.ToLower(r.Rounding), "-", "_")
+	r.BaseCurrency = strings.ToUpper(strings.TrimSpace(r.BaseCurrency))
+}
+
+// parseUploadOptions binds the upload request and converts it to options
+func (h *UploadHandler) parseUploadOptions(c *gin.Context) (uploadOptions, error) {
+	var req uploadRequest
+	if err := c.ShouldBindWith(&req, formQueryBinding{}); err != nil {
+		return uploadOptions{}, err
+	}
+	opts, err := h.uploadOptions(req)
+	opts.Process.Logger = middleware.RequestLogger(c, h.logger)
+	return opts, err
+}
+
+// uploadOptions converts a bound request to upload options
+func (h *UploadHandler) uploadOptions(req uploadRequest) (uploadOptions, error) {
+	opts := uploadOptions{MaxSkippedRatio: -1}
+
+	process, err := processOptions(req)
+	if err != nil {
+		return opts, err
+	}
+	opts.Process = process
+	opts.Process.PIIMode = h.piiMode
+	if opts.Process.BaseCurrency != "" && !h.csvService.HasExchangeRates() {
+		return opts, fieldError("base_currency", errors.New("exchange rates are not configured on this server"))
+	}
+	if req.Schema != "" {
+		if h.schemas == nil {
+			return opts, fieldError("schema", errors.New("schema profiles are not configured on this server"))
+		}
+		if opts.Process.Schema, err = h.schemas.Get(req.Schema); err != nil {
+			return opts, fieldError("schema", err)
+		}
+		if req.SchemaMode != "" {
+			// The registered profile is shared, so the override applies to a copy
+			profile := *opts.Process.Schema
+			profile.Strict = req.SchemaMode == "strict"
+			opts.Process.Schema = &profile
+		}
+	} else if req.SchemaMode != "" {
+		return opts, fieldError("schema_mode", errors.New("requires schema"))
+	}
+
+	if req.MaxSkippedRatio != nil {
+		opts.MaxSkippedRatio = *req.MaxSkippedRatio
+	}
+	opts.Cleaned = req.Cleaned
+	if req.ExpectedTotal != "" {
+		expected, err := models.ParseAmount(req.ExpectedTotal)
+		if err != nil {
+			return opts, fieldError("expected_total", errors.New("must be a number"))
+		}
+		opts.ExpectedTotal = &expected
+	}
+
+	if req.ReportTemplate != "" {
+		format := req.ReportFormat
+		if format == "" {
+			format = "markdown"
+		}
+		if opts.Report, err = h.reportService.ParseTemplate(req.ReportTemplate, format); err != nil {
+			return opts, fieldError("report_template", err)
+		}
+	} else if req.Report != "" {
+		if opts.Report, err = h.reportService.NamedTemplate(req.Report); err != nil {
+			return opts, fieldError("report", err)
+		}
+	}
+
+	opts.SupportRecord = req.SupportRecord
+	if opts.SupportRecord && h.supportService == nil {
+		return opts, fieldError("support_record", errors.New("support recording is not enabled on this server"))
+	}
+
+	opts.Async = h.asyncDefault
+	if req.Async != nil {
+		opts.Async = *req.Async
+		opts.AsyncChosen = true
+	}
+	if opts.Async && opts.SupportRecord {
+		return opts, fieldError("support_record", errors.New("cannot be combined with async"))
+	}
+	if req.CallbackURL != "" {
+		if err := h.callbacks.Validate(req.CallbackURL); err != nil {
+			return opts, fieldError("callback_url", err)
+		}
+		opts.CallbackURL = req.CallbackURL
+	}
+
+	precision := ""
+	if req.Precision != nil {
+		precision = strconv.Itoa(*req.Precision)
+	}
+	if opts.NumberFormat, err = services.ParseNumberFormat(precision, req.Rounding); err != nil {
+		return opts, fieldError("precision", err)
+	}
+
+	opts.Metric = req.Metric
+	opts.NestedResults = req.ResponseShape == "nested"
+	opts.IncludeResults = req.IncludeResults || opts.NestedResults
+	opts.Metadata = req.Metadata
+	if opts.Metadata && len(opts.Process.GroupBy) > 0 {
+		return opts, fieldError("metadata", errors.New("cannot be combined with group_by"))
+	}
+	opts.OutputFormat = req.OutputFormat
+	if opts.OutputFormat == "" {
+		opts.OutputFormat = services.OutputFormatCSV
+	}
+	opts.JoinDepartments = req.JoinDepartments
+	opts.Feed = req.Feed
+	if opts.Tags, err = services.ParseTags(req.Tags); err != nil {
+		return opts, fieldError("tags", err)
+	}
+	if err := opts.setEncryption(req); err != nil {
+		return opts, err
+	}
+
+	if req.MicroBatch {
+		if err := opts.setMicroBatch(h.microBatches); err != nil {
+			return opts, err
+		}
+	}
+
+	if req.Trend != nil {
+		if *req.Trend > 0 && opts.Feed == "" {
+			return opts, fieldError("trend", errors.New("requires feed"))
+		}
+		opts.TrendPoints = *req.Trend
+	} else if opts.Feed != "" {
+		opts.TrendPoints = services.DefaultTrendPoints
+	}
+
+	return opts, nil
+}
+
+// setEncryption sets up encryption of the results when the request asked for
+// it. The passphrase and the department totals are kept off the server, so
+// options that would store them are refused.
+func (opts *uploadOptions) setEncryption(req uploadRequest) error {
+	field := "encrypt_to"
+	if req.EncryptPassphrase != "" {
+		field = "encrypt_passphrase"
+	} else if req.EncryptTo == "" {
+		return nil
+	}
+	encryptor, err := services.NewEncryptor(splitColumns(req.EncryptTo), req.EncryptPassphrase)
+	if err != nil {
+		return fieldError(field, err)
+	}
+	for _, option := range []struct {
+		name string
+		set  bool
+	}{
+		{"async", opts.Async},
+		{"feed", opts.Feed != ""},
+		{"support_record", opts.SupportRecord},
+		{"callback_url", opts.CallbackURL != ""},
+	} {
+		if option.set {
+			return fieldError(field, fmt.Errorf("cannot be combined with %s", option.name))
+		}
+	}
+	opts.Encryptor = encryptor
+	return nil
+}
+
+// setMicroBatch adds the upload to a micro-batch window instead of saving a
+// result of its own. The window's result holds department totals only, so
+// options producing other files are refused.
+func (opts *uploadOptions) setMicroBatch(batcher *services.MicroBatcher) error {
+	if batcher == nil {
+		return fieldError("micro_batch", errors.New("micro-batching is not enabled on this server"))
+	}
+	for _, option := range []struct {
+		name string
+		set  bool
+	}{
+		{"group_by", len(opts.Process.GroupBy) > 0},
+		{"aggregations", len(opts.Process.Aggregations) > 0},
+		{"heatmap", opts.Process.Heatmap},
+		{"bucket", opts.Process.Bucket != ""},
+		{"currency", opts.Process.Currency},
+		{"cleaned", opts.Cleaned},
+		{"report", opts.Report != nil},
+		{"metadata", opts.Metadata},
+		{"output_format", opts.OutputFormat == services.OutputFormatIntegrationJSON},
+		{"encrypt_to", opts.Encryptor != nil},
+	} {
+		if option.set {
+			return fieldError("micro_batch", fmt.Errorf("cannot be combined with %s", option.name))
+		}
+	}
+	opts.MicroBatch = true
+	return nil
+}
+
+// processOptions converts the processing parameters of a bound request
+func processOptions(req uploadRequest) (services.ProcessOptions, error) {
+	opts := services.ProcessOptions{
+		DistinctColumns:  splitColumns(req.Distinct),
+		DistinctMode:     req.DistinctMode,
+		Stats:            req.Stats,
+		GroupBy:          splitColumns(req.GroupBy),
+		Aggregation:      req.Agg,
+		Order:            req.Order,
+		KeepTotalRows:    req.KeepTotalRows,
+		Strategy:         req.Strategy,
+		Parser:           req.Parser,
+		TraceColumns:     req.Debug,
+		Numbers:          req.Numbers,
+		Percent:          req.Percent,
+		MissingValue:     req.MissingValue,
+		Sheets:           req.Sheets,
+		Heatmap:          req.Heatmap,
+		TimestampColumn:  req.TimestampColumn,
+		Bucket:           req.Bucket,
+		DateColumn:       req.DateColumn,
+		Currency:         req.Currency,
+		CurrencyColumn:   req.CurrencyColumn,
+		BaseCurrency:     req.BaseCurrency,
+		DepartmentColumn: req.DepartmentColumn,
+		SalesColumn:      req.SalesColumn,
+	}
+	if opts.Numbers == "strict" {
+		opts.Numbers = services.NumbersStrict
+	}
+	if opts.MissingValue == "skip" {
+		opts.MissingValue = services.MissingValueSkip
+	}
+	if err := services.ValidateNumberParsing(opts.Numbers, opts.Percent); err != nil {
+		return opts, fieldError("percent", err)
+	}
+	if err := services.ValidateMissingValue(opts.MissingValue); err != nil {
+		return opts, fieldError("missing_value", err)
+	}
+	if err := services.ValidateSheetSelection(opts.Sheets); err != nil {
+		return opts, fieldError("sheets", err)
+	}
+	delimiter, err := services.ParseDelimiter(req.Delimiter)
+	if err != nil {
+		return opts, fieldError("delimiter", err)
+	}
+	opts.Delimiter = delimiter
+	aggregations, err := services.ParseAggregations(req.Aggregations)
+	if err != nil {
+		return opts, fieldError("aggregations", err)
+	}
+	opts.Aggregations = aggregations
+	if opts.TimestampColumn != "" && !opts.Heatmap {
+		return opts, fieldError("timestamp_column", errors.New("requires heatmap"))
+	}
+	if opts.DateColumn != "" && opts.Bucket == "" {
+		return opts, fieldError("date_column", errors.New("requires bucket"))
+	}
+	if opts.CurrencyColumn != "" && !opts.Currency {
+		return opts, fieldError("currency_column", errors.New("requires currency"))
+	}
+	if opts.BaseCurrency != "" {
+		if !opts.Currency {
+			return opts, fieldError("base_currency", errors.New("requires currency"))
+		}
+		if !services.ValidCurrencyCode(opts.BaseCurrency) {
+			return opts, fieldError("base_currency", fmt.Errorf("invalid currency code %q: expected three letters such as USD", opts.BaseCurrency))
+		}
+		if opts.Stats {
+			// Statistics are of the amounts as read, before conversion
+			return opts, fieldError("stats", errors.New("cannot be combined with base_currency"))
+		}
+	}
+
+	if req.NormalizeHeaders != nil {
+		norm, err := services.ParseNormalization(*req.NormalizeHeaders)
+		if err != nil {
+			return opts, fieldError("normalize_headers", err)
+		}
+		opts.HeaderNormalization = &norm
+	}
+	if req.NormalizeValues != nil {
+		norm, err := services.ParseNormalization(*req.NormalizeValues)
+		if err != nil {
+			return opts, fieldError("normalize_values", err)
+		}
+		opts.ValueNormalization = &norm
+	}
+
+	return opts, nil
+}
+
+// splitColumns splits a comma-separated list of column names
+func splitColumns(list string) []string {
+	var columns []string
+	for _, column := range strings.Split(list, ",") {
+		if column = strings.TrimSpace(column); column != "" {
+			columns = append(columns, column)
+		}
+	}
+	return columns
+}
+
+// fieldErrors lists the request parameters that failed validation
+type fieldErrors []models.FieldError
+
+func (e fieldErrors) Error() string {
+	messages := make([]string, len(e))
+	for i, field := range e {
+		messages[i] = field.Field + ": " + field.Error
+	}
+	return strings.Join(messages, "; ")
+}
+
+// fieldError reports a single invalid parameter
+func fieldError(field string, err error) fieldErrors {
+	return fieldErrors{{Field: field, Error: err.Error()}}
+}
+
+// invalidRequestResponse builds the 400 response for a request that failed
+// binding, listing the invalid fields when they are known
+func invalidRequestResponse(err error) models.ErrorResponse {
+	response := models.ErrorResponse{
+		Success: false,
+		Error:   err.Error(),
+		Code:    http.StatusBadRequest,
+	}
+	var fields fieldErrors
+	if errors.As(err, &fields) {
+		response.Error = "Invalid request: " + err.Error()
+		response.Fields = fields
+	}
+	return response
+}
+
+// formQueryBinding binds multipart form fields, falling back to the query
+// string, into a struct with form tags. Values are trimmed, and an empty
+// value leaves a non-string field unset. Unlike binding.Form it reports
+// every invalid field by its parameter name, and it reads fields that a
+// streaming handler has already put in PostForm.
+type formQueryBinding struct{}
+
+func (formQueryBinding) Name() string {
+	return "form_query"
+}
+
+func (formQueryBinding) Bind(req *http.Request, obj any) error {
+	// Errors only mean there is no form body; the query string still applies
+	_ = req.ParseMultipartForm(32 << 20)
+
+	var errs fieldErrors
+	t := reflect.TypeOf(obj).Elem()
+	for i := 0; i < t.NumField(); i++ {
+		field := t.Field(i)
+		key := field.Tag.Get("form")
+		if key == "" || key == "-" {
+			continue
+		}
+		value, ok := requestValue(req, key)
+		kind := field.Type.Kind()
+		if kind == reflect.Ptr {
+			kind = field.Type.Elem().Kind()
+		}
+		if !ok || (value == "" && kind != reflect.String) {
+			continue
+		}
+		if err := binding.MapFormWithTag(obj, map[string][]string{key: {value}}, "form"); err != nil {
+			errs = append(errs, models.FieldError{Field: key, Error: kindMessage(kind)})
+		}
+	}
+
+	if n, ok := obj.(interface{ normalize() }); ok {
+		n.normalize()
+	}
+	if err := binding.Validator.ValidateStruct(obj); err != nil {
+		var invalid validator.ValidationErrors
+		if !errors.As(err, &invalid) {
+			return err
+		}
+		for _, fe := range invalid {
+			sf, _ := t.FieldByName(fe.StructField())
+			errs = append(errs, models.FieldError{Field: sf.Tag.Get("form"), Error: validationMessage(fe)})
+		}
+	}
+	if len(errs) > 0 {
+		sort.SliceStable(errs, func(i, j int) bool { return errs[i].Field < errs[j].Field })
+		return errs
+	}
+	return nil
+}
+
+// requestValue returns a form field, falling back to the query string
+func requestValue(req *http.Request, key string) (string, bool) {
+	if values, ok := req.PostForm[key]; ok && len(values) > 0 {
+		return strings.TrimSpace(values[0]), true
+	}
+	values, ok := req.URL.Query()[key]
+	if !ok || len(values) == 0 {
+		return "", false
+	}
+	return strings.TrimSpace(values[0]), true
+}
+
+// kindMessage describes the values a field of the given kind accepts
+func kindMessage(kind reflect.Kind) string {
+	switch kind {
+	case reflect.Bool:
+		return "must be true or false"
+	case reflect.Int, reflect.Int64:
+		return "must be an integer"
+	case reflect.Float64:
+		return "must be a number"
+	}
+	return "is invalid"
+}
+
+// validationMessage describes a failed binding tag
+func validationMessage(fe validator.FieldError) string {
+	switch fe.Tag() {
+	case "oneof":
+		return "must be one of " + strings.ReplaceAll(fe.Param(), " ", ", ")
+	case "min":
+		return "must be at least " + fe.Param()
+	case "max":
+		if fe.Kind() == reflect.String {
+			return fmt.Sprintf("must be at most %s characters", fe.Param())
+		}
+		return "must be at most " + fe.Param()
+	case "required":
+		return "is required"
+	}
+	return fmt.Sprintf("failed the %s check", fe.Tag())
+}
@@ -0,0 +1,72 @@
+package middleware
+
+import (
+	"errors"
+	"fmt"
+	"net/http"
+	"strings"
+
+	"github.com/gin-gonic/gin"
+	"github.com/mussietl/csv-sales-api/internal/models"
+	"github.com/mussietl/csv-sales-api/internal/services"
+	"github.com/sirupsen/logrus"
+)
+
+// ContextAPIKey is the gin context key holding the authenticated *services.APIKey
+const ContextAPIKey = "api_key"
+
+// APIKeyAuth authenticates requests with an API key granting scope. When
+// required is false, requests that carry no key are let through anonymously.
+func APIKeyAuth(keys *services.APIKeyService, scope string, required bool, logger *logrus.Logger) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		plaintext := extractAPIKey(c)
+		if plaintext == "" {
+			if !required {
+				c.Next()
+				return
+			}
+			abortWithError(c, http.StatusUnauthorized, "API key required")
+			return
+		}
+
+		key, err := keys.Authenticate(plaintext, scope)
+		if err != nil {
+			logger.Warnf("API key authentication failed for %s %s: %v", c.Request.Method, c.Request.URL.Path, err)
+			if errors.Is(err, services.ErrAPIKeyScope) {
+				abortWithError(c, http.StatusForbidden, err.Error())
+				return
+			}
+			abortWithError(c, http.StatusUnauthorized, err.Error())
+			return
+		}
+
+		// A key bound to a tenant can't act for another one
+		if tenant := strings.TrimSpace(c.GetHeader(TenantHeader)); key.Tenant != "" && tenant != "" && tenant != key.Tenant {
+			logger.Warnf("API key %s of tenant %s used for tenant %s", key.ID, key.Tenant, tenant)
+			abortWithError(c, http.StatusForbidden, fmt.Sprintf("api key belongs to tenant %s", key.Tenant))
+			return
+		}
+		c.Set(ContextAPIKey, key)
+		c.Next()
+	}
+}
+
+// extractAPIKey reads the key from X-API-Key or an Authorization bearer token
+func extractAPIKey(c *gin.Context) string {
+	if key := c.GetHeader("X-API-Key"); key != "" {
+		return strings.TrimSpace(key)
+	}
+	if auth := c.GetHeader("Authorization"); len(auth) > 7 && strings.EqualFold(auth[:7], "bearer ") {
+		return strings.TrimSpace(auth[7:])
+	}
+	return ""
+}
+
+// abortWithError stops the chain with a structured error response
+func abortWithError(c *gin.Context, code int, message string) {
+	c.AbortWithStatusJSON(code, models.ErrorResponse{
+		Success: false,
+		Error:   message,
+		Code:    code,
+	})
+}
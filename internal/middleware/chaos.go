@@ -0,0 +1,160 @@
+package middleware
+
+import (
+	"bytes"
+	"fmt"
+	"math/rand"
+	"net/http"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/mussietl/csv-sales-api/internal/models"
+	"github.com/sirupsen/logrus"
+)
+
+// ChaosHeader names the failure injected into a response, so client teams
+// can tell injected failures from real ones in their logs
+const ChaosHeader = "X-Chaos-Injected"
+
+// ChaosConfig sets how often each kind of failure is injected. Rates are
+// probabilities between 0 and 1, drawn independently per request.
+type ChaosConfig struct {
+	// DelayRate is the rate of requests delayed by up to MaxDelay
+	DelayRate float64
+	MaxDelay  time.Duration
+	// StorageErrorRate is the rate of requests failed with a storage error
+	StorageErrorRate float64
+	// PartialReadRate is the rate of responses cut off part-way, with the
+	// connection closed, as if it dropped mid-transfer
+	PartialReadRate float64
+	// Seed makes the injected failures reproducible; 0 seeds from the clock
+	Seed int64
+	// SkipPaths are request paths never failed, such as health checks
+	SkipPaths []string
+}
+
+// Enabled reports whether any failure is injected
+func (cc ChaosConfig) Enabled() bool {
+	return cc.DelayRate > 0 || cc.StorageErrorRate > 0 || cc.PartialReadRate > 0
+}
+
+// Validate checks the rates and delay
+func (cc ChaosConfig) Validate() error {
+	for name, rate := range map[string]float64{"delay rate": cc.DelayRate, "storage error rate": cc.StorageErrorRate, "partial read rate": cc.PartialReadRate} {
+		if rate < 0 || rate > 1 {
+			return fmt.Errorf("%s must be between 0 and 1, got %g", name, rate)
+		}
+	}
+	if cc.DelayRate > 0 && cc.MaxDelay <= 0 {
+		return fmt.Errorf("a positive max delay is required with a delay rate")
+	}
+	return nil
+}
+
+// Chaos injects delays, storage failures and truncated responses at the
+// configured rates. It is meant for client integration testing only.
+func Chaos(config ChaosConfig, logger *logrus.Logger) gin.HandlerFunc {
+	seed := config.Seed
+	if seed == 0 {
+		seed = time.Now().UnixNano()
+	}
+	var mu sync.Mutex
+	rng := rand.New(rand.NewSource(seed))
+	roll := func(rate float64) bool {
+		mu.Lock()
+		defer mu.Unlock()
+		return rng.Float64() < rate
+	}
+	fraction := func() float64 {
+		mu.Lock()
+		defer mu.Unlock()
+		return rng.Float64()
+	}
+
+	skip := make(map[string]bool, len(config.SkipPaths))
+	for _, path := range config.SkipPaths {
+		skip[path] = true
+	}
+
+	return func(c *gin.Context) {
+		if skip[c.Request.URL.Path] {
+			c.Next()
+			return
+		}
+
+		if roll(config.DelayRate) {
+			delay := time.Duration(fraction() * float64(config.MaxDelay))
+			c.Writer.Header().Add(ChaosHeader, "delay="+delay.String())
+			time.Sleep(delay)
+		}
+
+		if roll(config.StorageErrorRate) {
+			logger.Infof("Chaos: injecting storage failure into %s %s", c.Request.Method, c.Request.URL.Path)
+			c.Writer.Header().Add(ChaosHeader, "storage_error")
+			c.AbortWithStatusJSON(http.StatusInternalServerError, models.ErrorResponse{
+				Success: false,
+				Error:   "Storage is temporarily unavailable",
+				Code:    http.StatusInternalServerError,
+			})
+			return
+		}
+
+		if !roll(config.PartialReadRate) {
+			c.Next()
+			return
+		}
+
+		// Buffer the response, then send only part of it before dropping the connection
+		truncated := &truncatingWriter{ResponseWriter: c.Writer, status: http.StatusOK}
+		c.Writer = truncated
+		c.Writer.Header().Add(ChaosHeader, "partial_read")
+		c.Next()
+		c.Writer = truncated.ResponseWriter
+
+		body := truncated.body.Bytes()
+		cut := int(fraction() * float64(len(body)))
+		logger.Infof("Chaos: truncating %s %s response after %d of %d bytes", c.Request.Method, c.Request.URL.Path, cut, len(body))
+		c.Writer.Header().Set("Content-Length", strconv.Itoa(len(body)))
+		c.Writer.WriteHeader(truncated.status)
+		c.Writer.Write(body[:cut])
+		c.Writer.Flush()
+		if conn, _, err := c.Writer.Hijack(); err == nil {
+			conn.Close()
+		}
+	}
+}
+
+// truncatingWriter holds back the response so Chaos can send part of it
+type truncatingWriter struct {
+	gin.ResponseWriter
+	body   bytes.Buffer
+	status int
+}
+
+func (w *truncatingWriter) WriteHeader(code int) {
+	w.status = code
+}
+
+func (w *truncatingWriter) WriteHeaderNow() {}
+
+func (w *truncatingWriter) Write(b []byte) (int, error) {
+	return w.body.Write(b)
+}
+
+func (w *truncatingWriter) WriteString(s string) (int, error) {
+	return w.body.WriteString(s)
+}
+
+func (w *truncatingWriter) Status() int {
+	return w.status
+}
+
+func (w *truncatingWriter) Size() int {
+	return w.body.Len()
+}
+
+func (w *truncatingWriter) Written() bool {
+	return w.body.Len() > 0
+}
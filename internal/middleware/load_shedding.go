@@ -0,0 +1,25 @@
+package middleware
+
+import (
+	"net/http"
+	"strconv"
+
+	"github.com/gin-gonic/gin"
+	"github.com/mussietl/csv-sales-api/internal/services"
+	"github.com/sirupsen/logrus"
+)
+
+// LoadShedding rejects requests with 503 and Retry-After while the server is
+// under memory pressure. It guards routes that always process synchronously;
+// uploads that may be async are checked by their handler instead.
+func LoadShedding(shedder *services.LoadShedder, logger *logrus.Logger) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		if overloaded, inUse := shedder.Overloaded(); overloaded {
+			logger.Warnf("Shedding %s %s: %d MB in use, threshold %d MB", c.Request.Method, c.Request.URL.Path, inUse>>20, shedder.Threshold()>>20)
+			c.Header("Retry-After", strconv.Itoa(int(services.LoadShedRetryAfter.Seconds())))
+			abortWithError(c, http.StatusServiceUnavailable, "server is under memory pressure, retry later")
+			return
+		}
+		c.Next()
+	}
+}
@@ -0,0 +1,34 @@
+package middleware
+
+import (
+	"fmt"
+	"math"
+	"net/http"
+	"strconv"
+
+	"github.com/gin-gonic/gin"
+	"github.com/mussietl/csv-sales-api/internal/services"
+	"github.com/sirupsen/logrus"
+)
+
+// RateLimit rejects requests with 429 and Retry-After once a client has used
+// up its rate limit. Clients are told apart by API key when the request was
+// authenticated with one, and by IP address otherwise, so it must run after
+// APIKeyAuth.
+func RateLimit(limiter *services.RateLimiter, logger *logrus.Logger) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		client := "ip:" + c.ClientIP()
+		if key, ok := c.Get(ContextAPIKey); ok {
+			client = "key:" + key.(*services.APIKey).ID
+		}
+		ok, wait := limiter.Allow(client)
+		if !ok {
+			seconds := int(math.Ceil(wait.Seconds()))
+			logger.Warnf("Rate limit exceeded by %s on %s %s", client, c.Request.Method, c.Request.URL.Path)
+			c.Header("Retry-After", strconv.Itoa(seconds))
+			abortWithError(c, http.StatusTooManyRequests, fmt.Sprintf("rate limit exceeded, retry in %d seconds", seconds))
+			return
+		}
+		c.Next()
+	}
+}
@@ -0,0 +1,91 @@
+package middleware
+
+import (
+	"net/http"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/google/uuid"
+	"github.com/mussietl/csv-sales-api/internal/services"
+	"github.com/sirupsen/logrus"
+)
+
+// RequestIDHeader carries the ID a request is logged under
+const RequestIDHeader = "X-Request-ID"
+
+// maxRequestIDLength bounds request IDs accepted from clients
+const maxRequestIDLength = 128
+
+// RequestID gives every request an ID, keeping a well-formed one sent by the
+// client or a proxy, and returns it in the response. The request's context
+// carries a log entry with the ID, so handlers and services log under it.
+func RequestID(logger *logrus.Logger) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		id := c.GetHeader(RequestIDHeader)
+		if !validRequestID(id) {
+			id = uuid.New().String()
+		}
+		c.Header(RequestIDHeader, id)
+		entry := logger.WithField("request_id", id)
+		c.Request = c.Request.WithContext(services.ContextWithLogger(c.Request.Context(), entry))
+		c.Next()
+	}
+}
+
+// RequestLogger returns the log entry of a request, or one of fallback when
+// RequestID did not run
+func RequestLogger(c *gin.Context, fallback *logrus.Logger) *logrus.Entry {
+	return services.LoggerFromContext(c.Request.Context(), fallback)
+}
+
+// validRequestID reports whether a client's request ID is short and made of
+// characters that are safe to log and echo
+func validRequestID(id string) bool {
+	if id == "" || len(id) > maxRequestIDLength {
+		return false
+	}
+	for _, r := range id {
+		switch {
+		case r >= 'a' && r <= 'z', r >= 'A' && r <= 'Z', r >= '0' && r <= '9':
+		case r == '-', r == '_', r == '.', r == ':':
+		default:
+			return false
+		}
+	}
+	return true
+}
+
+// AccessLog logs every request once it has been served, with its method,
+// path, status, latency and response size, replacing gin's text logger.
+// Server errors are logged as errors and client errors as warnings.
+func AccessLog(logger *logrus.Logger) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		start := time.Now()
+		c.Next()
+
+		status := c.Writer.Status()
+		size := c.Writer.Size()
+		if size < 0 {
+			size = 0
+		}
+		entry := RequestLogger(c, logger).WithFields(logrus.Fields{
+			"method":     c.Request.Method,
+			"path":       c.Request.URL.Path,
+			"status":     status,
+			"latency_ms": float64(time.Since(start).Microseconds()) / 1000,
+			"bytes":      size,
+			"client_ip":  c.ClientIP(),
+		})
+		if len(c.Errors) > 0 {
+			entry = entry.WithField("errors", c.Errors.String())
+		}
+		switch {
+		case status >= http.StatusInternalServerError:
+			entry.Error("Request failed")
+		case status >= http.StatusBadRequest:
+			entry.Warn("Request rejected")
+		default:
+			entry.Info("Request served")
+		}
+	}
+}
@@ -0,0 +1,19 @@
+package middleware
+
+import (
+	"net/http"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/mussietl/csv-sales-api/internal/services"
+)
+
+// SLOTracking records each request's latency and whether the server failed
+// it (a 5xx response) with tracker
+func SLOTracking(tracker *services.SLOTracker) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		start := time.Now()
+		c.Next()
+		tracker.Record(time.Since(start), c.Writer.Status() >= http.StatusInternalServerError)
+	}
+}
@@ -0,0 +1,118 @@
+package middleware
+
+import (
+	"errors"
+	"fmt"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/mussietl/csv-sales-api/internal/models"
+	"github.com/mussietl/csv-sales-api/internal/services"
+	"github.com/sirupsen/logrus"
+)
+
+// TenantHeader carries the tenant a request belongs to
+const TenantHeader = "X-Tenant-ID"
+
+// DefaultTenantID is used for requests that do not name a tenant
+const DefaultTenantID = services.DefaultTenantID
+
+// TenantID returns the tenant a request belongs to: the tenant of its API
+// key if the key has one, else the one named by the X-Tenant-ID header
+func TenantID(c *gin.Context) string {
+	if value, ok := c.Get(ContextAPIKey); ok {
+		if key, ok := value.(*services.APIKey); ok && key.Tenant != "" {
+			return key.Tenant
+		}
+	}
+	if tenant := strings.TrimSpace(c.GetHeader(TenantHeader)); tenant != "" {
+		return tenant
+	}
+	return DefaultTenantID
+}
+
+// ValidTenant rejects requests whose X-Tenant-ID header can't name a
+// tenant with 400, since tenant IDs are used in storage paths
+func ValidTenant() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		if tenant := strings.TrimSpace(c.GetHeader(TenantHeader)); tenant != "" && !services.ValidTenantID(tenant) {
+			abortWithError(c, http.StatusBadRequest, fmt.Sprintf("invalid %s: must be 1-64 letters, digits, '.', '_' or '-'", TenantHeader))
+			return
+		}
+		c.Next()
+	}
+}
+
+// TenantConcurrency rejects requests with 429 while the tenant already has
+// as many requests in flight as its concurrency cap allows
+func TenantConcurrency(limiter *services.TenantLimiter, logger *logrus.Logger) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		release, ok := AcquireTenantSlot(c, limiter, logger)
+		if !ok {
+			return
+		}
+		defer release()
+		c.Next()
+	}
+}
+
+// AcquireTenantSlot takes one of the concurrency slots of the request's
+// tenant, for handlers that only count some of their requests, such as
+// synchronous uploads. When the tenant is at its cap it aborts the request
+// like TenantConcurrency and returns false.
+func AcquireTenantSlot(c *gin.Context, limiter *services.TenantLimiter, logger *logrus.Logger) (func(), bool) {
+	tenant := TenantID(c)
+	release, ok := limiter.TryAcquire(tenant)
+	if !ok {
+		limit := limiter.Get(tenant).Limit
+		logger.Warnf("Tenant %s is at its concurrency limit of %d", tenant, limit)
+		c.Header("Retry-After", "5")
+		abortWithError(c, http.StatusTooManyRequests, fmt.Sprintf("tenant %s already has %d jobs in progress", tenant, limit))
+		return nil, false
+	}
+	return release, true
+}
+
+// TenantQuota rejects uploads of tenants that have used up a quota: with
+// 429 until the month ends for the monthly row quota, and with 507 when the
+// upload would take the tenant's stored files over its storage quota
+func TenantQuota(quotas *services.TenantQuotas, logger *logrus.Logger) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		if CheckTenantQuota(c, quotas, max(c.Request.ContentLength, 0), logger) {
+			c.Next()
+		}
+	}
+}
+
+// CheckTenantQuota checks the request's tenant's quotas before storing
+// incomingBytes more, aborting the request like TenantQuota and returning
+// false when one is used up
+func CheckTenantQuota(c *gin.Context, quotas *services.TenantQuotas, incomingBytes int64, logger *logrus.Logger) bool {
+	tenant := TenantID(c)
+	err := quotas.Check(tenant, incomingBytes)
+	var quotaErr *services.QuotaError
+	if errors.As(err, &quotaErr) {
+		logger.Warnf("Rejected upload of tenant %s: %v", tenant, err)
+		code := http.StatusInsufficientStorage
+		if quotaErr.Quota == services.QuotaMonthlyRows {
+			code = http.StatusTooManyRequests
+			c.Header("Retry-After", strconv.Itoa(int(time.Until(quotaErr.ResetAt).Seconds())+1))
+		}
+		c.AbortWithStatusJSON(code, models.ErrorResponse{
+			Success:   false,
+			Error:     err.Error(),
+			Code:      code,
+			ErrorCode: models.ErrorCodeQuotaExceeded,
+		})
+		return false
+	}
+	if err != nil {
+		// Quotas are best effort: an upload isn't refused because its
+		// tenant's usage couldn't be measured
+		logger.Errorf("Failed to check quotas of tenant %s: %v", tenant, err)
+	}
+	return true
+}
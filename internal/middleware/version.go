@@ -0,0 +1,12 @@
+package middleware
+
+import "github.com/gin-gonic/gin"
+
+// VersionHeader names the server version on every response, so reports
+// from clients can be matched to the deployed build
+func VersionHeader(version string) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		c.Header("X-Server-Version", version)
+		c.Next()
+	}
+}
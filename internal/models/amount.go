@@ -0,0 +1,133 @@
+package models
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+)
+
+// AmountDecimals is the number of decimal places an Amount holds exactly
+const AmountDecimals = 4
+
+// amountScale is the number of Amount units in 1
+const amountScale = 10000
+
+// Amount is a sales amount in fixed point with AmountDecimals decimal
+// places, so sums of decimal values such as 1234.56 are exact. It is
+// written to JSON as a plain number: 1500 or 1234.56.
+type Amount struct {
+	units int64
+}
+
+// WholeAmount returns the Amount of a whole number
+func WholeAmount(n int) Amount {
+	return Amount{units: int64(n) * amountScale}
+}
+
+// ParseAmount parses a plain decimal such as "-1234.56". Digits beyond
+// AmountDecimals are rounded half away from zero.
+func ParseAmount(s string) (Amount, error) {
+	unsigned := strings.TrimLeft(s, "+-")
+	if len(s)-len(unsigned) > 1 {
+		return Amount{}, fmt.Errorf("invalid amount %q", s)
+	}
+	intPart, fracPart, _ := strings.Cut(unsigned, ".")
+	if intPart+fracPart == "" || !isDigits(intPart) || !isDigits(fracPart) {
+		return Amount{}, fmt.Errorf("invalid amount %q", s)
+	}
+
+	roundUp := false
+	if len(fracPart) > AmountDecimals {
+		roundUp = fracPart[AmountDecimals] >= '5'
+		fracPart = fracPart[:AmountDecimals]
+	}
+	fracPart += strings.Repeat("0", AmountDecimals-len(fracPart))
+
+	units, err := strconv.ParseInt(intPart+fracPart, 10, 64)
+	if err != nil {
+		return Amount{}, fmt.Errorf("amount %q out of range", s)
+	}
+	if roundUp {
+		units++
+	}
+	if strings.HasPrefix(s, "-") {
+		units = -units
+	}
+	return Amount{units: units}, nil
+}
+
+// Add returns a + b
+func (a Amount) Add(b Amount) Amount {
+	return Amount{units: a.units + b.units}
+}
+
+// Sub returns a - b
+func (a Amount) Sub(b Amount) Amount {
+	return Amount{units: a.units - b.units}
+}
+
+// Cmp returns -1, 0 or +1 as a is less than, equal to or greater than b
+func (a Amount) Cmp(b Amount) int {
+	switch {
+	case a.units < b.units:
+		return -1
+	case a.units > b.units:
+		return 1
+	}
+	return 0
+}
+
+// IsZero reports whether the amount is 0
+func (a Amount) IsZero() bool {
+	return a.units == 0
+}
+
+// Units returns the amount in units of 1/10^AmountDecimals
+func (a Amount) Units() int64 {
+	return a.units
+}
+
+// Float64 returns the amount as a float, for ratios and charts
+func (a Amount) Float64() float64 {
+	return float64(a.units) / amountScale
+}
+
+// String returns the exact decimal without trailing zeros, e.g. "1500" or "-0.5"
+func (a Amount) String() string {
+	sign := ""
+	units := uint64(a.units)
+	if a.units < 0 {
+		sign, units = "-", uint64(-a.units)
+	}
+	whole, frac := units/amountScale, units%amountScale
+	if frac == 0 {
+		return sign + strconv.FormatUint(whole, 10)
+	}
+	digits := strings.TrimRight(fmt.Sprintf("%0*d", AmountDecimals, frac), "0")
+	return sign + strconv.FormatUint(whole, 10) + "." + digits
+}
+
+// MarshalJSON writes the amount as a JSON number
+func (a Amount) MarshalJSON() ([]byte, error) {
+	return []byte(a.String()), nil
+}
+
+// UnmarshalJSON reads a JSON number, rejecting exponents
+func (a *Amount) UnmarshalJSON(data []byte) error {
+	parsed, err := ParseAmount(string(data))
+	if err != nil {
+		return err
+	}
+	*a = parsed
+	return nil
+}
+
+// isDigits reports whether s holds only ASCII digits; the empty string qualifies
+func isDigits(s string) bool {
+	for i := 0; i < len(s); i++ {
+		if s[i] < '0' || s[i] > '9' {
+			return false
+		}
+	}
+	return true
+}
@@ -3,23 +3,365 @@ package models
 // SalesRecord represents a single sales record from CSV
 type SalesRecord struct {
 	Department string `csv:"department"`
-	Sales      int    `csv:"sales"`
+	Sales      Amount `csv:"sales"`
 }
 
 // DepartmentSummary represents aggregated sales data for a department
 type DepartmentSummary struct {
 	Department string `json:"department" csv:"Department Name"`
-	TotalSales int    `json:"total_sales" csv:"Total Number of Sales"`
+	TotalSales Amount `json:"total_sales" csv:"Total Number of Sales"`
 }
 
 // UploadResponse represents the response after successful CSV upload and processing
 type UploadResponse struct {
-	Success          bool   `json:"success"`
-	Message          string `json:"message"`
-	DownloadURL      string `json:"download_url"`
-	TotalDepartments int    `json:"total_departments"`
-	TotalSales       int    `json:"total_sales"`
-	ProcessedAt      string `json:"processed_at"`
+	Success          bool            `json:"success"`
+	Message          string          `json:"message"`
+	UploadID         string          `json:"upload_id,omitempty"`
+	ResultID         string          `json:"result_id,omitempty"`
+	DownloadURL      string          `json:"download_url,omitempty"`
+	CleanedURL       string          `json:"cleaned_download_url,omitempty"`
+	ReportURL        string          `json:"report_download_url,omitempty"`
+	HeatmapURL       string          `json:"heatmap_download_url,omitempty"`
+	TimeSeriesURL    string          `json:"time_series_download_url,omitempty"`
+	ErrorReportURL   string          `json:"error_report_download_url,omitempty"`
+	TotalDepartments int             `json:"total_departments"`
+	TotalSales       Amount          `json:"total_sales"`
+	SalesCount       *int            `json:"sales_count,omitempty"`
+	ProcessedAt      string          `json:"processed_at"`
+	Partial          bool            `json:"partial"`
+	Rows             RowStats        `json:"rows"`
+	Reconciliation   *Reconciliation `json:"reconciliation,omitempty"`
+	Schema           *SchemaReport   `json:"schema,omitempty"`
+	Sheets           []SheetStats    `json:"sheets,omitempty"`
+	Entries          []SheetStats    `json:"entries,omitempty"`
+	Strategy         string          `json:"strategy"`
+	SchemaDrift      *SchemaDrift    `json:"schema_drift,omitempty"`
+	// Ephemeral is set when nothing was stored
+	Ephemeral bool `json:"ephemeral,omitempty"`
+	// Encrypted is set when the result files were encrypted with age
+	Encrypted bool `json:"encrypted,omitempty"`
+	// Departments lists each department's total for ephemeral uploads and trend and stats requests
+	Departments []DepartmentTotal `json:"departments,omitempty"`
+	// Aggregation holds the groups of a group_by or agg request
+	Aggregation *AggregationResult `json:"aggregation,omitempty"`
+	// Aggregations holds the results of an aggregations request, in request order
+	Aggregations []*AggregationResult `json:"aggregations,omitempty"`
+	// Heatmap breaks department sales down by weekday and hour, when requested
+	Heatmap *SalesHeatmap `json:"heatmap,omitempty"`
+	// TimeSeries holds department sales per date bucket, when requested
+	TimeSeries *SalesTimeSeries `json:"time_series,omitempty"`
+	// Currency breaks department sales down by currency, when requested
+	Currency *CurrencyBreakdown `json:"currency,omitempty"`
+	// PII lists the columns where personal data was found
+	PII *PIIReport `json:"pii,omitempty"`
+	// Results holds the result file's department totals inline, when
+	// requested: a []DepartmentSummary, or a map[string]NestedResult keyed by
+	// department in the nested response shape
+	Results any `json:"results,omitempty"`
+	// ResultsTruncated is set when Results was cut off at the server's inline limit
+	ResultsTruncated bool `json:"results_truncated,omitempty"`
+	// MicroBatch is the window the totals were added to, for uploads with no result of their own
+	MicroBatch *MicroBatchMembership `json:"micro_batch,omitempty"`
+}
+
+// MicroBatchMembership identifies the micro-batch window an upload joined
+type MicroBatchMembership struct {
+	ID       string `json:"id"`
+	ClosesAt string `json:"closes_at"`
+	// Uploads is the number of uploads in the window so far, this one included
+	Uploads int `json:"uploads"`
+}
+
+// NestedResult is a department's inline result in the nested response shape
+type NestedResult struct {
+	Total Amount `json:"total"`
+	Count int    `json:"count"`
+}
+
+// JobAcceptedResponse is returned when an upload is queued for background processing
+type JobAcceptedResponse struct {
+	Success  bool   `json:"success"`
+	Message  string `json:"message"`
+	JobID    string `json:"job_id"`
+	UploadID string `json:"upload_id"`
+	State    string `json:"state"`
+	// StatusURL is polled for the job's state and, once done, its result
+	StatusURL string `json:"status_url"`
+	// Strategy is the processing strategy the job will use
+	Strategy string `json:"strategy,omitempty"`
+}
+
+// DepartmentTotal is one department's total, returned inline for ephemeral
+// uploads and with trends
+type DepartmentTotal struct {
+	Department string `json:"department"`
+	TotalSales Amount `json:"total_sales"`
+	// SalesCount is the number of sales rows, when the metric includes counts
+	SalesCount *int `json:"sales_count,omitempty"`
+	// Trend holds the totals of the feed's recent uploads, oldest first, ending with this one
+	Trend []Amount `json:"trend,omitempty"`
+	// Stats describes the department's sale amounts, when requested
+	Stats *SalesStats `json:"stats,omitempty"`
+}
+
+// SalesStats describes the spread of a department's sale amounts. The
+// median and percentiles are estimated with a t-digest; StdDev is the
+// sample standard deviation.
+type SalesStats struct {
+	Avg    Amount `json:"avg"`
+	Median Amount `json:"median"`
+	P90    Amount `json:"p90"`
+	P95    Amount `json:"p95"`
+	Min    Amount `json:"min"`
+	Max    Amount `json:"max"`
+	StdDev Amount `json:"stddev"`
+}
+
+// RowStats counts how the data rows of a file were handled
+type RowStats struct {
+	Total       int            `json:"total"`
+	Processed   int            `json:"processed"`
+	Skipped     int            `json:"skipped"`
+	SkipReasons map[string]int `json:"skip_reasons,omitempty"`
+	// FooterRows counts the TOTAL rows ending the data, which are excluded
+	// from aggregation
+	FooterRows int `json:"footer_rows,omitempty"`
+	// FooterRowNumbers are the row numbers of the excluded footer rows
+	FooterRowNumbers []int `json:"footer_row_numbers,omitempty"`
+}
+
+// AggregationResult is the outcome of a configurable aggregation: the sales
+// column aggregated with Function over each combination of the GroupBy columns
+type AggregationResult struct {
+	GroupBy  []string           `json:"group_by"`
+	Function string             `json:"function"`
+	Column   string             `json:"column"`
+	Groups   []AggregationGroup `json:"groups"`
+	// DownloadURL is the result file of one of several aggregations
+	DownloadURL string `json:"download_url,omitempty"`
+}
+
+// AggregationGroup is one combination of group-by values and its aggregate
+type AggregationGroup struct {
+	// Key holds the group's values, in GroupBy order
+	Key   []string `json:"key"`
+	Value float64  `json:"value"`
+	// Rows is the number of sales rows in the group
+	Rows int `json:"rows"`
+	// Exact is Value as an exact decimal, which avg can need more digits for than a float has
+	Exact string `json:"-"`
+}
+
+// PIIReport lists the columns other than department and sales where personal
+// data was found
+type PIIReport struct {
+	// Mode is the server's PII mode: warn, redact or reject
+	Mode    string      `json:"mode"`
+	Columns []PIIColumn `json:"columns"`
+	// Redacted is set when the stored upload was rewritten without the values
+	Redacted bool `json:"redacted,omitempty"`
+}
+
+// PIIColumn counts the values of each kind of personal data in a column
+type PIIColumn struct {
+	Index  int            `json:"index"`
+	Column string         `json:"column"`
+	Kinds  map[string]int `json:"kinds"`
+}
+
+// SalesHeatmap breaks each department's sales down by weekday and hour of
+// day, read from the timestamp column as written, without time zone conversion
+type SalesHeatmap struct {
+	TimestampColumn string `json:"timestamp_column"`
+	// Weekdays names the rows of each department's grid, Monday first
+	Weekdays    []string            `json:"weekdays"`
+	Departments []DepartmentHeatmap `json:"departments"`
+	// UntimedRows counts rows whose timestamp was empty or had no time of day;
+	// their sales are still in the department totals
+	UntimedRows int `json:"untimed_rows"`
+}
+
+// DepartmentHeatmap is one department's sales grid: Sales[weekday][hour]
+type DepartmentHeatmap struct {
+	Department string     `json:"department"`
+	Sales      [][]Amount `json:"sales"`
+}
+
+// SalesTimeSeries holds each department's sales per day, week, month or
+// quarter, read from the date column as written
+type SalesTimeSeries struct {
+	DateColumn string `json:"date_column"`
+	Bucket     string `json:"bucket"`
+	// Buckets lists the buckets with sales in any department, in time order
+	Buckets     []string               `json:"buckets"`
+	Departments []DepartmentTimeSeries `json:"departments"`
+	// UndatedRows counts rows whose date was empty or unrecognized; their
+	// sales are still in the department totals
+	UndatedRows int `json:"undated_rows"`
+}
+
+// DepartmentTimeSeries is one department's sales in each of the series' buckets
+type DepartmentTimeSeries struct {
+	Department string       `json:"department"`
+	Buckets    []TimeBucket `json:"buckets"`
+}
+
+// TimeBucket is a department's sales and number of sales in one bucket
+type TimeBucket struct {
+	Bucket string `json:"bucket"`
+	Sales  Amount `json:"sales"`
+	Count  int    `json:"count"`
+}
+
+// CurrencyBreakdown holds each department's sales per currency, read from
+// the currency column
+type CurrencyBreakdown struct {
+	CurrencyColumn string `json:"currency_column"`
+	// Currencies lists the currencies with sales in any department, in order
+	Currencies []string `json:"currencies"`
+	// BaseCurrency is the currency department totals were converted to, if any
+	BaseCurrency string `json:"base_currency,omitempty"`
+	// Rates holds the units of the base currency one unit of each currency
+	// was converted at, as decimal strings so no precision is lost
+	Rates       map[string]string      `json:"rates,omitempty"`
+	Departments []DepartmentCurrencies `json:"departments"`
+}
+
+// DepartmentCurrencies is one department's sales in each of its currencies
+type DepartmentCurrencies struct {
+	Department string            `json:"department"`
+	Sales      map[string]Amount `json:"sales"`
+	// Converted is the department's total in the base currency
+	Converted *Amount `json:"converted,omitempty"`
+}
+
+// SheetStats counts the data rows read from one sheet of a workbook
+type SheetStats struct {
+	Name string `json:"name"`
+	Rows int    `json:"rows"`
+}
+
+// Reconciliation compares the computed sales total with totals stated by the client or file
+type Reconciliation struct {
+	ComputedTotal Amount                `json:"computed_total"`
+	Reconciled    bool                  `json:"reconciled"`
+	Checks        []ReconciliationCheck `json:"checks"`
+}
+
+// ReconciliationCheck is a single expected total and how far the computed total is from it
+type ReconciliationCheck struct {
+	Source   string `json:"source"`
+	Expected Amount `json:"expected"`
+	Delta    Amount `json:"delta"`
+	Match    bool   `json:"match"`
+}
+
+// BatchUploadResponse is the result of a batch upload: each file's own
+// upload response and the cross-file reconciliation of department totals
+type BatchUploadResponse struct {
+	Success bool              `json:"success"`
+	Message string            `json:"message"`
+	Files   []BatchFileResult `json:"files"`
+	// Reconciliation compares the files that were processed successfully
+	Reconciliation    *BatchReconciliation `json:"reconciliation,omitempty"`
+	ReconciliationURL string               `json:"reconciliation_download_url,omitempty"`
+	// Combined holds the merged result of the processed files, when requested
+	Combined *BatchCombined `json:"combined,omitempty"`
+}
+
+// BatchCombined is the result of merging the processed files of a batch
+// upload into one set of department totals
+type BatchCombined struct {
+	UploadIDs        []string          `json:"upload_ids"`
+	ResultID         string            `json:"result_id"`
+	DownloadURL      string            `json:"download_url"`
+	TotalDepartments int               `json:"total_departments"`
+	TotalSales       Amount            `json:"total_sales"`
+	SalesCount       *int              `json:"sales_count,omitempty"`
+	Rows             RowStats          `json:"rows"`
+	Departments      []DepartmentTotal `json:"departments"`
+}
+
+// BatchFileResult is the outcome of one file of a batch upload
+type BatchFileResult struct {
+	Filename string          `json:"filename"`
+	Success  bool            `json:"success"`
+	Result   *UploadResponse `json:"result,omitempty"`
+	Error    *ErrorResponse  `json:"error,omitempty"`
+}
+
+// BatchReconciliation is a matrix of each department's total per file,
+// flagging departments that some files have and others lack
+type BatchReconciliation struct {
+	// Files names the matrix columns, in upload order
+	Files       []BatchFile       `json:"files"`
+	Departments []BatchDepartment `json:"departments"`
+	// FileTotals is the total of each file, in Files order
+	FileTotals    []Amount `json:"file_totals"`
+	CombinedTotal Amount   `json:"combined_total"`
+	// Complete is set when every department appears in every file
+	Complete bool `json:"complete"`
+}
+
+// BatchFile is one column of a batch reconciliation
+type BatchFile struct {
+	Filename string `json:"filename"`
+	UploadID string `json:"upload_id"`
+}
+
+// BatchDepartment is one row of a batch reconciliation
+type BatchDepartment struct {
+	Department string `json:"department"`
+	// Totals holds the department's total in each file, in Files order; null
+	// where the file has no rows for it
+	Totals        []*Amount `json:"totals"`
+	CombinedTotal Amount    `json:"combined_total"`
+	// MissingFrom lists the files without the department
+	MissingFrom []string `json:"missing_from,omitempty"`
+}
+
+// AggregateResponse represents the result of aggregating several stored uploads
+type AggregateResponse struct {
+	Success          bool     `json:"success"`
+	Message          string   `json:"message"`
+	UploadIDs        []string `json:"upload_ids"`
+	ResultID         string   `json:"result_id"`
+	DownloadURL      string   `json:"download_url"`
+	TotalDepartments int      `json:"total_departments"`
+	TotalSales       Amount   `json:"total_sales"`
+	SalesCount       *int     `json:"sales_count,omitempty"`
+	Rows             RowStats `json:"rows"`
+	ProcessedAt      string   `json:"processed_at"`
+}
+
+// ResultMetadata describes a stored result file and its annotations
+type ResultMetadata struct {
+	ResultID    string `json:"result_id"`
+	DownloadURL string `json:"download_url"`
+	CreatedAt   string `json:"created_at"`
+	Size        int64  `json:"size"`
+	// Pinned results are exempt from retention cleanup
+	Pinned      bool         `json:"pinned"`
+	Annotations []Annotation `json:"annotations"`
+}
+
+// Annotation is a note attached to a result for audit context
+type Annotation struct {
+	ID        string `json:"id"`
+	Note      string `json:"note"`
+	Author    string `json:"author,omitempty"`
+	Tenant    string `json:"tenant"`
+	CreatedAt string `json:"created_at"`
+}
+
+// BuildInfo identifies the deployed build of the server
+type BuildInfo struct {
+	Version   string `json:"version"`
+	Commit    string `json:"commit"`
+	BuildDate string `json:"build_date"`
+	GoVersion string `json:"go_version"`
+	// Features lists the feature flags the build was made with
+	Features []string `json:"features"`
 }
 
 // ErrorResponse represents an error response
@@ -27,4 +369,129 @@ type ErrorResponse struct {
 	Success bool   `json:"success"`
 	Error   string `json:"error"`
 	Code    int    `json:"code"`
+	// ErrorCode identifies errors clients may want to handle specifically
+	ErrorCode   string       `json:"error_code,omitempty"`
+	Rows        *RowStats    `json:"rows,omitempty"`
+	SchemaDrift *SchemaDrift `json:"schema_drift,omitempty"`
+	// Columns traces column detection when it failed and debug was requested
+	Columns []SchemaColumn `json:"columns,omitempty"`
+	// Fields lists the request parameters that failed validation
+	Fields []FieldError `json:"fields,omitempty"`
+	// Violations lists how the upload broke its schema profile
+	Violations []SchemaViolation `json:"violations,omitempty"`
+}
+
+// ErrorCodeContentMismatch is the error code of uploads whose content
+// doesn't match their extension, such as executables or HTML named .csv
+const ErrorCodeContentMismatch = "content_mismatch"
+
+// ErrorCodeSchemaViolation is the error code of uploads that don't match
+// the schema profile they were validated against
+const ErrorCodeSchemaViolation = "schema_violation"
+
+// ErrorCodeUploadInfected is the error code of uploads the upload scanner
+// rejected
+const ErrorCodeUploadInfected = "upload_infected"
+
+// ErrorCodeQuotaExceeded is the error code of uploads of tenants that have
+// used up a quota
+const ErrorCodeQuotaExceeded = "quota_exceeded"
+
+// SchemaViolation is one way an upload broke its schema profile. Row counts
+// the header as row 1, as skipped rows do in error reports.
+type SchemaViolation struct {
+	Row    int    `json:"row"`
+	Column string `json:"column,omitempty"`
+	Rule   string `json:"rule"`
+	Value  string `json:"value,omitempty"`
+	// Expected and Actual name the columns of a column_order violation
+	Expected string `json:"expected,omitempty"`
+	Actual   string `json:"actual,omitempty"`
+	Message  string `json:"message"`
+}
+
+// FieldError describes why a request parameter was rejected
+type FieldError struct {
+	Field string `json:"field"`
+	Error string `json:"error"`
+}
+
+// SchemaReport describes how the CSV header was interpreted
+type SchemaReport struct {
+	Columns             []SchemaColumn `json:"columns"`
+	DepartmentColumn    string         `json:"department_column"`
+	SalesColumn         string         `json:"sales_column"`
+	HeaderNormalization []string       `json:"header_normalization"`
+	ValueNormalization  []string       `json:"value_normalization"`
+	// Encoding is the file's character encoding when it wasn't plain UTF-8,
+	// e.g. utf-16le or utf-8-bom
+	Encoding string `json:"encoding,omitempty"`
+	// Delimiter is the field delimiter, as requested or detected
+	Delimiter string `json:"delimiter"`
+}
+
+// Preview is the header and first rows of a file as processing would read
+// them, for checking the column mapping before uploading the whole file
+type Preview struct {
+	Success bool          `json:"success"`
+	Schema  *SchemaReport `json:"schema"`
+	Rows    []PreviewRow  `json:"rows"`
+	// More reports whether the file has rows after those previewed
+	More bool `json:"more"`
+}
+
+// PreviewRow is a data row of a preview with the values processing would
+// take from it, or why it would be skipped
+type PreviewRow struct {
+	Row        int      `json:"row"`
+	Values     []string `json:"values"`
+	Department string   `json:"department,omitempty"`
+	Sales      *Amount  `json:"sales,omitempty"`
+	SkipReason string   `json:"skip_reason,omitempty"`
+	Detail     string   `json:"detail,omitempty"`
+}
+
+// SchemaColumn is a single header cell before and after normalization
+type SchemaColumn struct {
+	Index      int    `json:"index"`
+	Raw        string `json:"raw"`
+	Normalized string `json:"normalized"`
+	// Match traces the column detection decisions for this cell, when requested
+	Match *ColumnMatch `json:"match,omitempty"`
+}
+
+// ColumnMatch records which synonym rule a header cell matched, if any, and
+// why it was not used for the other roles
+type ColumnMatch struct {
+	// Roles lists the roles the cell was chosen for: department and/or sales
+	Roles []string `json:"roles"`
+	// Rules names the rule that matched for each chosen role
+	Rules []string `json:"rules"`
+	// Rejected explains, per role, why the cell was not chosen for it
+	Rejected []string `json:"rejected"`
+}
+
+// SchemaDrift describes how an upload's header differs from the previous
+// upload of the same feed
+type SchemaDrift struct {
+	Feed             string           `json:"feed"`
+	PreviousUploadID string           `json:"previous_upload_id"`
+	PreviousSeenAt   string           `json:"previous_seen_at"`
+	Detected         bool             `json:"detected"`
+	Added            []string         `json:"added"`
+	Removed          []string         `json:"removed"`
+	Renamed          []ColumnRename   `json:"renamed"`
+	Delimiter        *DelimiterChange `json:"delimiter_changed,omitempty"`
+}
+
+// ColumnRename is a column whose header changed while keeping its position
+type ColumnRename struct {
+	From string `json:"from"`
+	To   string `json:"to"`
+}
+
+// DelimiterChange is a change of the field delimiter between uploads
+type DelimiterChange struct {
+	From string `json:"from"`
+	To   string `json:"to"`
 }
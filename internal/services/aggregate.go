@@ -0,0 +1,43 @@
+package services
+
+import "github.com/mussietl/csv-sales-api/internal/models"
+
+// MergeResults combines the summaries and row counts of several processing
+// runs into one result. Departments keep the order in which they first
+// appear across the runs. Distinct counts and sales statistics cannot be
+// merged and are dropped.
+func MergeResults(results []*ProcessResult) *ProcessResult {
+	merged := &ProcessResult{
+		Rows: models.RowStats{SkipReasons: make(map[string]int)},
+	}
+
+	totals := make(map[string]models.Amount)
+	counts := make(map[string]int)
+	var order []string
+	for _, result := range results {
+		for _, summary := range result.Summaries {
+			if _, ok := totals[summary.Department]; !ok {
+				order = append(order, summary.Department)
+			}
+			totals[summary.Department] = totals[summary.Department].Add(summary.TotalSales)
+			counts[summary.Department] += summary.SalesCount
+		}
+
+		merged.Rows.Total += result.Rows.Total
+		merged.Rows.Processed += result.Rows.Processed
+		merged.Rows.Skipped += result.Rows.Skipped
+		merged.Rows.FooterRows += result.Rows.FooterRows
+		for reason, count := range result.Rows.SkipReasons {
+			merged.Rows.SkipReasons[reason] += count
+		}
+	}
+
+	for _, department := range order {
+		merged.Summaries = append(merged.Summaries, DepartmentSummary{
+			Department: department,
+			TotalSales: totals[department],
+			SalesCount: counts[department],
+		})
+	}
+	return merged
+}
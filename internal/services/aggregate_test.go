@@ -0,0 +1,30 @@
+package services
+
+import (
+	"testing"
+
+	"github.com/mussietl/csv-sales-api/internal/models"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestMergeResults(t *testing.T) {
+	merged := MergeResults([]*ProcessResult{
+		{
+			Summaries: []DepartmentSummary{{Department: "Toys", TotalSales: models.WholeAmount(5)}, {Department: "Art", TotalSales: models.WholeAmount(2)}},
+			Rows:      models.RowStats{Total: 3, Processed: 2, Skipped: 1, SkipReasons: map[string]int{SkipInvalidSales: 1}},
+		},
+		{
+			Summaries: []DepartmentSummary{{Department: "Books", TotalSales: models.WholeAmount(1)}, {Department: "Toys", TotalSales: models.WholeAmount(10)}},
+			Rows:      models.RowStats{Total: 2, Processed: 2},
+		},
+	})
+
+	assert.Equal(t, []DepartmentSummary{
+		{Department: "Toys", TotalSales: models.WholeAmount(15)},
+		{Department: "Art", TotalSales: models.WholeAmount(2)},
+		{Department: "Books", TotalSales: models.WholeAmount(1)},
+	}, merged.Summaries)
+	assert.Equal(t, 5, merged.Rows.Total)
+	assert.Equal(t, 4, merged.Rows.Processed)
+	assert.Equal(t, map[string]int{SkipInvalidSales: 1}, merged.Rows.SkipReasons)
+}
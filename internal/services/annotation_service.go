@@ -0,0 +1,109 @@
+package services
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/mussietl/csv-sales-api/internal/models"
+	"github.com/sirupsen/logrus"
+)
+
+// MaxAnnotationLength is the longest note accepted, in bytes
+const MaxAnnotationLength = 4096
+
+// AnnotationService stores notes attached to results, one JSON file per result
+type AnnotationService struct {
+	dir    string
+	mu     sync.Mutex
+	logger *logrus.Logger
+}
+
+// NewAnnotationService creates a new AnnotationService storing notes in dir
+func NewAnnotationService(dir string, logger *logrus.Logger) *AnnotationService {
+	return &AnnotationService{
+		dir:    dir,
+		logger: logger,
+	}
+}
+
+// Add attaches a note to a result. resultID must already have been validated.
+func (as *AnnotationService) Add(resultID, note, author, tenant string) (*models.Annotation, error) {
+	note = strings.TrimSpace(note)
+	if note == "" {
+		return nil, fmt.Errorf("note is required")
+	}
+	if len(note) > MaxAnnotationLength {
+		return nil, fmt.Errorf("note is longer than %d bytes", MaxAnnotationLength)
+	}
+
+	annotation := models.Annotation{
+		ID:        uuid.New().String(),
+		Note:      note,
+		Author:    author,
+		Tenant:    tenant,
+		CreatedAt: time.Now().UTC().Format(time.RFC3339),
+	}
+
+	as.mu.Lock()
+	defer as.mu.Unlock()
+	annotations, err := as.load(resultID)
+	if err != nil {
+		return nil, err
+	}
+	annotations = append(annotations, annotation)
+
+	data, err := json.MarshalIndent(annotations, "", "  ")
+	if err != nil {
+		return nil, fmt.Errorf("failed to encode annotations: %w", err)
+	}
+	if err := writeFileAtomic(as.path(resultID), data, 0600); err != nil {
+		return nil, err
+	}
+
+	as.logger.Infof("Added annotation %s to result %s", annotation.ID, resultID)
+	return &annotation, nil
+}
+
+// List returns a result's notes, oldest first
+func (as *AnnotationService) List(resultID string) ([]models.Annotation, error) {
+	as.mu.Lock()
+	defer as.mu.Unlock()
+	return as.load(resultID)
+}
+
+// Delete removes every note attached to a result
+func (as *AnnotationService) Delete(resultID string) error {
+	as.mu.Lock()
+	defer as.mu.Unlock()
+	if err := os.Remove(as.path(resultID)); err != nil && !errors.Is(err, os.ErrNotExist) {
+		return fmt.Errorf("failed to delete annotations: %w", err)
+	}
+	return nil
+}
+
+// load reads a result's notes; a missing file means none. Callers must hold as.mu.
+func (as *AnnotationService) load(resultID string) ([]models.Annotation, error) {
+	annotations := []models.Annotation{}
+	data, err := os.ReadFile(as.path(resultID))
+	if errors.Is(err, os.ErrNotExist) {
+		return annotations, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to read annotations: %w", err)
+	}
+	if err := json.Unmarshal(data, &annotations); err != nil {
+		return nil, fmt.Errorf("failed to decode annotations: %w", err)
+	}
+	return annotations, nil
+}
+
+func (as *AnnotationService) path(resultID string) string {
+	return filepath.Join(as.dir, resultID+".json")
+}
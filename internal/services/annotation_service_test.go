@@ -0,0 +1,39 @@
+package services
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/sirupsen/logrus"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestAnnotationService(t *testing.T) {
+	logger := logrus.New()
+	logger.SetLevel(logrus.ErrorLevel)
+	as := NewAnnotationService(t.TempDir(), logger)
+	resultID := "0b8f3f7e-4a59-4d43-9a5b-3c4f1e2d9a10"
+
+	annotations, err := as.List(resultID)
+	require.NoError(t, err)
+	assert.Empty(t, annotations)
+
+	first, err := as.Add(resultID, "  June restated after refund correction ", "finance", "acme")
+	require.NoError(t, err)
+	assert.Equal(t, "June restated after refund correction", first.Note)
+	_, err = as.Add(resultID, "Approved", "", "acme")
+	require.NoError(t, err)
+
+	annotations, err = as.List(resultID)
+	require.NoError(t, err)
+	require.Len(t, annotations, 2)
+	assert.Equal(t, first.ID, annotations[0].ID)
+	assert.Equal(t, "finance", annotations[0].Author)
+	assert.Equal(t, "Approved", annotations[1].Note)
+
+	_, err = as.Add(resultID, "   ", "", "acme")
+	assert.Error(t, err)
+	_, err = as.Add(resultID, strings.Repeat("x", MaxAnnotationLength+1), "", "acme")
+	assert.Error(t, err)
+}
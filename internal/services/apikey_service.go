@@ -0,0 +1,480 @@
+package services
+
+import (
+	"crypto/rand"
+	"crypto/sha256"
+	"crypto/subtle"
+	"database/sql"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/sirupsen/logrus"
+)
+
+// API key scopes
+const (
+	ScopeUpload = "upload"
+	ScopeRead   = "read"
+	ScopeAdmin  = "admin"
+)
+
+// apiKeyPrefix marks plaintext keys issued by this service
+const apiKeyPrefix = "csk_"
+
+// lastUsedPersistInterval limits how often last-used timestamps are written to the database
+const lastUsedPersistInterval = time.Minute
+
+// Errors returned by APIKeyService
+var (
+	ErrAPIKeyNotFound = errors.New("api key not found")
+	ErrAPIKeyInvalid  = errors.New("invalid api key")
+	ErrAPIKeyDisabled = errors.New("api key is disabled")
+	ErrAPIKeyExpired  = errors.New("api key has expired")
+	ErrAPIKeyScope    = errors.New("api key lacks required scope")
+)
+
+// APIKey is a stored API key. Only the SHA-256 hash of the key is kept.
+type APIKey struct {
+	ID     string   `json:"id"`
+	Name   string   `json:"name"`
+	Prefix string   `json:"prefix"`
+	Hash   string   `json:"-"`
+	Scopes []string `json:"scopes"`
+	// Tenant, when set, is the only tenant the key's requests act for
+	Tenant     string     `json:"tenant,omitempty"`
+	CreatedAt  time.Time  `json:"created_at"`
+	ExpiresAt  *time.Time `json:"expires_at,omitempty"`
+	LastUsedAt *time.Time `json:"last_used_at,omitempty"`
+	RotatedAt  *time.Time `json:"rotated_at,omitempty"`
+	Disabled   bool       `json:"disabled"`
+}
+
+// HasScope reports whether the key grants a scope. Admin keys grant every scope.
+func (k *APIKey) HasScope(scope string) bool {
+	for _, s := range k.Scopes {
+		if s == scope || s == ScopeAdmin {
+			return true
+		}
+	}
+	return false
+}
+
+// storedAPIKey is the form of an APIKey in the legacy JSON file, including
+// its hash
+type storedAPIKey struct {
+	APIKey
+	Hash string `json:"hash"`
+}
+
+// APIKeyService manages API keys stored in the history database. Keys are
+// cached in memory, so authenticating a request doesn't query the database.
+type APIKeyService struct {
+	db            *sql.DB
+	mu            sync.Mutex
+	keys          map[string]*APIKey
+	lastPersisted map[string]time.Time
+	logger        *logrus.Logger
+}
+
+// NewAPIKeyService creates a new APIKeyService, loading existing keys from
+// the history database. Keys of the JSON file at legacyPath, where earlier
+// versions kept them, are imported once when the database has none; the
+// file is then renamed with an .imported suffix.
+func NewAPIKeyService(history *HistoryRepository, legacyPath string, logger *logrus.Logger) (*APIKeyService, error) {
+	ks := &APIKeyService{
+		db:            history.db,
+		keys:          make(map[string]*APIKey),
+		lastPersisted: make(map[string]time.Time),
+		logger:        logger,
+	}
+	if err := ks.load(); err != nil {
+		return nil, err
+	}
+	if len(ks.keys) == 0 && legacyPath != "" {
+		if err := ks.importFile(legacyPath); err != nil {
+			return nil, err
+		}
+	}
+	return ks, nil
+}
+
+// ValidateScopes checks that every scope is known
+func ValidateScopes(scopes []string) error {
+	if len(scopes) == 0 {
+		return fmt.Errorf("at least one scope is required")
+	}
+	for _, scope := range scopes {
+		switch scope {
+		case ScopeUpload, ScopeRead, ScopeAdmin:
+		default:
+			return fmt.Errorf("unknown scope %q: expected upload, read or admin", scope)
+		}
+	}
+	return nil
+}
+
+// ValidateKeyTenant checks the tenant of a key with the given scopes. Admin
+// keys manage every tenant, so they can't be bound to one.
+func ValidateKeyTenant(tenant string, scopes []string) error {
+	if tenant == "" {
+		return nil
+	}
+	if !ValidTenantID(tenant) {
+		return fmt.Errorf("tenant must be 1-64 letters, digits, '.', '_' or '-'")
+	}
+	for _, scope := range scopes {
+		if scope == ScopeAdmin {
+			return fmt.Errorf("admin keys can't be bound to a tenant")
+		}
+	}
+	return nil
+}
+
+// Create issues a new key and returns its plaintext value, which is not
+// stored. A key with a tenant acts only for that tenant.
+func (ks *APIKeyService) Create(name, tenant string, scopes []string, expiresAt *time.Time) (string, *APIKey, error) {
+	if err := ValidateScopes(scopes); err != nil {
+		return "", nil, err
+	}
+	if err := ValidateKeyTenant(tenant, scopes); err != nil {
+		return "", nil, err
+	}
+
+	plaintext, err := generateAPIKey()
+	if err != nil {
+		return "", nil, err
+	}
+
+	key := &APIKey{
+		ID:        uuid.New().String(),
+		Name:      name,
+		Prefix:    plaintext[:len(apiKeyPrefix)+6],
+		Hash:      hashAPIKey(plaintext),
+		Scopes:    scopes,
+		Tenant:    tenant,
+		CreatedAt: time.Now().UTC(),
+		ExpiresAt: expiresAt,
+	}
+
+	ks.mu.Lock()
+	defer ks.mu.Unlock()
+	if err := ks.save(key); err != nil {
+		return "", nil, err
+	}
+	ks.keys[key.ID] = key
+
+	ks.logger.Infof("Created API key %s (%s)", key.ID, key.Name)
+	copied := *key
+	return plaintext, &copied, nil
+}
+
+// Import stores an externally provided plaintext key, e.g. from the
+// environment, unless a key with the same hash already exists
+func (ks *APIKeyService) Import(name, plaintext string, scopes []string) error {
+	hash := hashAPIKey(plaintext)
+
+	ks.mu.Lock()
+	defer ks.mu.Unlock()
+	for _, key := range ks.keys {
+		if key.Hash == hash {
+			return nil
+		}
+	}
+
+	prefix := plaintext
+	if len(prefix) > 6 {
+		prefix = prefix[:6]
+	}
+	key := &APIKey{
+		ID:        uuid.New().String(),
+		Name:      name,
+		Prefix:    prefix,
+		Hash:      hash,
+		Scopes:    scopes,
+		CreatedAt: time.Now().UTC(),
+	}
+	if err := ks.save(key); err != nil {
+		return err
+	}
+	ks.keys[key.ID] = key
+	ks.logger.Infof("Imported API key %s (%s)", key.ID, key.Name)
+	return nil
+}
+
+// Rotate replaces a key's secret, keeping its ID, name and scopes
+func (ks *APIKeyService) Rotate(id string) (string, *APIKey, error) {
+	plaintext, err := generateAPIKey()
+	if err != nil {
+		return "", nil, err
+	}
+
+	ks.mu.Lock()
+	defer ks.mu.Unlock()
+	key, ok := ks.keys[id]
+	if !ok {
+		return "", nil, ErrAPIKeyNotFound
+	}
+
+	previous := *key
+	now := time.Now().UTC()
+	key.Prefix = plaintext[:len(apiKeyPrefix)+6]
+	key.Hash = hashAPIKey(plaintext)
+	key.RotatedAt = &now
+	if err := ks.save(key); err != nil {
+		*key = previous
+		return "", nil, err
+	}
+
+	ks.logger.Infof("Rotated API key %s", key.ID)
+	copied := *key
+	return plaintext, &copied, nil
+}
+
+// SetDisabled enables or disables a key
+func (ks *APIKeyService) SetDisabled(id string, disabled bool) (*APIKey, error) {
+	ks.mu.Lock()
+	defer ks.mu.Unlock()
+	key, ok := ks.keys[id]
+	if !ok {
+		return nil, ErrAPIKeyNotFound
+	}
+
+	key.Disabled = disabled
+	if err := ks.save(key); err != nil {
+		key.Disabled = !disabled
+		return nil, err
+	}
+
+	ks.logger.Infof("API key %s disabled=%t", key.ID, disabled)
+	copied := *key
+	return &copied, nil
+}
+
+// List returns all keys ordered by creation time
+func (ks *APIKeyService) List() []APIKey {
+	ks.mu.Lock()
+	defer ks.mu.Unlock()
+
+	keys := make([]APIKey, 0, len(ks.keys))
+	for _, key := range ks.keys {
+		keys = append(keys, *key)
+	}
+	sort.Slice(keys, func(i, j int) bool {
+		return keys[i].CreatedAt.Before(keys[j].CreatedAt)
+	})
+	return keys
+}
+
+// Count returns the number of stored keys
+func (ks *APIKeyService) Count() int {
+	ks.mu.Lock()
+	defer ks.mu.Unlock()
+	return len(ks.keys)
+}
+
+// Authenticate resolves a plaintext key, checks it grants scope and records its use
+func (ks *APIKeyService) Authenticate(plaintext, scope string) (*APIKey, error) {
+	hash := hashAPIKey(plaintext)
+
+	ks.mu.Lock()
+	defer ks.mu.Unlock()
+
+	var found *APIKey
+	for _, key := range ks.keys {
+		if subtle.ConstantTimeCompare([]byte(key.Hash), []byte(hash)) == 1 {
+			found = key
+		}
+	}
+	if found == nil {
+		return nil, ErrAPIKeyInvalid
+	}
+	if found.Disabled {
+		return nil, ErrAPIKeyDisabled
+	}
+	now := time.Now().UTC()
+	if found.ExpiresAt != nil && now.After(*found.ExpiresAt) {
+		return nil, ErrAPIKeyExpired
+	}
+	if scope != "" && !found.HasScope(scope) {
+		return nil, ErrAPIKeyScope
+	}
+
+	found.LastUsedAt = &now
+	if now.Sub(ks.lastPersisted[found.ID]) >= lastUsedPersistInterval {
+		ks.lastPersisted[found.ID] = now
+		if err := ks.save(found); err != nil {
+			ks.logger.Warnf("Failed to persist API key last-used time: %v", err)
+		}
+	}
+
+	copied := *found
+	return &copied, nil
+}
+
+// apiKeyColumns are the columns of api_keys, in the order scanned by load
+const apiKeyColumns = `id, name, prefix, hash, scopes, tenant, created_at, expires_at, last_used_at, rotated_at, disabled`
+
+// load reads every key from the database
+func (ks *APIKeyService) load() error {
+	rows, err := ks.db.Query(`SELECT ` + apiKeyColumns + ` FROM api_keys`)
+	if err != nil {
+		return fmt.Errorf("failed to read api keys: %w", err)
+	}
+	defer rows.Close()
+	for rows.Next() {
+		var key APIKey
+		var scopes, createdAt string
+		var expiresAt, lastUsedAt, rotatedAt sql.NullString
+		var disabled int
+		if err := rows.Scan(&key.ID, &key.Name, &key.Prefix, &key.Hash, &scopes, &key.Tenant,
+			&createdAt, &expiresAt, &lastUsedAt, &rotatedAt, &disabled); err != nil {
+			return fmt.Errorf("failed to read api keys: %w", err)
+		}
+		if err := json.Unmarshal([]byte(scopes), &key.Scopes); err != nil {
+			return fmt.Errorf("invalid scopes of api key %s: %w", key.ID, err)
+		}
+		if key.CreatedAt, err = time.Parse(historyTimeLayout, createdAt); err != nil {
+			return fmt.Errorf("invalid time in api key %s: %w", key.ID, err)
+		}
+		for _, field := range []struct {
+			value sql.NullString
+			at    **time.Time
+		}{{expiresAt, &key.ExpiresAt}, {lastUsedAt, &key.LastUsedAt}, {rotatedAt, &key.RotatedAt}} {
+			if !field.value.Valid {
+				continue
+			}
+			at, err := time.Parse(historyTimeLayout, field.value.String)
+			if err != nil {
+				return fmt.Errorf("invalid time in api key %s: %w", key.ID, err)
+			}
+			*field.at = &at
+		}
+		key.Disabled = disabled != 0
+		ks.keys[key.ID] = &key
+	}
+	if err := rows.Err(); err != nil {
+		return fmt.Errorf("failed to read api keys: %w", err)
+	}
+	return nil
+}
+
+// save inserts or updates a key. Callers must hold ks.mu.
+func (ks *APIKeyService) save(key *APIKey) error {
+	scopes, err := json.Marshal(key.Scopes)
+	if err != nil {
+		return fmt.Errorf("failed to encode api key scopes: %w", err)
+	}
+	disabled := 0
+	if key.Disabled {
+		disabled = 1
+	}
+	_, err = ks.db.Exec(`INSERT INTO api_keys (`+apiKeyColumns+`)
+		VALUES ($1, $2, $3, $4, $5, $6, $7, $8, $9, $10, $11)
+		ON CONFLICT (id) DO UPDATE SET name = $2, prefix = $3, hash = $4, scopes = $5, tenant = $6,
+			expires_at = $8, last_used_at = $9, rotated_at = $10, disabled = $11`,
+		key.ID, key.Name, key.Prefix, key.Hash, string(scopes), key.Tenant, formatHistoryTime(key.CreatedAt),
+		nullableHistoryTime(key.ExpiresAt), nullableHistoryTime(key.LastUsedAt), nullableHistoryTime(key.RotatedAt), disabled)
+	if err != nil {
+		return fmt.Errorf("failed to save api key %s: %w", key.ID, err)
+	}
+	return nil
+}
+
+// ReadAPIKeyFile reads the keys of a JSON file written by earlier versions;
+// a missing file has no keys
+func ReadAPIKeyFile(path string) ([]APIKey, error) {
+	data, err := os.ReadFile(path)
+	if errors.Is(err, os.ErrNotExist) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to read api keys: %w", err)
+	}
+
+	var stored []storedAPIKey
+	if err := json.Unmarshal(data, &stored); err != nil {
+		return nil, fmt.Errorf("failed to decode api keys: %w", err)
+	}
+	keys := make([]APIKey, 0, len(stored))
+	for _, s := range stored {
+		key := s.APIKey
+		key.Hash = s.Hash
+		keys = append(keys, key)
+	}
+	return keys, nil
+}
+
+// importFile moves the keys of a legacy JSON file into the database
+func (ks *APIKeyService) importFile(path string) error {
+	keys, err := ReadAPIKeyFile(path)
+	if err != nil || len(keys) == 0 {
+		return err
+	}
+	ks.mu.Lock()
+	defer ks.mu.Unlock()
+	for i := range keys {
+		key := &keys[i]
+		if err := ks.save(key); err != nil {
+			return fmt.Errorf("failed to import api keys from %s: %w", path, err)
+		}
+		ks.keys[key.ID] = key
+	}
+	if err := os.Rename(path, path+".imported"); err != nil {
+		return fmt.Errorf("failed to rename imported api keys file: %w", err)
+	}
+	ks.logger.Infof("Imported %d API keys from %s into the history database", len(keys), path)
+	return nil
+}
+
+// generateAPIKey returns a new random plaintext key
+func generateAPIKey() (string, error) {
+	buf := make([]byte, 24)
+	if _, err := rand.Read(buf); err != nil {
+		return "", fmt.Errorf("failed to generate api key: %w", err)
+	}
+	return apiKeyPrefix + hex.EncodeToString(buf), nil
+}
+
+// hashAPIKey returns the hex SHA-256 of a plaintext key
+func hashAPIKey(plaintext string) string {
+	sum := sha256.Sum256([]byte(strings.TrimSpace(plaintext)))
+	return hex.EncodeToString(sum[:])
+}
+
+// writeFileAtomic writes data to a temp file and renames it over path
+func writeFileAtomic(path string, data []byte, perm os.FileMode) error {
+	if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+		return fmt.Errorf("failed to create directory: %w", err)
+	}
+
+	tmp, err := os.CreateTemp(filepath.Dir(path), filepath.Base(path)+".*.tmp")
+	if err != nil {
+		return fmt.Errorf("failed to create temp file: %w", err)
+	}
+	defer os.Remove(tmp.Name())
+
+	if _, err := tmp.Write(data); err != nil {
+		tmp.Close()
+		return fmt.Errorf("failed to write temp file: %w", err)
+	}
+	if err := tmp.Chmod(perm); err != nil {
+		tmp.Close()
+		return fmt.Errorf("failed to set file mode: %w", err)
+	}
+	if err := tmp.Close(); err != nil {
+		return fmt.Errorf("failed to close temp file: %w", err)
+	}
+	if err := os.Rename(tmp.Name(), path); err != nil {
+		return fmt.Errorf("failed to replace file: %w", err)
+	}
+	return nil
+}
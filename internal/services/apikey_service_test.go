@@ -0,0 +1,125 @@
+package services
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/sirupsen/logrus"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func newTestAPIKeyService(t *testing.T) (*APIKeyService, *HistoryRepository) {
+	logger := logrus.New()
+	logger.SetLevel(logrus.ErrorLevel)
+	history, err := NewHistoryRepository(filepath.Join(t.TempDir(), "history.db"), logger)
+	require.NoError(t, err)
+	t.Cleanup(func() { history.Close() })
+	ks, err := NewAPIKeyService(history, "", logger)
+	require.NoError(t, err)
+	return ks, history
+}
+
+func TestAPIKeyServiceLifecycle(t *testing.T) {
+	ks, history := newTestAPIKeyService(t)
+
+	plaintext, key, err := ks.Create("ci", "", []string{ScopeUpload}, nil)
+	require.NoError(t, err)
+	assert.True(t, strings.HasPrefix(plaintext, apiKeyPrefix))
+	assert.True(t, strings.HasPrefix(plaintext, key.Prefix))
+
+	// Only the hash is persisted
+	var hash string
+	require.NoError(t, history.db.QueryRow(`SELECT hash FROM api_keys WHERE id = $1`, key.ID).Scan(&hash))
+	assert.Equal(t, hashAPIKey(plaintext), hash)
+
+	authed, err := ks.Authenticate(plaintext, ScopeUpload)
+	require.NoError(t, err)
+	assert.NotNil(t, authed.LastUsedAt)
+
+	_, err = ks.Authenticate(plaintext, ScopeAdmin)
+	assert.ErrorIs(t, err, ErrAPIKeyScope)
+
+	rotated, _, err := ks.Rotate(key.ID)
+	require.NoError(t, err)
+	_, err = ks.Authenticate(plaintext, ScopeUpload)
+	assert.ErrorIs(t, err, ErrAPIKeyInvalid)
+	_, err = ks.Authenticate(rotated, ScopeUpload)
+	assert.NoError(t, err)
+
+	_, err = ks.SetDisabled(key.ID, true)
+	require.NoError(t, err)
+	_, err = ks.Authenticate(rotated, ScopeUpload)
+	assert.ErrorIs(t, err, ErrAPIKeyDisabled)
+
+	// Keys survive a reload
+	reloaded, err := NewAPIKeyService(history, "", ks.logger)
+	require.NoError(t, err)
+	keys := reloaded.List()
+	require.Len(t, keys, 1)
+	assert.True(t, keys[0].Disabled)
+	assert.Equal(t, hashAPIKey(rotated), keys[0].Hash)
+	assert.Equal(t, []string{ScopeUpload}, keys[0].Scopes)
+	assert.NotNil(t, keys[0].LastUsedAt)
+	assert.NotNil(t, keys[0].RotatedAt)
+}
+
+func TestAPIKeyServiceImportsLegacyFile(t *testing.T) {
+	logger := logrus.New()
+	logger.SetLevel(logrus.ErrorLevel)
+	dir := t.TempDir()
+	expires := time.Date(2030, 1, 1, 0, 0, 0, 0, time.UTC)
+	legacy := `[{"id":"k1","name":"ci","prefix":"csk_abcdef","hash":"` + hashAPIKey("csk_legacy") + `","scopes":["upload"],"tenant":"acme",` +
+		`"created_at":"2024-05-01T10:00:00Z","expires_at":"2030-01-01T00:00:00Z","disabled":false}]`
+	path := filepath.Join(dir, "api_keys.json")
+	require.NoError(t, os.WriteFile(path, []byte(legacy), 0600))
+
+	history, err := NewHistoryRepository(filepath.Join(dir, "history.db"), logger)
+	require.NoError(t, err)
+	defer history.Close()
+	ks, err := NewAPIKeyService(history, path, logger)
+	require.NoError(t, err)
+	key, err := ks.Authenticate("csk_legacy", ScopeUpload)
+	require.NoError(t, err)
+	assert.Equal(t, "acme", key.Tenant)
+	assert.Equal(t, expires, *key.ExpiresAt)
+
+	// The file is imported once and kept aside
+	_, err = os.Stat(path)
+	assert.ErrorIs(t, err, os.ErrNotExist)
+	_, err = os.Stat(path + ".imported")
+	assert.NoError(t, err)
+	reloaded, err := NewAPIKeyService(history, path, logger)
+	require.NoError(t, err)
+	assert.Equal(t, 1, reloaded.Count())
+
+	require.NoError(t, os.WriteFile(path, []byte("not json"), 0600))
+	_, err = ReadAPIKeyFile(path)
+	assert.Error(t, err)
+}
+
+func TestAPIKeyServiceExpiryAndImport(t *testing.T) {
+	ks, _ := newTestAPIKeyService(t)
+
+	past := time.Now().Add(-time.Hour)
+	plaintext, _, err := ks.Create("old", "", []string{ScopeRead}, &past)
+	require.NoError(t, err)
+	_, err = ks.Authenticate(plaintext, ScopeRead)
+	assert.ErrorIs(t, err, ErrAPIKeyExpired)
+
+	require.NoError(t, ks.Import("env-admin", "bootstrap-secret", []string{ScopeAdmin}))
+	require.NoError(t, ks.Import("env-admin", "bootstrap-secret", []string{ScopeAdmin}))
+	assert.Equal(t, 2, ks.Count())
+
+	admin, err := ks.Authenticate("bootstrap-secret", ScopeUpload)
+	require.NoError(t, err)
+	assert.True(t, admin.HasScope(ScopeRead))
+
+	_, _, err = ks.Create("bad", "", []string{"delete"}, nil)
+	assert.Error(t, err)
+	_, _, err = ks.Rotate("missing")
+	assert.ErrorIs(t, err, ErrAPIKeyNotFound)
+}
@@ -0,0 +1,123 @@
+package services
+
+import (
+	"errors"
+	"os"
+	"path/filepath"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/sirupsen/logrus"
+)
+
+// ErrArchiveQueueFull is returned when a file is archived while the
+// archive queue is full or closed
+var ErrArchiveQueueFull = errors.New("archive queue is full")
+
+// archiveAttempts is how many times a file is saved to storage before the
+// archiver gives up on it
+const archiveAttempts = 3
+
+// Archiver saves uploads to the storage backend in the background, so slow
+// object store writes don't add to the time clients wait for their results.
+// Uploads are processed from their working copy in the meantime.
+type Archiver struct {
+	persist func(path string) error
+	tasks   chan string
+	backoff time.Duration
+	pending atomic.Int64
+	mu      sync.RWMutex
+	closed  bool
+	wg      sync.WaitGroup
+	logger  *logrus.Logger
+}
+
+// NewArchiver starts workers that save queued files with persist; at most
+// capacity files wait
+func NewArchiver(persist func(path string) error, workers, capacity int, logger *logrus.Logger) *Archiver {
+	if workers < 1 {
+		workers = 1
+	}
+	if capacity < 0 {
+		capacity = 0
+	}
+	a := &Archiver{
+		persist: persist,
+		tasks:   make(chan string, capacity),
+		backoff: time.Second,
+		logger:  logger,
+	}
+	for i := 0; i < workers; i++ {
+		a.wg.Add(1)
+		go a.work()
+	}
+	return a
+}
+
+// Archive queues a file to be saved to storage. It fails with
+// ErrArchiveQueueFull rather than wait, so the caller can save it itself.
+func (a *Archiver) Archive(path string) error {
+	a.mu.RLock()
+	defer a.mu.RUnlock()
+	if a.closed {
+		return ErrArchiveQueueFull
+	}
+	select {
+	case a.tasks <- path:
+		a.pending.Add(1)
+		return nil
+	default:
+		return ErrArchiveQueueFull
+	}
+}
+
+// Pending returns the number of files queued or being saved
+func (a *Archiver) Pending() int {
+	return int(a.pending.Load())
+}
+
+// work saves queued files until the archiver is closed
+func (a *Archiver) work() {
+	defer a.wg.Done()
+	for path := range a.tasks {
+		a.archive(path)
+		a.pending.Add(-1)
+	}
+}
+
+// archive saves a file, retrying with a growing delay. Files deleted before
+// their turn are dropped.
+func (a *Archiver) archive(path string) {
+	start := time.Now()
+	for attempt := 1; ; attempt++ {
+		if _, err := os.Stat(path); errors.Is(err, os.ErrNotExist) {
+			a.logger.Warnf("Not archiving %s: it was deleted", filepath.Base(path))
+			return
+		}
+		err := a.persist(path)
+		if err == nil {
+			a.logger.Infof("Archived %s in %v", filepath.Base(path), time.Since(start))
+			return
+		}
+		if attempt == archiveAttempts {
+			a.logger.Errorf("Failed to archive %s after %d attempts: %v", filepath.Base(path), attempt, err)
+			return
+		}
+		a.logger.Warnf("Failed to archive %s, retrying: %v", filepath.Base(path), err)
+		time.Sleep(a.backoff * time.Duration(attempt))
+	}
+}
+
+// Close stops accepting files and waits for queued ones to be saved
+func (a *Archiver) Close() {
+	a.mu.Lock()
+	if a.closed {
+		a.mu.Unlock()
+		return
+	}
+	a.closed = true
+	close(a.tasks)
+	a.mu.Unlock()
+	a.wg.Wait()
+}
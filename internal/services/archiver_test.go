@@ -0,0 +1,87 @@
+package services
+
+import (
+	"context"
+	"errors"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/sirupsen/logrus"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestArchiver(t *testing.T) {
+	logger := logrus.New()
+	logger.SetLevel(logrus.PanicLevel)
+	dir := t.TempDir()
+
+	var mu sync.Mutex
+	attempts := map[string]int{}
+	release := make(chan struct{})
+	persist := func(path string) error {
+		<-release
+		mu.Lock()
+		defer mu.Unlock()
+		attempts[filepath.Base(path)]++
+		if filepath.Base(path) == "flaky.csv" && attempts["flaky.csv"] < 2 {
+			return errors.New("slow down")
+		}
+		if filepath.Base(path) == "broken.csv" {
+			return errors.New("access denied")
+		}
+		return nil
+	}
+	archiver := NewArchiver(persist, 1, 3, logger)
+	archiver.backoff = 0
+
+	for _, name := range []string{"flaky.csv", "broken.csv", "gone.csv"} {
+		require.NoError(t, os.WriteFile(filepath.Join(dir, name), []byte("Department,Sales\n"), 0600))
+	}
+	// Once the worker holds the first file, three more fill the queue
+	require.NoError(t, archiver.Archive(filepath.Join(dir, "flaky.csv")))
+	require.Eventually(t, func() bool { return len(archiver.tasks) == 0 }, time.Second, time.Millisecond)
+	for _, name := range []string{"broken.csv", "gone.csv", "gone.csv"} {
+		require.NoError(t, archiver.Archive(filepath.Join(dir, name)))
+	}
+	assert.ErrorIs(t, archiver.Archive(filepath.Join(dir, "late.csv")), ErrArchiveQueueFull)
+	assert.Equal(t, 4, archiver.Pending())
+	require.NoError(t, os.Remove(filepath.Join(dir, "gone.csv")))
+
+	close(release)
+	archiver.Close()
+	assert.Equal(t, map[string]int{"flaky.csv": 2, "broken.csv": archiveAttempts}, attempts)
+	assert.Zero(t, archiver.Pending())
+	assert.ErrorIs(t, archiver.Archive(filepath.Join(dir, "flaky.csv")), ErrArchiveQueueFull)
+}
+
+func TestFileServiceArchiver(t *testing.T) {
+	logger := logrus.New()
+	logger.SetLevel(logrus.PanicLevel)
+	fs := NewFileService(t.TempDir(), logger)
+	storage := NewLocalStorage(t.TempDir())
+	fs.SetStorage(storage, 0)
+
+	release := make(chan struct{})
+	archiver := NewArchiver(func(path string) error {
+		<-release
+		return fs.Persist(path)
+	}, 1, 10, logger)
+	fs.SetArchiver(archiver)
+
+	path, err := fs.SaveUpload(context.Background(), "sales.csv", strings.NewReader("Department,Sales\nBooks,1\n"))
+	require.NoError(t, err)
+	assert.FileExists(t, path, "the working copy is written before returning")
+	_, err = storage.Open(filepath.Base(path))
+	assert.ErrorIs(t, err, ErrObjectNotFound, "the upload is archived after returning")
+
+	close(release)
+	archiver.Close()
+	stored, err := storage.Open(filepath.Base(path))
+	require.NoError(t, err)
+	stored.Close()
+}
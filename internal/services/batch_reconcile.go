@@ -0,0 +1,112 @@
+package services
+
+import (
+	"fmt"
+	"os"
+	"sort"
+	"strings"
+
+	"github.com/mussietl/csv-sales-api/internal/models"
+)
+
+// BatchFileTotals holds the department totals of one file of a batch
+type BatchFileTotals struct {
+	File      models.BatchFile
+	Summaries []DepartmentSummary
+}
+
+// ReconcileBatch builds the reconciliation matrix of a batch: every
+// department found in any file, sorted by name, with its total in each file
+// and the files it is missing from
+func ReconcileBatch(files []BatchFileTotals) *models.BatchReconciliation {
+	r := &models.BatchReconciliation{
+		Files:      make([]models.BatchFile, len(files)),
+		FileTotals: make([]models.Amount, len(files)),
+		Complete:   true,
+	}
+
+	rows := make(map[string]*models.BatchDepartment)
+	var names []string
+	for i, file := range files {
+		r.Files[i] = file.File
+		for _, summary := range file.Summaries {
+			row, ok := rows[summary.Department]
+			if !ok {
+				row = &models.BatchDepartment{Department: summary.Department, Totals: make([]*models.Amount, len(files))}
+				rows[summary.Department] = row
+				names = append(names, summary.Department)
+			}
+			total := summary.TotalSales
+			row.Totals[i] = &total
+			row.CombinedTotal = row.CombinedTotal.Add(summary.TotalSales)
+			r.FileTotals[i] = r.FileTotals[i].Add(summary.TotalSales)
+		}
+		r.CombinedTotal = r.CombinedTotal.Add(r.FileTotals[i])
+	}
+
+	sort.Strings(names)
+	r.Departments = make([]models.BatchDepartment, len(names))
+	for i, name := range names {
+		row := rows[name]
+		for j, total := range row.Totals {
+			if total == nil {
+				row.MissingFrom = append(row.MissingFrom, files[j].File.Filename)
+			}
+		}
+		if len(row.MissingFrom) > 0 {
+			r.Complete = false
+		}
+		r.Departments[i] = *row
+	}
+	return r
+}
+
+// SaveBatchReconciliationFile saves a batch reconciliation matrix to
+// reconciliation_<uuid>.csv: a column per file, then the combined total and
+// the files each department is missing from. Missing totals are left empty.
+func (fs *FileService) SaveBatchReconciliationFile(r *models.BatchReconciliation, format NumberFormat) (string, error) {
+	file, err := fs.CreateOutputFile("reconciliation", "csv")
+	if err != nil {
+		return "", err
+	}
+	defer file.Close()
+
+	header := []string{"Department Name"}
+	for _, f := range r.Files {
+		header = append(header, quoteCSVField(f.Filename))
+	}
+	header = append(header, "Combined Total", "Missing From")
+	lines := []string{strings.Join(header, ",")}
+
+	for _, row := range r.Departments {
+		fields := []string{quoteCSVField(row.Department)}
+		for _, total := range row.Totals {
+			value := ""
+			if total != nil {
+				value = format.FormatAmount(*total)
+			}
+			fields = append(fields, value)
+		}
+		fields = append(fields, format.FormatAmount(row.CombinedTotal), quoteCSVField(strings.Join(row.MissingFrom, "; ")))
+		lines = append(lines, strings.Join(fields, ","))
+	}
+
+	totals := []string{"Total"}
+	for _, total := range r.FileTotals {
+		totals = append(totals, format.FormatAmount(total))
+	}
+	totals = append(totals, format.FormatAmount(r.CombinedTotal), "")
+	lines = append(lines, strings.Join(totals, ","))
+
+	if _, err := file.WriteString(strings.Join(lines, "\n") + "\n"); err != nil {
+		os.Remove(file.Name())
+		return "", fmt.Errorf("failed to write reconciliation file: %w", err)
+	}
+	if err := fs.Persist(file.Name()); err != nil {
+		fs.logger.Errorf("Failed to store reconciliation file: %v", err)
+		os.Remove(file.Name())
+		return "", err
+	}
+	fs.logger.Infof("Batch reconciliation file saved successfully: %s", file.Name())
+	return file.Name(), nil
+}
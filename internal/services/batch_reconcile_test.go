@@ -0,0 +1,65 @@
+package services
+
+import (
+	"os"
+	"testing"
+
+	"github.com/mussietl/csv-sales-api/internal/models"
+	"github.com/sirupsen/logrus"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func batchFiles() []BatchFileTotals {
+	return []BatchFileTotals{
+		{File: models.BatchFile{Filename: "north.csv", UploadID: "u1"}, Summaries: []DepartmentSummary{
+			{Department: "Toys", TotalSales: models.WholeAmount(5)},
+			{Department: "Books", TotalSales: models.WholeAmount(10)},
+		}},
+		{File: models.BatchFile{Filename: "south.csv", UploadID: "u2"}, Summaries: []DepartmentSummary{
+			{Department: "Books", TotalSales: models.WholeAmount(20)},
+			{Department: "Garden", TotalSales: models.WholeAmount(7)},
+		}},
+	}
+}
+
+func TestReconcileBatch(t *testing.T) {
+	r := ReconcileBatch(batchFiles())
+
+	assert.Equal(t, []models.BatchFile{{Filename: "north.csv", UploadID: "u1"}, {Filename: "south.csv", UploadID: "u2"}}, r.Files)
+	assert.Equal(t, []models.Amount{models.WholeAmount(15), models.WholeAmount(27)}, r.FileTotals)
+	assert.Equal(t, models.WholeAmount(42), r.CombinedTotal)
+	assert.False(t, r.Complete)
+
+	require.Len(t, r.Departments, 3)
+	books, garden, toys := r.Departments[0], r.Departments[1], r.Departments[2]
+	assert.Equal(t, "Books", books.Department)
+	assert.Equal(t, models.WholeAmount(30), books.CombinedTotal)
+	assert.Empty(t, books.MissingFrom)
+	assert.Equal(t, models.WholeAmount(10), *books.Totals[0])
+	assert.Equal(t, models.WholeAmount(20), *books.Totals[1])
+
+	assert.Equal(t, "Garden", garden.Department)
+	assert.Nil(t, garden.Totals[0])
+	assert.Equal(t, []string{"north.csv"}, garden.MissingFrom)
+	assert.Equal(t, []string{"south.csv"}, toys.MissingFrom)
+
+	complete := ReconcileBatch(batchFiles()[:1])
+	assert.True(t, complete.Complete)
+}
+
+func TestFileServiceSaveBatchReconciliationFile(t *testing.T) {
+	logger := logrus.New()
+	logger.SetLevel(logrus.ErrorLevel)
+	fs := NewFileService(t.TempDir(), logger)
+
+	path, err := fs.SaveBatchReconciliationFile(ReconcileBatch(batchFiles()), NumberFormat{Precision: 2})
+	require.NoError(t, err)
+	data, err := os.ReadFile(path)
+	require.NoError(t, err)
+	assert.Equal(t, "Department Name,north.csv,south.csv,Combined Total,Missing From\n"+
+		"Books,10.00,20.00,30.00,\n"+
+		"Garden,,7.00,7.00,north.csv\n"+
+		"Toys,5.00,,5.00,south.csv\n"+
+		"Total,15.00,27.00,42.00,\n", string(data))
+}
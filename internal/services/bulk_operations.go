@@ -0,0 +1,218 @@
+package services
+
+import (
+	"errors"
+	"os"
+	"time"
+
+	"github.com/sirupsen/logrus"
+)
+
+// MaxBulkItems is the most uploads a bulk operation acts on at once
+const MaxBulkItems = 500
+
+// Statuses of the uploads of a bulk operation
+const (
+	BulkDeleted        = "deleted"
+	BulkReprocessed    = "reprocessed"
+	BulkWouldDelete    = "would_delete"
+	BulkWouldReprocess = "would_reprocess"
+	BulkNotFound       = "not_found"
+	BulkSkipped        = "skipped"
+	BulkFailed         = "failed"
+)
+
+// BulkSelector selects the uploads of a bulk operation: the listed uploads,
+// or every stored upload when none are listed, narrowed by the filters
+type BulkSelector struct {
+	UploadIDs []string
+	// OlderThan keeps uploads stored before it
+	OlderThan time.Time
+	Tenant    string
+	// State keeps uploads whose latest intake log state is State
+	State string
+}
+
+// Empty reports whether the selector has neither uploads nor filters, which
+// would select every upload
+func (s BulkSelector) Empty() bool {
+	return len(s.UploadIDs) == 0 && s.OlderThan.IsZero() && s.Tenant == "" && s.State == ""
+}
+
+func (s BulkSelector) matches(upload bulkUpload) bool {
+	if !s.OlderThan.IsZero() && !upload.storedAt.Before(s.OlderThan) {
+		return false
+	}
+	if s.Tenant != "" && (!upload.known || upload.entry.Tenant != s.Tenant) {
+		return false
+	}
+	return s.State == "" || (upload.known && upload.entry.State == s.State)
+}
+
+// BulkItem is the outcome of a bulk operation for one upload
+type BulkItem struct {
+	UploadID string `json:"upload_id"`
+	Status   string `json:"status"`
+	ResultID string `json:"result_id,omitempty"`
+	Error    string `json:"error,omitempty"`
+}
+
+// BulkResult is the outcome of a bulk operation
+type BulkResult struct {
+	DryRun bool `json:"dry_run"`
+	// Matched counts the selected uploads
+	Matched int `json:"matched"`
+	// Remaining counts the matched uploads left over after MaxBulkItems, for a
+	// later request
+	Remaining int        `json:"remaining"`
+	Items     []BulkItem `json:"items"`
+}
+
+// bulkUpload is a stored upload selected for a bulk operation
+type bulkUpload struct {
+	id       string
+	path     string
+	storedAt time.Time
+	entry    IntakeEntry
+	known    bool
+}
+
+// BulkOperations deletes and reprocesses many stored uploads at once
+type BulkOperations struct {
+	fileService *FileService
+	intake      *IntakeLog
+	events      *EventBus
+	retry       RetryFunc
+	logger      *logrus.Logger
+}
+
+// NewBulkOperations creates a BulkOperations instance; retry reprocesses
+// uploads
+func NewBulkOperations(fileService *FileService, intake *IntakeLog, events *EventBus, retry RetryFunc, logger *logrus.Logger) *BulkOperations {
+	return &BulkOperations{
+		fileService: fileService,
+		intake:      intake,
+		events:      events,
+		retry:       retry,
+		logger:      logger,
+	}
+}
+
+// Delete removes the selected uploads, except those still being
+// processed. reason is recorded in their cleanup events.
+func (bo *BulkOperations) Delete(sel BulkSelector, dryRun bool, reason string) (*BulkResult, error) {
+	result, uploads, err := bo.selectUploads(sel, dryRun)
+	if err != nil {
+		return nil, err
+	}
+	for _, upload := range uploads {
+		item := BulkItem{UploadID: upload.id}
+		switch {
+		case upload.known && upload.entry.State == IntakeProcessing:
+			item.Status, item.Error = BulkSkipped, "upload is still being processed"
+		case dryRun:
+			item.Status = BulkWouldDelete
+		default:
+			if err := bo.fileService.DeleteUpload(upload.id); err != nil {
+				bo.logger.Errorf("Failed to delete upload %s: %v", upload.id, err)
+				item.Status, item.Error = BulkFailed, err.Error()
+				break
+			}
+			item.Status = BulkDeleted
+			event := Event{Type: EventCleanup, UploadID: upload.id, Paths: []string{upload.path}, Reason: reason}
+			if upload.known {
+				event.Tenant, event.Filename = upload.entry.Tenant, upload.entry.Filename
+			}
+			bo.events.Publish(event)
+		}
+		result.Items = append(result.Items, item)
+	}
+	return result, nil
+}
+
+// Reprocess processes the selected failed uploads again with default
+// options. Without listed uploads, only failed uploads are selected.
+func (bo *BulkOperations) Reprocess(sel BulkSelector, dryRun bool) (*BulkResult, error) {
+	if len(sel.UploadIDs) == 0 && sel.State == "" {
+		sel.State = IntakeFailed
+	}
+	result, uploads, err := bo.selectUploads(sel, dryRun)
+	if err != nil {
+		return nil, err
+	}
+	for _, upload := range uploads {
+		item := BulkItem{UploadID: upload.id}
+		switch {
+		case !upload.known:
+			item.Status, item.Error = BulkNotFound, ErrIntakeNotFound.Error()
+		case upload.entry.State != IntakeFailed:
+			item.Status, item.Error = BulkSkipped, "upload is "+upload.entry.State+", not failed"
+		case upload.entry.Encrypted:
+			item.Status, item.Error = BulkSkipped, ErrIntakeEncrypted.Error()
+		case dryRun:
+			item.Status = BulkWouldReprocess
+		default:
+			entry, err := bo.intake.Requeue(upload.id, bo.retry)
+			switch {
+			case errors.Is(err, ErrIntakeNotFailed), errors.Is(err, ErrIntakeEncrypted):
+				item.Status, item.Error = BulkSkipped, err.Error()
+			case err != nil:
+				item.Status, item.Error = BulkFailed, err.Error()
+			default:
+				item.Status, item.ResultID = BulkReprocessed, entry.ResultID
+			}
+		}
+		result.Items = append(result.Items, item)
+	}
+	return result, nil
+}
+
+// selectUploads returns the first MaxBulkItems selected uploads, in the
+// listed order or else oldest first, and a result holding items for listed
+// uploads that are missing or don't match the filters
+func (bo *BulkOperations) selectUploads(sel BulkSelector, dryRun bool) (*BulkResult, []bulkUpload, error) {
+	result := &BulkResult{DryRun: dryRun, Items: []BulkItem{}}
+	var uploads []bulkUpload
+	if len(sel.UploadIDs) > 0 {
+		for _, id := range sel.UploadIDs {
+			path, err := bo.fileService.UploadPath(id)
+			if err != nil {
+				result.Items = append(result.Items, BulkItem{UploadID: id, Status: BulkNotFound, Error: err.Error()})
+				continue
+			}
+			info, err := os.Stat(path)
+			if err != nil {
+				result.Items = append(result.Items, BulkItem{UploadID: id, Status: BulkNotFound, Error: err.Error()})
+				continue
+			}
+			upload := bo.upload(id, path, info.ModTime())
+			if !sel.matches(upload) {
+				result.Items = append(result.Items, BulkItem{UploadID: id, Status: BulkSkipped, Error: "upload does not match the filter"})
+				continue
+			}
+			uploads = append(uploads, upload)
+		}
+	} else {
+		stored, err := bo.fileService.ListUploads(time.Time{}, sel.OlderThan)
+		if err != nil {
+			return nil, nil, err
+		}
+		for _, s := range stored {
+			if upload := bo.upload(s.ID, s.Path, s.UploadedAt); sel.matches(upload) {
+				uploads = append(uploads, upload)
+			}
+		}
+	}
+
+	result.Matched = len(uploads)
+	if len(uploads) > MaxBulkItems {
+		result.Remaining = len(uploads) - MaxBulkItems
+		uploads = uploads[:MaxBulkItems]
+	}
+	return result, uploads, nil
+}
+
+func (bo *BulkOperations) upload(id, path string, storedAt time.Time) bulkUpload {
+	entry, known := bo.intake.Entry(id)
+	return bulkUpload{id: id, path: path, storedAt: storedAt, entry: entry, known: known}
+}
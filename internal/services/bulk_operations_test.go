@@ -0,0 +1,107 @@
+package services
+
+import (
+	"errors"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/sirupsen/logrus"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestBulkOperations(t *testing.T) {
+	logger := logrus.New()
+	logger.SetLevel(logrus.PanicLevel)
+	dir := t.TempDir()
+	fs := NewFileService(dir, logger)
+	intake, err := NewIntakeLog(filepath.Join(t.TempDir(), "intake.log"), logger)
+	require.NoError(t, err)
+	defer intake.Close()
+	events := NewEventBus(logger)
+	var cleanups []Event
+	events.Subscribe(func(e Event) { cleanups = append(cleanups, e) }, EventCleanup)
+
+	retried := map[string]bool{}
+	retry := func(entry IntakeEntry) (string, error) {
+		retried[entry.UploadID] = true
+		if entry.Filename == "broken.csv" {
+			return "", errors.New("no sales column")
+		}
+		return "99999999-9999-9999-9999-999999999999", nil
+	}
+	bulk := NewBulkOperations(fs, intake, events, retry, logger)
+
+	old := time.Now().Add(-48 * time.Hour)
+	upload := func(id, filename, tenant, state string, storedAt time.Time) {
+		path := filepath.Join(dir, "upload_"+id+".csv")
+		require.NoError(t, os.WriteFile(path, []byte("Department,Sales\nBooks,1\n"), 0600))
+		require.NoError(t, os.Chtimes(path, storedAt, storedAt))
+		require.NoError(t, intake.Begin(id, path, filename, tenant))
+		switch state {
+		case IntakeCompleted:
+			require.NoError(t, intake.Complete(id, ""))
+		case IntakeFailed:
+			require.NoError(t, intake.Fail(id, "boom"))
+		}
+	}
+	done, failed, broken := "11111111-1111-1111-1111-111111111111", "22222222-2222-2222-2222-222222222222", "33333333-3333-3333-3333-333333333333"
+	busy, recent, other := "44444444-4444-4444-4444-444444444444", "55555555-5555-5555-5555-555555555555", "66666666-6666-6666-6666-666666666666"
+	upload(done, "june.csv", "acme", IntakeCompleted, old)
+	upload(failed, "july.csv", "acme", IntakeFailed, old.Add(time.Minute))
+	upload(broken, "broken.csv", "acme", IntakeFailed, old.Add(2*time.Minute))
+	upload(busy, "august.csv", "acme", IntakeProcessing, old.Add(3*time.Minute))
+	upload(recent, "today.csv", "acme", IntakeFailed, time.Now())
+	upload(other, "q2.csv", "globex", IntakeFailed, old)
+
+	cutoff := time.Now().Add(-24 * time.Hour)
+	result, err := bulk.Reprocess(BulkSelector{OlderThan: cutoff, Tenant: "acme"}, true)
+	require.NoError(t, err)
+	assert.Equal(t, 2, result.Matched, "only failed uploads are selected by filter")
+	assert.Equal(t, []BulkItem{
+		{UploadID: failed, Status: BulkWouldReprocess},
+		{UploadID: broken, Status: BulkWouldReprocess},
+	}, result.Items)
+	assert.Empty(t, retried, "dry runs change nothing")
+
+	result, err = bulk.Reprocess(BulkSelector{UploadIDs: []string{broken, done, failed, "not-an-id"}}, false)
+	require.NoError(t, err)
+	require.Len(t, result.Items, 4)
+	assert.Equal(t, BulkItem{UploadID: "not-an-id", Status: BulkNotFound, Error: `invalid upload id "not-an-id"`}, result.Items[0])
+	assert.Equal(t, broken, result.Items[1].UploadID)
+	assert.Equal(t, BulkFailed, result.Items[1].Status)
+	assert.Equal(t, BulkItem{UploadID: done, Status: BulkSkipped, Error: "upload is completed, not failed"}, result.Items[2])
+	assert.Equal(t, BulkItem{UploadID: failed, Status: BulkReprocessed, ResultID: "99999999-9999-9999-9999-999999999999"}, result.Items[3])
+	entry, _ := intake.Entry(failed)
+	assert.Equal(t, IntakeCompleted, entry.State)
+
+	result, err = bulk.Delete(BulkSelector{OlderThan: cutoff, Tenant: "acme"}, true, "cleanup")
+	require.NoError(t, err)
+	assert.Equal(t, 4, result.Matched)
+	assert.FileExists(t, filepath.Join(dir, "upload_"+done+".csv"))
+
+	result, err = bulk.Delete(BulkSelector{OlderThan: cutoff, Tenant: "acme"}, false, "cleanup")
+	require.NoError(t, err)
+	assert.Equal(t, []BulkItem{
+		{UploadID: done, Status: BulkDeleted},
+		{UploadID: failed, Status: BulkDeleted},
+		{UploadID: broken, Status: BulkDeleted},
+		{UploadID: busy, Status: BulkSkipped, Error: "upload is still being processed"},
+	}, result.Items)
+	assert.NoFileExists(t, filepath.Join(dir, "upload_"+done+".csv"))
+	assert.FileExists(t, filepath.Join(dir, "upload_"+recent+".csv"))
+	assert.FileExists(t, filepath.Join(dir, "upload_"+other+".csv"))
+	require.Len(t, cleanups, 3)
+	assert.Equal(t, done, cleanups[0].UploadID)
+	assert.Equal(t, "june.csv", cleanups[0].Filename)
+	assert.Equal(t, []string{filepath.Join(dir, "upload_"+done+".csv")}, cleanups[0].Paths)
+	assert.Equal(t, "cleanup", cleanups[0].Reason)
+
+	result, err = bulk.Delete(BulkSelector{UploadIDs: []string{done, other}, Tenant: "acme"}, false, "cleanup")
+	require.NoError(t, err)
+	assert.Equal(t, BulkNotFound, result.Items[0].Status)
+	assert.Equal(t, BulkItem{UploadID: other, Status: BulkSkipped, Error: "upload does not match the filter"}, result.Items[1])
+	assert.Zero(t, result.Matched)
+}
@@ -0,0 +1,312 @@
+package services
+
+import (
+	"bytes"
+	"context"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"net/url"
+	"os"
+	"strconv"
+	"strings"
+	"sync"
+	"syscall"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/sirupsen/logrus"
+)
+
+// Headers sent with completion callbacks
+const (
+	CallbackSignatureHeader = "X-Callback-Signature"
+	CallbackTimestampHeader = "X-Callback-Timestamp"
+	CallbackIDHeader        = "X-Callback-ID"
+	CallbackAttemptHeader   = "X-Callback-Attempt"
+)
+
+// DefaultCallbackAttempts is how many times a callback is posted before it
+// is dead-lettered
+const DefaultCallbackAttempts = 5
+
+// ErrCallbacksDisabled is returned for a callback URL when no signing
+// secret is configured
+var ErrCallbacksDisabled = errors.New("callbacks are not enabled on this server")
+
+// ErrCallbackAddressBlocked is returned for a callback to a loopback,
+// private or link-local address while private networks aren't allowed
+var ErrCallbackAddressBlocked = errors.New("callbacks to private network addresses are not allowed")
+
+// maxCallbackRedirects is how many redirects a callback follows
+const maxCallbackRedirects = 5
+
+// Callback is a processing outcome posted to a client's URL
+type Callback struct {
+	URL      string
+	Tenant   string
+	UploadID string
+	// Payload is the upload response or error response, sent as JSON
+	Payload any
+}
+
+// deadLetter records a callback that could not be delivered
+type deadLetter struct {
+	ID       string          `json:"id"`
+	URL      string          `json:"url"`
+	Tenant   string          `json:"tenant"`
+	UploadID string          `json:"upload_id"`
+	Attempts int             `json:"attempts"`
+	Error    string          `json:"error"`
+	FailedAt time.Time       `json:"failed_at"`
+	Payload  json.RawMessage `json:"payload"`
+}
+
+// permanentError is a delivery failure that retrying won't fix
+type permanentError struct{ err error }
+
+func (e permanentError) Error() string { return e.err.Error() }
+
+func (e permanentError) Unwrap() error { return e.err }
+
+// CallbackService posts processing outcomes to client URLs, signed with
+// HMAC-SHA256 and retried with backoff. Callbacks that still fail are
+// appended to a dead-letter log.
+type CallbackService struct {
+	secret       []byte
+	deadLetters  string
+	allowedHosts []string
+	allowPrivate bool
+	attempts     int
+	backoff      time.Duration
+	client       *http.Client
+	mu           sync.Mutex
+	wg           sync.WaitGroup
+	logger       *logrus.Logger
+}
+
+// NewCallbackService creates a CallbackService signing with secret and
+// recording undeliverable callbacks in deadLetterPath. Without a secret,
+// callbacks are disabled.
+func NewCallbackService(secret, deadLetterPath string, logger *logrus.Logger) *CallbackService {
+	cs := &CallbackService{
+		secret:      []byte(secret),
+		deadLetters: deadLetterPath,
+		attempts:    DefaultCallbackAttempts,
+		backoff:     time.Second,
+		logger:      logger,
+	}
+	// Callbacks connect directly rather than through a proxy, so the
+	// address checked when dialing is the receiver's
+	transport := http.DefaultTransport.(*http.Transport).Clone()
+	transport.Proxy = nil
+	transport.DialContext = (&net.Dialer{Timeout: 10 * time.Second, Control: cs.checkDial}).DialContext
+	cs.client = &http.Client{
+		Timeout:   10 * time.Second,
+		Transport: transport,
+		CheckRedirect: func(req *http.Request, via []*http.Request) error {
+			// Each hop must be a callback URL the client could have sent
+			if len(via) >= maxCallbackRedirects {
+				return permanentError{fmt.Errorf("stopped after %d redirects", maxCallbackRedirects)}
+			}
+			if err := cs.Validate(req.URL.String()); err != nil {
+				return permanentError{fmt.Errorf("redirect to %s refused: %w", req.URL.Redacted(), err)}
+			}
+			return nil
+		},
+	}
+	return cs
+}
+
+// SetAttempts sets how many times a callback is posted before it is
+// dead-lettered
+func (cs *CallbackService) SetAttempts(attempts int) {
+	if attempts > 0 {
+		cs.attempts = attempts
+	}
+}
+
+// SetAllowedHosts restricts callback URLs to the given hosts; a leading
+// "*." matches any subdomain. An empty list allows every host.
+func (cs *CallbackService) SetAllowedHosts(hosts []string) {
+	cs.allowedHosts = hosts
+}
+
+// SetAllowPrivateNetworks allows callbacks to loopback, private and
+// link-local addresses, which are refused by default
+func (cs *CallbackService) SetAllowPrivateNetworks(allow bool) {
+	cs.allowPrivate = allow
+}
+
+// Enabled reports whether callbacks can be requested
+func (cs *CallbackService) Enabled() bool {
+	return cs != nil && len(cs.secret) > 0
+}
+
+// Validate checks that a callback URL may be used. Host names are checked
+// against private addresses once resolved, when the callback is posted.
+func (cs *CallbackService) Validate(rawURL string) error {
+	if !cs.Enabled() {
+		return ErrCallbacksDisabled
+	}
+	u, err := url.Parse(rawURL)
+	if err != nil || (u.Scheme != "http" && u.Scheme != "https") || u.Host == "" {
+		return errors.New("must be an absolute http or https URL")
+	}
+	host := strings.ToLower(u.Hostname())
+	if len(cs.allowedHosts) > 0 && !cs.hostAllowed(host) {
+		return fmt.Errorf("host %s is not allowed", host)
+	}
+	if ip := net.ParseIP(host); ip != nil && !cs.addressAllowed(ip) {
+		return fmt.Errorf("%w: %s", ErrCallbackAddressBlocked, host)
+	}
+	return nil
+}
+
+func (cs *CallbackService) hostAllowed(host string) bool {
+	for _, allowed := range cs.allowedHosts {
+		allowed = strings.ToLower(allowed)
+		if host == allowed || (strings.HasPrefix(allowed, "*.") && strings.HasSuffix(host, allowed[1:])) {
+			return true
+		}
+	}
+	return false
+}
+
+// addressAllowed reports whether callbacks may connect to ip
+func (cs *CallbackService) addressAllowed(ip net.IP) bool {
+	if cs.allowPrivate {
+		return true
+	}
+	return !(ip.IsLoopback() || ip.IsPrivate() || ip.IsLinkLocalUnicast() || ip.IsLinkLocalMulticast() ||
+		ip.IsInterfaceLocalMulticast() || ip.IsMulticast() || ip.IsUnspecified())
+}
+
+// checkDial refuses connections to blocked addresses. It runs after host
+// names are resolved, for every connection including those of redirects,
+// so names that resolve to internal addresses are caught too.
+func (cs *CallbackService) checkDial(network, address string, _ syscall.RawConn) error {
+	host, _, err := net.SplitHostPort(address)
+	if err != nil {
+		return err
+	}
+	if ip := net.ParseIP(host); ip == nil || !cs.addressAllowed(ip) {
+		return fmt.Errorf("%w: %s", ErrCallbackAddressBlocked, host)
+	}
+	return nil
+}
+
+// Deliver posts a callback in the background
+func (cs *CallbackService) Deliver(cb Callback) {
+	body, err := json.Marshal(cb.Payload)
+	if err != nil {
+		cs.logger.Errorf("Failed to encode callback for upload %s: %v", cb.UploadID, err)
+		return
+	}
+	id := uuid.New().String()
+	cs.wg.Add(1)
+	go func() {
+		defer cs.wg.Done()
+		attempt := 1
+		for ; ; attempt++ {
+			err = cs.post(cb.URL, id, attempt, body)
+			if err == nil {
+				cs.logger.Infof("Delivered callback %s for upload %s", id, cb.UploadID)
+				return
+			}
+			var permanent permanentError
+			if errors.As(err, &permanent) || attempt == cs.attempts {
+				break
+			}
+			cs.logger.Warnf("Callback %s for upload %s failed, retrying: %v", id, cb.UploadID, err)
+			time.Sleep(cs.backoff << (attempt - 1))
+		}
+		cs.logger.Errorf("Giving up on callback %s for upload %s after %d attempts: %v", id, cb.UploadID, attempt, err)
+		cs.deadLetter(deadLetter{
+			ID:       id,
+			URL:      cb.URL,
+			Tenant:   cb.Tenant,
+			UploadID: cb.UploadID,
+			Attempts: attempt,
+			Error:    err.Error(),
+			FailedAt: time.Now().UTC(),
+			Payload:  body,
+		})
+	}()
+}
+
+// post makes one delivery attempt. Client errors other than timeouts and
+// rate limiting are permanent.
+func (cs *CallbackService) post(target, id string, attempt int, body []byte) error {
+	ctx, cancel := context.WithTimeout(context.Background(), cs.client.Timeout)
+	defer cancel()
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, target, bytes.NewReader(body))
+	if err != nil {
+		return permanentError{err}
+	}
+	timestamp := strconv.FormatInt(time.Now().Unix(), 10)
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set(CallbackIDHeader, id)
+	req.Header.Set(CallbackAttemptHeader, strconv.Itoa(attempt))
+	req.Header.Set(CallbackTimestampHeader, timestamp)
+	req.Header.Set(CallbackSignatureHeader, "sha256="+SignCallback(cs.secret, timestamp, body))
+
+	resp, err := cs.client.Do(req)
+	if err != nil {
+		if errors.Is(err, ErrCallbackAddressBlocked) {
+			return permanentError{err}
+		}
+		return err
+	}
+	defer resp.Body.Close()
+	io.Copy(io.Discard, io.LimitReader(resp.Body, 64<<10))
+	switch {
+	case resp.StatusCode >= 200 && resp.StatusCode < 300:
+		return nil
+	case resp.StatusCode >= 400 && resp.StatusCode < 500 && resp.StatusCode != http.StatusRequestTimeout && resp.StatusCode != http.StatusTooManyRequests:
+		return permanentError{fmt.Errorf("callback returned status %d", resp.StatusCode)}
+	default:
+		return fmt.Errorf("callback returned status %d", resp.StatusCode)
+	}
+}
+
+// deadLetter appends an undeliverable callback to the dead-letter log
+func (cs *CallbackService) deadLetter(record deadLetter) {
+	line, err := json.Marshal(record)
+	if err != nil {
+		cs.logger.Errorf("Failed to encode dead-lettered callback %s: %v", record.ID, err)
+		return
+	}
+	cs.mu.Lock()
+	defer cs.mu.Unlock()
+	file, err := os.OpenFile(cs.deadLetters, os.O_CREATE|os.O_APPEND|os.O_WRONLY, 0600)
+	if err != nil {
+		cs.logger.Errorf("Failed to open callback dead-letter log: %v", err)
+		return
+	}
+	defer file.Close()
+	if _, err := file.Write(append(line, '\n')); err != nil {
+		cs.logger.Errorf("Failed to write callback dead-letter log: %v", err)
+	}
+}
+
+// Close waits for callbacks being delivered
+func (cs *CallbackService) Close() {
+	cs.wg.Wait()
+}
+
+// SignCallback returns the hex HMAC-SHA256 of timestamp, a dot and body,
+// which receivers recompute to verify a callback
+func SignCallback(secret []byte, timestamp string, body []byte) string {
+	mac := hmac.New(sha256.New, secret)
+	mac.Write([]byte(timestamp))
+	mac.Write([]byte("."))
+	mac.Write(body)
+	return hex.EncodeToString(mac.Sum(nil))
+}
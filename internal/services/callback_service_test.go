@@ -0,0 +1,138 @@
+package services
+
+import (
+	"bufio"
+	"encoding/json"
+	"errors"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"testing"
+
+	"github.com/sirupsen/logrus"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestCallbackServiceValidate(t *testing.T) {
+	logger := logrus.New()
+	logger.SetLevel(logrus.PanicLevel)
+
+	disabled := NewCallbackService("", filepath.Join(t.TempDir(), "dead.jsonl"), logger)
+	assert.ErrorIs(t, disabled.Validate("https://example.com/hook"), ErrCallbacksDisabled)
+
+	cs := NewCallbackService("s3cret", filepath.Join(t.TempDir(), "dead.jsonl"), logger)
+	assert.NoError(t, cs.Validate("https://example.com/hook"))
+	assert.Error(t, cs.Validate("ftp://example.com/hook"))
+	assert.Error(t, cs.Validate("/hook"))
+
+	cs.SetAllowedHosts([]string{"hooks.acme.com", "*.globex.io"})
+	assert.NoError(t, cs.Validate("https://hooks.acme.com/csv"))
+	assert.NoError(t, cs.Validate("https://eu.globex.io:8443/csv"))
+	assert.EqualError(t, cs.Validate("http://169.254.169.254/latest"), "host 169.254.169.254 is not allowed")
+
+	// Private addresses are refused unless allowed, whatever the host list
+	cs.SetAllowedHosts(nil)
+	for _, target := range []string{"http://127.0.0.1/hook", "http://[::1]/hook", "http://10.0.0.5/hook", "http://192.168.1.1/hook", "http://169.254.169.254/latest", "http://0.0.0.0/hook"} {
+		assert.ErrorIs(t, cs.Validate(target), ErrCallbackAddressBlocked, target)
+	}
+	cs.SetAllowPrivateNetworks(true)
+	assert.NoError(t, cs.Validate("http://10.0.0.5/hook"))
+}
+
+func TestCallbackServiceBlocksPrivateAddresses(t *testing.T) {
+	logger := logrus.New()
+	logger.SetLevel(logrus.PanicLevel)
+	deadLetters := filepath.Join(t.TempDir(), "dead.jsonl")
+	cs := NewCallbackService("s3cret", deadLetters, logger)
+	cs.backoff = 0
+
+	var mu sync.Mutex
+	var calls []string
+	internal := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		mu.Lock()
+		calls = append(calls, r.URL.Path)
+		mu.Unlock()
+		if r.URL.Path == "/redirect" {
+			http.Redirect(w, r, "http://169.254.169.254/latest/meta-data", http.StatusTemporaryRedirect)
+		}
+	}))
+	defer internal.Close()
+
+	// A name resolving to loopback is refused when dialing, without retries
+	target := strings.Replace(internal.URL, "127.0.0.1", "localhost", 1)
+	require.NoError(t, cs.Validate(target+"/hook"))
+	err := cs.post(target+"/hook", "cb1", 1, []byte("{}"))
+	assert.ErrorIs(t, err, ErrCallbackAddressBlocked)
+	assert.True(t, errors.As(err, new(permanentError)))
+	assert.Empty(t, calls)
+
+	// Redirects are validated like the URL the client sent
+	cs.SetAllowPrivateNetworks(true)
+	cs.SetAllowedHosts([]string{"127.0.0.1"})
+	err = cs.post(internal.URL+"/redirect", "cb2", 1, []byte("{}"))
+	require.Error(t, err)
+	assert.Contains(t, err.Error(), "host 169.254.169.254 is not allowed")
+	assert.True(t, errors.As(err, new(permanentError)), "refused redirects are not retried")
+	assert.Equal(t, []string{"/redirect"}, calls)
+}
+
+func TestCallbackServiceDeliver(t *testing.T) {
+	logger := logrus.New()
+	logger.SetLevel(logrus.PanicLevel)
+	deadLetters := filepath.Join(t.TempDir(), "dead.jsonl")
+	cs := NewCallbackService("s3cret", deadLetters, logger)
+	cs.SetAttempts(3)
+	cs.SetAllowPrivateNetworks(true)
+	cs.backoff = 0
+
+	var mu sync.Mutex
+	calls := map[string]int{}
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		body, _ := io.ReadAll(r.Body)
+		signature := "sha256=" + SignCallback([]byte("s3cret"), r.Header.Get(CallbackTimestampHeader), body)
+		if r.Header.Get(CallbackSignatureHeader) != signature {
+			w.WriteHeader(http.StatusUnauthorized)
+			return
+		}
+		mu.Lock()
+		calls[r.URL.Path]++
+		n := calls[r.URL.Path]
+		mu.Unlock()
+		switch {
+		case r.URL.Path == "/flaky" && n < 2, r.URL.Path == "/down":
+			w.WriteHeader(http.StatusBadGateway)
+		case r.URL.Path == "/gone":
+			w.WriteHeader(http.StatusGone)
+		}
+	}))
+	defer server.Close()
+
+	payload := map[string]any{"success": true, "upload_id": "u1"}
+	cs.Deliver(Callback{URL: server.URL + "/flaky", UploadID: "u1", Payload: payload})
+	cs.Deliver(Callback{URL: server.URL + "/down", UploadID: "u2", Payload: payload})
+	cs.Deliver(Callback{URL: server.URL + "/gone", UploadID: "u3", Payload: payload})
+	cs.Close()
+
+	assert.Equal(t, map[string]int{"/flaky": 2, "/down": 3, "/gone": 1}, calls)
+
+	file, err := os.Open(deadLetters)
+	require.NoError(t, err)
+	defer file.Close()
+	records := map[string]deadLetter{}
+	scanner := bufio.NewScanner(file)
+	for scanner.Scan() {
+		var record deadLetter
+		require.NoError(t, json.Unmarshal(scanner.Bytes(), &record))
+		records[record.UploadID] = record
+	}
+	require.Len(t, records, 2)
+	assert.Equal(t, 3, records["u2"].Attempts)
+	assert.Equal(t, "callback returned status 502", records["u2"].Error)
+	assert.JSONEq(t, `{"success":true,"upload_id":"u1"}`, string(records["u2"].Payload))
+	assert.Equal(t, 1, records["u3"].Attempts, "client errors are not retried")
+}
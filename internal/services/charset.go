@@ -0,0 +1,154 @@
+package services
+
+import (
+	"bufio"
+	"bytes"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"unicode/utf8"
+
+	"golang.org/x/text/encoding"
+	"golang.org/x/text/encoding/charmap"
+	"golang.org/x/text/encoding/unicode"
+	"golang.org/x/text/transform"
+)
+
+// Encodings detected in uploads that are not plain UTF-8; files in any of
+// them are transcoded to UTF-8 before parsing
+const (
+	EncodingUTF8BOM     = "utf-8-bom"
+	EncodingUTF16LE     = "utf-16le"
+	EncodingUTF16BE     = "utf-16be"
+	EncodingWindows1252 = "windows-1252"
+)
+
+// charsetSniffBytes is how much of a file is examined to detect its encoding
+const charsetSniffBytes = 4096
+
+var (
+	bomUTF8    = []byte{0xEF, 0xBB, 0xBF}
+	bomUTF16LE = []byte{0xFF, 0xFE}
+	bomUTF16BE = []byte{0xFE, 0xFF}
+)
+
+// sniffEncoding detects the encoding of a file from its first bytes,
+// returning "" for plain UTF-8 and the length of its byte order mark. Files
+// without a mark are taken as UTF-16 when most of their high or low bytes
+// are zero, and as Windows-1252, which Excel writes and which extends
+// Latin-1, when they aren't valid UTF-8.
+func sniffEncoding(head []byte) (string, int) {
+	switch {
+	case bytes.HasPrefix(head, bomUTF8):
+		return EncodingUTF8BOM, len(bomUTF8)
+	case bytes.HasPrefix(head, bomUTF16LE):
+		return EncodingUTF16LE, len(bomUTF16LE)
+	case bytes.HasPrefix(head, bomUTF16BE):
+		return EncodingUTF16BE, len(bomUTF16BE)
+	}
+
+	var evenZeros, oddZeros int
+	for i, b := range head {
+		if b == 0 && i%2 == 0 {
+			evenZeros++
+		} else if b == 0 {
+			oddZeros++
+		}
+	}
+	pairs := len(head) / 2
+	switch {
+	case pairs > 0 && oddZeros > pairs/2 && evenZeros == 0:
+		return EncodingUTF16LE, 0
+	case pairs > 0 && evenZeros > pairs/2 && oddZeros == 0:
+		return EncodingUTF16BE, 0
+	}
+
+	// A multi-byte character cut off at the end of head is still valid
+	for i := 0; i < utf8.UTFMax && i < len(head); i++ {
+		if utf8.RuneStart(head[len(head)-1-i]) {
+			if !utf8.FullRune(head[len(head)-1-i:]) {
+				head = head[:len(head)-1-i]
+			}
+			break
+		}
+	}
+	if !utf8.Valid(head) {
+		return EncodingWindows1252, 0
+	}
+	return "", 0
+}
+
+// encodingDecoder returns the decoder from an encoding to UTF-8, or nil for
+// encodings that only need their byte order mark removed
+func encodingDecoder(name string) *encoding.Decoder {
+	switch name {
+	case EncodingUTF16LE:
+		return unicode.UTF16(unicode.LittleEndian, unicode.IgnoreBOM).NewDecoder()
+	case EncodingUTF16BE:
+		return unicode.UTF16(unicode.BigEndian, unicode.IgnoreBOM).NewDecoder()
+	case EncodingWindows1252:
+		return charmap.Windows1252.NewDecoder()
+	}
+	return nil
+}
+
+// decodeCharset detects the encoding of buffered and returns a reader of
+// its content as UTF-8 without a byte order mark, along with the encoding
+func decodeCharset(buffered *bufio.Reader) (*bufio.Reader, string, error) {
+	head, err := buffered.Peek(charsetSniffBytes)
+	if err != nil && !errors.Is(err, io.EOF) && !errors.Is(err, bufio.ErrBufferFull) {
+		return nil, "", err
+	}
+	name, bom := sniffEncoding(head)
+	if _, err := buffered.Discard(bom); err != nil {
+		return nil, "", err
+	}
+	decoder := encodingDecoder(name)
+	if decoder == nil {
+		return buffered, name, nil
+	}
+	return bufio.NewReaderSize(transform.NewReader(buffered, decoder), 64*1024), name, nil
+}
+
+// sniffFileEncoding detects the encoding of the file at filePath
+func sniffFileEncoding(filePath string) (string, int, error) {
+	file, err := os.Open(filePath)
+	if err != nil {
+		return "", 0, err
+	}
+	defer file.Close()
+	head := make([]byte, charsetSniffBytes)
+	n, err := io.ReadFull(file, head)
+	if err != nil && !errors.Is(err, io.EOF) && !errors.Is(err, io.ErrUnexpectedEOF) {
+		return "", 0, err
+	}
+	name, bom := sniffEncoding(head[:n])
+	return name, bom, nil
+}
+
+// transcodeFile writes the content of the file at filePath, in the given
+// encoding, to a temporary UTF-8 file, which the caller removes
+func transcodeFile(filePath, name string, bom int) (string, error) {
+	src, err := os.Open(filePath)
+	if err != nil {
+		return "", fmt.Errorf("failed to open file: %w", err)
+	}
+	defer src.Close()
+	if _, err := src.Seek(int64(bom), io.SeekStart); err != nil {
+		return "", fmt.Errorf("failed to open file: %w", err)
+	}
+	dst, err := os.CreateTemp("", "transcoded_*.csv")
+	if err != nil {
+		return "", fmt.Errorf("failed to create temp file: %w", err)
+	}
+	_, err = io.Copy(dst, transform.NewReader(src, encodingDecoder(name)))
+	if closeErr := dst.Close(); err == nil {
+		err = closeErr
+	}
+	if err != nil {
+		os.Remove(dst.Name())
+		return "", fmt.Errorf("failed to transcode %s file: %w", name, err)
+	}
+	return dst.Name(), nil
+}
@@ -0,0 +1,107 @@
+package services
+
+import (
+	"bytes"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"unicode/utf16"
+
+	"github.com/sirupsen/logrus"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+const charsetCSV = "Department Name,Number of Sales\nCafé,10\nBooks,5\nCafé,2\n"
+
+// encodeUTF16 encodes s as UTF-16, with a byte order mark if bom is set
+func encodeUTF16(s string, bigEndian, bom bool) []byte {
+	units := utf16.Encode([]rune(s))
+	if bom {
+		units = append([]uint16{0xFEFF}, units...)
+	}
+	var buf bytes.Buffer
+	for _, u := range units {
+		if bigEndian {
+			buf.Write([]byte{byte(u >> 8), byte(u)})
+		} else {
+			buf.Write([]byte{byte(u), byte(u >> 8)})
+		}
+	}
+	return buf.Bytes()
+}
+
+func TestSniffEncoding(t *testing.T) {
+	cases := []struct {
+		data     []byte
+		encoding string
+		bom      int
+	}{
+		{[]byte(charsetCSV), "", 0},
+		{append([]byte{0xEF, 0xBB, 0xBF}, charsetCSV...), EncodingUTF8BOM, 3},
+		{encodeUTF16(charsetCSV, false, true), EncodingUTF16LE, 2},
+		{encodeUTF16(charsetCSV, true, true), EncodingUTF16BE, 2},
+		{encodeUTF16(charsetCSV, false, false), EncodingUTF16LE, 0},
+		{encodeUTF16(charsetCSV, true, false), EncodingUTF16BE, 0},
+		{[]byte("Department Name,Number of Sales\nCaf\xe9,10\n"), EncodingWindows1252, 0},
+		// A character cut off by the sniffed prefix is not an encoding error
+		{[]byte("Caf\xc3"), "", 0},
+	}
+	for _, c := range cases {
+		encoding, bom := sniffEncoding(c.data)
+		assert.Equal(t, c.encoding, encoding, "%q", c.data)
+		assert.Equal(t, c.bom, bom, "%q", c.data)
+	}
+}
+
+func TestProcessEncodings(t *testing.T) {
+	logger := logrus.New()
+	logger.SetLevel(logrus.ErrorLevel)
+	cs := NewCSVService(logger)
+	cs.SetStrategyThresholds(StrategyThresholds{Workers: 4})
+
+	files := map[string][]byte{
+		EncodingUTF8BOM:     append([]byte{0xEF, 0xBB, 0xBF}, charsetCSV...),
+		EncodingUTF16LE:     encodeUTF16(charsetCSV, false, true),
+		EncodingUTF16BE:     encodeUTF16(charsetCSV, true, false),
+		EncodingWindows1252: []byte(strings.ReplaceAll(charsetCSV, "é", "\xe9")),
+	}
+	for encoding, data := range files {
+		path := filepath.Join(t.TempDir(), "sales.csv")
+		require.NoError(t, os.WriteFile(path, data, 0644))
+		for _, strategy := range []string{StrategyInMemory, StrategyStreaming, StrategyParallel} {
+			result, err := cs.Process(path, ProcessOptions{Strategy: strategy})
+			require.NoError(t, err, "%s %s", encoding, strategy)
+			assert.Equal(t, encoding, result.Schema.Encoding)
+			assert.Equal(t, "Department Name", result.Schema.DepartmentColumn, "%s %s", encoding, strategy)
+			require.Len(t, result.Summaries, 2)
+			assert.Equal(t, "Books", result.Summaries[0].Department)
+			assert.Equal(t, "Café", result.Summaries[1].Department)
+			assert.Equal(t, "12", result.Summaries[1].TotalSales.String())
+		}
+
+		result, err := cs.ProcessStream(bytes.NewReader(data), ProcessOptions{})
+		require.NoError(t, err, encoding)
+		assert.Equal(t, encoding, result.Schema.Encoding)
+		assert.Equal(t, "Café", result.Summaries[1].Department)
+
+		result, err = cs.Process(writeGzip(t, "sales.csv.gz", string(data)), ProcessOptions{})
+		require.NoError(t, err, encoding)
+		assert.Equal(t, encoding, result.Schema.Encoding)
+		assert.Equal(t, "Café", result.Summaries[1].Department)
+	}
+
+	// Archive entries are transcoded one by one
+	result, err := cs.Process(writeZip(t,
+		[2]string{"a.csv", string(encodeUTF16(charsetCSV, false, true))},
+		[2]string{"b.csv", "Department Name,Number of Sales\nCaf\xe9,1\n"},
+	), ProcessOptions{})
+	require.NoError(t, err)
+	assert.Empty(t, result.Schema.Encoding)
+	assert.Equal(t, "13", result.Summaries[1].TotalSales.String())
+
+	result, err = cs.ProcessStream(strings.NewReader(charsetCSV), ProcessOptions{})
+	require.NoError(t, err)
+	assert.Empty(t, result.Schema.Encoding)
+}
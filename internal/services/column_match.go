@@ -0,0 +1,159 @@
+package services
+
+import (
+	"errors"
+	"fmt"
+	"strconv"
+	"strings"
+
+	"github.com/mussietl/csv-sales-api/internal/models"
+)
+
+// Column roles detected in the header
+const (
+	RoleDepartment = "department"
+	RoleSales      = "sales"
+)
+
+// columnRule is one synonym rule for detecting a column role
+type columnRule struct {
+	role  string
+	name  string
+	match func(column string) bool
+}
+
+// equals returns a rule matching a normalized header cell exactly
+func equals(role, synonym string) columnRule {
+	return columnRule{role: role, name: fmt.Sprintf("equals %q", synonym), match: func(column string) bool { return column == synonym }}
+}
+
+// columnRules are the synonym rules in the order they are tried; the first
+// cell matching any rule for a role is chosen for it
+var columnRules = []columnRule{
+	equals(RoleDepartment, "department"),
+	equals(RoleDepartment, "department name"),
+	{role: RoleDepartment, name: `contains "department"`, match: func(column string) bool { return strings.Contains(column, "department") }},
+	equals(RoleDepartment, "dept"),
+	equals(RoleSales, "sales"),
+	equals(RoleSales, "total_sales"),
+	equals(RoleSales, "total sales"),
+	equals(RoleSales, "number of sales"),
+	equals(RoleSales, "amount"),
+	equals(RoleSales, "revenue"),
+}
+
+// ErrColumnNotFound is returned when a column named in the processing
+// options is not in the header
+var ErrColumnNotFound = errors.New("not found in CSV header")
+
+// ColumnMatchError is returned when a required column is not found. It
+// carries the traced header cells so clients can see why no cell matched.
+type ColumnMatchError struct {
+	Err     error
+	Columns []models.SchemaColumn
+}
+
+func (e *ColumnMatchError) Error() string { return e.Err.Error() }
+
+func (e *ColumnMatchError) Unwrap() error { return e.Err }
+
+// traceColumnMatches finds the department and sales columns in a normalized
+// header, recording for every cell which rule matched and why it was not
+// chosen for the other roles. Roles in mapped are assigned to their column
+// instead of being detected. The decisions are also logged at debug level.
+func (cs *CSVService) traceColumnMatches(header []string, mapped map[string]int) (int, int, []*models.ColumnMatch, error) {
+	chosen := map[string]int{RoleDepartment: -1, RoleSales: -1}
+	for role, index := range mapped {
+		chosen[role] = index
+	}
+	trace := make([]*models.ColumnMatch, len(header))
+
+	for i, column := range header {
+		match := &models.ColumnMatch{Roles: []string{}, Rules: []string{}, Rejected: []string{}}
+		trace[i] = match
+		for _, role := range []string{RoleDepartment, RoleSales} {
+			if index, ok := mapped[role]; ok {
+				if index == i {
+					match.Roles = append(match.Roles, role)
+					match.Rules = append(match.Rules, "mapped by "+role+"_column")
+				} else {
+					match.Rejected = append(match.Rejected, fmt.Sprintf("%s: mapped to column %d by %s_column", role, index, role))
+				}
+				continue
+			}
+			rule, ok := matchingRule(role, column)
+			switch {
+			case !ok:
+				match.Rejected = append(match.Rejected, fmt.Sprintf("%s: no synonym rule matched", role))
+			case chosen[role] >= 0:
+				match.Rejected = append(match.Rejected, fmt.Sprintf("%s: matched rule %s, but column %d was already chosen", role, rule, chosen[role]))
+			default:
+				chosen[role] = i
+				match.Roles = append(match.Roles, role)
+				match.Rules = append(match.Rules, rule)
+			}
+		}
+		cs.logger.Debugf("Header cell %d %q: roles %v rules %v rejected %v", i, column, match.Roles, match.Rules, match.Rejected)
+	}
+
+	departmentIndex, salesIndex := chosen[RoleDepartment], chosen[RoleSales]
+	if departmentIndex == -1 {
+		return -1, -1, trace, fmt.Errorf("department column not found in CSV header")
+	}
+	if salesIndex == -1 {
+		return -1, -1, trace, fmt.Errorf("sales column not found in CSV header")
+	}
+
+	cs.logger.Infof("Found department column at index %d, sales column at index %d", departmentIndex, salesIndex)
+	return departmentIndex, salesIndex, trace, nil
+}
+
+// matchingRule returns the name of the first rule for role that matches column
+func matchingRule(role, column string) (string, bool) {
+	for _, rule := range columnRules {
+		if rule.role == role && rule.match(column) {
+			return rule.name, true
+		}
+	}
+	return "", false
+}
+
+// mapColumns resolves the department and sales columns named in the
+// options. Each is a header name, normalized the same way as the header, or
+// failing that a zero-based column index.
+func mapColumns(normalizedHeader []string, opts ProcessOptions, norm Normalization) (map[string]int, error) {
+	mapped := map[string]int{}
+	for _, option := range []struct{ role, column string }{
+		{RoleDepartment, opts.DepartmentColumn},
+		{RoleSales, opts.SalesColumn},
+	} {
+		if option.column == "" {
+			continue
+		}
+		index, err := mapColumn(normalizedHeader, option.column, norm)
+		if err != nil {
+			return nil, fmt.Errorf("%s_column %w", option.role, err)
+		}
+		mapped[option.role] = index
+	}
+	return mapped, nil
+}
+
+// mapColumn returns the index of the header cell named column or, when none
+// is, of the column at that index
+func mapColumn(normalizedHeader []string, column string, norm Normalization) (int, error) {
+	name := norm.Apply(column)
+	for i, col := range normalizedHeader {
+		if col == name {
+			return i, nil
+		}
+	}
+	index, err := strconv.Atoi(column)
+	if err != nil {
+		return -1, fmt.Errorf("%q %w", column, ErrColumnNotFound)
+	}
+	if index < 0 || index >= len(normalizedHeader) {
+		return -1, fmt.Errorf("%d %w: it has %d columns", index, ErrColumnNotFound, len(normalizedHeader))
+	}
+	return index, nil
+}
@@ -0,0 +1,94 @@
+package services
+
+import (
+	"errors"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/mussietl/csv-sales-api/internal/models"
+	"github.com/sirupsen/logrus"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestTraceColumnMatches(t *testing.T) {
+	logger := logrus.New()
+	logger.SetLevel(logrus.ErrorLevel)
+	cs := NewCSVService(logger)
+
+	dept, sales, trace, err := cs.traceColumnMatches([]string{"region", "department name", "sub department", "amount"}, nil)
+	require.NoError(t, err)
+	assert.Equal(t, 1, dept)
+	assert.Equal(t, 3, sales)
+
+	assert.Empty(t, trace[0].Roles)
+	assert.Equal(t, []string{"department: no synonym rule matched", "sales: no synonym rule matched"}, trace[0].Rejected)
+	assert.Equal(t, []string{RoleDepartment}, trace[1].Roles)
+	assert.Equal(t, []string{`equals "department name"`}, trace[1].Rules)
+	assert.Equal(t, []string{`department: matched rule contains "department", but column 1 was already chosen`, "sales: no synonym rule matched"}, trace[2].Rejected)
+	assert.Equal(t, []string{`equals "amount"`}, trace[3].Rules)
+}
+
+func TestProcessTraceColumns(t *testing.T) {
+	logger := logrus.New()
+	logger.SetLevel(logrus.ErrorLevel)
+	cs := NewCSVService(logger)
+	dir := t.TempDir()
+
+	path := filepath.Join(dir, "sales.csv")
+	require.NoError(t, os.WriteFile(path, []byte("Dept,Revenue\nBooks,5\n"), 0600))
+	result, err := cs.Process(path, ProcessOptions{})
+	require.NoError(t, err)
+	assert.Nil(t, result.Schema.Columns[0].Match, "traces are only reported on request")
+	result, err = cs.Process(path, ProcessOptions{TraceColumns: true})
+	require.NoError(t, err)
+	assert.Equal(t, []string{`equals "dept"`}, result.Schema.Columns[0].Match.Rules)
+
+	// A failed match carries the trace of every cell
+	path = filepath.Join(dir, "unknown.csv")
+	require.NoError(t, os.WriteFile(path, []byte("Category,Units\nBooks,5\n"), 0600))
+	_, err = cs.Process(path, ProcessOptions{})
+	var matchErr *ColumnMatchError
+	require.True(t, errors.As(err, &matchErr))
+	assert.EqualError(t, err, "failed to find required columns: department column not found in CSV header")
+	require.Len(t, matchErr.Columns, 2)
+	assert.Equal(t, models.SchemaColumn{Index: 1, Raw: "Units", Normalized: "units", Match: &models.ColumnMatch{
+		Roles:    []string{},
+		Rules:    []string{},
+		Rejected: []string{"department: no synonym rule matched", "sales: no synonym rule matched"},
+	}}, matchErr.Columns[1])
+}
+
+func TestProcessColumnMapping(t *testing.T) {
+	logger := logrus.New()
+	logger.SetLevel(logrus.ErrorLevel)
+	cs := NewCSVService(logger)
+
+	path := filepath.Join(t.TempDir(), "sales.csv")
+	require.NoError(t, os.WriteFile(path, []byte("Store,Category,Units,Amount\nNorth,Books,2,5\nSouth,Toys,1,7\nNorth,Toys,4,1\n"), 0600))
+
+	result, err := cs.Process(path, ProcessOptions{DepartmentColumn: " category ", SalesColumn: "2", TraceColumns: true})
+	require.NoError(t, err)
+	assert.Equal(t, "Category", result.Schema.DepartmentColumn)
+	assert.Equal(t, "Units", result.Schema.SalesColumn)
+	assert.Equal(t, []DepartmentSummary{
+		{Department: "Books", TotalSales: models.WholeAmount(2), SalesCount: 1},
+		{Department: "Toys", TotalSales: models.WholeAmount(5), SalesCount: 2},
+	}, result.Summaries)
+	assert.Equal(t, []string{"mapped by department_column"}, result.Schema.Columns[1].Match.Rules)
+	assert.Equal(t, []string{"department: mapped to column 1 by department_column", "sales: mapped to column 2 by sales_column"}, result.Schema.Columns[3].Match.Rejected)
+
+	// Only the mapped role overrides detection
+	result, err = cs.Process(path, ProcessOptions{DepartmentColumn: "0"})
+	require.NoError(t, err)
+	assert.Equal(t, "Store", result.Schema.DepartmentColumn)
+	assert.Equal(t, "Amount", result.Schema.SalesColumn)
+
+	_, err = cs.Process(path, ProcessOptions{DepartmentColumn: "Region"})
+	assert.ErrorIs(t, err, ErrColumnNotFound)
+	assert.EqualError(t, err, `department_column "Region" not found in CSV header`)
+	_, err = cs.Process(path, ProcessOptions{SalesColumn: "4"})
+	assert.ErrorIs(t, err, ErrColumnNotFound)
+	assert.EqualError(t, err, "sales_column 4 not found in CSV header: it has 4 columns")
+}
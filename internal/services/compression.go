@@ -0,0 +1,256 @@
+package services
+
+import (
+	"archive/zip"
+	"bufio"
+	"bytes"
+	"compress/gzip"
+	"encoding/csv"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path"
+	"strings"
+
+	"github.com/mussietl/csv-sales-api/internal/models"
+)
+
+// Compression formats of uploaded files, detected by their magic bytes
+const (
+	CompressionNone = ""
+	CompressionGzip = "gzip"
+	CompressionZip  = "zip"
+)
+
+var (
+	gzipMagic = []byte{0x1f, 0x8b}
+	zipMagic  = []byte("PK\x03\x04")
+)
+
+// DetectCompression reads the magic bytes of a file to tell whether it is
+// gzip-compressed or a ZIP archive, whatever its extension
+func DetectCompression(filePath string) (string, error) {
+	file, err := os.Open(filePath)
+	if err != nil {
+		return "", err
+	}
+	defer file.Close()
+
+	magic := make([]byte, len(zipMagic))
+	n, err := io.ReadFull(file, magic)
+	if err != nil && !errors.Is(err, io.ErrUnexpectedEOF) && !errors.Is(err, io.EOF) {
+		return "", err
+	}
+	magic = magic[:n]
+	switch {
+	case bytes.HasPrefix(magic, gzipMagic):
+		return CompressionGzip, nil
+	case bytes.HasPrefix(magic, zipMagic):
+		return CompressionZip, nil
+	}
+	return CompressionNone, nil
+}
+
+// IsCompressed reports whether a file is gzip-compressed or a ZIP archive.
+// Workbooks are ZIP archives too, but are not reported.
+func IsCompressed(filePath string) bool {
+	if IsXLSX(filePath) {
+		return false
+	}
+	compression, err := DetectCompression(filePath)
+	return err == nil && compression != CompressionNone
+}
+
+// decompressedLimit fails reads once more than max decompressed bytes have
+// been read, so a small archive can't expand without bound
+type decompressedLimit struct {
+	r    io.Reader
+	read int64
+	max  int64
+}
+
+func (l *decompressedLimit) Read(p []byte) (int, error) {
+	n, err := l.r.Read(p)
+	l.read += int64(n)
+	if l.max > 0 && l.read > l.max {
+		return n, fmt.Errorf("%w: file decompresses to more than %d bytes", ErrCSVLimitExceeded, l.max)
+	}
+	return n, err
+}
+
+// processGzip processes a gzip-compressed CSV file, decompressing it while
+// streaming; compressed files are never read whole or split
+func (cs *CSVService) processGzip(filePath string, opts ProcessOptions) (*ProcessResult, error) {
+	file, err := os.Open(filePath)
+	if err != nil {
+		return nil, fmt.Errorf("failed to open file: %w", err)
+	}
+	defer file.Close()
+
+	info, err := file.Stat()
+	if err != nil {
+		return nil, fmt.Errorf("failed to open file: %w", err)
+	}
+	// Progress counts compressed bytes, so the percentage is of the file as stored
+	opts.progress = newProgressTracker(opts.Progress, info.Size())
+	gz, err := gzip.NewReader(opts.progress.reader(file))
+	if err != nil {
+		return nil, fmt.Errorf("failed to decompress file: %w", err)
+	}
+	defer gz.Close()
+
+	if opts.Parser == ParserDefault {
+		opts.Parser = cs.parser
+	}
+	limited := &decompressedLimit{r: gz, max: cs.limits.MaxDecompressedBytes}
+	buffered, encoding, err := decodeCharset(bufio.NewReaderSize(limited, 64*1024))
+	if err != nil {
+		return nil, fmt.Errorf("failed to decompress file: %w", err)
+	}
+	opts.encoding = encoding
+	return cs.processSource(buffered, StrategyStreaming, opts, nil)
+}
+
+// processArchive processes every CSV file in a ZIP archive as one file. The
+// entries are merged into a temporary CSV: the first entry's header becomes
+// the header, and later entries' columns are matched to it by normalized
+// name, as with workbook sheets.
+func (cs *CSVService) processArchive(filePath string, opts ProcessOptions) (*ProcessResult, error) {
+	merged, err := os.CreateTemp("", "archive_*.csv")
+	if err != nil {
+		return nil, fmt.Errorf("failed to create temp file: %w", err)
+	}
+	defer os.Remove(merged.Name())
+
+	entries, err := cs.mergeArchive(filePath, opts.Delimiter, merged)
+	if closeErr := merged.Close(); err == nil && closeErr != nil {
+		err = fmt.Errorf("failed to write archive rows: %w", closeErr)
+	}
+	if err != nil {
+		cs.log(opts).Errorf("Failed to read archive: %v", err)
+		return nil, err
+	}
+	for _, entry := range entries {
+		cs.log(opts).Infof("Read %d rows from archive entry %q", entry.Rows, entry.Name)
+	}
+
+	// The merged rows are written comma-separated, whatever the entries used
+	opts.Delimiter = ","
+	result, err := cs.processCSV(merged.Name(), opts)
+	if err != nil {
+		return nil, err
+	}
+	result.Entries = entries
+	return result, nil
+}
+
+// mergeArchive writes the rows of the CSV entries of a ZIP archive to w.
+// Each entry is split on delimiter, or on the delimiter detected in its header.
+func (cs *CSVService) mergeArchive(filePath, delimiter string, w io.Writer) ([]models.SheetStats, error) {
+	archive, err := zip.OpenReader(filePath)
+	if err != nil {
+		return nil, fmt.Errorf("failed to open archive: %w", err)
+	}
+	defer archive.Close()
+
+	writer := csv.NewWriter(w)
+	var baseHeader []string
+	var entries []models.SheetStats
+	limit := &decompressedLimit{max: cs.limits.MaxDecompressedBytes}
+	for _, f := range archive.File {
+		if !isArchivedCSV(f) {
+			continue
+		}
+		stats, err := cs.mergeArchiveEntry(f, delimiter, writer, &baseHeader, limit)
+		if err != nil {
+			return nil, fmt.Errorf("failed to read %s: %w", f.Name, err)
+		}
+		entries = append(entries, stats)
+	}
+	if len(entries) == 0 {
+		return nil, errors.New("archive contains no CSV files")
+	}
+
+	writer.Flush()
+	if err := writer.Error(); err != nil {
+		return nil, fmt.Errorf("failed to write archive rows: %w", err)
+	}
+	return entries, nil
+}
+
+// mergeArchiveEntry writes the rows of one archived CSV to writer, setting
+// baseHeader from the first entry
+func (cs *CSVService) mergeArchiveEntry(f *zip.File, delimiter string, writer *csv.Writer, baseHeader *[]string, limit *decompressedLimit) (models.SheetStats, error) {
+	stats := models.SheetStats{Name: f.Name}
+	rc, err := f.Open()
+	if err != nil {
+		return stats, err
+	}
+	defer rc.Close()
+	limit.r = rc
+	buffered, _, err := decodeCharset(bufio.NewReaderSize(limit, 64*1024))
+	if err != nil {
+		return stats, err
+	}
+
+	buffered = newLineEndingReader(buffered)
+	headerLine, err := readHeaderLine(buffered, cs.limits.MaxHeaderBytes)
+	if errors.Is(err, io.EOF) {
+		return stats, nil
+	}
+	if err != nil {
+		return stats, err
+	}
+	comma := rune(resolveDelimiter(delimiter, headerLine))
+	headerReader := csv.NewReader(bytes.NewReader(headerLine))
+	headerReader.Comma = comma
+	header, err := headerReader.Read()
+	if err != nil {
+		return stats, fmt.Errorf("failed to read CSV header: %w", err)
+	}
+	if cs.limits.MaxColumns > 0 && len(header) > cs.limits.MaxColumns {
+		return stats, fmt.Errorf("%w: header has %d columns, maximum is %d", ErrCSVLimitExceeded, len(header), cs.limits.MaxColumns)
+	}
+
+	// Rows of the first entry are copied as they are; later entries' rows are remapped
+	var mapping []int
+	if *baseHeader == nil {
+		*baseHeader = header
+		if err := writer.Write(header); err != nil {
+			return stats, err
+		}
+	} else {
+		mapping = mapSheetColumns(*baseHeader, header)
+	}
+
+	reader := csv.NewReader(buffered)
+	reader.Comma = comma
+	reader.FieldsPerRecord = -1
+	for {
+		row, err := reader.Read()
+		if errors.Is(err, io.EOF) {
+			return stats, nil
+		}
+		if err != nil {
+			return stats, err
+		}
+		stats.Rows++
+		if mapping != nil {
+			row = remapRow(row, mapping, len(*baseHeader))
+		}
+		if err := writer.Write(row); err != nil {
+			return stats, err
+		}
+	}
+}
+
+// isArchivedCSV reports whether an archive entry is a CSV file, skipping
+// directories and the hidden files some archivers add
+func isArchivedCSV(f *zip.File) bool {
+	name := f.Name
+	if f.FileInfo().IsDir() || strings.HasPrefix(name, "__MACOSX/") || strings.HasPrefix(path.Base(name), ".") {
+		return false
+	}
+	return strings.EqualFold(path.Ext(name), ".csv")
+}
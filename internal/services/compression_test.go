@@ -0,0 +1,114 @@
+package services
+
+import (
+	"archive/zip"
+	"bytes"
+	"compress/gzip"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/mussietl/csv-sales-api/internal/models"
+	"github.com/sirupsen/logrus"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// writeGzip writes data gzip-compressed to a file named name
+func writeGzip(t *testing.T, name, data string) string {
+	var buf bytes.Buffer
+	gz := gzip.NewWriter(&buf)
+	_, err := gz.Write([]byte(data))
+	require.NoError(t, err)
+	require.NoError(t, gz.Close())
+	path := filepath.Join(t.TempDir(), name)
+	require.NoError(t, os.WriteFile(path, buf.Bytes(), 0644))
+	return path
+}
+
+// writeZip writes a ZIP archive holding the given entries, in order
+func writeZip(t *testing.T, entries ...[2]string) string {
+	var buf bytes.Buffer
+	zw := zip.NewWriter(&buf)
+	for _, entry := range entries {
+		w, err := zw.Create(entry[0])
+		require.NoError(t, err)
+		_, err = w.Write([]byte(entry[1]))
+		require.NoError(t, err)
+	}
+	require.NoError(t, zw.Close())
+	path := filepath.Join(t.TempDir(), "stores.zip")
+	require.NoError(t, os.WriteFile(path, buf.Bytes(), 0644))
+	return path
+}
+
+func TestDetectCompression(t *testing.T) {
+	dir := t.TempDir()
+	plain := filepath.Join(dir, "plain.csv")
+	require.NoError(t, os.WriteFile(plain, []byte("Department,Sales\n"), 0644))
+	empty := filepath.Join(dir, "empty.csv")
+	require.NoError(t, os.WriteFile(empty, nil, 0644))
+
+	for path, want := range map[string]string{
+		plain: CompressionNone,
+		empty: CompressionNone,
+		// Detected by content, not by the name
+		writeGzip(t, "sales.csv", "x"):             CompressionGzip,
+		writeZip(t, [2]string{"a.csv", "x"}):       CompressionZip,
+		writeGzip(t, "sales.csv.gz", "Department"): CompressionGzip,
+	} {
+		got, err := DetectCompression(path)
+		require.NoError(t, err)
+		assert.Equal(t, want, got, path)
+	}
+	assert.False(t, IsCompressed(plain))
+	assert.True(t, IsCompressed(writeGzip(t, "sales.gz", "x")))
+}
+
+func TestProcessGzip(t *testing.T) {
+	logger := logrus.New()
+	logger.SetLevel(logrus.ErrorLevel)
+	cs := NewCSVService(logger)
+
+	path := writeGzip(t, "sales.csv.gz", "Department,Sales\nBooks,10\nToys,5\nBooks,2.5\n")
+	result, err := cs.Process(path, ProcessOptions{Strategy: StrategyParallel})
+	require.NoError(t, err)
+	assert.Equal(t, StrategyStreaming, result.Strategy)
+	assert.Equal(t, []DepartmentSummary{
+		{Department: "Books", TotalSales: amount(t, "12.5"), SalesCount: 2},
+		{Department: "Toys", TotalSales: models.WholeAmount(5), SalesCount: 1},
+	}, result.Summaries)
+
+	cs.SetLimits(CSVLimits{MaxDecompressedBytes: 20})
+	_, err = cs.Process(path, ProcessOptions{})
+	assert.ErrorIs(t, err, ErrCSVLimitExceeded)
+}
+
+func TestProcessZipArchive(t *testing.T) {
+	logger := logrus.New()
+	logger.SetLevel(logrus.ErrorLevel)
+	cs := NewCSVService(logger)
+
+	path := writeZip(t,
+		[2]string{"north/sales.csv", "Department,Sales\nBooks,10\nToys,5\n"},
+		[2]string{"__MACOSX/north/._sales.csv", "junk"},
+		[2]string{"README.txt", "not a CSV"},
+		// Columns are matched by name, in any order
+		[2]string{"south/SALES.CSV", "Region,sales,department\nSouth,20,Books\nSouth,abc,Toys\n"},
+	)
+	result, err := cs.Process(path, ProcessOptions{})
+	require.NoError(t, err)
+	assert.Equal(t, []models.SheetStats{{Name: "north/sales.csv", Rows: 2}, {Name: "south/SALES.CSV", Rows: 2}}, result.Entries)
+	assert.Equal(t, []DepartmentSummary{
+		{Department: "Books", TotalSales: models.WholeAmount(30), SalesCount: 2},
+		{Department: "Toys", TotalSales: models.WholeAmount(5), SalesCount: 1},
+	}, result.Summaries)
+	assert.Equal(t, 1, result.Rows.Skipped)
+
+	_, err = cs.Process(writeZip(t, [2]string{"notes.txt", "hello"}), ProcessOptions{})
+	assert.ErrorContains(t, err, "archive contains no CSV files")
+
+	cs.SetLimits(CSVLimits{MaxDecompressedBytes: 40})
+	_, err = cs.Process(path, ProcessOptions{})
+	assert.ErrorIs(t, err, ErrCSVLimitExceeded)
+}
@@ -0,0 +1,253 @@
+package services
+
+import (
+	"archive/zip"
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"golang.org/x/text/transform"
+)
+
+// ErrContentMismatch is returned for uploads whose content is not what
+// their extension claims, or is an archive built to exhaust the server
+var ErrContentMismatch = errors.New("file content does not match its extension")
+
+// Content detected in uploads other than delimited text
+const (
+	ContentText             = "text"
+	ContentExecutable       = "executable"
+	ContentMarkup           = "html"
+	ContentBinary           = "binary"
+	ContentGzip             = "gzip"
+	ContentZip              = "zip"
+	ContentCompressionBomb  = "compression bomb"
+	maxCompressionRatio     = 500
+	maxControlCharsFraction = 0.01
+)
+
+// ContentError describes an upload rejected by CheckContent
+type ContentError struct {
+	// Extension is the upload's extension, e.g. ".csv"
+	Extension string
+	// Detected is what the content turned out to be, e.g. ContentExecutable
+	Detected string
+	// Reason explains the detection
+	Reason string
+}
+
+func (e *ContentError) Error() string {
+	return fmt.Sprintf("%s upload rejected as %s: %s", e.Extension, e.Detected, e.Reason)
+}
+
+func (e *ContentError) Unwrap() error {
+	return ErrContentMismatch
+}
+
+// ContentSniffBytes is how much of an upload is examined to detect its content
+const ContentSniffBytes = charsetSniffBytes
+
+// binarySignatures are the magic bytes of file types that are never sales data
+var binarySignatures = []struct {
+	magic  []byte
+	detect string
+	name   string
+}{
+	{[]byte("MZ\x90\x00"), ContentExecutable, "Windows executable"},
+	{[]byte("MZP\x00"), ContentExecutable, "Windows executable"},
+	{[]byte("\x7fELF"), ContentExecutable, "ELF executable"},
+	{[]byte{0xfe, 0xed, 0xfa, 0xce}, ContentExecutable, "Mach-O executable"},
+	{[]byte{0xfe, 0xed, 0xfa, 0xcf}, ContentExecutable, "Mach-O executable"},
+	{[]byte{0xce, 0xfa, 0xed, 0xfe}, ContentExecutable, "Mach-O executable"},
+	{[]byte{0xcf, 0xfa, 0xed, 0xfe}, ContentExecutable, "Mach-O executable"},
+	{[]byte{0xca, 0xfe, 0xba, 0xbe}, ContentExecutable, "Mach-O or Java class file"},
+	{[]byte("#!"), ContentExecutable, "script"},
+	{[]byte("%PDF-"), ContentBinary, "PDF document"},
+	{[]byte("\x89PNG"), ContentBinary, "PNG image"},
+	{[]byte{0xff, 0xd8, 0xff}, ContentBinary, "JPEG image"},
+	{[]byte("GIF8"), ContentBinary, "GIF image"},
+	{[]byte{0xd0, 0xcf, 0x11, 0xe0}, ContentBinary, "legacy Office document"},
+	{[]byte("7z\xbc\xaf\x27\x1c"), ContentBinary, "7-Zip archive"},
+	{[]byte("Rar!\x1a\x07"), ContentBinary, "RAR archive"},
+}
+
+// markupPrefixes start HTML, XML and SVG documents, after any whitespace
+var markupPrefixes = []string{"<!doctype", "<html", "<head", "<body", "<script", "<iframe", "<?xml", "<svg", "<!--"}
+
+// CheckContent sniffs a stored upload and rejects, with a ContentError,
+// files that are executables, markup, other binary formats, or archives
+// that would expand beyond the decompression limit or by more than
+// maxCompressionRatio. Gzip and ZIP content is accepted under a .csv name,
+// as Process handles it whatever the extension.
+func (cs *CSVService) CheckContent(filePath string) error {
+	file, err := os.Open(filePath)
+	if err != nil {
+		return fmt.Errorf("failed to open file: %w", err)
+	}
+	defer file.Close()
+	head := make([]byte, ContentSniffBytes)
+	n, err := io.ReadFull(file, head)
+	if err != nil && !errors.Is(err, io.EOF) && !errors.Is(err, io.ErrUnexpectedEOF) {
+		return fmt.Errorf("failed to read file: %w", err)
+	}
+	head = head[:n]
+	info, err := file.Stat()
+	if err != nil {
+		return fmt.Errorf("failed to read file: %w", err)
+	}
+
+	ext := strings.ToLower(filepath.Ext(filePath))
+	mismatch := func(detected, reason string) error {
+		return &ContentError{Extension: ext, Detected: detected, Reason: reason}
+	}
+
+	detected := ""
+	switch {
+	case bytes.HasPrefix(head, gzipMagic):
+		detected = ContentGzip
+	case bytes.HasPrefix(head, zipMagic):
+		detected = ContentZip
+	}
+	switch ext {
+	case ".xlsx", ".zip":
+		if detected != ContentZip {
+			return mismatch(sniffKind(head), "not a ZIP archive")
+		}
+	case ".gz":
+		if detected != ContentGzip {
+			return mismatch(sniffKind(head), "not gzip-compressed")
+		}
+	}
+
+	switch detected {
+	case ContentZip:
+		return cs.checkZipExpansion(filePath, info.Size(), mismatch)
+	case ContentGzip:
+		return checkGzipExpansion(file, info.Size(), mismatch)
+	}
+	if kind := sniffText(head); kind != "" {
+		return mismatch(kind, describeContent(head))
+	}
+	return nil
+}
+
+// CheckStreamContent sniffs the start of an upload that is processed as it
+// is read, which can't be decompressed, so archives are rejected as well
+func CheckStreamContent(ext string, head []byte) error {
+	if kind := sniffText(head); kind != "" {
+		return &ContentError{Extension: strings.ToLower(ext), Detected: kind, Reason: describeContent(head)}
+	}
+	return nil
+}
+
+// checkZipExpansion rejects an archive whose entries declare more
+// uncompressed data than the limits allow. Declared sizes can lie, so
+// reading the entries is limited as well.
+func (cs *CSVService) checkZipExpansion(filePath string, size int64, mismatch func(string, string) error) error {
+	archive, err := zip.OpenReader(filePath)
+	if err != nil {
+		return mismatch(ContentBinary, "corrupt ZIP archive")
+	}
+	defer archive.Close()
+	var total uint64
+	for _, f := range archive.File {
+		total += f.UncompressedSize64
+	}
+	return checkExpansion(total, size, cs.limits.MaxDecompressedBytes, mismatch)
+}
+
+// checkGzipExpansion rejects a gzip file whose trailer declares an
+// expansion beyond maxCompressionRatio. The trailer holds the size modulo
+// 2^32, so larger files are caught by the limit applied while reading.
+func checkGzipExpansion(file *os.File, size int64, mismatch func(string, string) error) error {
+	if size < 18 {
+		return nil
+	}
+	trailer := make([]byte, 4)
+	if _, err := file.ReadAt(trailer, size-4); err != nil {
+		return fmt.Errorf("failed to read file: %w", err)
+	}
+	return checkExpansion(uint64(binary.LittleEndian.Uint32(trailer)), size, 0, mismatch)
+}
+
+// checkExpansion compares an archive's uncompressed size with its size and
+// the decompression limit, if any
+func checkExpansion(uncompressed uint64, size, limit int64, mismatch func(string, string) error) error {
+	if limit > 0 && uncompressed > uint64(limit) {
+		return mismatch(ContentCompressionBomb, fmt.Sprintf("expands to %d bytes, more than the limit of %d", uncompressed, limit))
+	}
+	if size > 0 && uncompressed/uint64(size) > maxCompressionRatio {
+		return mismatch(ContentCompressionBomb, fmt.Sprintf("expands %d times, more than %d", uncompressed/uint64(size), maxCompressionRatio))
+	}
+	return nil
+}
+
+// sniffText returns what the start of a file is when it isn't delimited
+// text, or "". Text in UTF-16 is decoded before it is examined.
+func sniffText(head []byte) string {
+	for _, signature := range binarySignatures {
+		if bytes.HasPrefix(head, signature.magic) {
+			return signature.detect
+		}
+	}
+	if bytes.HasPrefix(head, gzipMagic) || bytes.HasPrefix(head, zipMagic) {
+		return ContentBinary
+	}
+
+	encoding, bom := sniffEncoding(head)
+	text := head[bom:]
+	if decoder := encodingDecoder(encoding); decoder != nil {
+		if decoded, _, err := transform.Bytes(decoder, text); err == nil {
+			text = decoded
+		}
+	}
+	trimmed := strings.ToLower(strings.TrimLeft(string(text), " \t\r\n"))
+	for _, prefix := range markupPrefixes {
+		if strings.HasPrefix(trimmed, prefix) {
+			return ContentMarkup
+		}
+	}
+
+	control := 0
+	for _, r := range string(text) {
+		switch {
+		case r == 0:
+			return ContentBinary
+		case r < 0x20 && r != '\t' && r != '\n' && r != '\r', r == 0x7f:
+			control++
+		}
+	}
+	if len(text) > 0 && float64(control) > maxControlCharsFraction*float64(len(text)) {
+		return ContentBinary
+	}
+	return ""
+}
+
+// sniffKind is sniffText, calling delimited text ContentText
+func sniffKind(head []byte) string {
+	if kind := sniffText(head); kind != "" {
+		return kind
+	}
+	return ContentText
+}
+
+// describeContent names what a rejected file looks like
+func describeContent(head []byte) string {
+	for _, signature := range binarySignatures {
+		if bytes.HasPrefix(head, signature.magic) {
+			return "content is a " + signature.name
+		}
+	}
+	if bytes.HasPrefix(head, gzipMagic) || bytes.HasPrefix(head, zipMagic) {
+		return "content is an archive"
+	}
+	if sniffText(head) == ContentMarkup {
+		return "content is an HTML or XML document"
+	}
+	return "content has control characters that don't occur in text"
+}
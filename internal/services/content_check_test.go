@@ -0,0 +1,58 @@
+package services
+
+import (
+	"errors"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/sirupsen/logrus"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestCheckContent(t *testing.T) {
+	logger := logrus.New()
+	logger.SetLevel(logrus.ErrorLevel)
+	cs := NewCSVService(logger)
+	write := func(name, data string) string {
+		path := filepath.Join(t.TempDir(), name)
+		require.NoError(t, os.WriteFile(path, []byte(data), 0644))
+		return path
+	}
+	rejected := func(path, detected string) {
+		err := cs.CheckContent(path)
+		require.ErrorIs(t, err, ErrContentMismatch, path)
+		var contentErr *ContentError
+		require.True(t, errors.As(err, &contentErr))
+		assert.Equal(t, detected, contentErr.Detected, path)
+	}
+
+	// Delimited text in any encoding passes
+	assert.NoError(t, cs.CheckContent(write("sales.csv", "Department Name;Number of Sales\nBooks;1\n")))
+	assert.NoError(t, cs.CheckContent(write("sales.csv", "\xff\xfeD\x00,\x00S\x00\n\x00")))
+	assert.NoError(t, cs.CheckContent(write("sales.csv", "Abteilung,Umsatz\nB\xfccher,1\n")))
+	assert.NoError(t, cs.CheckContent(write("empty.csv", "")))
+	assert.NoError(t, cs.CheckContent(writeGzip(t, "sales.csv", "Department Name,Number of Sales\nBooks,1\n")))
+	assert.NoError(t, cs.CheckContent(writeZip(t, [2]string{"a.csv", "Department Name,Number of Sales\nBooks,1\n"})))
+
+	rejected(write("sales.csv", "MZ\x90\x00\x03\x00\x00\x00\x04\x00"), ContentExecutable)
+	rejected(write("sales.csv", "\x7fELF\x02\x01\x01"), ContentExecutable)
+	rejected(write("sales.csv", "#!/bin/sh\nrm -rf /\n"), ContentExecutable)
+	rejected(write("sales.csv", "\n  <!DOCTYPE html><html><body>Sales</body></html>"), ContentMarkup)
+	rejected(write("sales.csv", "\xef\xbb\xbf<script>alert(1)</script>"), ContentMarkup)
+	rejected(write("sales.csv", "%PDF-1.7\n"), ContentBinary)
+	rejected(write("sales.csv", "Department\x01\x02\x03\x04,Sales\x05\x06\n"), ContentBinary)
+	rejected(write("sales.xlsx", "Department Name,Number of Sales\n"), ContentText)
+	rejected(write("sales.gz", "<html></html>"), ContentMarkup)
+
+	// Archives expanding far beyond their size, or past the limit, are bombs
+	rejected(writeGzip(t, "sales.csv", strings.Repeat("0", 1<<22)), ContentCompressionBomb)
+	rejected(writeZip(t, [2]string{"a.csv", strings.Repeat("0", 1<<22)}), ContentCompressionBomb)
+	cs.SetLimits(CSVLimits{MaxDecompressedBytes: 20})
+	rejected(writeZip(t, [2]string{"a.csv", "Department Name,Number of Sales\nBooks,1\n"}), ContentCompressionBomb)
+
+	assert.NoError(t, CheckStreamContent(".csv", []byte("Department Name,Number of Sales\n")))
+	assert.ErrorIs(t, CheckStreamContent(".csv", gzipMagic), ErrContentMismatch)
+}
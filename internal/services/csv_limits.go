@@ -0,0 +1,146 @@
+package services
+
+import (
+	"bufio"
+	"errors"
+	"fmt"
+	"io"
+)
+
+// ErrCSVLimitExceeded is returned when a file exceeds a structural limit
+var ErrCSVLimitExceeded = errors.New("csv limit exceeded")
+
+// CSVLimits bounds the shape of files CSVService will parse
+type CSVLimits struct {
+	// MaxColumns is the maximum number of columns in the header row
+	MaxColumns int
+	// MaxHeaderBytes is the maximum size of the header row in bytes
+	MaxHeaderBytes int
+	// MaxDecompressedBytes bounds the decompressed size of a gzip file or of
+	// all CSV files in a ZIP archive; 0 is unlimited
+	MaxDecompressedBytes int64
+}
+
+// DefaultCSVLimits are the limits used by NewCSVService
+var DefaultCSVLimits = CSVLimits{
+	MaxColumns:     10000,
+	MaxHeaderBytes: 1 << 20,
+	// 4 GiB
+	MaxDecompressedBytes: 4 << 30,
+}
+
+// readHeaderLine reads the first CSV record's raw bytes, honouring quoted
+// newlines, and fails once more than maxBytes have been read
+func readHeaderLine(r *bufio.Reader, maxBytes int) ([]byte, error) {
+	var line []byte
+	inQuote := false
+	for {
+		b, err := r.ReadByte()
+		if err == io.EOF {
+			if len(line) == 0 {
+				return nil, io.EOF
+			}
+			return line, nil
+		}
+		if err != nil {
+			return nil, err
+		}
+
+		line = append(line, b)
+		if maxBytes > 0 && len(line) > maxBytes {
+			return nil, fmt.Errorf("%w: header row is longer than %d bytes", ErrCSVLimitExceeded, maxBytes)
+		}
+
+		switch {
+		case b == '"':
+			inQuote = !inQuote
+		case b == '\n' && !inQuote:
+			return line, nil
+		}
+	}
+}
+
+// rowTruncator drops every field after the first keep fields of each record
+// so that the CSV reader never materializes unneeded trailing columns. It
+// follows RFC 4180 quoting, so quoted delimiters and newlines in discarded
+// fields do not split or end records.
+type rowTruncator struct {
+	src        io.Reader
+	keep       int
+	comma      byte
+	scratch    []byte
+	field      int
+	fieldStart bool
+	inQuote    bool
+	justClosed bool
+	discarding bool
+}
+
+// newRowTruncator wraps r, keeping the first keep fields of each record
+// separated by comma
+func newRowTruncator(r io.Reader, keep int, comma byte) *rowTruncator {
+	return &rowTruncator{src: r, keep: keep, comma: comma, fieldStart: true}
+}
+
+func (t *rowTruncator) Read(p []byte) (int, error) {
+	if len(t.scratch) < len(p) {
+		t.scratch = make([]byte, len(p))
+	}
+	for {
+		m, err := t.src.Read(t.scratch[:len(p)])
+		n := t.filter(t.scratch[:m], p)
+		if n > 0 || err != nil {
+			return n, err
+		}
+	}
+}
+
+// filter copies the kept bytes of in to out and returns how many were written
+func (t *rowTruncator) filter(in, out []byte) int {
+	n := 0
+	for _, b := range in {
+		emit := !t.discarding
+
+		switch {
+		case t.inQuote:
+			if b == '"' {
+				t.inQuote = false
+				t.justClosed = true
+			}
+		case b == '"' && (t.fieldStart || t.justClosed):
+			// Opening quote, or the second half of an escaped quote
+			t.inQuote = true
+			t.justClosed = false
+		case b == t.comma:
+			t.field++
+			t.fieldStart = true
+			t.justClosed = false
+			if t.field >= t.keep {
+				t.discarding = true
+				emit = false
+			}
+			if emit {
+				out[n] = b
+				n++
+			}
+			continue
+		case b == '\n':
+			t.field = 0
+			t.discarding = false
+			t.fieldStart = true
+			t.justClosed = false
+			out[n] = b
+			n++
+			continue
+		default:
+			t.justClosed = false
+		}
+
+		t.fieldStart = false
+		if emit {
+			out[n] = b
+			n++
+		}
+	}
+	return n
+}
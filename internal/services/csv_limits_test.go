@@ -0,0 +1,88 @@
+package services
+
+import (
+	"bufio"
+	"encoding/csv"
+	"fmt"
+	"github.com/mussietl/csv-sales-api/internal/models"
+	"io"
+	"os"
+	"strings"
+	"testing"
+
+	"github.com/sirupsen/logrus"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestRowTruncator(t *testing.T) {
+	input := "department,sales,notes,extra\n" +
+		"Electronics,100,\"multi\nline, note\",x\n" +
+		"\"Home, Garden\",50,\"say \"\"hi\"\"\",y\r\n" +
+		"Books,7\n"
+
+	truncated, err := io.ReadAll(newRowTruncator(strings.NewReader(input), 2, ','))
+	require.NoError(t, err)
+	assert.Equal(t, "department,sales\nElectronics,100\n\"Home, Garden\",50\nBooks,7\n", string(truncated))
+
+	// Truncated output parses to the same leading fields as the original
+	fullReader := csv.NewReader(strings.NewReader(input))
+	fullReader.FieldsPerRecord = -1
+	full, err := fullReader.ReadAll()
+	require.NoError(t, err)
+	reader := csv.NewReader(newRowTruncator(strings.NewReader(input), 2, ','))
+	reader.FieldsPerRecord = -1
+	cut, err := reader.ReadAll()
+	require.NoError(t, err)
+	require.Len(t, cut, len(full))
+	for i := range full {
+		n := len(cut[i])
+		assert.Equal(t, full[i][:n], cut[i])
+	}
+}
+
+func TestReadHeaderLine(t *testing.T) {
+	r := bufio.NewReader(strings.NewReader("\"dept\nname\",sales\nA,1\n"))
+	line, err := readHeaderLine(r, 100)
+	require.NoError(t, err)
+	assert.Equal(t, "\"dept\nname\",sales\n", string(line))
+
+	r = bufio.NewReader(strings.NewReader(strings.Repeat("x", 200) + "\n"))
+	_, err = readHeaderLine(r, 100)
+	assert.ErrorIs(t, err, ErrCSVLimitExceeded)
+}
+
+func TestCSVServiceLimits(t *testing.T) {
+	logger := logrus.New()
+	logger.SetLevel(logrus.ErrorLevel)
+	csvService := NewCSVService(logger)
+	csvService.SetLimits(CSVLimits{MaxColumns: 50, MaxHeaderBytes: 4096})
+
+	writeCSV := func(content string) string {
+		tempFile, err := os.CreateTemp("", "test_*.csv")
+		require.NoError(t, err)
+		t.Cleanup(func() { os.Remove(tempFile.Name()) })
+		_, err = tempFile.WriteString(content)
+		require.NoError(t, err)
+		tempFile.Close()
+		return tempFile.Name()
+	}
+
+	var wide strings.Builder
+	wide.WriteString("department,sales")
+	for i := 0; i < 60; i++ {
+		fmt.Fprintf(&wide, ",c%d", i)
+	}
+	_, err := csvService.Process(writeCSV(wide.String()+"\nA,1\n"), ProcessOptions{})
+	assert.ErrorIs(t, err, ErrCSVLimitExceeded)
+
+	_, err = csvService.Process(writeCSV(strings.Repeat("d", 5000)+",sales\nA,1\n"), ProcessOptions{})
+	assert.ErrorIs(t, err, ErrCSVLimitExceeded)
+
+	// Ultra-wide data rows are fine as long as the header is within limits
+	row := "Electronics,10" + strings.Repeat(",\"x,\ny\"", 10000) + "\n"
+	result, err := csvService.Process(writeCSV("department,sales,rest\n"+row+row), ProcessOptions{})
+	require.NoError(t, err)
+	require.Len(t, result.Summaries, 1)
+	assert.Equal(t, models.WholeAmount(20), result.Summaries[0].TotalSales)
+}
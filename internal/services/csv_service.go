@@ -1,144 +1,1177 @@
 package services
 
 import (
+	"bufio"
+	"bytes"
 	"encoding/csv"
+	"errors"
 	"fmt"
 	"io"
 	"os"
+	"sort"
 	"strconv"
 	"strings"
 
+	"github.com/mussietl/csv-sales-api/internal/models"
 	"github.com/sirupsen/logrus"
 )
 
 // CSVService handles CSV processing operations
 type CSVService struct {
-	logger *logrus.Logger
+	limits        CSVLimits
+	thresholds    StrategyThresholds
+	parser        string
+	exchangeRates ExchangeRates
+	logger        *logrus.Logger
 }
 
 // NewCSVService creates a new CSVService instance
 func NewCSVService(logger *logrus.Logger) *CSVService {
 	return &CSVService{
-		logger: logger,
+		limits:     DefaultCSVLimits,
+		thresholds: DefaultStrategyThresholds(),
+		logger:     logger,
 	}
 }
 
+// SetLimits replaces the structural limits applied to parsed files
+func (cs *CSVService) SetLimits(limits CSVLimits) {
+	cs.limits = limits
+}
+
+// SetExchangeRates sets the rates department totals are converted to a
+// base currency with
+func (cs *CSVService) SetExchangeRates(rates ExchangeRates) {
+	cs.exchangeRates = rates
+}
+
+// HasExchangeRates reports whether totals can be converted to a base currency
+func (cs *CSVService) HasExchangeRates() bool {
+	return cs.exchangeRates != nil
+}
+
+// log returns the logger for processing a file with opts
+func (cs *CSVService) log(opts ProcessOptions) logrus.FieldLogger {
+	if opts.Logger != nil {
+		return opts.Logger
+	}
+	return cs.logger
+}
+
+// SetParser sets the row parser used when a request doesn't choose one
+func (cs *CSVService) SetParser(parser string) error {
+	if err := ValidateParser(parser); err != nil {
+		return err
+	}
+	cs.parser = parser
+	return nil
+}
+
+// Reasons a data row can be skipped
+const (
+	SkipInsufficientColumns = "insufficient_columns"
+	SkipEmptyDepartment     = "empty_department"
+	SkipInvalidSales        = "invalid_sales"
+)
+
+// errorReportHeader is the header of the error report
+var errorReportHeader = []string{"Row Number", "Reason", "Error", "Department", "Sales"}
+
+// rejectedRow is a data row skipped during processing
+type rejectedRow struct {
+	row        int
+	reason     string
+	detail     string
+	department string
+	sales      string
+}
+
+func (r rejectedRow) record() []string {
+	return []string{strconv.Itoa(r.row), r.reason, r.detail, r.department, r.sales}
+}
+
+// fieldAt returns the field at index, or "" for short rows
+func fieldAt(record []string, index int) string {
+	if index < len(record) {
+		return record[index]
+	}
+	return ""
+}
+
+// Aggregate output orderings
+const (
+	// OrderUnspecified is the default, OrderDepartment
+	OrderUnspecified = ""
+	// OrderDepartment sorts departments by name, byte-wise
+	OrderDepartment = "department"
+	// OrderFirstSeen keeps the order in which departments first appear
+	OrderFirstSeen = "first_seen"
+	// OrderTotalDesc sorts by total sales, largest first, then by name
+	OrderTotalDesc = "total_desc"
+)
+
+// ValidateOrder checks that order is a known output ordering
+func ValidateOrder(order string) error {
+	switch order {
+	case OrderUnspecified, OrderDepartment, OrderFirstSeen, OrderTotalDesc:
+		return nil
+	}
+	return fmt.Errorf("invalid order %q: expected department, first_seen or total_desc", order)
+}
+
+// SortSummaries puts summaries in the given order. OrderFirstSeen leaves
+// them as they are; every other order is total, so the same totals always
+// come out in the same order.
+func SortSummaries(summaries []DepartmentSummary, order string) {
+	switch order {
+	case OrderFirstSeen:
+	case OrderTotalDesc:
+		sort.SliceStable(summaries, func(i, j int) bool {
+			if c := summaries[i].TotalSales.Cmp(summaries[j].TotalSales); c != 0 {
+				return c > 0
+			}
+			return summaries[i].Department < summaries[j].Department
+		})
+	default:
+		sort.SliceStable(summaries, func(i, j int) bool {
+			return summaries[i].Department < summaries[j].Department
+		})
+	}
+}
+
+// ProcessOptions controls optional aggregation behaviour
+type ProcessOptions struct {
+	// DistinctColumns lists columns whose distinct values are counted per department
+	DistinctColumns []string
+	// DistinctMode is one of DistinctModeAuto, DistinctModeExact or DistinctModeApprox
+	DistinctMode string
+	// Stats describes the spread of each department's sale amounts in
+	// DepartmentSummary.Stats
+	Stats bool
+	// HeaderNormalization overrides DefaultHeaderNormalization when set
+	HeaderNormalization *Normalization
+	// ValueNormalization overrides DefaultValueNormalization when set. It
+	// applies to department and distinct values; sales numbers are always trimmed.
+	ValueNormalization *Normalization
+	// Order controls the order of the summaries; by default they are
+	// sorted by department name
+	Order string
+	// KeepTotalRows aggregates rows labelled TOTAL like any other department
+	// instead of treating them as footer totals to reconcile against
+	KeepTotalRows bool
+	// CleanedOutput, when set, receives every accepted row as CSV in the
+	// original file order, prefixed with its original row number
+	CleanedOutput io.Writer
+	// ErrorReport, when set, receives every skipped row as CSV in the
+	// original file order: its row number, the reason and the raw values
+	ErrorReport io.Writer
+	// Progress, when set, is called with the rows and bytes read so far at
+	// most every few hundred milliseconds while the file is read, and once
+	// more when it has been read
+	Progress func(ProcessProgress)
+	// Sheets selects the sheets of an XLSX workbook to process: SheetsFirst,
+	// SheetsAll or a name pattern. It is ignored for CSV files.
+	Sheets string
+	// Strategy forces a processing strategy instead of choosing one by file size
+	Strategy string
+	// Numbers is NumbersStrict or NumbersLenient
+	Numbers string
+	// Percent sets how lenient mode converts values ending in %
+	Percent string
+	// MissingValue is MissingValueSkip, MissingValueZero or MissingValueError
+	MissingValue string
+	// TraceColumns adds the column detection decisions to the schema report
+	TraceColumns bool
+	// Parser selects the row parser; ParserDefault uses the service's configured parser
+	Parser string
+	// GroupBy lists columns whose value combinations are aggregated into
+	// ProcessResult.Aggregation; with only Aggregation set, the department column
+	GroupBy []string
+	// Aggregation is the function applied to each group's sales
+	Aggregation string
+	// Aggregations are computed alongside GroupBy in the same pass, into
+	// ProcessResult.Aggregations
+	Aggregations []AggregationSpec
+	// Heatmap breaks department sales down by weekday and hour of day into
+	// ProcessResult.Heatmap
+	Heatmap bool
+	// DepartmentColumn and SalesColumn, when set, name the department and
+	// sales columns by header name or zero-based index instead of detecting them
+	DepartmentColumn string
+	SalesColumn      string
+	// TimestampColumn names the column the heatmap reads; by default the
+	// first column with a timestamp-like name is used
+	TimestampColumn string
+	// Bucket breaks department sales down by day, week, month or quarter
+	// into ProcessResult.TimeSeries; "" skips the breakdown
+	Bucket string
+	// DateColumn names the column the time series reads; by default the
+	// first column with a date-like name is used
+	DateColumn string
+	// FiscalCalendar labels time series buckets with fiscal periods; nil
+	// uses calendar ones
+	FiscalCalendar *FiscalCalendar
+	// Delimiter separates fields; DelimiterAuto detects it from the header
+	Delimiter string
+	// PIIMode scans the other columns of accepted rows for personal data;
+	// empty or PIIModeOff skips the scan
+	PIIMode string
+	// Schema, when set, validates the header and every row against a
+	// profile; uploads with violations fail with a SchemaError
+	Schema *SchemaProfile
+	// Currency breaks department sales down by currency into
+	// ProcessResult.Currency; rows without a valid code are skipped
+	Currency bool
+	// CurrencyColumn names the column Currency reads; by default the first
+	// column with a currency-like name is used
+	CurrencyColumn string
+	// BaseCurrency, with Currency, converts department totals to this
+	// currency with the service's exchange rates
+	BaseCurrency string
+	// Logger, when set, logs the processing of this file in place of the
+	// service's logger, so its messages carry the request's fields
+	Logger *logrus.Entry
+
+	// progress reports to Progress
+	progress *progressTracker
+	// encoding is the file's encoding when it wasn't plain UTF-8
+	encoding string
+}
+
+// ProcessResult holds the outcome of processing a CSV file
+type ProcessResult struct {
+	Summaries       []DepartmentSummary
+	DistinctColumns []string
+	Schema          *models.SchemaReport
+	Rows            models.RowStats
+	// FooterTotal is the sales value of the last TOTAL row ending the data, if any
+	FooterTotal *models.Amount
+	// Sheets counts the rows read from each sheet of an XLSX workbook
+	Sheets []models.SheetStats
+	// Entries counts the rows read from each CSV file of a ZIP archive
+	Entries []models.SheetStats
+	// Strategy is the processing strategy that was used
+	Strategy string
+	// Aggregation holds the groups when GroupBy or Aggregation was requested
+	Aggregation *models.AggregationResult
+	// Aggregations holds the result of each of the requested Aggregations
+	Aggregations []*models.AggregationResult
+	// Heatmap holds the weekday and hour breakdown when Heatmap was requested
+	Heatmap *models.SalesHeatmap
+	// TimeSeries holds the sales per date bucket when Bucket was requested
+	TimeSeries *models.SalesTimeSeries
+	// Currency holds the sales per currency when Currency was requested
+	Currency *models.CurrencyBreakdown
+	// PII lists the columns where personal data was found
+	PII *models.PIIReport
+}
+
 // ProcessSalesCSV processes a CSV file and returns aggregated sales data by department
 func (cs *CSVService) ProcessSalesCSV(filePath string) ([]DepartmentSummary, error) {
-	// Open the CSV file
-	file, err := openFile(filePath)
+	result, err := cs.Process(filePath, ProcessOptions{})
+	if err != nil {
+		return nil, err
+	}
+	return result.Summaries, nil
+}
+
+// Process processes a CSV file, the selected sheets of an XLSX workbook, a
+// gzip-compressed CSV file or the CSV files of a ZIP archive, using the
+// given options. Compression is detected from the file's contents.
+func (cs *CSVService) Process(filePath string, opts ProcessOptions) (*ProcessResult, error) {
+	if IsXLSX(filePath) {
+		return cs.processWorkbook(filePath, opts)
+	}
+	compression, err := DetectCompression(filePath)
 	if err != nil {
 		return nil, fmt.Errorf("failed to open file: %w", err)
 	}
-	defer file.Close()
+	switch compression {
+	case CompressionGzip:
+		return cs.processGzip(filePath, opts)
+	case CompressionZip:
+		return cs.processArchive(filePath, opts)
+	}
+	return cs.processCSV(filePath, opts)
+}
 
-	// Create CSV reader
-	reader := csv.NewReader(file)
+// processCSV processes a CSV file using the given options
+func (cs *CSVService) processCSV(filePath string, opts ProcessOptions) (*ProcessResult, error) {
+	info, err := os.Stat(filePath)
+	if err != nil {
+		return nil, fmt.Errorf("failed to open file: %w", err)
+	}
+	// Files in another encoding are transcoded to UTF-8 first, since
+	// parallel processing splits the file at byte offsets
+	encoding, bom, err := sniffFileEncoding(filePath)
+	if err != nil {
+		return nil, fmt.Errorf("failed to open file: %w", err)
+	}
+	if encodingDecoder(encoding) != nil {
+		transcoded, err := transcodeFile(filePath, encoding, bom)
+		if err != nil {
+			return nil, err
+		}
+		defer os.Remove(transcoded)
+		cs.log(opts).Infof("Transcoded %s file to UTF-8", encoding)
+		opts.encoding = encoding
+		return cs.processCSV(transcoded, opts)
+	}
+	if bom > 0 {
+		opts.encoding = encoding
+	}
+
+	strategy := cs.selectStrategy(info.Size(), opts)
+	if opts.Parser == ParserDefault {
+		opts.Parser = cs.parser
+	}
+	opts.progress = newProgressTracker(opts.Progress, info.Size())
 
-	// Read header row first
-	header, err := reader.Read()
+	// Open the CSV file past any byte order mark, or read it whole for small files
+	var source io.Reader
+	if strategy == StrategyInMemory {
+		data, err := os.ReadFile(filePath)
+		if err != nil {
+			return nil, fmt.Errorf("failed to open file: %w", err)
+		}
+		source = bytes.NewReader(data[bom:])
+	} else {
+		file, err := openFile(filePath)
+		if err != nil {
+			return nil, fmt.Errorf("failed to open file: %w", err)
+		}
+		defer file.Close()
+		if _, err := io.CopyN(io.Discard, file, int64(bom)); err != nil {
+			return nil, fmt.Errorf("failed to read file: %w", err)
+		}
+		source = file
+	}
+
+	parallel := func(layout columnLayout, dataStart int64, valueNorm Normalization) (*rowAggregator, error) {
+		return cs.aggregateParallel(filePath, int64(bom)+dataStart, info.Size(), layout, valueNorm, opts)
+	}
+	return cs.processSource(bufio.NewReaderSize(opts.progress.reader(source), 64*1024), strategy, opts, parallel)
+}
+
+// ProcessStream processes CSV data read from r in a single streaming pass.
+// Nothing is written to disk unless opts.CleanedOutput does so.
+func (cs *CSVService) ProcessStream(r io.Reader, opts ProcessOptions) (*ProcessResult, error) {
+	if opts.Parser == ParserDefault {
+		opts.Parser = cs.parser
+	}
+	opts.progress = newProgressTracker(opts.Progress, 0)
+	buffered, encoding, err := decodeCharset(bufio.NewReaderSize(opts.progress.reader(r), 64*1024))
 	if err != nil {
-		cs.logger.Errorf("Failed to read CSV header: %v", err)
+		return nil, fmt.Errorf("failed to read CSV data: %w", err)
+	}
+	opts.encoding = encoding
+	return cs.processSource(buffered, StrategyStreaming, opts, nil)
+}
+
+// parallelAggregator aggregates the data rows starting at dataStart concurrently
+type parallelAggregator func(layout columnLayout, dataStart int64, valueNorm Normalization) (*rowAggregator, error)
+
+// processSource parses the header and aggregates the rows of buffered with
+// the given strategy. parallel is used for StrategyParallel and must be set
+// when that strategy can be chosen.
+func (cs *CSVService) processSource(buffered *bufio.Reader, strategy string, opts ProcessOptions, parallel parallelAggregator) (*ProcessResult, error) {
+	buffered = newLineEndingReader(buffered)
+	parsed, err := cs.readHeader(buffered, opts)
+	if err != nil {
+		return nil, err
+	}
+	headerLine, comma, header, normalizedHeader := parsed.line, parsed.comma, parsed.raw, parsed.normalized
+	headerNorm, valueNorm := parsed.headerNorm, parsed.valueNorm
+	departmentIndex, salesIndex := parsed.department, parsed.sales
+
+	distinctIndices, err := cs.findColumns(normalizedHeader, opts.DistinctColumns, headerNorm, "distinct")
+	if err != nil {
+		return nil, err
+	}
+	var groupIndices []int
+	if len(opts.GroupBy) > 0 {
+		if groupIndices, err = cs.findColumns(normalizedHeader, opts.GroupBy, headerNorm, "group_by"); err != nil {
+			return nil, err
+		}
+	} else if opts.Aggregation != AggregationDefault {
+		groupIndices = []int{departmentIndex}
+	}
+	aggregationIndices := make([][]int, len(opts.Aggregations))
+	for i, spec := range opts.Aggregations {
+		if aggregationIndices[i], err = cs.findColumns(normalizedHeader, spec.GroupBy, headerNorm, "aggregations"); err != nil {
+			return nil, err
+		}
+	}
+
+	var piiIndices []int
+	if opts.PIIMode != "" && opts.PIIMode != PIIModeOff {
+		for i := range header {
+			if i != departmentIndex && i != salesIndex {
+				piiIndices = append(piiIndices, i)
+			}
+		}
+	}
+
+	timestampIndex := -1
+	if opts.Heatmap {
+		if timestampIndex, err = cs.findTimestampColumn(normalizedHeader, opts.TimestampColumn, headerNorm); err != nil {
+			return nil, err
+		}
+	}
+
+	currencyIndex := -1
+	if opts.Currency {
+		if currencyIndex, err = cs.findCurrencyColumn(normalizedHeader, opts.CurrencyColumn, headerNorm); err != nil {
+			return nil, err
+		}
+	}
+
+	dateIndex := -1
+	if opts.Bucket != "" {
+		if dateIndex, err = cs.findDateColumn(normalizedHeader, opts.DateColumn, headerNorm); err != nil {
+			return nil, err
+		}
+	}
+
+	var schemaRules *schemaLayout
+	if opts.Schema != nil {
+		var violations []models.SchemaViolation
+		if schemaRules, violations = resolveSchema(opts.Schema, header, normalizedHeader, headerNorm); len(violations) > 0 {
+			return nil, &SchemaError{Profile: opts.Schema.Name, Violations: violations}
+		}
+	}
+
+	schema := parsed.schema(opts)
+
+	layout := columnLayout{
+		department:   departmentIndex,
+		sales:        salesIndex,
+		distinct:     distinctIndices,
+		groupBy:      groupIndices,
+		aggregations: aggregationIndices,
+		timestamp:    timestampIndex,
+		date:         dateIndex,
+		currency:     currencyIndex,
+		pii:          piiIndices,
+		schema:       schemaRules,
+		comma:        comma,
+	}
+
+	var agg *rowAggregator
+	if strategy == StrategyParallel {
+		agg, err = parallel(layout, int64(len(headerLine)), valueNorm)
+	} else {
+		agg = newRowAggregator(layout, valueNorm, opts, cs.log(opts))
+		if opts.CleanedOutput != nil {
+			agg.cleaned = csv.NewWriter(opts.CleanedOutput)
+			if err := agg.cleaned.Write([]string{"Row Number", "Department Name", "Number of Sales"}); err != nil {
+				return nil, fmt.Errorf("failed to write cleaned output: %w", err)
+			}
+		}
+		if opts.ErrorReport != nil {
+			agg.report = csv.NewWriter(opts.ErrorReport)
+			if err := agg.report.Write(errorReportHeader); err != nil {
+				return nil, fmt.Errorf("failed to write error report: %w", err)
+			}
+		}
+		// Row numbers start from 1 since we already read the header
+		err = agg.consume(buffered, 1, "")
+		agg.closeFooter()
+		if len(agg.blank) > 0 {
+			cs.log(opts).Infof("Ignored %d blank rows at the end of the file", len(agg.blank))
+		}
+		if err == nil && agg.cleaned != nil {
+			agg.cleaned.Flush()
+			if err = agg.cleaned.Error(); err != nil {
+				err = fmt.Errorf("failed to write cleaned output: %w", err)
+			}
+		}
+	}
+	if err == nil && opts.ErrorReport != nil {
+		err = agg.flushReport(opts.ErrorReport)
+	}
+	var piiErr *PIIError
+	if errors.As(err, &piiErr) {
+		piiErr.Column = header[piiErr.Index]
+		cs.log(opts).Warnf("Rejecting file: %v", err)
+	}
+	if err != nil {
+		return nil, err
+	}
+	if len(agg.schemaViolations) > 0 {
+		return nil, &SchemaError{Profile: opts.Schema.Name, Violations: agg.schemaViolations}
+	}
+	opts.progress.finish()
+
+	// Check if we processed any data
+	if len(agg.departmentSales) == 0 {
+		return nil, fmt.Errorf("no valid data rows found in CSV file")
+	}
+
+	if agg.currencies != nil && opts.BaseCurrency != "" {
+		if cs.exchangeRates == nil {
+			return nil, fmt.Errorf("%w: no exchange rates are configured", ErrExchangeRate)
+		}
+		if err := agg.currencies.convert(opts.BaseCurrency, cs.exchangeRates); err != nil {
+			return nil, err
+		}
+	}
+	summaries := agg.summaries(opts)
+	cs.log(opts).Infof("Processed %d departments from CSV file using %s strategy", len(summaries), strategy)
+	result := &ProcessResult{
+		Summaries:       summaries,
+		DistinctColumns: opts.DistinctColumns,
+		Schema:          schema,
+		Rows:            agg.rows,
+		FooterTotal:     agg.footerTotal,
+		Strategy:        strategy,
+	}
+	if agg.groups != nil {
+		groupBy := make([]string, len(groupIndices))
+		for i, index := range groupIndices {
+			groupBy[i] = header[index]
+		}
+		result.Aggregation = agg.groups.result(groupBy, opts.Aggregation, header[salesIndex], opts.Order)
+	}
+	for i, groups := range agg.aggregations {
+		groupBy := make([]string, len(aggregationIndices[i]))
+		for j, index := range aggregationIndices[i] {
+			groupBy[j] = header[index]
+		}
+		result.Aggregations = append(result.Aggregations, groups.result(groupBy, opts.Aggregations[i].Function, header[salesIndex], opts.Order))
+	}
+	if agg.heatmap != nil {
+		result.Heatmap = agg.heatmap.result(header[timestampIndex], summaries)
+	}
+	if agg.timeSeries != nil {
+		result.TimeSeries = agg.timeSeries.result(header[dateIndex], summaries)
+	}
+	if agg.currencies != nil {
+		result.Currency = agg.currencies.result(header[currencyIndex], opts.BaseCurrency, summaries)
+	}
+	if result.PII = agg.pii.report(opts.PIIMode, header); result.PII != nil {
+		cs.log(opts).Warnf("Personal data found in %d columns", len(result.PII.Columns))
+	}
+	return result, nil
+}
+
+// csvHeader is a parsed header row with its department and sales columns
+type csvHeader struct {
+	line       []byte
+	comma      byte
+	raw        []string
+	normalized []string
+	headerNorm Normalization
+	valueNorm  Normalization
+	department int
+	sales      int
+	trace      []*models.ColumnMatch
+}
+
+// readHeader reads the header row and finds the department and sales
+// columns, failing with a ColumnMatchError when they can't be found
+func (cs *CSVService) readHeader(buffered *bufio.Reader, opts ProcessOptions) (*csvHeader, error) {
+	// Read header row first, bounded so a pathological header can't exhaust memory
+	headerLine, err := readHeaderLine(buffered, cs.limits.MaxHeaderBytes)
+	if err != nil {
+		cs.log(opts).Errorf("Failed to read CSV header: %v", err)
+		return nil, fmt.Errorf("failed to read CSV header: %w", err)
+	}
+	comma := resolveDelimiter(opts.Delimiter, headerLine)
+	headerReader := csv.NewReader(bytes.NewReader(headerLine))
+	headerReader.Comma = rune(comma)
+	header, err := headerReader.Read()
+	if err != nil {
+		cs.log(opts).Errorf("Failed to read CSV header: %v", err)
 		return nil, fmt.Errorf("failed to read CSV header: %w", err)
 	}
+	if cs.limits.MaxColumns > 0 && len(header) > cs.limits.MaxColumns {
+		return nil, fmt.Errorf("%w: header has %d columns, maximum is %d", ErrCSVLimitExceeded, len(header), cs.limits.MaxColumns)
+	}
+
+	parsed := &csvHeader{line: headerLine, comma: comma, raw: header, headerNorm: DefaultHeaderNormalization, valueNorm: DefaultValueNormalization}
+	if opts.HeaderNormalization != nil {
+		parsed.headerNorm = *opts.HeaderNormalization
+	}
+	if opts.ValueNormalization != nil {
+		parsed.valueNorm = *opts.ValueNormalization
+	}
 
 	// Parse header to find department and sales columns
-	departmentIndex, salesIndex, err := cs.findColumnIndices(header)
+	parsed.normalized = normalizeHeader(header, parsed.headerNorm)
+	mapped, err := mapColumns(parsed.normalized, opts, parsed.headerNorm)
 	if err != nil {
-		return nil, fmt.Errorf("failed to find required columns: %w", err)
+		return nil, err
+	}
+	parsed.department, parsed.sales, parsed.trace, err = cs.traceColumnMatches(parsed.normalized, mapped)
+	if err != nil {
+		matchErr := &ColumnMatchError{Err: err, Columns: make([]models.SchemaColumn, len(header))}
+		for i := range header {
+			matchErr.Columns[i] = models.SchemaColumn{Index: i, Raw: header[i], Normalized: parsed.normalized[i], Match: parsed.trace[i]}
+		}
+		return nil, fmt.Errorf("failed to find required columns: %w", matchErr)
+	}
+	return parsed, nil
+}
+
+// schema describes the header in the result's schema report
+func (h *csvHeader) schema(opts ProcessOptions) *models.SchemaReport {
+	schema := &models.SchemaReport{
+		Columns:             make([]models.SchemaColumn, len(h.raw)),
+		DepartmentColumn:    h.raw[h.department],
+		SalesColumn:         h.raw[h.sales],
+		HeaderNormalization: h.headerNorm.Steps(),
+		ValueNormalization:  h.valueNorm.Steps(),
+		Encoding:            opts.encoding,
+		Delimiter:           string(h.comma),
+	}
+	for i := range h.raw {
+		schema.Columns[i] = models.SchemaColumn{Index: i, Raw: h.raw[i], Normalized: h.normalized[i]}
+		if opts.TraceColumns {
+			schema.Columns[i].Match = h.trace[i]
+		}
+	}
+	return schema
+}
+
+// columnLayout holds the header indices of the columns used for aggregation
+type columnLayout struct {
+	department int
+	sales      int
+	distinct   []int
+	groupBy    []int
+	// aggregations holds the group-by columns of each extra aggregation
+	aggregations [][]int
+	// timestamp is the heatmap's timestamp column, or -1
+	timestamp int
+	// date is the time series' date column, or -1
+	date int
+	// currency is the currency breakdown's currency column, or -1
+	currency int
+	// pii lists the columns scanned for personal data
+	pii []int
+	// schema holds the columns of the schema profile rows are validated against, if any
+	schema *schemaLayout
+	// comma is the field delimiter
+	comma byte
+}
+
+// width returns the number of leading fields needed from each row
+func (l columnLayout) width() int {
+	last := l.department
+	if l.sales > last {
+		last = l.sales
+	}
+	if l.timestamp > last {
+		last = l.timestamp
+	}
+	if l.date > last {
+		last = l.date
+	}
+	if l.currency > last {
+		last = l.currency
+	}
+	indices := append(append(append([]int(nil), l.distinct...), l.groupBy...), l.pii...)
+	if l.schema != nil {
+		indices = append(indices, l.schema.indices...)
+	}
+	for _, columns := range l.aggregations {
+		indices = append(indices, columns...)
+	}
+	for _, index := range indices {
+		if index > last {
+			last = index
+		}
+	}
+	return last + 1
+}
+
+// rowAggregator accumulates department totals from data rows
+type rowAggregator struct {
+	layout             columnLayout
+	valueNorm          Normalization
+	opts               ProcessOptions
+	departmentSales    map[string]models.Amount
+	departmentCounts   map[string]int
+	departmentDistinct map[string][]distinctCounter
+	departmentStats    map[string]*salesStats
+	groups             *groupAggregator
+	aggregations       []*groupAggregator
+	heatmap            *heatmapAggregator
+	timeSeries         *timeSeriesAggregator
+	currencies         *currencyAggregator
+	pii                piiFindings
+	schemaViolations   []models.SchemaViolation
+	firstSeen          []string
+	footerTotal        *models.Amount
+	rows               models.RowStats
+	cleaned            *csv.Writer
+	// report receives skipped rows as they are read; without it, rows are
+	// collected in rejected when the error report was requested
+	report   *csv.Writer
+	rejected []rejectedRow
+	// blank holds the blank records read since the last data row. They are
+	// counted once another data row follows, so blank rows trailing the
+	// data are ignored.
+	blank [][]string
+	// totals holds the TOTAL rows read since the last data row. They are
+	// aggregated like any row once another data row follows, so only TOTAL
+	// rows ending the data are treated as the footer.
+	totals []heldRow
+	logger logrus.FieldLogger
+}
+
+// heldRow is a valid row whose aggregation waits on the rows after it
+type heldRow struct {
+	row    int
+	where  string
+	sales  models.Amount
+	fields []string
+}
+
+func newRowAggregator(layout columnLayout, valueNorm Normalization, opts ProcessOptions, logger logrus.FieldLogger) *rowAggregator {
+	var groups *groupAggregator
+	if len(layout.groupBy) > 0 {
+		groups = newGroupAggregator()
+	}
+	aggregations := make([]*groupAggregator, len(layout.aggregations))
+	for i := range aggregations {
+		aggregations[i] = newGroupAggregator()
+	}
+	var heatmap *heatmapAggregator
+	if layout.timestamp >= 0 {
+		heatmap = newHeatmapAggregator()
+	}
+	var timeSeries *timeSeriesAggregator
+	if layout.date >= 0 {
+		timeSeries = newTimeSeriesAggregator(opts.Bucket, opts.FiscalCalendar)
+	}
+	var currencies *currencyAggregator
+	if layout.currency >= 0 {
+		currencies = newCurrencyAggregator()
+	}
+	return &rowAggregator{
+		layout:             layout,
+		valueNorm:          valueNorm,
+		opts:               opts,
+		departmentSales:    make(map[string]models.Amount),
+		departmentCounts:   make(map[string]int),
+		departmentDistinct: make(map[string][]distinctCounter),
+		departmentStats:    make(map[string]*salesStats),
+		groups:             groups,
+		aggregations:       aggregations,
+		heatmap:            heatmap,
+		timeSeries:         timeSeries,
+		currencies:         currencies,
+		pii:                make(piiFindings),
+		rows:               models.RowStats{SkipReasons: make(map[string]int)},
+		logger:             logger,
+	}
+}
+
+// skip counts a skipped row and adds it to the error report, if requested
+func (a *rowAggregator) skip(rejected rejectedRow) error {
+	a.rows.Skipped++
+	a.rows.SkipReasons[rejected.reason]++
+	switch {
+	case a.report != nil:
+		if err := a.report.Write(rejected.record()); err != nil {
+			return fmt.Errorf("failed to write error report: %w", err)
+		}
+	case a.opts.ErrorReport != nil:
+		a.rejected = append(a.rejected, rejected)
+	}
+	return nil
+}
+
+// flushReport writes the collected skipped rows to w and flushes the report
+func (a *rowAggregator) flushReport(w io.Writer) error {
+	if a.report == nil {
+		a.report = csv.NewWriter(w)
+		if err := a.report.Write(errorReportHeader); err != nil {
+			return fmt.Errorf("failed to write error report: %w", err)
+		}
+	}
+	for _, rejected := range a.rejected {
+		if err := a.report.Write(rejected.record()); err != nil {
+			return fmt.Errorf("failed to write error report: %w", err)
+		}
 	}
+	a.rejected = nil
+	a.report.Flush()
+	if err := a.report.Error(); err != nil {
+		return fmt.Errorf("failed to write error report: %w", err)
+	}
+	return nil
+}
+
+// consume aggregates every record read from r. rowNumber is the number of the
+// row preceding r's first record; where prefixes row numbers in log messages.
+func (a *rowAggregator) consume(r io.Reader, rowNumber int, where string) error {
+	if a.opts.Parser == ParserFast {
+		return a.consumeFast(r, rowNumber, where)
+	}
+	return a.consumeCSV(r, rowNumber, where)
+}
 
-	// Process data rows using streaming
-	departmentSales := make(map[string]int)
-	rowNumber := 1 // Start from 1 since we already read the header
+// consumeCSV aggregates the records of r using encoding/csv
+func (a *rowAggregator) consumeCSV(r io.Reader, rowNumber int, where string) error {
+	// Only the fields up to the last needed column are parsed from each row
+	reader := csv.NewReader(newRowTruncator(r, a.layout.width(), a.layout.comma))
+	reader.Comma = rune(a.layout.comma)
+	reader.FieldsPerRecord = -1
+	reader.ReuseRecord = true
 
 	for {
 		record, err := reader.Read()
 		if err == io.EOF {
-			break
+			return nil
 		}
 		if err != nil {
-			cs.logger.Errorf("Failed to read CSV record at row %d: %v", rowNumber+1, err)
-			return nil, fmt.Errorf("failed to read CSV record at row %d: %w", rowNumber+1, err)
+			a.logger.Errorf("Failed to read CSV record at %srow %d: %v", where, rowNumber+1, err)
+			return fmt.Errorf("failed to read CSV record at %srow %d: %w", where, rowNumber+1, err)
 		}
 
+		if blankRecord(record) {
+			a.blank = append(a.blank, append([]string(nil), record...))
+			continue
+		}
+		if rowNumber, err = a.flushBlank(rowNumber, where); err != nil {
+			return err
+		}
 		rowNumber++
+		if err := a.record(record, rowNumber, where); err != nil {
+			return err
+		}
+	}
+}
 
-		if len(record) <= departmentIndex || len(record) <= salesIndex {
-			cs.logger.Warnf("Skipping row %d: insufficient columns", rowNumber)
-			continue
+// flushBlank counts the blank records held back since the last data row,
+// now that another data row follows them, and returns the last row number
+func (a *rowAggregator) flushBlank(rowNumber int, where string) (int, error) {
+	for _, record := range a.blank {
+		rowNumber++
+		if err := a.record(record, rowNumber, where); err != nil {
+			return rowNumber, err
 		}
+	}
+	a.blank = a.blank[:0]
+	return rowNumber, nil
+}
 
-		department := strings.TrimSpace(record[departmentIndex])
-		salesStr := strings.TrimSpace(record[salesIndex])
+// record aggregates one parsed row
+func (a *rowAggregator) record(record []string, rowNumber int, where string) error {
+	a.rows.Total++
+	a.opts.progress.row()
 
-		if department == "" {
-			cs.logger.Warnf("Skipping row %d: empty department", rowNumber)
-			continue
+	departmentIndex, salesIndex := a.layout.department, a.layout.sales
+	if a.layout.schema != nil {
+		if err := a.checkSchema(rowNumber, func(index int) string { return fieldAt(record, index) }); err != nil {
+			return err
 		}
+	}
 
-		sales, err := strconv.Atoi(salesStr)
-		if err != nil {
-			cs.logger.Warnf("Skipping row %d: invalid sales value '%s': %v", rowNumber, salesStr, err)
-			continue
+	if len(record) <= departmentIndex || len(record) <= salesIndex {
+		a.logger.Warnf("Skipping %srow %d: insufficient columns", where, rowNumber)
+		return a.skip(rejectedRow{
+			row: rowNumber, reason: SkipInsufficientColumns, detail: fmt.Sprintf("row has %d columns", len(record)),
+			department: fieldAt(record, departmentIndex), sales: fieldAt(record, salesIndex),
+		})
+	}
+
+	department := a.valueNorm.Apply(record[departmentIndex])
+	salesStr := strings.TrimSpace(record[salesIndex])
+
+	if department == "" {
+		a.logger.Warnf("Skipping %srow %d: empty department", where, rowNumber)
+		return a.skip(rejectedRow{row: rowNumber, reason: SkipEmptyDepartment, detail: "empty department", department: record[departmentIndex], sales: record[salesIndex]})
+	}
+
+	var sales models.Amount
+	if salesStr == "" {
+		if ok, err := a.missingSales(rowNumber, where, department); !ok {
+			return err
+		}
+	} else {
+		var err error
+		if sales, err = parseSales(salesStr, a.opts); err != nil {
+			a.logger.Warnf("Skipping %srow %d: invalid sales value '%s': %v", where, rowNumber, salesStr, err)
+			return a.skip(rejectedRow{row: rowNumber, reason: SkipInvalidSales, detail: err.Error(), department: department, sales: record[salesIndex]})
 		}
+	}
 
-		departmentSales[department] += sales
+	return a.accept(rowNumber, where, department, sales, func(index int) string {
+		if index < len(record) {
+			return record[index]
+		}
+		return ""
+	})
+}
+
+// missingSales applies the missing value strategy to a row whose sales value
+// is blank, reporting whether the row is counted with sales of zero
+func (a *rowAggregator) missingSales(rowNumber int, where, department string) (bool, error) {
+	switch a.opts.MissingValue {
+	case MissingValueZero:
+		return true, nil
+	case MissingValueError:
+		a.logger.Errorf("Missing sales value at %srow %d", where, rowNumber)
+		return false, fmt.Errorf("%w at %srow %d", ErrMissingValue, where, rowNumber)
 	}
+	a.logger.Warnf("Skipping %srow %d: missing sales value", where, rowNumber)
+	return false, a.skip(rejectedRow{row: rowNumber, reason: SkipInvalidSales, detail: "missing sales value", department: department})
+}
 
-	// Check if we processed any data
-	if len(departmentSales) == 0 {
-		return nil, fmt.Errorf("no valid data rows found in CSV file")
+// accept adds a valid row's sales to its department and group, or holds it
+// back when it may be the footer total. fieldValue returns the raw field at
+// a header index.
+func (a *rowAggregator) accept(rowNumber int, where, department string, sales models.Amount, fieldValue func(index int) string) error {
+	if !a.opts.KeepTotalRows && isTotalRow(department) {
+		fields := make([]string, a.layout.width())
+		for i := range fields {
+			fields[i] = fieldValue(i)
+		}
+		a.totals = append(a.totals, heldRow{row: rowNumber, where: where, sales: sales, fields: fields})
+		return nil
+	}
+	if err := a.flushTotals(); err != nil {
+		return err
 	}
+	return a.add(rowNumber, where, department, sales, fieldValue)
+}
 
-	// Convert map to slice
-	var summaries []DepartmentSummary
-	for department, totalSales := range departmentSales {
-		summaries = append(summaries, DepartmentSummary{
-			Department: department,
-			TotalSales: totalSales,
-		})
+// flushTotals aggregates the TOTAL rows held back since the last data row,
+// now that another data row follows them
+func (a *rowAggregator) flushTotals() error {
+	totals := a.totals
+	a.totals = nil
+	for _, held := range totals {
+		fields := held.fields
+		department := a.valueNorm.Apply(fields[a.layout.department])
+		a.logger.Infof("Aggregating %srow %d labelled %q: data rows follow it", held.where, held.row, department)
+		// The schema let the row through as a possible footer
+		if schema := a.layout.schema; schema != nil && schema.departments != nil && !schema.departments[strings.ToLower(department)] {
+			a.schemaViolation(held.row, "", RuleDepartment, department, fmt.Sprintf("row %d: department %q is not allowed", held.row, department))
+			continue
+		}
+		if err := a.add(held.row, held.where, department, held.sales, func(index int) string { return fields[index] }); err != nil {
+			return err
+		}
 	}
+	return nil
+}
 
-	cs.logger.Infof("Processed %d departments from CSV file", len(summaries))
-	return summaries, nil
+// closeFooter records the TOTAL rows ending the data as the footer, whose
+// last row is the footer total
+func (a *rowAggregator) closeFooter() {
+	for _, held := range a.totals {
+		a.logger.Infof("Footer total at %srow %d: %s", held.where, held.row, held.sales)
+		a.rows.FooterRows++
+		a.rows.FooterRowNumbers = append(a.rows.FooterRowNumbers, held.row)
+		sales := held.sales
+		a.footerTotal = &sales
+	}
+	a.totals = nil
 }
 
-// findColumnIndices finds the indices of department and sales columns
-func (cs *CSVService) findColumnIndices(header []string) (int, int, error) {
-	var departmentIndex, salesIndex int = -1, -1
+// add aggregates a valid row
+func (a *rowAggregator) add(rowNumber int, where, department string, sales models.Amount, fieldValue func(index int) string) error {
 
-	for i, col := range header {
-		colLower := strings.ToLower(strings.TrimSpace(col))
+	var currency string
+	if a.currencies != nil {
+		raw := fieldValue(a.layout.currency)
+		if currency = strings.ToUpper(strings.TrimSpace(raw)); !ValidCurrencyCode(currency) {
+			a.logger.Warnf("Skipping %srow %d: invalid currency '%s'", where, rowNumber, raw)
+			return a.skip(rejectedRow{row: rowNumber, reason: SkipInvalidCurrency, detail: fmt.Sprintf("invalid currency %q", raw), department: department, sales: sales.String()})
+		}
+	}
+
+	if len(a.layout.pii) > 0 {
+		if err := a.scanPII(rowNumber, where, fieldValue); err != nil {
+			return err
+		}
+	}
+
+	a.rows.Processed++
+	if _, ok := a.departmentSales[department]; !ok {
+		a.firstSeen = append(a.firstSeen, department)
+	}
+	a.departmentSales[department] = a.departmentSales[department].Add(sales)
+	a.departmentCounts[department]++
+	if a.opts.Stats {
+		stats, ok := a.departmentStats[department]
+		if !ok {
+			stats = newSalesStats()
+			a.departmentStats[department] = stats
+		}
+		stats.add(sales)
+	}
+
+	if a.cleaned != nil {
+		if err := a.cleaned.Write([]string{strconv.Itoa(rowNumber), department, sales.String()}); err != nil {
+			return fmt.Errorf("failed to write cleaned output: %w", err)
+		}
+	}
+
+	if len(a.layout.distinct) > 0 {
+		counters, ok := a.departmentDistinct[department]
+		if !ok {
+			counters = make([]distinctCounter, len(a.layout.distinct))
+			for i := range counters {
+				counters[i] = newDistinctCounter(a.opts.DistinctMode)
+			}
+			a.departmentDistinct[department] = counters
+		}
+		for i, index := range a.layout.distinct {
+			if value := a.valueNorm.Apply(fieldValue(index)); value != "" {
+				counters[i].Add(value)
+			}
+		}
+	}
 
-		// Look for department column
-		if departmentIndex == -1 && (colLower == "department" ||
-			colLower == "department name" ||
-			strings.Contains(colLower, "department") ||
-			colLower == "dept") {
-			departmentIndex = i
+	if a.groups != nil {
+		key := make([]string, len(a.layout.groupBy))
+		for i, index := range a.layout.groupBy {
+			key[i] = a.valueNorm.Apply(fieldValue(index))
+		}
+		a.groups.add(key, sales)
+	}
+	for i, groups := range a.aggregations {
+		key := make([]string, len(a.layout.aggregations[i]))
+		for j, index := range a.layout.aggregations[i] {
+			key[j] = a.valueNorm.Apply(fieldValue(index))
 		}
+		groups.add(key, sales)
+	}
+	if a.heatmap != nil {
+		a.heatmap.add(department, fieldValue(a.layout.timestamp), sales)
+	}
+	if a.timeSeries != nil {
+		a.timeSeries.add(department, fieldValue(a.layout.date), sales)
+	}
+	if a.currencies != nil {
+		a.currencies.add(department, currency, sales)
+	}
+	return nil
+}
 
-		// Look for sales column
-		if salesIndex == -1 && (colLower == "sales" ||
-			colLower == "total_sales" ||
-			colLower == "total sales" ||
-			colLower == "number of sales" ||
-			colLower == "amount" ||
-			colLower == "revenue") {
-			salesIndex = i
+// merge adds the totals of an aggregator that consumed a later part of the file
+func (a *rowAggregator) merge(other *rowAggregator) {
+	for _, department := range other.firstSeen {
+		if _, ok := a.departmentSales[department]; !ok {
+			a.firstSeen = append(a.firstSeen, department)
+		}
+		a.departmentSales[department] = a.departmentSales[department].Add(other.departmentSales[department])
+		a.departmentCounts[department] += other.departmentCounts[department]
+		if stats, ok := other.departmentStats[department]; ok {
+			if a.departmentStats[department] == nil {
+				a.departmentStats[department] = newSalesStats()
+			}
+			a.departmentStats[department].merge(stats)
 		}
 	}
+	if a.groups != nil {
+		a.groups.merge(other.groups)
+	}
+	for i, groups := range a.aggregations {
+		groups.merge(other.aggregations[i])
+	}
+	if a.heatmap != nil {
+		a.heatmap.merge(other.heatmap)
+	}
+	if a.timeSeries != nil {
+		a.timeSeries.merge(other.timeSeries)
+	}
+	if a.currencies != nil {
+		a.currencies.merge(other.currencies)
+	}
+	a.pii.merge(other.pii)
+	if other.footerTotal != nil {
+		a.footerTotal = other.footerTotal
+	}
+	a.rows.Total += other.rows.Total
+	a.rows.Processed += other.rows.Processed
+	a.rows.Skipped += other.rows.Skipped
+	a.rows.FooterRows += other.rows.FooterRows
+	a.rows.FooterRowNumbers = append(a.rows.FooterRowNumbers, other.rows.FooterRowNumbers...)
+	for reason, count := range other.rows.SkipReasons {
+		a.rows.SkipReasons[reason] += count
+	}
+	a.rejected = append(a.rejected, other.rejected...)
+}
 
-	if departmentIndex == -1 {
-		return -1, -1, fmt.Errorf("department column not found in CSV header")
+// summaries converts the totals to DepartmentSummary values in the requested order
+func (a *rowAggregator) summaries(opts ProcessOptions) []DepartmentSummary {
+	var summaries []DepartmentSummary
+	for _, department := range a.firstSeen {
+		summary := DepartmentSummary{
+			Department: department,
+			TotalSales: a.departmentSales[department],
+			SalesCount: a.departmentCounts[department],
+		}
+		if len(a.layout.distinct) > 0 {
+			counters := a.departmentDistinct[department]
+			for i, column := range opts.DistinctColumns {
+				summary.DistinctCounts = append(summary.DistinctCounts, DistinctCount{
+					Column:      column,
+					Count:       counters[i].Count(),
+					Approximate: counters[i].Approximate(),
+				})
+			}
+		}
+		if stats, ok := a.departmentStats[department]; ok {
+			summary.Stats = stats.result()
+		}
+		if a.currencies != nil {
+			a.currencies.apply(&summary, opts.BaseCurrency)
+		}
+		summaries = append(summaries, summary)
 	}
-	if salesIndex == -1 {
-		return -1, -1, fmt.Errorf("sales column not found in CSV header")
+	SortSummaries(summaries, opts.Order)
+	return summaries
+}
+
+// findColumns resolves the header indices of requested columns, normalizing
+// the requested names the same way as the header. kind names the option in errors.
+func (cs *CSVService) findColumns(normalizedHeader []string, columns []string, norm Normalization, kind string) ([]int, error) {
+	indices := make([]int, 0, len(columns))
+	for _, column := range columns {
+		index := -1
+		for i, col := range normalizedHeader {
+			if col == norm.Apply(column) {
+				index = i
+				break
+			}
+		}
+		if index == -1 {
+			return nil, fmt.Errorf("%s column %q %w", kind, column, ErrColumnNotFound)
+		}
+		indices = append(indices, index)
 	}
+	return indices, nil
+}
+
+// findColumnIndices finds the indices of department and sales columns using
+// the default header normalization
+func (cs *CSVService) findColumnIndices(header []string) (int, int, error) {
+	return cs.matchColumnIndices(normalizeHeader(header, DefaultHeaderNormalization))
+}
 
-	cs.logger.Infof("Found department column at index %d, sales column at index %d", departmentIndex, salesIndex)
-	return departmentIndex, salesIndex, nil
+// matchColumnIndices finds the department and sales columns in an already
+// normalized header. Names are compared against the lowercase synonyms as-is,
+// so disabling case folding makes matching case-sensitive.
+func (cs *CSVService) matchColumnIndices(header []string) (int, int, error) {
+	departmentIndex, salesIndex, _, err := cs.traceColumnMatches(header, nil)
+	return departmentIndex, salesIndex, err
+}
+
+// normalizeHeader applies a normalization to every header cell
+func normalizeHeader(header []string, norm Normalization) []string {
+	normalized := make([]string, len(header))
+	for i, col := range header {
+		normalized[i] = norm.Apply(col)
+	}
+	return normalized
 }
 
 // openFile opens a file for reading
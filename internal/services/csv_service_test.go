@@ -1,8 +1,12 @@
 package services
 
 import (
+	"encoding/csv"
 	"fmt"
+	"github.com/mussietl/csv-sales-api/internal/models"
 	"os"
+	"path/filepath"
+	"strings"
 	"testing"
 
 	"github.com/sirupsen/logrus"
@@ -31,9 +35,9 @@ func TestCSVServiceProcessSalesCSV(t *testing.T) {
 							Books,300
 							Clothing,200`,
 			expectedResult: []DepartmentSummary{
-				{Department: "Electronics", TotalSales: 2500},
-				{Department: "Clothing", TotalSales: 700},
-				{Department: "Books", TotalSales: 300},
+				{Department: "Electronics", TotalSales: models.WholeAmount(2500)},
+				{Department: "Clothing", TotalSales: models.WholeAmount(700)},
+				{Department: "Books", TotalSales: models.WholeAmount(300)},
 			},
 			expectError: false,
 		},
@@ -43,8 +47,8 @@ func TestCSVServiceProcessSalesCSV(t *testing.T) {
 Electronics,1000
 Clothing,500`,
 			expectedResult: []DepartmentSummary{
-				{Department: "Electronics", TotalSales: 1000},
-				{Department: "Clothing", TotalSales: 500},
+				{Department: "Electronics", TotalSales: models.WholeAmount(1000)},
+				{Department: "Clothing", TotalSales: models.WholeAmount(500)},
 			},
 			expectError: false,
 		},
@@ -55,8 +59,8 @@ Electronics,1000
 ,500
 Books,300`,
 			expectedResult: []DepartmentSummary{
-				{Department: "Electronics", TotalSales: 1000},
-				{Department: "Books", TotalSales: 300},
+				{Department: "Electronics", TotalSales: models.WholeAmount(1000)},
+				{Department: "Books", TotalSales: models.WholeAmount(300)},
 			},
 			expectError: false,
 		},
@@ -67,8 +71,8 @@ Electronics,1000
 Clothing,invalid
 Books,300`,
 			expectedResult: []DepartmentSummary{
-				{Department: "Electronics", TotalSales: 1000},
-				{Department: "Books", TotalSales: 300},
+				{Department: "Electronics", TotalSales: models.WholeAmount(1000)},
+				{Department: "Books", TotalSales: models.WholeAmount(300)},
 			},
 			expectError: false,
 		},
@@ -105,11 +109,11 @@ Jane,30`,
 				return content
 			}(),
 			expectedResult: []DepartmentSummary{
-				{Department: "DepartmentA", TotalSales: 997000},  // 200 rows: 10+60+110+160+...+9950 = 200*4985 = 997000
-				{Department: "DepartmentB", TotalSales: 999000},  // 200 rows: 20+70+120+170+...+9960 = 200*4995 = 999000
-				{Department: "DepartmentC", TotalSales: 1001000}, // 200 rows: 30+80+130+180+...+9970 = 200*5005 = 1001000
-				{Department: "DepartmentD", TotalSales: 1003000}, // 200 rows: 40+90+140+190+...+9980 = 200*5015 = 1003000
-				{Department: "DepartmentE", TotalSales: 1005000}, // 200 rows: 50+100+150+200+...+9990 = 200*5025 = 1005000
+				{Department: "DepartmentA", TotalSales: models.WholeAmount(997000)},  // 200 rows: 10+60+110+160+...+9950 = 200*4985 = 997000
+				{Department: "DepartmentB", TotalSales: models.WholeAmount(999000)},  // 200 rows: 20+70+120+170+...+9960 = 200*4995 = 999000
+				{Department: "DepartmentC", TotalSales: models.WholeAmount(1001000)}, // 200 rows: 30+80+130+180+...+9970 = 200*5005 = 1001000
+				{Department: "DepartmentD", TotalSales: models.WholeAmount(1003000)}, // 200 rows: 40+90+140+190+...+9980 = 200*5015 = 1003000
+				{Department: "DepartmentE", TotalSales: models.WholeAmount(1005000)}, // 200 rows: 50+100+150+200+...+9990 = 200*5025 = 1005000
 			},
 			expectError: false,
 		},
@@ -137,12 +141,12 @@ Jane,30`,
 				assert.Equal(t, len(tt.expectedResult), len(result))
 
 				// Convert results to map for easier comparison
-				resultMap := make(map[string]int)
+				resultMap := make(map[string]models.Amount)
 				for _, r := range result {
 					resultMap[r.Department] = r.TotalSales
 				}
 
-				expectedMap := make(map[string]int)
+				expectedMap := make(map[string]models.Amount)
 				for _, r := range tt.expectedResult {
 					expectedMap[r.Department] = r.TotalSales
 				}
@@ -219,3 +223,163 @@ func TestCSVServiceFindColumnIndices(t *testing.T) {
 		})
 	}
 }
+
+func TestCSVServiceProcessRowStats(t *testing.T) {
+	logger := logrus.New()
+	logger.SetLevel(logrus.ErrorLevel)
+	csvService := NewCSVService(logger)
+
+	tempFile, err := os.CreateTemp("", "test_*.csv")
+	require.NoError(t, err)
+	defer os.Remove(tempFile.Name())
+
+	_, err = tempFile.WriteString("id,department,sales\n1,Electronics,1000\n2,,500\n3,Books,abc\n4\n5,Books,300\n")
+	require.NoError(t, err)
+	tempFile.Close()
+
+	result, err := csvService.Process(tempFile.Name(), ProcessOptions{})
+	require.NoError(t, err)
+	assert.Equal(t, 5, result.Rows.Total)
+	assert.Equal(t, 2, result.Rows.Processed)
+	assert.Equal(t, 3, result.Rows.Skipped)
+	assert.Equal(t, map[string]int{
+		SkipEmptyDepartment:     1,
+		SkipInvalidSales:        1,
+		SkipInsufficientColumns: 1,
+	}, result.Rows.SkipReasons)
+}
+
+func TestCSVServiceProcessStream(t *testing.T) {
+	logger := logrus.New()
+	logger.SetLevel(logrus.ErrorLevel)
+	csvService := NewCSVService(logger)
+
+	data := "id,department,sales\n1,Electronics,1000\n2,Books,abc\n3,Books,300\n4,Electronics,50\n"
+	result, err := csvService.ProcessStream(strings.NewReader(data), ProcessOptions{})
+	require.NoError(t, err)
+	assert.Equal(t, StrategyStreaming, result.Strategy)
+	assert.ElementsMatch(t, []DepartmentSummary{
+		{Department: "Books", TotalSales: models.WholeAmount(300), SalesCount: 1},
+		{Department: "Electronics", TotalSales: models.WholeAmount(1050), SalesCount: 2},
+	}, result.Summaries)
+	assert.Equal(t, 4, result.Rows.Total)
+	assert.Equal(t, 1, result.Rows.Skipped)
+
+	_, err = csvService.ProcessStream(strings.NewReader("id,name\n1,x\n"), ProcessOptions{})
+	assert.Error(t, err)
+}
+
+func TestCSVServiceProcessOrderAndCleanedOutput(t *testing.T) {
+	logger := logrus.New()
+	logger.SetLevel(logrus.ErrorLevel)
+	csvService := NewCSVService(logger)
+
+	tempFile, err := os.CreateTemp("", "test_*.csv")
+	require.NoError(t, err)
+	defer os.Remove(tempFile.Name())
+
+	_, err = tempFile.WriteString("department,sales\nToys,5\nBooks,x\nArt,2\nToys,1\nZoo,9\nArt,3\n")
+	require.NoError(t, err)
+	tempFile.Close()
+
+	var cleaned strings.Builder
+	result, err := csvService.Process(tempFile.Name(), ProcessOptions{Order: OrderFirstSeen, CleanedOutput: &cleaned})
+	require.NoError(t, err)
+
+	var order []string
+	for _, s := range result.Summaries {
+		order = append(order, s.Department)
+	}
+	assert.Equal(t, []string{"Toys", "Art", "Zoo"}, order)
+	assert.Equal(t, "Row Number,Department Name,Number of Sales\n2,Toys,5\n4,Art,2\n5,Toys,1\n6,Zoo,9\n7,Art,3\n", cleaned.String())
+}
+
+func TestCSVServiceDeterministicOutput(t *testing.T) {
+	logger := logrus.New()
+	logger.SetLevel(logrus.ErrorLevel)
+	csvService := NewCSVService(logger)
+	dir := t.TempDir()
+	fileService := NewFileService(dir, logger)
+
+	input := filepath.Join(dir, "sales.csv")
+	f, err := os.Create(input)
+	require.NoError(t, err)
+	require.NoError(t, GenerateSampleCSV(f, SampleOptions{Rows: 5000, Departments: 200, Seed: 7}))
+	require.NoError(t, f.Close())
+
+	// Every run and strategy writes the same bytes
+	var first []byte
+	for run := 0; run < 5; run++ {
+		for _, strategy := range []string{StrategyInMemory, StrategyStreaming, StrategyParallel} {
+			result, err := csvService.Process(input, ProcessOptions{Strategy: strategy})
+			require.NoError(t, err)
+			path, err := fileService.SaveResultFile(result.Summaries)
+			require.NoError(t, err)
+			output, err := os.ReadFile(path)
+			require.NoError(t, err)
+			if first == nil {
+				first = output
+				continue
+			}
+			require.Equal(t, string(first), string(output), "run %d with strategy %s", run, strategy)
+		}
+	}
+
+	summaries := []DepartmentSummary{{Department: "b", TotalSales: models.WholeAmount(5)}, {Department: "a", TotalSales: models.WholeAmount(5)}, {Department: "C", TotalSales: models.WholeAmount(9)}}
+	SortSummaries(summaries, OrderTotalDesc)
+	assert.Equal(t, []string{"C", "a", "b"}, []string{summaries[0].Department, summaries[1].Department, summaries[2].Department})
+	SortSummaries(summaries, OrderUnspecified)
+	assert.Equal(t, []string{"C", "a", "b"}, []string{summaries[0].Department, summaries[1].Department, summaries[2].Department}, "names sort byte-wise")
+	SortSummaries(summaries, OrderFirstSeen)
+	assert.Equal(t, "C", summaries[0].Department)
+	assert.Error(t, ValidateOrder("random"))
+}
+
+func TestErrorReport(t *testing.T) {
+	logger := logrus.New()
+	logger.SetLevel(logrus.PanicLevel)
+	cs := NewCSVService(logger)
+
+	var buf strings.Builder
+	buf.WriteString("Department Name,Notes,Number of Sales\n")
+	for i := 0; i < 3000; i++ {
+		switch i % 500 {
+		case 7:
+			fmt.Fprintf(&buf, "Dept %d,\"multi\nline\",lots\n", i%5)
+		case 99:
+			buf.WriteString(",plain,3\n")
+		case 300:
+			buf.WriteString("Dept 1\n")
+		default:
+			fmt.Fprintf(&buf, "Dept %d,plain,%d\n", i%5, i)
+		}
+	}
+	path := filepath.Join(t.TempDir(), "sales.csv")
+	require.NoError(t, os.WriteFile(path, []byte(buf.String()), 0644))
+
+	var streaming strings.Builder
+	result, err := cs.Process(path, ProcessOptions{Strategy: StrategyStreaming, ErrorReport: &streaming})
+	require.NoError(t, err)
+	assert.Equal(t, 18, result.Rows.Skipped)
+
+	records, err := csv.NewReader(strings.NewReader(streaming.String())).ReadAll()
+	require.NoError(t, err)
+	require.Len(t, records, 19)
+	assert.Equal(t, []string{"Row Number", "Reason", "Error", "Department", "Sales"}, records[0])
+	assert.Equal(t, []string{"9", SkipInvalidSales, `invalid number "lots"`, "Dept 2", "lots"}, records[1])
+	assert.Equal(t, []string{"101", SkipEmptyDepartment, "empty department", "", "3"}, records[2])
+	assert.Equal(t, []string{"302", SkipInsufficientColumns, "row has 1 columns", "Dept 1", ""}, records[3])
+
+	cs.SetStrategyThresholds(StrategyThresholds{Workers: 4})
+	for _, opts := range []ProcessOptions{
+		{Strategy: StrategyParallel},
+		{Strategy: StrategyParallel, Parser: ParserFast},
+		{Strategy: StrategyInMemory, Parser: ParserFast},
+	} {
+		var report strings.Builder
+		opts.ErrorReport = &report
+		_, err := cs.Process(path, opts)
+		require.NoError(t, err)
+		assert.Equal(t, streaming.String(), report.String(), "%s %s", opts.Strategy, opts.Parser)
+	}
+}
@@ -0,0 +1,206 @@
+package services
+
+import (
+	"fmt"
+	"math/big"
+	"sort"
+	"strings"
+
+	"github.com/mussietl/csv-sales-api/internal/models"
+)
+
+// SkipInvalidCurrency is the skip reason of rows without a valid currency
+// code when sales are broken down by currency
+const SkipInvalidCurrency = "invalid_currency"
+
+// currencyColumnNames are the normalized header names tried, in order,
+// when a currency breakdown is requested without naming the column
+var currencyColumnNames = []string{"currency", "currency code", "currency_code", "ccy"}
+
+// CurrencyTotal is a department's sales in one currency
+type CurrencyTotal struct {
+	Currency   string        `json:"currency"`
+	TotalSales models.Amount `json:"total_sales"`
+	SalesCount int           `json:"sales_count"`
+}
+
+// ValidCurrencyCode reports whether code is three letters, the form of an
+// ISO 4217 code
+func ValidCurrencyCode(code string) bool {
+	if len(code) != 3 {
+		return false
+	}
+	for i := 0; i < len(code); i++ {
+		if (code[i] < 'A' || code[i] > 'Z') && (code[i] < 'a' || code[i] > 'z') {
+			return false
+		}
+	}
+	return true
+}
+
+// findCurrencyColumn returns the index of the column named column or, when
+// column is empty, of the first header cell with a currency-like name
+func (cs *CSVService) findCurrencyColumn(normalizedHeader []string, column string, norm Normalization) (int, error) {
+	if column != "" {
+		indices, err := cs.findColumns(normalizedHeader, []string{column}, norm, "currency")
+		if err != nil {
+			return -1, err
+		}
+		return indices[0], nil
+	}
+	for _, name := range currencyColumnNames {
+		for i, col := range normalizedHeader {
+			if strings.EqualFold(col, name) {
+				return i, nil
+			}
+		}
+	}
+	return -1, fmt.Errorf("currency column %w: none of %s; name it with currency_column", ErrColumnNotFound, strings.Join(currencyColumnNames, ", "))
+}
+
+// currencyAggregator accumulates department sales per currency
+type currencyAggregator struct {
+	departments map[string]map[string]*CurrencyTotal
+	// rates and converted hold the rate of each currency and each
+	// department's converted total, once a base currency was applied
+	rates     map[string]*big.Rat
+	converted map[string]models.Amount
+}
+
+func newCurrencyAggregator() *currencyAggregator {
+	return &currencyAggregator{departments: make(map[string]map[string]*CurrencyTotal)}
+}
+
+// total returns a department's total in a currency, creating it
+func (c *currencyAggregator) total(department, currency string) *CurrencyTotal {
+	totals, ok := c.departments[department]
+	if !ok {
+		totals = make(map[string]*CurrencyTotal)
+		c.departments[department] = totals
+	}
+	total, ok := totals[currency]
+	if !ok {
+		total = &CurrencyTotal{Currency: currency}
+		totals[currency] = total
+	}
+	return total
+}
+
+// add counts a row's sales in its currency
+func (c *currencyAggregator) add(department, currency string, sales models.Amount) {
+	total := c.total(department, currency)
+	total.TotalSales = total.TotalSales.Add(sales)
+	total.SalesCount++
+}
+
+// merge adds the totals of an aggregator that consumed another part of the file
+func (c *currencyAggregator) merge(other *currencyAggregator) {
+	for department, totals := range other.departments {
+		for currency, part := range totals {
+			total := c.total(department, currency)
+			total.TotalSales = total.TotalSales.Add(part.TotalSales)
+			total.SalesCount += part.SalesCount
+		}
+	}
+}
+
+// currencies returns every currency seen, in order
+func (c *currencyAggregator) currencies() []string {
+	seen := make(map[string]bool)
+	var currencies []string
+	for _, totals := range c.departments {
+		for currency := range totals {
+			if !seen[currency] {
+				seen[currency] = true
+				currencies = append(currencies, currency)
+			}
+		}
+	}
+	sort.Strings(currencies)
+	return currencies
+}
+
+// convert converts every department's total to base with rates
+func (c *currencyAggregator) convert(base string, rates ExchangeRates) error {
+	c.rates = make(map[string]*big.Rat)
+	for _, currency := range c.currencies() {
+		rate, err := rates.Rate(currency, base)
+		if err != nil {
+			return err
+		}
+		c.rates[currency] = rate
+	}
+	c.converted = make(map[string]models.Amount, len(c.departments))
+	for department, totals := range c.departments {
+		var converted models.Amount
+		for currency, total := range totals {
+			amount, err := convertAmount(total.TotalSales, c.rates[currency])
+			if err != nil {
+				return fmt.Errorf("failed to convert %s sales of %s to %s: %w", currency, department, base, err)
+			}
+			converted = converted.Add(amount)
+		}
+		c.converted[department] = converted
+	}
+	return nil
+}
+
+// apply sets a department's per-currency totals on its summary and, after
+// convert, replaces its total with the converted one
+func (c *currencyAggregator) apply(summary *DepartmentSummary, base string) {
+	totals := c.departments[summary.Department]
+	summary.Currencies = make([]CurrencyTotal, 0, len(totals))
+	for _, total := range totals {
+		summary.Currencies = append(summary.Currencies, *total)
+	}
+	sort.Slice(summary.Currencies, func(i, j int) bool { return summary.Currencies[i].Currency < summary.Currencies[j].Currency })
+	if c.converted != nil {
+		summary.TotalSales = c.converted[summary.Department]
+		summary.Currency = base
+	}
+}
+
+// result converts the totals to a CurrencyBreakdown with departments in the
+// order of summaries
+func (c *currencyAggregator) result(column, base string, summaries []DepartmentSummary) *models.CurrencyBreakdown {
+	breakdown := &models.CurrencyBreakdown{
+		CurrencyColumn: column,
+		Currencies:     c.currencies(),
+		Departments:    make([]models.DepartmentCurrencies, len(summaries)),
+	}
+	if c.rates != nil {
+		breakdown.BaseCurrency = base
+		breakdown.Rates = make(map[string]string, len(c.rates))
+		for currency, rate := range c.rates {
+			breakdown.Rates[currency] = formatRate(rate)
+		}
+	}
+	for i, summary := range summaries {
+		sales := make(map[string]models.Amount, len(summary.Currencies))
+		for _, total := range summary.Currencies {
+			sales[total.Currency] = total.TotalSales
+		}
+		breakdown.Departments[i] = models.DepartmentCurrencies{Department: summary.Department, Sales: sales}
+		if c.rates != nil {
+			total := summary.TotalSales
+			breakdown.Departments[i].Converted = &total
+		}
+	}
+	return breakdown
+}
+
+// convertAmount multiplies an amount by an exchange rate, rounding half
+// away from zero to the amount's precision
+func convertAmount(amount models.Amount, rate *big.Rat) (models.Amount, error) {
+	value, _ := new(big.Rat).SetString(amount.String())
+	return models.ParseAmount(value.Mul(value, rate).FloatString(models.AmountDecimals))
+}
+
+// formatRate writes an exchange rate as a decimal with up to 10 places
+func formatRate(rate *big.Rat) string {
+	s := rate.FloatString(10)
+	if strings.Contains(s, ".") {
+		s = strings.TrimRight(strings.TrimRight(s, "0"), ".")
+	}
+	return s
+}
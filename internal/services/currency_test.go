@@ -0,0 +1,198 @@
+package services
+
+import (
+	"fmt"
+	"math/big"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/mussietl/csv-sales-api/internal/models"
+	"github.com/sirupsen/logrus"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+const currencyCSV = "Department,Sales,Currency\n" +
+	"Books,10,USD\n" +
+	"Books,20,eur\n" +
+	"Toys,5.5,EUR\n" +
+	"Toys,3,\n" +
+	"Garden,100,GBP\n" +
+	"Books,1.25,usd\n"
+
+func TestProcessCurrency(t *testing.T) {
+	logger := logrus.New()
+	logger.SetLevel(logrus.ErrorLevel)
+	cs := NewCSVService(logger)
+
+	result, err := cs.ProcessStream(strings.NewReader(currencyCSV), ProcessOptions{Currency: true})
+	require.NoError(t, err)
+	assert.Equal(t, 1, result.Rows.SkipReasons[SkipInvalidCurrency])
+
+	books := result.Summaries[0]
+	assert.Equal(t, "Books", books.Department)
+	assert.Equal(t, []CurrencyTotal{
+		{Currency: "EUR", TotalSales: models.WholeAmount(20), SalesCount: 1},
+		{Currency: "USD", TotalSales: amount(t, "11.25"), SalesCount: 2},
+	}, books.Currencies)
+	// Without a base currency, totals add up the amounts as they are
+	assert.Equal(t, amount(t, "31.25"), books.TotalSales)
+	assert.Empty(t, books.Currency)
+
+	breakdown := result.Currency
+	require.NotNil(t, breakdown)
+	assert.Equal(t, "Currency", breakdown.CurrencyColumn)
+	assert.Equal(t, []string{"EUR", "GBP", "USD"}, breakdown.Currencies)
+	assert.Empty(t, breakdown.BaseCurrency)
+	assert.Equal(t, map[string]models.Amount{"GBP": models.WholeAmount(100)}, breakdown.Departments[1].Sales)
+	assert.Nil(t, breakdown.Departments[1].Converted)
+
+	_, err = cs.ProcessStream(strings.NewReader("Department,Sales\nBooks,1\n"), ProcessOptions{Currency: true})
+	assert.ErrorIs(t, err, ErrColumnNotFound)
+}
+
+func TestProcessCurrencyConversion(t *testing.T) {
+	logger := logrus.New()
+	logger.SetLevel(logrus.ErrorLevel)
+	cs := NewCSVService(logger)
+
+	opts := ProcessOptions{Currency: true, BaseCurrency: "USD", Order: OrderTotalDesc}
+	_, err := cs.ProcessStream(strings.NewReader(currencyCSV), opts)
+	assert.ErrorIs(t, err, ErrExchangeRate, "no rates are configured")
+
+	rates, err := ParseExchangeRates("USD=1, EUR=1.1, gbp=1.25")
+	require.NoError(t, err)
+	cs.SetExchangeRates(rates)
+	result, err := cs.ProcessStream(strings.NewReader(currencyCSV), opts)
+	require.NoError(t, err)
+
+	// Converted totals decide the order
+	require.Len(t, result.Summaries, 3)
+	assert.Equal(t, "Garden", result.Summaries[0].Department)
+	assert.Equal(t, amount(t, "125"), result.Summaries[0].TotalSales)
+	assert.Equal(t, "USD", result.Summaries[0].Currency)
+	assert.Equal(t, "Books", result.Summaries[1].Department)
+	assert.Equal(t, amount(t, "33.25"), result.Summaries[1].TotalSales)
+	assert.Equal(t, amount(t, "6.05"), result.Summaries[2].TotalSales)
+
+	breakdown := result.Currency
+	assert.Equal(t, "USD", breakdown.BaseCurrency)
+	assert.Equal(t, map[string]string{"EUR": "1.1", "GBP": "1.25", "USD": "1"}, breakdown.Rates)
+	assert.Equal(t, amount(t, "33.25"), *breakdown.Departments[1].Converted)
+
+	_, err = cs.ProcessStream(strings.NewReader("Department,Sales,Currency\nBooks,1,CHF\n"), opts)
+	assert.ErrorIs(t, err, ErrExchangeRate)
+}
+
+func TestCurrencyParallel(t *testing.T) {
+	logger := logrus.New()
+	logger.SetLevel(logrus.ErrorLevel)
+	cs := NewCSVService(logger)
+
+	var buf strings.Builder
+	buf.WriteString("Department,Currency,Sales\n")
+	currencies := []string{"USD", "EUR", "GBP", "??"}
+	for i := 0; i < 3000; i++ {
+		fmt.Fprintf(&buf, "Dept %d,%s,%d\n", i%5, currencies[i%4], i)
+	}
+	path := filepath.Join(t.TempDir(), "sales.csv")
+	require.NoError(t, os.WriteFile(path, []byte(buf.String()), 0644))
+
+	opts := ProcessOptions{Currency: true, Strategy: StrategyStreaming}
+	streaming, err := cs.Process(path, opts)
+	require.NoError(t, err)
+	assert.Equal(t, 750, streaming.Rows.SkipReasons[SkipInvalidCurrency])
+
+	cs.SetStrategyThresholds(StrategyThresholds{Workers: 4})
+	opts.Strategy = StrategyParallel
+	for _, parser := range []string{ParserStandard, ParserFast} {
+		opts.Parser = parser
+		parallel, err := cs.Process(path, opts)
+		require.NoError(t, err)
+		assert.Equal(t, streaming.Currency, parallel.Currency, parser)
+		assert.Equal(t, streaming.Summaries, parallel.Summaries, parser)
+	}
+}
+
+func TestFileServiceSaveCurrencyColumns(t *testing.T) {
+	logger := logrus.New()
+	logger.SetLevel(logrus.ErrorLevel)
+	fs := NewFileService(t.TempDir(), logger)
+	cs := NewCSVService(logger)
+	rates, err := ParseExchangeRates("USD=1,EUR=1.1,GBP=1.25")
+	require.NoError(t, err)
+	cs.SetExchangeRates(rates)
+
+	result, err := cs.ProcessStream(strings.NewReader(currencyCSV), ProcessOptions{Currency: true, BaseCurrency: "EUR"})
+	require.NoError(t, err)
+	path, err := fs.SaveResultFile(result.Summaries)
+	require.NoError(t, err)
+
+	data, err := os.ReadFile(path)
+	require.NoError(t, err)
+	assert.Equal(t, "Department Name,Total Number of Sales,Sales EUR,Sales GBP,Sales USD,Currency\n"+
+		"Books,30.2273,20,0,11.25,EUR\n"+
+		"Garden,113.6364,0,100,0,EUR\n"+
+		"Toys,5.5,5.5,0,0,EUR\n", string(data))
+}
+
+func TestStaticExchangeRates(t *testing.T) {
+	for _, spec := range []string{"", "EUR", "EURO=1", "EUR=0", "EUR=-1", "EUR=abc"} {
+		_, err := ParseExchangeRates(spec)
+		assert.Error(t, err, spec)
+	}
+
+	rates, err := ParseExchangeRates("USD=1,JPY=0.0067")
+	require.NoError(t, err)
+	rate, err := rates.Rate("USD", "JPY")
+	require.NoError(t, err)
+	assert.Equal(t, "10000/67", rate.String())
+	rate, err = rates.Rate("CHF", "CHF")
+	require.NoError(t, err)
+	assert.Equal(t, big.NewRat(1, 1), rate)
+	_, err = rates.Rate("CHF", "USD")
+	assert.ErrorIs(t, err, ErrExchangeRate)
+}
+
+func TestRemoteExchangeRates(t *testing.T) {
+	logger := logrus.New()
+	logger.SetLevel(logrus.PanicLevel)
+	fetches := 0
+	failing := false
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		fetches++
+		if failing {
+			w.WriteHeader(http.StatusBadGateway)
+			return
+		}
+		fmt.Fprint(w, `{"base": "USD", "rates": {"EUR": 0.8, "GBP": 0.5}}`)
+	}))
+	defer server.Close()
+
+	now := time.Date(2024, 5, 1, 12, 0, 0, 0, time.UTC)
+	rates := NewRemoteExchangeRates(server.URL, time.Hour, logger)
+	rates.now = func() time.Time { return now }
+
+	rate, err := rates.Rate("EUR", "GBP")
+	require.NoError(t, err)
+	assert.Equal(t, "5/8", rate.String())
+	rate, err = rates.Rate("GBP", "USD")
+	require.NoError(t, err)
+	assert.Equal(t, "2", rate.RatString())
+	assert.Equal(t, 1, fetches, "the table is cached")
+
+	// A failed refresh keeps the last table
+	failing = true
+	now = now.Add(2 * time.Hour)
+	_, err = rates.Rate("EUR", "USD")
+	require.NoError(t, err)
+	assert.Equal(t, 2, fetches)
+
+	_, err = NewRemoteExchangeRates(server.URL, time.Hour, logger).Rate("EUR", "USD")
+	assert.ErrorIs(t, err, ErrExchangeRate)
+}
@@ -0,0 +1,67 @@
+package services
+
+import "fmt"
+
+// DelimiterAuto detects the delimiter from the header line
+const DelimiterAuto = ""
+
+// delimiterCandidates are the delimiters recognised when sniffing a header
+var delimiterCandidates = []byte{',', ';', '\t', '|'}
+
+// delimiterNames maps the names a request may use to their delimiters
+var delimiterNames = map[string]string{
+	"comma":     ",",
+	"semicolon": ";",
+	"tab":       "\t",
+	"pipe":      "|",
+}
+
+// ParseDelimiter converts a delimiter name (comma, semicolon, tab or pipe)
+// or the character itself to a delimiter; "auto" and "" select detection
+func ParseDelimiter(value string) (string, error) {
+	if value == "" || value == "auto" {
+		return DelimiterAuto, nil
+	}
+	if delimiter, ok := delimiterNames[value]; ok {
+		return delimiter, nil
+	}
+	for _, candidate := range delimiterCandidates {
+		if value == string(candidate) {
+			return value, nil
+		}
+	}
+	return "", fmt.Errorf("invalid delimiter %q: expected auto, comma, semicolon, tab or pipe", value)
+}
+
+// resolveDelimiter returns the requested delimiter, or the one detected in
+// headerLine when none was requested
+func resolveDelimiter(requested string, headerLine []byte) byte {
+	if requested != DelimiterAuto {
+		return requested[0]
+	}
+	return detectDelimiter(headerLine)
+}
+
+// detectDelimiter returns the candidate delimiter occurring most often
+// outside quotes in a header line, defaulting to a comma
+func detectDelimiter(line []byte) byte {
+	counts := make(map[byte]int)
+	inQuote := false
+	for _, b := range line {
+		if b == '"' {
+			inQuote = !inQuote
+			continue
+		}
+		if !inQuote {
+			counts[b]++
+		}
+	}
+
+	best := byte(',')
+	for _, candidate := range delimiterCandidates {
+		if counts[candidate] > counts[best] {
+			best = candidate
+		}
+	}
+	return best
+}
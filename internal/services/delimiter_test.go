@@ -0,0 +1,76 @@
+package services
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/sirupsen/logrus"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestParseDelimiter(t *testing.T) {
+	for value, expected := range map[string]string{"": DelimiterAuto, "auto": DelimiterAuto, "semicolon": ";", "tab": "\t", "|": "|", ",": ","} {
+		delimiter, err := ParseDelimiter(value)
+		require.NoError(t, err, value)
+		assert.Equal(t, expected, delimiter, value)
+	}
+	_, err := ParseDelimiter("colon")
+	assert.Error(t, err)
+
+	assert.Equal(t, byte(';'), detectDelimiter([]byte("Abteilung;department;sales\n")))
+	assert.Equal(t, byte(','), detectDelimiter([]byte(`"a;b;c",sales`+"\n")), "quoted delimiters don't count")
+	assert.Equal(t, byte(','), detectDelimiter([]byte("department\n")))
+}
+
+func TestProcessDelimiters(t *testing.T) {
+	logger := logrus.New()
+	logger.SetLevel(logrus.ErrorLevel)
+	cs := NewCSVService(logger)
+	cs.SetStrategyThresholds(StrategyThresholds{Workers: 4})
+
+	for _, delimiter := range []string{";", "\t", "|"} {
+		var buf strings.Builder
+		buf.WriteString(strings.Join([]string{"Store", "Department Name", "Number of Sales", "Note"}, delimiter) + "\n")
+		for i := 0; i < 500; i++ {
+			note := "plain"
+			if i%50 == 0 {
+				note = `"has` + delimiter + ` delimiter"`
+			}
+			fmt.Fprintf(&buf, "%d%s%s%s%d%s%s\n", i, delimiter, []string{"Books", "Toys"}[i%2], delimiter, i, delimiter, note)
+		}
+		path := filepath.Join(t.TempDir(), "sales.csv")
+		require.NoError(t, os.WriteFile(path, []byte(buf.String()), 0644))
+
+		for _, strategy := range []string{StrategyInMemory, StrategyStreaming, StrategyParallel} {
+			for _, parser := range []string{ParserStandard, ParserFast} {
+				result, err := cs.Process(path, ProcessOptions{Strategy: strategy, Parser: parser, DistinctColumns: []string{"Note"}})
+				require.NoError(t, err, "%q %s %s", delimiter, strategy, parser)
+				assert.Equal(t, delimiter, result.Schema.Delimiter)
+				assert.Equal(t, 500, result.Rows.Processed)
+				require.Len(t, result.Summaries, 2)
+				assert.Equal(t, "62250", result.Summaries[0].TotalSales.String(), "%q %s %s", delimiter, strategy, parser)
+				assert.Equal(t, int64(2), result.Summaries[0].DistinctCounts[0].Count)
+			}
+		}
+	}
+
+	// An explicit delimiter overrides detection
+	data := "Department Name;Notes;Region,Number of Sales\nBooks;a;N,1\n"
+	result, err := cs.ProcessStream(strings.NewReader(data), ProcessOptions{Delimiter: ","})
+	require.NoError(t, err)
+	assert.Equal(t, "Department Name;Notes;Region", result.Schema.DepartmentColumn)
+	_, err = cs.ProcessStream(strings.NewReader(data), ProcessOptions{})
+	assert.Error(t, err)
+
+	// ZIP entries may use different delimiters
+	result, err = cs.Process(writeZip(t,
+		[2]string{"a.csv", "Department Name;Number of Sales\nBooks;1\n"},
+		[2]string{"b.csv", "Department Name\tNumber of Sales\nBooks\t2\n"},
+	), ProcessOptions{})
+	require.NoError(t, err)
+	assert.Equal(t, "3", result.Summaries[0].TotalSales.String())
+}
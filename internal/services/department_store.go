@@ -0,0 +1,341 @@
+package services
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/sirupsen/logrus"
+)
+
+// ErrDepartmentNotFound is returned when a tenant has no department by that name or alias
+var ErrDepartmentNotFound = errors.New("department not found")
+
+// ErrDepartmentConflict is returned when an alias is already another department's name or alias
+var ErrDepartmentConflict = errors.New("department alias conflict")
+
+// Department is a row of a tenant's department dimension table
+type Department struct {
+	Name       string            `json:"name"`
+	Region     string            `json:"region,omitempty"`
+	Aliases    []string          `json:"aliases"`
+	Attributes map[string]string `json:"attributes"`
+	FirstSeen  *time.Time        `json:"first_seen,omitempty"`
+	LastSeen   *time.Time        `json:"last_seen,omitempty"`
+	// Uploads is the number of processed uploads the department appeared in
+	Uploads int `json:"uploads"`
+	// History records every change to Region and Attributes, oldest first
+	History []DepartmentChange `json:"history"`
+}
+
+// DepartmentChange records one attribute change of a department
+type DepartmentChange struct {
+	Attribute string    `json:"attribute"`
+	From      string    `json:"from"`
+	To        string    `json:"to"`
+	ChangedAt time.Time `json:"changed_at"`
+}
+
+// DepartmentUpdate changes a department's attributes; nil fields are left
+// unchanged and an empty attribute value removes the attribute
+type DepartmentUpdate struct {
+	Region     *string           `json:"region"`
+	Aliases    *[]string         `json:"aliases"`
+	Attributes map[string]string `json:"attributes"`
+}
+
+// DimensionValue is a department attribute joined onto a result row
+type DimensionValue struct {
+	Column string `json:"column"`
+	Value  string `json:"value"`
+}
+
+// DepartmentStore holds per-tenant department dimension tables persisted to a JSON file
+type DepartmentStore struct {
+	path        string
+	mu          sync.Mutex
+	departments map[string]map[string]*Department
+	logger      *logrus.Logger
+}
+
+// NewDepartmentStore creates a DepartmentStore, loading departments from path
+func NewDepartmentStore(path string, logger *logrus.Logger) (*DepartmentStore, error) {
+	ds := &DepartmentStore{
+		path:        path,
+		departments: make(map[string]map[string]*Department),
+		logger:      logger,
+	}
+
+	data, err := os.ReadFile(path)
+	if err != nil && !errors.Is(err, os.ErrNotExist) {
+		return nil, fmt.Errorf("failed to read departments: %w", err)
+	}
+	if err == nil {
+		if err := json.Unmarshal(data, &ds.departments); err != nil {
+			return nil, fmt.Errorf("failed to decode departments: %w", err)
+		}
+	}
+	return ds, nil
+}
+
+// departmentKey is the lookup key of a department name or alias
+func departmentKey(name string) string {
+	return strings.ToLower(strings.TrimSpace(name))
+}
+
+// Observe records that the named departments appeared in an upload processed
+// at, adding departments seen for the first time
+func (ds *DepartmentStore) Observe(tenant string, names []string, at time.Time) error {
+	at = at.UTC()
+
+	ds.mu.Lock()
+	defer ds.mu.Unlock()
+	snapshot := ds.snapshot(tenant)
+	table := ds.table(tenant)
+
+	seen := make(map[*Department]bool)
+	for _, name := range names {
+		if strings.TrimSpace(name) == "" {
+			continue
+		}
+		dept := ds.resolve(tenant, name)
+		if dept == nil {
+			dept = &Department{Name: strings.TrimSpace(name), Aliases: []string{}, Attributes: map[string]string{}, History: []DepartmentChange{}}
+			table[departmentKey(name)] = dept
+		}
+		if seen[dept] {
+			continue
+		}
+		seen[dept] = true
+		if dept.FirstSeen == nil {
+			dept.FirstSeen = &at
+		}
+		dept.LastSeen = &at
+		dept.Uploads++
+	}
+
+	if err := ds.save(); err != nil {
+		ds.restore(tenant, snapshot)
+		return err
+	}
+	return nil
+}
+
+// List returns a tenant's departments ordered by name
+func (ds *DepartmentStore) List(tenant string) []Department {
+	ds.mu.Lock()
+	defer ds.mu.Unlock()
+	list := make([]Department, 0, len(ds.departments[tenant]))
+	for _, dept := range ds.departments[tenant] {
+		list = append(list, dept.clone())
+	}
+	sort.Slice(list, func(i, j int) bool { return list[i].Name < list[j].Name })
+	return list
+}
+
+// Get returns the department with the given name or alias
+func (ds *DepartmentStore) Get(tenant, name string) (Department, error) {
+	ds.mu.Lock()
+	defer ds.mu.Unlock()
+	dept := ds.resolve(tenant, name)
+	if dept == nil {
+		return Department{}, ErrDepartmentNotFound
+	}
+	return dept.clone(), nil
+}
+
+// Update applies an attribute update to the named department, creating it if
+// it has not been seen yet, and records changed attributes in its history
+func (ds *DepartmentStore) Update(tenant, name string, update DepartmentUpdate) (Department, error) {
+	if strings.TrimSpace(name) == "" {
+		return Department{}, fmt.Errorf("department name is required")
+	}
+	for attribute := range update.Attributes {
+		if strings.TrimSpace(attribute) == "" || departmentKey(attribute) == "region" {
+			return Department{}, fmt.Errorf("invalid attribute name %q", attribute)
+		}
+	}
+	now := time.Now().UTC()
+
+	ds.mu.Lock()
+	defer ds.mu.Unlock()
+	snapshot := ds.snapshot(tenant)
+	table := ds.table(tenant)
+
+	dept := ds.resolve(tenant, name)
+	if dept == nil {
+		dept = &Department{Name: strings.TrimSpace(name), Aliases: []string{}, Attributes: map[string]string{}, History: []DepartmentChange{}}
+		table[departmentKey(name)] = dept
+	}
+
+	if update.Aliases != nil {
+		aliases := []string{}
+		for _, alias := range *update.Aliases {
+			alias = strings.TrimSpace(alias)
+			if alias == "" || departmentKey(alias) == departmentKey(dept.Name) {
+				continue
+			}
+			if other := ds.resolve(tenant, alias); other != nil && other != dept {
+				ds.restore(tenant, snapshot)
+				return Department{}, fmt.Errorf("%w: %q already refers to department %q", ErrDepartmentConflict, alias, other.Name)
+			}
+			aliases = append(aliases, alias)
+		}
+		dept.Aliases = aliases
+	}
+	if update.Region != nil && *update.Region != dept.Region {
+		dept.History = append(dept.History, DepartmentChange{Attribute: "region", From: dept.Region, To: *update.Region, ChangedAt: now})
+		dept.Region = *update.Region
+	}
+	keys := make([]string, 0, len(update.Attributes))
+	for attribute := range update.Attributes {
+		keys = append(keys, attribute)
+	}
+	sort.Strings(keys)
+	for _, attribute := range keys {
+		value := update.Attributes[attribute]
+		if dept.Attributes[attribute] == value {
+			continue
+		}
+		dept.History = append(dept.History, DepartmentChange{Attribute: attribute, From: dept.Attributes[attribute], To: value, ChangedAt: now})
+		if value == "" {
+			delete(dept.Attributes, attribute)
+		} else {
+			dept.Attributes[attribute] = value
+		}
+	}
+
+	if err := ds.save(); err != nil {
+		ds.restore(tenant, snapshot)
+		return Department{}, err
+	}
+	ds.logger.Infof("Updated department %s of tenant %s", dept.Name, tenant)
+	return dept.clone(), nil
+}
+
+// Delete removes the department with the given name or alias
+func (ds *DepartmentStore) Delete(tenant, name string) error {
+	ds.mu.Lock()
+	defer ds.mu.Unlock()
+	dept := ds.resolve(tenant, name)
+	if dept == nil {
+		return ErrDepartmentNotFound
+	}
+	snapshot := ds.snapshot(tenant)
+	delete(ds.departments[tenant], departmentKey(dept.Name))
+	if err := ds.save(); err != nil {
+		ds.restore(tenant, snapshot)
+		return err
+	}
+	ds.logger.Infof("Deleted department %s of tenant %s", dept.Name, tenant)
+	return nil
+}
+
+// Join attaches each summary's department region and attributes, resolving
+// aliases to their department. Every summary gets the same columns, in order:
+// Region followed by the tenant's attribute names sorted alphabetically.
+func (ds *DepartmentStore) Join(tenant string, summaries []DepartmentSummary) {
+	ds.mu.Lock()
+	defer ds.mu.Unlock()
+
+	attributeSet := make(map[string]bool)
+	for _, dept := range ds.departments[tenant] {
+		for attribute := range dept.Attributes {
+			attributeSet[attribute] = true
+		}
+	}
+	columns := make([]string, 0, len(attributeSet))
+	for attribute := range attributeSet {
+		columns = append(columns, attribute)
+	}
+	sort.Strings(columns)
+
+	for i := range summaries {
+		dept := ds.resolve(tenant, summaries[i].Department)
+		if dept == nil {
+			dept = &Department{}
+		}
+		dims := []DimensionValue{{Column: "Region", Value: dept.Region}}
+		for _, column := range columns {
+			dims = append(dims, DimensionValue{Column: column, Value: dept.Attributes[column]})
+		}
+		summaries[i].Dimensions = dims
+	}
+}
+
+// resolve finds a department by name, then by alias. Callers must hold ds.mu.
+func (ds *DepartmentStore) resolve(tenant, name string) *Department {
+	key := departmentKey(name)
+	table := ds.departments[tenant]
+	if dept, ok := table[key]; ok {
+		return dept
+	}
+	for _, dept := range table {
+		for _, alias := range dept.Aliases {
+			if departmentKey(alias) == key {
+				return dept
+			}
+		}
+	}
+	return nil
+}
+
+// table returns a tenant's table, creating it. Callers must hold ds.mu.
+func (ds *DepartmentStore) table(tenant string) map[string]*Department {
+	table, ok := ds.departments[tenant]
+	if !ok {
+		table = make(map[string]*Department)
+		ds.departments[tenant] = table
+	}
+	return table
+}
+
+// snapshot deep-copies a tenant's table so a failed save can be rolled back.
+// Callers must hold ds.mu.
+func (ds *DepartmentStore) snapshot(tenant string) map[string]*Department {
+	table, ok := ds.departments[tenant]
+	if !ok {
+		return nil
+	}
+	copied := make(map[string]*Department, len(table))
+	for key, dept := range table {
+		clone := dept.clone()
+		copied[key] = &clone
+	}
+	return copied
+}
+
+// clone deep-copies a department so callers can't share its slices and map
+func (d *Department) clone() Department {
+	clone := *d
+	clone.Aliases = append([]string{}, d.Aliases...)
+	clone.History = append([]DepartmentChange{}, d.History...)
+	clone.Attributes = make(map[string]string, len(d.Attributes))
+	for k, v := range d.Attributes {
+		clone.Attributes[k] = v
+	}
+	return clone
+}
+
+// restore puts back a snapshot taken by snapshot. Callers must hold ds.mu.
+func (ds *DepartmentStore) restore(tenant string, snapshot map[string]*Department) {
+	if snapshot == nil {
+		delete(ds.departments, tenant)
+		return
+	}
+	ds.departments[tenant] = snapshot
+}
+
+// save persists the departments. Callers must hold ds.mu.
+func (ds *DepartmentStore) save() error {
+	data, err := json.MarshalIndent(ds.departments, "", "  ")
+	if err != nil {
+		return fmt.Errorf("failed to encode departments: %w", err)
+	}
+	return writeFileAtomic(ds.path, data, 0600)
+}
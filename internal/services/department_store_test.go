@@ -0,0 +1,119 @@
+package services
+
+import (
+	"github.com/mussietl/csv-sales-api/internal/models"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/sirupsen/logrus"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func newTestDepartmentStore(t *testing.T) (*DepartmentStore, string) {
+	logger := logrus.New()
+	logger.SetLevel(logrus.ErrorLevel)
+	path := filepath.Join(t.TempDir(), "departments.json")
+	ds, err := NewDepartmentStore(path, logger)
+	require.NoError(t, err)
+	return ds, path
+}
+
+func TestDepartmentStoreObserve(t *testing.T) {
+	ds, path := newTestDepartmentStore(t)
+	first := time.Date(2024, 3, 1, 6, 0, 0, 0, time.UTC)
+	second := first.Add(24 * time.Hour)
+
+	require.NoError(t, ds.Observe("acme", []string{"Books", "Electronics"}, first))
+	_, err := ds.Update("acme", "Books", DepartmentUpdate{Aliases: &[]string{"Literature"}})
+	require.NoError(t, err)
+	// An alias counts towards its department, once per upload
+	require.NoError(t, ds.Observe("acme", []string{"literature", "Books"}, second))
+
+	books, err := ds.Get("acme", "LITERATURE")
+	require.NoError(t, err)
+	assert.Equal(t, "Books", books.Name)
+	assert.Equal(t, first, *books.FirstSeen)
+	assert.Equal(t, second, *books.LastSeen)
+	assert.Equal(t, 2, books.Uploads)
+
+	electronics, err := ds.Get("acme", "Electronics")
+	require.NoError(t, err)
+	assert.Equal(t, first, *electronics.LastSeen)
+
+	// Tables are per tenant and survive a restart
+	_, err = ds.Get("other", "Books")
+	assert.ErrorIs(t, err, ErrDepartmentNotFound)
+	reloaded, err := NewDepartmentStore(path, logrus.New())
+	require.NoError(t, err)
+	assert.Len(t, reloaded.List("acme"), 2)
+}
+
+func TestDepartmentStoreUpdateHistory(t *testing.T) {
+	ds, _ := newTestDepartmentStore(t)
+
+	north, south := "North", "South"
+	dept, err := ds.Update("acme", "Books", DepartmentUpdate{Region: &north, Attributes: map[string]string{"manager": "Ana"}})
+	require.NoError(t, err)
+	assert.Nil(t, dept.FirstSeen, "departments created by an update have not been seen in an upload")
+
+	dept, err = ds.Update("acme", "Books", DepartmentUpdate{Region: &south, Attributes: map[string]string{"manager": ""}})
+	require.NoError(t, err)
+	assert.Equal(t, "South", dept.Region)
+	assert.Empty(t, dept.Attributes)
+	require.Len(t, dept.History, 4)
+	assert.Equal(t, "region", dept.History[2].Attribute)
+	assert.Equal(t, "North", dept.History[2].From)
+	assert.Equal(t, "South", dept.History[2].To)
+	assert.Equal(t, DepartmentChange{Attribute: "manager", From: "Ana", To: "", ChangedAt: dept.History[3].ChangedAt}, dept.History[3])
+
+	// Unchanged values are not recorded again
+	dept, err = ds.Update("acme", "Books", DepartmentUpdate{Region: &south})
+	require.NoError(t, err)
+	assert.Len(t, dept.History, 4)
+
+	_, err = ds.Update("acme", "Books", DepartmentUpdate{Attributes: map[string]string{"Region": "x"}})
+	assert.Error(t, err)
+}
+
+func TestDepartmentStoreAliasConflict(t *testing.T) {
+	ds, _ := newTestDepartmentStore(t)
+	require.NoError(t, ds.Observe("acme", []string{"Books", "Electronics"}, time.Now()))
+
+	_, err := ds.Update("acme", "Books", DepartmentUpdate{Aliases: &[]string{"electronics"}})
+	assert.ErrorIs(t, err, ErrDepartmentConflict)
+
+	// A failed update of a new department leaves no trace
+	_, err = ds.Update("acme", "Media", DepartmentUpdate{Aliases: &[]string{"Books"}})
+	assert.ErrorIs(t, err, ErrDepartmentConflict)
+	_, err = ds.Get("acme", "Media")
+	assert.ErrorIs(t, err, ErrDepartmentNotFound)
+
+	require.NoError(t, ds.Delete("acme", "Books"))
+	assert.ErrorIs(t, ds.Delete("acme", "Books"), ErrDepartmentNotFound)
+}
+
+func TestDepartmentStoreJoin(t *testing.T) {
+	ds, _ := newTestDepartmentStore(t)
+	north := "North, East"
+	_, err := ds.Update("acme", "Books", DepartmentUpdate{Region: &north, Aliases: &[]string{"Literature"}, Attributes: map[string]string{"manager": "Ana"}})
+	require.NoError(t, err)
+	_, err = ds.Update("acme", "Toys", DepartmentUpdate{Attributes: map[string]string{"category": "kids"}})
+	require.NoError(t, err)
+
+	summaries := []DepartmentSummary{{Department: "Literature", TotalSales: models.WholeAmount(5)}, {Department: "Garden", TotalSales: models.WholeAmount(3)}}
+	ds.Join("acme", summaries)
+	assert.Equal(t, []DimensionValue{{Column: "Region", Value: "North, East"}, {Column: "category", Value: ""}, {Column: "manager", Value: "Ana"}}, summaries[0].Dimensions)
+	assert.Equal(t, []DimensionValue{{Column: "Region", Value: ""}, {Column: "category", Value: ""}, {Column: "manager", Value: ""}}, summaries[1].Dimensions)
+
+	logger := logrus.New()
+	logger.SetLevel(logrus.ErrorLevel)
+	fs := NewFileService(t.TempDir(), logger)
+	path, err := fs.SaveResultFile(summaries)
+	require.NoError(t, err)
+	content, err := os.ReadFile(path)
+	require.NoError(t, err)
+	assert.Equal(t, "Department Name,Total Number of Sales,Region,category,manager\nLiterature,5,\"North, East\",,Ana\nGarden,3,,,\n", string(content))
+}
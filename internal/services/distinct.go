@@ -0,0 +1,132 @@
+package services
+
+import (
+	"hash/fnv"
+	"math"
+	"math/bits"
+)
+
+// Distinct counting modes accepted by ProcessOptions.DistinctMode
+const (
+	DistinctModeAuto   = "auto"
+	DistinctModeExact  = "exact"
+	DistinctModeApprox = "approx"
+)
+
+// exactDistinctLimit is the number of distinct values a department may hold in
+// an exact set before auto mode switches it over to HyperLogLog
+const exactDistinctLimit = 100000
+
+// hllPrecision gives 2^14 registers, a standard error of roughly 0.8%
+const hllPrecision = 14
+
+// distinctCounter counts distinct string values
+type distinctCounter interface {
+	Add(value string)
+	Count() int64
+	Approximate() bool
+}
+
+// newDistinctCounter creates a counter for the given mode
+func newDistinctCounter(mode string) distinctCounter {
+	switch mode {
+	case DistinctModeApprox:
+		return newHyperLogLog()
+	case DistinctModeExact:
+		return &exactCounter{values: make(map[string]struct{}), limit: -1}
+	default:
+		return &exactCounter{values: make(map[string]struct{}), limit: exactDistinctLimit}
+	}
+}
+
+// exactCounter keeps every distinct value in a set. When limit is reached the
+// values are folded into a HyperLogLog sketch and the set is released.
+type exactCounter struct {
+	values map[string]struct{}
+	limit  int
+	sketch *hyperLogLog
+}
+
+func (ec *exactCounter) Add(value string) {
+	if ec.sketch != nil {
+		ec.sketch.Add(value)
+		return
+	}
+
+	ec.values[value] = struct{}{}
+	if ec.limit > 0 && len(ec.values) >= ec.limit {
+		ec.sketch = newHyperLogLog()
+		for v := range ec.values {
+			ec.sketch.Add(v)
+		}
+		ec.values = nil
+	}
+}
+
+func (ec *exactCounter) Count() int64 {
+	if ec.sketch != nil {
+		return ec.sketch.Count()
+	}
+	return int64(len(ec.values))
+}
+
+func (ec *exactCounter) Approximate() bool {
+	return ec.sketch != nil
+}
+
+// hyperLogLog is a fixed-precision HyperLogLog cardinality estimator
+type hyperLogLog struct {
+	registers []uint8
+}
+
+func newHyperLogLog() *hyperLogLog {
+	return &hyperLogLog{registers: make([]uint8, 1<<hllPrecision)}
+}
+
+func (h *hyperLogLog) Add(value string) {
+	hasher := fnv.New64a()
+	hasher.Write([]byte(value))
+	hash := mix64(hasher.Sum64())
+
+	index := hash >> (64 - hllPrecision)
+	rank := uint8(bits.LeadingZeros64(hash<<hllPrecision|1<<(hllPrecision-1)) + 1)
+	if rank > h.registers[index] {
+		h.registers[index] = rank
+	}
+}
+
+func (h *hyperLogLog) Count() int64 {
+	m := float64(len(h.registers))
+	sum := 0.0
+	zeros := 0
+	for _, r := range h.registers {
+		sum += 1 / float64(uint64(1)<<r)
+		if r == 0 {
+			zeros++
+		}
+	}
+
+	alpha := 0.7213 / (1 + 1.079/m)
+	estimate := alpha * m * m / sum
+
+	// Small range correction using linear counting
+	if estimate <= 2.5*m && zeros > 0 {
+		estimate = m * math.Log(m/float64(zeros))
+	}
+
+	return int64(estimate + 0.5)
+}
+
+func (h *hyperLogLog) Approximate() bool {
+	return true
+}
+
+// mix64 spreads FNV output bits so the register index is well distributed
+func mix64(x uint64) uint64 {
+	x ^= x >> 33
+	x *= 0xff51afd7ed558ccd
+	x ^= x >> 33
+	x *= 0xc4ceb9fe1a85ec53
+	x ^= x >> 33
+	return x
+}
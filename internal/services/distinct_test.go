@@ -0,0 +1,71 @@
+package services
+
+import (
+	"fmt"
+	"os"
+	"testing"
+
+	"github.com/sirupsen/logrus"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestDistinctCounters(t *testing.T) {
+	exact := newDistinctCounter(DistinctModeExact)
+	for i := 0; i < 1000; i++ {
+		exact.Add(fmt.Sprintf("order-%d", i%250))
+	}
+	assert.Equal(t, int64(250), exact.Count())
+	assert.False(t, exact.Approximate())
+
+	approx := newDistinctCounter(DistinctModeApprox)
+	for i := 0; i < 50000; i++ {
+		approx.Add(fmt.Sprintf("order-%d", i))
+	}
+	assert.True(t, approx.Approximate())
+	assert.InEpsilon(t, 50000, approx.Count(), 0.03)
+}
+
+func TestExactCounterSwitchesToSketch(t *testing.T) {
+	counter := &exactCounter{values: make(map[string]struct{}), limit: 100}
+	for i := 0; i < 1000; i++ {
+		counter.Add(fmt.Sprintf("value-%d", i))
+	}
+	assert.True(t, counter.Approximate())
+	assert.Nil(t, counter.values)
+	assert.InEpsilon(t, 1000, counter.Count(), 0.05)
+}
+
+func TestCSVServiceProcessDistinct(t *testing.T) {
+	logger := logrus.New()
+	logger.SetLevel(logrus.ErrorLevel)
+	csvService := NewCSVService(logger)
+
+	tempFile, err := os.CreateTemp("", "test_*.csv")
+	require.NoError(t, err)
+	defer os.Remove(tempFile.Name())
+
+	_, err = tempFile.WriteString(`department,order_id,product,sales
+Electronics,1,TV,100
+Electronics,1,Radio,50
+Electronics,2,TV,200
+Books,3,Novel,10
+Books,4,,20`)
+	require.NoError(t, err)
+	tempFile.Close()
+
+	result, err := csvService.Process(tempFile.Name(), ProcessOptions{DistinctColumns: []string{"Order_ID", "product"}})
+	require.NoError(t, err)
+
+	counts := make(map[string][]int64)
+	for _, s := range result.Summaries {
+		for _, dc := range s.DistinctCounts {
+			counts[s.Department] = append(counts[s.Department], dc.Count)
+		}
+	}
+	assert.Equal(t, []int64{2, 2}, counts["Electronics"])
+	assert.Equal(t, []int64{2, 1}, counts["Books"])
+
+	_, err = csvService.Process(tempFile.Name(), ProcessOptions{DistinctColumns: []string{"missing"}})
+	assert.Error(t, err)
+}
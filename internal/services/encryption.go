@@ -0,0 +1,346 @@
+package services
+
+import (
+	"bytes"
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+
+	"golang.org/x/crypto/chacha20poly1305"
+	"golang.org/x/crypto/curve25519"
+	"golang.org/x/crypto/hkdf"
+	"golang.org/x/crypto/scrypt"
+)
+
+// AgeHeader starts every file encrypted in the age format
+const AgeHeader = "age-encryption.org/v1\n"
+
+// MaxEncryptionRecipients bounds the public keys a result is encrypted to
+const MaxEncryptionRecipients = 16
+
+const (
+	ageFileKeySize     = 16
+	ageStreamNonceSize = 16
+	ageChunkSize       = 64 << 10
+	ageX25519Label     = "age-encryption.org/v1/X25519"
+	ageScryptLabel     = "age-encryption.org/v1/scrypt"
+	ageRecipientHRP    = "age"
+)
+
+// ageScryptWorkFactor is the base-2 logarithm of the scrypt cost of
+// passphrase encryption, as used by the age tool
+var ageScryptWorkFactor = 18
+
+// Encryptor encrypts files in the age format (age-encryption.org/v1), to
+// X25519 public keys (age1...) or to a passphrase, so they can be decrypted
+// with the age or rage tools: `age -d -i key.txt result.csv.age`
+type Encryptor struct {
+	recipients [][]byte
+	passphrase string
+}
+
+// NewEncryptor creates an Encryptor for age public keys or a passphrase;
+// age allows one or the other, not both
+func NewEncryptor(recipients []string, passphrase string) (*Encryptor, error) {
+	switch {
+	case len(recipients) == 0 && passphrase == "":
+		return nil, errors.New("an age public key or a passphrase is required")
+	case len(recipients) > 0 && passphrase != "":
+		return nil, errors.New("encrypt to public keys or a passphrase, not both")
+	case len(recipients) > MaxEncryptionRecipients:
+		return nil, fmt.Errorf("at most %d public keys are allowed", MaxEncryptionRecipients)
+	}
+	e := &Encryptor{passphrase: passphrase}
+	for _, recipient := range recipients {
+		key, err := parseAgeRecipient(recipient)
+		if err != nil {
+			return nil, err
+		}
+		e.recipients = append(e.recipients, key)
+	}
+	return e, nil
+}
+
+// parseAgeRecipient decodes an age X25519 public key such as age1ql3z7...
+func parseAgeRecipient(recipient string) ([]byte, error) {
+	hrp, key, err := decodeBech32(recipient)
+	if err != nil {
+		return nil, fmt.Errorf("invalid age public key %q: %w", recipient, err)
+	}
+	if hrp != ageRecipientHRP || len(key) != curve25519.PointSize {
+		return nil, fmt.Errorf("invalid age public key %q: not an X25519 recipient", recipient)
+	}
+	return key, nil
+}
+
+// Encrypt writes src to dst encrypted: the header wraps a random file key
+// for each recipient, and the payload is encrypted with a key derived from
+// it in 64 KiB ChaCha20-Poly1305 chunks
+func (e *Encryptor) Encrypt(dst io.Writer, src io.Reader) error {
+	fileKey := make([]byte, ageFileKeySize)
+	if _, err := rand.Read(fileKey); err != nil {
+		return err
+	}
+
+	var header bytes.Buffer
+	header.WriteString(AgeHeader)
+	if e.passphrase != "" {
+		if err := e.writeScryptStanza(&header, fileKey); err != nil {
+			return err
+		}
+	}
+	for _, recipient := range e.recipients {
+		if err := writeX25519Stanza(&header, recipient, fileKey); err != nil {
+			return err
+		}
+	}
+	header.WriteString("---")
+	mac := hmac.New(sha256.New, ageKey(fileKey, nil, "header"))
+	mac.Write(header.Bytes())
+	header.WriteString(" " + base64.RawStdEncoding.EncodeToString(mac.Sum(nil)) + "\n")
+	if _, err := dst.Write(header.Bytes()); err != nil {
+		return err
+	}
+
+	nonce := make([]byte, ageStreamNonceSize)
+	if _, err := rand.Read(nonce); err != nil {
+		return err
+	}
+	if _, err := dst.Write(nonce); err != nil {
+		return err
+	}
+	return ageEncryptPayload(dst, src, ageKey(fileKey, nonce, "payload"))
+}
+
+// writeX25519Stanza wraps the file key for a public key, with a shared
+// secret agreed with a new ephemeral key
+func writeX25519Stanza(header *bytes.Buffer, recipient, fileKey []byte) error {
+	ephemeral := make([]byte, curve25519.ScalarSize)
+	if _, err := rand.Read(ephemeral); err != nil {
+		return err
+	}
+	share, err := curve25519.X25519(ephemeral, curve25519.Basepoint)
+	if err != nil {
+		return err
+	}
+	shared, err := curve25519.X25519(ephemeral, recipient)
+	if err != nil {
+		return fmt.Errorf("invalid age public key: %w", err)
+	}
+	salt := append(append([]byte{}, share...), recipient...)
+	body, err := ageWrap(ageKey(shared, salt, ageX25519Label), fileKey)
+	if err != nil {
+		return err
+	}
+	writeAgeStanza(header, body, "X25519", base64.RawStdEncoding.EncodeToString(share))
+	return nil
+}
+
+// writeScryptStanza wraps the file key with a key derived from the passphrase
+func (e *Encryptor) writeScryptStanza(header *bytes.Buffer, fileKey []byte) error {
+	salt := make([]byte, 16)
+	if _, err := rand.Read(salt); err != nil {
+		return err
+	}
+	key, err := scrypt.Key([]byte(e.passphrase), append([]byte(ageScryptLabel), salt...), 1<<ageScryptWorkFactor, 8, 1, chacha20poly1305.KeySize)
+	if err != nil {
+		return err
+	}
+	body, err := ageWrap(key, fileKey)
+	if err != nil {
+		return err
+	}
+	writeAgeStanza(header, body, "scrypt", base64.RawStdEncoding.EncodeToString(salt), strconv.Itoa(ageScryptWorkFactor))
+	return nil
+}
+
+// writeAgeStanza writes a recipient stanza: its type and arguments, then its
+// body in base64 lines of 64 columns, the last of which is always shorter
+func writeAgeStanza(header *bytes.Buffer, body []byte, args ...string) {
+	header.WriteString("-> " + strings.Join(args, " ") + "\n")
+	encoded := base64.RawStdEncoding.EncodeToString(body)
+	for len(encoded) >= 64 {
+		header.WriteString(encoded[:64] + "\n")
+		encoded = encoded[64:]
+	}
+	header.WriteString(encoded + "\n")
+}
+
+// ageKey derives a 32-byte key with HKDF-SHA-256
+func ageKey(secret, salt []byte, label string) []byte {
+	key := make([]byte, chacha20poly1305.KeySize)
+	if _, err := io.ReadFull(hkdf.New(sha256.New, secret, salt, []byte(label)), key); err != nil {
+		panic(err)
+	}
+	return key
+}
+
+// ageWrap encrypts a file key with a single-use key, so the nonce is zero
+func ageWrap(key, fileKey []byte) ([]byte, error) {
+	aead, err := chacha20poly1305.New(key)
+	if err != nil {
+		return nil, err
+	}
+	return aead.Seal(nil, make([]byte, chacha20poly1305.NonceSize), fileKey, nil), nil
+}
+
+// ageEncryptPayload encrypts src in chunks whose nonce is an 11-byte
+// counter and a flag set on the last chunk, which is only empty when src is
+func ageEncryptPayload(dst io.Writer, src io.Reader, key []byte) error {
+	aead, err := chacha20poly1305.New(key)
+	if err != nil {
+		return err
+	}
+	nonce := make([]byte, chacha20poly1305.NonceSize)
+	chunk := make([]byte, ageChunkSize)
+	next := make([]byte, ageChunkSize)
+	n, err := io.ReadFull(src, chunk)
+	for counter := uint64(0); ; counter++ {
+		last := true
+		var m int
+		if err == nil {
+			m, err = io.ReadFull(src, next)
+			last = m == 0
+		}
+		if err != nil && !errors.Is(err, io.EOF) && !errors.Is(err, io.ErrUnexpectedEOF) {
+			return err
+		}
+		for i := 0; i < 8; i++ {
+			nonce[10-i] = byte(counter >> (8 * i))
+		}
+		if last {
+			nonce[11] = 1
+		}
+		if _, werr := dst.Write(aead.Seal(nil, nonce, chunk[:n], nil)); werr != nil {
+			return werr
+		}
+		if last {
+			return nil
+		}
+		chunk, next, n = next, chunk, m
+	}
+}
+
+// encryptFile replaces a file with its encryption, keeping its name
+func (fs *FileService) encryptFile(filePath string) error {
+	src, err := os.Open(filePath)
+	if err != nil {
+		return fmt.Errorf("failed to open %s: %w", filePath, err)
+	}
+	defer src.Close()
+	tmp, err := os.CreateTemp(filepath.Dir(filePath), ".encrypt-*")
+	if err != nil {
+		return fmt.Errorf("failed to encrypt %s: %w", filepath.Base(filePath), err)
+	}
+	err = fs.encryptor.Encrypt(tmp, src)
+	if closeErr := tmp.Close(); err == nil {
+		err = closeErr
+	}
+	if err == nil {
+		err = os.Rename(tmp.Name(), filePath)
+	}
+	if err != nil {
+		os.Remove(tmp.Name())
+		return fmt.Errorf("failed to encrypt %s: %w", filepath.Base(filePath), err)
+	}
+	return nil
+}
+
+// IsEncrypted reports whether a file starts with the age header
+func IsEncrypted(file io.ReaderAt) bool {
+	header := make([]byte, len(AgeHeader))
+	n, _ := file.ReadAt(header, 0)
+	return n == len(header) && string(header) == AgeHeader
+}
+
+// bech32Charset is the alphabet of bech32 data characters
+const bech32Charset = "qpzry9x8gf2tvdw0s3jn54khce6mua7l"
+
+// decodeBech32 decodes a bech32 string, as used for age keys, into its
+// human-readable part and 8-bit data. Unlike BIP 173, any length is allowed.
+func decodeBech32(s string) (string, []byte, error) {
+	if strings.ToLower(s) != s && strings.ToUpper(s) != s {
+		return "", nil, errors.New("mixed case")
+	}
+	s = strings.ToLower(s)
+	pos := strings.LastIndexByte(s, '1')
+	if pos < 1 || pos+7 > len(s) {
+		return "", nil, errors.New("separator misplaced")
+	}
+	hrp := s[:pos]
+	values := make([]byte, 0, len(s)-pos-1)
+	for _, c := range s[pos+1:] {
+		v := strings.IndexRune(bech32Charset, c)
+		if v < 0 {
+			return "", nil, fmt.Errorf("invalid character %q", c)
+		}
+		values = append(values, byte(v))
+	}
+	if bech32Polymod(append(bech32ExpandHRP(hrp), values...)) != 1 {
+		return "", nil, errors.New("invalid checksum")
+	}
+	data, err := convertBits(values[:len(values)-6], 5, 8, false)
+	if err != nil {
+		return "", nil, err
+	}
+	return hrp, data, nil
+}
+
+func bech32Polymod(values []byte) uint32 {
+	generator := [5]uint32{0x3b6a57b2, 0x26508e6d, 0x1ea119fa, 0x3d4233dd, 0x2a1462b3}
+	chk := uint32(1)
+	for _, v := range values {
+		top := chk >> 25
+		chk = (chk&0x1ffffff)<<5 ^ uint32(v)
+		for i := 0; i < 5; i++ {
+			if (top>>i)&1 == 1 {
+				chk ^= generator[i]
+			}
+		}
+	}
+	return chk
+}
+
+func bech32ExpandHRP(hrp string) []byte {
+	expanded := make([]byte, 0, 2*len(hrp)+1)
+	for i := 0; i < len(hrp); i++ {
+		expanded = append(expanded, hrp[i]>>5)
+	}
+	expanded = append(expanded, 0)
+	for i := 0; i < len(hrp); i++ {
+		expanded = append(expanded, hrp[i]&31)
+	}
+	return expanded
+}
+
+// convertBits regroups values of fromBits bits into values of toBits bits
+func convertBits(data []byte, fromBits, toBits uint, pad bool) ([]byte, error) {
+	var acc uint32
+	var bits uint
+	var out []byte
+	maxValue := uint32(1)<<toBits - 1
+	for _, b := range data {
+		acc = acc<<fromBits | uint32(b)
+		bits += fromBits
+		for bits >= toBits {
+			bits -= toBits
+			out = append(out, byte(acc>>bits&maxValue))
+		}
+	}
+	if pad {
+		if bits > 0 {
+			out = append(out, byte(acc<<(toBits-bits)&maxValue))
+		}
+	} else if bits >= fromBits || acc<<(toBits-bits)&maxValue != 0 {
+		return nil, errors.New("invalid padding")
+	}
+	return out, nil
+}
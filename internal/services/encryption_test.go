@@ -0,0 +1,229 @@
+package services
+
+import (
+	"bufio"
+	"bytes"
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"io"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"testing"
+
+	"github.com/sirupsen/logrus"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"golang.org/x/crypto/chacha20poly1305"
+	"golang.org/x/crypto/curve25519"
+	"golang.org/x/crypto/scrypt"
+)
+
+// encodeBech32 encodes data with a human-readable part, to make test keys
+func encodeBech32(t *testing.T, hrp string, data []byte) string {
+	values, err := convertBits(data, 8, 5, true)
+	require.NoError(t, err)
+	polymod := bech32Polymod(append(append(bech32ExpandHRP(hrp), values...), 0, 0, 0, 0, 0, 0)) ^ 1
+	for i := 0; i < 6; i++ {
+		values = append(values, byte(polymod>>(5*(5-i))&31))
+	}
+	var b strings.Builder
+	b.WriteString(hrp + "1")
+	for _, v := range values {
+		b.WriteByte(bech32Charset[v])
+	}
+	return b.String()
+}
+
+// ageDecrypt decrypts an age file with an X25519 private key or a
+// passphrase, following the format specification
+func ageDecrypt(t *testing.T, data []byte, identity []byte, passphrase string) []byte {
+	r := bufio.NewReader(bytes.NewReader(data))
+	var header bytes.Buffer
+	line := func() string {
+		l, err := r.ReadString('\n')
+		require.NoError(t, err)
+		header.WriteString(l)
+		return strings.TrimSuffix(l, "\n")
+	}
+	require.Equal(t, strings.TrimSuffix(AgeHeader, "\n"), line())
+
+	var fileKey []byte
+	for {
+		l := line()
+		if strings.HasPrefix(l, "--- ") {
+			header.Truncate(header.Len() - len(l) - 1)
+			header.WriteString("---")
+			mac, err := base64.RawStdEncoding.DecodeString(strings.TrimPrefix(l, "--- "))
+			require.NoError(t, err)
+			require.NotNil(t, fileKey, "no stanza matched")
+			h := hmac.New(sha256.New, ageKey(fileKey, nil, "header"))
+			h.Write(header.Bytes())
+			require.True(t, hmac.Equal(mac, h.Sum(nil)), "header MAC")
+			break
+		}
+		args := strings.Fields(strings.TrimPrefix(l, "-> "))
+		var body string
+		for {
+			b := line()
+			body += b
+			if len(b) < 64 {
+				break
+			}
+		}
+		wrapped, err := base64.RawStdEncoding.DecodeString(body)
+		require.NoError(t, err)
+
+		var key []byte
+		switch {
+		case args[0] == "X25519" && identity != nil:
+			share, err := base64.RawStdEncoding.DecodeString(args[1])
+			require.NoError(t, err)
+			shared, err := curve25519.X25519(identity, share)
+			require.NoError(t, err)
+			public, err := curve25519.X25519(identity, curve25519.Basepoint)
+			require.NoError(t, err)
+			key = ageKey(shared, append(share, public...), ageX25519Label)
+		case args[0] == "scrypt" && passphrase != "":
+			salt, err := base64.RawStdEncoding.DecodeString(args[1])
+			require.NoError(t, err)
+			logN, err := strconv.Atoi(args[2])
+			require.NoError(t, err)
+			key, err = scrypt.Key([]byte(passphrase), append([]byte(ageScryptLabel), salt...), 1<<logN, 8, 1, 32)
+			require.NoError(t, err)
+		default:
+			continue
+		}
+		aead, err := chacha20poly1305.New(key)
+		require.NoError(t, err)
+		if unwrapped, err := aead.Open(nil, make([]byte, 12), wrapped, nil); err == nil {
+			fileKey = unwrapped
+		}
+	}
+
+	nonce := make([]byte, ageStreamNonceSize)
+	_, err := io.ReadFull(r, nonce)
+	require.NoError(t, err)
+	payload, err := io.ReadAll(r)
+	require.NoError(t, err)
+	aead, err := chacha20poly1305.New(ageKey(fileKey, nonce, "payload"))
+	require.NoError(t, err)
+	var plaintext []byte
+	chunkNonce := make([]byte, 12)
+	for counter := 0; ; counter++ {
+		chunkNonce[10] = byte(counter)
+		size := ageChunkSize + aead.Overhead()
+		last := len(payload) <= size
+		if !last {
+			chunk, err := aead.Open(nil, chunkNonce, payload[:size], nil)
+			require.NoError(t, err, "chunk %d", counter)
+			plaintext, payload = append(plaintext, chunk...), payload[size:]
+			continue
+		}
+		chunkNonce[11] = 1
+		chunk, err := aead.Open(nil, chunkNonce, payload, nil)
+		require.NoError(t, err, "last chunk")
+		return append(plaintext, chunk...)
+	}
+}
+
+func TestParseAgeRecipient(t *testing.T) {
+	// The example recipient from the age documentation
+	key, err := parseAgeRecipient("age1ql3z7hjy54pw3hyww5ayyfg7zqgvc7w3j2elw8zmrj2kg5sfn9aqmcac8p")
+	require.NoError(t, err)
+	assert.Len(t, key, 32)
+
+	for _, invalid := range []string{
+		"age1ql3z7hjy54pw3hyww5ayyfg7zqgvc7w3j2elw8zmrj2kg5sfn9aqmcac8q",
+		"age1ql3z7hjy54pw3hyww5ayyfg7zqgvc7w3j2elw8zmrj2kg5sfn9aqmcaC8p",
+		"ssh-ed25519 AAAA",
+		"age1qqqqqqqqqqqqqqqqq",
+	} {
+		_, err := parseAgeRecipient(invalid)
+		assert.Error(t, err, invalid)
+	}
+
+	_, err = NewEncryptor(nil, "")
+	assert.Error(t, err)
+	_, err = NewEncryptor([]string{"age1ql3z7hjy54pw3hyww5ayyfg7zqgvc7w3j2elw8zmrj2kg5sfn9aqmcac8p"}, "secret")
+	assert.ErrorContains(t, err, "not both")
+}
+
+func TestEncryptor(t *testing.T) {
+	defer func(workFactor int) { ageScryptWorkFactor = workFactor }(ageScryptWorkFactor)
+	ageScryptWorkFactor = 10
+
+	identity := make([]byte, 32)
+	other := make([]byte, 32)
+	_, err := rand.Read(identity)
+	require.NoError(t, err)
+	_, err = rand.Read(other)
+	require.NoError(t, err)
+	recipient := func(identity []byte) string {
+		public, err := curve25519.X25519(identity, curve25519.Basepoint)
+		require.NoError(t, err)
+		return encodeBech32(t, "age", public)
+	}
+
+	large := make([]byte, 2*ageChunkSize+100)
+	_, err = rand.Read(large)
+	require.NoError(t, err)
+	for _, plaintext := range [][]byte{nil, []byte("Department Name,Total Number of Sales\nBooks,10\n"), large, large[:ageChunkSize]} {
+		encryptor, err := NewEncryptor([]string{recipient(other), recipient(identity)}, "")
+		require.NoError(t, err)
+		var encrypted bytes.Buffer
+		require.NoError(t, encryptor.Encrypt(&encrypted, bytes.NewReader(plaintext)))
+		assert.True(t, IsEncrypted(bytes.NewReader(encrypted.Bytes())))
+		assert.Equal(t, plaintext, nilIfEmpty(ageDecrypt(t, encrypted.Bytes(), identity, "")), "%d bytes", len(plaintext))
+		assert.Equal(t, plaintext, nilIfEmpty(ageDecrypt(t, encrypted.Bytes(), other, "")), "%d bytes", len(plaintext))
+	}
+
+	encryptor, err := NewEncryptor(nil, "correct horse")
+	require.NoError(t, err)
+	var encrypted bytes.Buffer
+	require.NoError(t, encryptor.Encrypt(&encrypted, strings.NewReader("Books,10\n")))
+	assert.Contains(t, encrypted.String(), "\n-> scrypt ")
+	assert.Equal(t, "Books,10\n", string(ageDecrypt(t, encrypted.Bytes(), nil, "correct horse")))
+}
+
+func nilIfEmpty(data []byte) []byte {
+	if len(data) == 0 {
+		return nil
+	}
+	return data
+}
+
+func TestFileServiceWithEncryptor(t *testing.T) {
+	defer func(workFactor int) { ageScryptWorkFactor = workFactor }(ageScryptWorkFactor)
+	ageScryptWorkFactor = 10
+	logger := logrus.New()
+	logger.SetLevel(logrus.ErrorLevel)
+	fs := NewFileService(t.TempDir(), logger)
+	encryptor, err := NewEncryptor(nil, "secret")
+	require.NoError(t, err)
+
+	summaries := []DepartmentSummary{{Department: "Books", TotalSales: amount(t, "10")}}
+	path, err := fs.WithEncryptor(encryptor).SaveResultFile(summaries)
+	require.NoError(t, err)
+	data, err := os.ReadFile(path)
+	require.NoError(t, err)
+	assert.True(t, strings.HasPrefix(string(data), AgeHeader))
+	assert.NotContains(t, string(data), "Books")
+	assert.Contains(t, string(ageDecrypt(t, data, nil, "secret")), "Books,10")
+
+	// The result keeps its ID, and the service it was derived from doesn't encrypt
+	resultPath, err := fs.ResultPath(fs.ResultID(path))
+	require.NoError(t, err)
+	assert.Equal(t, path, resultPath)
+	path, err = fs.SaveResultFile(summaries)
+	require.NoError(t, err)
+	data, err = os.ReadFile(path)
+	require.NoError(t, err)
+	assert.Contains(t, string(data), "Books")
+	entries, err := os.ReadDir(filepath.Dir(path))
+	require.NoError(t, err)
+	assert.Len(t, entries, 2, "no temporary files are left")
+}
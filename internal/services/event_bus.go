@@ -0,0 +1,118 @@
+package services
+
+import (
+	"time"
+
+	"github.com/mussietl/csv-sales-api/internal/models"
+	"github.com/sirupsen/logrus"
+)
+
+// Upload lifecycle event types
+const (
+	// EventUploadReceived is published once an upload has been accepted
+	EventUploadReceived = "upload.received"
+	// EventProcessingStarted is published before an upload is aggregated
+	EventProcessingStarted = "processing.started"
+	// EventProcessingCompleted is published when processing succeeds or fails
+	EventProcessingCompleted = "processing.completed"
+	// EventCleanup is published when stored files are removed
+	EventCleanup = "cleanup"
+)
+
+// Event describes a step in an upload's lifecycle. Fields that don't apply
+// to the event's type are left empty.
+type Event struct {
+	Type     string    `json:"type"`
+	At       time.Time `json:"at"`
+	Tenant   string    `json:"tenant"`
+	UploadID string    `json:"upload_id,omitempty"`
+	Filename string    `json:"filename,omitempty"`
+	// Ephemeral is set for uploads processed without storing anything
+	Ephemeral bool `json:"ephemeral,omitempty"`
+	// Size is the stored upload's size in bytes
+	Size int64 `json:"size,omitempty"`
+
+	// Success, Error and the totals describe a completed processing run
+	Success bool   `json:"success,omitempty"`
+	Error   string `json:"error,omitempty"`
+	// ErrorType classifies Error, as one of the Failure constants
+	ErrorType        string              `json:"error_type,omitempty"`
+	TotalSales       *models.Amount      `json:"total_sales,omitempty"`
+	TotalDepartments int                 `json:"total_departments,omitempty"`
+	Summaries        []DepartmentSummary `json:"departments,omitempty"`
+	ResultID         string              `json:"result_id,omitempty"`
+	DownloadURL      string              `json:"download_url,omitempty"`
+	SchemaDrift      *models.SchemaDrift `json:"schema_drift,omitempty"`
+	Rows             *models.RowStats    `json:"rows,omitempty"`
+	Tags             []string            `json:"tags,omitempty"`
+	// MicroBatchID is the window an upload was added to, or that closed
+	MicroBatchID string `json:"micro_batch_id,omitempty"`
+	// UploadIDs lists the uploads combined by a closed micro-batch window
+	UploadIDs []string `json:"upload_ids,omitempty"`
+	// ResultPath is the result file on the server; it isn't published outside the process
+	ResultPath string `json:"-"`
+
+	// Paths lists the files removed by a cleanup
+	Paths []string `json:"paths,omitempty"`
+	// Reason says why a cleanup happened
+	Reason string `json:"reason,omitempty"`
+}
+
+// EventHandler receives published events
+type EventHandler func(Event)
+
+// subscription is a handler and the event types it receives; no types means all
+type subscription struct {
+	handler EventHandler
+	types   map[string]bool
+}
+
+// EventBus delivers upload lifecycle events to in-process subscribers, so
+// integrations can react to uploads without changes to the handlers
+type EventBus struct {
+	subscriptions []subscription
+	logger        *logrus.Logger
+}
+
+// NewEventBus creates an EventBus with no subscribers
+func NewEventBus(logger *logrus.Logger) *EventBus {
+	return &EventBus{logger: logger}
+}
+
+// Subscribe registers handler for the given event types, or for every event
+// when none are given. Subscribers must be registered before events are published.
+func (eb *EventBus) Subscribe(handler EventHandler, types ...string) {
+	sub := subscription{handler: handler}
+	if len(types) > 0 {
+		sub.types = make(map[string]bool, len(types))
+		for _, t := range types {
+			sub.types[t] = true
+		}
+	}
+	eb.subscriptions = append(eb.subscriptions, sub)
+}
+
+// Publish delivers e to its subscribers in registration order. Handlers run
+// on the publishing goroutine, so anything slow must be handed off; a
+// panicking handler is logged and doesn't affect the others.
+func (eb *EventBus) Publish(e Event) {
+	if e.At.IsZero() {
+		e.At = time.Now().UTC()
+	}
+	for _, sub := range eb.subscriptions {
+		if sub.types != nil && !sub.types[e.Type] {
+			continue
+		}
+		eb.deliver(sub.handler, e)
+	}
+}
+
+// deliver calls handler, recovering from a panic
+func (eb *EventBus) deliver(handler EventHandler, e Event) {
+	defer func() {
+		if r := recover(); r != nil {
+			eb.logger.Errorf("Event handler for %s panicked: %v", e.Type, r)
+		}
+	}()
+	handler(e)
+}
@@ -0,0 +1,116 @@
+package services
+
+import (
+	"bufio"
+	"encoding/json"
+	"fmt"
+	"github.com/mussietl/csv-sales-api/internal/models"
+	"io"
+	"net"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/sirupsen/logrus"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestEventBusSubscribe(t *testing.T) {
+	logger := logrus.New()
+	logger.SetLevel(logrus.PanicLevel)
+	bus := NewEventBus(logger)
+
+	var all, completed []string
+	bus.Subscribe(func(e Event) { all = append(all, e.Type) })
+	bus.Subscribe(func(e Event) { panic("broken integration") }, EventUploadReceived)
+	bus.Subscribe(func(e Event) {
+		assert.False(t, e.At.IsZero())
+		completed = append(completed, e.UploadID)
+	}, EventProcessingCompleted)
+
+	bus.Publish(Event{Type: EventUploadReceived, UploadID: "a"})
+	bus.Publish(Event{Type: EventProcessingStarted, UploadID: "a"})
+	bus.Publish(Event{Type: EventProcessingCompleted, UploadID: "a", Success: true})
+	bus.Publish(Event{Type: EventCleanup, UploadID: "a"})
+
+	assert.Equal(t, []string{EventUploadReceived, EventProcessingStarted, EventProcessingCompleted, EventCleanup}, all)
+	assert.Equal(t, []string{"a"}, completed)
+}
+
+// fakeNATSServer accepts one connection at a time and sends the subject and
+// payload of each PUB it receives to messages
+func fakeNATSServer(t *testing.T, messages chan<- [2]string) net.Listener {
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	require.NoError(t, err)
+	go func() {
+		for {
+			conn, err := listener.Accept()
+			if err != nil {
+				return
+			}
+			handleFakeNATS(conn, messages)
+		}
+	}()
+	return listener
+}
+
+func handleFakeNATS(conn net.Conn, messages chan<- [2]string) {
+	defer conn.Close()
+	fmt.Fprint(conn, "INFO {\"server_id\":\"test\"}\r\n")
+	reader := bufio.NewReader(conn)
+	for {
+		line, err := reader.ReadString('\n')
+		if err != nil {
+			return
+		}
+		fields := strings.Fields(line)
+		switch {
+		case len(fields) == 0:
+		case fields[0] == "PING":
+			fmt.Fprint(conn, "PONG\r\n")
+		case fields[0] == "PUB" && len(fields) == 3:
+			size, _ := strconv.Atoi(fields[2])
+			payload := make([]byte, size+2)
+			if _, err := io.ReadFull(reader, payload); err != nil {
+				return
+			}
+			messages <- [2]string{fields[1], string(payload[:size])}
+		}
+	}
+}
+
+func TestNATSPublisher(t *testing.T) {
+	logger := logrus.New()
+	logger.SetLevel(logrus.PanicLevel)
+	messages := make(chan [2]string, 4)
+	listener := fakeNATSServer(t, messages)
+	defer listener.Close()
+
+	publisher, err := NewNATSPublisher("nats://"+listener.Addr().String(), "sales", logger)
+	require.NoError(t, err)
+	total := models.WholeAmount(42)
+	publisher.Handle(Event{Type: EventProcessingCompleted, Tenant: "acme", UploadID: "u1", Success: true, TotalSales: &total})
+	publisher.Close()
+
+	select {
+	case msg := <-messages:
+		assert.Equal(t, "sales.processing.completed", msg[0])
+		var e Event
+		require.NoError(t, json.Unmarshal([]byte(msg[1]), &e))
+		assert.Equal(t, "acme", e.Tenant)
+		assert.Equal(t, "u1", e.UploadID)
+		assert.Equal(t, &total, e.TotalSales)
+	case <-time.After(5 * time.Second):
+		t.Fatal("no message published")
+	}
+}
+
+func TestNewNATSPublisherValidation(t *testing.T) {
+	logger := logrus.New()
+	_, err := NewNATSPublisher("http://localhost:4222", "sales", logger)
+	assert.Error(t, err)
+	_, err = NewNATSPublisher("nats://localhost", "sales.*", logger)
+	assert.Error(t, err)
+}
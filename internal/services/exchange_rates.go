@@ -0,0 +1,153 @@
+package services
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"math/big"
+	"net/http"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/sirupsen/logrus"
+)
+
+// ErrExchangeRate is returned when a currency can't be converted to the
+// requested base currency
+var ErrExchangeRate = errors.New("exchange rate unavailable")
+
+// ExchangeRates supplies the rates currency totals are converted with
+type ExchangeRates interface {
+	// Rate returns how many units of base one unit of currency is worth
+	Rate(currency, base string) (*big.Rat, error)
+}
+
+// StaticExchangeRates is a fixed table of each currency's value in a common
+// reference currency, which needs no entry of its own
+type StaticExchangeRates map[string]*big.Rat
+
+// ParseExchangeRates parses a static table such as "USD=1,EUR=1.08,GBP=1.27"
+func ParseExchangeRates(spec string) (StaticExchangeRates, error) {
+	rates := make(StaticExchangeRates)
+	for _, entry := range strings.Split(spec, ",") {
+		entry = strings.TrimSpace(entry)
+		if entry == "" {
+			continue
+		}
+		currency, value, ok := strings.Cut(entry, "=")
+		currency = strings.ToUpper(strings.TrimSpace(currency))
+		if !ok || !ValidCurrencyCode(currency) {
+			return nil, fmt.Errorf("invalid exchange rate %q: expected CODE=rate", entry)
+		}
+		rate, ok := new(big.Rat).SetString(strings.TrimSpace(value))
+		if !ok || rate.Sign() <= 0 {
+			return nil, fmt.Errorf("invalid exchange rate %q: rate must be a positive number", entry)
+		}
+		rates[currency] = rate
+	}
+	if len(rates) == 0 {
+		return nil, errors.New("no exchange rates given")
+	}
+	return rates, nil
+}
+
+// Rate returns the ratio of the two currencies' values
+func (r StaticExchangeRates) Rate(currency, base string) (*big.Rat, error) {
+	if currency == base {
+		return big.NewRat(1, 1), nil
+	}
+	from, ok := r[currency]
+	if !ok {
+		return nil, fmt.Errorf("%w: no rate for %s", ErrExchangeRate, currency)
+	}
+	to, ok := r[base]
+	if !ok {
+		return nil, fmt.Errorf("%w: no rate for %s", ErrExchangeRate, base)
+	}
+	return new(big.Rat).Quo(from, to), nil
+}
+
+// RemoteExchangeRates fetches a rate table from an HTTP endpoint answering
+// with JSON such as {"base": "USD", "rates": {"EUR": 0.92, "GBP": 0.79}},
+// each rate the units of that currency one unit of base buys. The table is
+// kept for ttl; when a refresh fails, the last table is used until one
+// succeeds.
+type RemoteExchangeRates struct {
+	url    string
+	ttl    time.Duration
+	client *http.Client
+	logger *logrus.Logger
+	now    func() time.Time
+
+	mu        sync.Mutex
+	table     StaticExchangeRates
+	fetchedAt time.Time
+}
+
+// NewRemoteExchangeRates creates RemoteExchangeRates fetching from url
+func NewRemoteExchangeRates(url string, ttl time.Duration, logger *logrus.Logger) *RemoteExchangeRates {
+	return &RemoteExchangeRates{
+		url:    url,
+		ttl:    ttl,
+		client: &http.Client{Timeout: 10 * time.Second},
+		logger: logger,
+		now:    time.Now,
+	}
+}
+
+// Rate returns the rate from the current table, fetching it if it is stale
+func (r *RemoteExchangeRates) Rate(currency, base string) (*big.Rat, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if r.table == nil || r.now().Sub(r.fetchedAt) >= r.ttl {
+		table, err := r.fetch()
+		switch {
+		case err == nil:
+			r.table, r.fetchedAt = table, r.now()
+		case r.table == nil:
+			return nil, fmt.Errorf("%w: %v", ErrExchangeRate, err)
+		default:
+			r.logger.Warnf("Failed to refresh exchange rates, using rates from %s: %v", r.fetchedAt.UTC().Format(time.RFC3339), err)
+		}
+	}
+	return r.table.Rate(currency, base)
+}
+
+// fetch downloads the rate table and turns it into currency values in its base
+func (r *RemoteExchangeRates) fetch() (StaticExchangeRates, error) {
+	resp, err := r.client.Get(r.url)
+	if err != nil {
+		return nil, fmt.Errorf("failed to fetch exchange rates: %w", err)
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("failed to fetch exchange rates: status %d", resp.StatusCode)
+	}
+
+	var body struct {
+		Base  string                 `json:"base"`
+		Rates map[string]json.Number `json:"rates"`
+	}
+	decoder := json.NewDecoder(io.LimitReader(resp.Body, 1<<20))
+	decoder.UseNumber()
+	if err := decoder.Decode(&body); err != nil {
+		return nil, fmt.Errorf("failed to decode exchange rates: %w", err)
+	}
+	base := strings.ToUpper(body.Base)
+	if !ValidCurrencyCode(base) {
+		return nil, fmt.Errorf("exchange rates have an invalid base %q", body.Base)
+	}
+
+	table := StaticExchangeRates{base: big.NewRat(1, 1)}
+	for currency, number := range body.Rates {
+		rate, ok := new(big.Rat).SetString(number.String())
+		if !ok || rate.Sign() <= 0 || !ValidCurrencyCode(currency) {
+			return nil, fmt.Errorf("exchange rates have an invalid rate for %q", currency)
+		}
+		table[strings.ToUpper(currency)] = rate.Inv(rate)
+	}
+	r.logger.Infof("Fetched %d exchange rates in %s", len(body.Rates), base)
+	return table, nil
+}
@@ -0,0 +1,231 @@
+package services
+
+import (
+	"bufio"
+	"bytes"
+	"fmt"
+	"io"
+
+	"github.com/mussietl/csv-sales-api/internal/models"
+)
+
+// Parsers for data rows
+const (
+	// ParserDefault uses the service's configured parser
+	ParserDefault = ""
+	// ParserStandard parses rows with encoding/csv
+	ParserStandard = "standard"
+	// ParserFast scans rows as bytes, only converting the fields it needs to
+	// strings, and falls back to encoding/csv from the first quoted row on
+	ParserFast = "fast"
+)
+
+// maxFastDigits is the longest sales value parsed without overflow checks
+const maxFastDigits = 18
+
+// ValidateParser checks that parser is a known row parser
+func ValidateParser(parser string) error {
+	switch parser {
+	case ParserDefault, ParserStandard, ParserFast:
+		return nil
+	}
+	return fmt.Errorf("invalid parser %q: expected standard or fast", parser)
+}
+
+// consumeFast aggregates the records of r like consumeCSV, but splits
+// unquoted rows on the delimiter itself. Fields are sliced from the read buffer and
+// only the department, sales and distinct fields become strings. Quoting
+// needs encoding/csv, so at the first row containing a quote the rest of
+// the input is handed to consumeCSV.
+func (a *rowAggregator) consumeFast(r io.Reader, rowNumber int, where string) error {
+	reader, ok := r.(*bufio.Reader)
+	if !ok {
+		reader = bufio.NewReaderSize(r, 64*1024)
+	}
+	departmentIndex, salesIndex := a.layout.department, a.layout.sales
+	width := a.layout.width()
+	fields := make([][]byte, 0, width)
+	// Department names recur on most rows, so each is converted once
+	interned := make(map[string]string)
+	trimOnly := a.valueNorm == Normalization{Trim: true}
+
+	for {
+		line, err := reader.ReadSlice('\n')
+		if err == bufio.ErrBufferFull {
+			long := append([]byte(nil), line...)
+			for err == bufio.ErrBufferFull {
+				line, err = reader.ReadSlice('\n')
+				long = append(long, line...)
+			}
+			line = long
+		}
+		if err != nil && err != io.EOF {
+			a.logger.Errorf("Failed to read CSV record at %srow %d: %v", where, rowNumber+1, err)
+			return fmt.Errorf("failed to read CSV record at %srow %d: %w", where, rowNumber+1, err)
+		}
+		if len(line) == 0 && err == io.EOF {
+			return nil
+		}
+
+		if bytes.IndexByte(line, '"') >= 0 {
+			rest := io.MultiReader(bytes.NewReader(append([]byte(nil), line...)), reader)
+			return a.consumeCSV(rest, rowNumber, where)
+		}
+
+		// Line endings are handled as encoding/csv does: \r\n becomes \n, a
+		// trailing \r at EOF is dropped and empty lines are skipped
+		line = bytes.TrimSuffix(line, []byte{'\n'})
+		line = bytes.TrimSuffix(line, []byte{'\r'})
+		if len(line) == 0 {
+			if err == io.EOF {
+				return nil
+			}
+			continue
+		}
+
+		fields = splitFields(fields[:0], line, width, a.layout.comma)
+		if blankFields(fields) {
+			record := make([]string, len(fields))
+			for i, field := range fields {
+				record[i] = string(field)
+			}
+			a.blank = append(a.blank, record)
+			if err == io.EOF {
+				return nil
+			}
+			continue
+		}
+		var flushErr error
+		if rowNumber, flushErr = a.flushBlank(rowNumber, where); flushErr != nil {
+			return flushErr
+		}
+
+		rowNumber++
+		a.rows.Total++
+		a.opts.progress.row()
+
+		if a.layout.schema != nil {
+			if rowErr := a.checkSchema(rowNumber, func(index int) string {
+				if index < len(fields) {
+					return string(fields[index])
+				}
+				return ""
+			}); rowErr != nil {
+				return rowErr
+			}
+		}
+		if len(fields) <= departmentIndex || len(fields) <= salesIndex {
+			a.logger.Warnf("Skipping %srow %d: insufficient columns", where, rowNumber)
+			rejected := rejectedRow{row: rowNumber, reason: SkipInsufficientColumns, detail: fmt.Sprintf("row has %d columns", len(fields))}
+			if departmentIndex < len(fields) {
+				rejected.department = string(fields[departmentIndex])
+			}
+			if salesIndex < len(fields) {
+				rejected.sales = string(fields[salesIndex])
+			}
+			if rowErr := a.skip(rejected); rowErr != nil {
+				return rowErr
+			}
+		} else if rowErr := a.acceptFast(fields, rowNumber, where, interned, trimOnly); rowErr != nil {
+			return rowErr
+		}
+
+		if err == io.EOF {
+			return nil
+		}
+	}
+}
+
+// acceptFast validates and aggregates one row split by consumeFast
+func (a *rowAggregator) acceptFast(fields [][]byte, rowNumber int, where string, interned map[string]string, trimOnly bool) error {
+	var department string
+	if trimOnly {
+		raw := bytes.TrimSpace(fields[a.layout.department])
+		var ok bool
+		if department, ok = interned[string(raw)]; !ok {
+			department = string(raw)
+			interned[department] = department
+		}
+	} else {
+		department = a.valueNorm.Apply(string(fields[a.layout.department]))
+	}
+	if department == "" {
+		a.logger.Warnf("Skipping %srow %d: empty department", where, rowNumber)
+		return a.skip(rejectedRow{
+			row: rowNumber, reason: SkipEmptyDepartment, detail: "empty department",
+			department: string(fields[a.layout.department]), sales: string(fields[a.layout.sales]),
+		})
+	}
+
+	salesField := bytes.TrimSpace(fields[a.layout.sales])
+	n, ok := parseFastInt(salesField)
+	sales := models.WholeAmount(n)
+	if len(salesField) == 0 {
+		if ok, err := a.missingSales(rowNumber, where, department); !ok {
+			return err
+		}
+	} else if !ok {
+		// Anything but a plain integer gets the regular parser and its errors
+		salesStr := string(salesField)
+		var err error
+		if sales, err = parseSales(salesStr, a.opts); err != nil {
+			a.logger.Warnf("Skipping %srow %d: invalid sales value '%s': %v", where, rowNumber, salesStr, err)
+			return a.skip(rejectedRow{row: rowNumber, reason: SkipInvalidSales, detail: err.Error(), department: department, sales: string(fields[a.layout.sales])})
+		}
+	}
+
+	return a.accept(rowNumber, where, department, sales, func(index int) string {
+		if index < len(fields) {
+			return string(fields[index])
+		}
+		return ""
+	})
+}
+
+// blankFields reports whether every split field is empty or whitespace
+func blankFields(fields [][]byte) bool {
+	for _, field := range fields {
+		if len(bytes.TrimSpace(field)) > 0 {
+			return false
+		}
+	}
+	return true
+}
+
+// splitFields appends the first width fields of line, separated by comma, to fields
+func splitFields(fields [][]byte, line []byte, width int, comma byte) [][]byte {
+	for len(fields) < width {
+		i := bytes.IndexByte(line, comma)
+		if i < 0 {
+			return append(fields, line)
+		}
+		fields = append(fields, line[:i])
+		line = line[i+1:]
+	}
+	return fields
+}
+
+// parseFastInt parses an optionally signed decimal integer short enough not
+// to overflow. It reports false for anything else, including values
+// strconv.Atoi would accept, so callers can fall back to it.
+func parseFastInt(b []byte) (int, bool) {
+	negative := false
+	if len(b) > 0 && (b[0] == '-' || b[0] == '+') {
+		negative = b[0] == '-'
+		b = b[1:]
+	}
+	if len(b) == 0 || len(b) > maxFastDigits {
+		return 0, false
+	}
+	n := 0
+	for _, c := range b {
+		if c < '0' || c > '9' {
+			return 0, false
+		}
+		n = n*10 + int(c-'0')
+	}
+	if negative {
+		n = -n
+	}
+	return n, true
+}
@@ -0,0 +1,125 @@
+package services
+
+import (
+	"bytes"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/sirupsen/logrus"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestFastParserMatchesStandard(t *testing.T) {
+	logger := logrus.New()
+	logger.SetLevel(logrus.PanicLevel)
+	cs := NewCSVService(logger)
+
+	inputs := map[string]string{
+		"plain":        "Department Name,Number of Sales,Order ID\nToys,5,a\nGarden,3,b\nToys,2,c\n",
+		"crlf":         "Department Name,Number of Sales,Order ID\r\nToys,5,a\r\n\r\nGarden,3,b\r\nToys,2,a",
+		"trailing cr":  "Department Name,Number of Sales,Order ID\nToys,5,a\nGarden,3,b\r",
+		"blank lines":  "Department Name,Number of Sales,Order ID\n\nToys,5,a\n\n\nGarden,3,b\n",
+		"quoted later": "Department Name,Number of Sales,Order ID\nToys,5,a\n\"Home, Garden\",3,b\nToys,\"7\",\"multi\nline\"\nGarden,1,c\n",
+		"short rows":   "Department Name,Number of Sales,Order ID\nToys\nToys,5\n,4,a\nGarden,3,b,extra\n",
+		"bad sales":    "Department Name,Number of Sales,Order ID\nToys,abc,a\nToys,+5,b\nToys,-2,c\nToys, 4 ,d\nToys,99999999999999999999,e\nToys,1234567890123456789,f\n",
+		"footer":       "Department Name,Number of Sales,Order ID\nToys,5,a\nGarden,3,b\nTOTAL,8,\n",
+		"padded names": "Department Name,Number of Sales,Order ID\n Toys ,5,a\nToys,3,b\n",
+	}
+
+	for name, input := range inputs {
+		t.Run(name, func(t *testing.T) {
+			opts := ProcessOptions{DistinctColumns: []string{"Order ID"}, DistinctMode: DistinctModeExact}
+
+			var standardCleaned, fastCleaned bytes.Buffer
+			opts.Parser, opts.CleanedOutput = ParserStandard, &standardCleaned
+			standard, standardErr := cs.ProcessStream(strings.NewReader(input), opts)
+			opts.Parser, opts.CleanedOutput = ParserFast, &fastCleaned
+			fast, fastErr := cs.ProcessStream(strings.NewReader(input), opts)
+
+			require.Equal(t, standardErr, fastErr)
+			if standardErr != nil {
+				return
+			}
+			assert.Equal(t, standard.Summaries, fast.Summaries)
+			assert.Equal(t, standard.Rows, fast.Rows)
+			assert.Equal(t, standard.FooterTotal, fast.FooterTotal)
+			assert.Equal(t, standardCleaned.String(), fastCleaned.String())
+		})
+	}
+}
+
+func TestFastParserParallel(t *testing.T) {
+	logger := logrus.New()
+	logger.SetLevel(logrus.PanicLevel)
+	cs := NewCSVService(logger)
+	cs.SetStrategyThresholds(StrategyThresholds{ParallelMinBytes: 1, Workers: 4})
+
+	var buf strings.Builder
+	buf.WriteString("Department Name,Notes,Number of Sales\n")
+	for i := 0; i < 2000; i++ {
+		if i%97 == 0 {
+			fmt.Fprintf(&buf, "Dept %d,\"a, \"\"b\"\"\nc\",%d\n", i%11, i)
+		} else {
+			fmt.Fprintf(&buf, "Dept %d,plain,%d\n", i%11, i)
+		}
+	}
+	path := filepath.Join(t.TempDir(), "sales.csv")
+	require.NoError(t, os.WriteFile(path, []byte(buf.String()), 0644))
+
+	standard, err := cs.Process(path, ProcessOptions{Strategy: StrategyParallel, Parser: ParserStandard})
+	require.NoError(t, err)
+	fast, err := cs.Process(path, ProcessOptions{Strategy: StrategyParallel, Parser: ParserFast})
+	require.NoError(t, err)
+	assert.Equal(t, standard.Summaries, fast.Summaries)
+	assert.Equal(t, standard.Rows, fast.Rows)
+}
+
+func TestSetParser(t *testing.T) {
+	cs := NewCSVService(logrus.New())
+	assert.NoError(t, cs.SetParser(ParserFast))
+	assert.Equal(t, ParserFast, cs.parser)
+	assert.Error(t, cs.SetParser("simd"))
+	assert.Equal(t, ParserFast, cs.parser)
+}
+
+func TestParseFastInt(t *testing.T) {
+	for input, want := range map[string]int{"0": 0, "42": 42, "+7": 7, "-13": -13, "123456789012345678": 123456789012345678} {
+		got, ok := parseFastInt([]byte(input))
+		assert.True(t, ok, input)
+		assert.Equal(t, want, got, input)
+	}
+	for _, input := range []string{"", "-", "1.5", "1e3", "1,000", "1234567890123456789"} {
+		_, ok := parseFastInt([]byte(input))
+		assert.False(t, ok, input)
+	}
+}
+
+// benchmarkParser aggregates a wide generated file with the given parser
+func benchmarkParser(b *testing.B, parser string) {
+	var buf bytes.Buffer
+	buf.WriteString("Order ID,Date,Department Name,Region,Store,Channel,SKU,Description,Number of Sales,Notes\n")
+	for i := 0; i < 100000; i++ {
+		fmt.Fprintf(&buf, "ORD-%07d,2024-01-%02d,Department %d,Region %d,Store %d,online,SKU-%05d,Product description %d,%d,no notes\n",
+			i, i%28+1, i%50, i%7, i%120, i%9973, i%311, i%1000)
+	}
+	data := buf.Bytes()
+
+	logger := logrus.New()
+	logger.SetLevel(logrus.ErrorLevel)
+	cs := NewCSVService(logger)
+	b.SetBytes(int64(len(data)))
+	b.ReportAllocs()
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		if _, err := cs.ProcessStream(bytes.NewReader(data), ProcessOptions{Parser: parser}); err != nil {
+			b.Fatal(err)
+		}
+	}
+}
+
+func BenchmarkConsumeStandard(b *testing.B) { benchmarkParser(b, ParserStandard) }
+func BenchmarkConsumeFast(b *testing.B)     { benchmarkParser(b, ParserFast) }
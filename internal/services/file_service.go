@@ -1,39 +1,153 @@
 package services
 
 import (
+	"context"
+	"errors"
 	"fmt"
 	"io"
 	"mime/multipart"
 	"os"
 	"path/filepath"
+	"sort"
+	"strconv"
 	"strings"
+	"time"
 
-	"github.com/google/uuid"
+	"github.com/mussietl/csv-sales-api/internal/models"
 	"github.com/sirupsen/logrus"
 )
 
-// FileService handles file operations
+// ErrUploadNotFound is returned when an upload ID has no stored file
+var ErrUploadNotFound = errors.New("upload not found")
+
+// ErrResultNotFound is returned when a result ID has no stored result file
+var ErrResultNotFound = errors.New("result not found")
+
+// DefaultSignedURLExpiry is how long download URLs of remote storage stay valid
+const DefaultSignedURLExpiry = time.Hour
+
+// FileService handles file operations. Files are written to the uploads
+// directory, which serves as a working copy, and saved to the storage
+// backend, from which missing working copies are restored.
 type FileService struct {
 	uploadsDir string
-	logger     *logrus.Logger
+	// root is the shared uploads directory; tenants other than the default
+	// one keep their files in directories below it
+	root string
+	// tenant is the tenant the service is scoped to, or empty for the files
+	// of every tenant
+	tenant    string
+	storage   Storage
+	ids       IDGenerator
+	urlExpiry time.Duration
+	archiver  *Archiver
+	// encryptor, when set, encrypts files before they are stored
+	encryptor *Encryptor
+	// scanner, when set, must pass uploads held in quarantineDir before
+	// they reach the uploads directory
+	scanner       Scanner
+	quarantineDir string
+	// sessions, when set, assembles resumable uploads
+	sessions *uploadSessions
+	logger   *logrus.Logger
 }
 
-// NewFileService creates a new FileService instance
+// NewFileService creates a new FileService instance that stores files in
+// the uploads directory itself
 func NewFileService(uploadsDir string, logger *logrus.Logger) *FileService {
 	return &FileService{
 		uploadsDir: uploadsDir,
+		root:       uploadsDir,
+		storage:    NewLocalStorage(uploadsDir),
+		ids:        uuidIDs{},
+		urlExpiry:  DefaultSignedURLExpiry,
 		logger:     logger,
 	}
 }
 
-// SaveUploadedFile saves an uploaded file to the uploads directory
-func (fs *FileService) SaveUploadedFile(file *multipart.FileHeader) (string, error) {
-	// Generate unique filename
-	fileExt := filepath.Ext(file.Filename)
-	uniqueID := uuid.New().String()
-	filename := fmt.Sprintf("upload_%s%s", uniqueID, fileExt)
-	filePath := filepath.Join(fs.uploadsDir, filename)
+// WithEncryptor returns a FileService sharing the directory and storage
+// backend whose Persist encrypts each file in place, keeping its name, so
+// no plaintext copy is stored
+func (fs *FileService) WithEncryptor(encryptor *Encryptor) *FileService {
+	encrypted := *fs
+	encrypted.encryptor = encryptor
+	return &encrypted
+}
+
+// SetStorage replaces the storage backend; download URLs of its files are
+// valid for urlExpiry
+func (fs *FileService) SetStorage(storage Storage, urlExpiry time.Duration) {
+	fs.storage = storage
+	if urlExpiry > 0 {
+		fs.urlExpiry = urlExpiry
+	}
+}
+
+// SetArchiver saves uploads to the storage backend in the background with
+// archiver instead of before SaveUpload returns. Result and output files are
+// still saved straight away, since their download URLs are handed out.
+func (fs *FileService) SetArchiver(archiver *Archiver) {
+	fs.archiver = archiver
+}
+
+// SetIDGenerator replaces the generator of upload, result and output file IDs
+func (fs *FileService) SetIDGenerator(ids IDGenerator) {
+	fs.ids = ids
+}
+
+// newID returns a new file ID, logging failures
+func (fs *FileService) newID() (string, error) {
+	id, err := fs.ids.NewID()
+	if err != nil {
+		fs.logger.Errorf("Failed to generate file ID: %v", err)
+		return "", err
+	}
+	return id, nil
+}
+
+// Persist saves a file written to the uploads directory to the storage
+// backend, encrypting it first when the service has an encryptor
+func (fs *FileService) Persist(filePath string) error {
+	if fs.encryptor != nil {
+		if err := fs.encryptFile(filePath); err != nil {
+			return err
+		}
+	}
+	file, err := os.Open(filePath)
+	if err != nil {
+		return fmt.Errorf("failed to open %s: %w", filePath, err)
+	}
+	defer file.Close()
+	key := fs.storageKey(filePath)
+	if err := fs.storage.Save(key, file); err != nil {
+		return fmt.Errorf("failed to store %s: %w", key, err)
+	}
+	return nil
+}
+
+// restore copies a file missing from the uploads directory back from the
+// storage backend, returning false if the backend doesn't have it either
+func (fs *FileService) restore(filePath string) (bool, error) {
+	key := fs.storageKey(filePath)
+	src, err := fs.storage.Open(key)
+	if errors.Is(err, ErrObjectNotFound) {
+		return false, nil
+	}
+	if err != nil {
+		return false, err
+	}
+	defer src.Close()
 
+	if err := NewLocalStorage(fs.root).Save(key, src); err != nil {
+		return false, err
+	}
+	fs.logger.Infof("Restored %s from storage", key)
+	return true, nil
+}
+
+// SaveUploadedFile saves an uploaded file to the uploads directory. The copy
+// is abandoned with ErrUploadInterrupted once ctx is done.
+func (fs *FileService) SaveUploadedFile(ctx context.Context, file *multipart.FileHeader) (string, error) {
 	// Open uploaded file
 	src, err := file.Open()
 	if err != nil {
@@ -41,20 +155,77 @@ func (fs *FileService) SaveUploadedFile(file *multipart.FileHeader) (string, err
 		return "", fmt.Errorf("failed to open uploaded file: %w", err)
 	}
 	defer src.Close()
+	return fs.SaveUpload(ctx, file.Filename, src)
+}
+
+// SaveUpload saves the contents of an upload named originalName to the
+// uploads directory, keeping its extension. With a scanner set the upload is
+// written to quarantine first and fails with ErrUploadInfected or
+// ErrScanFailed unless the scanner passes it.
+func (fs *FileService) SaveUpload(ctx context.Context, originalName string, src io.Reader) (string, error) {
+	filePath, err := fs.newUploadPath(originalName)
+	if err != nil {
+		return "", err
+	}
+	writePath := filePath
+	if fs.scanner != nil {
+		writePath = filepath.Join(fs.quarantineDir, filepath.Base(filePath))
+	}
 
 	// Create destination file
-	dst, err := os.Create(filePath)
+	dst, err := os.Create(writePath)
 	if err != nil {
 		fs.logger.Errorf("Failed to create destination file: %v", err)
 		return "", fmt.Errorf("failed to create destination file: %w", err)
 	}
 	defer dst.Close()
 
-	// Copy file contents
-	if _, err := io.Copy(dst, src); err != nil {
+	// Copy file contents, stopping as soon as the client disconnects; a
+	// partial upload is never left behind
+	if _, err := io.Copy(dst, contextReader{ctx: ctx, r: src}); err != nil {
 		fs.logger.Errorf("Failed to copy file contents: %v", err)
+		dst.Close()
+		os.Remove(writePath)
 		return "", fmt.Errorf("failed to copy file contents: %w", err)
 	}
+	if fs.scanner != nil {
+		if err := dst.Close(); err != nil {
+			os.Remove(writePath)
+			return "", fmt.Errorf("failed to write upload: %w", err)
+		}
+		if err := fs.release(ctx, writePath, filePath); err != nil {
+			return "", err
+		}
+	}
+	return fs.storeUpload(filePath)
+}
+
+// newUploadPath returns the path of a new upload in the uploads directory,
+// keeping the extension of originalName
+func (fs *FileService) newUploadPath(originalName string) (string, error) {
+	uniqueID, err := fs.newID()
+	if err != nil {
+		return "", err
+	}
+	return filepath.Join(fs.uploadsDir, fmt.Sprintf("upload_%s%s", uniqueID, filepath.Ext(originalName))), nil
+}
+
+// storeUpload saves an upload that has reached the uploads directory to the
+// storage backend, or hands it to the archiver, removing it on failure
+func (fs *FileService) storeUpload(filePath string) (string, error) {
+	filename := filepath.Base(filePath)
+	if fs.archiver != nil {
+		if err := fs.archiver.Archive(filePath); err == nil {
+			fs.logger.Infof("File saved successfully, archiving in the background: %s", filePath)
+			return filePath, nil
+		}
+		fs.logger.Warnf("Archive queue is full, storing %s before processing", filename)
+	}
+	if err := fs.Persist(filePath); err != nil {
+		fs.logger.Errorf("Failed to store uploaded file: %v", err)
+		os.Remove(filePath)
+		return "", err
+	}
 
 	fs.logger.Infof("File saved successfully: %s", filePath)
 	return filePath, nil
@@ -62,51 +233,572 @@ func (fs *FileService) SaveUploadedFile(file *multipart.FileHeader) (string, err
 
 // SaveResultFile saves the aggregated results to a CSV file
 func (fs *FileService) SaveResultFile(departmentSummaries []DepartmentSummary) (string, error) {
-	// Generate unique filename for result
-	uniqueID := uuid.New().String()
-	filename := fmt.Sprintf("result_%s.csv", uniqueID)
-	filePath := filepath.Join(fs.uploadsDir, filename)
+	return fs.SaveFormattedResultFile(departmentSummaries, DefaultNumberFormat, MetricDefault, nil, OutputFormatCSV)
+}
 
-	// Create result file
-	file, err := os.Create(filePath)
+// ResultMetadata describes where the totals of a result file came from
+type ResultMetadata struct {
+	// UploadID is the upload the totals were computed from
+	UploadID string
+	// ProcessedAt is when the upload was processed
+	ProcessedAt time.Time
+}
+
+// metadataHeaders are the columns written for ResultMetadata
+var metadataHeaders = []string{"Source Upload ID", "Processed At", "Row Count"}
+
+// SaveFormattedResultFile saves the aggregated results to a result file in
+// the given output format, writing the columns of the given metric and
+// totals with the given precision and rounding. With metadata, every row
+// also carries the source upload ID, the processing time and the number of
+// rows behind the total, so the file describes itself once loaded elsewhere.
+func (fs *FileService) SaveFormattedResultFile(departmentSummaries []DepartmentSummary, format NumberFormat, metric string, metadata *ResultMetadata, output string) (string, error) {
+	return fs.saveResultTable(departmentTable(departmentSummaries, format, metric, metadata), output)
+}
+
+// departmentTable lays out department totals as a result table, with one
+// extra column per distinct count, sales statistic, joined dimension and
+// currency
+func departmentTable(departmentSummaries []DepartmentSummary, format NumberFormat, metric string, metadata *ResultMetadata) *ResultTable {
+	table := &ResultTable{Columns: []ResultColumn{{Name: "Department Name"}}}
+	for _, header := range metricHeaders(metric) {
+		table.Columns = append(table.Columns, ResultColumn{Name: header, Numeric: true})
+	}
+	if len(departmentSummaries) > 0 {
+		for _, dc := range departmentSummaries[0].DistinctCounts {
+			table.Columns = append(table.Columns, ResultColumn{Name: "Distinct " + dc.Column, Numeric: true})
+		}
+		if departmentSummaries[0].Stats != nil {
+			for _, header := range statsHeaders {
+				table.Columns = append(table.Columns, ResultColumn{Name: header, Numeric: true})
+			}
+		}
+		for _, dim := range departmentSummaries[0].Dimensions {
+			table.Columns = append(table.Columns, ResultColumn{Name: dim.Column})
+		}
+	}
+	currencies := summaryCurrencies(departmentSummaries)
+	for _, currency := range currencies {
+		table.Columns = append(table.Columns, ResultColumn{Name: "Sales " + currency, Numeric: true})
+	}
+	converted := len(departmentSummaries) > 0 && departmentSummaries[0].Currency != ""
+	if converted {
+		table.Columns = append(table.Columns, ResultColumn{Name: "Currency"})
+	}
+	if metadata != nil {
+		table.Columns = append(table.Columns,
+			ResultColumn{Name: metadataHeaders[0]}, ResultColumn{Name: metadataHeaders[1]}, ResultColumn{Name: metadataHeaders[2], Numeric: true})
+	}
+
+	for _, summary := range departmentSummaries {
+		row := append([]string{summary.Department}, metricValues(summary, metric, format)...)
+		for _, dc := range summary.DistinctCounts {
+			row = append(row, strconv.FormatInt(dc.Count, 10))
+		}
+		if stats := summary.Stats; stats != nil {
+			for _, value := range []models.Amount{stats.Avg, stats.Median, stats.P90, stats.P95, stats.Min, stats.Max, stats.StdDev} {
+				row = append(row, format.FormatAmount(value))
+			}
+		}
+		for _, dim := range summary.Dimensions {
+			row = append(row, dim.Value)
+		}
+		for _, currency := range currencies {
+			var sales models.Amount
+			for _, total := range summary.Currencies {
+				if total.Currency == currency {
+					sales = total.TotalSales
+				}
+			}
+			row = append(row, format.FormatAmount(sales))
+		}
+		if converted {
+			row = append(row, summary.Currency)
+		}
+		if metadata != nil {
+			row = append(row, metadata.UploadID, metadata.ProcessedAt.UTC().Format(time.RFC3339), strconv.Itoa(summary.SalesCount))
+		}
+		table.Rows = append(table.Rows, row)
+	}
+	return table
+}
+
+// statsHeaders are the result columns of models.SalesStats, in field order
+var statsHeaders = []string{"Average Sale", "Median Sale", "P90 Sale", "P95 Sale", "Min Sale", "Max Sale", "Sale StdDev"}
+
+// summaryCurrencies returns the currencies any department has sales in, in order
+func summaryCurrencies(departmentSummaries []DepartmentSummary) []string {
+	seen := make(map[string]bool)
+	var currencies []string
+	for _, summary := range departmentSummaries {
+		for _, total := range summary.Currencies {
+			if !seen[total.Currency] {
+				seen[total.Currency] = true
+				currencies = append(currencies, total.Currency)
+			}
+		}
+	}
+	sort.Strings(currencies)
+	return currencies
+}
+
+// SaveAggregationResultFile saves a configurable aggregation to a result
+// file in the given output format, one column per group-by column followed
+// by the aggregated value
+func (fs *FileService) SaveAggregationResultFile(result *models.AggregationResult, format NumberFormat, output string) (string, error) {
+	table, err := aggregationTable(result, format)
+	if err != nil {
+		return "", err
+	}
+	return fs.saveResultTable(table, output)
+}
+
+// aggregationTable lays out a configurable aggregation as a result table
+func aggregationTable(result *models.AggregationResult, format NumberFormat) (*ResultTable, error) {
+	table := &ResultTable{}
+	for _, column := range result.GroupBy {
+		table.Columns = append(table.Columns, ResultColumn{Name: column})
+	}
+	table.Columns = append(table.Columns, ResultColumn{Name: fmt.Sprintf("%s(%s)", result.Function, result.Column), Numeric: true})
+
+	for _, group := range result.Groups {
+		value, err := format.FormatDecimal(group.Exact)
+		if err != nil {
+			return nil, err
+		}
+		table.Rows = append(table.Rows, append(append([]string{}, group.Key...), value))
+	}
+	return table, nil
+}
+
+// WriteResult writes department totals to w in the given output format, as
+// SaveFormattedResultFile would save them, for results that aren't stored
+func WriteResult(w io.Writer, departmentSummaries []DepartmentSummary, format NumberFormat, metric, output string) error {
+	writer, err := NewResultWriter(output)
 	if err != nil {
-		fs.logger.Errorf("Failed to create result file: %v", err)
-		return "", fmt.Errorf("failed to create result file: %w", err)
+		return err
+	}
+	return writer.Write(w, departmentTable(departmentSummaries, format, metric, nil))
+}
+
+// WriteAggregationResult writes a configurable aggregation to w in the given
+// output format, as SaveAggregationResultFile would save it
+func WriteAggregationResult(w io.Writer, result *models.AggregationResult, format NumberFormat, output string) error {
+	writer, err := NewResultWriter(output)
+	if err != nil {
+		return err
+	}
+	table, err := aggregationTable(result, format)
+	if err != nil {
+		return err
+	}
+	return writer.Write(w, table)
+}
+
+// SaveHeatmapFile saves a sales heatmap to heatmap_<uuid>.csv: one row per
+// department and weekday, with a column per hour of day from 00 to 23
+func (fs *FileService) SaveHeatmapFile(heatmap *models.SalesHeatmap, format NumberFormat) (string, error) {
+	file, err := fs.CreateOutputFile("heatmap", "csv")
+	if err != nil {
+		return "", err
 	}
 	defer file.Close()
 
-	// Write CSV header
-	if _, err := file.WriteString("Department Name,Total Number of Sales\n"); err != nil {
-		fs.logger.Errorf("Failed to write CSV header: %v", err)
+	header := []string{"Department Name", "Weekday"}
+	for hour := 0; hour < 24; hour++ {
+		header = append(header, fmt.Sprintf("%02d", hour))
+	}
+	if _, err := file.WriteString(strings.Join(header, ",") + "\n"); err != nil {
+		os.Remove(file.Name())
 		return "", fmt.Errorf("failed to write CSV header: %w", err)
 	}
 
-	// Write data rows
-	for _, summary := range departmentSummaries {
-		line := fmt.Sprintf("%s,%d\n", summary.Department, summary.TotalSales)
-		if _, err := file.WriteString(line); err != nil {
-			fs.logger.Errorf("Failed to write CSV data: %v", err)
+	for _, department := range heatmap.Departments {
+		for day, hours := range department.Sales {
+			fields := []string{quoteCSVField(department.Department), heatmap.Weekdays[day]}
+			for _, sales := range hours {
+				fields = append(fields, format.FormatAmount(sales))
+			}
+			if _, err := file.WriteString(strings.Join(fields, ",") + "\n"); err != nil {
+				os.Remove(file.Name())
+				return "", fmt.Errorf("failed to write CSV data: %w", err)
+			}
+		}
+	}
+
+	if err := fs.Persist(file.Name()); err != nil {
+		fs.logger.Errorf("Failed to store heatmap file: %v", err)
+		os.Remove(file.Name())
+		return "", err
+	}
+	fs.logger.Infof("Heatmap file saved successfully: %s", file.Name())
+	return file.Name(), nil
+}
+
+// SaveTimeSeriesFile saves a sales time series pivoted to
+// timeseries_<uuid>.csv: one row per department, with a column per bucket
+func (fs *FileService) SaveTimeSeriesFile(series *models.SalesTimeSeries, format NumberFormat) (string, error) {
+	file, err := fs.CreateOutputFile("timeseries", "csv")
+	if err != nil {
+		return "", err
+	}
+	defer file.Close()
+
+	header := append([]string{"Department Name"}, series.Buckets...)
+	if _, err := file.WriteString(strings.Join(header, ",") + "\n"); err != nil {
+		os.Remove(file.Name())
+		return "", fmt.Errorf("failed to write CSV header: %w", err)
+	}
+
+	for _, department := range series.Departments {
+		fields := []string{quoteCSVField(department.Department)}
+		for _, bucket := range department.Buckets {
+			fields = append(fields, format.FormatAmount(bucket.Sales))
+		}
+		if _, err := file.WriteString(strings.Join(fields, ",") + "\n"); err != nil {
+			os.Remove(file.Name())
 			return "", fmt.Errorf("failed to write CSV data: %w", err)
 		}
 	}
 
-	fs.logger.Infof("Result file saved successfully: %s", filePath)
-	return filePath, nil
+	if err := fs.Persist(file.Name()); err != nil {
+		fs.logger.Errorf("Failed to store time series file: %v", err)
+		os.Remove(file.Name())
+		return "", err
+	}
+	fs.logger.Infof("Time series file saved successfully: %s", file.Name())
+	return file.Name(), nil
+}
+
+// CreateOutputFile creates a uniquely named output file, e.g. cleaned_<uuid>.csv
+func (fs *FileService) CreateOutputFile(prefix, ext string) (*os.File, error) {
+	uniqueID, err := fs.newID()
+	if err != nil {
+		return nil, err
+	}
+	filename := fmt.Sprintf("%s_%s.%s", prefix, uniqueID, ext)
+	filePath := filepath.Join(fs.uploadsDir, filename)
+
+	file, err := os.Create(filePath)
+	if err != nil {
+		fs.logger.Errorf("Failed to create %s file: %v", prefix, err)
+		return nil, fmt.Errorf("failed to create %s file: %w", prefix, err)
+	}
+	return file, nil
+}
+
+// resultFilePrefix starts the name of every result file. Result IDs are
+// mapped to file names only by resultFileName and resultIDOf, so clients
+// never depend on how results are laid out in storage.
+const resultFilePrefix = "result_"
+
+// resultFileName returns the name of a result's file with the extension of
+// its output format
+func resultFileName(resultID, ext string) string {
+	return resultFilePrefix + resultID + ext
+}
+
+// resultIDOf returns the result ID of a stored file's name, reporting
+// whether it is a result file
+func resultIDOf(name string) (string, bool) {
+	if !strings.HasPrefix(name, resultFilePrefix) {
+		return "", false
+	}
+	return strings.TrimPrefix(strings.TrimSuffix(name, filepath.Ext(name)), resultFilePrefix), true
+}
+
+// ResultID extracts the result ID from a result file's path
+func (fs *FileService) ResultID(filePath string) string {
+	id, _ := resultIDOf(filepath.Base(filePath))
+	return id
+}
+
+// resultExtensions are the extensions a result file can have, one per output format
+var resultExtensions = []string{".csv", ".json", ".ndjson", ".xlsx"}
+
+// ResultPath resolves a result ID to the path of its result file, restoring
+// it from the storage backend if needed
+func (fs *FileService) ResultPath(resultID string) (string, error) {
+	if !ValidID(resultID) {
+		return "", fmt.Errorf("%w: %s", ErrResultNotFound, resultID)
+	}
+
+	dirs := fs.dirs()
+	for _, dir := range dirs {
+		for _, ext := range resultExtensions {
+			filePath := filepath.Join(dir, resultFileName(resultID, ext))
+			if _, err := os.Stat(filePath); err == nil {
+				return filePath, nil
+			} else if !errors.Is(err, os.ErrNotExist) {
+				return "", fmt.Errorf("failed to look up result: %w", err)
+			}
+		}
+	}
+	for _, dir := range dirs {
+		for _, ext := range resultExtensions {
+			filePath := filepath.Join(dir, resultFileName(resultID, ext))
+			restored, err := fs.restore(filePath)
+			if err != nil {
+				return "", fmt.Errorf("failed to restore result: %w", err)
+			}
+			if restored {
+				return filePath, nil
+			}
+		}
+	}
+	return "", fmt.Errorf("%w: %s", ErrResultNotFound, resultID)
 }
 
-// GetDownloadURL generates a download URL for a file
+// UploadID extracts the upload ID from a stored upload's path
+func (fs *FileService) UploadID(filePath string) string {
+	name := filepath.Base(filePath)
+	name = strings.TrimSuffix(name, filepath.Ext(name))
+	return strings.TrimPrefix(name, "upload_")
+}
+
+// uploadExtensions are the extensions an accepted upload can have
+var uploadExtensions = []string{".csv", ".xlsx", ".gz", ".zip"}
+
+// UploadPath resolves an upload ID to the path of the stored upload,
+// restoring it from the storage backend if needed
+func (fs *FileService) UploadPath(uploadID string) (string, error) {
+	if !ValidID(uploadID) {
+		return "", fmt.Errorf("invalid upload id %q", uploadID)
+	}
+
+	dirs := fs.dirs()
+	for _, dir := range dirs {
+		matches, err := filepath.Glob(filepath.Join(dir, "upload_"+uploadID+".*"))
+		if err != nil {
+			return "", fmt.Errorf("failed to look up upload: %w", err)
+		}
+		if len(matches) > 0 {
+			return matches[0], nil
+		}
+	}
+	for _, dir := range dirs {
+		for _, ext := range uploadExtensions {
+			filePath := filepath.Join(dir, "upload_"+uploadID+ext)
+			restored, err := fs.restore(filePath)
+			if err != nil {
+				return "", fmt.Errorf("failed to restore upload: %w", err)
+			}
+			if restored {
+				return filePath, nil
+			}
+		}
+	}
+	return "", fmt.Errorf("%w: %s", ErrUploadNotFound, uploadID)
+}
+
+// DeleteUpload removes a stored upload
+func (fs *FileService) DeleteUpload(uploadID string) error {
+	path, err := fs.UploadPath(uploadID)
+	if err != nil {
+		return err
+	}
+	if err := fs.DeleteStoredFile(fs.storedName(path)); err != nil {
+		return err
+	}
+	fs.logger.Infof("Deleted upload %s", uploadID)
+	return nil
+}
+
+// DeleteResult removes a result file and returns the path it had
+func (fs *FileService) DeleteResult(resultID string) (string, error) {
+	path, err := fs.ResultPath(resultID)
+	if err != nil {
+		return "", err
+	}
+	if err := fs.DeleteStoredFile(fs.storedName(path)); err != nil {
+		return "", err
+	}
+	fs.logger.Infof("Deleted result %s", resultID)
+	return path, nil
+}
+
+// DeleteStoredFile removes a file of the uploads directory, locally and
+// from the storage backend. name is relative to the uploads directory, as
+// in StoredFile.
+func (fs *FileService) DeleteStoredFile(name string) error {
+	filePath := filepath.Join(fs.uploadsDir, name)
+	if err := os.Remove(filePath); err != nil && !errors.Is(err, os.ErrNotExist) {
+		return fmt.Errorf("failed to delete %s: %w", name, err)
+	}
+	if err := fs.storage.Delete(fs.storageKey(filePath)); err != nil {
+		return fmt.Errorf("failed to delete %s from storage: %w", name, err)
+	}
+	return nil
+}
+
+// StoredFile is a file in the uploads directory named <kind>_<id>.<ext>
+type StoredFile struct {
+	// Name is the file's path relative to the uploads directory
+	Name string
+	Path string
+	// Tenant owns the file; empty for the default tenant
+	Tenant string
+	// Kind is the filename prefix, such as upload, result or cleaned
+	Kind    string
+	ID      string
+	ModTime time.Time
+	Size    int64
+}
+
+// StoredFiles lists the files of the uploads directory that were created by
+// the service, including those of every tenant unless the service is scoped
+// to one; any other files are left out
+func (fs *FileService) StoredFiles() ([]StoredFile, error) {
+	var files []StoredFile
+	for _, dir := range fs.dirs() {
+		entries, err := os.ReadDir(dir)
+		if err != nil {
+			return nil, fmt.Errorf("failed to read uploads directory: %w", err)
+		}
+		for _, entry := range entries {
+			info, err := entry.Info()
+			if err != nil || !info.Mode().IsRegular() {
+				continue
+			}
+			kind, rest, ok := strings.Cut(entry.Name(), "_")
+			id := strings.TrimSuffix(rest, filepath.Ext(rest))
+			if !ok || !ValidID(id) {
+				continue
+			}
+			path := filepath.Join(dir, entry.Name())
+			files = append(files, StoredFile{
+				Name:    fs.storedName(path),
+				Path:    path,
+				Tenant:  fs.tenantOf(dir),
+				Kind:    kind,
+				ID:      id,
+				ModTime: info.ModTime(),
+				Size:    info.Size(),
+			})
+		}
+	}
+	return files, nil
+}
+
+// StoredUpload describes an upload kept in the uploads directory
+type StoredUpload struct {
+	ID         string
+	Path       string
+	UploadedAt time.Time
+}
+
+// ListUploads returns the stored uploads modified within [from, to], oldest
+// first. Zero times leave that end of the range open.
+func (fs *FileService) ListUploads(from, to time.Time) ([]StoredUpload, error) {
+	var matches []string
+	for _, dir := range fs.dirs() {
+		dirMatches, err := filepath.Glob(filepath.Join(dir, "upload_*"))
+		if err != nil {
+			return nil, fmt.Errorf("failed to list uploads: %w", err)
+		}
+		matches = append(matches, dirMatches...)
+	}
+
+	var uploads []StoredUpload
+	for _, path := range matches {
+		info, err := os.Stat(path)
+		if err != nil || !info.Mode().IsRegular() {
+			continue
+		}
+		modTime := info.ModTime()
+		if (!from.IsZero() && modTime.Before(from)) || (!to.IsZero() && modTime.After(to)) {
+			continue
+		}
+		uploads = append(uploads, StoredUpload{ID: fs.UploadID(path), Path: path, UploadedAt: modTime})
+	}
+
+	sort.Slice(uploads, func(i, j int) bool {
+		return uploads[i].UploadedAt.Before(uploads[j].UploadedAt)
+	})
+	return uploads, nil
+}
+
+// StorageUsage is the number and total size of the stored files of each kind
+type StorageUsage struct {
+	Files int64            `json:"files"`
+	Bytes int64            `json:"bytes"`
+	Kinds map[string]Usage `json:"kinds"`
+	// Tenants totals the files of each tenant other than the default one,
+	// when the usage covers every tenant
+	Tenants map[string]Usage `json:"tenants,omitempty"`
+}
+
+// Usage counts files and their total size
+type Usage struct {
+	Files int64 `json:"files"`
+	Bytes int64 `json:"bytes"`
+}
+
+// StorageUsage totals the files in the uploads directory by kind: the
+// filename prefix, such as upload, result or cleaned
+func (fs *FileService) StorageUsage() (StorageUsage, error) {
+	usage := StorageUsage{Kinds: make(map[string]Usage)}
+	for _, dir := range fs.dirs() {
+		entries, err := os.ReadDir(dir)
+		if err != nil {
+			return usage, fmt.Errorf("failed to read uploads directory: %w", err)
+		}
+		tenant := fs.tenantOf(dir)
+		for _, entry := range entries {
+			info, err := entry.Info()
+			if err != nil || !info.Mode().IsRegular() {
+				continue
+			}
+			kind, _, ok := strings.Cut(entry.Name(), "_")
+			if !ok {
+				kind = "other"
+			}
+			k := usage.Kinds[kind]
+			k.Files++
+			k.Bytes += info.Size()
+			usage.Kinds[kind] = k
+			usage.Files++
+			usage.Bytes += info.Size()
+			if tenant != "" && fs.tenant == "" {
+				if usage.Tenants == nil {
+					usage.Tenants = make(map[string]Usage)
+				}
+				t := usage.Tenants[tenant]
+				t.Files++
+				t.Bytes += info.Size()
+				usage.Tenants[tenant] = t
+			}
+		}
+	}
+	return usage, nil
+}
+
+// GetDownloadURL generates a download URL for a file: a download route of
+// the API for local storage, or a signed URL of the storage backend
 func (fs *FileService) GetDownloadURL(filePath string) string {
-	// Extract just the filename from the full path
-	filename := filepath.Base(filePath)
-	return fmt.Sprintf("/public/uploads/%s", filename)
+	key := fs.storageKey(filePath)
+	url, err := fs.storage.SignedURL(key, fs.urlExpiry)
+	if err != nil {
+		fs.logger.Warnf("Failed to sign download URL of %s, linking the local copy: %v", key, err)
+		return LocalDownloadURL(key)
+	}
+	return url
+}
+
+// ValidateFilename checks that a file name has an extension that can be processed
+func ValidateFilename(filename string) error {
+	ext := strings.ToLower(filepath.Ext(filename))
+	if ext != ".csv" && ext != ".xlsx" && ext != ".gz" && ext != ".zip" {
+		return fmt.Errorf("only CSV, XLSX, gzip-compressed CSV and ZIP files are allowed, got: %s", ext)
+	}
+	return nil
 }
 
 // ValidateFile validates the uploaded file
 func (fs *FileService) ValidateFile(file *multipart.FileHeader) error {
 	// Check file extension
-	ext := strings.ToLower(filepath.Ext(file.Filename))
-	if ext != ".csv" {
-		return fmt.Errorf("only CSV files are allowed, got: %s", ext)
+	if err := ValidateFilename(file.Filename); err != nil {
+		return err
 	}
 
 	// Check MIME type
@@ -123,6 +815,35 @@ func (fs *FileService) ValidateFile(file *multipart.FileHeader) error {
 
 // DepartmentSummary represents aggregated sales data for a department
 type DepartmentSummary struct {
-	Department string `json:"department" csv:"Department Name"`
-	TotalSales int    `json:"total_sales" csv:"Total Number of Sales"`
+	Department string        `json:"department" csv:"Department Name"`
+	TotalSales models.Amount `json:"total_sales" csv:"Total Number of Sales"`
+	// SalesCount is the number of sales rows aggregated into TotalSales
+	SalesCount int `json:"sales_count" csv:"-"`
+	// DistinctCounts holds the requested distinct-value counts, in request order
+	DistinctCounts []DistinctCount `json:"distinct_counts,omitempty" csv:"-"`
+	// Dimensions holds department attributes joined from the department table
+	Dimensions []DimensionValue `json:"dimensions,omitempty" csv:"-"`
+	// Trend holds the department's totals over the feed's recent uploads, oldest first
+	Trend []models.Amount `json:"trend,omitempty" csv:"-"`
+	// Currencies holds the department's sales per currency, when broken down
+	Currencies []CurrencyTotal `json:"currencies,omitempty" csv:"-"`
+	// Currency is the currency TotalSales was converted to, if any
+	Currency string `json:"currency,omitempty" csv:"-"`
+	// Stats describes the department's sale amounts, when requested
+	Stats *models.SalesStats `json:"stats,omitempty" csv:"-"`
+}
+
+// quoteCSVField quotes a free-text field if it contains a delimiter, quote or line break
+func quoteCSVField(value string) string {
+	if !strings.ContainsAny(value, ",\"\r\n") {
+		return value
+	}
+	return `"` + strings.ReplaceAll(value, `"`, `""`) + `"`
+}
+
+// DistinctCount is the number of distinct values seen in a column for a department
+type DistinctCount struct {
+	Column      string `json:"column"`
+	Count       int64  `json:"count"`
+	Approximate bool   `json:"approximate"`
 }
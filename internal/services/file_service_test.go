@@ -1,10 +1,14 @@
 package services
 
 import (
+	"context"
+	"github.com/mussietl/csv-sales-api/internal/models"
 	"mime/multipart"
 	"os"
 	"path/filepath"
+	"strings"
 	"testing"
+	"time"
 
 	"github.com/sirupsen/logrus"
 	"github.com/stretchr/testify/assert"
@@ -53,6 +57,24 @@ func TestFileServiceValidateFile(t *testing.T) {
 			},
 			expectError: true,
 		},
+		{
+			name: "gzip-compressed CSV",
+			fileHeader: &multipart.FileHeader{
+				Filename: "sales.csv.gz",
+				Size:     1000,
+				Header:   map[string][]string{"Content-Type": {"application/gzip"}},
+			},
+			expectError: false,
+		},
+		{
+			name: "ZIP archive",
+			fileHeader: &multipart.FileHeader{
+				Filename: "stores.zip",
+				Size:     1000,
+				Header:   map[string][]string{"Content-Type": {"application/zip"}},
+			},
+			expectError: false,
+		},
 		{
 			name: "valid CSV with different MIME type",
 			fileHeader: &multipart.FileHeader{
@@ -88,9 +110,9 @@ func TestFileServiceSaveResultFile(t *testing.T) {
 
 	// Test data
 	summaries := []DepartmentSummary{
-		{Department: "Electronics", TotalSales: 2500},
-		{Department: "Clothing", TotalSales: 700},
-		{Department: "Books", TotalSales: 300},
+		{Department: "Electronics", TotalSales: models.WholeAmount(2500)},
+		{Department: "Clothing", TotalSales: models.WholeAmount(700)},
+		{Department: "Books", TotalSales: models.WholeAmount(300)},
 	}
 
 	// Save result file
@@ -109,6 +131,51 @@ func TestFileServiceSaveResultFile(t *testing.T) {
 	assert.Equal(t, expectedContent, string(content))
 }
 
+func TestFileServiceSaveResultFileMetrics(t *testing.T) {
+	logger := logrus.New()
+	logger.SetLevel(logrus.ErrorLevel)
+	fileService := NewFileService(t.TempDir(), logger)
+	summaries := []DepartmentSummary{{Department: "Toys", TotalSales: models.WholeAmount(2500), SalesCount: 3}}
+
+	expected := map[string]string{
+		MetricSum:   "Department Name,Total Sales Amount\nToys,2500.0\n",
+		MetricCount: "Department Name,Sales Count\nToys,3\n",
+		MetricBoth:  "Department Name,Sales Count,Total Sales Amount\nToys,3,2500.0\n",
+	}
+	for metric, want := range expected {
+		path, err := fileService.SaveFormattedResultFile(summaries, NumberFormat{Precision: 1, Rounding: RoundHalfUp}, metric, nil, OutputFormatCSV)
+		require.NoError(t, err)
+		content, err := os.ReadFile(path)
+		require.NoError(t, err)
+		assert.Equal(t, want, string(content), metric)
+	}
+
+	assert.NoError(t, ValidateMetric(MetricBoth))
+	assert.Error(t, ValidateMetric("average"))
+}
+
+func TestFileServiceSaveResultFileMetadata(t *testing.T) {
+	logger := logrus.New()
+	logger.SetLevel(logrus.ErrorLevel)
+	fileService := NewFileService(t.TempDir(), logger)
+	summaries := []DepartmentSummary{
+		{Department: "Toys", TotalSales: models.WholeAmount(2500), SalesCount: 3},
+		{Department: "Books", TotalSales: models.WholeAmount(300), SalesCount: 1},
+	}
+	metadata := &ResultMetadata{
+		UploadID:    "0b8f3f7e-4a59-4d43-9a5b-3c4f1e2d9a10",
+		ProcessedAt: time.Date(2024, 3, 1, 14, 30, 0, 0, time.FixedZone("CET", 3600)),
+	}
+
+	path, err := fileService.SaveFormattedResultFile(summaries, DefaultNumberFormat, MetricDefault, metadata, OutputFormatCSV)
+	require.NoError(t, err)
+	content, err := os.ReadFile(path)
+	require.NoError(t, err)
+	assert.Equal(t, "Department Name,Total Number of Sales,Source Upload ID,Processed At,Row Count\n"+
+		"Toys,2500,0b8f3f7e-4a59-4d43-9a5b-3c4f1e2d9a10,2024-03-01T13:30:00Z,3\n"+
+		"Books,300,0b8f3f7e-4a59-4d43-9a5b-3c4f1e2d9a10,2024-03-01T13:30:00Z,1\n", string(content))
+}
+
 func TestFileServiceGetDownloadURL(t *testing.T) {
 	tempDir, err := os.MkdirTemp("", "test_uploads")
 	require.NoError(t, err)
@@ -120,12 +187,16 @@ func TestFileServiceGetDownloadURL(t *testing.T) {
 	// Test with full path
 	fullPath := filepath.Join(tempDir, "test_file.csv")
 	url := fileService.GetDownloadURL(fullPath)
-	expectedURL := "/public/uploads/test_file.csv"
+	expectedURL := "/api/v1/files/test_file.csv"
 	assert.Equal(t, expectedURL, url)
 
 	// Test with just filename
 	url = fileService.GetDownloadURL("test_file.csv")
 	assert.Equal(t, expectedURL, url)
+
+	// Results are downloaded through the results API by their ID
+	url = fileService.GetDownloadURL(filepath.Join(tempDir, "result_1234.json"))
+	assert.Equal(t, "/api/v1/results/1234/download", url)
 }
 
 func TestFileServiceSaveUploadedFile(t *testing.T) {
@@ -147,3 +218,107 @@ func TestFileServiceSaveUploadedFile(t *testing.T) {
 	assert.Equal(t, tempDir, fileService.uploadsDir)
 	assert.NotNil(t, fileService.logger)
 }
+
+func TestFileServiceSaveUpload(t *testing.T) {
+	logger := logrus.New()
+	logger.SetLevel(logrus.ErrorLevel)
+	fileService := NewFileService(t.TempDir(), logger)
+
+	path, err := fileService.SaveUpload(context.Background(), "sales.csv", strings.NewReader("Department Name,Total Sales\nBooks,300\n"))
+	require.NoError(t, err)
+	assert.Equal(t, ".csv", filepath.Ext(path))
+	content, err := os.ReadFile(path)
+	require.NoError(t, err)
+	assert.Equal(t, "Department Name,Total Sales\nBooks,300\n", string(content))
+
+	resolved, err := fileService.UploadPath(fileService.UploadID(path))
+	require.NoError(t, err)
+	assert.Equal(t, path, resolved)
+}
+
+func TestFileServiceUploadLookup(t *testing.T) {
+	tempDir, err := os.MkdirTemp("", "test_uploads")
+	require.NoError(t, err)
+	defer os.RemoveAll(tempDir)
+
+	logger := logrus.New()
+	logger.SetLevel(logrus.ErrorLevel)
+	fileService := NewFileService(tempDir, logger)
+
+	id := "0b8f3f7e-4a59-4d43-9a5b-3c4f1e2d9a10"
+	path := filepath.Join(tempDir, "upload_"+id+".csv")
+	require.NoError(t, os.WriteFile(path, []byte("department,sales\nA,1\n"), 0644))
+	old := time.Now().Add(-48 * time.Hour)
+	require.NoError(t, os.Chtimes(path, old, old))
+	require.NoError(t, os.WriteFile(filepath.Join(tempDir, "result_x.csv"), []byte("x"), 0644))
+
+	assert.Equal(t, id, fileService.UploadID(path))
+
+	resolved, err := fileService.UploadPath(id)
+	require.NoError(t, err)
+	assert.Equal(t, path, resolved)
+
+	_, err = fileService.UploadPath("../result_x")
+	assert.Error(t, err)
+	_, err = fileService.UploadPath("11111111-2222-3333-4444-555555555555")
+	assert.ErrorIs(t, err, ErrUploadNotFound)
+
+	uploads, err := fileService.ListUploads(time.Time{}, time.Time{})
+	require.NoError(t, err)
+	require.Len(t, uploads, 1)
+	assert.Equal(t, id, uploads[0].ID)
+
+	uploads, err = fileService.ListUploads(time.Now().Add(-time.Hour), time.Time{})
+	require.NoError(t, err)
+	assert.Empty(t, uploads)
+
+	require.NoError(t, fileService.DeleteUpload(id))
+	assert.NoFileExists(t, path)
+	assert.ErrorIs(t, fileService.DeleteUpload(id), ErrUploadNotFound)
+}
+
+func TestFileServiceResultLookup(t *testing.T) {
+	tempDir, err := os.MkdirTemp("", "test_uploads")
+	require.NoError(t, err)
+	defer os.RemoveAll(tempDir)
+
+	logger := logrus.New()
+	logger.SetLevel(logrus.ErrorLevel)
+	fileService := NewFileService(tempDir, logger)
+
+	path, err := fileService.SaveResultFile([]DepartmentSummary{{Department: "Books", TotalSales: models.WholeAmount(10)}})
+	require.NoError(t, err)
+
+	id := fileService.ResultID(path)
+	resolved, err := fileService.ResultPath(id)
+	require.NoError(t, err)
+	assert.Equal(t, path, resolved)
+	assert.Equal(t, "/api/v1/results/"+id+"/download", LocalDownloadURL(filepath.Base(path)))
+	assert.Equal(t, "/api/v1/files/report_x.html", LocalDownloadURL("report_x.html"))
+
+	_, err = fileService.ResultPath("../result_x")
+	assert.ErrorIs(t, err, ErrResultNotFound)
+	_, err = fileService.ResultPath("11111111-2222-3333-4444-555555555555")
+	assert.ErrorIs(t, err, ErrResultNotFound)
+}
+
+func TestFileServiceStorageUsage(t *testing.T) {
+	dir := t.TempDir()
+	logger := logrus.New()
+	logger.SetLevel(logrus.ErrorLevel)
+	fileService := NewFileService(dir, logger)
+
+	require.NoError(t, os.WriteFile(filepath.Join(dir, "upload_a.csv"), []byte("12345"), 0600))
+	require.NoError(t, os.WriteFile(filepath.Join(dir, "upload_b.csv"), []byte("123"), 0600))
+	require.NoError(t, os.WriteFile(filepath.Join(dir, "result_a.csv"), []byte("12"), 0600))
+	require.NoError(t, os.WriteFile(filepath.Join(dir, ".gitkeep"), nil, 0600))
+	require.NoError(t, os.Mkdir(filepath.Join(dir, "nested"), 0700))
+
+	usage, err := fileService.StorageUsage()
+	require.NoError(t, err)
+	assert.Equal(t, int64(4), usage.Files)
+	assert.Equal(t, int64(10), usage.Bytes)
+	assert.Equal(t, Usage{Files: 2, Bytes: 8}, usage.Kinds["upload"])
+	assert.Equal(t, Usage{Files: 1, Bytes: 2}, usage.Kinds["result"])
+	assert.Equal(t, Usage{Files: 1}, usage.Kinds["other"])
+}
@@ -0,0 +1,304 @@
+package services
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/sirupsen/logrus"
+)
+
+// Retail year end rules
+const (
+	RetailYearEndLast    = "last"
+	RetailYearEndNearest = "nearest"
+)
+
+// Fiscal year naming conventions
+const (
+	FiscalYearLabelEnd   = "end"
+	FiscalYearLabelStart = "start"
+)
+
+// retailPatterns maps a retail calendar pattern to its weeks per period within a quarter
+var retailPatterns = map[string][3]int{
+	"4-4-5": {4, 4, 5},
+	"4-5-4": {4, 5, 4},
+	"5-4-4": {5, 4, 4},
+}
+
+// FiscalCalendar defines how dates map to fiscal years and quarters.
+//
+// Without a pattern, fiscal quarters are three calendar months starting at
+// StartMonth. With a retail pattern (4-4-5, 4-5-4 or 5-4-4) the year is made
+// of whole weeks ending on the week-end day (the day before WeekStart) that is
+// the last one in the month before StartMonth, or with YearEnd "nearest" the
+// one nearest that month's end (as in the NRF 4-5-4 calendar). A year has 52
+// or 53 weeks; a 53rd week belongs to the last period.
+type FiscalCalendar struct {
+	StartMonth time.Month `json:"start_month"`
+	Pattern    string     `json:"pattern,omitempty"`
+	// WeekStart is the first day of a retail week; only used with a pattern
+	WeekStart time.Weekday `json:"week_start"`
+	// YearEnd is RetailYearEndLast (default) or RetailYearEndNearest; only used with a pattern
+	YearEnd string `json:"year_end,omitempty"`
+	// YearLabel names a fiscal year after the calendar year it ends in (default) or starts in
+	YearLabel string `json:"year_label,omitempty"`
+}
+
+// DefaultFiscalCalendar is the plain calendar year
+var DefaultFiscalCalendar = FiscalCalendar{StartMonth: time.January, WeekStart: time.Sunday, YearLabel: FiscalYearLabelEnd}
+
+// FiscalPeriod is the fiscal position of a date
+type FiscalPeriod struct {
+	Year    int `json:"year"`
+	Quarter int `json:"quarter"`
+	// Period is the fiscal month, 1-12
+	Period int `json:"period"`
+	// Week is the week of a retail year, 1-53; 0 without a pattern
+	Week  int       `json:"week,omitempty"`
+	Start time.Time `json:"period_start"`
+	End   time.Time `json:"period_end"`
+}
+
+// QuarterLabel returns the quarter in "FY2024 Q1" form
+func (p FiscalPeriod) QuarterLabel() string {
+	return fmt.Sprintf("FY%d Q%d", p.Year, p.Quarter)
+}
+
+// Validate checks the calendar's fields
+func (fc FiscalCalendar) Validate() error {
+	if fc.StartMonth < time.January || fc.StartMonth > time.December {
+		return fmt.Errorf("start_month must be between 1 and 12")
+	}
+	if _, ok := retailPatterns[fc.Pattern]; fc.Pattern != "" && !ok {
+		return fmt.Errorf("unknown pattern %q: expected 4-4-5, 4-5-4 or 5-4-4", fc.Pattern)
+	}
+	if fc.WeekStart < time.Sunday || fc.WeekStart > time.Saturday {
+		return fmt.Errorf("week_start must be between 0 (Sunday) and 6 (Saturday)")
+	}
+	switch fc.YearEnd {
+	case "", RetailYearEndLast, RetailYearEndNearest:
+	default:
+		return fmt.Errorf("unknown year_end %q: expected last or nearest", fc.YearEnd)
+	}
+	switch fc.YearLabel {
+	case "", FiscalYearLabelEnd, FiscalYearLabelStart:
+	default:
+		return fmt.Errorf("unknown year_label %q: expected end or start", fc.YearLabel)
+	}
+	return nil
+}
+
+// ParseFiscalCalendar parses a "start_month=2,pattern=4-5-4,year_end=nearest,year_label=start"
+// specification; omitted keys keep their DefaultFiscalCalendar values
+func ParseFiscalCalendar(spec string) (FiscalCalendar, error) {
+	fc := DefaultFiscalCalendar
+	for _, part := range strings.Split(spec, ",") {
+		part = strings.TrimSpace(part)
+		if part == "" {
+			continue
+		}
+		key, value, ok := strings.Cut(part, "=")
+		if !ok {
+			return fc, fmt.Errorf("invalid fiscal calendar setting %q: expected key=value", part)
+		}
+		key, value = strings.TrimSpace(key), strings.TrimSpace(value)
+		switch key {
+		case "start_month", "week_start":
+			n, err := strconv.Atoi(value)
+			if err != nil {
+				return fc, fmt.Errorf("invalid %s %q", key, value)
+			}
+			if key == "start_month" {
+				fc.StartMonth = time.Month(n)
+			} else {
+				fc.WeekStart = time.Weekday(n)
+			}
+		case "pattern":
+			fc.Pattern = value
+		case "year_end":
+			fc.YearEnd = value
+		case "year_label":
+			fc.YearLabel = value
+		default:
+			return fc, fmt.Errorf("unknown fiscal calendar setting %q", key)
+		}
+	}
+	return fc, fc.Validate()
+}
+
+// isCalendarYear reports whether fiscal periods are plain calendar ones
+func (fc FiscalCalendar) isCalendarYear() bool {
+	return fc.Pattern == "" && fc.StartMonth == time.January
+}
+
+// PeriodOf returns the fiscal year, quarter and period containing date
+func (fc FiscalCalendar) PeriodOf(date time.Time) FiscalPeriod {
+	date = time.Date(date.Year(), date.Month(), date.Day(), 0, 0, 0, 0, time.UTC)
+	if fc.Pattern != "" {
+		return fc.retailPeriodOf(date)
+	}
+
+	index := (int(date.Month()) - int(fc.StartMonth) + 12) % 12
+	startYear := date.Year()
+	if date.Month() < fc.StartMonth {
+		startYear--
+	}
+	start := time.Date(date.Year(), date.Month(), 1, 0, 0, 0, 0, time.UTC)
+	return FiscalPeriod{
+		Year:    fc.yearLabel(startYear),
+		Quarter: index/3 + 1,
+		Period:  index + 1,
+		Start:   start,
+		End:     start.AddDate(0, 1, -1),
+	}
+}
+
+// retailPeriodOf maps a date onto a week-based retail calendar
+func (fc FiscalCalendar) retailPeriodOf(date time.Time) FiscalPeriod {
+	startYear := date.Year() + 1
+	for fc.retailYearStart(startYear).After(date) {
+		startYear--
+	}
+	yearStart := fc.retailYearStart(startYear)
+	yearEnd := fc.retailYearStart(startYear+1).AddDate(0, 0, -1)
+
+	week := int(date.Sub(yearStart).Hours() / 24 / 7)
+	weeks := retailPatterns[fc.Pattern]
+	period, first := 0, 0
+	for period < 11 && week >= first+weeks[period%3] {
+		first += weeks[period%3]
+		period++
+	}
+
+	start := yearStart.AddDate(0, 0, first*7)
+	end := start.AddDate(0, 0, weeks[period%3]*7-1)
+	if period == 11 {
+		end = yearEnd
+	}
+	return FiscalPeriod{
+		Year:    fc.yearLabel(startYear),
+		Quarter: period/3 + 1,
+		Period:  period + 1,
+		Week:    week + 1,
+		Start:   start,
+		End:     end,
+	}
+}
+
+// retailYearStart returns the first day of the retail year starting around
+// StartMonth of year: the day after the preceding year's final week end
+func (fc FiscalCalendar) retailYearStart(year int) time.Time {
+	lastDay := time.Date(year, fc.StartMonth, 0, 0, 0, 0, 0, time.UTC)
+	weekEnd := (fc.WeekStart + 6) % 7
+	back := (int(lastDay.Weekday()) - int(weekEnd) + 7) % 7
+	if fc.YearEnd == RetailYearEndNearest && back > 3 {
+		back -= 7
+	}
+	return lastDay.AddDate(0, 0, 1-back)
+}
+
+// yearLabel names the fiscal year whose StartMonth falls in startYear
+func (fc FiscalCalendar) yearLabel(startYear int) int {
+	if fc.YearLabel == FiscalYearLabelStart || fc.StartMonth == time.January {
+		return startYear
+	}
+	return startYear + 1
+}
+
+// FiscalCalendarStore holds per-tenant fiscal calendars persisted to a JSON file
+type FiscalCalendarStore struct {
+	path            string
+	defaultCalendar FiscalCalendar
+	mu              sync.Mutex
+	calendars       map[string]FiscalCalendar
+	logger          *logrus.Logger
+}
+
+// NewFiscalCalendarStore creates a FiscalCalendarStore, loading overrides from path
+func NewFiscalCalendarStore(path string, defaultCalendar FiscalCalendar, logger *logrus.Logger) (*FiscalCalendarStore, error) {
+	fs := &FiscalCalendarStore{
+		path:            path,
+		defaultCalendar: defaultCalendar,
+		calendars:       make(map[string]FiscalCalendar),
+		logger:          logger,
+	}
+
+	data, err := os.ReadFile(path)
+	if err != nil && !errors.Is(err, os.ErrNotExist) {
+		return nil, fmt.Errorf("failed to read fiscal calendars: %w", err)
+	}
+	if err == nil {
+		if err := json.Unmarshal(data, &fs.calendars); err != nil {
+			return nil, fmt.Errorf("failed to decode fiscal calendars: %w", err)
+		}
+	}
+	return fs, nil
+}
+
+// Get returns the calendar in effect for tenant and whether it is an override
+func (fs *FiscalCalendarStore) Get(tenant string) (FiscalCalendar, bool) {
+	fs.mu.Lock()
+	defer fs.mu.Unlock()
+	if calendar, ok := fs.calendars[tenant]; ok {
+		return calendar, true
+	}
+	return fs.defaultCalendar, false
+}
+
+// Set overrides a tenant's calendar and persists it
+func (fs *FiscalCalendarStore) Set(tenant string, calendar FiscalCalendar) error {
+	if err := calendar.Validate(); err != nil {
+		return err
+	}
+	if calendar.YearLabel == "" {
+		calendar.YearLabel = FiscalYearLabelEnd
+	}
+
+	fs.mu.Lock()
+	defer fs.mu.Unlock()
+	previous, existed := fs.calendars[tenant]
+	fs.calendars[tenant] = calendar
+	if err := fs.save(); err != nil {
+		if existed {
+			fs.calendars[tenant] = previous
+		} else {
+			delete(fs.calendars, tenant)
+		}
+		return err
+	}
+	fs.logger.Infof("Set fiscal calendar for tenant %s", tenant)
+	return nil
+}
+
+// Reset removes a tenant's override so it uses the default calendar
+func (fs *FiscalCalendarStore) Reset(tenant string) error {
+	fs.mu.Lock()
+	defer fs.mu.Unlock()
+	previous, existed := fs.calendars[tenant]
+	if !existed {
+		return nil
+	}
+	delete(fs.calendars, tenant)
+	if err := fs.save(); err != nil {
+		fs.calendars[tenant] = previous
+		return err
+	}
+	fs.logger.Infof("Reset fiscal calendar for tenant %s", tenant)
+	return nil
+}
+
+// save persists the overrides. Callers must hold fs.mu.
+func (fs *FiscalCalendarStore) save() error {
+	data, err := json.MarshalIndent(fs.calendars, "", "  ")
+	if err != nil {
+		return fmt.Errorf("failed to encode fiscal calendars: %w", err)
+	}
+	return writeFileAtomic(fs.path, data, 0600)
+}
@@ -0,0 +1,99 @@
+package services
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/sirupsen/logrus"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func fiscalDate(year int, month time.Month, day int) time.Time {
+	return time.Date(year, month, day, 0, 0, 0, 0, time.UTC)
+}
+
+func TestFiscalCalendarMonthBased(t *testing.T) {
+	period := DefaultFiscalCalendar.PeriodOf(fiscalDate(2024, time.May, 17))
+	assert.Equal(t, "FY2024 Q2", period.QuarterLabel())
+	assert.Equal(t, 5, period.Period)
+
+	// July start, named after the year it ends in
+	july := FiscalCalendar{StartMonth: time.July}
+	assert.Equal(t, "FY2025 Q1", july.PeriodOf(fiscalDate(2024, time.July, 1)).QuarterLabel())
+	assert.Equal(t, "FY2025 Q4", july.PeriodOf(fiscalDate(2025, time.June, 30)).QuarterLabel())
+	assert.Equal(t, "FY2024 Q3", july.PeriodOf(fiscalDate(2024, time.March, 31)).QuarterLabel())
+
+	july.YearLabel = FiscalYearLabelStart
+	assert.Equal(t, "FY2024 Q1", july.PeriodOf(fiscalDate(2024, time.July, 1)).QuarterLabel())
+}
+
+func TestFiscalCalendarRetail(t *testing.T) {
+	// NRF 4-5-4: fiscal 2023 ran from 29 Jan 2023 to 3 Feb 2024 (53 weeks)
+	nrf, err := ParseFiscalCalendar("start_month=2,pattern=4-5-4,year_end=nearest,year_label=start")
+	require.NoError(t, err)
+
+	first := nrf.PeriodOf(fiscalDate(2023, time.January, 29))
+	assert.Equal(t, "FY2023 Q1", first.QuarterLabel())
+	assert.Equal(t, 1, first.Period)
+	assert.Equal(t, fiscalDate(2023, time.February, 25), first.End)
+
+	second := nrf.PeriodOf(fiscalDate(2023, time.February, 26))
+	assert.Equal(t, 2, second.Period)
+	assert.Equal(t, fiscalDate(2023, time.April, 1), second.End)
+
+	last := nrf.PeriodOf(fiscalDate(2024, time.February, 3))
+	assert.Equal(t, "FY2023 Q4", last.QuarterLabel())
+	assert.Equal(t, 12, last.Period)
+	assert.Equal(t, fiscalDate(2024, time.February, 3), last.End)
+	assert.Equal(t, 35, int(last.End.Sub(last.Start).Hours()/24)+1)
+
+	assert.Equal(t, "FY2024 Q1", nrf.PeriodOf(fiscalDate(2024, time.February, 4)).QuarterLabel())
+	assert.Equal(t, "FY2022 Q4", nrf.PeriodOf(fiscalDate(2023, time.January, 28)).QuarterLabel())
+
+	// With the default "last" rule the year ends on the last Saturday of January
+	last445, err := ParseFiscalCalendar("start_month=2,pattern=4-4-5")
+	require.NoError(t, err)
+	assert.Equal(t, "FY2025 Q1", last445.PeriodOf(fiscalDate(2024, time.January, 28)).QuarterLabel())
+	assert.Equal(t, "FY2024 Q4", last445.PeriodOf(fiscalDate(2024, time.January, 27)).QuarterLabel())
+}
+
+func TestParseFiscalCalendarErrors(t *testing.T) {
+	for _, spec := range []string{"start_month=13", "pattern=4-4-4", "week_start=7", "year_label=middle", "year_end=first", "quarter=1", "start_month"} {
+		_, err := ParseFiscalCalendar(spec)
+		assert.Error(t, err, spec)
+	}
+}
+
+func TestFiscalCalendarStore(t *testing.T) {
+	tempDir, err := os.MkdirTemp("", "test_calendars")
+	require.NoError(t, err)
+	defer os.RemoveAll(tempDir)
+
+	logger := logrus.New()
+	logger.SetLevel(logrus.ErrorLevel)
+	path := filepath.Join(tempDir, "fiscal_calendars.json")
+
+	store, err := NewFiscalCalendarStore(path, DefaultFiscalCalendar, logger)
+	require.NoError(t, err)
+
+	calendar, override := store.Get("acme")
+	assert.False(t, override)
+	assert.Equal(t, DefaultFiscalCalendar, calendar)
+
+	require.NoError(t, store.Set("acme", FiscalCalendar{StartMonth: time.October}))
+	assert.Error(t, store.Set("acme", FiscalCalendar{StartMonth: 0}))
+
+	reloaded, err := NewFiscalCalendarStore(path, DefaultFiscalCalendar, logger)
+	require.NoError(t, err)
+	calendar, override = reloaded.Get("acme")
+	assert.True(t, override)
+	assert.Equal(t, time.October, calendar.StartMonth)
+	assert.Equal(t, FiscalYearLabelEnd, calendar.YearLabel)
+
+	require.NoError(t, reloaded.Reset("acme"))
+	_, override = reloaded.Get("acme")
+	assert.False(t, override)
+}
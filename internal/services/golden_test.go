@@ -0,0 +1,93 @@
+package services
+
+import (
+	"bytes"
+	"encoding/json"
+	"flag"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/mussietl/csv-sales-api/internal/models"
+	"github.com/sirupsen/logrus"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// Regenerate golden files with: go test ./internal/services -run TestGoldenFixtures -update
+var updateGolden = flag.Bool("update", false, "regenerate golden files in testdata/golden")
+
+// goldenResult is the stable, comparable form of a processing run
+type goldenResult struct {
+	Error            string              `json:"error,omitempty"`
+	DepartmentColumn string              `json:"department_column,omitempty"`
+	SalesColumn      string              `json:"sales_column,omitempty"`
+	Rows             *models.RowStats    `json:"rows,omitempty"`
+	FooterTotal      *models.Amount      `json:"footer_total,omitempty"`
+	Summaries        []DepartmentSummary `json:"summaries,omitempty"`
+}
+
+func TestGoldenFixtures(t *testing.T) {
+	logger := logrus.New()
+	logger.SetLevel(logrus.PanicLevel)
+	csvService := NewCSVService(logger)
+
+	fixtures, err := filepath.Glob(filepath.Join("testdata", "fixtures", "*.csv"))
+	require.NoError(t, err)
+	require.NotEmpty(t, fixtures)
+
+	for _, fixture := range fixtures {
+		name := strings.TrimSuffix(filepath.Base(fixture), ".csv")
+		t.Run(name, func(t *testing.T) {
+			result, err := csvService.Process(fixture, ProcessOptions{})
+			assertGolden(t, name, newGoldenResult(result, err))
+
+			// The fast parser must produce identical results
+			if *updateGolden {
+				return
+			}
+			result, err = csvService.Process(fixture, ProcessOptions{Parser: ParserFast})
+			assertGolden(t, name, newGoldenResult(result, err))
+		})
+	}
+}
+
+// newGoldenResult converts a processing outcome into its golden form
+func newGoldenResult(result *ProcessResult, err error) goldenResult {
+	if err != nil {
+		return goldenResult{Error: err.Error()}
+	}
+
+	rows := result.Rows
+	return goldenResult{
+		DepartmentColumn: result.Schema.DepartmentColumn,
+		SalesColumn:      result.Schema.SalesColumn,
+		Rows:             &rows,
+		FooterTotal:      result.FooterTotal,
+		Summaries:        result.Summaries,
+	}
+}
+
+// assertGolden compares got against testdata/golden/<name>.json, rewriting
+// the file instead when -update is set
+func assertGolden(t *testing.T, name string, got interface{}) {
+	t.Helper()
+
+	var buf bytes.Buffer
+	encoder := json.NewEncoder(&buf)
+	encoder.SetEscapeHTML(false)
+	encoder.SetIndent("", "  ")
+	require.NoError(t, encoder.Encode(got))
+	actual := buf.Bytes()
+
+	path := filepath.Join("testdata", "golden", name+".json")
+	if *updateGolden {
+		require.NoError(t, os.WriteFile(path, actual, 0644))
+		return
+	}
+
+	expected, err := os.ReadFile(path)
+	require.NoError(t, err, "missing golden file; run with -update to create it")
+	assert.Equal(t, string(expected), string(actual))
+}
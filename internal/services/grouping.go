@@ -0,0 +1,250 @@
+package services
+
+import (
+	"fmt"
+	"math/big"
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/mussietl/csv-sales-api/internal/models"
+)
+
+// Aggregation functions applied to the sales column of each group
+const (
+	// AggregationDefault sums, like AggregationSum
+	AggregationDefault = ""
+	AggregationSum     = "sum"
+	AggregationAvg     = "avg"
+	AggregationCount   = "count"
+	AggregationMin     = "min"
+	AggregationMax     = "max"
+)
+
+// ValidateAggregation checks that function is a known aggregation function
+func ValidateAggregation(function string) error {
+	switch function {
+	case AggregationDefault, AggregationSum, AggregationAvg, AggregationCount, AggregationMin, AggregationMax:
+		return nil
+	}
+	return fmt.Errorf("invalid agg %q: expected sum, avg, count, min or max", function)
+}
+
+// MaxAggregations limits the aggregations of a single request
+const MaxAggregations = 10
+
+// AggregationSpec is one of several aggregations computed in the same pass:
+// the sales column aggregated with Function over the GroupBy columns
+type AggregationSpec struct {
+	GroupBy  []string
+	Function string
+}
+
+// ParseAggregations parses a semicolon-separated list of aggregations, each
+// a comma-separated list of group-by columns optionally followed by a colon
+// and a function, e.g. "region:sum;department,month:avg"
+func ParseAggregations(list string) ([]AggregationSpec, error) {
+	var specs []AggregationSpec
+	for _, entry := range strings.Split(list, ";") {
+		if entry = strings.TrimSpace(entry); entry == "" {
+			continue
+		}
+		var spec AggregationSpec
+		columns, function, ok := strings.Cut(entry, ":")
+		if ok {
+			spec.Function = strings.ToLower(strings.TrimSpace(function))
+			if err := ValidateAggregation(spec.Function); err != nil {
+				return nil, err
+			}
+		}
+		for _, column := range strings.Split(columns, ",") {
+			if column = strings.TrimSpace(column); column != "" {
+				spec.GroupBy = append(spec.GroupBy, column)
+			}
+		}
+		if len(spec.GroupBy) == 0 {
+			return nil, fmt.Errorf("aggregation %q has no group-by columns", entry)
+		}
+		specs = append(specs, spec)
+	}
+	if len(specs) > MaxAggregations {
+		return nil, fmt.Errorf("at most %d aggregations may be requested", MaxAggregations)
+	}
+	return specs, nil
+}
+
+// groupKeySeparator joins group values into a map key; it cannot appear in
+// a CSV field that survived normalization unchanged, so keys don't collide
+const groupKeySeparator = "\x00"
+
+// groupStats accumulates the sales of one group
+type groupStats struct {
+	key  []string
+	rows int
+	sum  models.Amount
+	min  models.Amount
+	max  models.Amount
+}
+
+// add counts one row's sales
+func (s *groupStats) add(sales models.Amount) {
+	if s.rows == 0 || sales.Cmp(s.min) < 0 {
+		s.min = sales
+	}
+	if s.rows == 0 || sales.Cmp(s.max) > 0 {
+		s.max = sales
+	}
+	s.rows++
+	s.sum = s.sum.Add(sales)
+}
+
+// merge adds the sales of the same group counted elsewhere
+func (s *groupStats) merge(other *groupStats) {
+	if other.rows == 0 {
+		return
+	}
+	if s.rows == 0 || other.min.Cmp(s.min) < 0 {
+		s.min = other.min
+	}
+	if s.rows == 0 || other.max.Cmp(s.max) > 0 {
+		s.max = other.max
+	}
+	s.rows += other.rows
+	s.sum = s.sum.Add(other.sum)
+}
+
+// value returns the group's aggregate as an exact decimal string
+func (s *groupStats) value(function string) string {
+	switch function {
+	case AggregationAvg:
+		return averageDecimal(s.sum, s.rows)
+	case AggregationCount:
+		return strconv.Itoa(s.rows)
+	case AggregationMin:
+		return s.min.String()
+	case AggregationMax:
+		return s.max.String()
+	}
+	return s.sum.String()
+}
+
+// groupAggregator accumulates sales per combination of group-by values
+type groupAggregator struct {
+	groups map[string]*groupStats
+	order  []*groupStats
+}
+
+func newGroupAggregator() *groupAggregator {
+	return &groupAggregator{groups: make(map[string]*groupStats)}
+}
+
+// add counts a row's sales towards the group of key
+func (g *groupAggregator) add(key []string, sales models.Amount) {
+	g.group(key).add(sales)
+}
+
+// group returns the stats of key, creating them the first time it is seen
+func (g *groupAggregator) group(key []string) *groupStats {
+	joined := strings.Join(key, groupKeySeparator)
+	stats, ok := g.groups[joined]
+	if !ok {
+		stats = &groupStats{key: append([]string(nil), key...)}
+		g.groups[joined] = stats
+		g.order = append(g.order, stats)
+	}
+	return stats
+}
+
+// merge adds the groups of an aggregator that consumed a later part of the file
+func (g *groupAggregator) merge(other *groupAggregator) {
+	for _, stats := range other.order {
+		g.group(stats.key).merge(stats)
+	}
+}
+
+// result converts the groups to an AggregationResult in the requested order:
+// OrderFirstSeen keeps the order of first appearance, OrderTotalDesc sorts by
+// value, largest first, and the default sorts by key
+func (g *groupAggregator) result(groupBy []string, function, column, order string) *models.AggregationResult {
+	if function == AggregationDefault {
+		function = AggregationSum
+	}
+	result := &models.AggregationResult{
+		GroupBy:  groupBy,
+		Function: function,
+		Column:   column,
+		Groups:   make([]models.AggregationGroup, len(g.order)),
+	}
+	for i, stats := range g.order {
+		exact := stats.value(function)
+		value, _ := strconv.ParseFloat(exact, 64)
+		result.Groups[i] = models.AggregationGroup{Key: stats.key, Value: value, Rows: stats.rows, Exact: exact}
+	}
+
+	groups := result.Groups
+	byKey := func(i, j int) bool {
+		a, b := groups[i].Key, groups[j].Key
+		for k := range a {
+			if a[k] != b[k] {
+				return a[k] < b[k]
+			}
+		}
+		return false
+	}
+	switch order {
+	case OrderFirstSeen:
+	case OrderTotalDesc:
+		sort.SliceStable(groups, func(i, j int) bool {
+			if groups[i].Value != groups[j].Value {
+				return groups[i].Value > groups[j].Value
+			}
+			return byKey(i, j)
+		})
+	default:
+		sort.SliceStable(groups, byKey)
+	}
+	return result
+}
+
+// averageDecimal returns sum/count as a decimal string precise enough to be
+// rounded to MaxPrecision places exactly: further digits are truncated,
+// with a trailing 1 standing in for any non-zero remainder
+func averageDecimal(sum models.Amount, count int) string {
+	if count == 0 {
+		return "0"
+	}
+	scale := new(big.Int).Exp(big.NewInt(10), big.NewInt(MaxPrecision+1), nil)
+	numerator := new(big.Int).Mul(big.NewInt(sum.Units()), scale)
+	units := new(big.Int).Exp(big.NewInt(10), big.NewInt(models.AmountDecimals), nil)
+	denominator := new(big.Int).Mul(big.NewInt(int64(count)), units)
+	quotient, remainder := new(big.Int).QuoRem(numerator, denominator, new(big.Int))
+
+	negative := quotient.Sign() < 0 || (quotient.Sign() == 0 && remainder.Sign() < 0)
+	digits := new(big.Int).Abs(quotient).String()
+	if len(digits) <= MaxPrecision+1 {
+		digits = strings.Repeat("0", MaxPrecision+2-len(digits)) + digits
+	}
+	intPart, fracPart := digits[:len(digits)-MaxPrecision-1], digits[len(digits)-MaxPrecision-1:]
+	if remainder.Sign() != 0 {
+		fracPart += "1"
+	}
+	fracPart = strings.TrimRight(fracPart, "0")
+
+	result := intPart
+	if fracPart != "" {
+		result += "." + fracPart
+	}
+	if negative {
+		result = "-" + result
+	}
+	return result
+}
+
+// RoundAggregation rounds every group's value to the format's precision
+func RoundAggregation(result *models.AggregationResult, format NumberFormat) {
+	for i, group := range result.Groups {
+		if formatted, err := format.FormatDecimal(group.Exact); err == nil {
+			result.Groups[i].Value, _ = strconv.ParseFloat(formatted, 64)
+		}
+	}
+}
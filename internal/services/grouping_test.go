@@ -0,0 +1,230 @@
+package services
+
+import (
+	"bytes"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/mussietl/csv-sales-api/internal/models"
+	"github.com/sirupsen/logrus"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+const groupingCSV = "Department Name,Region,Number of Sales\n" +
+	"Books,North,10\n" +
+	"Books,South,20\n" +
+	"Toys,North,5\n" +
+	"Books,North,1\n" +
+	"Toys,North,abc\n"
+
+func TestProcessGroupBy(t *testing.T) {
+	logger := logrus.New()
+	logger.SetLevel(logrus.ErrorLevel)
+	cs := NewCSVService(logger)
+
+	result, err := cs.ProcessStream(strings.NewReader(groupingCSV), ProcessOptions{GroupBy: []string{"department name", "REGION"}})
+	require.NoError(t, err)
+	require.NotNil(t, result.Aggregation)
+	assert.Equal(t, []string{"Department Name", "Region"}, result.Aggregation.GroupBy)
+	assert.Equal(t, AggregationSum, result.Aggregation.Function)
+	assert.Equal(t, "Number of Sales", result.Aggregation.Column)
+	assert.Equal(t, []models.AggregationGroup{
+		{Key: []string{"Books", "North"}, Value: 11, Rows: 2, Exact: "11"},
+		{Key: []string{"Books", "South"}, Value: 20, Rows: 1, Exact: "20"},
+		{Key: []string{"Toys", "North"}, Value: 5, Rows: 1, Exact: "5"},
+	}, result.Aggregation.Groups)
+
+	// Department totals are still computed alongside
+	assert.Len(t, result.Summaries, 2)
+
+	_, err = cs.ProcessStream(strings.NewReader(groupingCSV), ProcessOptions{GroupBy: []string{"country"}})
+	assert.ErrorContains(t, err, `group_by column "country" not found`)
+}
+
+func TestProcessAggregationFunctions(t *testing.T) {
+	logger := logrus.New()
+	logger.SetLevel(logrus.ErrorLevel)
+	cs := NewCSVService(logger)
+
+	values := func(function string) []string {
+		result, err := cs.ProcessStream(strings.NewReader(groupingCSV), ProcessOptions{Aggregation: function})
+		require.NoError(t, err)
+		require.NotNil(t, result.Aggregation)
+		assert.Equal(t, []string{"Department Name"}, result.Aggregation.GroupBy)
+		var exact []string
+		for _, group := range result.Aggregation.Groups {
+			exact = append(exact, group.Exact)
+		}
+		return exact
+	}
+	assert.Equal(t, []string{"31", "5"}, values(AggregationSum))
+	assert.Equal(t, []string{"10.333333333331", "5"}, values(AggregationAvg))
+	assert.Equal(t, []string{"3", "1"}, values(AggregationCount))
+	assert.Equal(t, []string{"1", "5"}, values(AggregationMin))
+	assert.Equal(t, []string{"20", "5"}, values(AggregationMax))
+
+	result, err := cs.ProcessStream(strings.NewReader(groupingCSV), ProcessOptions{})
+	require.NoError(t, err)
+	assert.Nil(t, result.Aggregation)
+
+	assert.NoError(t, ValidateAggregation("avg"))
+	assert.Error(t, ValidateAggregation("median"))
+}
+
+func TestAverageDecimal(t *testing.T) {
+	assert.Equal(t, "2.5", averageDecimal(models.WholeAmount(5), 2))
+	assert.Equal(t, "-2.5", averageDecimal(models.WholeAmount(-5), 2))
+	assert.Equal(t, "-0.5", averageDecimal(models.WholeAmount(-1), 2))
+	assert.Equal(t, "0", averageDecimal(models.Amount{}, 0))
+	sum, err := models.ParseAmount("0.0003")
+	require.NoError(t, err)
+	assert.Equal(t, "0.0001", averageDecimal(sum, 3))
+
+	// The sticky digit makes a value just above a tie round up
+	format := NumberFormat{Precision: 0, Rounding: RoundHalfEven}
+	sum, err = models.ParseAmount("500000000000000.0001")
+	require.NoError(t, err)
+	rounded, err := format.FormatDecimal(averageDecimal(sum, int(2e14)))
+	require.NoError(t, err)
+	assert.Equal(t, "3", rounded)
+}
+
+func TestGroupOrder(t *testing.T) {
+	logger := logrus.New()
+	logger.SetLevel(logrus.ErrorLevel)
+	cs := NewCSVService(logger)
+
+	keys := func(order string) []string {
+		result, err := cs.ProcessStream(strings.NewReader(groupingCSV), ProcessOptions{GroupBy: []string{"Region"}, Order: order})
+		require.NoError(t, err)
+		var keys []string
+		for _, group := range result.Aggregation.Groups {
+			keys = append(keys, group.Key[0])
+		}
+		return keys
+	}
+	assert.Equal(t, []string{"North", "South"}, keys(""))
+	assert.Equal(t, []string{"North", "South"}, keys(OrderFirstSeen))
+	// North sums to 16, South to 20
+	assert.Equal(t, []string{"South", "North"}, keys(OrderTotalDesc))
+}
+
+func TestGroupByParallelAndFastParser(t *testing.T) {
+	logger := logrus.New()
+	logger.SetLevel(logrus.ErrorLevel)
+	cs := NewCSVService(logger)
+
+	var buf strings.Builder
+	buf.WriteString("Department Name,Region,Number of Sales\n")
+	for i := 0; i < 3000; i++ {
+		fmt.Fprintf(&buf, "Dept %d,Region %d,%d\n", i%7, i%3, i)
+	}
+	path := filepath.Join(t.TempDir(), "sales.csv")
+	require.NoError(t, os.WriteFile(path, []byte(buf.String()), 0644))
+
+	opts := ProcessOptions{GroupBy: []string{"Region", "Department Name"}, Aggregation: AggregationAvg, Strategy: StrategyStreaming}
+	streaming, err := cs.Process(path, opts)
+	require.NoError(t, err)
+	assert.Len(t, streaming.Aggregation.Groups, 21)
+
+	cs.SetStrategyThresholds(StrategyThresholds{Workers: 4})
+	opts.Strategy = StrategyParallel
+	parallel, err := cs.Process(path, opts)
+	require.NoError(t, err)
+	assert.Equal(t, StrategyParallel, parallel.Strategy)
+	assert.Equal(t, streaming.Aggregation, parallel.Aggregation)
+
+	opts.Strategy, opts.Parser = StrategyStreaming, ParserFast
+	fast, err := cs.Process(path, opts)
+	require.NoError(t, err)
+	assert.Equal(t, streaming.Aggregation, fast.Aggregation)
+}
+
+func TestProcessMultipleAggregations(t *testing.T) {
+	logger := logrus.New()
+	logger.SetLevel(logrus.ErrorLevel)
+	cs := NewCSVService(logger)
+
+	specs, err := ParseAggregations(" region ; department name,region:AVG;")
+	require.NoError(t, err)
+	assert.Equal(t, []AggregationSpec{
+		{GroupBy: []string{"region"}},
+		{GroupBy: []string{"department name", "region"}, Function: AggregationAvg},
+	}, specs)
+	_, err = ParseAggregations("region:median")
+	assert.Error(t, err)
+	_, err = ParseAggregations(":sum")
+	assert.Error(t, err)
+	_, err = ParseAggregations(strings.Repeat("region;", MaxAggregations+1))
+	assert.Error(t, err)
+
+	result, err := cs.ProcessStream(strings.NewReader(groupingCSV), ProcessOptions{GroupBy: []string{"Region"}, Aggregations: specs})
+	require.NoError(t, err)
+	require.Len(t, result.Aggregations, 2)
+	assert.Equal(t, result.Aggregation.Groups, result.Aggregations[0].Groups)
+	assert.Equal(t, []string{"Department Name", "Region"}, result.Aggregations[1].GroupBy)
+	assert.Equal(t, AggregationAvg, result.Aggregations[1].Function)
+	var values []string
+	for _, group := range result.Aggregations[1].Groups {
+		values = append(values, group.Exact)
+	}
+	assert.Equal(t, []string{"5.5", "20", "5"}, values)
+
+	_, err = cs.ProcessStream(strings.NewReader(groupingCSV), ProcessOptions{Aggregations: []AggregationSpec{{GroupBy: []string{"Store"}}}})
+	assert.Error(t, err)
+
+	// Parallel chunks merge every aggregation
+	var buf strings.Builder
+	buf.WriteString("Department Name,Region,Number of Sales\n")
+	for i := 0; i < 3000; i++ {
+		fmt.Fprintf(&buf, "Dept %d,Region %d,%d\n", i%7, i%3, i)
+	}
+	path := filepath.Join(t.TempDir(), "sales.csv")
+	require.NoError(t, os.WriteFile(path, []byte(buf.String()), 0644))
+	opts := ProcessOptions{Aggregations: []AggregationSpec{{GroupBy: []string{"Region"}}, {GroupBy: []string{"Department Name"}, Function: AggregationMax}}, Strategy: StrategyStreaming}
+	streaming, err := cs.Process(path, opts)
+	require.NoError(t, err)
+	assert.Nil(t, streaming.Aggregation)
+	cs.SetStrategyThresholds(StrategyThresholds{Workers: 4})
+	opts.Strategy = StrategyParallel
+	parallel, err := cs.Process(path, opts)
+	require.NoError(t, err)
+	assert.Equal(t, streaming.Aggregations, parallel.Aggregations)
+}
+
+func TestFileServiceSaveAggregationResultFile(t *testing.T) {
+	logger := logrus.New()
+	logger.SetLevel(logrus.ErrorLevel)
+	fs := NewFileService(t.TempDir(), logger)
+
+	result := &models.AggregationResult{
+		GroupBy:  []string{"Department Name", "Region, Area"},
+		Function: AggregationAvg,
+		Column:   "Number of Sales",
+		Groups: []models.AggregationGroup{
+			{Key: []string{"Books", "North"}, Exact: "10.333333333331"},
+			{Key: []string{"Toys", "South"}, Exact: "2.5"},
+		},
+	}
+	path, err := fs.SaveAggregationResultFile(result, NumberFormat{Precision: 2}, OutputFormatCSV)
+	require.NoError(t, err)
+	data, err := os.ReadFile(path)
+	require.NoError(t, err)
+	assert.Equal(t, "Department Name,\"Region, Area\",avg(Number of Sales)\nBooks,North,10.33\nToys,South,2.50\n", string(data))
+
+	// Unstored results are written the same way
+	var buf bytes.Buffer
+	require.NoError(t, WriteAggregationResult(&buf, result, NumberFormat{Precision: 2}, OutputFormatCSV))
+	assert.Equal(t, string(data), buf.String())
+	buf.Reset()
+	require.NoError(t, WriteResult(&buf, []DepartmentSummary{{Department: "Books", TotalSales: models.WholeAmount(10)}}, DefaultNumberFormat, MetricDefault, OutputFormatNDJSON))
+	assert.Equal(t, "{\"Department Name\":\"Books\",\"Total Number of Sales\":10}\n", buf.String())
+
+	RoundAggregation(result, NumberFormat{Precision: 1})
+	assert.Equal(t, 10.3, result.Groups[0].Value)
+	assert.Equal(t, 2.5, result.Groups[1].Value)
+}
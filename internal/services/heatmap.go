@@ -0,0 +1,134 @@
+package services
+
+import (
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/mussietl/csv-sales-api/internal/models"
+)
+
+// HeatmapWeekdays names the rows of a sales heatmap, Monday first
+var HeatmapWeekdays = []string{"Monday", "Tuesday", "Wednesday", "Thursday", "Friday", "Saturday", "Sunday"}
+
+// timestampColumnNames are the normalized header names tried, in order,
+// when a heatmap is requested without naming the timestamp column
+var timestampColumnNames = []string{
+	"timestamp", "datetime", "date time", "date_time", "transaction time",
+	"transaction_time", "sold at", "sold_at", "time", "date",
+}
+
+// timestampLayouts are the accepted timestamp formats. Each needs a time of
+// day; fractional seconds are accepted after the seconds of any layout.
+var timestampLayouts = []string{
+	time.RFC3339,
+	"2006-01-02T15:04:05",
+	"2006-01-02 15:04:05",
+	"2006-01-02T15:04",
+	"2006-01-02 15:04",
+	"2006-01-02 15:04:05 -0700",
+	"1/2/2006 15:04:05",
+	"1/2/2006 15:04",
+	"1/2/2006 3:04:05 PM",
+	"1/2/2006 3:04 PM",
+}
+
+// parseTimestamp parses a sales timestamp, keeping its wall clock time
+func parseTimestamp(value string) (time.Time, error) {
+	for _, layout := range timestampLayouts {
+		if t, err := time.Parse(layout, value); err == nil {
+			return t, nil
+		}
+	}
+	return time.Time{}, fmt.Errorf("unrecognized timestamp %q", value)
+}
+
+// findTimestampColumn returns the index of the column named column or, when
+// column is empty, of the first header cell with a timestamp-like name
+func (cs *CSVService) findTimestampColumn(normalizedHeader []string, column string, norm Normalization) (int, error) {
+	if column != "" {
+		indices, err := cs.findColumns(normalizedHeader, []string{column}, norm, "timestamp")
+		if err != nil {
+			return -1, err
+		}
+		return indices[0], nil
+	}
+	for _, name := range timestampColumnNames {
+		for i, col := range normalizedHeader {
+			if strings.EqualFold(col, name) {
+				return i, nil
+			}
+		}
+	}
+	return -1, fmt.Errorf("heatmap needs a timestamp column: none of %s found in CSV header; name it with timestamp_column", strings.Join(timestampColumnNames, ", "))
+}
+
+// heatmapGrid holds one department's sales per weekday and hour
+type heatmapGrid [7][24]models.Amount
+
+// heatmapAggregator accumulates department sales by weekday and hour
+type heatmapAggregator struct {
+	departments map[string]*heatmapGrid
+	untimed     int
+}
+
+func newHeatmapAggregator() *heatmapAggregator {
+	return &heatmapAggregator{departments: make(map[string]*heatmapGrid)}
+}
+
+// add counts a row's sales in the cell of its timestamp
+func (h *heatmapAggregator) add(department, timestamp string, sales models.Amount) {
+	t, err := parseTimestamp(strings.TrimSpace(timestamp))
+	if err != nil {
+		h.untimed++
+		return
+	}
+	grid, ok := h.departments[department]
+	if !ok {
+		grid = &heatmapGrid{}
+		h.departments[department] = grid
+	}
+	// time.Weekday starts on Sunday
+	day := (int(t.Weekday()) + 6) % 7
+	grid[day][t.Hour()] = grid[day][t.Hour()].Add(sales)
+}
+
+// merge adds the cells of an aggregator that consumed another part of the file
+func (h *heatmapAggregator) merge(other *heatmapAggregator) {
+	for department, cells := range other.departments {
+		grid, ok := h.departments[department]
+		if !ok {
+			grid = &heatmapGrid{}
+			h.departments[department] = grid
+		}
+		for day := range cells {
+			for hour := range cells[day] {
+				grid[day][hour] = grid[day][hour].Add(cells[day][hour])
+			}
+		}
+	}
+	h.untimed += other.untimed
+}
+
+// result converts the grids to a SalesHeatmap with departments in the order
+// of summaries; departments without timed rows get an empty grid
+func (h *heatmapAggregator) result(column string, summaries []DepartmentSummary) *models.SalesHeatmap {
+	heatmap := &models.SalesHeatmap{
+		TimestampColumn: column,
+		Weekdays:        HeatmapWeekdays,
+		Departments:     make([]models.DepartmentHeatmap, len(summaries)),
+		UntimedRows:     h.untimed,
+	}
+	for i, summary := range summaries {
+		grid := h.departments[summary.Department]
+		if grid == nil {
+			grid = &heatmapGrid{}
+		}
+		sales := make([][]models.Amount, len(grid))
+		for day := range grid {
+			sales[day] = append([]models.Amount(nil), grid[day][:]...)
+		}
+		heatmap.Departments[i] = models.DepartmentHeatmap{Department: summary.Department, Sales: sales}
+	}
+	return heatmap
+}
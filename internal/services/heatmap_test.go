@@ -0,0 +1,133 @@
+package services
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/mussietl/csv-sales-api/internal/models"
+	"github.com/sirupsen/logrus"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// 2024-03-04 is a Monday
+const heatmapCSV = "Sold At,Department,Sales\n" +
+	"2024-03-04 09:15:00,Books,10\n" +
+	"2024-03-04T09:45:00+02:00,Books,5.5\n" +
+	"3/10/2024 6:05 PM,Books,2\n" +
+	",Books,7\n" +
+	"2024-03-05,Toys,3\n" +
+	"2024-03-05 23:59,Toys,4\n"
+
+func TestProcessHeatmap(t *testing.T) {
+	logger := logrus.New()
+	logger.SetLevel(logrus.ErrorLevel)
+	cs := NewCSVService(logger)
+
+	result, err := cs.ProcessStream(strings.NewReader(heatmapCSV), ProcessOptions{Heatmap: true})
+	require.NoError(t, err)
+	require.NotNil(t, result.Heatmap)
+	heatmap := result.Heatmap
+	assert.Equal(t, "Sold At", heatmap.TimestampColumn)
+	assert.Equal(t, HeatmapWeekdays, heatmap.Weekdays)
+	// The empty timestamp and the date without a time
+	assert.Equal(t, 2, heatmap.UntimedRows)
+	require.Len(t, heatmap.Departments, 2)
+
+	books := heatmap.Departments[0]
+	assert.Equal(t, "Books", books.Department)
+	require.Len(t, books.Sales, 7)
+	require.Len(t, books.Sales[0], 24)
+	// Offsets are ignored: 09:45+02:00 counts at 9 on its own clock
+	assert.Equal(t, amount(t, "15.5"), books.Sales[0][9])
+	assert.Equal(t, models.WholeAmount(2), books.Sales[6][18])
+
+	toys := heatmap.Departments[1]
+	assert.Equal(t, models.WholeAmount(4), toys.Sales[1][23])
+
+	// Untimed rows still count towards the department totals
+	assert.Equal(t, amount(t, "24.5"), result.Summaries[0].TotalSales)
+	assert.Equal(t, models.WholeAmount(7), result.Summaries[1].TotalSales)
+
+	result, err = cs.ProcessStream(strings.NewReader(heatmapCSV), ProcessOptions{})
+	require.NoError(t, err)
+	assert.Nil(t, result.Heatmap)
+}
+
+func TestHeatmapTimestampColumn(t *testing.T) {
+	logger := logrus.New()
+	logger.SetLevel(logrus.ErrorLevel)
+	cs := NewCSVService(logger)
+
+	csv := "Created,Department,Sales,Date\n2024-03-04 10:00,Books,1,2024-03-05 11:00\n"
+	result, err := cs.ProcessStream(strings.NewReader(csv), ProcessOptions{Heatmap: true})
+	require.NoError(t, err)
+	assert.Equal(t, "Date", result.Heatmap.TimestampColumn)
+	assert.Equal(t, models.WholeAmount(1), result.Heatmap.Departments[0].Sales[1][11])
+
+	result, err = cs.ProcessStream(strings.NewReader(csv), ProcessOptions{Heatmap: true, TimestampColumn: "created"})
+	require.NoError(t, err)
+	assert.Equal(t, "Created", result.Heatmap.TimestampColumn)
+	assert.Equal(t, models.WholeAmount(1), result.Heatmap.Departments[0].Sales[0][10])
+
+	_, err = cs.ProcessStream(strings.NewReader(csv), ProcessOptions{Heatmap: true, TimestampColumn: "closed"})
+	assert.ErrorContains(t, err, `timestamp column "closed" not found`)
+
+	_, err = cs.ProcessStream(strings.NewReader("Department,Sales\nBooks,1\n"), ProcessOptions{Heatmap: true})
+	assert.ErrorContains(t, err, "heatmap needs a timestamp column")
+}
+
+func TestHeatmapParallelAndFastParser(t *testing.T) {
+	logger := logrus.New()
+	logger.SetLevel(logrus.ErrorLevel)
+	cs := NewCSVService(logger)
+
+	var buf strings.Builder
+	buf.WriteString("Department,Sales,Timestamp\n")
+	for i := 0; i < 3000; i++ {
+		fmt.Fprintf(&buf, "Dept %d,%d,2024-03-%02d %02d:30:00\n", i%5, i, 4+i%7, i%24)
+	}
+	path := filepath.Join(t.TempDir(), "sales.csv")
+	require.NoError(t, os.WriteFile(path, []byte(buf.String()), 0644))
+
+	opts := ProcessOptions{Heatmap: true, Strategy: StrategyStreaming}
+	streaming, err := cs.Process(path, opts)
+	require.NoError(t, err)
+	assert.Zero(t, streaming.Heatmap.UntimedRows)
+
+	cs.SetStrategyThresholds(StrategyThresholds{Workers: 4})
+	opts.Strategy = StrategyParallel
+	parallel, err := cs.Process(path, opts)
+	require.NoError(t, err)
+	assert.Equal(t, streaming.Heatmap, parallel.Heatmap)
+
+	opts.Strategy, opts.Parser = StrategyStreaming, ParserFast
+	fast, err := cs.Process(path, opts)
+	require.NoError(t, err)
+	assert.Equal(t, streaming.Heatmap, fast.Heatmap)
+}
+
+func TestFileServiceSaveHeatmapFile(t *testing.T) {
+	logger := logrus.New()
+	logger.SetLevel(logrus.ErrorLevel)
+	fs := NewFileService(t.TempDir(), logger)
+	cs := NewCSVService(logger)
+
+	result, err := cs.ProcessStream(strings.NewReader(heatmapCSV), ProcessOptions{Heatmap: true})
+	require.NoError(t, err)
+	path, err := fs.SaveHeatmapFile(result.Heatmap, DefaultNumberFormat)
+	require.NoError(t, err)
+	assert.True(t, strings.HasPrefix(filepath.Base(path), "heatmap_"))
+
+	data, err := os.ReadFile(path)
+	require.NoError(t, err)
+	lines := strings.Split(strings.TrimSuffix(string(data), "\n"), "\n")
+	require.Len(t, lines, 1+2*7)
+	assert.True(t, strings.HasPrefix(lines[0], "Department Name,Weekday,00,01,"))
+	assert.True(t, strings.HasSuffix(lines[0], ",22,23"))
+	assert.Equal(t, "Books,Monday,0,0,0,0,0,0,0,0,0,15.5,0,0,0,0,0,0,0,0,0,0,0,0,0,0", lines[1])
+	assert.True(t, strings.HasPrefix(lines[8], "Toys,Monday,"))
+}
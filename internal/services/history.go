@@ -0,0 +1,273 @@
+package services
+
+import (
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/mussietl/csv-sales-api/internal/models"
+	"github.com/sirupsen/logrus"
+	// Registers the pure-Go "sqlite" driver
+	_ "modernc.org/sqlite"
+)
+
+// ErrHistoryNotFound is returned when an upload has no history record
+var ErrHistoryNotFound = errors.New("history record not found")
+
+// ErrUnsupportedHistoryDatabase is returned for a history database URL the
+// server has no driver for
+var ErrUnsupportedHistoryDatabase = errors.New("unsupported history database")
+
+// Statuses of history records
+const (
+	HistoryReceived   = "received"
+	HistoryProcessing = "processing"
+	HistoryCompleted  = "completed"
+	HistoryFailed     = "failed"
+)
+
+// historyTimeLayout stores timestamps as fixed-width UTC text, so they sort
+// the same as text on every database
+const historyTimeLayout = "2006-01-02T15:04:05.000000Z"
+
+// HistoryRecord is the processing history of one upload
+type HistoryRecord struct {
+	UploadID         string              `json:"upload_id"`
+	Tenant           string              `json:"tenant"`
+	Filename         string              `json:"filename"`
+	Size             int64               `json:"size"`
+	Status           string              `json:"status"`
+	Error            string              `json:"error,omitempty"`
+	Rows             *models.RowStats    `json:"rows,omitempty"`
+	TotalSales       *models.Amount      `json:"total_sales,omitempty"`
+	TotalDepartments int                 `json:"total_departments"`
+	Departments      []DepartmentSummary `json:"departments"`
+	ResultID         string              `json:"result_id,omitempty"`
+	ResultPath       string              `json:"-"`
+	Tags             []string            `json:"tags"`
+	// DownloadURL is filled in when the record is served, while the result exists
+	DownloadURL string     `json:"download_url,omitempty"`
+	ReceivedAt  time.Time  `json:"received_at"`
+	CompletedAt *time.Time `json:"completed_at,omitempty"`
+}
+
+// HistoryRepository records every stored upload and its outcome in a
+// database, so past results can be found without their original URLs
+type HistoryRepository struct {
+	db     *sql.DB
+	logger *logrus.Logger
+}
+
+// NewHistoryRepository opens the history database and applies its migrations.
+// databaseURL is a SQLite file path, optionally prefixed with sqlite://.
+func NewHistoryRepository(databaseURL string, logger *logrus.Logger) (*HistoryRepository, error) {
+	dsn, err := HistoryDSN(databaseURL)
+	if err != nil {
+		return nil, err
+	}
+	db, err := sql.Open("sqlite", dsn)
+	if err != nil {
+		return nil, fmt.Errorf("failed to open history database: %w", err)
+	}
+	// SQLite allows one writer at a time
+	db.SetMaxOpenConns(1)
+	if err := migrate(db); err != nil {
+		db.Close()
+		return nil, fmt.Errorf("failed to migrate history database: %w", err)
+	}
+	return &HistoryRepository{db: db, logger: logger}, nil
+}
+
+// HistoryDSN returns the SQLite file of a history database URL. Only the
+// SQLite driver is built in, so URLs of other databases, such as
+// postgres://, are refused rather than failing when first used.
+func HistoryDSN(databaseURL string) (string, error) {
+	if scheme, _, ok := strings.Cut(databaseURL, "://"); ok && scheme != "sqlite" {
+		return "", fmt.Errorf("%w: %s:// (only SQLite file paths are supported)", ErrUnsupportedHistoryDatabase, scheme)
+	}
+	return strings.TrimPrefix(databaseURL, "sqlite://"), nil
+}
+
+// Close closes the database
+func (hr *HistoryRepository) Close() error {
+	return hr.db.Close()
+}
+
+// Handle records upload lifecycle events. Ephemeral uploads are not stored,
+// so they have no history.
+func (hr *HistoryRepository) Handle(e Event) {
+	if e.Ephemeral || e.UploadID == "" {
+		return
+	}
+	var err error
+	switch e.Type {
+	case EventUploadReceived:
+		err = hr.received(e)
+	case EventProcessingStarted:
+		err = hr.setStatus(e, HistoryProcessing)
+	case EventProcessingCompleted:
+		err = hr.completed(e)
+	}
+	if err != nil {
+		hr.logger.Errorf("Failed to record %s event of upload %s in history: %v", e.Type, e.UploadID, err)
+	}
+}
+
+func (hr *HistoryRepository) received(e Event) error {
+	_, err := hr.db.Exec(`INSERT INTO upload_history (upload_id, tenant, filename, size, status, received_at)
+		VALUES ($1, $2, $3, $4, $5, $6) ON CONFLICT (upload_id) DO NOTHING`,
+		e.UploadID, e.Tenant, e.Filename, e.Size, HistoryReceived, formatHistoryTime(e.At))
+	return err
+}
+
+// setStatus updates a record's status, creating the record for uploads
+// received before history was enabled, such as recovered ones
+func (hr *HistoryRepository) setStatus(e Event, status string) error {
+	_, err := hr.db.Exec(`INSERT INTO upload_history (upload_id, tenant, filename, status, received_at)
+		VALUES ($1, $2, $3, $4, $5) ON CONFLICT (upload_id) DO UPDATE SET status = excluded.status`,
+		e.UploadID, e.Tenant, e.Filename, status, formatHistoryTime(e.At))
+	return err
+}
+
+func (hr *HistoryRepository) completed(e Event) error {
+	if err := hr.setStatus(e, HistoryProcessing); err != nil {
+		return err
+	}
+	status := HistoryFailed
+	if e.Success {
+		status = HistoryCompleted
+	}
+	departments := e.Summaries
+	if departments == nil {
+		departments = []DepartmentSummary{}
+	}
+	encoded, err := json.Marshal(departments)
+	if err != nil {
+		return fmt.Errorf("failed to encode departments: %w", err)
+	}
+	tags := e.Tags
+	if tags == nil {
+		tags = []string{}
+	}
+	encodedTags, err := json.Marshal(tags)
+	if err != nil {
+		return fmt.Errorf("failed to encode tags: %w", err)
+	}
+	var total, processed, skipped sql.NullInt64
+	if e.Rows != nil {
+		total = sql.NullInt64{Int64: int64(e.Rows.Total), Valid: true}
+		processed = sql.NullInt64{Int64: int64(e.Rows.Processed), Valid: true}
+		skipped = sql.NullInt64{Int64: int64(e.Rows.Skipped), Valid: true}
+	}
+	var totalSales sql.NullString
+	if e.TotalSales != nil {
+		totalSales = sql.NullString{String: e.TotalSales.String(), Valid: true}
+	}
+
+	_, err = hr.db.Exec(`UPDATE upload_history SET status = $1, error = $2, rows_total = $3, rows_processed = $4,
+		rows_skipped = $5, total_sales = $6, total_departments = $7, departments = $8, result_id = $9,
+		result_path = $10, tags = $11, completed_at = $12 WHERE upload_id = $13`,
+		status, e.Error, total, processed, skipped, totalSales, e.TotalDepartments, string(encoded),
+		e.ResultID, e.ResultPath, string(encodedTags), formatHistoryTime(e.At), e.UploadID)
+	return err
+}
+
+// historyColumns are the columns scanned by scanHistory
+const historyColumns = `upload_id, tenant, filename, size, status, error, rows_total, rows_processed, rows_skipped,
+	total_sales, total_departments, departments, result_id, result_path, tags, received_at, completed_at`
+
+// List returns a tenant's records, newest first
+func (hr *HistoryRepository) List(tenant string, limit, offset int) ([]HistoryRecord, error) {
+	rows, err := hr.db.Query(`SELECT `+historyColumns+` FROM upload_history WHERE tenant = $1
+		ORDER BY received_at DESC, upload_id LIMIT $2 OFFSET $3`, tenant, limit, offset)
+	if err != nil {
+		return nil, fmt.Errorf("failed to query history: %w", err)
+	}
+	defer rows.Close()
+
+	records := []HistoryRecord{}
+	for rows.Next() {
+		record, err := scanHistory(rows)
+		if err != nil {
+			return nil, err
+		}
+		records = append(records, record)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, fmt.Errorf("failed to query history: %w", err)
+	}
+	return records, nil
+}
+
+// Get returns the record of a tenant's upload
+func (hr *HistoryRepository) Get(tenant, uploadID string) (HistoryRecord, error) {
+	row := hr.db.QueryRow(`SELECT `+historyColumns+` FROM upload_history WHERE upload_id = $1 AND tenant = $2`, uploadID, tenant)
+	record, err := scanHistory(row)
+	if errors.Is(err, sql.ErrNoRows) {
+		return HistoryRecord{}, fmt.Errorf("%w: %s", ErrHistoryNotFound, uploadID)
+	}
+	return record, err
+}
+
+// scanHistory reads a record selected with historyColumns
+func scanHistory(row interface{ Scan(...any) error }) (HistoryRecord, error) {
+	var record HistoryRecord
+	var total, processed, skipped sql.NullInt64
+	var totalSales, completedAt sql.NullString
+	var departments, tags, receivedAt string
+	err := row.Scan(&record.UploadID, &record.Tenant, &record.Filename, &record.Size, &record.Status, &record.Error,
+		&total, &processed, &skipped, &totalSales, &record.TotalDepartments, &departments,
+		&record.ResultID, &record.ResultPath, &tags, &receivedAt, &completedAt)
+	if err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			return record, err
+		}
+		return record, fmt.Errorf("failed to read history record: %w", err)
+	}
+
+	if total.Valid {
+		record.Rows = &models.RowStats{Total: int(total.Int64), Processed: int(processed.Int64), Skipped: int(skipped.Int64)}
+	}
+	if totalSales.Valid {
+		amount, err := models.ParseAmount(totalSales.String)
+		if err != nil {
+			return record, fmt.Errorf("invalid total sales in history record %s: %w", record.UploadID, err)
+		}
+		record.TotalSales = &amount
+	}
+	if err := json.Unmarshal([]byte(departments), &record.Departments); err != nil {
+		return record, fmt.Errorf("invalid departments in history record %s: %w", record.UploadID, err)
+	}
+	if err := json.Unmarshal([]byte(tags), &record.Tags); err != nil {
+		return record, fmt.Errorf("invalid tags in history record %s: %w", record.UploadID, err)
+	}
+	if record.ReceivedAt, err = time.Parse(historyTimeLayout, receivedAt); err != nil {
+		return record, fmt.Errorf("invalid time in history record %s: %w", record.UploadID, err)
+	}
+	if completedAt.Valid {
+		at, err := time.Parse(historyTimeLayout, completedAt.String)
+		if err != nil {
+			return record, fmt.Errorf("invalid time in history record %s: %w", record.UploadID, err)
+		}
+		record.CompletedAt = &at
+	}
+	return record, nil
+}
+
+func formatHistoryTime(t time.Time) string {
+	if t.IsZero() {
+		t = time.Now()
+	}
+	return t.UTC().Format(historyTimeLayout)
+}
+
+// nullableHistoryTime formats an optional timestamp, nil being NULL
+func nullableHistoryTime(t *time.Time) any {
+	if t == nil {
+		return nil
+	}
+	return formatHistoryTime(*t)
+}
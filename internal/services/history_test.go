@@ -0,0 +1,204 @@
+package services
+
+import (
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/mussietl/csv-sales-api/internal/models"
+	"github.com/sirupsen/logrus"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestHistoryRepository(t *testing.T) {
+	logger := logrus.New()
+	logger.SetLevel(logrus.PanicLevel)
+	path := filepath.Join(t.TempDir(), "history.db")
+	history, err := NewHistoryRepository(path, logger)
+	require.NoError(t, err)
+
+	june, july, other := "11111111-1111-1111-1111-111111111111", "22222222-2222-2222-2222-222222222222", "33333333-3333-3333-3333-333333333333"
+	at := time.Date(2024, 7, 1, 9, 0, 0, 0, time.UTC)
+	total := models.WholeAmount(1350)
+	summaries := []DepartmentSummary{
+		{Department: "Books", TotalSales: models.WholeAmount(1000)},
+		{Department: "Toys", TotalSales: models.WholeAmount(350)},
+	}
+
+	history.Handle(Event{Type: EventUploadReceived, At: at, Tenant: "acme", UploadID: june, Filename: "june.csv", Size: 2048})
+	history.Handle(Event{Type: EventProcessingStarted, At: at, Tenant: "acme", UploadID: june, Filename: "june.csv"})
+	history.Handle(Event{
+		Type: EventProcessingCompleted, At: at.Add(time.Second), Tenant: "acme", UploadID: june, Filename: "june.csv",
+		Success: true, TotalSales: &total, TotalDepartments: 2, Summaries: summaries,
+		Rows: &models.RowStats{Total: 3, Processed: 2, Skipped: 1}, ResultID: "r1", ResultPath: "public/uploads/result_r1.csv",
+	})
+	history.Handle(Event{Type: EventUploadReceived, At: at.Add(time.Hour), Tenant: "acme", UploadID: july, Filename: "july.csv", Size: 10})
+	history.Handle(Event{Type: EventProcessingCompleted, At: at.Add(time.Hour), Tenant: "acme", UploadID: july, Filename: "july.csv", Error: "no sales column"})
+	history.Handle(Event{Type: EventUploadReceived, At: at, Tenant: "globex", UploadID: other, Filename: "q2.csv"})
+	history.Handle(Event{Type: EventUploadReceived, Tenant: "acme", Filename: "live.csv", Ephemeral: true})
+	require.NoError(t, history.Close())
+
+	// Records survive a restart
+	history, err = NewHistoryRepository("sqlite://"+path, logger)
+	require.NoError(t, err)
+	defer history.Close()
+
+	records, err := history.List("acme", 10, 0)
+	require.NoError(t, err)
+	require.Len(t, records, 2)
+	assert.Equal(t, july, records[0].UploadID, "newest first")
+	assert.Equal(t, HistoryFailed, records[0].Status)
+	assert.Equal(t, "no sales column", records[0].Error)
+	assert.Nil(t, records[0].Rows)
+
+	record := records[1]
+	assert.Equal(t, HistoryCompleted, record.Status)
+	assert.Equal(t, int64(2048), record.Size)
+	assert.Equal(t, &models.RowStats{Total: 3, Processed: 2, Skipped: 1}, record.Rows)
+	require.NotNil(t, record.TotalSales)
+	assert.Equal(t, "1350", record.TotalSales.String())
+	assert.Equal(t, summaries, record.Departments)
+	assert.Equal(t, "r1", record.ResultID)
+	assert.Equal(t, "public/uploads/result_r1.csv", record.ResultPath)
+	assert.True(t, record.ReceivedAt.Equal(at))
+	require.NotNil(t, record.CompletedAt)
+	assert.True(t, record.CompletedAt.Equal(at.Add(time.Second)))
+
+	page, err := history.List("acme", 1, 1)
+	require.NoError(t, err)
+	require.Len(t, page, 1)
+	assert.Equal(t, june, page[0].UploadID)
+
+	got, err := history.Get("acme", june)
+	require.NoError(t, err)
+	assert.Equal(t, record, got)
+	_, err = history.Get("acme", other)
+	assert.ErrorIs(t, err, ErrHistoryNotFound, "other tenants' uploads are not found")
+}
+
+func TestHistoryMigrations(t *testing.T) {
+	logger := logrus.New()
+	logger.SetLevel(logrus.PanicLevel)
+	path := filepath.Join(t.TempDir(), "history.db")
+
+	entries, err := migrationFiles.ReadDir("migrations")
+	require.NoError(t, err)
+	require.NotEmpty(t, entries)
+
+	// Reopening applies nothing twice
+	for i := 0; i < 2; i++ {
+		history, err := NewHistoryRepository(path, logger)
+		require.NoError(t, err)
+		var applied int
+		require.NoError(t, history.db.QueryRow(`SELECT COUNT(*) FROM schema_migrations`).Scan(&applied))
+		assert.Equal(t, len(entries), applied)
+		require.NoError(t, history.Close())
+	}
+}
+
+func TestHistoryDSN(t *testing.T) {
+	dsn, err := HistoryDSN("sqlite:///var/lib/csv/history.db")
+	require.NoError(t, err)
+	assert.Equal(t, "/var/lib/csv/history.db", dsn)
+	dsn, err = HistoryDSN("data/history.db")
+	require.NoError(t, err)
+	assert.Equal(t, "data/history.db", dsn)
+
+	for _, url := range []string{"postgres://csv@db/history", "postgresql://csv@db/history", "mysql://db/history"} {
+		_, err := HistoryDSN(url)
+		assert.ErrorIs(t, err, ErrUnsupportedHistoryDatabase, url)
+		_, err = NewHistoryRepository(url, logrus.New())
+		assert.ErrorIs(t, err, ErrUnsupportedHistoryDatabase, url)
+	}
+}
+
+func TestParseTags(t *testing.T) {
+	tags, err := ParseTags(" Q3, q3,region:emea,,fy-2024 ")
+	require.NoError(t, err)
+	assert.Equal(t, []string{"q3", "region:emea", "fy-2024"}, tags)
+
+	tags, err = ParseTags("")
+	require.NoError(t, err)
+	assert.Empty(t, tags)
+
+	for _, invalid := range []string{"two words", "-leading", "a%b", strings.Repeat("x", MaxTagLength+1), "a,b,c,d,e,f,g,h,i,j,k"} {
+		_, err := ParseTags(invalid)
+		assert.Error(t, err, invalid)
+	}
+}
+
+func TestHistorySearch(t *testing.T) {
+	logger := logrus.New()
+	logger.SetLevel(logrus.PanicLevel)
+	history, err := NewHistoryRepository(filepath.Join(t.TempDir(), "history.db"), logger)
+	require.NoError(t, err)
+	defer history.Close()
+
+	at := time.Date(2024, 7, 1, 9, 0, 0, 0, time.UTC)
+	complete := func(tenant, id string, day int, tags []string, departments ...DepartmentSummary) {
+		total := models.Amount{}
+		for _, department := range departments {
+			total = total.Add(department.TotalSales)
+		}
+		received := at.AddDate(0, 0, day)
+		history.Handle(Event{Type: EventUploadReceived, At: received, Tenant: tenant, UploadID: id, Filename: id + ".csv"})
+		history.Handle(Event{
+			Type: EventProcessingCompleted, At: received, Tenant: tenant, UploadID: id, Filename: id + ".csv",
+			Success: true, TotalSales: &total, TotalDepartments: len(departments), Summaries: departments,
+			ResultID: "r-" + id, Tags: tags,
+		})
+	}
+	complete("acme", "june", 0, []string{"q2", "region_emea"},
+		DepartmentSummary{Department: "Electronics", TotalSales: models.WholeAmount(900000)},
+		DepartmentSummary{Department: "Books", TotalSales: models.WholeAmount(200000)})
+	complete("acme", "july", 31, []string{"q3", "regionxemea"},
+		DepartmentSummary{Department: "Consumer Electronics", TotalSales: models.WholeAmount(1200000)},
+		DepartmentSummary{Department: "Books", TotalSales: models.WholeAmount(1000)})
+	complete("acme", "august", 62, nil,
+		DepartmentSummary{Department: "Toys", TotalSales: models.WholeAmount(50)})
+	complete("globex", "other", 31, []string{"q3"},
+		DepartmentSummary{Department: "Electronics", TotalSales: models.WholeAmount(5000000)})
+	history.Handle(Event{Type: EventProcessingCompleted, At: at, Tenant: "acme", UploadID: "failed", Error: "no sales column"})
+
+	ids := func(search ResultSearch, limit, offset int) ([]string, bool) {
+		matches, more, err := history.Search("acme", search, limit, offset)
+		require.NoError(t, err)
+		ids := []string{}
+		for _, match := range matches {
+			ids = append(ids, match.UploadID)
+		}
+		return ids, more
+	}
+
+	all, more := ids(ResultSearch{}, 10, 0)
+	assert.Equal(t, []string{"august", "july", "june"}, all, "completed uploads, newest first")
+	assert.False(t, more)
+	page, more := ids(ResultSearch{}, 2, 1)
+	assert.Equal(t, []string{"july", "june"}, page)
+	assert.False(t, more)
+	page, more = ids(ResultSearch{}, 1, 1)
+	assert.Equal(t, []string{"july"}, page)
+	assert.True(t, more)
+
+	tagged, _ := ids(ResultSearch{Tags: []string{"region_emea"}}, 10, 0)
+	assert.Equal(t, []string{"june"}, tagged, "underscores in tags are not wildcards")
+	tagged, _ = ids(ResultSearch{Tags: []string{"q3", "q2"}}, 10, 0)
+	assert.Empty(t, tagged, "every tag must match")
+
+	million := models.WholeAmount(1000000)
+	matches, _, err := history.Search("acme", ResultSearch{Department: "electronics", MinTotal: &million}, 10, 0)
+	require.NoError(t, err)
+	require.Len(t, matches, 1)
+	assert.Equal(t, "july", matches[0].UploadID)
+	assert.Equal(t, []string{"q3", "regionxemea"}, matches[0].Tags)
+	assert.Equal(t, []DepartmentSummary{{Department: "Consumer Electronics", TotalSales: models.WholeAmount(1200000)}}, matches[0].MatchedDepartments)
+
+	// Without a department, the minimum applies to the upload's total
+	large, _ := ids(ResultSearch{MinTotal: &million}, 10, 0)
+	assert.Equal(t, []string{"july", "june"}, large)
+
+	dated, _ := ids(ResultSearch{From: at.AddDate(0, 0, 1), To: at.AddDate(0, 0, 31)}, 10, 0)
+	assert.Equal(t, []string{"july"}, dated)
+}
@@ -0,0 +1,155 @@
+package services
+
+import (
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/binary"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"os"
+	"strconv"
+	"strings"
+	"sync"
+
+	"github.com/google/uuid"
+)
+
+// ID schemes for the upload, result and output file IDs that appear in public URLs
+const (
+	// IDSchemeUUID uses random version 4 UUIDs
+	IDSchemeUUID = "uuid"
+	// IDSchemeToken uses random 128-bit tokens written as 32 hex digits
+	IDSchemeToken = "token"
+	// IDSchemeSequential numbers files sequentially and publishes each number
+	// encrypted into a 128-bit token, so IDs can't be enumerated or counted
+	IDSchemeSequential = "sequential"
+)
+
+// IDGenerator creates the IDs of stored files
+type IDGenerator interface {
+	NewID() (string, error)
+}
+
+// ValidID reports whether id has the form of an ID made by any scheme: a
+// canonical UUID or 32 lowercase hex digits. IDs of every scheme stay valid,
+// so changing the scheme doesn't break existing links.
+func ValidID(id string) bool {
+	if len(id) == 36 {
+		parsed, err := uuid.Parse(id)
+		return err == nil && parsed.String() == id
+	}
+	if len(id) != 32 {
+		return false
+	}
+	for i := 0; i < len(id); i++ {
+		if !(id[i] >= '0' && id[i] <= '9' || id[i] >= 'a' && id[i] <= 'f') {
+			return false
+		}
+	}
+	return true
+}
+
+// IDConfig selects and configures an ID scheme
+type IDConfig struct {
+	// Scheme is IDSchemeUUID, IDSchemeToken or IDSchemeSequential
+	Scheme string
+	// Secret keys the encryption of sequential IDs
+	Secret string
+}
+
+// NewIDGenerator creates the configured ID generator. Sequential IDs keep
+// their counter in sequencePath.
+func NewIDGenerator(config IDConfig, sequencePath string) (IDGenerator, error) {
+	switch config.Scheme {
+	case "", IDSchemeUUID:
+		return uuidIDs{}, nil
+	case IDSchemeToken:
+		return tokenIDs{}, nil
+	case IDSchemeSequential:
+		return NewSequentialIDs(config.Secret, sequencePath)
+	default:
+		return nil, fmt.Errorf("invalid ID scheme %q: expected uuid, token or sequential", config.Scheme)
+	}
+}
+
+// uuidIDs generates random version 4 UUIDs
+type uuidIDs struct{}
+
+func (uuidIDs) NewID() (string, error) {
+	return uuid.New().String(), nil
+}
+
+// tokenIDs generates random 128-bit tokens
+type tokenIDs struct{}
+
+func (tokenIDs) NewID() (string, error) {
+	var token [16]byte
+	if _, err := rand.Read(token[:]); err != nil {
+		return "", fmt.Errorf("failed to generate ID: %w", err)
+	}
+	return hex.EncodeToString(token[:]), nil
+}
+
+// SequentialIDs numbers files from 1 and publishes each number as a token:
+// the number encrypted with AES in a single block, so tokens look random
+// but never repeat. The last number is saved so numbering continues after a
+// restart.
+type SequentialIDs struct {
+	block cipher.Block
+	path  string
+	mu    sync.Mutex
+	last  uint64
+}
+
+// NewSequentialIDs creates a SequentialIDs keyed by secret, continuing the
+// sequence saved in path
+func NewSequentialIDs(secret, path string) (*SequentialIDs, error) {
+	if secret == "" {
+		return nil, errors.New("sequential IDs need a secret")
+	}
+	key := sha256.Sum256([]byte(secret))
+	block, err := aes.NewCipher(key[:16])
+	if err != nil {
+		return nil, err
+	}
+	s := &SequentialIDs{block: block, path: path}
+
+	data, err := os.ReadFile(path)
+	if err != nil && !errors.Is(err, os.ErrNotExist) {
+		return nil, fmt.Errorf("failed to read ID sequence: %w", err)
+	}
+	if len(data) > 0 {
+		if s.last, err = strconv.ParseUint(strings.TrimSpace(string(data)), 10, 64); err != nil {
+			return nil, fmt.Errorf("invalid ID sequence in %s: %w", path, err)
+		}
+	}
+	return s, nil
+}
+
+// NewID saves the next number and returns its token
+func (s *SequentialIDs) NewID() (string, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	next := s.last + 1
+	if err := s.save(next); err != nil {
+		return "", err
+	}
+	s.last = next
+
+	var plain, token [aes.BlockSize]byte
+	binary.BigEndian.PutUint64(plain[8:], next)
+	s.block.Encrypt(token[:], plain[:])
+	return hex.EncodeToString(token[:]), nil
+}
+
+// save writes the last number used, replacing the file atomically
+func (s *SequentialIDs) save(last uint64) error {
+	if err := writeFileAtomic(s.path, []byte(strconv.FormatUint(last, 10)+"\n"), 0600); err != nil {
+		return fmt.Errorf("failed to save ID sequence: %w", err)
+	}
+	return nil
+}
@@ -0,0 +1,100 @@
+package services
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/sirupsen/logrus"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestValidID(t *testing.T) {
+	assert.True(t, ValidID("12345678-1234-1234-1234-123456789abc"))
+	assert.True(t, ValidID("0123456789abcdef0123456789abcdef"))
+	assert.False(t, ValidID("12345678-1234-1234-1234-123456789ABC"))
+	assert.False(t, ValidID("0123456789ABCDEF0123456789abcdef"))
+	assert.False(t, ValidID("urn:uuid:12345678-1234-1234-1234-123456789abc"))
+	assert.False(t, ValidID("../../etc/passwd"))
+	assert.False(t, ValidID("42"))
+}
+
+func TestIDSchemes(t *testing.T) {
+	dir := t.TempDir()
+	for _, scheme := range []string{"", IDSchemeUUID, IDSchemeToken} {
+		ids, err := NewIDGenerator(IDConfig{Scheme: scheme}, filepath.Join(dir, "seq"))
+		require.NoError(t, err)
+		a, err := ids.NewID()
+		require.NoError(t, err)
+		b, err := ids.NewID()
+		require.NoError(t, err)
+		assert.True(t, ValidID(a), scheme)
+		assert.NotEqual(t, a, b)
+	}
+
+	_, err := NewIDGenerator(IDConfig{Scheme: "hashids"}, "")
+	assert.Error(t, err)
+	_, err = NewIDGenerator(IDConfig{Scheme: IDSchemeSequential}, filepath.Join(dir, "seq"))
+	assert.ErrorContains(t, err, "secret")
+}
+
+func TestSequentialIDs(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "id_sequence")
+	ids, err := NewSequentialIDs("s3cret", path)
+	require.NoError(t, err)
+
+	first, err := ids.NewID()
+	require.NoError(t, err)
+	second, err := ids.NewID()
+	require.NoError(t, err)
+	assert.True(t, ValidID(first))
+	assert.NotEqual(t, first[:8], second[:8], "consecutive tokens should not share a prefix")
+
+	saved, err := os.ReadFile(path)
+	require.NoError(t, err)
+	assert.Equal(t, "2\n", string(saved))
+
+	// Numbering continues after a restart
+	restarted, err := NewSequentialIDs("s3cret", path)
+	require.NoError(t, err)
+	third, err := restarted.NewID()
+	require.NoError(t, err)
+	assert.NotContains(t, []string{first, second}, third)
+	saved, err = os.ReadFile(path)
+	require.NoError(t, err)
+	assert.Equal(t, "3\n", string(saved))
+
+	// Another secret doesn't reproduce the tokens
+	other, err := NewSequentialIDs("other", filepath.Join(t.TempDir(), "id_sequence"))
+	require.NoError(t, err)
+	otherFirst, err := other.NewID()
+	require.NoError(t, err)
+	assert.NotEqual(t, first, otherFirst)
+
+	require.NoError(t, os.WriteFile(path, []byte("garbage"), 0600))
+	_, err = NewSequentialIDs("s3cret", path)
+	assert.Error(t, err)
+}
+
+func TestFileServiceIDGenerator(t *testing.T) {
+	logger := logrus.New()
+	logger.SetLevel(logrus.ErrorLevel)
+	dir := t.TempDir()
+	fs := NewFileService(dir, logger)
+	ids, err := NewIDGenerator(IDConfig{Scheme: IDSchemeSequential, Secret: "s3cret"}, filepath.Join(t.TempDir(), "seq"))
+	require.NoError(t, err)
+	fs.SetIDGenerator(ids)
+
+	path, err := fs.SaveResultFile([]DepartmentSummary{{Department: "Books"}})
+	require.NoError(t, err)
+	resultID := fs.ResultID(path)
+	assert.Len(t, resultID, 32)
+
+	resolved, err := fs.ResultPath(resultID)
+	require.NoError(t, err)
+	assert.Equal(t, path, resolved)
+
+	_, err = fs.ResultPath("1")
+	assert.ErrorIs(t, err, ErrResultNotFound)
+}
@@ -0,0 +1,405 @@
+package services
+
+import (
+	"bufio"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/sirupsen/logrus"
+)
+
+// Intake states, in the order an upload moves through them
+const (
+	IntakeProcessing = "processing"
+	IntakeCompleted  = "completed"
+	IntakeFailed     = "failed"
+	// IntakeDeleted marks a completed upload whose result was deleted,
+	// through the results API or by the retention janitor
+	IntakeDeleted = "deleted"
+)
+
+// Recovery policies for uploads found half-processed at startup
+const (
+	// RecoveryFail marks them failed
+	RecoveryFail = "fail"
+	// RecoveryRetry processes them again with the options they were sent with
+	RecoveryRetry = "retry"
+)
+
+// ErrIntakeNotFound is returned for an upload ID that is not in the intake log
+var ErrIntakeNotFound = errors.New("upload is not in the intake log")
+
+// ErrIntakeNotFailed is returned when requeueing an upload that has not failed
+var ErrIntakeNotFailed = errors.New("upload has not failed")
+
+// ErrIntakeEncrypted is returned when requeueing an encrypted upload, whose
+// encryption options were not logged
+var ErrIntakeEncrypted = errors.New("encrypted uploads are not retried")
+
+// intakeRetention is how long finished entries survive compaction
+const intakeRetention = 7 * 24 * time.Hour
+
+// IntakeEntry is one state change of an upload in the intake log
+type IntakeEntry struct {
+	UploadID string    `json:"upload_id"`
+	Path     string    `json:"path"`
+	Filename string    `json:"filename"`
+	Tenant   string    `json:"tenant"`
+	State    string    `json:"state"`
+	ResultID string    `json:"result_id,omitempty"`
+	Error    string    `json:"error,omitempty"`
+	At       time.Time `json:"at"`
+	// Recovered is set on entries written by startup recovery
+	Recovered bool `json:"recovered,omitempty"`
+	// Params are the upload options the request sent, by name, which retries
+	// process the upload with. Encryption options are left out.
+	Params map[string]string `json:"params,omitempty"`
+	// Encrypted is set for uploads whose results were encrypted; they are
+	// never retried
+	Encrypted bool `json:"encrypted,omitempty"`
+}
+
+// RetryFunc reprocesses a stored upload and returns its result ID
+type RetryFunc func(entry IntakeEntry) (string, error)
+
+// IntakeLog is a write-ahead log of uploads, appended to before processing
+// begins and when it ends, so uploads interrupted by a crash can be found on
+// startup. Each line is a JSON IntakeEntry; the last line per upload wins.
+type IntakeLog struct {
+	path    string
+	mu      sync.Mutex
+	file    *os.File
+	entries map[string]IntakeEntry
+	logger  *logrus.Logger
+}
+
+// NewIntakeLog opens the intake log at path, replaying existing entries
+func NewIntakeLog(path string, logger *logrus.Logger) (*IntakeLog, error) {
+	il := &IntakeLog{path: path, entries: make(map[string]IntakeEntry), logger: logger}
+	if err := il.replay(); err != nil {
+		return nil, err
+	}
+	file, err := os.OpenFile(path, os.O_APPEND|os.O_CREATE|os.O_RDWR, 0600)
+	if err != nil {
+		return nil, fmt.Errorf("failed to open intake log: %w", err)
+	}
+	il.file = file
+
+	// Terminate a torn last line so the next entry starts on its own line
+	if info, err := file.Stat(); err == nil && info.Size() > 0 {
+		last := make([]byte, 1)
+		if _, err := file.ReadAt(last, info.Size()-1); err == nil && last[0] != '\n' {
+			if _, err := file.Write([]byte{'\n'}); err != nil {
+				file.Close()
+				return nil, fmt.Errorf("failed to repair intake log: %w", err)
+			}
+		}
+	}
+	return il, nil
+}
+
+// ReadIntakeEntries returns the latest entry of every upload in the log at
+// path without opening it for writing, so it is safe to use while a server
+// has the log open
+func ReadIntakeEntries(path string, logger *logrus.Logger) ([]IntakeEntry, error) {
+	il := &IntakeLog{path: path, entries: make(map[string]IntakeEntry), logger: logger}
+	if err := il.replay(); err != nil {
+		return nil, err
+	}
+	return il.List(""), nil
+}
+
+// replay loads the latest entry of every upload. Unreadable lines, such as
+// one torn by a crash mid-write, are skipped.
+func (il *IntakeLog) replay() error {
+	file, err := os.Open(il.path)
+	if errors.Is(err, os.ErrNotExist) {
+		return nil
+	}
+	if err != nil {
+		return fmt.Errorf("failed to read intake log: %w", err)
+	}
+	defer file.Close()
+
+	scanner := bufio.NewScanner(file)
+	scanner.Buffer(make([]byte, 64<<10), 1<<20)
+	line := 0
+	for scanner.Scan() {
+		line++
+		var entry IntakeEntry
+		if err := json.Unmarshal(scanner.Bytes(), &entry); err != nil {
+			il.logger.Warnf("Ignoring unreadable intake log line %d: %v", line, err)
+			continue
+		}
+		il.entries[entry.UploadID] = entry
+	}
+	if err := scanner.Err(); err != nil {
+		return fmt.Errorf("failed to read intake log: %w", err)
+	}
+	return nil
+}
+
+// Begin records that an upload was received and is about to be processed
+func (il *IntakeLog) Begin(uploadID, path, filename, tenant string) error {
+	return il.BeginEntry(IntakeEntry{UploadID: uploadID, Path: path, Filename: filename, Tenant: tenant})
+}
+
+// BeginEntry is Begin for an entry that also carries the upload's options
+func (il *IntakeLog) BeginEntry(entry IntakeEntry) error {
+	entry.State, entry.ResultID, entry.Error, entry.Recovered = IntakeProcessing, "", "", false
+	return il.append(entry)
+}
+
+// Complete records that an upload was processed into a result
+func (il *IntakeLog) Complete(uploadID, resultID string) error {
+	return il.transition(uploadID, IntakeCompleted, resultID, "", false)
+}
+
+// Fail records that processing an upload failed
+func (il *IntakeLog) Fail(uploadID, reason string) error {
+	return il.transition(uploadID, IntakeFailed, "", reason, false)
+}
+
+// MarkDeleted records that an upload's result was deleted, keeping its result ID
+func (il *IntakeLog) MarkDeleted(uploadID string) error {
+	il.mu.Lock()
+	entry, ok := il.entries[uploadID]
+	il.mu.Unlock()
+	if !ok {
+		return fmt.Errorf("%w: %s", ErrIntakeNotFound, uploadID)
+	}
+	return il.transition(uploadID, IntakeDeleted, entry.ResultID, "", false)
+}
+
+// Entry returns the latest entry of an upload
+func (il *IntakeLog) Entry(uploadID string) (IntakeEntry, bool) {
+	il.mu.Lock()
+	defer il.mu.Unlock()
+	entry, ok := il.entries[uploadID]
+	return entry, ok
+}
+
+// EntryByResult returns the latest entry of the upload processed into a result
+func (il *IntakeLog) EntryByResult(resultID string) (IntakeEntry, bool) {
+	il.mu.Lock()
+	defer il.mu.Unlock()
+	for _, entry := range il.entries {
+		if entry.ResultID == resultID {
+			return entry, true
+		}
+	}
+	return IntakeEntry{}, false
+}
+
+// transition appends a new state for a known upload
+func (il *IntakeLog) transition(uploadID, state, resultID, reason string, recovered bool) error {
+	il.mu.Lock()
+	entry, ok := il.entries[uploadID]
+	il.mu.Unlock()
+	if !ok {
+		return fmt.Errorf("%w: %s", ErrIntakeNotFound, uploadID)
+	}
+	entry.State, entry.ResultID, entry.Error, entry.Recovered = state, resultID, reason, recovered
+	return il.append(entry)
+}
+
+// append writes an entry and syncs it to disk before returning
+func (il *IntakeLog) append(entry IntakeEntry) error {
+	entry.At = time.Now().UTC()
+	data, err := json.Marshal(entry)
+	if err != nil {
+		return fmt.Errorf("failed to encode intake entry: %w", err)
+	}
+
+	il.mu.Lock()
+	defer il.mu.Unlock()
+	if _, err := il.file.Write(append(data, '\n')); err != nil {
+		return fmt.Errorf("failed to write intake log: %w", err)
+	}
+	if err := il.file.Sync(); err != nil {
+		return fmt.Errorf("failed to sync intake log: %w", err)
+	}
+	il.entries[entry.UploadID] = entry
+	return nil
+}
+
+// List returns the latest entry of every upload, newest first, optionally
+// only those in state
+func (il *IntakeLog) List(state string) []IntakeEntry {
+	il.mu.Lock()
+	defer il.mu.Unlock()
+	list := make([]IntakeEntry, 0, len(il.entries))
+	for _, entry := range il.entries {
+		if state == "" || entry.State == state {
+			list = append(list, entry)
+		}
+	}
+	sort.Slice(list, func(i, j int) bool { return list[i].At.After(list[j].At) })
+	return list
+}
+
+// IntakeStats counts uploads by their latest state
+type IntakeStats struct {
+	Processing int `json:"processing"`
+	Completed  int `json:"completed"`
+	Failed     int `json:"failed"`
+	// FailureRate is the fraction of finished uploads that failed; 0 when none finished
+	FailureRate float64 `json:"failure_rate"`
+}
+
+// Stats counts the uploads whose latest entry was logged at or after since
+func (il *IntakeLog) Stats(since time.Time) IntakeStats {
+	il.mu.Lock()
+	defer il.mu.Unlock()
+	var stats IntakeStats
+	for _, entry := range il.entries {
+		if entry.At.Before(since) {
+			continue
+		}
+		switch entry.State {
+		case IntakeProcessing:
+			stats.Processing++
+		case IntakeCompleted:
+			stats.Completed++
+		case IntakeFailed:
+			stats.Failed++
+		}
+	}
+	if finished := stats.Completed + stats.Failed; finished > 0 {
+		stats.FailureRate = float64(stats.Failed) / float64(finished)
+	}
+	return stats
+}
+
+// Recover handles uploads left processing by a previous run: with
+// RecoveryRetry they are reprocessed by retry, otherwise, or if the retry
+// fails, the upload file is gone or the upload was encrypted, they are
+// marked failed. It returns how
+// many uploads were recovered. Uploads in resumed are left processing, since
+// their jobs are run again by JobQueue.Recover.
+func (il *IntakeLog) Recover(policy string, retry RetryFunc, resumed map[string]bool) int {
+	var pending []IntakeEntry
+	for _, entry := range il.List(IntakeProcessing) {
+		if !resumed[entry.UploadID] {
+			pending = append(pending, entry)
+		}
+	}
+	for _, entry := range pending {
+		reason := "interrupted by a server restart"
+		if _, err := os.Stat(entry.Path); err != nil {
+			reason = "interrupted by a server restart; upload file is missing"
+		} else if entry.Encrypted {
+			reason = "interrupted by a server restart; " + ErrIntakeEncrypted.Error()
+		} else if policy == RecoveryRetry {
+			resultID, err := retry(entry)
+			if err == nil {
+				il.logger.Infof("Recovered upload %s by reprocessing it into result %s", entry.UploadID, resultID)
+				if err := il.transition(entry.UploadID, IntakeCompleted, resultID, "", true); err != nil {
+					il.logger.Errorf("Failed to record recovery of upload %s: %v", entry.UploadID, err)
+				}
+				continue
+			}
+			reason = "interrupted by a server restart; retry failed: " + err.Error()
+		}
+		il.logger.Warnf("Marking interrupted upload %s (%s) failed", entry.UploadID, entry.Filename)
+		if err := il.transition(entry.UploadID, IntakeFailed, "", reason, true); err != nil {
+			il.logger.Errorf("Failed to record recovery of upload %s: %v", entry.UploadID, err)
+		}
+	}
+	return len(pending)
+}
+
+// Requeue processes a failed upload again with retry. The upload is logged as
+// processing first, so a crash during the retry is handled by Recover. If the
+// retry fails, the upload is marked failed again and its entry is returned
+// along with the error. Encrypted uploads are refused with ErrIntakeEncrypted.
+func (il *IntakeLog) Requeue(uploadID string, retry RetryFunc) (IntakeEntry, error) {
+	il.mu.Lock()
+	entry, ok := il.entries[uploadID]
+	il.mu.Unlock()
+	if !ok {
+		return IntakeEntry{}, fmt.Errorf("%w: %s", ErrIntakeNotFound, uploadID)
+	}
+	if entry.State != IntakeFailed {
+		return IntakeEntry{}, fmt.Errorf("%w: %s is %s", ErrIntakeNotFailed, uploadID, entry.State)
+	}
+	if entry.Encrypted {
+		return IntakeEntry{}, fmt.Errorf("%w: %s", ErrIntakeEncrypted, uploadID)
+	}
+	if _, err := os.Stat(entry.Path); err != nil {
+		return IntakeEntry{}, fmt.Errorf("upload file of %s is missing: %w", uploadID, err)
+	}
+
+	if err := il.transition(uploadID, IntakeProcessing, "", "", false); err != nil {
+		return IntakeEntry{}, err
+	}
+	resultID, retryErr := retry(entry)
+	var err error
+	if retryErr != nil {
+		err = il.transition(uploadID, IntakeFailed, "", "requeue failed: "+retryErr.Error(), false)
+	} else {
+		err = il.transition(uploadID, IntakeCompleted, resultID, "", false)
+	}
+	if err != nil {
+		return IntakeEntry{}, err
+	}
+
+	il.mu.Lock()
+	entry = il.entries[uploadID]
+	il.mu.Unlock()
+	if retryErr != nil {
+		return entry, fmt.Errorf("failed to reprocess upload %s: %w", uploadID, retryErr)
+	}
+	il.logger.Infof("Requeued upload %s into result %s", uploadID, resultID)
+	return entry, nil
+}
+
+// Compact rewrites the log with the latest entry per upload, dropping
+// finished entries older than the retention period
+func (il *IntakeLog) Compact() error {
+	il.mu.Lock()
+	defer il.mu.Unlock()
+
+	cutoff := time.Now().Add(-intakeRetention)
+	kept := make([]IntakeEntry, 0, len(il.entries))
+	for id, entry := range il.entries {
+		if entry.State != IntakeProcessing && entry.At.Before(cutoff) {
+			delete(il.entries, id)
+			continue
+		}
+		kept = append(kept, entry)
+	}
+	sort.Slice(kept, func(i, j int) bool { return kept[i].At.Before(kept[j].At) })
+
+	var data []byte
+	for _, entry := range kept {
+		line, err := json.Marshal(entry)
+		if err != nil {
+			return fmt.Errorf("failed to encode intake entry: %w", err)
+		}
+		data = append(append(data, line...), '\n')
+	}
+	if err := writeFileAtomic(il.path, data, 0600); err != nil {
+		return err
+	}
+
+	file, err := os.OpenFile(il.path, os.O_APPEND|os.O_CREATE|os.O_WRONLY, 0600)
+	if err != nil {
+		return fmt.Errorf("failed to reopen intake log: %w", err)
+	}
+	il.file.Close()
+	il.file = file
+	return nil
+}
+
+// Close closes the log file
+func (il *IntakeLog) Close() error {
+	il.mu.Lock()
+	defer il.mu.Unlock()
+	return il.file.Close()
+}
@@ -0,0 +1,225 @@
+package services
+
+import (
+	"errors"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/sirupsen/logrus"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestIntakeLogRecover(t *testing.T) {
+	logger := logrus.New()
+	logger.SetLevel(logrus.ErrorLevel)
+	dir := t.TempDir()
+	logPath := filepath.Join(dir, "intake.log")
+
+	upload := func(name string) string {
+		path := filepath.Join(dir, name)
+		require.NoError(t, os.WriteFile(path, []byte("Department,Sales\nBooks,1\n"), 0600))
+		return path
+	}
+
+	il, err := NewIntakeLog(logPath, logger)
+	require.NoError(t, err)
+	require.NoError(t, il.Begin("done", upload("done.csv"), "done.csv", "acme"))
+	require.NoError(t, il.Complete("done", "r1"))
+	require.NoError(t, il.Begin("crashed", upload("crashed.csv"), "crashed.csv", "acme"))
+	require.NoError(t, il.Begin("gone", filepath.Join(dir, "gone.csv"), "gone.csv", "acme"))
+	require.NoError(t, il.Begin("broken", upload("broken.csv"), "broken.csv", "acme"))
+	assert.Error(t, il.Complete("unknown", "r"))
+	require.NoError(t, il.Close())
+
+	// Simulate a torn write at the moment of the crash
+	f, err := os.OpenFile(logPath, os.O_APPEND|os.O_WRONLY, 0600)
+	require.NoError(t, err)
+	_, err = f.WriteString(`{"upload_id":"torn","sta`)
+	require.NoError(t, err)
+	require.NoError(t, f.Close())
+
+	il, err = NewIntakeLog(logPath, logger)
+	require.NoError(t, err)
+	assert.Len(t, il.List(IntakeProcessing), 3)
+	// Entries appended after the torn line are still readable
+	require.NoError(t, il.Begin("after", upload("after.csv"), "after.csv", "acme"))
+	require.NoError(t, il.Fail("after", "rejected"))
+	require.NoError(t, il.Close())
+	il, err = NewIntakeLog(logPath, logger)
+	require.NoError(t, err)
+	assert.Len(t, il.List(IntakeFailed), 1)
+
+	var retried []string
+	n := il.Recover(RecoveryRetry, func(entry IntakeEntry) (string, error) {
+		retried = append(retried, entry.UploadID)
+		if entry.UploadID == "broken" {
+			return "", errors.New("no valid data rows")
+		}
+		return "r2", nil
+	}, nil)
+	assert.Equal(t, 3, n)
+	assert.ElementsMatch(t, []string{"crashed", "broken"}, retried, "missing files are not retried")
+	assert.Empty(t, il.List(IntakeProcessing))
+
+	states := make(map[string]IntakeEntry)
+	for _, entry := range il.List("") {
+		states[entry.UploadID] = entry
+	}
+	assert.Equal(t, IntakeCompleted, states["done"].State)
+	assert.False(t, states["done"].Recovered)
+	assert.Equal(t, IntakeCompleted, states["crashed"].State)
+	assert.Equal(t, "r2", states["crashed"].ResultID)
+	assert.True(t, states["crashed"].Recovered)
+	assert.Equal(t, IntakeFailed, states["gone"].State)
+	assert.Contains(t, states["gone"].Error, "missing")
+	assert.Contains(t, states["broken"].Error, "retry failed: no valid data rows")
+
+	// Compaction keeps one line per upload and the log stays appendable
+	require.NoError(t, il.Compact())
+	require.NoError(t, il.Begin("next", upload("next.csv"), "next.csv", "acme"))
+	require.NoError(t, il.Close())
+	il, err = NewIntakeLog(logPath, logger)
+	require.NoError(t, err)
+	assert.Len(t, il.List(""), 6)
+	assert.Len(t, il.List(IntakeProcessing), 1)
+	require.NoError(t, il.Close())
+}
+
+func TestIntakeLogRecoverFailPolicy(t *testing.T) {
+	logger := logrus.New()
+	logger.SetLevel(logrus.ErrorLevel)
+	dir := t.TempDir()
+	path := filepath.Join(dir, "sales.csv")
+	require.NoError(t, os.WriteFile(path, []byte("x"), 0600))
+
+	il, err := NewIntakeLog(filepath.Join(dir, "intake.log"), logger)
+	require.NoError(t, err)
+	defer il.Close()
+	require.NoError(t, il.Begin("u1", path, "sales.csv", "acme"))
+	require.NoError(t, il.Begin("u2", path, "sales.csv", "acme"))
+
+	n := il.Recover(RecoveryFail, func(IntakeEntry) (string, error) {
+		t.Fatal("fail policy must not retry")
+		return "", nil
+	}, map[string]bool{"u2": true})
+	assert.Equal(t, 1, n)
+	entries := il.List(IntakeFailed)
+	require.Len(t, entries, 1)
+	assert.Equal(t, "interrupted by a server restart", entries[0].Error)
+	entries = il.List(IntakeProcessing)
+	require.Len(t, entries, 1)
+	assert.Equal(t, "u2", entries[0].UploadID, "uploads of resumed jobs are left to the job queue")
+}
+
+func TestIntakeLogRequeue(t *testing.T) {
+	logger := logrus.New()
+	logger.SetLevel(logrus.ErrorLevel)
+	dir := t.TempDir()
+	logPath := filepath.Join(dir, "intake.log")
+	path := filepath.Join(dir, "sales.csv")
+	require.NoError(t, os.WriteFile(path, []byte("x"), 0600))
+
+	il, err := NewIntakeLog(logPath, logger)
+	require.NoError(t, err)
+	defer il.Close()
+	require.NoError(t, il.Begin("u1", path, "sales.csv", "acme"))
+	require.NoError(t, il.Begin("u2", filepath.Join(dir, "gone.csv"), "gone.csv", "acme"))
+	require.NoError(t, il.Fail("u2", "rejected"))
+
+	retry := func(entry IntakeEntry) (string, error) {
+		assert.Equal(t, IntakeProcessing, il.List(IntakeProcessing)[0].State, "logged as processing during the retry")
+		return "", errors.New("still broken")
+	}
+	_, err = il.Requeue("u1", retry)
+	assert.ErrorIs(t, err, ErrIntakeNotFailed)
+	_, err = il.Requeue("unknown", retry)
+	assert.ErrorIs(t, err, ErrIntakeNotFound)
+	_, err = il.Requeue("u2", retry)
+	assert.ErrorContains(t, err, "missing")
+
+	require.NoError(t, il.Fail("u1", "rejected"))
+	entry, err := il.Requeue("u1", retry)
+	assert.ErrorContains(t, err, "still broken")
+	assert.Equal(t, IntakeFailed, entry.State)
+	assert.Equal(t, "requeue failed: still broken", entry.Error)
+
+	entry, err = il.Requeue("u1", func(IntakeEntry) (string, error) { return "r1", nil })
+	require.NoError(t, err)
+	assert.Equal(t, IntakeCompleted, entry.State)
+	assert.Equal(t, "r1", entry.ResultID)
+
+	// The log can be read without opening it for writing
+	entries, err := ReadIntakeEntries(logPath, logger)
+	require.NoError(t, err)
+	assert.Len(t, entries, 2)
+	entries, err = ReadIntakeEntries(filepath.Join(dir, "missing.log"), logger)
+	require.NoError(t, err)
+	assert.Empty(t, entries)
+}
+
+func TestIntakeLogUploadOptions(t *testing.T) {
+	logger := logrus.New()
+	logger.SetLevel(logrus.ErrorLevel)
+	dir := t.TempDir()
+	logPath := filepath.Join(dir, "intake.log")
+	path := filepath.Join(dir, "sales.csv")
+	require.NoError(t, os.WriteFile(path, []byte("x"), 0600))
+
+	il, err := NewIntakeLog(logPath, logger)
+	require.NoError(t, err)
+	params := map[string]string{"delimiter": ";", "metric": "count"}
+	require.NoError(t, il.BeginEntry(IntakeEntry{UploadID: "plain", Path: path, Filename: "sales.csv", Tenant: "acme", Params: params}))
+	require.NoError(t, il.BeginEntry(IntakeEntry{UploadID: "sealed", Path: path, Filename: "sales.csv", Tenant: "acme", Encrypted: true}))
+	require.NoError(t, il.Close())
+
+	il, err = NewIntakeLog(logPath, logger)
+	require.NoError(t, err)
+	defer il.Close()
+	var retried []IntakeEntry
+	retry := func(entry IntakeEntry) (string, error) {
+		retried = append(retried, entry)
+		return "r1", nil
+	}
+	assert.Equal(t, 2, il.Recover(RecoveryRetry, retry, nil))
+
+	// The options survive a restart and are handed to the retry; encrypted
+	// uploads are failed without one
+	require.Len(t, retried, 1)
+	assert.Equal(t, "plain", retried[0].UploadID)
+	assert.Equal(t, params, retried[0].Params)
+	entry, _ := il.Entry("plain")
+	assert.Equal(t, params, entry.Params, "kept by later states")
+	entry, _ = il.Entry("sealed")
+	assert.Equal(t, IntakeFailed, entry.State)
+	assert.Contains(t, entry.Error, ErrIntakeEncrypted.Error())
+
+	_, err = il.Requeue("sealed", retry)
+	assert.ErrorIs(t, err, ErrIntakeEncrypted)
+	assert.Len(t, retried, 1)
+}
+
+func TestIntakeLogStats(t *testing.T) {
+	logger := logrus.New()
+	logger.SetLevel(logrus.ErrorLevel)
+	il, err := NewIntakeLog(filepath.Join(t.TempDir(), "intake.log"), logger)
+	require.NoError(t, err)
+	defer il.Close()
+
+	assert.Equal(t, IntakeStats{}, il.Stats(time.Time{}))
+	for _, id := range []string{"u1", "u2", "u3", "u4"} {
+		require.NoError(t, il.Begin(id, id+".csv", id+".csv", "acme"))
+	}
+	require.NoError(t, il.Complete("u1", "r1"))
+	require.NoError(t, il.Complete("u2", "r2"))
+	require.NoError(t, il.Fail("u3", "rejected"))
+
+	stats := il.Stats(time.Now().Add(-time.Hour))
+	assert.Equal(t, 1, stats.Processing)
+	assert.Equal(t, 2, stats.Completed)
+	assert.Equal(t, 1, stats.Failed)
+	assert.InDelta(t, 1.0/3, stats.FailureRate, 1e-9)
+	assert.Equal(t, IntakeStats{}, il.Stats(time.Now().Add(time.Hour)))
+}
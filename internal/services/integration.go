@@ -0,0 +1,245 @@
+package services
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"github.com/mussietl/csv-sales-api/internal/models"
+)
+
+// The integration schema's name and version. The version changes whenever a
+// key is renamed, removed or changes type; new keys may appear without one.
+const (
+	IntegrationSchemaName    = "csv-sales-api/integration"
+	IntegrationSchemaVersion = "1"
+)
+
+// Record types of the integration schema
+const (
+	IntegrationRecordDepartment  = "department"
+	IntegrationRecordAggregation = "aggregation"
+)
+
+// IntegrationDocument is a result in the integration schema: a versioned
+// envelope around a list of flat records, for automation tools that map
+// JSON keys to fields. Decimals are strings, so no precision is lost to
+// floating point, and times are RFC 3339 in UTC.
+type IntegrationDocument struct {
+	Schema        string              `json:"schema"`
+	SchemaVersion string              `json:"schema_version"`
+	ResultID      string              `json:"result_id"`
+	UploadID      string              `json:"upload_id"`
+	ProcessedAt   string              `json:"processed_at"`
+	RecordCount   int                 `json:"record_count"`
+	Records       []IntegrationRecord `json:"records"`
+}
+
+// IntegrationRecord is one flat record; values are strings or integers
+type IntegrationRecord map[string]any
+
+// NewIntegrationDocument builds an integration document from department
+// totals and aggregations, either of which may be empty
+func NewIntegrationDocument(metadata ResultMetadata, summaries []DepartmentSummary, aggregations []*models.AggregationResult, format NumberFormat) (*IntegrationDocument, error) {
+	doc := &IntegrationDocument{
+		Schema:        IntegrationSchemaName,
+		SchemaVersion: IntegrationSchemaVersion,
+		UploadID:      metadata.UploadID,
+		ProcessedAt:   metadata.ProcessedAt.UTC().Format(time.RFC3339),
+		Records:       []IntegrationRecord{},
+	}
+
+	for _, summary := range summaries {
+		record := IntegrationRecord{
+			"record_type": IntegrationRecordDepartment,
+			"department":  summary.Department,
+			"total_sales": format.FormatAmount(summary.TotalSales),
+			"sales_count": summary.SalesCount,
+		}
+		for _, dc := range summary.DistinctCounts {
+			record.set("distinct_"+integrationKey(dc.Column), dc.Count)
+		}
+		if stats := summary.Stats; stats != nil {
+			for i, value := range []models.Amount{stats.Avg, stats.Median, stats.P90, stats.P95, stats.Min, stats.Max, stats.StdDev} {
+				record[integrationStatsKeys[i]] = format.FormatAmount(value)
+			}
+		}
+		for _, dim := range summary.Dimensions {
+			record.set("dimension_"+integrationKey(dim.Column), dim.Value)
+		}
+		doc.Records = append(doc.Records, record)
+	}
+
+	for _, aggregation := range aggregations {
+		label := fmt.Sprintf("%s(%s) by %s", aggregation.Function, aggregation.Column, strings.Join(aggregation.GroupBy, ","))
+		for _, group := range aggregation.Groups {
+			value, err := format.FormatDecimal(group.Exact)
+			if err != nil {
+				return nil, err
+			}
+			record := IntegrationRecord{
+				"record_type": IntegrationRecordAggregation,
+				"aggregation": label,
+				"function":    aggregation.Function,
+				"column":      aggregation.Column,
+				"value":       value,
+				"rows":        group.Rows,
+			}
+			for i, column := range aggregation.GroupBy {
+				record.set("group_"+integrationKey(column), group.Key[i])
+			}
+			doc.Records = append(doc.Records, record)
+		}
+	}
+
+	doc.RecordCount = len(doc.Records)
+	return doc, nil
+}
+
+// integrationStatsKeys are the department record keys of models.SalesStats,
+// in field order
+var integrationStatsKeys = []string{"sale_avg", "sale_median", "sale_p90", "sale_p95", "sale_min", "sale_max", "sale_stddev"}
+
+// set adds a key taken from a column name, numbering it if another column
+// already produced the same key
+func (r IntegrationRecord) set(key string, value any) {
+	unique := key
+	for n := 2; r[unique] != nil; n++ {
+		unique = fmt.Sprintf("%s_%d", key, n)
+	}
+	r[unique] = value
+}
+
+// integrationKey turns a column name into a lowercase key of letters,
+// digits and underscores, e.g. "Store Region" into "store_region"
+func integrationKey(column string) string {
+	var b strings.Builder
+	underscore := false
+	for _, r := range strings.ToLower(column) {
+		if (r >= 'a' && r <= 'z') || (r >= '0' && r <= '9') {
+			if underscore && b.Len() > 0 {
+				b.WriteByte('_')
+			}
+			b.WriteRune(r)
+			underscore = false
+		} else {
+			underscore = true
+		}
+	}
+	if b.Len() == 0 {
+		return "column"
+	}
+	return b.String()
+}
+
+// SaveIntegrationResultFile saves department totals and aggregations to a
+// result_<uuid>.json file in the integration schema
+func (fs *FileService) SaveIntegrationResultFile(metadata ResultMetadata, summaries []DepartmentSummary, aggregations []*models.AggregationResult, format NumberFormat) (string, error) {
+	doc, err := NewIntegrationDocument(metadata, summaries, aggregations, format)
+	if err != nil {
+		return "", err
+	}
+	uniqueID, err := fs.newID()
+	if err != nil {
+		return "", err
+	}
+	doc.ResultID = uniqueID
+	data, err := json.MarshalIndent(doc, "", "  ")
+	if err != nil {
+		return "", fmt.Errorf("failed to encode result: %w", err)
+	}
+
+	filePath := filepath.Join(fs.uploadsDir, fmt.Sprintf("result_%s.json", uniqueID))
+	if err := os.WriteFile(filePath, append(data, '\n'), 0644); err != nil {
+		fs.logger.Errorf("Failed to write result file: %v", err)
+		return "", fmt.Errorf("failed to write result file: %w", err)
+	}
+	if err := fs.Persist(filePath); err != nil {
+		fs.logger.Errorf("Failed to store result file: %v", err)
+		os.Remove(filePath)
+		return "", err
+	}
+
+	fs.logger.Infof("Integration result file saved successfully: %s", filePath)
+	return filePath, nil
+}
+
+// OutputSchema returns the JSON Schema describing an output format, if it
+// has one
+func OutputSchema(format string) (map[string]any, bool) {
+	if format != OutputFormatIntegrationJSON {
+		return nil, false
+	}
+	return integrationJSONSchema, true
+}
+
+// integrationJSONSchema describes IntegrationDocument as JSON Schema
+var integrationJSONSchema = map[string]any{
+	"$schema":     "https://json-schema.org/draft/2020-12/schema",
+	"$id":         IntegrationSchemaName + "/v" + IntegrationSchemaVersion,
+	"title":       "Sales result (integration schema v" + IntegrationSchemaVersion + ")",
+	"description": "A processed upload as flat records. Decimals are strings to keep their exact value; times are RFC 3339 in UTC.",
+	"type":        "object",
+	"required":    []string{"schema", "schema_version", "result_id", "upload_id", "processed_at", "record_count", "records"},
+	"properties": map[string]any{
+		"schema":         map[string]any{"const": IntegrationSchemaName},
+		"schema_version": map[string]any{"const": IntegrationSchemaVersion},
+		"result_id":      map[string]any{"type": "string"},
+		"upload_id":      map[string]any{"type": "string"},
+		"processed_at":   map[string]any{"type": "string", "format": "date-time"},
+		"record_count":   map[string]any{"type": "integer", "minimum": 0},
+		"records": map[string]any{
+			"type": "array",
+			"items": map[string]any{
+				"oneOf": []any{
+					map[string]any{
+						"description": "A department's totals",
+						"type":        "object",
+						"required":    []string{"record_type", "department", "total_sales", "sales_count"},
+						"properties": map[string]any{
+							"record_type": map[string]any{"const": IntegrationRecordDepartment},
+							"department":  map[string]any{"type": "string"},
+							"total_sales": decimalSchema,
+							"sales_count": map[string]any{"type": "integer"},
+							"sale_avg":    decimalSchema,
+							"sale_median": decimalSchema,
+							"sale_p90":    decimalSchema,
+							"sale_p95":    decimalSchema,
+							"sale_min":    decimalSchema,
+							"sale_max":    decimalSchema,
+							"sale_stddev": decimalSchema,
+						},
+						"patternProperties": map[string]any{
+							"^distinct_[a-z0-9_]+$":  map[string]any{"type": "integer", "description": "Distinct values of a requested column"},
+							"^dimension_[a-z0-9_]+$": map[string]any{"type": "string", "description": "An attribute joined from the department table"},
+						},
+						"additionalProperties": false,
+					},
+					map[string]any{
+						"description": "One group of an aggregation",
+						"type":        "object",
+						"required":    []string{"record_type", "aggregation", "function", "column", "value", "rows"},
+						"properties": map[string]any{
+							"record_type": map[string]any{"const": IntegrationRecordAggregation},
+							"aggregation": map[string]any{"type": "string", "description": "The aggregation, e.g. sum(Number of Sales) by Region"},
+							"function":    map[string]any{"enum": []string{AggregationSum, AggregationAvg, AggregationCount, AggregationMin, AggregationMax}},
+							"column":      map[string]any{"type": "string"},
+							"value":       decimalSchema,
+							"rows":        map[string]any{"type": "integer"},
+						},
+						"patternProperties": map[string]any{
+							"^group_[a-z0-9_]+$": map[string]any{"type": "string", "description": "The group's value of a group-by column"},
+						},
+						"additionalProperties": false,
+					},
+				},
+			},
+		},
+	},
+}
+
+// decimalSchema describes a decimal written as a string
+var decimalSchema = map[string]any{"type": "string", "pattern": `^-?[0-9]+(\.[0-9]+)?$`}
@@ -0,0 +1,101 @@
+package services
+
+import (
+	"encoding/json"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/mussietl/csv-sales-api/internal/models"
+	"github.com/sirupsen/logrus"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestIntegrationKey(t *testing.T) {
+	for column, key := range map[string]string{
+		"Region":         "region",
+		"Store Region":   "store_region",
+		"  Größe (cm) ":  "gr_e_cm",
+		"Q1-2024 Sales!": "q1_2024_sales",
+		"€":              "column",
+	} {
+		assert.Equal(t, key, integrationKey(column), column)
+	}
+}
+
+func TestSaveIntegrationResultFile(t *testing.T) {
+	logger := logrus.New()
+	logger.SetLevel(logrus.ErrorLevel)
+	dir := t.TempDir()
+	fs := NewFileService(dir, logger)
+
+	summaries := []DepartmentSummary{{
+		Department:     "Books",
+		TotalSales:     amount(t, "10.5"),
+		SalesCount:     3,
+		DistinctCounts: []DistinctCount{{Column: "Store", Count: 2}},
+		Dimensions:     []DimensionValue{{Column: "Region", Value: "North"}, {Column: "region", Value: "N"}},
+	}}
+	aggregations := []*models.AggregationResult{{
+		GroupBy:  []string{"Region", "Store Name"},
+		Function: AggregationAvg,
+		Column:   "Number of Sales",
+		Groups:   []models.AggregationGroup{{Key: []string{"North", "A"}, Rows: 3, Exact: "3.333333333"}},
+	}}
+	processedAt := time.Date(2024, 3, 1, 12, 30, 0, 0, time.FixedZone("CET", 3600))
+
+	path, err := fs.SaveIntegrationResultFile(ResultMetadata{UploadID: "u1", ProcessedAt: processedAt}, summaries, aggregations, NumberFormat{Precision: 2, Rounding: RoundHalfUp})
+	require.NoError(t, err)
+	assert.Equal(t, ".json", filepath.Ext(path))
+
+	resolved, err := fs.ResultPath(fs.ResultID(path))
+	require.NoError(t, err)
+	assert.Equal(t, path, resolved)
+
+	data, err := os.ReadFile(path)
+	require.NoError(t, err)
+	var doc map[string]any
+	require.NoError(t, json.Unmarshal(data, &doc))
+	assert.Equal(t, IntegrationSchemaName, doc["schema"])
+	assert.Equal(t, IntegrationSchemaVersion, doc["schema_version"])
+	assert.Equal(t, fs.ResultID(path), doc["result_id"])
+	assert.Equal(t, "2024-03-01T11:30:00Z", doc["processed_at"])
+	assert.Equal(t, float64(2), doc["record_count"])
+
+	records := doc["records"].([]any)
+	assert.Equal(t, map[string]any{
+		"record_type":      "department",
+		"department":       "Books",
+		"total_sales":      "10.50",
+		"sales_count":      float64(3),
+		"distinct_store":   float64(2),
+		"dimension_region": "North",
+		// Columns that map to the same key are numbered
+		"dimension_region_2": "N",
+	}, records[0])
+	assert.Equal(t, map[string]any{
+		"record_type":      "aggregation",
+		"aggregation":      "avg(Number of Sales) by Region,Store Name",
+		"function":         "avg",
+		"column":           "Number of Sales",
+		"value":            "3.33",
+		"rows":             float64(3),
+		"group_region":     "North",
+		"group_store_name": "A",
+	}, records[1])
+}
+
+func TestOutputSchema(t *testing.T) {
+	schema, ok := OutputSchema(OutputFormatIntegrationJSON)
+	require.True(t, ok)
+	_, err := json.Marshal(schema)
+	require.NoError(t, err)
+	assert.Equal(t, IntegrationSchemaName+"/v"+IntegrationSchemaVersion, schema["$id"])
+
+	_, ok = OutputSchema(OutputFormatCSV)
+	assert.False(t, ok)
+	assert.NoError(t, ValidateOutputFormat(OutputFormatIntegrationJSON))
+	assert.Error(t, ValidateOutputFormat("xml"))
+}
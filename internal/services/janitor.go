@@ -0,0 +1,141 @@
+package services
+
+import (
+	"fmt"
+	"sync"
+	"time"
+
+	"github.com/sirupsen/logrus"
+)
+
+// DefaultJanitorInterval is how often the janitor sweeps unless configured
+const DefaultJanitorInterval = time.Hour
+
+// Janitor deletes files of the uploads directory once they are older than
+// the retention TTL. Pinned results and uploads still being processed are
+// kept, as are files the service didn't create.
+type Janitor struct {
+	fileService *FileService
+	intake      *IntakeLog
+	annotations *AnnotationService
+	pins        *PinStore
+	events      *EventBus
+	ttl         time.Duration
+	logger      *logrus.Logger
+	now         func() time.Time
+
+	stop chan struct{}
+	done chan struct{}
+	once sync.Once
+}
+
+// NewJanitor creates a janitor removing files older than ttl
+func NewJanitor(fileService *FileService, intake *IntakeLog, annotations *AnnotationService, pins *PinStore, events *EventBus, ttl time.Duration, logger *logrus.Logger) *Janitor {
+	return &Janitor{
+		fileService: fileService,
+		intake:      intake,
+		annotations: annotations,
+		pins:        pins,
+		events:      events,
+		ttl:         ttl,
+		logger:      logger,
+		now:         time.Now,
+		stop:        make(chan struct{}),
+		done:        make(chan struct{}),
+	}
+}
+
+// Start sweeps once, then every interval until Close
+func (j *Janitor) Start(interval time.Duration) {
+	if interval <= 0 {
+		interval = DefaultJanitorInterval
+	}
+	go func() {
+		defer close(j.done)
+		ticker := time.NewTicker(interval)
+		defer ticker.Stop()
+		for {
+			j.sweepAndLog()
+			select {
+			case <-j.stop:
+				return
+			case <-ticker.C:
+			}
+		}
+	}()
+}
+
+// Close stops the janitor, waiting for a running sweep to finish
+func (j *Janitor) Close() {
+	j.once.Do(func() { close(j.stop) })
+	<-j.done
+}
+
+func (j *Janitor) sweepAndLog() {
+	n, err := j.Sweep()
+	if err != nil {
+		j.logger.Errorf("Retention sweep failed: %v", err)
+		return
+	}
+	if n > 0 {
+		j.logger.Infof("Retention sweep deleted %d expired files", n)
+	}
+}
+
+// Sweep deletes the expired files and returns how many were deleted. A
+// file that can't be deleted is logged and retried on the next sweep.
+func (j *Janitor) Sweep() (int, error) {
+	files, err := j.fileService.StoredFiles()
+	if err != nil {
+		return 0, err
+	}
+	processing := make(map[string]bool)
+	for _, entry := range j.intake.List(IntakeProcessing) {
+		processing[entry.UploadID] = true
+	}
+
+	cutoff := j.now().Add(-j.ttl)
+	reason := fmt.Sprintf("expired after %s", j.ttl)
+	deleted := 0
+	for _, file := range files {
+		if file.ModTime.After(cutoff) {
+			continue
+		}
+		if file.Kind == "upload" && processing[file.ID] {
+			j.logger.Debugf("Keeping expired upload %s until it has been processed", file.ID)
+			continue
+		}
+		if file.Kind == "result" && j.pins.IsPinned(file.ID) {
+			continue
+		}
+		if err := j.fileService.DeleteStoredFile(file.Name); err != nil {
+			j.logger.Errorf("Failed to delete expired file %s: %v", file.Name, err)
+			continue
+		}
+		deleted++
+
+		event := Event{Type: EventCleanup, Paths: []string{file.Path}, Reason: reason}
+		var entry IntakeEntry
+		var known bool
+		switch file.Kind {
+		case "upload":
+			entry, known = j.intake.Entry(file.ID)
+		case "result":
+			if err := j.annotations.Delete(file.ID); err != nil {
+				j.logger.Errorf("Failed to delete annotations of result %s: %v", file.ID, err)
+			}
+			entry, known = j.intake.EntryByResult(file.ID)
+			event.ResultID = file.ID
+		}
+		if known {
+			event.Tenant, event.UploadID, event.Filename = entry.Tenant, entry.UploadID, entry.Filename
+		}
+		if known && file.Kind == "result" {
+			if err := j.intake.MarkDeleted(entry.UploadID); err != nil {
+				j.logger.Errorf("Failed to record deletion of result %s: %v", file.ID, err)
+			}
+		}
+		j.events.Publish(event)
+	}
+	return deleted, nil
+}
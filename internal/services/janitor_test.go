@@ -0,0 +1,87 @@
+package services
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/sirupsen/logrus"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestJanitorSweep(t *testing.T) {
+	logger := logrus.New()
+	logger.SetLevel(logrus.PanicLevel)
+	dir := t.TempDir()
+	fs := NewFileService(dir, logger)
+	intake, err := NewIntakeLog(filepath.Join(t.TempDir(), "intake.log"), logger)
+	require.NoError(t, err)
+	defer intake.Close()
+	annotations := NewAnnotationService(t.TempDir(), logger)
+	events := NewEventBus(logger)
+	var cleanups []Event
+	events.Subscribe(func(e Event) { cleanups = append(cleanups, e) }, EventCleanup)
+
+	write := func(name string) string {
+		path := filepath.Join(dir, name)
+		require.NoError(t, os.WriteFile(path, []byte("Department Name,Total Sales\n"), 0600))
+		return path
+	}
+	done, processing := "11111111-1111-1111-1111-111111111111", "22222222-2222-2222-2222-222222222222"
+	resultID, fresh := "33333333-3333-3333-3333-333333333333", "44444444-4444-4444-4444-444444444444"
+	pinned := "55555555-5555-5555-5555-555555555555"
+	require.NoError(t, intake.Begin(done, write("upload_"+done+".csv"), "june.csv", "acme"))
+	require.NoError(t, intake.Complete(done, resultID))
+	write("result_" + resultID + ".csv")
+	_, err = annotations.Add(resultID, "Approved", "", "acme")
+	require.NoError(t, err)
+	require.NoError(t, intake.Begin(processing, write("upload_"+processing+".csv"), "july.csv", "acme"))
+	write("upload_" + fresh + ".csv")
+	write("notes.txt")
+	write("result_" + pinned + ".csv")
+	pins, err := NewPinStore(filepath.Join(t.TempDir(), "pins.json"), logger)
+	require.NoError(t, err)
+	_, err = pins.Pin(pinned, "acme", "finance", "year-end report")
+	require.NoError(t, err)
+
+	now := time.Now().Add(25 * time.Hour)
+	require.NoError(t, os.Chtimes(filepath.Join(dir, "upload_"+fresh+".csv"), now, now))
+	janitor := NewJanitor(fs, intake, annotations, pins, events, 24*time.Hour, logger)
+	janitor.now = func() time.Time { return now }
+
+	deleted, err := janitor.Sweep()
+	require.NoError(t, err)
+	assert.Equal(t, 2, deleted)
+	assert.NoFileExists(t, filepath.Join(dir, "upload_"+done+".csv"))
+	assert.NoFileExists(t, filepath.Join(dir, "result_"+resultID+".csv"))
+	assert.FileExists(t, filepath.Join(dir, "upload_"+processing+".csv"), "uploads being processed are kept")
+	assert.FileExists(t, filepath.Join(dir, "upload_"+fresh+".csv"))
+	assert.FileExists(t, filepath.Join(dir, "notes.txt"), "files the service didn't create are kept")
+	assert.FileExists(t, filepath.Join(dir, "result_"+pinned+".csv"), "pinned results are kept")
+
+	notes, err := annotations.List(resultID)
+	require.NoError(t, err)
+	assert.Empty(t, notes)
+	entry, ok := intake.Entry(done)
+	require.True(t, ok)
+	assert.Equal(t, IntakeDeleted, entry.State)
+	assert.Equal(t, resultID, entry.ResultID)
+
+	require.Len(t, cleanups, 2)
+	for _, e := range cleanups {
+		assert.Equal(t, done, e.UploadID)
+		assert.Equal(t, "acme", e.Tenant)
+		assert.Equal(t, "expired after 24h0m0s", e.Reason)
+	}
+
+	deleted, err = janitor.Sweep()
+	require.NoError(t, err)
+	assert.Zero(t, deleted)
+
+	require.NoError(t, pins.Unpin(pinned))
+	deleted, err = janitor.Sweep()
+	require.NoError(t, err)
+	assert.Equal(t, 1, deleted)
+}
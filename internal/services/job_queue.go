@@ -0,0 +1,480 @@
+package services
+
+import (
+	"errors"
+	"fmt"
+	"net/http"
+	"os"
+	"strconv"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/mussietl/csv-sales-api/internal/models"
+	"github.com/sirupsen/logrus"
+)
+
+// ErrJobQueueFull is returned when a job is submitted to a full queue
+var ErrJobQueueFull = errors.New("job queue is full")
+
+// ErrJobQueueClosed is returned when a job is submitted after Close
+var ErrJobQueueClosed = errors.New("job queue is closed")
+
+// Defaults for job leases
+const (
+	DefaultJobLease       = 30 * time.Second
+	DefaultJobMaxAttempts = 3
+)
+
+// deferredJobRetry is how often workers retry the jobs held back because
+// their tenant was at its concurrency cap
+const deferredJobRetry = 250 * time.Millisecond
+
+// JobFunc runs a job, returning its result on success or the error response
+// to report on failure
+type JobFunc func(job Job) (*models.UploadResponse, *models.ErrorResponse)
+
+// RedeliverFunc rebuilds the work of a job from its params, so a job whose
+// worker was lost can be run again
+type RedeliverFunc func(job Job) (JobFunc, error)
+
+// queuedJob is a job waiting for a worker
+type queuedJob struct {
+	id     string
+	tenant string
+	run    JobFunc
+}
+
+// JobQueueStats describes the queue for autoscalers and operators
+type JobQueueStats struct {
+	Workers int `json:"workers"`
+	Busy    int `json:"busy"`
+	Queued  int `json:"queued"`
+	// Capacity is the most jobs that may wait for a worker
+	Capacity int `json:"capacity"`
+	// LeaseExpirations counts the jobs whose worker stopped renewing its lease
+	LeaseExpirations int64 `json:"lease_expirations"`
+	// Redeliveries counts the jobs run again after their lease expired
+	Redeliveries int64 `json:"redeliveries"`
+}
+
+// JobQueue runs jobs on a pool of workers, recording their progress in a
+// JobStore. A worker leases each job it takes and renews the lease while it
+// runs; jobs whose lease expires, because the server running them stopped,
+// are run again by Recover.
+type JobQueue struct {
+	store       *JobStore
+	tasks       chan queuedJob
+	quit        chan struct{}
+	instance    string
+	lease       time.Duration
+	maxAttempts int
+	redeliver   RedeliverFunc
+	limiter     atomic.Pointer[TenantLimiter]
+	mu          sync.RWMutex
+	closed      bool
+	workers     int
+	nextWorker  int
+	busy        atomic.Int64
+	expirations atomic.Int64
+	redelivered atomic.Int64
+	stopReaper  chan struct{}
+	// deferred holds the jobs taken from the queue while their tenant was
+	// at its concurrency cap, in the order they were queued
+	deferMu  sync.Mutex
+	deferred []queuedJob
+	wg       sync.WaitGroup
+	logger   *logrus.Logger
+}
+
+// NewJobQueue starts workers that take jobs from a queue of capacity
+func NewJobQueue(store *JobStore, workers, capacity int, logger *logrus.Logger) *JobQueue {
+	if capacity < 0 {
+		capacity = 0
+	}
+	host, _ := os.Hostname()
+	jq := &JobQueue{
+		store:       store,
+		tasks:       make(chan queuedJob, capacity),
+		quit:        make(chan struct{}, 1024),
+		instance:    host + ":" + strconv.Itoa(os.Getpid()),
+		lease:       DefaultJobLease,
+		maxAttempts: DefaultJobMaxAttempts,
+		logger:      logger,
+	}
+	jq.SetWorkers(workers)
+	return jq
+}
+
+// SetLease sets how long a job's lease lasts without renewal and how many
+// times a job is taken before it is failed. It must be called before Recover.
+func (jq *JobQueue) SetLease(lease time.Duration, maxAttempts int) {
+	jq.mu.Lock()
+	defer jq.mu.Unlock()
+	if lease > 0 {
+		jq.lease = lease
+	}
+	if maxAttempts > 0 {
+		jq.maxAttempts = maxAttempts
+	}
+}
+
+// SetTenantLimiter caps how many jobs of a tenant run at once. A job whose
+// tenant is at its cap is held back, without blocking its worker, until a
+// slot is released.
+func (jq *JobQueue) SetTenantLimiter(limiter *TenantLimiter) {
+	jq.limiter.Store(limiter)
+}
+
+// SetWorkers grows or shrinks the worker pool to n. Workers beyond n stop
+// once their current job has finished, so no work is lost.
+func (jq *JobQueue) SetWorkers(n int) {
+	if n < 1 {
+		n = 1
+	}
+	jq.mu.Lock()
+	defer jq.mu.Unlock()
+	if jq.closed {
+		return
+	}
+	for ; jq.workers < n; jq.workers++ {
+		jq.nextWorker++
+		jq.wg.Add(1)
+		go jq.work(jq.instance + "/" + strconv.Itoa(jq.nextWorker))
+	}
+	for ; jq.workers > n; jq.workers-- {
+		jq.quit <- struct{}{}
+	}
+}
+
+// Stats returns the size and load of the queue
+func (jq *JobQueue) Stats() JobQueueStats {
+	jq.mu.RLock()
+	defer jq.mu.RUnlock()
+	return JobQueueStats{
+		Workers:          jq.workers,
+		Busy:             int(jq.busy.Load()),
+		Queued:           jq.Len(),
+		Capacity:         cap(jq.tasks),
+		LeaseExpirations: jq.expirations.Load(),
+		Redeliveries:     jq.redelivered.Load(),
+	}
+}
+
+// Submit creates a queued job and hands it to the workers. It fails with
+// ErrJobQueueFull rather than wait when every worker is busy and the queue
+// is at capacity. params are kept with the job for redelivery.
+func (jq *JobQueue) Submit(tenant, uploadID, filename string, params map[string]string, run JobFunc) (Job, error) {
+	jq.mu.RLock()
+	defer jq.mu.RUnlock()
+	if jq.closed {
+		return Job{}, ErrJobQueueClosed
+	}
+
+	// Held back jobs still wait for a worker
+	if held := jq.deferredLen(); held > 0 && len(jq.tasks)+held >= cap(jq.tasks) {
+		return Job{}, fmt.Errorf("%w: %d jobs waiting", ErrJobQueueFull, cap(jq.tasks))
+	}
+	job, err := jq.store.Create(tenant, uploadID, filename, params)
+	if err != nil {
+		return Job{}, err
+	}
+	select {
+	case jq.tasks <- queuedJob{id: job.ID, tenant: tenant, run: run}:
+		return job, nil
+	default:
+		jq.finish(job.ID, "", nil, &models.ErrorResponse{Error: ErrJobQueueFull.Error(), Code: http.StatusServiceUnavailable})
+		return Job{}, fmt.Errorf("%w: %d jobs waiting", ErrJobQueueFull, cap(jq.tasks))
+	}
+}
+
+// Resumable returns the jobs left by a previous run that Recover will run
+// again, so their uploads can be left to it
+func (jq *JobQueue) Resumable() []Job {
+	jq.mu.RLock()
+	defer jq.mu.RUnlock()
+	var jobs []Job
+	for _, job := range jq.store.Orphaned() {
+		if job.Attempts < jq.maxAttempts {
+			jobs = append(jobs, job)
+		}
+	}
+	return jobs
+}
+
+// Recover runs again, with work rebuilt by redeliver, the jobs a previous
+// run left queued and, once their lease has expired, those it left
+// processing. It then keeps watching for expired leases until Close.
+func (jq *JobQueue) Recover(redeliver RedeliverFunc) {
+	jq.mu.Lock()
+	if jq.closed || jq.redeliver != nil {
+		jq.mu.Unlock()
+		return
+	}
+	jq.redeliver = redeliver
+	jq.stopReaper = make(chan struct{})
+	interval := jq.lease / 2
+	jq.mu.Unlock()
+
+	for _, job := range jq.store.Orphaned() {
+		if job.State == JobQueued {
+			jq.requeue(job, "was queued when the server stopped")
+		}
+	}
+	jq.reap(time.Now())
+
+	jq.wg.Add(1)
+	go func() {
+		defer jq.wg.Done()
+		ticker := time.NewTicker(interval)
+		defer ticker.Stop()
+		for {
+			select {
+			case <-jq.stopReaper:
+				return
+			case now := <-ticker.C:
+				jq.reap(now)
+			}
+		}
+	}()
+}
+
+// reap redelivers the processing jobs whose lease expired before now
+func (jq *JobQueue) reap(now time.Time) {
+	for _, job := range jq.store.Expired(now) {
+		jq.expirations.Add(1)
+		jq.logger.Warnf("Lease of job %s held by %s expired", job.ID, job.Worker)
+		jq.requeue(job, "lease held by "+job.Worker+" expired")
+	}
+}
+
+// requeue hands a job back to the workers with its work rebuilt, or fails
+// it once it has used its attempts or can't be rebuilt
+func (jq *JobQueue) requeue(job Job, reason string) {
+	jq.mu.RLock()
+	defer jq.mu.RUnlock()
+	if jq.closed {
+		return
+	}
+	if job.Attempts >= jq.maxAttempts {
+		jq.finish(job.ID, job.Worker, nil, &models.ErrorResponse{Error: fmt.Sprintf("%s after %d attempts", reason, job.Attempts), Code: http.StatusInternalServerError})
+		return
+	}
+	run, err := jq.redeliver(job)
+	if err != nil {
+		jq.finish(job.ID, job.Worker, nil, &models.ErrorResponse{Error: reason + "; could not redeliver: " + err.Error(), Code: http.StatusInternalServerError})
+		return
+	}
+	worker := job.Worker
+	_, ok, err := jq.store.updateIf(job.ID, func(current Job) bool {
+		return current.State == job.State && current.Worker == worker
+	}, func(job *Job) {
+		job.State, job.Worker, job.LeaseExpiresAt, job.Progress = JobQueued, "", nil, nil
+	})
+	if err != nil || !ok {
+		if err != nil {
+			jq.logger.Errorf("Failed to requeue job %s: %v", job.ID, err)
+		}
+		return
+	}
+	select {
+	case jq.tasks <- queuedJob{id: job.ID, tenant: job.Tenant, run: run}:
+		jq.redelivered.Add(1)
+		jq.logger.Infof("Redelivered job %s (%s)", job.ID, reason)
+	default:
+		jq.finish(job.ID, "", nil, &models.ErrorResponse{Error: ErrJobQueueFull.Error(), Code: http.StatusServiceUnavailable})
+	}
+}
+
+// Len returns the number of jobs waiting for a worker
+func (jq *JobQueue) Len() int {
+	return len(jq.tasks) + jq.deferredLen()
+}
+
+// SetProgress records how far a running job's processing has come
+func (jq *JobQueue) SetProgress(id string, progress ProcessProgress) {
+	jq.store.SetProgress(id, progress)
+}
+
+// leaseDuration returns how long a lease lasts without renewal
+func (jq *JobQueue) leaseDuration() time.Duration {
+	jq.mu.RLock()
+	defer jq.mu.RUnlock()
+	return jq.lease
+}
+
+// work runs queued jobs until the queue is closed and drained or the worker
+// is told to quit. Jobs whose tenant is at its cap are held back and tried
+// again before new ones are taken.
+func (jq *JobQueue) work(worker string) {
+	defer jq.wg.Done()
+	retry := time.NewTicker(deferredJobRetry)
+	defer retry.Stop()
+	tasks := jq.tasks
+	for {
+		if task, release, ok := jq.nextDeferred(); ok {
+			jq.take(worker, task, release)
+			continue
+		}
+		if tasks == nil && jq.deferredLen() == 0 {
+			return
+		}
+		select {
+		case <-jq.quit:
+			return
+		case task, ok := <-tasks:
+			if !ok {
+				tasks = nil
+				continue
+			}
+			release, ok := jq.acquireTenant(task.tenant)
+			if !ok {
+				jq.deferJob(task)
+				continue
+			}
+			jq.take(worker, task, release)
+		case <-retry.C:
+		}
+	}
+}
+
+// acquireTenant reserves a slot for a job of tenant, returning false when
+// the tenant is at its cap
+func (jq *JobQueue) acquireTenant(tenant string) (func(), bool) {
+	limiter := jq.limiter.Load()
+	if limiter == nil {
+		return func() {}, true
+	}
+	return limiter.TryAcquire(tenant)
+}
+
+// deferJob holds back a job until its tenant has a free slot
+func (jq *JobQueue) deferJob(task queuedJob) {
+	jq.deferMu.Lock()
+	defer jq.deferMu.Unlock()
+	jq.deferred = append(jq.deferred, task)
+}
+
+// nextDeferred removes and returns the first held back job whose tenant now
+// has a free slot, with the slot reserved
+func (jq *JobQueue) nextDeferred() (queuedJob, func(), bool) {
+	jq.deferMu.Lock()
+	defer jq.deferMu.Unlock()
+	for i, task := range jq.deferred {
+		if release, ok := jq.acquireTenant(task.tenant); ok {
+			jq.deferred = append(jq.deferred[:i], jq.deferred[i+1:]...)
+			return task, release, true
+		}
+	}
+	return queuedJob{}, nil, false
+}
+
+func (jq *JobQueue) deferredLen() int {
+	jq.deferMu.Lock()
+	defer jq.deferMu.Unlock()
+	return len(jq.deferred)
+}
+
+// take leases a job and runs it, renewing the lease until it finishes, then
+// releases its tenant slot
+func (jq *JobQueue) take(worker string, task queuedJob, release func()) {
+	defer release()
+	lease := jq.leaseDuration()
+	job, ok, err := jq.store.updateIf(task.id, func(job Job) bool {
+		return job.State == JobQueued
+	}, func(job *Job) {
+		now := time.Now().UTC()
+		expires := now.Add(lease)
+		job.State, job.StartedAt, job.Worker, job.LeaseExpiresAt = JobProcessing, &now, worker, &expires
+		job.Attempts++
+	})
+	if err != nil || !ok {
+		if err != nil {
+			jq.logger.Errorf("Failed to start job %s: %v", task.id, err)
+		}
+		return
+	}
+
+	jq.busy.Add(1)
+	defer jq.busy.Add(-1)
+	done := make(chan struct{})
+	go jq.heartbeat(job.ID, worker, lease, done)
+	result, failure := jq.run(task, job)
+	close(done)
+	jq.finish(job.ID, worker, result, failure)
+}
+
+// heartbeat renews a job's lease until done is closed
+func (jq *JobQueue) heartbeat(id, worker string, lease time.Duration, done chan struct{}) {
+	ticker := time.NewTicker(lease / 3)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-done:
+			return
+		case <-ticker.C:
+			_, ok, err := jq.store.updateIf(id, func(job Job) bool {
+				return job.State == JobProcessing && job.Worker == worker
+			}, func(job *Job) {
+				expires := time.Now().UTC().Add(lease)
+				job.LeaseExpiresAt = &expires
+			})
+			if err != nil {
+				jq.logger.Errorf("Failed to renew lease of job %s: %v", id, err)
+			} else if !ok {
+				jq.logger.Warnf("Worker %s lost its lease on job %s", worker, id)
+				return
+			}
+		}
+	}
+}
+
+// run calls a job's function, turning a panic into a failure
+func (jq *JobQueue) run(task queuedJob, job Job) (result *models.UploadResponse, failure *models.ErrorResponse) {
+	defer func() {
+		if r := recover(); r != nil {
+			jq.logger.Errorf("Job %s panicked: %v", job.ID, r)
+			result, failure = nil, &models.ErrorResponse{Error: "internal error while processing", Code: http.StatusInternalServerError}
+		}
+	}()
+	return task.run(job)
+}
+
+// finish records a job's outcome, unless worker has lost its lease on it
+func (jq *JobQueue) finish(id, worker string, result *models.UploadResponse, failure *models.ErrorResponse) {
+	_, ok, err := jq.store.updateIf(id, func(job Job) bool {
+		return job.Worker == worker && job.State != JobDone && job.State != JobFailed
+	}, func(job *Job) {
+		now := time.Now().UTC()
+		job.FinishedAt, job.Worker, job.LeaseExpiresAt = &now, "", nil
+		if failure != nil {
+			job.State, job.Error, job.Failure = JobFailed, failure.Error, failure
+			return
+		}
+		job.State, job.Result = JobDone, result
+		if result != nil {
+			job.DownloadURL = result.DownloadURL
+		}
+	})
+	if err != nil {
+		jq.logger.Errorf("Failed to record outcome of job %s: %v", id, err)
+	} else if !ok {
+		jq.logger.Warnf("Discarding outcome of job %s: worker %s no longer holds its lease", id, worker)
+	}
+}
+
+// Close stops accepting jobs and waits for queued ones to finish
+func (jq *JobQueue) Close() {
+	jq.mu.Lock()
+	if jq.closed {
+		jq.mu.Unlock()
+		return
+	}
+	jq.closed = true
+	close(jq.tasks)
+	if jq.stopReaper != nil {
+		close(jq.stopReaper)
+	}
+	jq.mu.Unlock()
+	jq.wg.Wait()
+}
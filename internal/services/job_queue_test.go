@@ -0,0 +1,253 @@
+package services
+
+import (
+	"errors"
+	"path/filepath"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/mussietl/csv-sales-api/internal/models"
+	"github.com/sirupsen/logrus"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// waitForJob polls the store until a job has finished
+func waitForJob(t *testing.T, store *JobStore, id string) Job {
+	t.Helper()
+	deadline := time.Now().Add(5 * time.Second)
+	for time.Now().Before(deadline) {
+		job, err := store.Get(id)
+		require.NoError(t, err)
+		if job.State == JobDone || job.State == JobFailed {
+			return job
+		}
+		time.Sleep(5 * time.Millisecond)
+	}
+	t.Fatalf("job %s did not finish", id)
+	return Job{}
+}
+
+func TestJobQueue(t *testing.T) {
+	logger := logrus.New()
+	logger.SetLevel(logrus.FatalLevel)
+	path := filepath.Join(t.TempDir(), "jobs.json")
+	store, err := NewJobStore(path, logger)
+	require.NoError(t, err)
+	queue := NewJobQueue(store, 2, 10, logger)
+	defer queue.Close()
+
+	done, err := queue.Submit("acme", "u1", "sales.csv", nil, func(job Job) (*models.UploadResponse, *models.ErrorResponse) {
+		assert.Equal(t, JobProcessing, job.State)
+		return &models.UploadResponse{Success: true, DownloadURL: "/public/uploads/result.csv"}, nil
+	})
+	require.NoError(t, err)
+	assert.Equal(t, JobQueued, done.State)
+	failed, err := queue.Submit("acme", "u2", "bad.csv", nil, func(Job) (*models.UploadResponse, *models.ErrorResponse) {
+		return nil, &models.ErrorResponse{Error: "no sales column", Code: 400}
+	})
+	require.NoError(t, err)
+	panicked, err := queue.Submit("acme", "u3", "boom.csv", nil, func(Job) (*models.UploadResponse, *models.ErrorResponse) {
+		panic("boom")
+	})
+	require.NoError(t, err)
+
+	job := waitForJob(t, store, done.ID)
+	assert.Equal(t, JobDone, job.State)
+	assert.Equal(t, "/public/uploads/result.csv", job.DownloadURL)
+	require.NotNil(t, job.StartedAt)
+	require.NotNil(t, job.FinishedAt)
+
+	job = waitForJob(t, store, failed.ID)
+	assert.Equal(t, JobFailed, job.State)
+	assert.Equal(t, "no sales column", job.Error)
+	assert.Equal(t, 400, job.Failure.Code)
+
+	job = waitForJob(t, store, panicked.ID)
+	assert.Equal(t, JobFailed, job.State)
+	assert.Equal(t, 500, job.Failure.Code)
+
+	_, err = store.Get("missing")
+	assert.True(t, errors.Is(err, ErrJobNotFound))
+}
+
+func TestJobQueueFull(t *testing.T) {
+	logger := logrus.New()
+	logger.SetLevel(logrus.FatalLevel)
+	store, err := NewJobStore(filepath.Join(t.TempDir(), "jobs.json"), logger)
+	require.NoError(t, err)
+	queue := NewJobQueue(store, 1, 1, logger)
+
+	release := make(chan struct{})
+	started := make(chan struct{})
+	blocking := func(Job) (*models.UploadResponse, *models.ErrorResponse) {
+		close(started)
+		<-release
+		return &models.UploadResponse{Success: true}, nil
+	}
+	waiting := func(Job) (*models.UploadResponse, *models.ErrorResponse) {
+		return &models.UploadResponse{Success: true}, nil
+	}
+
+	running, err := queue.Submit("acme", "u1", "a.csv", nil, blocking)
+	require.NoError(t, err)
+	<-started
+	queued, err := queue.Submit("acme", "u2", "b.csv", nil, waiting)
+	require.NoError(t, err)
+	_, err = queue.Submit("acme", "u3", "c.csv", nil, waiting)
+	assert.True(t, errors.Is(err, ErrJobQueueFull))
+
+	close(release)
+	queue.Close()
+	for _, id := range []string{running.ID, queued.ID} {
+		job, err := store.Get(id)
+		require.NoError(t, err)
+		assert.Equal(t, JobDone, job.State, "Close waits for queued jobs")
+	}
+	_, err = queue.Submit("acme", "u4", "d.csv", nil, waiting)
+	assert.True(t, errors.Is(err, ErrJobQueueClosed))
+}
+
+func TestJobStoreMarksInterruptedJobsFailed(t *testing.T) {
+	logger := logrus.New()
+	logger.SetLevel(logrus.FatalLevel)
+	path := filepath.Join(t.TempDir(), "jobs.json")
+	store, err := NewJobStore(path, logger)
+	require.NoError(t, err)
+	queued, err := store.Create("acme", "u1", "a.csv", nil)
+	require.NoError(t, err)
+	finished, err := store.Create("acme", "u2", "b.csv", nil)
+	require.NoError(t, err)
+	_, err = store.Update(finished.ID, func(job *Job) { job.State = JobDone })
+	require.NoError(t, err)
+
+	store, err = NewJobStore(path, logger)
+	require.NoError(t, err)
+	job, err := store.Get(queued.ID)
+	require.NoError(t, err)
+	assert.Equal(t, JobFailed, job.State)
+	assert.Equal(t, "interrupted by a server restart", job.Error)
+	job, err = store.Get(finished.ID)
+	require.NoError(t, err)
+	assert.Equal(t, JobDone, job.State)
+
+	// Jobs finished before the retention period are dropped
+	old := time.Now().Add(-jobRetention - time.Hour)
+	_, err = store.Update(finished.ID, func(job *Job) { job.FinishedAt = &old })
+	require.NoError(t, err)
+	_, err = store.Create("acme", "u3", "c.csv", nil)
+	require.NoError(t, err)
+	_, err = store.Get(finished.ID)
+	assert.True(t, errors.Is(err, ErrJobNotFound))
+}
+
+func TestJobQueueLeases(t *testing.T) {
+	logger := logrus.New()
+	logger.SetLevel(logrus.FatalLevel)
+	path := filepath.Join(t.TempDir(), "jobs.json")
+	store, err := NewJobStore(path, logger)
+	require.NoError(t, err)
+	params := map[string]string{"agg": "sum"}
+	queued, err := store.Create("acme", "u1", "a.csv", params)
+	require.NoError(t, err)
+	crashed, err := store.Create("acme", "u2", "b.csv", params)
+	require.NoError(t, err)
+	exhausted, err := store.Create("acme", "u3", "c.csv", params)
+	require.NoError(t, err)
+	legacy, err := store.Create("acme", "u4", "d.csv", nil)
+	require.NoError(t, err)
+	expired := time.Now().Add(-time.Second)
+	for id, attempts := range map[string]int{crashed.ID: 1, exhausted.ID: 3, legacy.ID: 1} {
+		_, err = store.Update(id, func(job *Job) {
+			job.State, job.Worker, job.LeaseExpiresAt, job.Attempts = JobProcessing, "old-host:1/1", &expired, attempts
+		})
+		require.NoError(t, err)
+	}
+
+	store, err = NewJobStore(path, logger)
+	require.NoError(t, err)
+	queue := NewJobQueue(store, 1, 10, logger)
+	defer queue.Close()
+	queue.SetLease(90*time.Millisecond, 3)
+	var resumed []string
+	for _, job := range queue.Resumable() {
+		resumed = append(resumed, job.UploadID)
+	}
+	assert.Equal(t, []string{"u1", "u2"}, resumed)
+	job, err := store.Get(legacy.ID)
+	require.NoError(t, err)
+	assert.Equal(t, JobFailed, job.State, "jobs without params can't be redelivered")
+
+	queue.Recover(func(job Job) (JobFunc, error) {
+		assert.Equal(t, params, job.Params)
+		return func(Job) (*models.UploadResponse, *models.ErrorResponse) {
+			// Outlive the lease, which the worker keeps renewing
+			time.Sleep(200 * time.Millisecond)
+			return &models.UploadResponse{Success: true, DownloadURL: "/public/uploads/" + job.UploadID}, nil
+		}, nil
+	})
+
+	for id, attempts := range map[string]int{queued.ID: 1, crashed.ID: 2} {
+		job := waitForJob(t, store, id)
+		assert.Equal(t, JobDone, job.State)
+		assert.Equal(t, attempts, job.Attempts, "jobs are not redelivered while their lease is renewed")
+		assert.Empty(t, job.Worker)
+	}
+	job = waitForJob(t, store, exhausted.ID)
+	assert.Equal(t, JobFailed, job.State)
+	assert.Equal(t, "lease held by old-host:1/1 expired after 3 attempts", job.Error)
+
+	stats := queue.Stats()
+	assert.Equal(t, int64(2), stats.LeaseExpirations)
+	assert.Equal(t, int64(2), stats.Redeliveries)
+	queue.SetWorkers(4)
+	assert.Equal(t, 4, queue.Stats().Workers)
+	queue.SetWorkers(0)
+	assert.Equal(t, 1, queue.Stats().Workers)
+}
+
+func TestJobQueueTenantLimit(t *testing.T) {
+	logger := logrus.New()
+	logger.SetLevel(logrus.FatalLevel)
+	store, err := NewJobStore(filepath.Join(t.TempDir(), "jobs.json"), logger)
+	require.NoError(t, err)
+	limiter, err := NewTenantLimiter(filepath.Join(t.TempDir(), "tenant_limits.json"), 0, logger)
+	require.NoError(t, err)
+	require.NoError(t, limiter.SetLimit("acme", 1))
+	queue := NewJobQueue(store, 3, 10, logger)
+	queue.SetTenantLimiter(limiter)
+
+	var running, peak atomic.Int64
+	acme := func(Job) (*models.UploadResponse, *models.ErrorResponse) {
+		n := running.Add(1)
+		for {
+			p := peak.Load()
+			if n <= p || peak.CompareAndSwap(p, n) {
+				break
+			}
+		}
+		time.Sleep(50 * time.Millisecond)
+		running.Add(-1)
+		return &models.UploadResponse{Success: true}, nil
+	}
+	first, err := queue.Submit("acme", "u1", "a.csv", nil, acme)
+	require.NoError(t, err)
+	second, err := queue.Submit("acme", "u2", "b.csv", nil, acme)
+	require.NoError(t, err)
+	// Another tenant isn't held up by acme's cap
+	other, err := queue.Submit("globex", "u3", "c.csv", nil, func(Job) (*models.UploadResponse, *models.ErrorResponse) {
+		return &models.UploadResponse{Success: true}, nil
+	})
+	require.NoError(t, err)
+	assert.Equal(t, JobDone, waitForJob(t, store, other.ID).State)
+
+	queue.Close()
+	for _, id := range []string{first.ID, second.ID} {
+		job, err := store.Get(id)
+		require.NoError(t, err)
+		assert.Equal(t, JobDone, job.State, "Close waits for held back jobs")
+	}
+	assert.Equal(t, int64(1), peak.Load(), "jobs of a tenant capped at 1 never overlap")
+	assert.Equal(t, 0, limiter.Get("acme").Active)
+}
@@ -0,0 +1,247 @@
+package services
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/mussietl/csv-sales-api/internal/models"
+	"github.com/sirupsen/logrus"
+)
+
+// Job states, in the order a job moves through them
+const (
+	JobQueued     = "queued"
+	JobProcessing = "processing"
+	JobDone       = "done"
+	JobFailed     = "failed"
+)
+
+// ErrJobNotFound is returned for an unknown job ID
+var ErrJobNotFound = errors.New("job not found")
+
+// jobRetention is how long finished jobs are kept
+const jobRetention = 7 * 24 * time.Hour
+
+// Job is an upload processed in the background
+type Job struct {
+	ID       string `json:"id"`
+	Tenant   string `json:"tenant"`
+	UploadID string `json:"upload_id"`
+	Filename string `json:"filename"`
+	State    string `json:"state"`
+	// DownloadURL links to the result once the job is done
+	DownloadURL string `json:"download_url,omitempty"`
+	Error       string `json:"error,omitempty"`
+	// Result is the response a synchronous upload would have returned
+	Result *models.UploadResponse `json:"result,omitempty"`
+	// Failure is the error response a synchronous upload would have returned
+	Failure *models.ErrorResponse `json:"failure,omitempty"`
+	// Progress is how far processing has come, reported while processing
+	Progress *ProcessProgress `json:"progress,omitempty"`
+	// Params holds what is needed to rebuild the job's work after a restart;
+	// jobs without it can't be redelivered
+	Params map[string]string `json:"params,omitempty"`
+	// Attempts counts the times a worker has taken the job
+	Attempts int `json:"attempts,omitempty"`
+	// Worker holds a lease on a processing job until LeaseExpiresAt, renewed
+	// while the worker is alive
+	Worker         string     `json:"worker,omitempty"`
+	LeaseExpiresAt *time.Time `json:"lease_expires_at,omitempty"`
+	CreatedAt      time.Time  `json:"created_at"`
+	StartedAt      *time.Time `json:"started_at,omitempty"`
+	FinishedAt     *time.Time `json:"finished_at,omitempty"`
+}
+
+// JobStore persists the state of background jobs so clients can poll them
+type JobStore struct {
+	path string
+	mu   sync.Mutex
+	jobs map[string]Job
+	// orphaned lists the jobs left queued or processing by a previous run
+	orphaned []string
+	logger   *logrus.Logger
+}
+
+// NewJobStore creates a JobStore, loading jobs from path. Jobs left queued
+// or processing by a previous run are marked failed when they have no
+// params to rebuild their work from; the rest are left to JobQueue.Recover.
+func NewJobStore(path string, logger *logrus.Logger) (*JobStore, error) {
+	js := &JobStore{
+		path:   path,
+		jobs:   make(map[string]Job),
+		logger: logger,
+	}
+
+	data, err := os.ReadFile(path)
+	if err != nil && !errors.Is(err, os.ErrNotExist) {
+		return nil, fmt.Errorf("failed to read jobs: %w", err)
+	}
+	if err == nil {
+		if err := json.Unmarshal(data, &js.jobs); err != nil {
+			return nil, fmt.Errorf("failed to decode jobs: %w", err)
+		}
+	}
+
+	interrupted := 0
+	now := time.Now().UTC()
+	for id, job := range js.jobs {
+		if job.State != JobQueued && job.State != JobProcessing {
+			continue
+		}
+		if job.Params != nil {
+			js.orphaned = append(js.orphaned, id)
+			continue
+		}
+		job.State, job.Error, job.FinishedAt = JobFailed, "interrupted by a server restart", &now
+		js.jobs[id] = job
+		interrupted++
+	}
+	if interrupted > 0 {
+		logger.Warnf("Marked %d interrupted jobs failed", interrupted)
+		if err := js.save(); err != nil {
+			return nil, err
+		}
+	}
+	return js, nil
+}
+
+// Create stores a new queued job
+func (js *JobStore) Create(tenant, uploadID, filename string, params map[string]string) (Job, error) {
+	job := Job{
+		ID:        uuid.New().String(),
+		Tenant:    tenant,
+		UploadID:  uploadID,
+		Filename:  filename,
+		State:     JobQueued,
+		Params:    params,
+		CreatedAt: time.Now().UTC(),
+	}
+
+	js.mu.Lock()
+	defer js.mu.Unlock()
+	js.prune(job.CreatedAt)
+	js.jobs[job.ID] = job
+	if err := js.save(); err != nil {
+		delete(js.jobs, job.ID)
+		return Job{}, err
+	}
+	return job, nil
+}
+
+// Get returns a job by ID
+func (js *JobStore) Get(id string) (Job, error) {
+	js.mu.Lock()
+	defer js.mu.Unlock()
+	job, ok := js.jobs[id]
+	if !ok {
+		return Job{}, fmt.Errorf("%w: %s", ErrJobNotFound, id)
+	}
+	return job, nil
+}
+
+// Update applies fn to a job and persists the result
+func (js *JobStore) Update(id string, fn func(job *Job)) (Job, error) {
+	js.mu.Lock()
+	defer js.mu.Unlock()
+	previous, ok := js.jobs[id]
+	if !ok {
+		return Job{}, fmt.Errorf("%w: %s", ErrJobNotFound, id)
+	}
+	job := previous
+	fn(&job)
+	js.jobs[id] = job
+	if err := js.save(); err != nil {
+		js.jobs[id] = previous
+		return Job{}, err
+	}
+	return job, nil
+}
+
+// updateIf applies fn to a job and persists the result if ok accepts the
+// job, reporting whether it did
+func (js *JobStore) updateIf(id string, ok func(job Job) bool, fn func(job *Job)) (Job, bool, error) {
+	js.mu.Lock()
+	defer js.mu.Unlock()
+	previous, found := js.jobs[id]
+	if !found {
+		return Job{}, false, fmt.Errorf("%w: %s", ErrJobNotFound, id)
+	}
+	if !ok(previous) {
+		return previous, false, nil
+	}
+	job := previous
+	fn(&job)
+	js.jobs[id] = job
+	if err := js.save(); err != nil {
+		js.jobs[id] = previous
+		return Job{}, false, err
+	}
+	return job, true, nil
+}
+
+// Orphaned returns the jobs a previous run left queued or processing that
+// still are
+func (js *JobStore) Orphaned() []Job {
+	js.mu.Lock()
+	defer js.mu.Unlock()
+	var jobs []Job
+	for _, id := range js.orphaned {
+		if job, ok := js.jobs[id]; ok && (job.State == JobQueued || job.State == JobProcessing) {
+			jobs = append(jobs, job)
+		}
+	}
+	sort.Slice(jobs, func(i, j int) bool { return jobs[i].CreatedAt.Before(jobs[j].CreatedAt) })
+	return jobs
+}
+
+// Expired returns the processing jobs whose lease ran out before now
+func (js *JobStore) Expired(now time.Time) []Job {
+	js.mu.Lock()
+	defer js.mu.Unlock()
+	var jobs []Job
+	for _, job := range js.jobs {
+		if job.State == JobProcessing && job.LeaseExpiresAt != nil && job.LeaseExpiresAt.Before(now) {
+			jobs = append(jobs, job)
+		}
+	}
+	sort.Slice(jobs, func(i, j int) bool { return jobs[i].CreatedAt.Before(jobs[j].CreatedAt) })
+	return jobs
+}
+
+// SetProgress records how far a job's processing has come. Progress is
+// kept in memory only, since it changes too often to save each time; it is
+// saved with the job's next update.
+func (js *JobStore) SetProgress(id string, progress ProcessProgress) {
+	js.mu.Lock()
+	defer js.mu.Unlock()
+	if job, ok := js.jobs[id]; ok {
+		job.Progress = &progress
+		js.jobs[id] = job
+	}
+}
+
+// prune drops jobs that finished more than the retention period before now.
+// Callers must hold js.mu.
+func (js *JobStore) prune(now time.Time) {
+	cutoff := now.Add(-jobRetention)
+	for id, job := range js.jobs {
+		if job.FinishedAt != nil && job.FinishedAt.Before(cutoff) {
+			delete(js.jobs, id)
+		}
+	}
+}
+
+// save persists the jobs. Callers must hold js.mu.
+func (js *JobStore) save() error {
+	data, err := json.Marshal(js.jobs)
+	if err != nil {
+		return fmt.Errorf("failed to encode jobs: %w", err)
+	}
+	return writeFileAtomic(js.path, data, 0600)
+}
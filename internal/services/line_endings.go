@@ -0,0 +1,56 @@
+package services
+
+import (
+	"bufio"
+	"io"
+	"strings"
+)
+
+// lineEndingReader turns lone carriage returns, the line endings of classic
+// Mac OS exports, into newlines. \r\n is left for the CSV readers, which
+// already handle it, and every byte keeps its offset, so chunk boundaries
+// found in the raw file still apply.
+type lineEndingReader struct {
+	src *bufio.Reader
+}
+
+// newLineEndingReader wraps r, normalizing its line endings
+func newLineEndingReader(r io.Reader) *bufio.Reader {
+	src, ok := r.(*bufio.Reader)
+	if !ok {
+		src = bufio.NewReaderSize(r, 64*1024)
+	}
+	return bufio.NewReaderSize(&lineEndingReader{src: src}, 64*1024)
+}
+
+func (l *lineEndingReader) Read(p []byte) (int, error) {
+	n, err := l.src.Read(p)
+	for i := 0; i < n; i++ {
+		if p[i] != '\r' {
+			continue
+		}
+		if i+1 < n {
+			if p[i+1] != '\n' {
+				p[i] = '\n'
+			}
+			continue
+		}
+		// The \r ends this read; whether it starts a \r\n is up to the next byte
+		if next, peekErr := l.src.Peek(1); peekErr == nil && next[0] == '\n' {
+			continue
+		}
+		p[i] = '\n'
+	}
+	return n, err
+}
+
+// blankRecord reports whether every field of a record is empty or
+// whitespace, as in the rows of commas spreadsheets export below the data
+func blankRecord(record []string) bool {
+	for _, field := range record {
+		if strings.TrimSpace(field) != "" {
+			return false
+		}
+	}
+	return true
+}
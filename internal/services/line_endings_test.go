@@ -0,0 +1,69 @@
+package services
+
+import (
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"testing/iotest"
+
+	"github.com/sirupsen/logrus"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestLineEndingReader(t *testing.T) {
+	input := "a,b\rc,d\r\ne,f\n\rg,h\r"
+	for name, r := range map[string]io.Reader{
+		"whole":    strings.NewReader(input),
+		"one byte": iotest.OneByteReader(strings.NewReader(input)),
+	} {
+		normalized, err := io.ReadAll(newLineEndingReader(r))
+		require.NoError(t, err, name)
+		assert.Equal(t, "a,b\nc,d\r\ne,f\n\ng,h\n", string(normalized), name)
+	}
+}
+
+func TestTrailingBlankRows(t *testing.T) {
+	logger := logrus.New()
+	logger.SetLevel(logrus.PanicLevel)
+	cs := NewCSVService(logger)
+	cs.SetStrategyThresholds(StrategyThresholds{Workers: 4})
+
+	// Blank rows between data rows are skipped; the long run after the last
+	// one fills whole chunks of a parallel pass and is ignored
+	var b strings.Builder
+	b.WriteString("department,sales,region\r\n")
+	for i := 1; i <= 200; i++ {
+		fmt.Fprintf(&b, "Dept %d,1,North\r\n", i%3)
+		if i%50 == 0 && i < 200 {
+			b.WriteString(",,\r\n \t, ,\r\n")
+		}
+	}
+	b.WriteString(strings.Repeat(",,\r\n", 400))
+	path := filepath.Join(t.TempDir(), "blank.csv")
+	require.NoError(t, os.WriteFile(path, []byte(b.String()), 0600))
+
+	var expected string
+	for _, opts := range []ProcessOptions{
+		{Strategy: StrategyStreaming},
+		{Strategy: StrategyStreaming, Parser: ParserFast},
+		{Strategy: StrategyParallel},
+		{Strategy: StrategyParallel, Parser: ParserFast},
+	} {
+		var report strings.Builder
+		opts.ErrorReport = &report
+		result, err := cs.Process(path, opts)
+		require.NoError(t, err)
+		assert.Equal(t, 206, result.Rows.Total, "%s %s", opts.Strategy, opts.Parser)
+		assert.Equal(t, 6, result.Rows.Skipped, "%s %s", opts.Strategy, opts.Parser)
+		if expected == "" {
+			expected = report.String()
+			continue
+		}
+		assert.Equal(t, expected, report.String(), "%s %s", opts.Strategy, opts.Parser)
+	}
+	assert.Contains(t, expected, "52,empty_department")
+}
@@ -0,0 +1,75 @@
+package services
+
+import (
+	"runtime/metrics"
+	"sync"
+	"time"
+)
+
+// Runtime metrics whose difference is the memory the Go runtime holds from
+// the operating system, which is what an OOM killer sees
+const (
+	memoryTotalMetric    = "/memory/classes/total:bytes"
+	memoryReleasedMetric = "/memory/classes/heap/released:bytes"
+)
+
+// loadSampleInterval is how long a memory sample is reused, so busy
+// servers don't read runtime metrics on every request
+const loadSampleInterval = 100 * time.Millisecond
+
+// LoadShedRetryAfter is how long clients turned away by load shedding are
+// told to wait
+const LoadShedRetryAfter = 10 * time.Second
+
+// LoadShedder reports when the process uses more memory than its
+// threshold, so new synchronous processing can be turned away before the
+// process is killed for running out of memory
+type LoadShedder struct {
+	threshold uint64
+	read      func() uint64
+	now       func() time.Time
+
+	mu        sync.Mutex
+	sample    uint64
+	sampledAt time.Time
+}
+
+// NewLoadShedder creates a LoadShedder for a threshold in bytes; 0 disables it
+func NewLoadShedder(threshold uint64) *LoadShedder {
+	return &LoadShedder{threshold: threshold, read: readMemoryInUse, now: time.Now}
+}
+
+// Enabled reports whether a threshold is set
+func (ls *LoadShedder) Enabled() bool {
+	return ls != nil && ls.threshold > 0
+}
+
+// Threshold returns the memory threshold in bytes
+func (ls *LoadShedder) Threshold() uint64 {
+	return ls.threshold
+}
+
+// Overloaded reports whether memory in use is above the threshold, along
+// with the memory in use
+func (ls *LoadShedder) Overloaded() (bool, uint64) {
+	if !ls.Enabled() {
+		return false, 0
+	}
+	ls.mu.Lock()
+	defer ls.mu.Unlock()
+	if now := ls.now(); now.Sub(ls.sampledAt) >= loadSampleInterval {
+		ls.sample, ls.sampledAt = ls.read(), now
+	}
+	return ls.sample > ls.threshold, ls.sample
+}
+
+// readMemoryInUse returns the memory mapped by the Go runtime and not
+// returned to the operating system
+func readMemoryInUse() uint64 {
+	samples := []metrics.Sample{{Name: memoryTotalMetric}, {Name: memoryReleasedMetric}}
+	metrics.Read(samples)
+	if samples[0].Value.Kind() != metrics.KindUint64 || samples[1].Value.Kind() != metrics.KindUint64 {
+		return 0
+	}
+	return samples[0].Value.Uint64() - samples[1].Value.Uint64()
+}
@@ -0,0 +1,36 @@
+package services
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestLoadShedder(t *testing.T) {
+	var disabled *LoadShedder
+	overloaded, _ := disabled.Overloaded()
+	assert.False(t, overloaded)
+	assert.False(t, NewLoadShedder(0).Enabled())
+
+	inUse := uint64(300 << 20)
+	now := time.Date(2024, 7, 1, 9, 0, 0, 0, time.UTC)
+	ls := NewLoadShedder(512 << 20)
+	ls.read = func() uint64 { return inUse }
+	ls.now = func() time.Time { return now }
+
+	overloaded, bytes := ls.Overloaded()
+	assert.False(t, overloaded)
+	assert.Equal(t, inUse, bytes)
+
+	// Samples are reused briefly
+	inUse = 600 << 20
+	overloaded, _ = ls.Overloaded()
+	assert.False(t, overloaded)
+	now = now.Add(loadSampleInterval)
+	overloaded, bytes = ls.Overloaded()
+	assert.True(t, overloaded)
+	assert.Equal(t, uint64(600<<20), bytes)
+
+	assert.NotZero(t, readMemoryInUse(), "the runtime metrics should be readable")
+}
@@ -0,0 +1,68 @@
+package services
+
+import (
+	"fmt"
+	"strconv"
+)
+
+// Result metrics
+const (
+	// MetricDefault sums sales under the original "Total Number of Sales" header
+	MetricDefault = ""
+	// MetricSum sums the sales values of each department
+	MetricSum = "sum"
+	// MetricCount counts each department's sales rows
+	MetricCount = "count"
+	// MetricBoth reports the count and the sum
+	MetricBoth = "both"
+)
+
+// Result file column headers for each metric
+const (
+	headerDefaultTotal = "Total Number of Sales"
+	headerSalesAmount  = "Total Sales Amount"
+	headerSalesCount   = "Sales Count"
+)
+
+// ValidateMetric checks that metric is a known result metric
+func ValidateMetric(metric string) error {
+	switch metric {
+	case MetricDefault, MetricSum, MetricCount, MetricBoth:
+		return nil
+	}
+	return fmt.Errorf("invalid metric %q: expected count, sum or both", metric)
+}
+
+// MetricIncludesCount reports whether metric asks for sales row counts
+func MetricIncludesCount(metric string) bool {
+	return metric == MetricCount || metric == MetricBoth
+}
+
+// MetricIncludesSum reports whether metric asks for summed sales amounts
+func MetricIncludesSum(metric string) bool {
+	return metric != MetricCount
+}
+
+// metricHeaders returns the result file columns written for metric
+func metricHeaders(metric string) []string {
+	switch metric {
+	case MetricSum:
+		return []string{headerSalesAmount}
+	case MetricCount:
+		return []string{headerSalesCount}
+	case MetricBoth:
+		return []string{headerSalesCount, headerSalesAmount}
+	}
+	return []string{headerDefaultTotal}
+}
+
+// metricValues returns a summary's values for the columns of metricHeaders
+func metricValues(summary DepartmentSummary, metric string, format NumberFormat) []string {
+	switch metric {
+	case MetricCount:
+		return []string{strconv.Itoa(summary.SalesCount)}
+	case MetricBoth:
+		return []string{strconv.Itoa(summary.SalesCount), format.FormatAmount(summary.TotalSales)}
+	}
+	return []string{format.FormatAmount(summary.TotalSales)}
+}
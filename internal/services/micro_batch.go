@@ -0,0 +1,309 @@
+package services
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/mussietl/csv-sales-api/internal/models"
+	"github.com/sirupsen/logrus"
+)
+
+// EventMicroBatchClosed is published when a micro-batch window closes into
+// its combined result, or fails to
+const EventMicroBatchClosed = "micro_batch.closed"
+
+// Micro-batch window states
+const (
+	MicroBatchOpen   = "open"
+	MicroBatchClosed = "closed"
+	MicroBatchFailed = "failed"
+)
+
+// maxClosedMicroBatches is how many closed windows are kept to be looked up
+const maxClosedMicroBatches = 200
+
+// ErrMicroBatchNotFound is returned for a window ID that is not known
+var ErrMicroBatchNotFound = errors.New("micro-batch window not found")
+
+// MicroBatchOptions shape the combined result of a window. They are taken
+// from the upload that opened it.
+type MicroBatchOptions struct {
+	Order        string `json:"order,omitempty"`
+	Metric       string `json:"metric,omitempty"`
+	OutputFormat string `json:"output_format,omitempty"`
+	Precision    int    `json:"precision"`
+	Rounding     string `json:"rounding"`
+}
+
+// MicroBatch is a window in which a tenant's uploads to a feed are combined
+// into one result when it closes
+type MicroBatch struct {
+	ID          string              `json:"id"`
+	Tenant      string              `json:"tenant"`
+	Feed        string              `json:"feed,omitempty"`
+	State       string              `json:"state"`
+	OpenedAt    time.Time           `json:"opened_at"`
+	ClosesAt    time.Time           `json:"closes_at"`
+	ClosedAt    *time.Time          `json:"closed_at,omitempty"`
+	UploadIDs   []string            `json:"upload_ids"`
+	Rows        models.RowStats     `json:"rows"`
+	Summaries   []DepartmentSummary `json:"departments"`
+	Options     MicroBatchOptions   `json:"options"`
+	ResultID    string              `json:"result_id,omitempty"`
+	DownloadURL string              `json:"download_url,omitempty"`
+	Error       string              `json:"error,omitempty"`
+}
+
+// MicroBatcher coalesces small uploads into windows per tenant and feed.
+// The first upload opens a window; uploads within its duration join it, and
+// when it closes the totals are saved as one result file. Windows are
+// persisted to a JSON file, so open ones survive a restart.
+type MicroBatcher struct {
+	path        string
+	window      time.Duration
+	fileService *FileService
+	events      *EventBus
+	logger      *logrus.Logger
+	now         func() time.Time
+
+	mu      sync.Mutex
+	batches map[string]*MicroBatch
+	// open maps a tenant and feed to its open window
+	open   map[string]string
+	timers map[string]*time.Timer
+}
+
+// NewMicroBatcher creates a MicroBatcher with windows of the given duration,
+// loading windows from path. Windows that should have closed while the
+// server was down are closed once Start is called.
+func NewMicroBatcher(path string, window time.Duration, fileService *FileService, events *EventBus, logger *logrus.Logger) (*MicroBatcher, error) {
+	mb := &MicroBatcher{
+		path:        path,
+		window:      window,
+		fileService: fileService,
+		events:      events,
+		logger:      logger,
+		now:         time.Now,
+		batches:     make(map[string]*MicroBatch),
+		open:        make(map[string]string),
+		timers:      make(map[string]*time.Timer),
+	}
+
+	data, err := os.ReadFile(path)
+	if err != nil && !errors.Is(err, os.ErrNotExist) {
+		return nil, fmt.Errorf("failed to read micro-batches: %w", err)
+	}
+	if err == nil {
+		if err := json.Unmarshal(data, &mb.batches); err != nil {
+			return nil, fmt.Errorf("failed to decode micro-batches: %w", err)
+		}
+	}
+	for id, batch := range mb.batches {
+		if batch.State == MicroBatchOpen {
+			mb.open[microBatchKey(batch.Tenant, batch.Feed)] = id
+		}
+	}
+	return mb, nil
+}
+
+// Start schedules the closing of the windows loaded from disk
+func (mb *MicroBatcher) Start() {
+	mb.mu.Lock()
+	defer mb.mu.Unlock()
+	for _, id := range mb.open {
+		mb.schedule(mb.batches[id])
+	}
+}
+
+// Add adds the totals of a processed upload to the open window of its
+// tenant and feed, opening one if there is none, and returns the window
+func (mb *MicroBatcher) Add(tenant, feed, uploadID string, result *ProcessResult, opts MicroBatchOptions) (MicroBatch, error) {
+	mb.mu.Lock()
+	defer mb.mu.Unlock()
+
+	key := microBatchKey(tenant, feed)
+	batch, ok := mb.batches[mb.open[key]]
+	opened := !ok
+	if opened {
+		now := mb.now().UTC()
+		batch = &MicroBatch{
+			ID:       uuid.New().String(),
+			Tenant:   tenant,
+			Feed:     feed,
+			State:    MicroBatchOpen,
+			OpenedAt: now,
+			ClosesAt: now.Add(mb.window),
+			Options:  opts,
+		}
+	}
+	previous := *batch
+	merged := MergeResults([]*ProcessResult{{Summaries: batch.Summaries, Rows: batch.Rows}, result})
+	batch.Summaries, batch.Rows = merged.Summaries, merged.Rows
+	batch.UploadIDs = append(append([]string{}, batch.UploadIDs...), uploadID)
+
+	if opened {
+		mb.batches[batch.ID] = batch
+		mb.open[key] = batch.ID
+	}
+	if err := mb.save(); err != nil {
+		if opened {
+			delete(mb.batches, batch.ID)
+			delete(mb.open, key)
+		} else {
+			*batch = previous
+		}
+		return MicroBatch{}, err
+	}
+	if opened {
+		mb.schedule(batch)
+		mb.logger.Infof("Opened micro-batch window %s for tenant %s, closing at %s", batch.ID, tenant, batch.ClosesAt.Format(time.RFC3339))
+	}
+	return *batch, nil
+}
+
+// Get returns a window of a tenant
+func (mb *MicroBatcher) Get(tenant, id string) (MicroBatch, error) {
+	mb.mu.Lock()
+	defer mb.mu.Unlock()
+	batch, ok := mb.batches[id]
+	if !ok || batch.Tenant != tenant {
+		return MicroBatch{}, fmt.Errorf("%w: %s", ErrMicroBatchNotFound, id)
+	}
+	return *batch, nil
+}
+
+// List returns a tenant's windows, newest first
+func (mb *MicroBatcher) List(tenant string) []MicroBatch {
+	mb.mu.Lock()
+	defer mb.mu.Unlock()
+	batches := []MicroBatch{}
+	for _, batch := range mb.batches {
+		if batch.Tenant == tenant {
+			batches = append(batches, *batch)
+		}
+	}
+	sort.Slice(batches, func(i, j int) bool { return batches[i].OpenedAt.After(batches[j].OpenedAt) })
+	return batches
+}
+
+// CloseAll closes every open window now, as at the end of its duration
+func (mb *MicroBatcher) CloseAll() {
+	mb.mu.Lock()
+	ids := make([]string, 0, len(mb.open))
+	for _, id := range mb.open {
+		ids = append(ids, id)
+	}
+	mb.mu.Unlock()
+	for _, id := range ids {
+		mb.close(id)
+	}
+}
+
+// schedule arms the timer that closes a window; callers hold the lock
+func (mb *MicroBatcher) schedule(batch *MicroBatch) {
+	id := batch.ID
+	mb.timers[id] = time.AfterFunc(batch.ClosesAt.Sub(mb.now()), func() { mb.close(id) })
+}
+
+// close saves the combined result of an open window and publishes it
+func (mb *MicroBatcher) close(id string) {
+	mb.mu.Lock()
+	batch, ok := mb.batches[id]
+	if !ok || batch.State != MicroBatchOpen {
+		mb.mu.Unlock()
+		return
+	}
+	if timer, ok := mb.timers[id]; ok {
+		timer.Stop()
+		delete(mb.timers, id)
+	}
+	delete(mb.open, microBatchKey(batch.Tenant, batch.Feed))
+	summaries := append([]DepartmentSummary{}, batch.Summaries...)
+	opts := batch.Options
+	mb.mu.Unlock()
+
+	// The result file is written outside the lock; the window is no longer
+	// open, so uploads arriving meanwhile open a new one
+	SortSummaries(summaries, opts.Order)
+	format := NumberFormat{Precision: opts.Precision, Rounding: opts.Rounding}
+	resultPath, err := mb.fileService.ForTenant(batch.Tenant).SaveFormattedResultFile(summaries, format, opts.Metric, nil, opts.OutputFormat)
+
+	mb.mu.Lock()
+	closedAt := mb.now().UTC()
+	batch.ClosedAt = &closedAt
+	if err != nil {
+		batch.State = MicroBatchFailed
+		batch.Error = err.Error()
+		mb.logger.Errorf("Failed to save the result of micro-batch window %s: %v", id, err)
+	} else {
+		batch.State = MicroBatchClosed
+		batch.ResultID = mb.fileService.ResultID(resultPath)
+		batch.DownloadURL = mb.fileService.GetDownloadURL(resultPath)
+		mb.logger.Infof("Closed micro-batch window %s with %d uploads into result %s", id, len(batch.UploadIDs), batch.ResultID)
+	}
+	mb.prune()
+	if err := mb.save(); err != nil {
+		mb.logger.Errorf("Failed to record closing of micro-batch window %s: %v", id, err)
+	}
+	closed := *batch
+	mb.mu.Unlock()
+
+	event := Event{
+		Type:             EventMicroBatchClosed,
+		Tenant:           closed.Tenant,
+		MicroBatchID:     closed.ID,
+		UploadIDs:        closed.UploadIDs,
+		Success:          err == nil,
+		Error:            closed.Error,
+		TotalDepartments: len(summaries),
+		Summaries:        summaries,
+		ResultID:         closed.ResultID,
+		DownloadURL:      closed.DownloadURL,
+		Rows:             &closed.Rows,
+		ResultPath:       resultPath,
+	}
+	var total models.Amount
+	for _, summary := range summaries {
+		total = total.Add(summary.TotalSales)
+	}
+	event.TotalSales = &total
+	mb.events.Publish(event)
+}
+
+// prune forgets the oldest closed windows beyond maxClosedMicroBatches;
+// callers hold the lock
+func (mb *MicroBatcher) prune() {
+	var closed []*MicroBatch
+	for _, batch := range mb.batches {
+		if batch.State != MicroBatchOpen {
+			closed = append(closed, batch)
+		}
+	}
+	if len(closed) <= maxClosedMicroBatches {
+		return
+	}
+	sort.Slice(closed, func(i, j int) bool { return closed[i].ClosedAt.After(*closed[j].ClosedAt) })
+	for _, batch := range closed[maxClosedMicroBatches:] {
+		delete(mb.batches, batch.ID)
+	}
+}
+
+// save writes the windows to disk; callers hold the lock
+func (mb *MicroBatcher) save() error {
+	data, err := json.MarshalIndent(mb.batches, "", "  ")
+	if err != nil {
+		return fmt.Errorf("failed to encode micro-batches: %w", err)
+	}
+	return writeFileAtomic(mb.path, data, 0600)
+}
+
+// microBatchKey identifies the window of a tenant's feed
+func microBatchKey(tenant, feed string) string {
+	return tenant + "\x00" + feed
+}
@@ -0,0 +1,97 @@
+package services
+
+import (
+	"os"
+	"path/filepath"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/mussietl/csv-sales-api/internal/models"
+	"github.com/sirupsen/logrus"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestMicroBatcher(t *testing.T) {
+	logger := logrus.New()
+	logger.SetLevel(logrus.ErrorLevel)
+	dir := t.TempDir()
+	fs := NewFileService(dir, logger)
+	events := NewEventBus(logger)
+	var mu sync.Mutex
+	var closed []Event
+	events.Subscribe(func(e Event) {
+		mu.Lock()
+		defer mu.Unlock()
+		closed = append(closed, e)
+	}, EventMicroBatchClosed)
+	path := filepath.Join(dir, "micro_batches.json")
+	mb, err := NewMicroBatcher(path, time.Hour, fs, events, logger)
+	require.NoError(t, err)
+	mb.Start()
+
+	upload := func(department, total string) *ProcessResult {
+		return &ProcessResult{
+			Summaries: []DepartmentSummary{{Department: department, TotalSales: amount(t, total), SalesCount: 1}},
+			Rows:      models.RowStats{Total: 1, Processed: 1},
+		}
+	}
+	opts := MicroBatchOptions{Order: "department", OutputFormat: OutputFormatCSV, Precision: AutoPrecision, Rounding: RoundHalfUp}
+	first, err := mb.Add("acme", "stores", "u1", upload("Toys", "5"), opts)
+	require.NoError(t, err)
+	second, err := mb.Add("acme", "stores", "u2", upload("Books", "10"), opts)
+	require.NoError(t, err)
+	assert.Equal(t, first.ID, second.ID, "uploads within the window join it")
+	assert.Equal(t, []string{"u1", "u2"}, second.UploadIDs)
+	assert.Equal(t, 2, second.Rows.Total)
+	other, err := mb.Add("acme", "warehouses", "u3", upload("Books", "1"), opts)
+	require.NoError(t, err)
+	assert.NotEqual(t, first.ID, other.ID, "each feed has its own window")
+	_, err = mb.Get("globex", first.ID)
+	assert.ErrorIs(t, err, ErrMicroBatchNotFound)
+
+	// Open windows survive a restart and close once they are due
+	mb, err = NewMicroBatcher(path, time.Hour, fs, events, logger)
+	require.NoError(t, err)
+	mb.now = func() time.Time { return time.Now().Add(2 * time.Hour) }
+	_, err = mb.Add("acme", "stores", "u4", upload("Books", "2.5"), opts)
+	require.NoError(t, err)
+	mb.Start()
+	require.Eventually(t, func() bool {
+		mu.Lock()
+		defer mu.Unlock()
+		return len(closed) == 2
+	}, time.Second, 10*time.Millisecond)
+	mb.CloseAll()
+
+	batch, err := mb.Get("acme", first.ID)
+	require.NoError(t, err)
+	assert.Equal(t, []string{"u1", "u2", "u4"}, batch.UploadIDs)
+	assert.NotEmpty(t, batch.ResultID)
+	assert.Equal(t, "/api/v1/results/"+batch.ResultID+"/download", batch.DownloadURL)
+	resultPath, err := fs.ResultPath(batch.ResultID)
+	require.NoError(t, err)
+	data, err := os.ReadFile(resultPath)
+	require.NoError(t, err)
+	assert.Equal(t, "Department Name,Total Number of Sales\nBooks,12.5\nToys,5\n", string(data))
+
+	require.Len(t, closed, 2)
+	for _, e := range closed {
+		assert.True(t, e.Success)
+		if e.MicroBatchID == first.ID {
+			assert.Equal(t, "12.5", e.Summaries[0].TotalSales.String())
+			assert.Equal(t, "17.5", e.TotalSales.String())
+		}
+	}
+	for _, window := range mb.List("acme") {
+		assert.Equal(t, MicroBatchClosed, window.State)
+	}
+
+	// A new upload opens a new window
+	next, err := mb.Add("acme", "stores", "u5", upload("Books", "1"), opts)
+	require.NoError(t, err)
+	assert.NotEqual(t, first.ID, next.ID)
+	assert.Len(t, mb.List("acme"), 3)
+	assert.Empty(t, mb.List("globex"))
+}
@@ -0,0 +1,65 @@
+package services
+
+import (
+	"database/sql"
+	"embed"
+	"fmt"
+	"path"
+	"strings"
+	"time"
+)
+
+// migrationFiles holds the SQL migrations of the history database, applied
+// in file name order. Files are never edited once released; a schema change
+// is a new file.
+//
+//go:embed migrations/*.sql
+var migrationFiles embed.FS
+
+// migrate applies the embedded migrations not yet recorded in the
+// schema_migrations table, each in its own transaction
+func migrate(db *sql.DB) error {
+	if _, err := db.Exec(`CREATE TABLE IF NOT EXISTS schema_migrations (
+	version    TEXT PRIMARY KEY,
+	applied_at TEXT NOT NULL
+)`); err != nil {
+		return fmt.Errorf("failed to create migrations table: %w", err)
+	}
+
+	entries, err := migrationFiles.ReadDir("migrations")
+	if err != nil {
+		return fmt.Errorf("failed to read migrations: %w", err)
+	}
+	for _, entry := range entries {
+		version := strings.TrimSuffix(entry.Name(), ".sql")
+		var applied int
+		if err := db.QueryRow(`SELECT COUNT(*) FROM schema_migrations WHERE version = $1`, version).Scan(&applied); err != nil {
+			return fmt.Errorf("failed to look up migration %s: %w", version, err)
+		}
+		if applied > 0 {
+			continue
+		}
+
+		script, err := migrationFiles.ReadFile(path.Join("migrations", entry.Name()))
+		if err != nil {
+			return fmt.Errorf("failed to read migration %s: %w", version, err)
+		}
+		tx, err := db.Begin()
+		if err != nil {
+			return fmt.Errorf("failed to start migration %s: %w", version, err)
+		}
+		if _, err := tx.Exec(string(script)); err != nil {
+			tx.Rollback()
+			return fmt.Errorf("migration %s failed: %w", version, err)
+		}
+		if _, err := tx.Exec(`INSERT INTO schema_migrations (version, applied_at) VALUES ($1, $2)`,
+			version, time.Now().UTC().Format(historyTimeLayout)); err != nil {
+			tx.Rollback()
+			return fmt.Errorf("failed to record migration %s: %w", version, err)
+		}
+		if err := tx.Commit(); err != nil {
+			return fmt.Errorf("failed to commit migration %s: %w", version, err)
+		}
+	}
+	return nil
+}
@@ -0,0 +1,21 @@
+-- The processing history of every stored upload. This SQL and the queries
+-- of HistoryRepository run unchanged on SQLite and PostgreSQL.
+CREATE TABLE IF NOT EXISTS upload_history (
+	upload_id         TEXT PRIMARY KEY,
+	tenant            TEXT NOT NULL,
+	filename          TEXT NOT NULL,
+	size              BIGINT NOT NULL DEFAULT 0,
+	status            TEXT NOT NULL,
+	error             TEXT NOT NULL DEFAULT '',
+	rows_total        INTEGER,
+	rows_processed    INTEGER,
+	rows_skipped      INTEGER,
+	total_sales       TEXT,
+	total_departments INTEGER NOT NULL DEFAULT 0,
+	departments       TEXT NOT NULL DEFAULT '[]',
+	result_id         TEXT NOT NULL DEFAULT '',
+	result_path       TEXT NOT NULL DEFAULT '',
+	received_at       TEXT NOT NULL,
+	completed_at      TEXT
+);
+CREATE INDEX IF NOT EXISTS upload_history_tenant ON upload_history (tenant, received_at);
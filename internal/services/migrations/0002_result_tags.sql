@@ -0,0 +1,3 @@
+-- Tags given at upload, as a JSON array of lowercase strings, so results can
+-- be searched by tag
+ALTER TABLE upload_history ADD COLUMN tags TEXT NOT NULL DEFAULT '[]';
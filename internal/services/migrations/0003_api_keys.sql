@@ -0,0 +1,14 @@
+-- API keys, stored as the SHA-256 hash of the key. Scopes are a JSON array.
+CREATE TABLE IF NOT EXISTS api_keys (
+	id           TEXT PRIMARY KEY,
+	name         TEXT NOT NULL,
+	prefix       TEXT NOT NULL,
+	hash         TEXT NOT NULL UNIQUE,
+	scopes       TEXT NOT NULL DEFAULT '[]',
+	tenant       TEXT NOT NULL DEFAULT '',
+	created_at   TEXT NOT NULL,
+	expires_at   TEXT,
+	last_used_at TEXT,
+	rotated_at   TEXT,
+	disabled     INTEGER NOT NULL DEFAULT 0
+);
@@ -0,0 +1,190 @@
+package services
+
+import (
+	"bufio"
+	"encoding/json"
+	"fmt"
+	"net"
+	"net/url"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/sirupsen/logrus"
+)
+
+// natsQueueSize is the number of events buffered while NATS is slow or down;
+// further events are dropped
+const natsQueueSize = 1024
+
+// natsDialTimeout bounds connecting and the connection handshake
+const natsDialTimeout = 5 * time.Second
+
+// NATSPublisher forwards events to a NATS server as JSON messages on
+// <prefix>.<event type>. It speaks the core NATS text protocol, publishes
+// from a background goroutine and reconnects after failures.
+type NATSPublisher struct {
+	addr   string
+	user   string
+	pass   string
+	prefix string
+	queue  chan Event
+	done   chan struct{}
+	logger *logrus.Logger
+
+	// mu serializes writes to conn, which are shared with the PING replies
+	mu   sync.Mutex
+	conn net.Conn
+}
+
+// NewNATSPublisher creates a publisher for a nats://[user:pass@]host[:port] URL
+func NewNATSPublisher(rawURL, prefix string, logger *logrus.Logger) (*NATSPublisher, error) {
+	u, err := url.Parse(rawURL)
+	if err != nil || u.Scheme != "nats" || u.Hostname() == "" {
+		return nil, fmt.Errorf("invalid NATS URL %q: expected nats://host[:port]", rawURL)
+	}
+	if strings.TrimSpace(prefix) == "" || strings.ContainsAny(prefix, " \t\r\n*>") {
+		return nil, fmt.Errorf("invalid NATS subject prefix %q", prefix)
+	}
+	port := u.Port()
+	if port == "" {
+		port = "4222"
+	}
+
+	np := &NATSPublisher{
+		addr:   net.JoinHostPort(u.Hostname(), port),
+		prefix: prefix,
+		queue:  make(chan Event, natsQueueSize),
+		done:   make(chan struct{}),
+		logger: logger,
+	}
+	if u.User != nil {
+		np.user = u.User.Username()
+		np.pass, _ = u.User.Password()
+	}
+	go np.run()
+	return np, nil
+}
+
+// Handle queues e for publishing. It never blocks; events are dropped while
+// the queue is full.
+func (np *NATSPublisher) Handle(e Event) {
+	select {
+	case np.queue <- e:
+	default:
+		np.logger.Warnf("NATS queue full, dropping %s event", e.Type)
+	}
+}
+
+// Close publishes the queued events and closes the connection
+func (np *NATSPublisher) Close() {
+	close(np.queue)
+	<-np.done
+}
+
+// run publishes queued events until the queue is closed
+func (np *NATSPublisher) run() {
+	defer close(np.done)
+	defer np.disconnect()
+
+	for e := range np.queue {
+		payload, err := json.Marshal(e)
+		if err != nil {
+			np.logger.Errorf("Failed to encode %s event: %v", e.Type, err)
+			continue
+		}
+		subject := np.prefix + "." + e.Type
+
+		// A dropped connection is only noticed on write, so retry once on a new one
+		for attempt := 1; attempt <= 2; attempt++ {
+			if err = np.publish(subject, payload); err == nil {
+				break
+			}
+			np.disconnect()
+		}
+		if err != nil {
+			np.logger.Errorf("Failed to publish %s event to NATS: %v", e.Type, err)
+		}
+	}
+}
+
+// publish sends one message, connecting first if needed
+func (np *NATSPublisher) publish(subject string, payload []byte) error {
+	np.mu.Lock()
+	defer np.mu.Unlock()
+	if np.conn == nil {
+		if err := np.connect(); err != nil {
+			return err
+		}
+	}
+	msg := fmt.Sprintf("PUB %s %d\r\n%s\r\n", subject, len(payload), payload)
+	np.conn.SetWriteDeadline(time.Now().Add(natsDialTimeout))
+	_, err := np.conn.Write([]byte(msg))
+	return err
+}
+
+// connect dials the server and completes the handshake. Callers must hold np.mu.
+func (np *NATSPublisher) connect() error {
+	conn, err := net.DialTimeout("tcp", np.addr, natsDialTimeout)
+	if err != nil {
+		return fmt.Errorf("failed to connect to NATS: %w", err)
+	}
+	conn.SetDeadline(time.Now().Add(natsDialTimeout))
+	reader := bufio.NewReader(conn)
+
+	// The server opens with INFO, then answers our PING once CONNECT is accepted
+	if line, err := reader.ReadString('\n'); err != nil || !strings.HasPrefix(line, "INFO") {
+		conn.Close()
+		return fmt.Errorf("unexpected NATS greeting %q: %v", strings.TrimSpace(line), err)
+	}
+	fields := map[string]interface{}{"verbose": false, "pedantic": false, "name": "csv-sales-api", "lang": "go"}
+	if np.user != "" {
+		fields["user"], fields["pass"] = np.user, np.pass
+	}
+	options, _ := json.Marshal(fields)
+	if _, err := fmt.Fprintf(conn, "CONNECT %s\r\nPING\r\n", options); err != nil {
+		conn.Close()
+		return fmt.Errorf("failed to send NATS handshake: %w", err)
+	}
+	line, err := reader.ReadString('\n')
+	if err != nil || !strings.HasPrefix(line, "PONG") {
+		conn.Close()
+		return fmt.Errorf("NATS handshake rejected: %q: %v", strings.TrimSpace(line), err)
+	}
+	conn.SetDeadline(time.Time{})
+
+	np.conn = conn
+	go np.serve(conn, reader)
+	np.logger.Infof("Connected to NATS at %s", np.addr)
+	return nil
+}
+
+// serve answers the server's keepalive PINGs until conn is closed
+func (np *NATSPublisher) serve(conn net.Conn, reader *bufio.Reader) {
+	for {
+		line, err := reader.ReadString('\n')
+		if err != nil {
+			return
+		}
+		switch {
+		case strings.HasPrefix(line, "PING"):
+			np.mu.Lock()
+			if np.conn == conn {
+				conn.Write([]byte("PONG\r\n"))
+			}
+			np.mu.Unlock()
+		case strings.HasPrefix(line, "-ERR"):
+			np.logger.Errorf("NATS error: %s", strings.TrimSpace(line))
+		}
+	}
+}
+
+// disconnect closes the current connection, if any
+func (np *NATSPublisher) disconnect() {
+	np.mu.Lock()
+	defer np.mu.Unlock()
+	if np.conn != nil {
+		np.conn.Close()
+		np.conn = nil
+	}
+}
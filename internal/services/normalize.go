@@ -0,0 +1,93 @@
+package services
+
+import (
+	"fmt"
+	"strings"
+	"unicode"
+)
+
+// Normalization steps that can be applied to header and value cells
+const (
+	NormalizeTrim     = "trim"
+	NormalizeCollapse = "collapse"
+	NormalizeFold     = "fold"
+)
+
+// Normalization describes the cleanup applied to a header or value cell
+type Normalization struct {
+	// Trim removes leading and trailing whitespace
+	Trim bool
+	// CollapseWhitespace replaces internal whitespace runs with a single space
+	CollapseWhitespace bool
+	// CaseFold lowercases the text
+	CaseFold bool
+}
+
+// DefaultHeaderNormalization is applied to header cells before column matching
+var DefaultHeaderNormalization = Normalization{Trim: true, CollapseWhitespace: true, CaseFold: true}
+
+// DefaultValueNormalization is applied to department, sales and distinct values
+var DefaultValueNormalization = Normalization{Trim: true}
+
+// ParseNormalization parses a comma-separated list of steps (trim, collapse,
+// fold) or "none" into a Normalization
+func ParseNormalization(spec string) (Normalization, error) {
+	var n Normalization
+	for _, step := range strings.Split(spec, ",") {
+		switch strings.ToLower(strings.TrimSpace(step)) {
+		case NormalizeTrim:
+			n.Trim = true
+		case NormalizeCollapse:
+			n.CollapseWhitespace = true
+		case NormalizeFold:
+			n.CaseFold = true
+		case "none", "":
+		default:
+			return n, fmt.Errorf("unknown normalization step %q: expected trim, collapse, fold or none", step)
+		}
+	}
+	return n, nil
+}
+
+// Apply normalizes a single cell, applying the steps in the order Steps lists
+func (n Normalization) Apply(s string) string {
+	if n.Trim {
+		s = strings.TrimSpace(s)
+	}
+	if n.CollapseWhitespace {
+		var b strings.Builder
+		b.Grow(len(s))
+		inSpace := false
+		for _, r := range s {
+			if unicode.IsSpace(r) {
+				if !inSpace {
+					b.WriteByte(' ')
+				}
+				inSpace = true
+				continue
+			}
+			inSpace = false
+			b.WriteRune(r)
+		}
+		s = b.String()
+	}
+	if n.CaseFold {
+		s = strings.ToLower(s)
+	}
+	return s
+}
+
+// Steps lists the enabled steps in application order
+func (n Normalization) Steps() []string {
+	steps := []string{}
+	if n.Trim {
+		steps = append(steps, NormalizeTrim)
+	}
+	if n.CollapseWhitespace {
+		steps = append(steps, NormalizeCollapse)
+	}
+	if n.CaseFold {
+		steps = append(steps, NormalizeFold)
+	}
+	return steps
+}
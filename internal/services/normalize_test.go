@@ -0,0 +1,129 @@
+package services
+
+import (
+	"github.com/mussietl/csv-sales-api/internal/models"
+	"os"
+	"strings"
+	"testing"
+	"unicode"
+
+	"github.com/sirupsen/logrus"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestParseNormalization(t *testing.T) {
+	n, err := ParseNormalization("trim, FOLD")
+	require.NoError(t, err)
+	assert.Equal(t, Normalization{Trim: true, CaseFold: true}, n)
+
+	n, err = ParseNormalization("none")
+	require.NoError(t, err)
+	assert.Equal(t, Normalization{}, n)
+	assert.Empty(t, n.Steps())
+
+	_, err = ParseNormalization("trim,upper")
+	assert.Error(t, err)
+}
+
+func TestNormalizationApply(t *testing.T) {
+	tests := []struct {
+		name     string
+		norm     Normalization
+		input    string
+		expected string
+	}{
+		{"trim only", Normalization{Trim: true}, "  Home  &  Garden\t", "Home  &  Garden"},
+		{"collapse only", Normalization{CollapseWhitespace: true}, "  Home \t & Garden ", " Home & Garden "},
+		{"all steps", DefaultHeaderNormalization, " Total \n Sales ", "total sales"},
+		{"none", Normalization{}, " Dept ", " Dept "},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			assert.Equal(t, tt.expected, tt.norm.Apply(tt.input))
+		})
+	}
+}
+
+func TestCSVServiceProcessNormalization(t *testing.T) {
+	logger := logrus.New()
+	logger.SetLevel(logrus.ErrorLevel)
+	csvService := NewCSVService(logger)
+
+	tempFile, err := os.CreateTemp("", "test_*.csv")
+	require.NoError(t, err)
+	defer os.Remove(tempFile.Name())
+
+	_, err = tempFile.WriteString("  Department ,Total   Sales\nHome  Garden,10\n home garden ,5\nHOME GARDEN,1\n")
+	require.NoError(t, err)
+	tempFile.Close()
+
+	// Defaults keep department values distinct apart from trimming
+	result, err := csvService.Process(tempFile.Name(), ProcessOptions{})
+	require.NoError(t, err)
+	assert.Len(t, result.Summaries, 3)
+	assert.Equal(t, "total sales", result.Schema.Columns[1].Normalized)
+	assert.Equal(t, []string{"trim", "collapse", "fold"}, result.Schema.HeaderNormalization)
+	assert.Equal(t, []string{"trim"}, result.Schema.ValueNormalization)
+
+	// Full value normalization merges them
+	values := Normalization{Trim: true, CollapseWhitespace: true, CaseFold: true}
+	result, err = csvService.Process(tempFile.Name(), ProcessOptions{ValueNormalization: &values})
+	require.NoError(t, err)
+	require.Len(t, result.Summaries, 1)
+	assert.Equal(t, "home garden", result.Summaries[0].Department)
+	assert.Equal(t, models.WholeAmount(16), result.Summaries[0].TotalSales)
+
+	// Without header case folding the synonyms no longer match
+	headers := Normalization{Trim: true, CollapseWhitespace: true}
+	_, err = csvService.Process(tempFile.Name(), ProcessOptions{HeaderNormalization: &headers})
+	assert.Error(t, err)
+}
+
+func FuzzNormalizationApply(f *testing.F) {
+	for _, seed := range []string{"", " ", "Department", "  Total \t Sales  ", " dept name\r\n", "ÉLECTRONICS"} {
+		f.Add(seed)
+	}
+
+	full := Normalization{Trim: true, CollapseWhitespace: true, CaseFold: true}
+	f.Fuzz(func(t *testing.T, input string) {
+		out := full.Apply(input)
+
+		if full.Apply(out) != out {
+			t.Fatalf("normalization not idempotent for %q: %q", input, out)
+		}
+		if strings.TrimSpace(out) != out {
+			t.Fatalf("trimmed output %q has surrounding whitespace", out)
+		}
+		prevSpace := false
+		for _, r := range out {
+			if unicode.IsSpace(r) {
+				if r != ' ' || prevSpace {
+					t.Fatalf("collapsed output %q contains a whitespace run", out)
+				}
+				prevSpace = true
+				continue
+			}
+			prevSpace = false
+		}
+	})
+}
+
+func FuzzMatchColumnIndices(f *testing.F) {
+	f.Add(" Department ", "  total   SALES")
+	f.Add("dept", "amount")
+	f.Add("\tDEPARTMENT NAME\n", "Number  of Sales")
+
+	logger := logrus.New()
+	logger.SetLevel(logrus.ErrorLevel)
+	csvService := NewCSVService(logger)
+
+	f.Fuzz(func(t *testing.T, dept, sales string) {
+		header := normalizeHeader([]string{dept, sales}, DefaultHeaderNormalization)
+		deptIndex, salesIndex, err := csvService.matchColumnIndices(header)
+		if err == nil && (deptIndex < 0 || salesIndex < 0 || deptIndex >= 2 || salesIndex >= 2) {
+			t.Fatalf("indices out of range: %d, %d", deptIndex, salesIndex)
+		}
+	})
+}
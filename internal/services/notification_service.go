@@ -0,0 +1,346 @@
+package services
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/mussietl/csv-sales-api/internal/models"
+	"github.com/sirupsen/logrus"
+)
+
+// Notification channel kinds
+const (
+	ChannelSlack = "slack"
+	ChannelTeams = "teams"
+)
+
+// DefaultTenant is the channel key used when a tenant has no channels of its own
+const DefaultTenant = "*"
+
+// notificationTopN is the number of top departments listed in a summary card
+const notificationTopN = 5
+
+// Notification describes the outcome of a processing run
+type Notification struct {
+	Tenant           string
+	Filename         string
+	Success          bool
+	Error            string
+	TotalSales       models.Amount
+	TotalDepartments int
+	TopDepartments   []DepartmentSummary
+	DownloadURL      string
+	// SchemaDrift is set when the file's header differs from the feed's previous upload
+	SchemaDrift *models.SchemaDrift
+}
+
+// NotificationChannel delivers notifications to an external system
+type NotificationChannel interface {
+	Name() string
+	Send(ctx context.Context, n Notification) error
+}
+
+// NotificationService fans notifications out to the channels configured for a tenant
+type NotificationService struct {
+	channels map[string][]NotificationChannel
+	timeout  time.Duration
+	logger   *logrus.Logger
+}
+
+// NewNotificationService creates a new NotificationService instance
+func NewNotificationService(logger *logrus.Logger) *NotificationService {
+	return &NotificationService{
+		channels: make(map[string][]NotificationChannel),
+		timeout:  10 * time.Second,
+		logger:   logger,
+	}
+}
+
+// AddChannel registers a channel for a tenant, or for all tenants without
+// their own channels when tenant is DefaultTenant
+func (ns *NotificationService) AddChannel(tenant string, channel NotificationChannel) {
+	ns.channels[tenant] = append(ns.channels[tenant], channel)
+}
+
+// Configure registers channels from a spec of comma-separated
+// tenant=kind:url entries, e.g. "*=slack:https://hooks.slack.com/...,acme=teams:https://..."
+func (ns *NotificationService) Configure(spec string) error {
+	for _, entry := range strings.Split(spec, ",") {
+		entry = strings.TrimSpace(entry)
+		if entry == "" {
+			continue
+		}
+
+		tenant, target, ok := strings.Cut(entry, "=")
+		if !ok {
+			return fmt.Errorf("invalid notification channel %q: expected tenant=kind:url", entry)
+		}
+		kind, url, ok := strings.Cut(target, ":")
+		if !ok || url == "" {
+			return fmt.Errorf("invalid notification channel %q: expected tenant=kind:url", entry)
+		}
+
+		channel, err := NewWebhookChannel(strings.ToLower(kind), url)
+		if err != nil {
+			return err
+		}
+		ns.AddChannel(strings.TrimSpace(tenant), channel)
+	}
+	return nil
+}
+
+// Enabled reports whether any channel is configured
+func (ns *NotificationService) Enabled() bool {
+	return len(ns.channels) > 0
+}
+
+// HandleEvent notifies the tenant's channels of a completed processing run;
+// other events are ignored
+func (ns *NotificationService) HandleEvent(e Event) {
+	if e.Type != EventProcessingCompleted {
+		return
+	}
+	n := Notification{
+		Tenant:           e.Tenant,
+		Filename:         e.Filename,
+		Success:          e.Success,
+		Error:            e.Error,
+		TotalDepartments: e.TotalDepartments,
+		TopDepartments:   e.Summaries,
+		DownloadURL:      e.DownloadURL,
+		SchemaDrift:      e.SchemaDrift,
+	}
+	if e.TotalSales != nil {
+		n.TotalSales = *e.TotalSales
+	}
+	ns.Notify(n)
+}
+
+// Notify delivers a notification to the tenant's channels in the background
+func (ns *NotificationService) Notify(n Notification) {
+	channels := ns.channels[n.Tenant]
+	if len(channels) == 0 {
+		channels = ns.channels[DefaultTenant]
+	}
+	if len(channels) == 0 {
+		return
+	}
+
+	n.TopDepartments = topDepartments(n.TopDepartments, notificationTopN)
+	for _, channel := range channels {
+		go func(channel NotificationChannel) {
+			ctx, cancel := context.WithTimeout(context.Background(), ns.timeout)
+			defer cancel()
+			if err := channel.Send(ctx, n); err != nil {
+				ns.logger.Errorf("Failed to send %s notification: %v", channel.Name(), err)
+				return
+			}
+			ns.logger.Infof("Sent %s notification for %s", channel.Name(), n.Filename)
+		}(channel)
+	}
+}
+
+// NewWebhookChannel creates a channel of the given kind posting to url
+func NewWebhookChannel(kind, url string) (NotificationChannel, error) {
+	client := &http.Client{Timeout: 10 * time.Second}
+	switch kind {
+	case ChannelSlack:
+		return &SlackChannel{webhookURL: url, client: client}, nil
+	case ChannelTeams:
+		return &TeamsChannel{webhookURL: url, client: client}, nil
+	default:
+		return nil, fmt.Errorf("unknown notification channel kind %q: expected slack or teams", kind)
+	}
+}
+
+// SlackChannel posts Block Kit messages to a Slack incoming webhook
+type SlackChannel struct {
+	webhookURL string
+	client     *http.Client
+}
+
+// Name returns the channel kind
+func (s *SlackChannel) Name() string { return ChannelSlack }
+
+// Send posts the notification to Slack
+func (s *SlackChannel) Send(ctx context.Context, n Notification) error {
+	blocks := []map[string]interface{}{
+		{
+			"type": "header",
+			"text": map[string]string{"type": "plain_text", "text": notificationTitle(n)},
+		},
+	}
+
+	if n.Success {
+		var lines []string
+		for _, d := range n.TopDepartments {
+			lines = append(lines, fmt.Sprintf("• *%s*: %s", d.Department, d.TotalSales))
+		}
+		blocks = append(blocks,
+			map[string]interface{}{
+				"type": "section",
+				"fields": []map[string]string{
+					{"type": "mrkdwn", "text": fmt.Sprintf("*Total sales*\n%s", n.TotalSales)},
+					{"type": "mrkdwn", "text": fmt.Sprintf("*Departments*\n%d", n.TotalDepartments)},
+				},
+			},
+			map[string]interface{}{
+				"type": "section",
+				"text": map[string]string{"type": "mrkdwn", "text": "*Top departments*\n" + strings.Join(lines, "\n")},
+			},
+		)
+		if n.SchemaDrift != nil {
+			blocks = append(blocks, map[string]interface{}{
+				"type": "section",
+				"text": map[string]string{"type": "mrkdwn", "text": "*Schema drift*\n" + strings.Join(driftLines(n.SchemaDrift), "\n")},
+			})
+		}
+		if n.DownloadURL != "" {
+			blocks = append(blocks, map[string]interface{}{
+				"type": "section",
+				"text": map[string]string{"type": "mrkdwn", "text": fmt.Sprintf("<%s|Download result>", n.DownloadURL)},
+			})
+		}
+	} else {
+		blocks = append(blocks, map[string]interface{}{
+			"type": "section",
+			"text": map[string]string{"type": "mrkdwn", "text": "*Error*\n" + n.Error},
+		})
+		if n.SchemaDrift != nil {
+			blocks = append(blocks, map[string]interface{}{
+				"type": "section",
+				"text": map[string]string{"type": "mrkdwn", "text": "*Schema drift*\n" + strings.Join(driftLines(n.SchemaDrift), "\n")},
+			})
+		}
+	}
+
+	return postJSON(ctx, s.client, s.webhookURL, map[string]interface{}{
+		"text":   notificationTitle(n),
+		"blocks": blocks,
+	})
+}
+
+// TeamsChannel posts MessageCards to a Microsoft Teams incoming webhook
+type TeamsChannel struct {
+	webhookURL string
+	client     *http.Client
+}
+
+// Name returns the channel kind
+func (t *TeamsChannel) Name() string { return ChannelTeams }
+
+// Send posts the notification to Teams
+func (t *TeamsChannel) Send(ctx context.Context, n Notification) error {
+	color := "2EB886"
+	facts := []map[string]string{}
+	if n.Success {
+		facts = append(facts,
+			map[string]string{"name": "Total sales", "value": n.TotalSales.String()},
+			map[string]string{"name": "Departments", "value": fmt.Sprintf("%d", n.TotalDepartments)},
+		)
+		for _, d := range n.TopDepartments {
+			facts = append(facts, map[string]string{"name": d.Department, "value": d.TotalSales.String()})
+		}
+	} else {
+		color = "D93F0B"
+		facts = append(facts, map[string]string{"name": "Error", "value": n.Error})
+	}
+	if n.SchemaDrift != nil {
+		color = "E3A008"
+		if !n.Success {
+			color = "D93F0B"
+		}
+		facts = append(facts, map[string]string{"name": "Schema drift", "value": strings.Join(driftLines(n.SchemaDrift), "; ")})
+	}
+
+	card := map[string]interface{}{
+		"@type":      "MessageCard",
+		"@context":   "https://schema.org/extensions",
+		"summary":    notificationTitle(n),
+		"themeColor": color,
+		"sections": []map[string]interface{}{
+			{"activityTitle": notificationTitle(n), "facts": facts},
+		},
+	}
+	if n.Success && n.DownloadURL != "" {
+		card["potentialAction"] = []map[string]interface{}{
+			{
+				"@type":   "OpenUri",
+				"name":    "Download result",
+				"targets": []map[string]string{{"os": "default", "uri": n.DownloadURL}},
+			},
+		}
+	}
+
+	return postJSON(ctx, t.client, t.webhookURL, card)
+}
+
+// notificationTitle builds the headline for a notification
+func notificationTitle(n Notification) string {
+	if n.Success {
+		return fmt.Sprintf("Sales file processed: %s", n.Filename)
+	}
+	return fmt.Sprintf("Sales file processing failed: %s", n.Filename)
+}
+
+// driftLines describes each change in a schema drift
+func driftLines(d *models.SchemaDrift) []string {
+	var lines []string
+	if len(d.Added) > 0 {
+		lines = append(lines, "Added: "+strings.Join(d.Added, ", "))
+	}
+	if len(d.Removed) > 0 {
+		lines = append(lines, "Removed: "+strings.Join(d.Removed, ", "))
+	}
+	for _, r := range d.Renamed {
+		lines = append(lines, fmt.Sprintf("Renamed: %s → %s", r.From, r.To))
+	}
+	if d.Delimiter != nil {
+		lines = append(lines, fmt.Sprintf("Delimiter: %q → %q", d.Delimiter.From, d.Delimiter.To))
+	}
+	return lines
+}
+
+// topDepartments returns the n departments with the highest totals
+func topDepartments(summaries []DepartmentSummary, n int) []DepartmentSummary {
+	sorted := make([]DepartmentSummary, len(summaries))
+	copy(sorted, summaries)
+	sort.SliceStable(sorted, func(i, j int) bool {
+		return sorted[i].TotalSales.Cmp(sorted[j].TotalSales) > 0
+	})
+	if len(sorted) > n {
+		sorted = sorted[:n]
+	}
+	return sorted
+}
+
+// postJSON posts a JSON payload and treats any non-2xx status as an error
+func postJSON(ctx context.Context, client *http.Client, url string, payload interface{}) error {
+	body, err := json.Marshal(payload)
+	if err != nil {
+		return fmt.Errorf("failed to encode payload: %w", err)
+	}
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, url, bytes.NewReader(body))
+	if err != nil {
+		return fmt.Errorf("failed to create request: %w", err)
+	}
+	req.Header.Set("Content-Type", "application/json")
+
+	resp, err := client.Do(req)
+	if err != nil {
+		return fmt.Errorf("failed to post payload: %w", err)
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode < 200 || resp.StatusCode >= 300 {
+		return fmt.Errorf("unexpected status code %d", resp.StatusCode)
+	}
+	return nil
+}
@@ -0,0 +1,98 @@
+package services
+
+import (
+	"context"
+	"encoding/json"
+	"github.com/mussietl/csv-sales-api/internal/models"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/sirupsen/logrus"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestNotificationServiceConfigure(t *testing.T) {
+	logger := logrus.New()
+	logger.SetLevel(logrus.ErrorLevel)
+	ns := NewNotificationService(logger)
+
+	err := ns.Configure("*=slack:https://hooks.slack.com/services/x, acme=teams:https://example.webhook.office.com/y")
+	require.NoError(t, err)
+	assert.True(t, ns.Enabled())
+	assert.Len(t, ns.channels[DefaultTenant], 1)
+	assert.Equal(t, ChannelTeams, ns.channels["acme"][0].Name())
+
+	assert.Error(t, ns.Configure("acme"))
+	assert.Error(t, ns.Configure("acme=email:someone@example.com"))
+}
+
+func TestNotificationChannelsSend(t *testing.T) {
+	received := make(chan map[string]interface{}, 2)
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		var payload map[string]interface{}
+		require.NoError(t, json.NewDecoder(r.Body).Decode(&payload))
+		received <- payload
+	}))
+	defer server.Close()
+
+	n := Notification{
+		Filename:         "sales.csv",
+		Success:          true,
+		TotalSales:       models.WholeAmount(3500),
+		TotalDepartments: 2,
+		TopDepartments:   []DepartmentSummary{{Department: "Electronics", TotalSales: models.WholeAmount(2500)}},
+		DownloadURL:      "http://localhost/public/uploads/result.csv",
+	}
+
+	slack, err := NewWebhookChannel(ChannelSlack, server.URL)
+	require.NoError(t, err)
+	require.NoError(t, slack.Send(context.Background(), n))
+	payload := <-received
+	assert.Equal(t, "Sales file processed: sales.csv", payload["text"])
+	assert.NotEmpty(t, payload["blocks"])
+
+	teams, err := NewWebhookChannel(ChannelTeams, server.URL)
+	require.NoError(t, err)
+	n.Success = false
+	n.Error = "no valid data rows found in CSV file"
+	require.NoError(t, teams.Send(context.Background(), n))
+	payload = <-received
+	assert.Equal(t, "MessageCard", payload["@type"])
+	assert.Equal(t, "D93F0B", payload["themeColor"])
+}
+
+func TestNotificationServiceNotifyFallsBackToDefault(t *testing.T) {
+	received := make(chan string, 1)
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		received <- r.URL.Path
+	}))
+	defer server.Close()
+
+	logger := logrus.New()
+	logger.SetLevel(logrus.ErrorLevel)
+	ns := NewNotificationService(logger)
+	require.NoError(t, ns.Configure("*=slack:"+server.URL+"/default"))
+
+	ns.Notify(Notification{Tenant: "unknown", Filename: "sales.csv", Success: true})
+
+	select {
+	case path := <-received:
+		assert.Equal(t, "/default", path)
+	case <-time.After(5 * time.Second):
+		t.Fatal("notification was not delivered")
+	}
+}
+
+func TestTopDepartments(t *testing.T) {
+	summaries := []DepartmentSummary{
+		{Department: "A", TotalSales: models.WholeAmount(1)},
+		{Department: "B", TotalSales: models.WholeAmount(3)},
+		{Department: "C", TotalSales: models.WholeAmount(2)},
+	}
+	top := topDepartments(summaries, 2)
+	assert.Equal(t, []DepartmentSummary{{Department: "B", TotalSales: models.WholeAmount(3)}, {Department: "C", TotalSales: models.WholeAmount(2)}}, top)
+	assert.Equal(t, "A", summaries[0].Department)
+}
@@ -0,0 +1,205 @@
+package services
+
+import (
+	"errors"
+	"fmt"
+	"math/big"
+	"strconv"
+	"strings"
+
+	"github.com/mussietl/csv-sales-api/internal/models"
+)
+
+// Sales number parsing modes
+const (
+	// NumbersStrict accepts plain decimals, optionally with a currency
+	// symbol, comma thousands separators or accounting parentheses
+	NumbersStrict = ""
+	// NumbersLenient also accepts scientific notation, as Excel sometimes
+	// exports it (1.2E+03), and percentages
+	NumbersLenient = "lenient"
+)
+
+// Handling of rows whose sales value is blank
+const (
+	// MissingValueSkip skips the row as an invalid sales value
+	MissingValueSkip = ""
+	// MissingValueZero counts the row with sales of zero
+	MissingValueZero = "zero"
+	// MissingValueError fails the whole file
+	MissingValueError = "error"
+)
+
+// ErrMissingValue is returned for a blank sales value with MissingValueError
+var ErrMissingValue = errors.New("missing sales value")
+
+// Percent handling in lenient mode
+const (
+	// PercentReject treats values ending in % as invalid
+	PercentReject = ""
+	// PercentFraction converts 45% to 0.45
+	PercentFraction = "fraction"
+	// PercentPoints drops the sign, so 45% counts as 45
+	PercentPoints = "points"
+)
+
+// maxLenientExponent bounds the exponent of scientific notation, keeping
+// big number arithmetic cheap on hostile input
+const maxLenientExponent = 30
+
+// currencySymbols may prefix or follow a sales value
+var currencySymbols = []string{"$", "€", "£", "¥"}
+
+// ValidateMissingValue checks a missing value strategy
+func ValidateMissingValue(missing string) error {
+	switch missing {
+	case MissingValueSkip, MissingValueZero, MissingValueError:
+		return nil
+	}
+	return fmt.Errorf("invalid missing_value %q: expected skip, zero or error", missing)
+}
+
+// ValidateNumberParsing checks a numbers mode and percent handling
+func ValidateNumberParsing(numbers, percent string) error {
+	switch numbers {
+	case NumbersStrict, NumbersLenient:
+	default:
+		return fmt.Errorf("invalid numbers %q: expected strict or lenient", numbers)
+	}
+	switch percent {
+	case PercentReject, PercentFraction, PercentPoints:
+	default:
+		return fmt.Errorf("invalid percent %q: expected fraction or points", percent)
+	}
+	if percent != PercentReject && numbers != NumbersLenient {
+		return fmt.Errorf("percent requires numbers=lenient")
+	}
+	return nil
+}
+
+// parseSales parses a trimmed sales value according to the options
+func parseSales(value string, opts ProcessOptions) (models.Amount, error) {
+	plain, err := stripMoneyFormat(value)
+	if err == nil {
+		var amount models.Amount
+		if amount, err = models.ParseAmount(plain); err == nil {
+			return amount, nil
+		}
+	}
+	if opts.Numbers != NumbersLenient {
+		return models.Amount{}, err
+	}
+	return parseLenientNumber(value, opts.Percent)
+}
+
+// stripMoneyFormat turns a money value such as "$1,000.99", "-£5", "12 €"
+// or the accounting negative "(1,000.00)" into a plain decimal. Thousands
+// separators must group digits by three.
+func stripMoneyFormat(value string) (string, error) {
+	s := value
+	negative := false
+	if strings.HasPrefix(s, "(") && strings.HasSuffix(s, ")") {
+		negative, s = true, strings.TrimSpace(s[1:len(s)-1])
+	}
+	sign := func() {
+		if strings.HasPrefix(s, "-") || strings.HasPrefix(s, "+") {
+			if s[0] == '-' {
+				negative = !negative
+			}
+			s = strings.TrimSpace(s[1:])
+		}
+	}
+	sign()
+	for _, symbol := range currencySymbols {
+		if rest, ok := strings.CutPrefix(s, symbol); ok {
+			s = strings.TrimSpace(rest)
+			sign()
+			break
+		}
+		if rest, ok := strings.CutSuffix(s, symbol); ok {
+			s = strings.TrimSpace(rest)
+			break
+		}
+	}
+
+	intPart, fracPart, hasPoint := strings.Cut(s, ".")
+	if strings.Contains(intPart, ",") {
+		groups := strings.Split(intPart, ",")
+		if len(groups[0]) == 0 || len(groups[0]) > 3 {
+			return "", fmt.Errorf("invalid number %q", value)
+		}
+		for _, group := range groups[1:] {
+			if len(group) != 3 {
+				return "", fmt.Errorf("invalid thousands separators in %q", value)
+			}
+		}
+		intPart = strings.Join(groups, "")
+	}
+	if intPart+fracPart == "" || !isDigits(intPart) || !isDigits(fracPart) {
+		return "", fmt.Errorf("invalid number %q", value)
+	}
+
+	plain := intPart
+	if hasPoint {
+		plain += "." + fracPart
+	}
+	if negative {
+		plain = "-" + plain
+	}
+	return plain, nil
+}
+
+// parseLenientNumber parses scientific notation, decimals and optionally
+// percentages exactly, rounding to the places an Amount holds
+func parseLenientNumber(value, percent string) (models.Amount, error) {
+	isPercent := strings.HasSuffix(value, "%")
+	if isPercent {
+		if percent == PercentReject {
+			return models.Amount{}, fmt.Errorf("percent values are not enabled")
+		}
+		value = strings.TrimSpace(strings.TrimSuffix(value, "%"))
+	}
+
+	mantissa, exponent := value, 0
+	if i := strings.IndexAny(value, "eE"); i >= 0 {
+		exp, err := strconv.Atoi(value[i+1:])
+		if err != nil || exp > maxLenientExponent || exp < -maxLenientExponent {
+			return models.Amount{}, fmt.Errorf("invalid exponent in %q", value)
+		}
+		mantissa, exponent = value[:i], exp
+	}
+	if isPercent && percent == PercentFraction {
+		exponent -= 2
+	}
+
+	unsigned := strings.TrimLeft(mantissa, "+-")
+	intPart, fracPart, _ := strings.Cut(unsigned, ".")
+	if len(mantissa)-len(unsigned) > 1 || intPart+fracPart == "" || !isDigits(intPart) || !isDigits(fracPart) {
+		return models.Amount{}, fmt.Errorf("invalid number %q", value)
+	}
+
+	number, ok := new(big.Rat).SetString(mantissa)
+	if !ok {
+		return models.Amount{}, fmt.Errorf("invalid number %q", value)
+	}
+	scale := new(big.Rat).SetInt(new(big.Int).Exp(big.NewInt(10), big.NewInt(int64(abs(exponent))), nil))
+	if exponent >= 0 {
+		number.Mul(number, scale)
+	} else {
+		number.Quo(number, scale)
+	}
+
+	amount, err := models.ParseAmount(number.FloatString(models.AmountDecimals))
+	if err != nil {
+		return models.Amount{}, fmt.Errorf("value %q out of range", value)
+	}
+	return amount, nil
+}
+
+// abs returns the absolute value of n
+func abs(n int) int {
+	if n < 0 {
+		return -n
+	}
+	return n
+}
@@ -0,0 +1,187 @@
+package services
+
+import (
+	"encoding/json"
+	"github.com/mussietl/csv-sales-api/internal/models"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/sirupsen/logrus"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// amount parses a decimal for test expectations
+func amount(t *testing.T, value string) models.Amount {
+	t.Helper()
+	a, err := models.ParseAmount(value)
+	require.NoError(t, err)
+	return a
+}
+
+func TestParseSalesMoney(t *testing.T) {
+	for value, want := range map[string]string{
+		"42":           "42",
+		"1234.56":      "1234.56",
+		"-12.5":        "-12.5",
+		"+7":           "7",
+		".5":           "0.5",
+		"$1,000.99":    "1000.99",
+		"-$5":          "-5",
+		"$-5":          "-5",
+		"1,234,567":    "1234567",
+		"12 €":         "12",
+		"£0.10":        "0.1",
+		"(1,000.00)":   "-1000",
+		"0.123456":     "0.1235",
+		"¥ 1,000":      "1000",
+		"000123.4500":  "123.45",
+		"-0.00005":     "-0.0001",
+		"99999999.999": "99999999.999",
+	} {
+		got, err := parseSales(value, ProcessOptions{})
+		require.NoError(t, err, value)
+		assert.Equal(t, want, got.String(), value)
+	}
+
+	for _, value := range []string{"", "abc", "1,00", "1000,000", ",100", "1.2.3", "$", "--5", "1.2E+03", "45%", "$$5", "1 000", "0x10", "(5"} {
+		_, err := parseSales(value, ProcessOptions{})
+		assert.Error(t, err, value)
+	}
+}
+
+func TestParseSalesLenient(t *testing.T) {
+	lenient := ProcessOptions{Numbers: NumbersLenient}
+	for value, want := range map[string]string{
+		"42":          "42",
+		"$1,000.50":   "1000.5",
+		"1.2E+03":     "1200",
+		"1.2e3":       "1200",
+		"-2.5E+01":    "-25",
+		"12.0":        "12",
+		"1.25E+01":    "12.5",
+		"1.23457E+06": "1234570",
+		"5E0":         "5",
+		"1500E-2":     "15",
+		"1E-5":        "0",
+		"5E-5":        "0.0001",
+	} {
+		got, err := parseSales(value, lenient)
+		require.NoError(t, err, value)
+		assert.Equal(t, want, got.String(), value)
+	}
+
+	for _, value := range []string{"1E+400", "1E+30", "1E", "E5", "1e3e3", "--5", "0x10", "3/4", "", "45%"} {
+		_, err := parseSales(value, lenient)
+		assert.Error(t, err, value)
+	}
+}
+
+func TestParseSalesPercent(t *testing.T) {
+	points := ProcessOptions{Numbers: NumbersLenient, Percent: PercentPoints}
+	got, err := parseSales("45%", points)
+	require.NoError(t, err)
+	assert.Equal(t, models.WholeAmount(45), got)
+	got, err = parseSales("1.5E+02 %", points)
+	require.NoError(t, err)
+	assert.Equal(t, models.WholeAmount(150), got)
+
+	fraction := ProcessOptions{Numbers: NumbersLenient, Percent: PercentFraction}
+	got, err = parseSales("300%", fraction)
+	require.NoError(t, err)
+	assert.Equal(t, models.WholeAmount(3), got)
+	got, err = parseSales("45%", fraction)
+	require.NoError(t, err)
+	assert.Equal(t, amount(t, "0.45"), got)
+}
+
+func TestValidateNumberParsing(t *testing.T) {
+	assert.NoError(t, ValidateNumberParsing(NumbersStrict, PercentReject))
+	assert.NoError(t, ValidateNumberParsing(NumbersLenient, PercentPoints))
+	assert.Error(t, ValidateNumberParsing("loose", PercentReject))
+	assert.Error(t, ValidateNumberParsing(NumbersLenient, "basis_points"))
+	assert.Error(t, ValidateNumberParsing(NumbersStrict, PercentFraction))
+}
+
+func TestProcessLenientNumbers(t *testing.T) {
+	logger := logrus.New()
+	logger.SetLevel(logrus.ErrorLevel)
+	cs := NewCSVService(logger)
+	path := filepath.Join(t.TempDir(), "excel.csv")
+	require.NoError(t, os.WriteFile(path, []byte("Department,Sales\nBooks,1.2E+03\nBooks,5\nToys,12.5\nToys,10%\n"), 0600))
+
+	result, err := cs.Process(path, ProcessOptions{})
+	require.NoError(t, err)
+	assert.Equal(t, 2, result.Rows.Processed)
+	assert.Equal(t, 2, result.Rows.Skipped)
+
+	result, err = cs.Process(path, ProcessOptions{Numbers: NumbersLenient, Percent: PercentPoints})
+	require.NoError(t, err)
+	assert.Equal(t, 4, result.Rows.Processed)
+	assert.Equal(t, []DepartmentSummary{{Department: "Books", TotalSales: models.WholeAmount(1205), SalesCount: 2}, {Department: "Toys", TotalSales: amount(t, "22.5"), SalesCount: 2}}, result.Summaries)
+	assert.Equal(t, 0, result.Rows.SkipReasons[SkipInvalidSales])
+}
+
+func TestProcessMissingValue(t *testing.T) {
+	logger := logrus.New()
+	logger.SetLevel(logrus.PanicLevel)
+	cs := NewCSVService(logger)
+	path := filepath.Join(t.TempDir(), "blanks.csv")
+	require.NoError(t, os.WriteFile(path, []byte("Department,Sales\nBooks,5\nBooks,\nToys,  \nToys,abc\n"), 0600))
+
+	for _, parser := range []string{ParserStandard, ParserFast} {
+		result, err := cs.Process(path, ProcessOptions{Parser: parser})
+		require.NoError(t, err, parser)
+		assert.Equal(t, 1, result.Rows.Processed, parser)
+		assert.Equal(t, 3, result.Rows.SkipReasons[SkipInvalidSales], parser)
+
+		result, err = cs.Process(path, ProcessOptions{Parser: parser, MissingValue: MissingValueZero})
+		require.NoError(t, err, parser)
+		assert.Equal(t, 3, result.Rows.Processed, parser)
+		assert.Equal(t, 1, result.Rows.SkipReasons[SkipInvalidSales], parser)
+		assert.Equal(t, []DepartmentSummary{{Department: "Books", TotalSales: models.WholeAmount(5), SalesCount: 2}, {Department: "Toys", SalesCount: 1}}, result.Summaries, parser)
+
+		_, err = cs.Process(path, ProcessOptions{Parser: parser, MissingValue: MissingValueError})
+		assert.ErrorIs(t, err, ErrMissingValue, parser)
+		assert.ErrorContains(t, err, "row 3", parser)
+	}
+
+	assert.NoError(t, ValidateMissingValue(MissingValueZero))
+	assert.Error(t, ValidateMissingValue("null"))
+}
+
+func TestProcessDecimalSales(t *testing.T) {
+	logger := logrus.New()
+	logger.SetLevel(logrus.ErrorLevel)
+	cs := NewCSVService(logger)
+	fs := NewFileService(t.TempDir(), logger)
+	path := filepath.Join(t.TempDir(), "money.csv")
+	require.NoError(t, os.WriteFile(path, []byte("Department,Sales\nBooks,1234.56\nBooks,\"$1,000.99\"\nToys,0.1\nToys,0.2\nGames,15\n"), 0600))
+
+	result, err := cs.Process(path, ProcessOptions{})
+	require.NoError(t, err)
+	assert.Equal(t, 0, result.Rows.Skipped)
+	assert.Equal(t, []DepartmentSummary{
+		{Department: "Books", TotalSales: amount(t, "2235.55"), SalesCount: 2},
+		{Department: "Games", TotalSales: models.WholeAmount(15), SalesCount: 1},
+		{Department: "Toys", TotalSales: amount(t, "0.3"), SalesCount: 2},
+	}, result.Summaries)
+
+	// Totals are written exactly unless a precision is requested
+	resultPath, err := fs.SaveResultFile(result.Summaries)
+	require.NoError(t, err)
+	data, err := os.ReadFile(resultPath)
+	require.NoError(t, err)
+	assert.Equal(t, "Department Name,Total Number of Sales\nBooks,2235.55\nGames,15\nToys,0.3\n", string(data))
+
+	resultPath, err = fs.SaveFormattedResultFile(result.Summaries, NumberFormat{Precision: 1}, MetricDefault, nil, OutputFormatCSV)
+	require.NoError(t, err)
+	data, err = os.ReadFile(resultPath)
+	require.NoError(t, err)
+	assert.Equal(t, "Department Name,Total Number of Sales\nBooks,2235.6\nGames,15.0\nToys,0.3\n", string(data))
+
+	encoded, err := json.Marshal(result.Summaries[0])
+	require.NoError(t, err)
+	assert.Contains(t, string(encoded), `"total_sales":2235.55`)
+}
@@ -0,0 +1,295 @@
+package services
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"path"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/sirupsen/logrus"
+)
+
+// objectIntakeWait is how long a receive waits for notifications (SQS long polling)
+const objectIntakeWait = 20 * time.Second
+
+// objectIntakeRetry is how long the intake waits after a failed receive
+const objectIntakeRetry = 10 * time.Second
+
+// ObjectIntakeConfig configures an ObjectIntake
+type ObjectIntakeConfig struct {
+	// QueueURL is the SQS queue the bucket notifications are sent to,
+	// directly or through an SNS topic
+	QueueURL string
+	// Prefix limits intake to object keys starting with it
+	Prefix string
+	// Tenant is the tenant new objects are processed for
+	Tenant string
+	// S3 holds the credentials, region and endpoint used for the queue and
+	// the notified buckets; its Bucket and Prefix are not used
+	S3 S3Config
+}
+
+// ObjectFunc stores and processes a new object as an upload of filename.
+// It returns an error only when the object could not be stored, so the
+// notification is delivered again; processing failures are recorded like
+// those of uploads.
+type ObjectFunc func(tenant, filename string, r io.Reader) error
+
+// ObjectIntake processes objects created in S3 buckets, read from the
+// bucket notifications in an SQS queue, so files dropped into a bucket are
+// processed without an upload request. A notification is deleted from the
+// queue once all its objects were stored, and is otherwise received again
+// when its visibility timeout ends.
+type ObjectIntake struct {
+	config   ObjectIntakeConfig
+	endpoint *url.URL
+	client   *http.Client
+	process  ObjectFunc
+	logger   *logrus.Logger
+	now      func() time.Time
+
+	cancel context.CancelFunc
+	done   chan struct{}
+	once   sync.Once
+}
+
+// NewObjectIntake creates an ObjectIntake, validating the configuration
+func NewObjectIntake(config ObjectIntakeConfig, process ObjectFunc, logger *logrus.Logger) (*ObjectIntake, error) {
+	endpoint, err := url.Parse(config.QueueURL)
+	if err != nil || (endpoint.Scheme != "http" && endpoint.Scheme != "https") || endpoint.Host == "" {
+		return nil, fmt.Errorf("invalid queue URL %q", config.QueueURL)
+	}
+	if config.S3.Region == "" {
+		return nil, errors.New("s3 region is required")
+	}
+	if config.S3.AccessKeyID == "" || config.S3.SecretAccessKey == "" {
+		return nil, errors.New("s3 access key ID and secret access key are required")
+	}
+	if config.Tenant == "" {
+		config.Tenant = DefaultTenantID
+	}
+	if !ValidTenantID(config.Tenant) {
+		return nil, fmt.Errorf("invalid tenant %q", config.Tenant)
+	}
+	// Queue actions are posted to the service endpoint, which is the queue URL's host
+	endpoint.Path, endpoint.RawPath, endpoint.RawQuery = "/", "", ""
+	return &ObjectIntake{
+		config:   config,
+		endpoint: endpoint,
+		client:   &http.Client{Timeout: objectIntakeWait + 10*time.Second},
+		process:  process,
+		logger:   logger,
+		now:      time.Now,
+		done:     make(chan struct{}),
+	}, nil
+}
+
+// Start receives and processes notifications until Close
+func (oi *ObjectIntake) Start() {
+	ctx, cancel := context.WithCancel(context.Background())
+	oi.cancel = cancel
+	go func() {
+		defer close(oi.done)
+		for ctx.Err() == nil {
+			if _, err := oi.Poll(ctx); err != nil && ctx.Err() == nil {
+				oi.logger.Errorf("Failed to receive bucket notifications: %v", err)
+				select {
+				case <-ctx.Done():
+				case <-time.After(objectIntakeRetry):
+				}
+			}
+		}
+	}()
+}
+
+// Close stops the intake, waiting for objects being processed
+func (oi *ObjectIntake) Close() {
+	if oi.cancel == nil {
+		return
+	}
+	oi.once.Do(oi.cancel)
+	<-oi.done
+}
+
+// sqsMessage is a message received from the queue
+type sqsMessage struct {
+	MessageID     string `json:"MessageId"`
+	ReceiptHandle string `json:"ReceiptHandle"`
+	Body          string `json:"Body"`
+}
+
+// s3Notification is an S3 event notification
+type s3Notification struct {
+	Records []struct {
+		EventName string `json:"eventName"`
+		S3        struct {
+			Bucket struct {
+				Name string `json:"name"`
+			} `json:"bucket"`
+			Object struct {
+				Key string `json:"key"`
+			} `json:"object"`
+		} `json:"s3"`
+	} `json:"Records"`
+}
+
+// s3Object is an object a notification announces
+type s3Object struct {
+	Bucket string
+	Key    string
+}
+
+// Poll receives one batch of notifications, waiting for up to 20 seconds,
+// and processes their objects. It returns how many objects were processed.
+func (oi *ObjectIntake) Poll(ctx context.Context) (int, error) {
+	var received struct {
+		Messages []sqsMessage `json:"Messages"`
+	}
+	err := oi.call(ctx, "ReceiveMessage", map[string]interface{}{
+		"QueueUrl":            oi.config.QueueURL,
+		"MaxNumberOfMessages": 10,
+		"WaitTimeSeconds":     int(objectIntakeWait.Seconds()),
+	}, &received)
+	if err != nil {
+		return 0, err
+	}
+
+	processed := 0
+	for _, message := range received.Messages {
+		n, err := oi.handle(message)
+		processed += n
+		if err != nil {
+			oi.logger.Errorf("Failed to process bucket notification %s; it will be delivered again: %v", message.MessageID, err)
+			continue
+		}
+		if err := oi.call(ctx, "DeleteMessage", map[string]interface{}{
+			"QueueUrl":      oi.config.QueueURL,
+			"ReceiptHandle": message.ReceiptHandle,
+		}, nil); err != nil {
+			oi.logger.Errorf("Failed to delete bucket notification %s: %v", message.MessageID, err)
+		}
+	}
+	return processed, nil
+}
+
+// handle processes the objects of a notification, returning how many were
+// processed. Unreadable messages and objects deleted since are skipped, so
+// their notifications are deleted rather than received over and over.
+func (oi *ObjectIntake) handle(message sqsMessage) (int, error) {
+	objects, err := parseBucketNotification(message.Body)
+	if err != nil {
+		oi.logger.Warnf("Ignoring bucket notification %s: %v", message.MessageID, err)
+		return 0, nil
+	}
+	processed := 0
+	for _, object := range objects {
+		if !strings.HasPrefix(object.Key, oi.config.Prefix) || strings.HasSuffix(object.Key, "/") {
+			continue
+		}
+		if err := oi.processObject(object); errors.Is(err, ErrObjectNotFound) {
+			oi.logger.Warnf("Skipping s3://%s/%s: %v", object.Bucket, object.Key, err)
+			continue
+		} else if err != nil {
+			return processed, err
+		}
+		oi.logger.Infof("Processed s3://%s/%s from a bucket notification", object.Bucket, object.Key)
+		processed++
+	}
+	return processed, nil
+}
+
+// processObject downloads an object and passes it to the ObjectFunc
+func (oi *ObjectIntake) processObject(object s3Object) error {
+	config := oi.config.S3
+	config.Bucket, config.Prefix = object.Bucket, ""
+	bucket, err := NewS3Storage(config)
+	if err != nil {
+		return err
+	}
+	body, err := bucket.Open(object.Key)
+	if err != nil {
+		return err
+	}
+	defer body.Close()
+	return oi.process(oi.config.Tenant, path.Base(object.Key), body)
+}
+
+// parseBucketNotification returns the objects created according to an S3
+// event notification, which may be wrapped in an SNS notification. Test
+// events and other event types announce no objects.
+func parseBucketNotification(body string) ([]s3Object, error) {
+	var envelope struct {
+		Type    string `json:"Type"`
+		Message string `json:"Message"`
+	}
+	if err := json.Unmarshal([]byte(body), &envelope); err != nil {
+		return nil, fmt.Errorf("invalid notification: %w", err)
+	}
+	if envelope.Type == "Notification" {
+		body = envelope.Message
+	}
+
+	var notification s3Notification
+	if err := json.Unmarshal([]byte(body), &notification); err != nil {
+		return nil, fmt.Errorf("invalid S3 event notification: %w", err)
+	}
+	var objects []s3Object
+	for _, record := range notification.Records {
+		if !strings.HasPrefix(record.EventName, "ObjectCreated:") {
+			continue
+		}
+		// Keys are URL-encoded in notifications, with spaces as '+'
+		key, err := url.QueryUnescape(record.S3.Object.Key)
+		if err != nil {
+			return nil, fmt.Errorf("invalid object key %q: %w", record.S3.Object.Key, err)
+		}
+		objects = append(objects, s3Object{Bucket: record.S3.Bucket.Name, Key: key})
+	}
+	return objects, nil
+}
+
+// call runs an SQS action with the JSON protocol, decoding the response into out
+func (oi *ObjectIntake) call(ctx context.Context, action string, input map[string]interface{}, out interface{}) error {
+	body, err := json.Marshal(input)
+	if err != nil {
+		return err
+	}
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, oi.endpoint.String(), bytes.NewReader(body))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", "application/x-amz-json-1.0")
+	req.Header.Set("X-Amz-Target", "AmazonSQS."+action)
+	awsSigner{
+		AccessKeyID:     oi.config.S3.AccessKeyID,
+		SecretAccessKey: oi.config.S3.SecretAccessKey,
+		SessionToken:    oi.config.S3.SessionToken,
+		Region:          oi.config.S3.Region,
+		Service:         "sqs",
+	}.sign(req, sha256Hex(body), oi.now())
+
+	resp, err := oi.client.Do(req)
+	if err != nil {
+		return fmt.Errorf("sqs %s failed: %w", action, err)
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		message, _ := io.ReadAll(io.LimitReader(resp.Body, 512))
+		return fmt.Errorf("sqs %s failed: %s: %s", action, resp.Status, strings.TrimSpace(string(message)))
+	}
+	if out == nil {
+		return nil
+	}
+	if err := json.NewDecoder(resp.Body).Decode(out); err != nil {
+		return fmt.Errorf("invalid sqs %s response: %w", action, err)
+	}
+	return nil
+}
@@ -0,0 +1,135 @@
+package services
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"testing"
+
+	"github.com/sirupsen/logrus"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// fakeSQS is a queue served next to a fakeS3 bucket
+type fakeSQS struct {
+	*fakeS3
+	mu       sync.Mutex
+	messages []sqsMessage
+	deleted  []string
+}
+
+func (f *fakeSQS) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	target := r.Header.Get("X-Amz-Target")
+	if target == "" {
+		f.fakeS3.ServeHTTP(w, r)
+		return
+	}
+	if !strings.Contains(r.Header.Get("Authorization"), "/eu-west-1/sqs/aws4_request") || r.URL.Path != "/" {
+		w.WriteHeader(http.StatusForbidden)
+		return
+	}
+	var input map[string]interface{}
+	if err := json.NewDecoder(r.Body).Decode(&input); err != nil || input["QueueUrl"] == nil {
+		w.WriteHeader(http.StatusBadRequest)
+		return
+	}
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	switch target {
+	case "AmazonSQS.ReceiveMessage":
+		json.NewEncoder(w).Encode(map[string]interface{}{"Messages": f.messages})
+		f.messages = nil
+	case "AmazonSQS.DeleteMessage":
+		f.deleted = append(f.deleted, input["ReceiptHandle"].(string))
+		w.Write([]byte("{}"))
+	default:
+		w.WriteHeader(http.StatusBadRequest)
+	}
+}
+
+// s3Event returns an S3 event notification for an object created in bucket
+func s3Event(bucket, key string) string {
+	return `{"Records":[{"eventName":"ObjectCreated:Put","s3":{"bucket":{"name":"` + bucket + `"},"object":{"key":"` + key + `"}}}]}`
+}
+
+func TestObjectIntakePoll(t *testing.T) {
+	queue := &fakeSQS{fakeS3: &fakeS3{objects: map[string][]byte{
+		"/drop/incoming/march sales.csv": []byte("Department,Date,Sales\nA,2024-03-01,5\n"),
+		"/drop/incoming/april.csv":       []byte("Department,Date,Sales\nB,2024-04-01,7\n"),
+		"/drop/incoming/may.csv":         []byte("Department,Date,Sales\nC,2024-05-01,9\n"),
+		"/drop/other/june.csv":           []byte("Department,Date,Sales\nD,2024-06-01,1\n"),
+	}}}
+	server := httptest.NewServer(queue)
+	defer server.Close()
+
+	snsMessage, err := json.Marshal(map[string]string{"Type": "Notification", "Message": s3Event("drop", "incoming/april.csv")})
+	require.NoError(t, err)
+	queue.messages = []sqsMessage{
+		{MessageID: "1", ReceiptHandle: "direct", Body: s3Event("drop", "incoming/march+sales.csv")},
+		{MessageID: "2", ReceiptHandle: "sns", Body: string(snsMessage)},
+		{MessageID: "3", ReceiptHandle: "outside", Body: s3Event("drop", "other/june.csv")},
+		{MessageID: "4", ReceiptHandle: "test", Body: `{"Service":"Amazon S3","Event":"s3:TestEvent","Bucket":"drop"}`},
+		{MessageID: "5", ReceiptHandle: "missing", Body: s3Event("drop", "incoming/gone.csv")},
+		{MessageID: "6", ReceiptHandle: "failing", Body: s3Event("drop", "incoming/may.csv")},
+	}
+
+	processed := make(map[string]string)
+	intake, err := NewObjectIntake(ObjectIntakeConfig{
+		QueueURL: server.URL + "/123456789012/drop",
+		Prefix:   "incoming/",
+		S3: S3Config{
+			Region:          "eu-west-1",
+			Endpoint:        server.URL,
+			PathStyle:       true,
+			AccessKeyID:     "AKID",
+			SecretAccessKey: "secret",
+		},
+	}, func(tenant, filename string, r io.Reader) error {
+		assert.Equal(t, DefaultTenantID, tenant)
+		if filename == "may.csv" {
+			return errors.New("disk full")
+		}
+		data, err := io.ReadAll(r)
+		require.NoError(t, err)
+		processed[filename] = string(data)
+		return nil
+	}, logrus.New())
+	require.NoError(t, err)
+
+	n, err := intake.Poll(context.Background())
+	require.NoError(t, err)
+	assert.Equal(t, 2, n)
+	assert.Equal(t, map[string]string{
+		"march sales.csv": "Department,Date,Sales\nA,2024-03-01,5\n",
+		"april.csv":       "Department,Date,Sales\nB,2024-04-01,7\n",
+	}, processed)
+	// The notification of an object that could not be stored is left for redelivery
+	assert.Equal(t, []string{"direct", "sns", "outside", "test", "missing"}, queue.deleted)
+}
+
+func TestNewObjectIntakeValidates(t *testing.T) {
+	valid := ObjectIntakeConfig{
+		QueueURL: "https://sqs.eu-west-1.amazonaws.com/123456789012/drop",
+		S3:       S3Config{Region: "eu-west-1", AccessKeyID: "AKID", SecretAccessKey: "secret"},
+	}
+	_, err := NewObjectIntake(valid, nil, logrus.New())
+	assert.NoError(t, err)
+
+	for name, change := range map[string]func(*ObjectIntakeConfig){
+		"queue URL":   func(c *ObjectIntakeConfig) { c.QueueURL = "drop" },
+		"region":      func(c *ObjectIntakeConfig) { c.S3.Region = "" },
+		"credentials": func(c *ObjectIntakeConfig) { c.S3.SecretAccessKey = "" },
+		"tenant":      func(c *ObjectIntakeConfig) { c.Tenant = "../acme" },
+	} {
+		config := valid
+		change(&config)
+		_, err := NewObjectIntake(config, nil, logrus.New())
+		assert.Error(t, err, name)
+	}
+}
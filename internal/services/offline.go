@@ -0,0 +1,21 @@
+package services
+
+import (
+	"errors"
+	"fmt"
+	"net/http"
+)
+
+// ErrOffline is returned for outbound requests made while the server runs offline
+var ErrOffline = errors.New("outbound network access is disabled in offline mode")
+
+// OfflineTransport is an http.RoundTripper that refuses every request. It
+// replaces the default transport in offline mode, so an integration that
+// slips past the configuration checks fails at once instead of waiting for
+// a connection that an air-gapped network will never make.
+type OfflineTransport struct{}
+
+// RoundTrip fails with ErrOffline
+func (OfflineTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	return nil, fmt.Errorf("%w: %s %s", ErrOffline, req.Method, req.URL.Redacted())
+}
@@ -0,0 +1,19 @@
+package services
+
+import (
+	"context"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestOfflineTransport(t *testing.T) {
+	channel, err := NewWebhookChannel(ChannelSlack, "https://hooks.example.com/services/T000")
+	require.NoError(t, err)
+	channel.(*SlackChannel).client.Transport = OfflineTransport{}
+
+	err = channel.Send(context.Background(), Notification{Filename: "sales.csv", Success: true})
+	assert.ErrorIs(t, err, ErrOffline)
+	assert.ErrorContains(t, err, "hooks.example.com")
+}
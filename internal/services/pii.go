@@ -0,0 +1,238 @@
+package services
+
+import (
+	"encoding/csv"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"regexp"
+	"sort"
+	"strings"
+
+	"github.com/mussietl/csv-sales-api/internal/models"
+)
+
+// PII handling modes, applied to every column other than the department and
+// sales columns
+const (
+	// PIIModeOff doesn't scan for personal data
+	PIIModeOff = "off"
+	// PIIModeWarn reports the columns holding personal data in the response
+	PIIModeWarn = "warn"
+	// PIIModeRedact also replaces the values in the stored upload
+	PIIModeRedact = "redact"
+	// PIIModeReject fails processing at the first value found
+	PIIModeReject = "reject"
+)
+
+// Kinds of personal data detected
+const (
+	PIIEmail      = "email"
+	PIIPhone      = "phone"
+	PIICardNumber = "card_number"
+)
+
+// PIIRedacted replaces redacted values
+const PIIRedacted = "[REDACTED]"
+
+// ErrPIIDetected is returned for a file holding personal data with PIIModeReject
+var ErrPIIDetected = errors.New("personal data found")
+
+// ErrCannotRedact is returned for stored uploads that can't be rewritten,
+// such as workbooks and compressed files
+var ErrCannotRedact = errors.New("file format cannot be redacted")
+
+// ValidatePIIMode checks that mode is a known PII handling mode
+func ValidatePIIMode(mode string) error {
+	switch mode {
+	case PIIModeOff, PIIModeWarn, PIIModeRedact, PIIModeReject:
+		return nil
+	}
+	return fmt.Errorf("invalid PII mode %q: expected off, warn, redact or reject", mode)
+}
+
+// PIIError reports where personal data was found; the value itself is left
+// out so it doesn't end up in logs and responses
+type PIIError struct {
+	Kind   string
+	Column string
+	Index  int
+	Row    int
+	Where  string
+}
+
+func (e *PIIError) Error() string {
+	return fmt.Sprintf("%s: %s in column %q at %srow %d", ErrPIIDetected, e.Kind, e.Column, e.Where, e.Row)
+}
+
+func (e *PIIError) Unwrap() error { return ErrPIIDetected }
+
+var emailPattern = regexp.MustCompile(`[A-Za-z0-9._%+-]+@[A-Za-z0-9-]+(\.[A-Za-z0-9-]+)*\.[A-Za-z]{2,}`)
+
+// DetectPII returns the kind of personal data value holds, or "". Phone
+// numbers need a leading + or a separator, so plain IDs and Unix timestamps
+// aren't mistaken for them; card numbers must pass the Luhn check.
+func DetectPII(value string) string {
+	if strings.IndexByte(value, '@') >= 0 && emailPattern.MatchString(value) {
+		return PIIEmail
+	}
+	value = strings.TrimSpace(value)
+	digits, separators, other := 0, 0, false
+	for i := 0; i < len(value); i++ {
+		switch c := value[i]; {
+		case c >= '0' && c <= '9':
+			digits++
+		case c == ' ' || c == '-':
+			separators++
+		case c == '(' || c == ')' || (c == '+' && i == 0):
+			separators++
+			other = true
+		default:
+			return ""
+		}
+	}
+	if digits >= 13 && digits <= 19 && !other && luhnValid(value) {
+		return PIICardNumber
+	}
+	if digits >= 10 && digits <= 15 && separators > 0 {
+		return PIIPhone
+	}
+	return ""
+}
+
+// luhnValid reports whether the digits of value pass the Luhn checksum
+func luhnValid(value string) bool {
+	sum, double := 0, false
+	for i := len(value) - 1; i >= 0; i-- {
+		c := value[i]
+		if c < '0' || c > '9' {
+			continue
+		}
+		d := int(c - '0')
+		if double {
+			if d *= 2; d > 9 {
+				d -= 9
+			}
+		}
+		sum += d
+		double = !double
+	}
+	return sum%10 == 0
+}
+
+// piiFindings counts the personal data found per column index and kind
+type piiFindings map[int]map[string]int
+
+// add counts n values of kind in column
+func (f piiFindings) add(column int, kind string, n int) {
+	if f[column] == nil {
+		f[column] = make(map[string]int)
+	}
+	f[column][kind] += n
+}
+
+// merge adds the findings of an aggregator that consumed a later part of the file
+func (f piiFindings) merge(other piiFindings) {
+	for column, kinds := range other {
+		for kind, n := range kinds {
+			f.add(column, kind, n)
+		}
+	}
+}
+
+// report lists the columns with findings in header order, or returns nil
+func (f piiFindings) report(mode string, header []string) *models.PIIReport {
+	if len(f) == 0 {
+		return nil
+	}
+	report := &models.PIIReport{Mode: mode}
+	for column, kinds := range f {
+		report.Columns = append(report.Columns, models.PIIColumn{Index: column, Column: header[column], Kinds: kinds})
+	}
+	sort.Slice(report.Columns, func(i, j int) bool { return report.Columns[i].Index < report.Columns[j].Index })
+	return report
+}
+
+// scanPII checks the scanned columns of an accepted row for personal data
+func (a *rowAggregator) scanPII(rowNumber int, where string, fieldValue func(index int) string) error {
+	for _, index := range a.layout.pii {
+		kind := DetectPII(fieldValue(index))
+		if kind == "" {
+			continue
+		}
+		if a.opts.PIIMode == PIIModeReject {
+			return &PIIError{Kind: kind, Index: index, Row: rowNumber, Where: where}
+		}
+		a.pii.add(index, kind, 1)
+	}
+	return nil
+}
+
+// RedactUpload rewrites a stored CSV upload, whose fields are separated by
+// delimiter, with every value of the given columns that holds personal data
+// replaced by PIIRedacted, then stores the new copy. The header row is kept
+// as is.
+func (fs *FileService) RedactUpload(filePath, delimiter string, columns []int) error {
+	if IsXLSX(filePath) {
+		return ErrCannotRedact
+	}
+	if compression, err := DetectCompression(filePath); err != nil {
+		return fmt.Errorf("failed to open upload: %w", err)
+	} else if compression != CompressionNone {
+		return ErrCannotRedact
+	}
+
+	src, err := os.Open(filePath)
+	if err != nil {
+		return fmt.Errorf("failed to open upload: %w", err)
+	}
+	defer src.Close()
+	dst, err := os.CreateTemp(filepath.Dir(filePath), ".redact-*")
+	if err != nil {
+		return fmt.Errorf("failed to create redacted upload: %w", err)
+	}
+	defer os.Remove(dst.Name())
+	defer dst.Close()
+	if err := dst.Chmod(0644); err != nil {
+		return fmt.Errorf("failed to create redacted upload: %w", err)
+	}
+
+	reader := csv.NewReader(src)
+	reader.Comma = rune(delimiter[0])
+	reader.FieldsPerRecord = -1
+	reader.LazyQuotes = true
+	writer := csv.NewWriter(dst)
+	writer.Comma = reader.Comma
+	for row := 0; ; row++ {
+		record, err := reader.Read()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return fmt.Errorf("failed to read upload: %w", err)
+		}
+		if row > 0 {
+			for _, index := range columns {
+				if index < len(record) && DetectPII(record[index]) != "" {
+					record[index] = PIIRedacted
+				}
+			}
+		}
+		if err := writer.Write(record); err != nil {
+			return fmt.Errorf("failed to write redacted upload: %w", err)
+		}
+	}
+	writer.Flush()
+	if err := writer.Error(); err != nil {
+		return fmt.Errorf("failed to write redacted upload: %w", err)
+	}
+	if err := dst.Close(); err != nil {
+		return fmt.Errorf("failed to write redacted upload: %w", err)
+	}
+	if err := os.Rename(dst.Name(), filePath); err != nil {
+		return fmt.Errorf("failed to replace upload: %w", err)
+	}
+	return fs.Persist(filePath)
+}
@@ -0,0 +1,88 @@
+package services
+
+import (
+	"errors"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/mussietl/csv-sales-api/internal/models"
+	"github.com/sirupsen/logrus"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+const piiCSV = "Department Name,Customer,Number of Sales,Note\n" +
+	"Books,jane.doe@example.com,10,1700000000\n" +
+	"Toys,+1 415 555 0123,5,\n" +
+	"Books,Walk-in,7,card 4111 1111 1111 1111\n" +
+	"Toys,4111-1111-1111-1111,3,order 2024-01-15\n"
+
+func TestDetectPII(t *testing.T) {
+	assert.Equal(t, PIIEmail, DetectPII("Contact: jane.doe@example.co.uk"))
+	assert.Equal(t, PIIPhone, DetectPII("(415) 555-0123"))
+	assert.Equal(t, PIIPhone, DetectPII("+14155550123"))
+	assert.Equal(t, PIICardNumber, DetectPII("4111 1111 1111 1111"))
+	assert.Equal(t, PIICardNumber, DetectPII("5500000000000004"))
+
+	for _, value := range []string{"", "Books", "1700000000", "4111111111111112", "2024-01-15", "1,234,567,890.00", "@handle"} {
+		assert.Empty(t, DetectPII(value), value)
+	}
+
+	assert.NoError(t, ValidatePIIMode(PIIModeRedact))
+	assert.Error(t, ValidatePIIMode("mask"))
+}
+
+func TestProcessPIIModes(t *testing.T) {
+	logger := logrus.New()
+	logger.SetLevel(logrus.PanicLevel)
+	cs := NewCSVService(logger)
+
+	result, err := cs.ProcessStream(strings.NewReader(piiCSV), ProcessOptions{})
+	require.NoError(t, err)
+	assert.Nil(t, result.PII)
+
+	result, err = cs.ProcessStream(strings.NewReader(piiCSV), ProcessOptions{PIIMode: PIIModeWarn})
+	require.NoError(t, err)
+	assert.Equal(t, &models.PIIReport{Mode: PIIModeWarn, Columns: []models.PIIColumn{
+		{Index: 1, Column: "Customer", Kinds: map[string]int{PIIEmail: 1, PIIPhone: 1, PIICardNumber: 1}},
+	}}, result.PII, "free text is only flagged when the whole value is a number")
+	assert.Equal(t, 4, result.Rows.Processed)
+
+	_, err = cs.ProcessStream(strings.NewReader(piiCSV), ProcessOptions{PIIMode: PIIModeReject})
+	require.ErrorIs(t, err, ErrPIIDetected)
+	var piiErr *PIIError
+	require.True(t, errors.As(err, &piiErr))
+	assert.Equal(t, "personal data found: email in column \"Customer\" at row 2", err.Error())
+	assert.NotContains(t, err.Error(), "jane")
+}
+
+func TestFileServiceRedactUpload(t *testing.T) {
+	logger := logrus.New()
+	logger.SetLevel(logrus.PanicLevel)
+	dir := t.TempDir()
+	fs := NewFileService(dir, logger)
+
+	path := filepath.Join(dir, "upload_1.csv")
+	require.NoError(t, os.WriteFile(path, []byte(piiCSV), 0644))
+	require.NoError(t, fs.RedactUpload(path, ",", []int{1, 3}))
+	data, err := os.ReadFile(path)
+	require.NoError(t, err)
+	assert.Equal(t, "Department Name,Customer,Number of Sales,Note\n"+
+		"Books,[REDACTED],10,1700000000\n"+
+		"Toys,[REDACTED],5,\n"+
+		"Books,Walk-in,7,card 4111 1111 1111 1111\n"+
+		"Toys,[REDACTED],3,order 2024-01-15\n", string(data))
+
+	// The redacted copy still processes the same
+	cs := NewCSVService(logger)
+	result, err := cs.Process(path, ProcessOptions{PIIMode: PIIModeWarn})
+	require.NoError(t, err)
+	assert.Nil(t, result.PII)
+	assert.Equal(t, 4, result.Rows.Processed)
+
+	workbook := filepath.Join(dir, "upload_2.xlsx")
+	require.NoError(t, os.WriteFile(workbook, []byte("PK"), 0644))
+	assert.ErrorIs(t, fs.RedactUpload(workbook, ",", []int{1}), ErrCannotRedact)
+}
@@ -0,0 +1,139 @@
+package services
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/sirupsen/logrus"
+)
+
+// ErrResultPinned is returned when deleting a pinned result
+var ErrResultPinned = errors.New("result is pinned")
+
+// ErrPinNotFound is returned when unpinning a result that isn't pinned
+var ErrPinNotFound = errors.New("result is not pinned")
+
+// maxPinReasonLength is the longest reason accepted for a pin
+const maxPinReasonLength = 500
+
+// Pin exempts a result from retention cleanup
+type Pin struct {
+	ResultID string    `json:"result_id"`
+	Tenant   string    `json:"tenant"`
+	PinnedBy string    `json:"pinned_by,omitempty"`
+	Reason   string    `json:"reason,omitempty"`
+	PinnedAt time.Time `json:"pinned_at"`
+}
+
+// PinStore holds the pinned results, persisted to a JSON file
+type PinStore struct {
+	path   string
+	mu     sync.Mutex
+	pins   map[string]Pin
+	logger *logrus.Logger
+}
+
+// NewPinStore creates a PinStore, loading pins from path
+func NewPinStore(path string, logger *logrus.Logger) (*PinStore, error) {
+	ps := &PinStore{
+		path:   path,
+		pins:   make(map[string]Pin),
+		logger: logger,
+	}
+
+	data, err := os.ReadFile(path)
+	if err != nil && !errors.Is(err, os.ErrNotExist) {
+		return nil, fmt.Errorf("failed to read pins: %w", err)
+	}
+	if err == nil {
+		if err := json.Unmarshal(data, &ps.pins); err != nil {
+			return nil, fmt.Errorf("failed to decode pins: %w", err)
+		}
+	}
+	return ps, nil
+}
+
+// Pin exempts a result from retention cleanup. Pinning a pinned result
+// replaces its reason and author.
+func (ps *PinStore) Pin(resultID, tenant, pinnedBy, reason string) (Pin, error) {
+	reason = strings.TrimSpace(reason)
+	if len(reason) > maxPinReasonLength {
+		return Pin{}, fmt.Errorf("reason exceeds %d bytes", maxPinReasonLength)
+	}
+
+	ps.mu.Lock()
+	defer ps.mu.Unlock()
+	pin := Pin{ResultID: resultID, Tenant: tenant, PinnedBy: pinnedBy, Reason: reason, PinnedAt: time.Now().UTC()}
+	previous, existed := ps.pins[resultID]
+	ps.pins[resultID] = pin
+	if err := ps.save(); err != nil {
+		if existed {
+			ps.pins[resultID] = previous
+		} else {
+			delete(ps.pins, resultID)
+		}
+		return Pin{}, err
+	}
+	ps.logger.Infof("Pinned result %s", resultID)
+	return pin, nil
+}
+
+// Unpin makes a result subject to retention cleanup again
+func (ps *PinStore) Unpin(resultID string) error {
+	ps.mu.Lock()
+	defer ps.mu.Unlock()
+	pin, ok := ps.pins[resultID]
+	if !ok {
+		return fmt.Errorf("%w: %s", ErrPinNotFound, resultID)
+	}
+	delete(ps.pins, resultID)
+	if err := ps.save(); err != nil {
+		ps.pins[resultID] = pin
+		return err
+	}
+	ps.logger.Infof("Unpinned result %s", resultID)
+	return nil
+}
+
+// Get returns a result's pin
+func (ps *PinStore) Get(resultID string) (Pin, bool) {
+	ps.mu.Lock()
+	defer ps.mu.Unlock()
+	pin, ok := ps.pins[resultID]
+	return pin, ok
+}
+
+// IsPinned reports whether a result is pinned
+func (ps *PinStore) IsPinned(resultID string) bool {
+	_, ok := ps.Get(resultID)
+	return ok
+}
+
+// List returns the pins, newest first; a non-empty tenant keeps only its pins
+func (ps *PinStore) List(tenant string) []Pin {
+	ps.mu.Lock()
+	defer ps.mu.Unlock()
+	pins := make([]Pin, 0, len(ps.pins))
+	for _, pin := range ps.pins {
+		if tenant == "" || pin.Tenant == tenant {
+			pins = append(pins, pin)
+		}
+	}
+	sort.Slice(pins, func(i, j int) bool { return pins[i].PinnedAt.After(pins[j].PinnedAt) })
+	return pins
+}
+
+// save writes the pins to disk; callers hold the lock
+func (ps *PinStore) save() error {
+	data, err := json.MarshalIndent(ps.pins, "", "  ")
+	if err != nil {
+		return fmt.Errorf("failed to encode pins: %w", err)
+	}
+	return writeFileAtomic(ps.path, data, 0600)
+}
@@ -0,0 +1,42 @@
+package services
+
+import (
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/sirupsen/logrus"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestPinStore(t *testing.T) {
+	logger := logrus.New()
+	logger.SetLevel(logrus.ErrorLevel)
+	path := filepath.Join(t.TempDir(), "pins.json")
+	ps, err := NewPinStore(path, logger)
+	require.NoError(t, err)
+	yearEnd, june := "0b8f3f7e-4a59-4d43-9a5b-3c4f1e2d9a10", "1c9f4f8e-5b6a-4e54-8b6c-4d5f2e3d0b21"
+
+	assert.False(t, ps.IsPinned(yearEnd))
+	pin, err := ps.Pin(yearEnd, "acme", "finance", "  year-end report ")
+	require.NoError(t, err)
+	assert.Equal(t, "year-end report", pin.Reason)
+	_, err = ps.Pin(june, "globex", "", "")
+	require.NoError(t, err)
+	_, err = ps.Pin(june, "globex", "", strings.Repeat("x", maxPinReasonLength+1))
+	assert.Error(t, err)
+
+	// Pins survive a restart
+	ps, err = NewPinStore(path, logger)
+	require.NoError(t, err)
+	assert.True(t, ps.IsPinned(yearEnd))
+	assert.Len(t, ps.List(""), 2)
+	acme := ps.List("acme")
+	require.Len(t, acme, 1)
+	assert.Equal(t, "finance", acme[0].PinnedBy)
+
+	require.NoError(t, ps.Unpin(yearEnd))
+	assert.False(t, ps.IsPinned(yearEnd))
+	assert.ErrorIs(t, ps.Unpin(yearEnd), ErrPinNotFound)
+}
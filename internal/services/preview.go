@@ -0,0 +1,95 @@
+package services
+
+import (
+	"bufio"
+	"encoding/csv"
+	"fmt"
+	"io"
+	"strings"
+
+	"github.com/mussietl/csv-sales-api/internal/models"
+)
+
+// DefaultPreviewRows is the number of data rows a preview returns by default
+const DefaultPreviewRows = 20
+
+// MaxPreviewRows bounds the data rows a preview may ask for
+const MaxPreviewRows = 100
+
+// Preview reads the header and up to rows data rows of CSV data, reporting
+// the detected delimiter and columns and what processing would take from
+// each row. The rest of r is left unread.
+func (cs *CSVService) Preview(r io.Reader, opts ProcessOptions, rows int) (*models.Preview, error) {
+	buffered, encoding, err := decodeCharset(bufio.NewReaderSize(r, 64*1024))
+	if err != nil {
+		return nil, fmt.Errorf("failed to read CSV data: %w", err)
+	}
+	opts.encoding = encoding
+	buffered = newLineEndingReader(buffered)
+	header, err := cs.readHeader(buffered, opts)
+	if err != nil {
+		return nil, err
+	}
+
+	reader := csv.NewReader(buffered)
+	reader.Comma = rune(header.comma)
+	reader.FieldsPerRecord = -1
+	preview := &models.Preview{Success: true, Schema: header.schema(opts), Rows: []models.PreviewRow{}}
+	rowNumber := 0
+	for {
+		record, err := reader.Read()
+		if err == io.EOF {
+			return preview, nil
+		}
+		if err != nil {
+			return nil, fmt.Errorf("failed to read CSV record at row %d: %w", rowNumber+1, err)
+		}
+		// Blank rows keep their row numbers but aren't shown
+		rowNumber++
+		if blankRecord(record) {
+			continue
+		}
+		if len(preview.Rows) == rows {
+			preview.More = true
+			return preview, nil
+		}
+		preview.Rows = append(preview.Rows, header.previewRow(record, rowNumber, opts))
+	}
+}
+
+// previewRow reads a data row the way rowAggregator.record does
+func (h *csvHeader) previewRow(record []string, rowNumber int, opts ProcessOptions) models.PreviewRow {
+	row := models.PreviewRow{Row: rowNumber, Values: record}
+	if len(record) <= h.department || len(record) <= h.sales {
+		row.SkipReason, row.Detail = SkipInsufficientColumns, fmt.Sprintf("row has %d columns", len(record))
+		return row
+	}
+	if row.Department = h.valueNorm.Apply(record[h.department]); row.Department == "" {
+		row.SkipReason, row.Detail = SkipEmptyDepartment, "empty department"
+		return row
+	}
+
+	var sales models.Amount
+	if salesStr := strings.TrimSpace(record[h.sales]); salesStr == "" {
+		switch opts.MissingValue {
+		case MissingValueZero:
+		case MissingValueError:
+			row.SkipReason, row.Detail = SkipInvalidSales, "missing sales value, which fails processing with missing_value=error"
+			return row
+		default:
+			row.SkipReason, row.Detail = SkipInvalidSales, "missing sales value"
+			return row
+		}
+	} else {
+		var err error
+		if sales, err = parseSales(salesStr, opts); err != nil {
+			row.SkipReason, row.Detail = SkipInvalidSales, err.Error()
+			return row
+		}
+	}
+	row.Sales = &sales
+	if !opts.KeepTotalRows && isTotalRow(row.Department) {
+		row.Detail = "footer total if no data rows follow it, reconciled against the department totals"
+	}
+	return row
+}
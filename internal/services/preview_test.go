@@ -0,0 +1,63 @@
+package services
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/sirupsen/logrus"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestPreview(t *testing.T) {
+	logger := logrus.New()
+	logger.SetLevel(logrus.ErrorLevel)
+	cs := NewCSVService(logger)
+
+	data := "Region;Dept;Amount\n" +
+		"N;Books;1.50\n" +
+		";;\n" +
+		"S;;3\n" +
+		"N;Toys;abc\n" +
+		"S;Toys\n" +
+		"N;Garden;\n" +
+		"S;TOTAL;4\n" +
+		"N;Garden;2\n"
+	preview, err := cs.Preview(strings.NewReader(data), ProcessOptions{}, 6)
+	require.NoError(t, err)
+
+	assert.Equal(t, ";", preview.Schema.Delimiter)
+	assert.Equal(t, "Dept", preview.Schema.DepartmentColumn)
+	assert.Equal(t, "Amount", preview.Schema.SalesColumn)
+	assert.True(t, preview.More)
+	require.Len(t, preview.Rows, 6)
+
+	assert.Equal(t, 1, preview.Rows[0].Row)
+	assert.Equal(t, []string{"N", "Books", "1.50"}, preview.Rows[0].Values)
+	assert.Equal(t, "Books", preview.Rows[0].Department)
+	assert.Equal(t, amount(t, "1.5"), *preview.Rows[0].Sales)
+	assert.Empty(t, preview.Rows[0].SkipReason)
+
+	// The blank row keeps its number
+	assert.Equal(t, 3, preview.Rows[1].Row)
+	assert.Equal(t, SkipEmptyDepartment, preview.Rows[1].SkipReason)
+	assert.Equal(t, SkipInvalidSales, preview.Rows[2].SkipReason)
+	assert.Nil(t, preview.Rows[2].Sales)
+	assert.Equal(t, SkipInsufficientColumns, preview.Rows[3].SkipReason)
+	assert.Equal(t, "missing sales value", preview.Rows[4].Detail)
+	assert.Equal(t, "TOTAL", preview.Rows[5].Department)
+	assert.Empty(t, preview.Rows[5].SkipReason)
+
+	// Options apply as they would to processing
+	preview, err = cs.Preview(strings.NewReader(data), ProcessOptions{MissingValue: MissingValueZero, SalesColumn: "Region"}, 20)
+	require.NoError(t, err)
+	assert.False(t, preview.More)
+	assert.Len(t, preview.Rows, 7)
+	assert.Equal(t, "Region", preview.Schema.SalesColumn)
+	assert.Equal(t, SkipInvalidSales, preview.Rows[0].SkipReason)
+
+	_, err = cs.Preview(strings.NewReader("a,b\n1,2\n"), ProcessOptions{}, 20)
+	var matchErr *ColumnMatchError
+	require.ErrorAs(t, err, &matchErr)
+	assert.Len(t, matchErr.Columns, 2)
+}
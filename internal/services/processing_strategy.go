@@ -0,0 +1,251 @@
+package services
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+	"os"
+	"runtime"
+	"sync"
+)
+
+// Processing strategies, chosen by file size unless ProcessOptions.Strategy is set
+const (
+	StrategyAuto      = ""
+	StrategyInMemory  = "in_memory"
+	StrategyStreaming = "streaming"
+	StrategyParallel  = "parallel"
+)
+
+// StrategyThresholds decide which strategy processes a file of a given size
+type StrategyThresholds struct {
+	// InMemoryMaxBytes is the largest file read whole into memory
+	InMemoryMaxBytes int64
+	// ParallelMinBytes is the smallest file split into chunks aggregated in parallel; 0 disables it
+	ParallelMinBytes int64
+	// Workers is the number of chunks a file is split into for parallel processing
+	Workers int
+}
+
+// DefaultStrategyThresholds returns the thresholds used by NewCSVService
+func DefaultStrategyThresholds() StrategyThresholds {
+	return StrategyThresholds{
+		InMemoryMaxBytes: 8 << 20,
+		ParallelMinBytes: 256 << 20,
+		Workers:          runtime.NumCPU(),
+	}
+}
+
+// SetStrategyThresholds replaces the file-size thresholds used to pick a strategy
+func (cs *CSVService) SetStrategyThresholds(thresholds StrategyThresholds) {
+	if thresholds.Workers < 1 {
+		thresholds.Workers = 1
+	}
+	cs.thresholds = thresholds
+}
+
+// ValidateStrategy checks a requested processing strategy
+func ValidateStrategy(strategy string) error {
+	switch strategy {
+	case StrategyAuto, StrategyInMemory, StrategyStreaming, StrategyParallel:
+		return nil
+	}
+	return fmt.Errorf("invalid strategy %q: expected in_memory, streaming or parallel", strategy)
+}
+
+// LargeFile reports whether a file of size bytes is large enough to be split
+// for parallel processing, so uploads of it are queued as async jobs unless
+// the client asks otherwise
+func (cs *CSVService) LargeFile(size int64) bool {
+	return cs.thresholds.ParallelMinBytes > 0 && size >= cs.thresholds.ParallelMinBytes
+}
+
+// StrategyFor returns the strategy a file of size bytes is processed with
+func (cs *CSVService) StrategyFor(size int64, opts ProcessOptions) string {
+	return cs.selectStrategy(size, opts)
+}
+
+// selectStrategy picks the strategy for a file of size bytes. Parallel
+// processing splits the file, so it is only used when nothing depends on
+// reading rows in a single pass: distinct counts, cleaned output and schema
+// validation, which reports violations in row order.
+func (cs *CSVService) selectStrategy(size int64, opts ProcessOptions) string {
+	strategy := opts.Strategy
+	if strategy == StrategyAuto {
+		switch {
+		case size <= cs.thresholds.InMemoryMaxBytes:
+			strategy = StrategyInMemory
+		case cs.LargeFile(size):
+			strategy = StrategyParallel
+		default:
+			strategy = StrategyStreaming
+		}
+	}
+	if strategy == StrategyParallel && (len(opts.DistinctColumns) > 0 || opts.CleanedOutput != nil || opts.Schema != nil || cs.thresholds.Workers < 2) {
+		strategy = StrategyStreaming
+	}
+	return strategy
+}
+
+// aggregateParallel splits the data rows between dataStart and size into
+// chunks at record boundaries and aggregates them concurrently. Chunks are
+// merged in file order, so first-seen ordering and footer totals match a
+// sequential pass.
+func (cs *CSVService) aggregateParallel(filePath string, dataStart, size int64, layout columnLayout, valueNorm Normalization, opts ProcessOptions) (*rowAggregator, error) {
+	file, err := os.Open(filePath)
+	if err != nil {
+		return nil, fmt.Errorf("failed to open file: %w", err)
+	}
+	defer file.Close()
+
+	bounds, err := chunkBoundaries(file, dataStart, size, cs.thresholds.Workers)
+	if err != nil {
+		return nil, fmt.Errorf("failed to split file into chunks: %w", err)
+	}
+
+	chunks := len(bounds) - 1
+	aggregators := make([]*rowAggregator, chunks)
+	errs := make([]error, chunks)
+	var wg sync.WaitGroup
+	for i := 0; i < chunks; i++ {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			aggregators[i] = newRowAggregator(layout, valueNorm, opts, cs.log(opts))
+			section := io.NewSectionReader(file, bounds[i], bounds[i+1]-bounds[i])
+			errs[i] = aggregators[i].consume(newLineEndingReader(opts.progress.reader(section)), 0, fmt.Sprintf("chunk %d ", i+1))
+		}(i)
+	}
+	wg.Wait()
+
+	for _, err := range errs {
+		if err != nil {
+			return nil, err
+		}
+	}
+	// Blank rows ending a chunk are counted unless no later chunk has data,
+	// and its TOTAL rows are the footer only if no later chunk has data rows
+	trailing, dataFollows, rowsFollow := 0, false, false
+	for i := chunks - 1; i >= 0; i-- {
+		if !dataFollows {
+			trailing += len(aggregators[i].blank)
+		} else if _, err := aggregators[i].flushBlank(aggregators[i].rows.Total, fmt.Sprintf("chunk %d ", i+1)); err != nil {
+			return nil, err
+		}
+		if !rowsFollow {
+			aggregators[i].closeFooter()
+		} else if err := aggregators[i].flushTotals(); err != nil {
+			return nil, err
+		}
+		dataFollows = dataFollows || aggregators[i].rows.Total > 0
+		rowsFollow = rowsFollow || aggregators[i].rows.Processed > 0
+	}
+	if trailing > 0 {
+		cs.log(opts).Infof("Ignored %d blank rows at the end of the file", trailing)
+	}
+	// Chunks number their rows from 0; skipped rows get their row in the file
+	offset := 1
+	for _, agg := range aggregators {
+		for i := range agg.rejected {
+			agg.rejected[i].row += offset
+		}
+		for i := range agg.rows.FooterRowNumbers {
+			agg.rows.FooterRowNumbers[i] += offset
+		}
+		offset += agg.rows.Total
+	}
+	for _, agg := range aggregators[1:] {
+		aggregators[0].merge(agg)
+	}
+	cs.log(opts).Infof("Aggregated %d chunks in parallel", chunks)
+	return aggregators[0], nil
+}
+
+// chunkBoundaries returns the offsets splitting [start, size) into at most n
+// chunks, each boundary placed just after a newline that is not inside a
+// quoted field. Quote parity at each candidate offset is found by counting
+// quote bytes in every span concurrently, which is much cheaper than parsing.
+func chunkBoundaries(r io.ReaderAt, start, size int64, n int) ([]int64, error) {
+	span := (size - start) / int64(n)
+	if n < 2 || span == 0 {
+		return []int64{start, size}, nil
+	}
+
+	candidates := make([]int64, n+1)
+	for i := range candidates {
+		candidates[i] = start + int64(i)*span
+	}
+	candidates[n] = size
+
+	quotes := make([]int64, n)
+	errs := make([]error, n)
+	var wg sync.WaitGroup
+	for i := 0; i < n; i++ {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			quotes[i], errs[i] = countQuotes(io.NewSectionReader(r, candidates[i], candidates[i+1]-candidates[i]))
+		}(i)
+	}
+	wg.Wait()
+	for _, err := range errs {
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	bounds := []int64{start}
+	var seen int64
+	for i := 1; i < n; i++ {
+		seen += quotes[i-1]
+		offset, err := nextRecordStart(r, candidates[i], size, seen%2 == 1)
+		if err != nil {
+			return nil, err
+		}
+		if offset > bounds[len(bounds)-1] && offset < size {
+			bounds = append(bounds, offset)
+		}
+	}
+	return append(bounds, size), nil
+}
+
+// countQuotes counts the double-quote bytes read from r
+func countQuotes(r io.Reader) (int64, error) {
+	buf := make([]byte, 64*1024)
+	var count int64
+	for {
+		n, err := r.Read(buf)
+		count += int64(bytes.Count(buf[:n], []byte{'"'}))
+		if err == io.EOF {
+			return count, nil
+		}
+		if err != nil {
+			return 0, err
+		}
+	}
+}
+
+// nextRecordStart returns the offset just after the first newline at or
+// after offset that lies outside quotes, or size if there is none
+func nextRecordStart(r io.ReaderAt, offset, size int64, inQuote bool) (int64, error) {
+	buf := make([]byte, 64*1024)
+	for offset < size {
+		n, err := r.ReadAt(buf, offset)
+		for i, b := range buf[:n] {
+			switch {
+			case b == '"':
+				inQuote = !inQuote
+			case b == '\n' && !inQuote:
+				return offset + int64(i) + 1, nil
+			}
+		}
+		offset += int64(n)
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return 0, err
+		}
+	}
+	return size, nil
+}
@@ -0,0 +1,95 @@
+package services
+
+import (
+	"bytes"
+	"fmt"
+	"github.com/mussietl/csv-sales-api/internal/models"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/sirupsen/logrus"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestSelectStrategy(t *testing.T) {
+	logger := logrus.New()
+	logger.SetLevel(logrus.ErrorLevel)
+	cs := NewCSVService(logger)
+	cs.SetStrategyThresholds(StrategyThresholds{InMemoryMaxBytes: 100, ParallelMinBytes: 1000, Workers: 4})
+
+	assert.Equal(t, StrategyInMemory, cs.selectStrategy(100, ProcessOptions{}))
+	assert.Equal(t, StrategyStreaming, cs.selectStrategy(101, ProcessOptions{}))
+	assert.Equal(t, StrategyParallel, cs.selectStrategy(1000, ProcessOptions{}))
+	assert.Equal(t, StrategyStreaming, cs.selectStrategy(10, ProcessOptions{Strategy: StrategyStreaming}))
+
+	// Options that need a single pass fall back to streaming
+	assert.Equal(t, StrategyStreaming, cs.selectStrategy(5000, ProcessOptions{DistinctColumns: []string{"order_id"}}))
+	assert.Equal(t, StrategyStreaming, cs.selectStrategy(5000, ProcessOptions{CleanedOutput: &bytes.Buffer{}}))
+
+	cs.SetStrategyThresholds(StrategyThresholds{InMemoryMaxBytes: 100, ParallelMinBytes: 0, Workers: 4})
+	assert.Equal(t, StrategyStreaming, cs.selectStrategy(5000, ProcessOptions{}))
+
+	assert.NoError(t, ValidateStrategy("parallel"))
+	assert.Error(t, ValidateStrategy("async"))
+}
+
+func TestChunkBoundariesRespectQuotes(t *testing.T) {
+	data := "a,\"x\ny\"\nb,\"p\n\nq\"\nc,1\nd,\"\"\"\n\"\n"
+	bounds, err := chunkBoundaries(strings.NewReader(data), 0, int64(len(data)), 8)
+	require.NoError(t, err)
+
+	assert.Equal(t, int64(0), bounds[0])
+	assert.Equal(t, int64(len(data)), bounds[len(bounds)-1])
+	starts := map[int64]bool{0: true, 8: true, 17: true, 21: true}
+	for _, bound := range bounds[:len(bounds)-1] {
+		assert.True(t, starts[bound], "boundary %d is not a record start", bound)
+	}
+}
+
+func TestParallelMatchesStreaming(t *testing.T) {
+	logger := logrus.New()
+	logger.SetLevel(logrus.ErrorLevel)
+	cs := NewCSVService(logger)
+
+	var buf strings.Builder
+	buf.WriteString("Department Name,Notes,Number of Sales\n")
+	for i := 0; i < 5000; i++ {
+		switch i % 7 {
+		case 0:
+			fmt.Fprintf(&buf, "Dept %d,\"multi\nline, \"\"quoted\"\"\",%d\n", i%13, i)
+		case 3:
+			fmt.Fprintf(&buf, "Dept %d,,not a number\n", i%13)
+		default:
+			fmt.Fprintf(&buf, "Dept %d,plain,%d\n", i%13, i)
+		}
+		if i%1000 == 500 {
+			fmt.Fprintf(&buf, "Total,,%d\n", i)
+		}
+	}
+	buf.WriteString("TOTAL,,42\n")
+
+	path := filepath.Join(t.TempDir(), "sales.csv")
+	require.NoError(t, os.WriteFile(path, []byte(buf.String()), 0644))
+
+	streaming, err := cs.Process(path, ProcessOptions{Order: OrderFirstSeen, Strategy: StrategyStreaming})
+	require.NoError(t, err)
+	assert.Equal(t, StrategyStreaming, streaming.Strategy)
+
+	cs.SetStrategyThresholds(StrategyThresholds{Workers: 6})
+	parallel, err := cs.Process(path, ProcessOptions{Order: OrderFirstSeen, Strategy: StrategyParallel})
+	require.NoError(t, err)
+	assert.Equal(t, StrategyParallel, parallel.Strategy)
+
+	assert.Equal(t, streaming.Summaries, parallel.Summaries)
+	assert.Equal(t, streaming.Rows, parallel.Rows)
+	require.NotNil(t, parallel.FooterTotal)
+	assert.Equal(t, models.WholeAmount(42), *parallel.FooterTotal)
+	assert.Equal(t, 1, parallel.Rows.FooterRows)
+
+	inMemory, err := cs.Process(path, ProcessOptions{Order: OrderFirstSeen, Strategy: StrategyInMemory})
+	require.NoError(t, err)
+	assert.Equal(t, streaming.Summaries, inMemory.Summaries)
+}
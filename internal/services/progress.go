@@ -0,0 +1,113 @@
+package services
+
+import (
+	"io"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// progressInterval is the least time between progress reports
+const progressInterval = 250 * time.Millisecond
+
+// progressCheckRows is how many rows are read between checks of whether a
+// progress report is due
+const progressCheckRows = 1024
+
+// ProcessProgress reports how far processing of a file has come
+type ProcessProgress struct {
+	RowsProcessed int64 `json:"rows_processed"`
+	BytesRead     int64 `json:"bytes_read"`
+	// TotalBytes is the size of the file, or 0 when it is not known in advance
+	TotalBytes int64 `json:"total_bytes,omitempty"`
+	// Percent is the share of the file read, when its size is known
+	Percent *float64 `json:"percent_complete,omitempty"`
+	Done    bool     `json:"done"`
+}
+
+// progressTracker counts the rows and bytes read while processing a file and
+// reports them at most every progressInterval. A nil tracker does nothing, and
+// a tracker may be shared by concurrent readers.
+type progressTracker struct {
+	report func(ProcessProgress)
+	total  int64
+	now    func() time.Time
+
+	rows  atomic.Int64
+	bytes atomic.Int64
+
+	mu       sync.Mutex
+	reported time.Time
+}
+
+// newProgressTracker returns a tracker reporting to report, or nil when
+// report is nil. total is the file size, 0 if unknown.
+func newProgressTracker(report func(ProcessProgress), total int64) *progressTracker {
+	if report == nil {
+		return nil
+	}
+	return &progressTracker{report: report, total: total, now: time.Now}
+}
+
+// reader counts the bytes read from r
+func (p *progressTracker) reader(r io.Reader) io.Reader {
+	if p == nil {
+		return r
+	}
+	return &progressReader{r: r, tracker: p}
+}
+
+// row counts a row read, reporting progress if a report is due
+func (p *progressTracker) row() {
+	if p == nil {
+		return
+	}
+	if p.rows.Add(1)%progressCheckRows == 0 {
+		p.send(false)
+	}
+}
+
+// finish reports the final progress
+func (p *progressTracker) finish() {
+	if p == nil {
+		return
+	}
+	p.send(true)
+}
+
+func (p *progressTracker) send(done bool) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	now := p.now()
+	if !done && now.Sub(p.reported) < progressInterval {
+		return
+	}
+	p.reported = now
+	p.report(p.snapshot(done))
+}
+
+// snapshot returns the current progress. Read-ahead can make the bytes read
+// overshoot the size slightly, so they are capped at it.
+func (p *progressTracker) snapshot(done bool) ProcessProgress {
+	progress := ProcessProgress{RowsProcessed: p.rows.Load(), BytesRead: p.bytes.Load(), TotalBytes: p.total, Done: done}
+	if p.total > 0 {
+		if done || progress.BytesRead > p.total {
+			progress.BytesRead = p.total
+		}
+		percent := float64(int64(float64(progress.BytesRead)/float64(p.total)*1000)) / 10
+		progress.Percent = &percent
+	}
+	return progress
+}
+
+// progressReader counts the bytes read through it
+type progressReader struct {
+	r       io.Reader
+	tracker *progressTracker
+}
+
+func (pr *progressReader) Read(b []byte) (int, error) {
+	n, err := pr.r.Read(b)
+	pr.tracker.bytes.Add(int64(n))
+	return n, err
+}
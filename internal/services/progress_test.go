@@ -0,0 +1,114 @@
+package services
+
+import (
+	"compress/gzip"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/sirupsen/logrus"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestProgressTracker(t *testing.T) {
+	var reports []ProcessProgress
+	tracker := newProgressTracker(func(p ProcessProgress) { reports = append(reports, p) }, 1000)
+	now := time.Date(2024, 3, 1, 9, 0, 0, 0, time.UTC)
+	tracker.now = func() time.Time { return now }
+
+	_, err := tracker.reader(strings.NewReader(strings.Repeat("x", 400))).Read(make([]byte, 250))
+	require.NoError(t, err)
+	for i := 0; i < progressCheckRows; i++ {
+		tracker.row()
+	}
+	require.Len(t, reports, 1)
+	assert.Equal(t, int64(progressCheckRows), reports[0].RowsProcessed)
+	assert.Equal(t, int64(250), reports[0].BytesRead)
+	require.NotNil(t, reports[0].Percent)
+	assert.Equal(t, 25.0, *reports[0].Percent)
+
+	for i := 0; i < progressCheckRows; i++ {
+		tracker.row()
+	}
+	assert.Len(t, reports, 1, "reports are throttled")
+	now = now.Add(progressInterval)
+	for i := 0; i < progressCheckRows; i++ {
+		tracker.row()
+	}
+	assert.Len(t, reports, 2)
+
+	tracker.finish()
+	require.Len(t, reports, 3)
+	assert.True(t, reports[2].Done)
+	assert.Equal(t, int64(1000), reports[2].BytesRead)
+	assert.Equal(t, 100.0, *reports[2].Percent)
+
+	var nilTracker *progressTracker
+	nilTracker.row()
+	nilTracker.finish()
+	assert.Nil(t, newProgressTracker(nil, 10))
+}
+
+func TestProcessProgress(t *testing.T) {
+	logger := logrus.New()
+	logger.SetLevel(logrus.PanicLevel)
+	cs := NewCSVService(logger)
+	cs.SetStrategyThresholds(StrategyThresholds{Workers: 4})
+
+	var buf strings.Builder
+	buf.WriteString("Department Name,Number of Sales\n")
+	for i := 0; i < 5000; i++ {
+		fmt.Fprintf(&buf, "Dept %d,%d\n", i%7, i)
+	}
+	dir := t.TempDir()
+	path := filepath.Join(dir, "sales.csv")
+	require.NoError(t, os.WriteFile(path, []byte(buf.String()), 0644))
+
+	for _, opts := range []ProcessOptions{
+		{Strategy: StrategyStreaming},
+		{Strategy: StrategyInMemory, Parser: ParserFast},
+		{Strategy: StrategyParallel},
+	} {
+		var mu sync.Mutex
+		var last ProcessProgress
+		opts.Progress = func(p ProcessProgress) {
+			mu.Lock()
+			defer mu.Unlock()
+			last = p
+		}
+		_, err := cs.Process(path, opts)
+		require.NoError(t, err)
+		assert.True(t, last.Done, opts.Strategy)
+		assert.Equal(t, int64(5000), last.RowsProcessed, opts.Strategy)
+		assert.Equal(t, int64(buf.Len()), last.BytesRead, opts.Strategy)
+		assert.Equal(t, 100.0, *last.Percent, opts.Strategy)
+	}
+
+	gzPath := filepath.Join(dir, "sales.csv.gz")
+	file, err := os.Create(gzPath)
+	require.NoError(t, err)
+	gz := gzip.NewWriter(file)
+	_, err = gz.Write([]byte(buf.String()))
+	require.NoError(t, err)
+	require.NoError(t, gz.Close())
+	require.NoError(t, file.Close())
+	info, err := os.Stat(gzPath)
+	require.NoError(t, err)
+
+	var last ProcessProgress
+	_, err = cs.Process(gzPath, ProcessOptions{Progress: func(p ProcessProgress) { last = p }})
+	require.NoError(t, err)
+	assert.Equal(t, int64(5000), last.RowsProcessed)
+	assert.Equal(t, info.Size(), last.TotalBytes, "compressed files report compressed bytes")
+
+	_, err = cs.ProcessStream(strings.NewReader(buf.String()), ProcessOptions{Progress: func(p ProcessProgress) { last = p }})
+	require.NoError(t, err)
+	assert.Equal(t, int64(buf.Len()), last.BytesRead)
+	assert.Zero(t, last.TotalBytes)
+	assert.Nil(t, last.Percent, "the size of a stream is not known")
+}
@@ -0,0 +1,175 @@
+package services
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"sort"
+	"strings"
+	"sync"
+)
+
+// Failure types of uploads that could not be processed, as reported in
+// processing.completed events and quality metrics
+const (
+	FailureSchemaViolation = "schema_violation"
+	FailurePIIDetected     = "pii_detected"
+	FailureMissingValue    = "missing_value"
+	FailureColumnNotFound  = "column_not_found"
+	FailureLimitExceeded   = "limit_exceeded"
+	FailureContentMismatch = "content_mismatch"
+	// FailureSkippedRatio is an upload rejected for skipping more rows than
+	// its max_skipped_ratio allows
+	FailureSkippedRatio = "max_skipped_ratio"
+	FailureExchangeRate = "exchange_rate"
+	FailureOther        = "other"
+)
+
+// FailureType classifies a processing error
+func FailureType(err error) string {
+	switch {
+	case errors.Is(err, ErrSchemaViolation):
+		return FailureSchemaViolation
+	case errors.Is(err, ErrPIIDetected):
+		return FailurePIIDetected
+	case errors.Is(err, ErrMissingValue):
+		return FailureMissingValue
+	case errors.Is(err, ErrColumnNotFound):
+		return FailureColumnNotFound
+	case errors.Is(err, ErrCSVLimitExceeded):
+		return FailureLimitExceeded
+	case errors.Is(err, ErrContentMismatch):
+		return FailureContentMismatch
+	case errors.Is(err, ErrExchangeRate):
+		return FailureExchangeRate
+	}
+	return FailureOther
+}
+
+// MaxMetricTenants bounds the tenants quality metrics are kept for. The
+// tenant comes from a request header, so later tenants are counted together
+// under OtherMetricTenant rather than growing the metrics without limit.
+const MaxMetricTenants = 1000
+
+// OtherMetricTenant is the tenant label of tenants beyond MaxMetricTenants
+const OtherMetricTenant = "_other"
+
+// tenantQuality holds the counters of one tenant
+type tenantQuality struct {
+	completed int64
+	failed    int64
+	rows      int64
+	skipped   map[string]int64
+	failures  map[string]int64
+}
+
+// QualityMetrics counts, per tenant, the rows skipped for each reason and
+// the uploads that failed for each failure type, so tenants whose feeds are
+// getting worse stand out. They are fed by processing.completed events and
+// exposed in the Prometheus text format.
+type QualityMetrics struct {
+	mu      sync.Mutex
+	tenants map[string]*tenantQuality
+}
+
+// NewQualityMetrics creates QualityMetrics with every counter at zero
+func NewQualityMetrics() *QualityMetrics {
+	return &QualityMetrics{tenants: make(map[string]*tenantQuality)}
+}
+
+// Handle counts a processing.completed event
+func (qm *QualityMetrics) Handle(e Event) {
+	if e.Type != EventProcessingCompleted {
+		return
+	}
+	qm.mu.Lock()
+	defer qm.mu.Unlock()
+	tenant := qm.tenant(e.Tenant)
+	if e.Rows != nil {
+		tenant.rows += int64(e.Rows.Total)
+		for reason, count := range e.Rows.SkipReasons {
+			tenant.skipped[reason] += int64(count)
+		}
+	}
+	if e.Success {
+		tenant.completed++
+		return
+	}
+	tenant.failed++
+	failureType := e.ErrorType
+	if failureType == "" {
+		failureType = FailureOther
+	}
+	tenant.failures[failureType]++
+}
+
+// tenant returns the counters of a tenant, creating them; callers hold the lock
+func (qm *QualityMetrics) tenant(name string) *tenantQuality {
+	if tenant, ok := qm.tenants[name]; ok {
+		return tenant
+	}
+	if len(qm.tenants) >= MaxMetricTenants {
+		name = OtherMetricTenant
+		if tenant, ok := qm.tenants[name]; ok {
+			return tenant
+		}
+	}
+	tenant := &tenantQuality{skipped: make(map[string]int64), failures: make(map[string]int64)}
+	qm.tenants[name] = tenant
+	return tenant
+}
+
+// WritePrometheus writes the counters in the Prometheus text exposition format
+func (qm *QualityMetrics) WritePrometheus(w io.Writer) error {
+	qm.mu.Lock()
+	defer qm.mu.Unlock()
+	names := make([]string, 0, len(qm.tenants))
+	for name := range qm.tenants {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	var b strings.Builder
+	family := func(name, help string) {
+		fmt.Fprintf(&b, "# HELP %s %s\n# TYPE %s counter\n", name, help, name)
+	}
+	family("csv_sales_uploads_processed_total", "Uploads processed, by tenant and outcome.")
+	for _, name := range names {
+		tenant := qm.tenants[name]
+		fmt.Fprintf(&b, "csv_sales_uploads_processed_total{tenant=\"%s\",outcome=\"completed\"} %d\n", escapeLabel(name), tenant.completed)
+		fmt.Fprintf(&b, "csv_sales_uploads_processed_total{tenant=\"%s\",outcome=\"failed\"} %d\n", escapeLabel(name), tenant.failed)
+	}
+	family("csv_sales_rows_total", "Data rows read from processed uploads, by tenant.")
+	for _, name := range names {
+		fmt.Fprintf(&b, "csv_sales_rows_total{tenant=\"%s\"} %d\n", escapeLabel(name), qm.tenants[name].rows)
+	}
+	family("csv_sales_rows_skipped_total", "Rows skipped while processing uploads, by tenant and skip reason.")
+	for _, name := range names {
+		for _, reason := range sortedKeys(qm.tenants[name].skipped) {
+			fmt.Fprintf(&b, "csv_sales_rows_skipped_total{tenant=\"%s\",reason=\"%s\"} %d\n", escapeLabel(name), escapeLabel(reason), qm.tenants[name].skipped[reason])
+		}
+	}
+	family("csv_sales_upload_failures_total", "Uploads that failed processing, by tenant and failure type.")
+	for _, name := range names {
+		for _, failureType := range sortedKeys(qm.tenants[name].failures) {
+			fmt.Fprintf(&b, "csv_sales_upload_failures_total{tenant=\"%s\",type=\"%s\"} %d\n", escapeLabel(name), escapeLabel(failureType), qm.tenants[name].failures[failureType])
+		}
+	}
+	_, err := io.WriteString(w, b.String())
+	return err
+}
+
+// sortedKeys returns the keys of a counter map in order
+func sortedKeys(counts map[string]int64) []string {
+	keys := make([]string, 0, len(counts))
+	for key := range counts {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+	return keys
+}
+
+// escapeLabel escapes a label value for the Prometheus text format
+func escapeLabel(value string) string {
+	return strings.NewReplacer(`\`, `\\`, `"`, `\"`, "\n", `\n`).Replace(value)
+}
@@ -0,0 +1,53 @@
+package services
+
+import (
+	"fmt"
+	"strings"
+	"testing"
+
+	"github.com/mussietl/csv-sales-api/internal/models"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestFailureType(t *testing.T) {
+	assert.Equal(t, FailureSchemaViolation, FailureType(&SchemaError{Violations: []models.SchemaViolation{{Row: 2}}}))
+	assert.Equal(t, FailureColumnNotFound, FailureType(fmt.Errorf("sales column: %w", ErrColumnNotFound)))
+	assert.Equal(t, FailureMissingValue, FailureType(fmt.Errorf("row 3: %w", ErrMissingValue)))
+	assert.Equal(t, FailureOther, FailureType(fmt.Errorf("disk full")))
+}
+
+func TestQualityMetrics(t *testing.T) {
+	qm := NewQualityMetrics()
+	qm.Handle(Event{Type: EventProcessingCompleted, Tenant: "acme", Success: true, Rows: &models.RowStats{
+		Total: 10, Processed: 7, Skipped: 3, SkipReasons: map[string]int{SkipInvalidSales: 2, SkipEmptyDepartment: 1},
+	}})
+	qm.Handle(Event{Type: EventProcessingCompleted, Tenant: "acme", Error: "too many skipped", ErrorType: FailureSkippedRatio, Rows: &models.RowStats{
+		Total: 4, Skipped: 4, SkipReasons: map[string]int{SkipInvalidSales: 4},
+	}})
+	qm.Handle(Event{Type: EventProcessingCompleted, Tenant: `we"ird`, Error: "disk full"})
+	qm.Handle(Event{Type: EventProcessingStarted, Tenant: "acme"})
+
+	var b strings.Builder
+	require.NoError(t, qm.WritePrometheus(&b))
+	out := b.String()
+	for _, line := range []string{
+		"# TYPE csv_sales_rows_skipped_total counter",
+		`csv_sales_uploads_processed_total{tenant="acme",outcome="completed"} 1`,
+		`csv_sales_uploads_processed_total{tenant="acme",outcome="failed"} 1`,
+		`csv_sales_rows_total{tenant="acme"} 14`,
+		`csv_sales_rows_skipped_total{tenant="acme",reason="empty_department"} 1`,
+		`csv_sales_rows_skipped_total{tenant="acme",reason="invalid_sales"} 6`,
+		`csv_sales_upload_failures_total{tenant="acme",type="max_skipped_ratio"} 1`,
+		`csv_sales_upload_failures_total{tenant="we\"ird",type="other"} 1`,
+	} {
+		assert.Contains(t, out, line+"\n")
+	}
+
+	// Tenants beyond the limit share one label
+	for i := 0; i < MaxMetricTenants+5; i++ {
+		qm.Handle(Event{Type: EventProcessingCompleted, Tenant: fmt.Sprintf("t%d", i), Success: true})
+	}
+	assert.Len(t, qm.tenants, MaxMetricTenants+1)
+	assert.Equal(t, int64(7), qm.tenants[OtherMetricTenant].completed)
+}
@@ -0,0 +1,86 @@
+package services
+
+import (
+	"math"
+	"sync"
+	"time"
+)
+
+// rateLimiterPruneSize is the number of tracked clients above which full
+// buckets are forgotten
+const rateLimiterPruneSize = 10000
+
+// RateLimiter limits the request rate of each client with a token bucket:
+// a client may make burst requests at once, and regains one request every
+// 1/rate. A rate of 0 means unlimited.
+type RateLimiter struct {
+	rate    float64 // tokens per second
+	burst   float64
+	mu      sync.Mutex
+	buckets map[string]*tokenBucket
+	now     func() time.Time
+}
+
+// tokenBucket holds a client's tokens as of updated
+type tokenBucket struct {
+	tokens  float64
+	updated time.Time
+}
+
+// NewRateLimiter creates a RateLimiter allowing perMinute requests a minute
+// per client, in bursts of up to burst
+func NewRateLimiter(perMinute float64, burst int) *RateLimiter {
+	if burst < 1 {
+		burst = 1
+	}
+	return &RateLimiter{
+		rate:    perMinute / 60,
+		burst:   float64(burst),
+		buckets: make(map[string]*tokenBucket),
+		now:     time.Now,
+	}
+}
+
+// Enabled reports whether requests are limited at all
+func (rl *RateLimiter) Enabled() bool {
+	return rl != nil && rl.rate > 0
+}
+
+// Allow takes a token from client's bucket. When the bucket is empty it
+// returns false and how long until a token is available.
+func (rl *RateLimiter) Allow(client string) (bool, time.Duration) {
+	if !rl.Enabled() {
+		return true, 0
+	}
+	rl.mu.Lock()
+	defer rl.mu.Unlock()
+
+	now := rl.now()
+	bucket, ok := rl.buckets[client]
+	if !ok {
+		if len(rl.buckets) >= rateLimiterPruneSize {
+			rl.prune(now)
+		}
+		bucket = &tokenBucket{tokens: rl.burst, updated: now}
+		rl.buckets[client] = bucket
+	}
+	bucket.tokens = math.Min(rl.burst, bucket.tokens+now.Sub(bucket.updated).Seconds()*rl.rate)
+	bucket.updated = now
+
+	if bucket.tokens < 1 {
+		wait := time.Duration(math.Ceil((1 - bucket.tokens) / rl.rate * float64(time.Second)))
+		return false, wait
+	}
+	bucket.tokens--
+	return true, 0
+}
+
+// prune forgets the clients whose buckets have refilled, which behave
+// exactly like new clients
+func (rl *RateLimiter) prune(now time.Time) {
+	for client, bucket := range rl.buckets {
+		if bucket.tokens+now.Sub(bucket.updated).Seconds()*rl.rate >= rl.burst {
+			delete(rl.buckets, client)
+		}
+	}
+}
@@ -0,0 +1,56 @@
+package services
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestRateLimiter(t *testing.T) {
+	now := time.Date(2024, 3, 1, 12, 0, 0, 0, time.UTC)
+	rl := NewRateLimiter(6, 2)
+	rl.now = func() time.Time { return now }
+
+	ok, _ := rl.Allow("ip:10.0.0.1")
+	assert.True(t, ok)
+	ok, _ = rl.Allow("ip:10.0.0.1")
+	assert.True(t, ok)
+	ok, wait := rl.Allow("ip:10.0.0.1")
+	assert.False(t, ok)
+	assert.Equal(t, 10*time.Second, wait)
+
+	// Other clients have their own bucket
+	ok, _ = rl.Allow("key:k1")
+	assert.True(t, ok)
+
+	now = now.Add(4 * time.Second)
+	ok, wait = rl.Allow("ip:10.0.0.1")
+	assert.False(t, ok)
+	assert.Equal(t, 6*time.Second, wait)
+
+	now = now.Add(6 * time.Second)
+	ok, _ = rl.Allow("ip:10.0.0.1")
+	assert.True(t, ok)
+
+	// Idle time refills the bucket up to the burst only
+	now = now.Add(time.Hour)
+	for i := 0; i < 2; i++ {
+		ok, _ = rl.Allow("ip:10.0.0.1")
+		assert.True(t, ok)
+	}
+	ok, _ = rl.Allow("ip:10.0.0.1")
+	assert.False(t, ok)
+}
+
+func TestRateLimiterDisabled(t *testing.T) {
+	rl := NewRateLimiter(0, 1)
+	assert.False(t, rl.Enabled())
+	for i := 0; i < 100; i++ {
+		ok, _ := rl.Allow("ip:10.0.0.1")
+		assert.True(t, ok)
+	}
+	var unset *RateLimiter
+	ok, _ := unset.Allow("ip:10.0.0.1")
+	assert.True(t, ok)
+}
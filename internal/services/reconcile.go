@@ -0,0 +1,52 @@
+package services
+
+import (
+	"github.com/mussietl/csv-sales-api/internal/models"
+)
+
+// Reconciliation sources
+const (
+	ReconcileSourceFooter    = "footer"
+	ReconcileSourceParameter = "expected_total"
+)
+
+// totalRowLabels are department values that mark a footer total row
+var totalRowLabels = map[string]bool{
+	"total":       true,
+	"totals":      true,
+	"grand total": true,
+	"sum":         true,
+}
+
+// isTotalRow reports whether a department value labels a footer total row
+func isTotalRow(department string) bool {
+	return totalRowLabels[DefaultHeaderNormalization.Apply(department)]
+}
+
+// Reconcile compares the computed total against a footer total and/or a
+// client-supplied expected total. It returns nil when there is nothing to compare.
+func Reconcile(computed models.Amount, footerTotal, expectedTotal *models.Amount) *models.Reconciliation {
+	if footerTotal == nil && expectedTotal == nil {
+		return nil
+	}
+
+	r := &models.Reconciliation{ComputedTotal: computed, Reconciled: true}
+	add := func(source string, expected models.Amount) {
+		check := models.ReconciliationCheck{
+			Source:   source,
+			Expected: expected,
+			Delta:    computed.Sub(expected),
+			Match:    computed == expected,
+		}
+		r.Checks = append(r.Checks, check)
+		r.Reconciled = r.Reconciled && check.Match
+	}
+
+	if footerTotal != nil {
+		add(ReconcileSourceFooter, *footerTotal)
+	}
+	if expectedTotal != nil {
+		add(ReconcileSourceParameter, *expectedTotal)
+	}
+	return r
+}
@@ -0,0 +1,81 @@
+package services
+
+import (
+	"github.com/mussietl/csv-sales-api/internal/models"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/sirupsen/logrus"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestReconcile(t *testing.T) {
+	computed := models.WholeAmount(100)
+	assert.Nil(t, Reconcile(computed, nil, nil))
+
+	footer, expected := models.WholeAmount(100), models.WholeAmount(90)
+	r := Reconcile(computed, &footer, &expected)
+	require.NotNil(t, r)
+	assert.False(t, r.Reconciled)
+	require.Len(t, r.Checks, 2)
+	assert.Equal(t, ReconcileSourceFooter, r.Checks[0].Source)
+	assert.True(t, r.Checks[0].Match)
+	assert.Equal(t, models.WholeAmount(10), r.Checks[1].Delta)
+	assert.False(t, r.Checks[1].Match)
+
+	r = Reconcile(computed, &footer, nil)
+	assert.True(t, r.Reconciled)
+}
+
+func TestCSVServiceProcessFooterTotal(t *testing.T) {
+	logger := logrus.New()
+	logger.SetLevel(logrus.ErrorLevel)
+	csvService := NewCSVService(logger)
+
+	tempFile, err := os.CreateTemp("", "test_*.csv")
+	require.NoError(t, err)
+	defer os.Remove(tempFile.Name())
+
+	_, err = tempFile.WriteString("department,sales\nElectronics,1000\nClothing,500\n Grand  Total ,1600\n")
+	require.NoError(t, err)
+	tempFile.Close()
+
+	result, err := csvService.Process(tempFile.Name(), ProcessOptions{})
+	require.NoError(t, err)
+	assert.Len(t, result.Summaries, 2)
+	require.NotNil(t, result.FooterTotal)
+	assert.Equal(t, models.WholeAmount(1600), *result.FooterTotal)
+	assert.Equal(t, 1, result.Rows.FooterRows)
+	assert.Equal(t, []int{4}, result.Rows.FooterRowNumbers)
+	assert.Equal(t, 0, result.Rows.Skipped)
+
+	result, err = csvService.Process(tempFile.Name(), ProcessOptions{KeepTotalRows: true})
+	require.NoError(t, err)
+	assert.Len(t, result.Summaries, 3)
+	assert.Nil(t, result.FooterTotal)
+}
+
+func TestCSVServiceProcessTotalRowsBeforeData(t *testing.T) {
+	logger := logrus.New()
+	logger.SetLevel(logrus.ErrorLevel)
+	csvService := NewCSVService(logger)
+
+	path := filepath.Join(t.TempDir(), "sales.csv")
+	require.NoError(t, os.WriteFile(path, []byte("department,sales\nElectronics,1000\nTotal,1000\nSum,7\nClothing,500\nnotes,\nSubtotal,1500\nTotals,1500\nGrand Total,1507\n\n"), 0644))
+
+	// Only the TOTAL rows ending the data are the footer; earlier ones are departments
+	result, err := csvService.Process(path, ProcessOptions{Order: OrderFirstSeen})
+	require.NoError(t, err)
+	var departments []string
+	for _, summary := range result.Summaries {
+		departments = append(departments, summary.Department)
+	}
+	assert.Equal(t, []string{"Electronics", "Total", "Sum", "Clothing", "Subtotal"}, departments)
+	require.NotNil(t, result.FooterTotal)
+	assert.Equal(t, models.WholeAmount(1507), *result.FooterTotal)
+	assert.Equal(t, 2, result.Rows.FooterRows)
+	assert.Equal(t, []int{8, 9}, result.Rows.FooterRowNumbers)
+	assert.Equal(t, 5, result.Rows.Processed)
+}
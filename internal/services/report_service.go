@@ -0,0 +1,206 @@
+package services
+
+import (
+	"embed"
+	"fmt"
+	htmltemplate "html/template"
+	"io"
+	"sync"
+	texttemplate "text/template"
+	"time"
+
+	"github.com/mussietl/csv-sales-api/internal/models"
+	"github.com/sirupsen/logrus"
+)
+
+// reportTopN is the number of departments exposed as .Top to templates
+const reportTopN = 10
+
+// MaxReportTemplateSize bounds the size of client-supplied templates
+const MaxReportTemplateSize = 64 << 10
+
+// MaxReportSize bounds the size of a rendered report, since a small template
+// can loop over the departments many times over
+const MaxReportSize = 16 << 20
+
+// ReportRenderTimeout bounds how long a report may take to render
+const ReportRenderTimeout = 10 * time.Second
+
+// ErrReportTooLarge is returned when a report grows past MaxReportSize
+var ErrReportTooLarge = fmt.Errorf("report is larger than %d bytes", MaxReportSize)
+
+// ErrReportTimeout is returned when a report takes longer than ReportRenderTimeout
+var ErrReportTimeout = fmt.Errorf("report took longer than %s to render", ReportRenderTimeout)
+
+//go:embed templates/*.tmpl
+var reportTemplates embed.FS
+
+// Report formats and the file extension each produces
+var reportExtensions = map[string]string{
+	"markdown": "md",
+	"html":     "html",
+	"text":     "txt",
+}
+
+// ReportData is the data available to report templates
+type ReportData struct {
+	Filename         string
+	ProcessedAt      string
+	TotalSales       models.Amount
+	TotalDepartments int
+	// Departments lists every department by descending total
+	Departments []DepartmentSummary
+	// Top holds the first departments of Departments
+	Top  []DepartmentSummary
+	Rows models.RowStats
+	// Format sets how Amount writes totals
+	Format NumberFormat
+}
+
+// Amount writes a total with the report's precision and rounding
+func (d ReportData) Amount(value models.Amount) string {
+	return d.Format.FormatAmount(value)
+}
+
+// NewReportData builds template data from processing results
+func NewReportData(filename, processedAt string, summaries []DepartmentSummary, rows models.RowStats) ReportData {
+	data := ReportData{
+		Filename:         filename,
+		ProcessedAt:      processedAt,
+		TotalDepartments: len(summaries),
+		Departments:      topDepartments(summaries, len(summaries)),
+		Rows:             rows,
+		Format:           DefaultNumberFormat,
+	}
+	for _, s := range summaries {
+		data.TotalSales = data.TotalSales.Add(s.TotalSales)
+	}
+	data.Top = data.Departments
+	if len(data.Top) > reportTopN {
+		data.Top = data.Top[:reportTopN]
+	}
+	return data
+}
+
+// ReportTemplate is a parsed report template ready to render
+type ReportTemplate struct {
+	Format    string
+	Extension string
+	execute   func(w io.Writer, data ReportData) error
+	maxSize   int64
+	timeout   time.Duration
+}
+
+// Render writes the report for data to w, failing with ErrReportTooLarge or
+// ErrReportTimeout when it gets too large or slow. Templates can't be
+// interrupted, so one still running at the timeout is left to stop at its
+// next write; nothing more reaches w after Render returns.
+func (rt *ReportTemplate) Render(w io.Writer, data ReportData) error {
+	out := &reportWriter{w: w, remaining: rt.maxSize}
+	done := make(chan error, 1)
+	go func() { done <- rt.execute(out, data) }()
+
+	timer := time.NewTimer(rt.timeout)
+	defer timer.Stop()
+	var err error
+	select {
+	case err = <-done:
+	case <-timer.C:
+		out.stop(ErrReportTimeout)
+		err = ErrReportTimeout
+	}
+	if err != nil {
+		return fmt.Errorf("failed to render report: %w", err)
+	}
+	return nil
+}
+
+// reportWriter passes a report through to its destination until it exceeds
+// its size or is stopped, after which every write fails
+type reportWriter struct {
+	mu        sync.Mutex
+	w         io.Writer
+	remaining int64
+	err       error
+}
+
+func (rw *reportWriter) Write(p []byte) (int, error) {
+	rw.mu.Lock()
+	defer rw.mu.Unlock()
+	if rw.err != nil {
+		return 0, rw.err
+	}
+	if int64(len(p)) > rw.remaining {
+		rw.err = ErrReportTooLarge
+		return 0, rw.err
+	}
+	rw.remaining -= int64(len(p))
+	return rw.w.Write(p)
+}
+
+// stop fails every later write with err
+func (rw *reportWriter) stop(err error) {
+	rw.mu.Lock()
+	defer rw.mu.Unlock()
+	rw.err = err
+}
+
+// ReportService renders textual summary reports from templates
+type ReportService struct {
+	logger *logrus.Logger
+}
+
+// NewReportService creates a new ReportService instance
+func NewReportService(logger *logrus.Logger) *ReportService {
+	return &ReportService{
+		logger: logger,
+	}
+}
+
+// NamedTemplate returns one of the built-in templates ("markdown" or "html")
+func (rs *ReportService) NamedTemplate(name string) (*ReportTemplate, error) {
+	source, err := reportTemplates.ReadFile("templates/" + name + ".tmpl")
+	if err != nil {
+		return nil, fmt.Errorf("unknown report template %q: expected markdown or html", name)
+	}
+	return rs.ParseTemplate(string(source), name)
+}
+
+// ParseTemplate parses a client-supplied template producing the given format
+// (markdown, html or text). HTML templates are auto-escaped.
+func (rs *ReportService) ParseTemplate(source, format string) (*ReportTemplate, error) {
+	ext, ok := reportExtensions[format]
+	if !ok {
+		return nil, fmt.Errorf("unknown report format %q: expected markdown, html or text", format)
+	}
+	if len(source) > MaxReportTemplateSize {
+		return nil, fmt.Errorf("report template is larger than %d bytes", MaxReportTemplateSize)
+	}
+
+	rt := &ReportTemplate{Format: format, Extension: ext, maxSize: MaxReportSize, timeout: ReportRenderTimeout}
+	if format == "html" {
+		tmpl, err := htmltemplate.New("report").Funcs(htmltemplate.FuncMap(reportFuncs)).Parse(source)
+		if err != nil {
+			return nil, fmt.Errorf("invalid report template: %w", err)
+		}
+		rt.execute = func(w io.Writer, data ReportData) error { return tmpl.Execute(w, data) }
+	} else {
+		tmpl, err := texttemplate.New("report").Funcs(texttemplate.FuncMap(reportFuncs)).Parse(source)
+		if err != nil {
+			return nil, fmt.Errorf("invalid report template: %w", err)
+		}
+		rt.execute = func(w io.Writer, data ReportData) error { return tmpl.Execute(w, data) }
+	}
+	return rt, nil
+}
+
+// reportFuncs are the helper functions available to templates
+var reportFuncs = map[string]interface{}{
+	"inc": func(i int) int { return i + 1 },
+	"percent": func(part, total models.Amount) string {
+		if total.IsZero() {
+			return "0.0%"
+		}
+		return fmt.Sprintf("%.1f%%", part.Float64()*100/total.Float64())
+	},
+}
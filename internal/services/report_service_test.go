@@ -0,0 +1,92 @@
+package services
+
+import (
+	"fmt"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/mussietl/csv-sales-api/internal/models"
+	"github.com/sirupsen/logrus"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestReportServiceNamedTemplates(t *testing.T) {
+	logger := logrus.New()
+	logger.SetLevel(logrus.ErrorLevel)
+	rs := NewReportService(logger)
+
+	data := NewReportData("sales.csv", "2024-01-15T10:30:00Z", []DepartmentSummary{
+		{Department: "Books", TotalSales: models.WholeAmount(250)},
+		{Department: "<Electronics>", TotalSales: models.WholeAmount(750)},
+	}, models.RowStats{Total: 2, Processed: 2})
+	assert.Equal(t, models.WholeAmount(1000), data.TotalSales)
+	assert.Equal(t, "<Electronics>", data.Top[0].Department)
+
+	markdown, err := rs.NamedTemplate("markdown")
+	require.NoError(t, err)
+	assert.Equal(t, "md", markdown.Extension)
+	var out strings.Builder
+	require.NoError(t, markdown.Render(&out, data))
+	assert.Contains(t, out.String(), "| 1 | <Electronics> | 750 | 75.0% |")
+	assert.Contains(t, out.String(), "| Total sales | 1000 |")
+
+	html, err := rs.NamedTemplate("html")
+	require.NoError(t, err)
+	out.Reset()
+	require.NoError(t, html.Render(&out, data))
+	assert.Contains(t, out.String(), "&lt;Electronics&gt;")
+	assert.NotContains(t, out.String(), "<Electronics>")
+
+	_, err = rs.NamedTemplate("../report_service")
+	assert.Error(t, err)
+}
+
+func TestReportServiceParseTemplate(t *testing.T) {
+	logger := logrus.New()
+	rs := NewReportService(logger)
+
+	tmpl, err := rs.ParseTemplate("{{ range .Top }}{{ .Department }}={{ .TotalSales }};{{ end }}", "text")
+	require.NoError(t, err)
+	var out strings.Builder
+	require.NoError(t, tmpl.Render(&out, NewReportData("f.csv", "", []DepartmentSummary{{Department: "A", TotalSales: models.WholeAmount(1)}}, models.RowStats{})))
+	assert.Equal(t, "A=1;", out.String())
+
+	_, err = rs.ParseTemplate("{{ .Missing", "text")
+	assert.Error(t, err)
+	_, err = rs.ParseTemplate("x", "pdf")
+	assert.Error(t, err)
+	_, err = rs.ParseTemplate(strings.Repeat("x", MaxReportTemplateSize+1), "text")
+	assert.Error(t, err)
+
+	tmpl, err = rs.ParseTemplate("{{ .NoSuchField }}", "text")
+	require.NoError(t, err)
+	assert.Error(t, tmpl.Render(&out, ReportData{}))
+}
+
+func TestReportTemplateLimits(t *testing.T) {
+	rs := NewReportService(logrus.New())
+	summaries := make([]DepartmentSummary, 1000)
+	for i := range summaries {
+		summaries[i] = DepartmentSummary{Department: fmt.Sprintf("D%d", i), TotalSales: models.WholeAmount(i)}
+	}
+	data := NewReportData("f.csv", "", summaries, models.RowStats{})
+	source := "{{ range .Departments }}{{ range $.Departments }}{{ .Department }}{{ end }}{{ end }}"
+
+	tmpl, err := rs.ParseTemplate(source, "text")
+	require.NoError(t, err)
+	tmpl.maxSize = 1 << 10
+	var out strings.Builder
+	assert.ErrorIs(t, tmpl.Render(&out, data), ErrReportTooLarge)
+	assert.LessOrEqual(t, out.Len(), 1<<10)
+
+	tmpl, err = rs.ParseTemplate(source, "html")
+	require.NoError(t, err)
+	tmpl.timeout = time.Millisecond
+	var slow strings.Builder
+	assert.ErrorIs(t, tmpl.Render(&slow, data), ErrReportTimeout)
+	written := slow.Len()
+	time.Sleep(10 * time.Millisecond)
+	assert.Equal(t, written, slow.Len(), "nothing is written after the timeout")
+}
@@ -0,0 +1,24 @@
+package services
+
+import (
+	"context"
+
+	"github.com/sirupsen/logrus"
+)
+
+// loggerKey is the context key of a request's log entry
+type loggerKey struct{}
+
+// ContextWithLogger returns a copy of ctx carrying a request's log entry
+func ContextWithLogger(ctx context.Context, entry *logrus.Entry) context.Context {
+	return context.WithValue(ctx, loggerKey{}, entry)
+}
+
+// LoggerFromContext returns the log entry carried by ctx, or an entry of
+// fallback when there is none
+func LoggerFromContext(ctx context.Context, fallback *logrus.Logger) *logrus.Entry {
+	if entry, ok := ctx.Value(loggerKey{}).(*logrus.Entry); ok {
+		return entry
+	}
+	return logrus.NewEntry(fallback)
+}
@@ -0,0 +1,20 @@
+package services
+
+import (
+	"context"
+	"testing"
+
+	"github.com/sirupsen/logrus"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestLoggerFromContext(t *testing.T) {
+	logger := logrus.New()
+
+	entry := LoggerFromContext(context.Background(), logger)
+	assert.Same(t, logger, entry.Logger)
+	assert.Empty(t, entry.Data)
+
+	ctx := ContextWithLogger(context.Background(), logger.WithField("request_id", "abc"))
+	assert.Equal(t, "abc", LoggerFromContext(ctx, logger).Data["request_id"])
+}
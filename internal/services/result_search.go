@@ -0,0 +1,143 @@
+package services
+
+import (
+	"fmt"
+	"regexp"
+	"strings"
+	"time"
+
+	"github.com/mussietl/csv-sales-api/internal/models"
+)
+
+// Limits on the tags of one upload
+const (
+	MaxTags      = 10
+	MaxTagLength = 32
+)
+
+// tagPattern is the form of a tag, once lowercased
+var tagPattern = regexp.MustCompile(`^[a-z0-9][a-z0-9._:-]*$`)
+
+// ParseTags parses a comma-separated list of tags. Tags are lowercased and
+// duplicates dropped.
+func ParseTags(value string) ([]string, error) {
+	var tags []string
+	seen := make(map[string]bool)
+	for _, tag := range strings.Split(value, ",") {
+		tag = strings.ToLower(strings.TrimSpace(tag))
+		if tag == "" || seen[tag] {
+			continue
+		}
+		if len(tag) > MaxTagLength || !tagPattern.MatchString(tag) {
+			return nil, fmt.Errorf("invalid tag %q: tags are up to %d letters, digits, '.', '_', ':' or '-'", tag, MaxTagLength)
+		}
+		seen[tag] = true
+		tags = append(tags, tag)
+	}
+	if len(tags) > MaxTags {
+		return nil, fmt.Errorf("at most %d tags are allowed", MaxTags)
+	}
+	return tags, nil
+}
+
+// ResultSearch filters the completed uploads of a tenant. Zero fields don't
+// filter.
+type ResultSearch struct {
+	// Tags must all be on the upload
+	Tags []string
+	// Department is matched case-insensitively anywhere in department names
+	Department string
+	// From and To bound the time the upload was received, inclusively
+	From, To time.Time
+	// MinTotal is the lowest total sales of a matching department or, without
+	// Department, of the whole upload
+	MinTotal *models.Amount
+}
+
+// ResultMatch is an upload found by a search, with the departments that
+// matched when the search named one
+type ResultMatch struct {
+	HistoryRecord
+	MatchedDepartments []DepartmentSummary `json:"matched_departments,omitempty"`
+}
+
+// Search returns a tenant's completed uploads matching search, newest
+// first, skipping offset matches and returning at most limit. more reports
+// whether further matches follow. Tags and dates are filtered by the
+// database; departments and totals, which are stored as JSON and text, are
+// compared here.
+func (hr *HistoryRepository) Search(tenant string, search ResultSearch, limit, offset int) (matches []ResultMatch, more bool, err error) {
+	query := `SELECT ` + historyColumns + ` FROM upload_history WHERE tenant = $1 AND status = $2 AND result_id <> ''`
+	args := []any{tenant, HistoryCompleted}
+	where := func(condition string, arg any) {
+		args = append(args, arg)
+		query += fmt.Sprintf(" AND "+condition, len(args))
+	}
+	for _, tag := range search.Tags {
+		where(`tags LIKE $%d ESCAPE '\'`, `%"`+escapeLike(tag)+`"%`)
+	}
+	if !search.From.IsZero() {
+		where("received_at >= $%d", formatHistoryTime(search.From))
+	}
+	if !search.To.IsZero() {
+		where("received_at <= $%d", formatHistoryTime(search.To))
+	}
+	query += " ORDER BY received_at DESC, upload_id"
+
+	rows, err := hr.db.Query(query, args...)
+	if err != nil {
+		return nil, false, fmt.Errorf("failed to search history: %w", err)
+	}
+	defer rows.Close()
+
+	matches = []ResultMatch{}
+	for rows.Next() {
+		record, err := scanHistory(rows)
+		if err != nil {
+			return nil, false, err
+		}
+		match, ok := search.match(record)
+		if !ok {
+			continue
+		}
+		if offset > 0 {
+			offset--
+			continue
+		}
+		if len(matches) == limit {
+			return matches, true, nil
+		}
+		matches = append(matches, match)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, false, fmt.Errorf("failed to search history: %w", err)
+	}
+	return matches, false, nil
+}
+
+// match applies the department and total filters to a record
+func (search ResultSearch) match(record HistoryRecord) (ResultMatch, bool) {
+	match := ResultMatch{HistoryRecord: record}
+	if search.Department == "" {
+		if search.MinTotal != nil && (record.TotalSales == nil || record.TotalSales.Cmp(*search.MinTotal) < 0) {
+			return match, false
+		}
+		return match, true
+	}
+	needle := strings.ToLower(search.Department)
+	for _, department := range record.Departments {
+		if !strings.Contains(strings.ToLower(department.Department), needle) {
+			continue
+		}
+		if search.MinTotal != nil && department.TotalSales.Cmp(*search.MinTotal) < 0 {
+			continue
+		}
+		match.MatchedDepartments = append(match.MatchedDepartments, department)
+	}
+	return match, len(match.MatchedDepartments) > 0
+}
+
+// escapeLike escapes the wildcards of a LIKE pattern, for ESCAPE '\'
+func escapeLike(s string) string {
+	return strings.NewReplacer(`\`, `\\`, `%`, `\%`, `_`, `\_`).Replace(s)
+}
@@ -0,0 +1,295 @@
+package services
+
+import (
+	"archive/zip"
+	"bufio"
+	"encoding/json"
+	"encoding/xml"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+// Result output formats
+const (
+	// OutputFormatCSV writes the result as CSV, the default
+	OutputFormatCSV = "csv"
+	// OutputFormatJSON writes the result as a JSON array of row objects
+	OutputFormatJSON = "json"
+	// OutputFormatNDJSON writes the result as one JSON object per line
+	OutputFormatNDJSON = "ndjson"
+	// OutputFormatXLSX writes the result as an Excel workbook with one sheet
+	OutputFormatXLSX = "xlsx"
+	// OutputFormatIntegrationJSON writes the result in the integration schema
+	OutputFormatIntegrationJSON = "integration-json"
+)
+
+// ValidateOutputFormat checks that format is a known result output format
+func ValidateOutputFormat(format string) error {
+	switch format {
+	case "", OutputFormatCSV, OutputFormatJSON, OutputFormatNDJSON, OutputFormatXLSX, OutputFormatIntegrationJSON:
+		return nil
+	}
+	return fmt.Errorf("invalid output format %q: expected csv, json, ndjson, xlsx or integration-json", format)
+}
+
+// ResultTable is the content of a result file whatever its format: named
+// columns and rows of formatted values
+type ResultTable struct {
+	Columns []ResultColumn
+	Rows    [][]string
+}
+
+// ResultColumn is a column of a result table. Numeric columns hold decimal
+// strings, which formats with typed values write as numbers.
+type ResultColumn struct {
+	Name    string
+	Numeric bool
+}
+
+// ResultWriter writes result tables in one output format
+type ResultWriter interface {
+	// Extension is the file extension of the format, e.g. ".csv"
+	Extension() string
+	// Write writes table to w
+	Write(w io.Writer, table *ResultTable) error
+}
+
+// NewResultWriter returns the writer of a tabular output format; "" selects
+// CSV. The integration schema is not a table and is written by
+// SaveIntegrationResultFile instead.
+func NewResultWriter(format string) (ResultWriter, error) {
+	switch format {
+	case "", OutputFormatCSV:
+		return csvResultWriter{}, nil
+	case OutputFormatJSON:
+		return jsonResultWriter{}, nil
+	case OutputFormatNDJSON:
+		return jsonResultWriter{lines: true}, nil
+	case OutputFormatXLSX:
+		return xlsxResultWriter{}, nil
+	}
+	return nil, fmt.Errorf("invalid output format %q: expected csv, json, ndjson or xlsx", format)
+}
+
+// csvResultWriter writes a header line and one line per row, quoting fields
+// only where needed
+type csvResultWriter struct{}
+
+func (csvResultWriter) Extension() string { return ".csv" }
+
+func (csvResultWriter) Write(w io.Writer, table *ResultTable) error {
+	buffered := bufio.NewWriter(w)
+	header := make([]string, len(table.Columns))
+	for i, column := range table.Columns {
+		header[i] = quoteCSVField(column.Name)
+	}
+	buffered.WriteString(strings.Join(header, ",") + "\n")
+	fields := make([]string, len(table.Columns))
+	for _, row := range table.Rows {
+		for i, value := range row {
+			fields[i] = quoteCSVField(value)
+		}
+		buffered.WriteString(strings.Join(fields, ",") + "\n")
+	}
+	return buffered.Flush()
+}
+
+// jsonResultWriter writes each row as an object keyed by column name, in
+// column order; as a JSON array, or one object per line for NDJSON
+type jsonResultWriter struct {
+	lines bool
+}
+
+func (jw jsonResultWriter) Extension() string {
+	if jw.lines {
+		return ".ndjson"
+	}
+	return ".json"
+}
+
+func (jw jsonResultWriter) Write(w io.Writer, table *ResultTable) error {
+	buffered := bufio.NewWriter(w)
+	if !jw.lines {
+		buffered.WriteString("[")
+	}
+	for i, row := range table.Rows {
+		if !jw.lines && i > 0 {
+			buffered.WriteString(",")
+		}
+		if !jw.lines {
+			buffered.WriteString("\n  ")
+		}
+		if err := writeJSONRow(buffered, table.Columns, row); err != nil {
+			return err
+		}
+		if jw.lines {
+			buffered.WriteString("\n")
+		}
+	}
+	if !jw.lines {
+		if len(table.Rows) > 0 {
+			buffered.WriteString("\n")
+		}
+		buffered.WriteString("]\n")
+	}
+	return buffered.Flush()
+}
+
+// writeJSONRow writes a row as a JSON object. Numeric values are written as
+// numbers, exactly as formatted; empty ones as null.
+func writeJSONRow(w *bufio.Writer, columns []ResultColumn, row []string) error {
+	w.WriteString("{")
+	for i, column := range columns {
+		if i > 0 {
+			w.WriteString(",")
+		}
+		key, err := json.Marshal(column.Name)
+		if err != nil {
+			return err
+		}
+		w.Write(key)
+		w.WriteString(":")
+		switch {
+		case column.Numeric && row[i] == "":
+			w.WriteString("null")
+		case column.Numeric:
+			w.WriteString(row[i])
+		default:
+			value, err := json.Marshal(row[i])
+			if err != nil {
+				return err
+			}
+			w.Write(value)
+		}
+	}
+	w.WriteString("}")
+	return nil
+}
+
+// xlsxResultWriter writes a workbook with a single "Result" sheet. Text is
+// written as inline strings, so no shared string table is needed.
+type xlsxResultWriter struct{}
+
+func (xlsxResultWriter) Extension() string { return ".xlsx" }
+
+// xlsxPackageParts are the fixed parts of a single-sheet workbook
+var xlsxPackageParts = []struct{ name, content string }{
+	{"[Content_Types].xml", xml.Header + `<Types xmlns="http://schemas.openxmlformats.org/package/2006/content-types">` +
+		`<Default Extension="rels" ContentType="application/vnd.openxmlformats-package.relationships+xml"/>` +
+		`<Default Extension="xml" ContentType="application/xml"/>` +
+		`<Override PartName="/xl/workbook.xml" ContentType="application/vnd.openxmlformats-officedocument.spreadsheetml.sheet.main+xml"/>` +
+		`<Override PartName="/xl/worksheets/sheet1.xml" ContentType="application/vnd.openxmlformats-officedocument.spreadsheetml.worksheet+xml"/>` +
+		`</Types>`},
+	{"_rels/.rels", xml.Header + `<Relationships xmlns="http://schemas.openxmlformats.org/package/2006/relationships">` +
+		`<Relationship Id="rId1" Type="http://schemas.openxmlformats.org/officeDocument/2006/relationships/officeDocument" Target="xl/workbook.xml"/>` +
+		`</Relationships>`},
+	{"xl/workbook.xml", xml.Header + `<workbook xmlns="http://schemas.openxmlformats.org/spreadsheetml/2006/main" xmlns:r="http://schemas.openxmlformats.org/officeDocument/2006/relationships">` +
+		`<sheets><sheet name="Result" sheetId="1" r:id="rId1"/></sheets></workbook>`},
+	{"xl/_rels/workbook.xml.rels", xml.Header + `<Relationships xmlns="http://schemas.openxmlformats.org/package/2006/relationships">` +
+		`<Relationship Id="rId1" Type="http://schemas.openxmlformats.org/officeDocument/2006/relationships/worksheet" Target="worksheets/sheet1.xml"/>` +
+		`</Relationships>`},
+}
+
+func (xlsxResultWriter) Write(w io.Writer, table *ResultTable) error {
+	archive := zip.NewWriter(w)
+	for _, part := range xlsxPackageParts {
+		pw, err := archive.Create(part.name)
+		if err != nil {
+			return err
+		}
+		if _, err := io.WriteString(pw, part.content); err != nil {
+			return err
+		}
+	}
+
+	pw, err := archive.Create("xl/worksheets/sheet1.xml")
+	if err != nil {
+		return err
+	}
+	sheet := bufio.NewWriter(pw)
+	sheet.WriteString(xml.Header + `<worksheet xmlns="http://schemas.openxmlformats.org/spreadsheetml/2006/main"><sheetData>`)
+	header := make([]string, len(table.Columns))
+	text := make([]ResultColumn, len(table.Columns))
+	for i, column := range table.Columns {
+		header[i] = column.Name
+		text[i] = ResultColumn{Name: column.Name}
+	}
+	writeXLSXRow(sheet, 1, text, header)
+	for i, row := range table.Rows {
+		writeXLSXRow(sheet, i+2, table.Columns, row)
+	}
+	sheet.WriteString(`</sheetData></worksheet>`)
+	if err := sheet.Flush(); err != nil {
+		return err
+	}
+	return archive.Close()
+}
+
+// writeXLSXRow writes a worksheet row; empty cells are left out
+func writeXLSXRow(w *bufio.Writer, number int, columns []ResultColumn, row []string) {
+	fmt.Fprintf(w, `<row r="%d">`, number)
+	for i, value := range row {
+		if value == "" {
+			continue
+		}
+		ref := fmt.Sprintf("%s%d", xlsxColumnName(i), number)
+		if columns[i].Numeric {
+			fmt.Fprintf(w, `<c r="%s"><v>%s</v></c>`, ref, value)
+			continue
+		}
+		fmt.Fprintf(w, `<c r="%s" t="inlineStr"><is><t xml:space="preserve">`, ref)
+		xml.EscapeText(w, []byte(value))
+		w.WriteString(`</t></is></c>`)
+	}
+	w.WriteString(`</row>`)
+}
+
+// xlsxColumnName returns the letters of a zero-based column index, e.g. 27 is "AB"
+func xlsxColumnName(index int) string {
+	name := ""
+	for index++; index > 0; index = (index - 1) / 26 {
+		name = string(rune('A'+(index-1)%26)) + name
+	}
+	return name
+}
+
+// saveResultTable writes a result table to result_<uuid> with the output
+// format's extension and saves it to the storage backend
+func (fs *FileService) saveResultTable(table *ResultTable, output string) (string, error) {
+	writer, err := NewResultWriter(output)
+	if err != nil {
+		return "", err
+	}
+	uniqueID, err := fs.newID()
+	if err != nil {
+		return "", err
+	}
+	filePath := filepath.Join(fs.uploadsDir, resultFileName(uniqueID, writer.Extension()))
+
+	file, err := os.Create(filePath)
+	if err != nil {
+		fs.logger.Errorf("Failed to create result file: %v", err)
+		return "", fmt.Errorf("failed to create result file: %w", err)
+	}
+	err = writer.Write(file, table)
+	if closeErr := file.Close(); err == nil {
+		err = closeErr
+	}
+	if err != nil {
+		fs.logger.Errorf("Failed to write result file: %v", err)
+		os.Remove(filePath)
+		return "", fmt.Errorf("failed to write result file: %w", err)
+	}
+
+	if err := fs.Persist(filePath); err != nil {
+		fs.logger.Errorf("Failed to store result file: %v", err)
+		os.Remove(filePath)
+		return "", err
+	}
+
+	fs.logger.Infof("Result file saved successfully: %s", filePath)
+	return filePath, nil
+}
@@ -0,0 +1,108 @@
+package services
+
+import (
+	"bytes"
+	"encoding/json"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/mussietl/csv-sales-api/internal/models"
+	"github.com/sirupsen/logrus"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+var writerTable = &ResultTable{
+	Columns: []ResultColumn{{Name: "Department Name"}, {Name: "Total Sales Amount", Numeric: true}, {Name: "Distinct Store", Numeric: true}},
+	Rows: [][]string{
+		{"Books, Used", "10.50", "2"},
+		{`Toys "R"`, "-3", ""},
+	},
+}
+
+func TestResultWriters(t *testing.T) {
+	cases := map[string]string{
+		OutputFormatCSV: "Department Name,Total Sales Amount,Distinct Store\n" +
+			"\"Books, Used\",10.50,2\n" +
+			"\"Toys \"\"R\"\"\",-3,\n",
+		OutputFormatJSON: "[\n" +
+			`  {"Department Name":"Books, Used","Total Sales Amount":10.50,"Distinct Store":2},` + "\n" +
+			`  {"Department Name":"Toys \"R\"","Total Sales Amount":-3,"Distinct Store":null}` + "\n" +
+			"]\n",
+		OutputFormatNDJSON: `{"Department Name":"Books, Used","Total Sales Amount":10.50,"Distinct Store":2}` + "\n" +
+			`{"Department Name":"Toys \"R\"","Total Sales Amount":-3,"Distinct Store":null}` + "\n",
+	}
+	for format, want := range cases {
+		writer, err := NewResultWriter(format)
+		require.NoError(t, err)
+		var buf bytes.Buffer
+		require.NoError(t, writer.Write(&buf, writerTable))
+		assert.Equal(t, want, buf.String(), format)
+		if format == OutputFormatJSON {
+			assert.True(t, json.Valid(buf.Bytes()))
+		}
+		if format == OutputFormatNDJSON {
+			for _, line := range strings.Split(strings.TrimSuffix(buf.String(), "\n"), "\n") {
+				assert.True(t, json.Valid([]byte(line)), line)
+			}
+		}
+	}
+
+	var buf bytes.Buffer
+	writer, _ := NewResultWriter(OutputFormatJSON)
+	require.NoError(t, writer.Write(&buf, &ResultTable{Columns: writerTable.Columns}))
+	assert.Equal(t, "[]\n", buf.String())
+
+	_, err := NewResultWriter(OutputFormatIntegrationJSON)
+	assert.Error(t, err)
+	assert.NoError(t, ValidateOutputFormat(OutputFormatXLSX))
+	assert.Error(t, ValidateOutputFormat("parquet"))
+}
+
+func TestXLSXResultWriter(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "result.xlsx")
+	file, err := os.Create(path)
+	require.NoError(t, err)
+	require.NoError(t, xlsxResultWriter{}.Write(file, writerTable))
+	require.NoError(t, file.Close())
+
+	// The workbook reads back through the upload converter
+	var csv bytes.Buffer
+	_, err = convertXLSX(path, SheetsFirst, &csv)
+	require.NoError(t, err)
+	assert.Equal(t, "Department Name,Total Sales Amount,Distinct Store\n\"Books, Used\",10.50,2\n\"Toys \"\"R\"\"\",-3,\n", csv.String())
+
+	for index, name := range map[int]string{0: "A", 25: "Z", 26: "AA", 27: "AB", 701: "ZZ", 702: "AAA"} {
+		assert.Equal(t, name, xlsxColumnName(index))
+		parsed, ok := cellColumnIndex(name + "1")
+		require.True(t, ok)
+		assert.Equal(t, index, parsed)
+	}
+}
+
+func TestSaveResultFileFormats(t *testing.T) {
+	logger := logrus.New()
+	logger.SetLevel(logrus.ErrorLevel)
+	fs := NewFileService(t.TempDir(), logger)
+	summaries := []DepartmentSummary{{Department: "Toys", TotalSales: models.WholeAmount(2500), SalesCount: 3}}
+
+	for format, ext := range map[string]string{OutputFormatCSV: ".csv", OutputFormatJSON: ".json", OutputFormatNDJSON: ".ndjson", OutputFormatXLSX: ".xlsx"} {
+		path, err := fs.SaveFormattedResultFile(summaries, DefaultNumberFormat, MetricBoth, nil, format)
+		require.NoError(t, err, format)
+		assert.Equal(t, ext, filepath.Ext(path))
+		resolved, err := fs.ResultPath(fs.ResultID(path))
+		require.NoError(t, err)
+		assert.Equal(t, path, resolved)
+	}
+
+	path, err := fs.SaveFormattedResultFile(summaries, DefaultNumberFormat, MetricBoth, nil, OutputFormatNDJSON)
+	require.NoError(t, err)
+	data, err := os.ReadFile(path)
+	require.NoError(t, err)
+	assert.Equal(t, `{"Department Name":"Toys","Sales Count":3,"Total Sales Amount":2500}`+"\n", string(data))
+
+	_, err = fs.SaveFormattedResultFile(summaries, DefaultNumberFormat, MetricBoth, nil, "parquet")
+	assert.Error(t, err)
+}
@@ -0,0 +1,145 @@
+package services
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+
+	"github.com/mussietl/csv-sales-api/internal/models"
+)
+
+// Rounding modes for output totals
+const (
+	// RoundHalfUp rounds halves away from zero, as most ledgers do
+	RoundHalfUp = "half_up"
+	// RoundHalfEven rounds halves to the nearest even digit (bankers' rounding)
+	RoundHalfEven = "half_even"
+)
+
+// MaxPrecision is the largest number of decimal places an output may request
+const MaxPrecision = 10
+
+// AutoPrecision writes amounts exactly, with as many decimal places as they
+// have; longer values such as averages are rounded to models.AmountDecimals
+const AutoPrecision = -1
+
+// NumberFormat controls how totals are written to result files
+type NumberFormat struct {
+	// Precision is the number of decimal places written, or AutoPrecision
+	Precision int
+	// Rounding is RoundHalfUp or RoundHalfEven
+	Rounding string
+}
+
+// DefaultNumberFormat writes amounts exactly, rounding halves up where needed
+var DefaultNumberFormat = NumberFormat{Precision: AutoPrecision, Rounding: RoundHalfUp}
+
+// ParseNumberFormat builds a NumberFormat from precision and rounding
+// parameters; empty values keep the defaults. "bankers" is accepted as an
+// alias of half_even and "half-up"/"half-even" spellings are accepted too.
+func ParseNumberFormat(precision, rounding string) (NumberFormat, error) {
+	format := DefaultNumberFormat
+	if precision != "" {
+		p, err := strconv.Atoi(precision)
+		if err != nil || p < 0 || p > MaxPrecision {
+			return format, fmt.Errorf("precision must be an integer between 0 and %d", MaxPrecision)
+		}
+		format.Precision = p
+	}
+	switch strings.ReplaceAll(strings.ToLower(rounding), "-", "_") {
+	case "", RoundHalfUp:
+	case RoundHalfEven, "bankers":
+		format.Rounding = RoundHalfEven
+	default:
+		return format, fmt.Errorf("unknown rounding %q: expected half_up or bankers", rounding)
+	}
+	return format, nil
+}
+
+// FormatAmount writes a total with the format's decimal places
+func (f NumberFormat) FormatAmount(value models.Amount) string {
+	formatted, _ := f.FormatDecimal(value.String())
+	return formatted
+}
+
+// FormatDecimal rounds a decimal string such as "-12.345" to the format's
+// precision. Rounding works on the decimal digits, so it is exact and does
+// not suffer from binary floating-point error.
+func (f NumberFormat) FormatDecimal(value string) (string, error) {
+	negative := strings.HasPrefix(value, "-")
+	digits := strings.TrimPrefix(strings.TrimPrefix(value, "-"), "+")
+	intPart, fracPart, _ := strings.Cut(digits, ".")
+	if intPart == "" {
+		intPart = "0"
+	}
+	if !isDigits(intPart) || !isDigits(fracPart) {
+		return "", fmt.Errorf("invalid decimal %q", value)
+	}
+	if f.Precision == AutoPrecision {
+		f.Precision = min(len(strings.TrimRight(fracPart, "0")), models.AmountDecimals)
+	}
+
+	if len(fracPart) <= f.Precision {
+		fracPart += strings.Repeat("0", f.Precision-len(fracPart))
+	} else {
+		kept, dropped := fracPart[:f.Precision], fracPart[f.Precision:]
+		number := []byte(intPart + kept)
+		if f.roundsUp(number[len(number)-1], dropped) {
+			number = incrementDigits(number)
+		}
+		intPart = string(number[:len(number)-len(kept)])
+		fracPart = string(number[len(number)-len(kept):])
+	}
+
+	intPart = strings.TrimLeft(intPart, "0")
+	if intPart == "" {
+		intPart = "0"
+	}
+	result := intPart
+	if f.Precision > 0 {
+		result += "." + fracPart
+	}
+	if negative && strings.Trim(result, "0.") != "" {
+		result = "-" + result
+	}
+	return result, nil
+}
+
+// roundsUp decides whether dropping digits rounds the magnitude up, given
+// the last kept digit
+func (f NumberFormat) roundsUp(last byte, dropped string) bool {
+	switch {
+	case dropped[0] > '5':
+		return true
+	case dropped[0] < '5':
+		return false
+	case strings.Trim(dropped[1:], "0") != "":
+		return true
+	case f.Rounding == RoundHalfEven:
+		return (last-'0')%2 == 1
+	default:
+		return true
+	}
+}
+
+// incrementDigits adds one to a string of decimal digits
+func incrementDigits(number []byte) []byte {
+	for i := len(number) - 1; i >= 0; i-- {
+		if number[i] < '9' {
+			number[i]++
+			return number
+		}
+		number[i] = '0'
+	}
+	return append([]byte{'1'}, number...)
+}
+
+// isDigits reports whether s consists only of ASCII digits
+func isDigits(s string) bool {
+	for i := 0; i < len(s); i++ {
+		if s[i] < '0' || s[i] > '9' {
+			return false
+		}
+	}
+	return true
+}
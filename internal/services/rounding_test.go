@@ -0,0 +1,70 @@
+package services
+
+import (
+	"github.com/mussietl/csv-sales-api/internal/models"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestNumberFormatFormatDecimal(t *testing.T) {
+	halfUp := NumberFormat{Precision: 2, Rounding: RoundHalfUp}
+	bankers := NumberFormat{Precision: 2, Rounding: RoundHalfEven}
+
+	cases := []struct {
+		value   string
+		halfUp  string
+		bankers string
+	}{
+		{"1.005", "1.01", "1.00"},
+		{"1.015", "1.02", "1.02"},
+		{"1.0051", "1.01", "1.01"},
+		{"2.675", "2.68", "2.68"},
+		{"9.995", "10.00", "10.00"},
+		{"-1.005", "-1.01", "-1.00"},
+		{"-0.001", "0.00", "0.00"},
+		{"7", "7.00", "7.00"},
+		{".5", "0.50", "0.50"},
+	}
+	for _, tc := range cases {
+		got, err := halfUp.FormatDecimal(tc.value)
+		require.NoError(t, err, tc.value)
+		assert.Equal(t, tc.halfUp, got, "half_up %s", tc.value)
+		got, err = bankers.FormatDecimal(tc.value)
+		require.NoError(t, err, tc.value)
+		assert.Equal(t, tc.bankers, got, "bankers %s", tc.value)
+	}
+
+	whole := NumberFormat{Rounding: RoundHalfEven}
+	for value, want := range map[string]string{"2.5": "2", "3.5": "4", "0.5": "0", "99.5": "100"} {
+		got, err := whole.FormatDecimal(value)
+		require.NoError(t, err)
+		assert.Equal(t, want, got, value)
+	}
+
+	_, err := halfUp.FormatDecimal("1.2.3")
+	assert.Error(t, err)
+	assert.Equal(t, "1500.000", NumberFormat{Precision: 3}.FormatAmount(models.WholeAmount(1500)))
+}
+
+func TestParseNumberFormat(t *testing.T) {
+	format, err := ParseNumberFormat("", "")
+	require.NoError(t, err)
+	assert.Equal(t, DefaultNumberFormat, format)
+
+	format, err = ParseNumberFormat("2", "bankers")
+	require.NoError(t, err)
+	assert.Equal(t, NumberFormat{Precision: 2, Rounding: RoundHalfEven}, format)
+
+	format, err = ParseNumberFormat("4", "half-up")
+	require.NoError(t, err)
+	assert.Equal(t, NumberFormat{Precision: 4, Rounding: RoundHalfUp}, format)
+
+	_, err = ParseNumberFormat("-1", "")
+	assert.Error(t, err)
+	_, err = ParseNumberFormat("11", "")
+	assert.Error(t, err)
+	_, err = ParseNumberFormat("2", "ceiling")
+	assert.Error(t, err)
+}
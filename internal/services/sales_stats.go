@@ -0,0 +1,228 @@
+package services
+
+import (
+	"math"
+	"sort"
+	"strconv"
+
+	"github.com/mussietl/csv-sales-api/internal/models"
+)
+
+// tDigestCompression bounds a digest to a few hundred centroids; quantile
+// estimates are typically within 0.1% of the rank near the tails
+const tDigestCompression = 100
+
+// salesStats accumulates the spread of one department's sale amounts in
+// constant memory: exact sum, extremes and variance, and a t-digest for the
+// median and percentiles
+type salesStats struct {
+	count int
+	sum   models.Amount
+	min   models.Amount
+	max   models.Amount
+	// mean and m2 track the variance with Welford's algorithm
+	mean   float64
+	m2     float64
+	digest *tDigest
+}
+
+func newSalesStats() *salesStats {
+	return &salesStats{digest: newTDigest(tDigestCompression)}
+}
+
+// add counts one sale
+func (s *salesStats) add(sales models.Amount) {
+	if s.count == 0 || sales.Cmp(s.min) < 0 {
+		s.min = sales
+	}
+	if s.count == 0 || sales.Cmp(s.max) > 0 {
+		s.max = sales
+	}
+	s.count++
+	s.sum = s.sum.Add(sales)
+
+	value := sales.Float64()
+	delta := value - s.mean
+	s.mean += delta / float64(s.count)
+	s.m2 += delta * (value - s.mean)
+	s.digest.add(value, 1)
+}
+
+// merge adds the sales of the same department counted elsewhere
+func (s *salesStats) merge(other *salesStats) {
+	if other.count == 0 {
+		return
+	}
+	if s.count == 0 || other.min.Cmp(s.min) < 0 {
+		s.min = other.min
+	}
+	if s.count == 0 || other.max.Cmp(s.max) > 0 {
+		s.max = other.max
+	}
+	total := float64(s.count + other.count)
+	delta := other.mean - s.mean
+	s.m2 += other.m2 + delta*delta*float64(s.count)*float64(other.count)/total
+	s.mean += delta * float64(other.count) / total
+	s.count += other.count
+	s.sum = s.sum.Add(other.sum)
+	s.digest.merge(other.digest)
+}
+
+// result returns the statistics. The standard deviation is that of a
+// sample, and 0 for a single sale.
+func (s *salesStats) result() *models.SalesStats {
+	avg, _ := models.ParseAmount(averageDecimal(s.sum, s.count))
+	var stddev float64
+	if s.count > 1 {
+		stddev = math.Sqrt(s.m2 / float64(s.count-1))
+	}
+	return &models.SalesStats{
+		Avg:    avg,
+		Median: floatAmount(s.digest.quantile(0.5)),
+		P90:    floatAmount(s.digest.quantile(0.9)),
+		P95:    floatAmount(s.digest.quantile(0.95)),
+		Min:    s.min,
+		Max:    s.max,
+		StdDev: floatAmount(stddev),
+	}
+}
+
+// floatAmount rounds an estimate to an Amount
+func floatAmount(value float64) models.Amount {
+	amount, _ := models.ParseAmount(strconv.FormatFloat(value, 'f', models.AmountDecimals, 64))
+	return amount
+}
+
+// centroid is a cluster of values of a t-digest
+type centroid struct {
+	mean   float64
+	weight float64
+}
+
+// tDigest is a merging t-digest (Dunning): values are buffered and
+// periodically merged into centroids that are small near the tails and
+// larger around the median, so quantiles are estimated in bounded memory
+// and digests of separate parts of a file can be combined
+type tDigest struct {
+	compression float64
+	centroids   []centroid
+	buffer      []centroid
+	weight      float64
+	min         float64
+	max         float64
+}
+
+func newTDigest(compression float64) *tDigest {
+	return &tDigest{compression: compression}
+}
+
+// add adds a value with the given weight
+func (d *tDigest) add(value, weight float64) {
+	if d.weight == 0 || value < d.min {
+		d.min = value
+	}
+	if d.weight == 0 || value > d.max {
+		d.max = value
+	}
+	d.weight += weight
+	d.buffer = append(d.buffer, centroid{mean: value, weight: weight})
+	if len(d.buffer) >= 5*int(d.compression) {
+		d.compress()
+	}
+}
+
+// merge adds the values of another digest
+func (d *tDigest) merge(other *tDigest) {
+	if other.weight == 0 {
+		return
+	}
+	if d.weight == 0 || other.min < d.min {
+		d.min = other.min
+	}
+	if d.weight == 0 || other.max > d.max {
+		d.max = other.max
+	}
+	d.weight += other.weight
+	d.buffer = append(d.buffer, other.centroids...)
+	d.buffer = append(d.buffer, other.buffer...)
+	d.compress()
+}
+
+// compress merges the buffered values into the centroids, combining
+// neighbours as long as a centroid spans at most one unit of the k1 scale
+func (d *tDigest) compress() {
+	if len(d.buffer) == 0 {
+		return
+	}
+	all := append(d.centroids, d.buffer...)
+	sort.Slice(all, func(i, j int) bool { return all[i].mean < all[j].mean })
+
+	merged := make([]centroid, 0, len(d.centroids)+1)
+	current := all[0]
+	var before float64
+	limit := d.weight * d.kInverse(d.k(0)+1)
+	for _, next := range all[1:] {
+		if before+current.weight+next.weight <= limit {
+			current.weight += next.weight
+			current.mean += (next.mean - current.mean) * next.weight / current.weight
+			continue
+		}
+		before += current.weight
+		merged = append(merged, current)
+		current = next
+		limit = d.weight * d.kInverse(d.k(before/d.weight)+1)
+	}
+	d.centroids = append(merged, current)
+	d.buffer = nil
+}
+
+// k is the k1 scale function mapping a quantile to centroid units
+func (d *tDigest) k(q float64) float64 {
+	return d.compression / (2 * math.Pi) * math.Asin(2*q-1)
+}
+
+// kInverse maps centroid units back to a quantile
+func (d *tDigest) kInverse(k float64) float64 {
+	if k >= d.compression/4 {
+		return 1
+	}
+	return (math.Sin(k*2*math.Pi/d.compression) + 1) / 2
+}
+
+// quantile estimates the value at quantile q, interpolating between the
+// centres of neighbouring centroids
+func (d *tDigest) quantile(q float64) float64 {
+	d.compress()
+	if len(d.centroids) == 0 {
+		return 0
+	}
+	if len(d.centroids) == 1 {
+		return d.centroids[0].mean
+	}
+
+	rank := q * d.weight
+	first, last := d.centroids[0], d.centroids[len(d.centroids)-1]
+	if rank < first.weight/2 {
+		if first.weight == 1 {
+			return d.min
+		}
+		return d.min + (first.mean-d.min)*rank/(first.weight/2)
+	}
+	if rank > d.weight-last.weight/2 {
+		if last.weight == 1 {
+			return d.max
+		}
+		return d.max - (d.max-last.mean)*(d.weight-rank)/(last.weight/2)
+	}
+
+	center := first.weight / 2
+	for i := 0; i < len(d.centroids)-1; i++ {
+		left, right := d.centroids[i], d.centroids[i+1]
+		gap := (left.weight + right.weight) / 2
+		if rank <= center+gap {
+			return left.mean + (right.mean-left.mean)*(rank-center)/gap
+		}
+		center += gap
+	}
+	return last.mean
+}
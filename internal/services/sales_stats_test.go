@@ -0,0 +1,119 @@
+package services
+
+import (
+	"fmt"
+	"math/rand"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"testing"
+
+	"github.com/mussietl/csv-sales-api/internal/models"
+	"github.com/sirupsen/logrus"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestTDigestQuantiles(t *testing.T) {
+	rng := rand.New(rand.NewSource(1))
+	values := make([]float64, 100000)
+	whole, halves := newTDigest(tDigestCompression), [2]*tDigest{newTDigest(tDigestCompression), newTDigest(tDigestCompression)}
+	for i := range values {
+		values[i] = rng.ExpFloat64() * 100
+		whole.add(values[i], 1)
+		halves[i%2].add(values[i], 1)
+	}
+	halves[0].merge(halves[1])
+	sort.Float64s(values)
+
+	// Estimates are compared by rank, the fraction of values below them
+	rank := func(value float64) float64 {
+		return float64(sort.SearchFloat64s(values, value)) / float64(len(values))
+	}
+	for _, q := range []float64{0.01, 0.5, 0.9, 0.95, 0.99} {
+		assert.InDelta(t, q, rank(whole.quantile(q)), 0.002, "q=%v", q)
+		assert.InDelta(t, q, rank(halves[0].quantile(q)), 0.002, "merged q=%v", q)
+	}
+	assert.Equal(t, values[0], whole.quantile(0))
+	assert.Equal(t, values[len(values)-1], whole.quantile(1))
+	assert.Less(t, len(whole.centroids), 5*tDigestCompression)
+}
+
+func TestSalesStats(t *testing.T) {
+	stats := newSalesStats()
+	for _, sales := range []string{"10", "20", "30", "40"} {
+		amount, err := models.ParseAmount(sales)
+		require.NoError(t, err)
+		stats.add(amount)
+	}
+	result := stats.result()
+	assert.Equal(t, "25", result.Avg.String())
+	assert.Equal(t, "25", result.Median.String())
+	assert.Equal(t, "10", result.Min.String())
+	assert.Equal(t, "40", result.Max.String())
+	assert.Equal(t, "40", result.P95.String())
+	assert.Equal(t, "12.9099", result.StdDev.String())
+
+	single := newSalesStats()
+	single.add(models.WholeAmount(7))
+	result = single.result()
+	assert.Equal(t, "7", result.Median.String())
+	assert.Equal(t, "7", result.P90.String())
+	assert.True(t, result.StdDev.IsZero())
+}
+
+func TestCSVServiceProcessStats(t *testing.T) {
+	logger := logrus.New()
+	logger.SetLevel(logrus.ErrorLevel)
+	cs := NewCSVService(logger)
+
+	var buf strings.Builder
+	buf.WriteString("Department Name,Number of Sales\n")
+	for i := 1; i <= 5000; i++ {
+		fmt.Fprintf(&buf, "Dept %d,%d.5\n", i%3, i)
+	}
+	path := filepath.Join(t.TempDir(), "sales.csv")
+	require.NoError(t, os.WriteFile(path, []byte(buf.String()), 0644))
+
+	streaming, err := cs.Process(path, ProcessOptions{Stats: true, Strategy: StrategyStreaming})
+	require.NoError(t, err)
+	require.Len(t, streaming.Summaries, 3)
+	stats := streaming.Summaries[1].Stats
+	require.NotNil(t, stats)
+	assert.Equal(t, "Dept 1", streaming.Summaries[1].Department)
+	assert.Equal(t, "2500.5", stats.Avg.String())
+	assert.Equal(t, "1.5", stats.Min.String())
+	assert.Equal(t, "4999.5", stats.Max.String())
+	assert.InEpsilon(t, 2500, stats.Median.Float64(), 0.01)
+	assert.InEpsilon(t, 4500, stats.P90.Float64(), 0.01)
+	assert.InEpsilon(t, 4750, stats.P95.Float64(), 0.01)
+	assert.InEpsilon(t, 1443.5, stats.StdDev.Float64(), 0.001)
+
+	// Chunks aggregated in parallel merge their statistics
+	cs.SetStrategyThresholds(StrategyThresholds{Workers: 4})
+	parallel, err := cs.Process(path, ProcessOptions{Stats: true, Strategy: StrategyParallel})
+	require.NoError(t, err)
+	assert.Equal(t, StrategyParallel, parallel.Strategy)
+	for i, summary := range parallel.Summaries {
+		want := streaming.Summaries[i].Stats
+		assert.Equal(t, want.Avg, summary.Stats.Avg)
+		assert.Equal(t, want.Min, summary.Stats.Min)
+		assert.Equal(t, want.Max, summary.Stats.Max)
+		assert.InEpsilon(t, want.Median.Float64(), summary.Stats.Median.Float64(), 0.01)
+		assert.InEpsilon(t, want.StdDev.Float64(), summary.Stats.StdDev.Float64(), 0.0001)
+	}
+
+	without, err := cs.Process(path, ProcessOptions{})
+	require.NoError(t, err)
+	assert.Nil(t, without.Summaries[0].Stats)
+
+	// Each statistic adds a result column
+	table := departmentTable(streaming.Summaries, NumberFormat{Precision: 2}, "", nil)
+	var columns []string
+	for _, column := range table.Columns {
+		columns = append(columns, column.Name)
+	}
+	assert.Equal(t, append([]string{"Department Name", "Total Number of Sales"}, statsHeaders...), columns)
+	assert.Equal(t, "2500.50", table.Rows[1][2])
+}
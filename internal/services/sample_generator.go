@@ -0,0 +1,123 @@
+package services
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"math"
+	"math/rand"
+	"strconv"
+	"time"
+)
+
+// Sample generator bounds
+const (
+	MaxSampleRows        = 1000000
+	MaxSampleDepartments = 1000
+)
+
+// sampleDepartmentNames are the base department names; larger samples
+// number them ("Books 2") to get more departments
+var sampleDepartmentNames = []string{
+	"Electronics", "Clothing", "Home & Garden", "Books", "Toys", "Sports",
+	"Grocery", "Beauty", "Automotive", "Pet Supplies", "Office", "Jewelry",
+	"Music", "Furniture", "Health", "Shoes", "Baby", "Tools", "Outdoor", "Kitchen",
+}
+
+// sampleStores are the store names written to the Store column
+var sampleStores = []string{"North", "South", "East", "West", "Online"}
+
+// SampleOptions configures GenerateSampleCSV
+type SampleOptions struct {
+	Rows        int
+	Departments int
+	// Seed makes the output reproducible; the same seed yields the same file
+	Seed int64
+	// Start is the first date written; rows spread over the following 90 days
+	Start time.Time
+}
+
+// Validate checks the row and department counts
+func (o SampleOptions) Validate() error {
+	if o.Rows < 1 || o.Rows > MaxSampleRows {
+		return fmt.Errorf("rows must be between 1 and %d", MaxSampleRows)
+	}
+	if o.Departments < 1 || o.Departments > MaxSampleDepartments {
+		return fmt.Errorf("departments must be between 1 and %d", MaxSampleDepartments)
+	}
+	return nil
+}
+
+// GenerateSampleCSV writes a random but realistic sales CSV: departments
+// follow a skewed popularity distribution, sales are log-normally distributed
+// around a per-department typical value, and rows carry a date and store.
+func GenerateSampleCSV(w io.Writer, opts SampleOptions) error {
+	if err := opts.Validate(); err != nil {
+		return err
+	}
+	rng := rand.New(rand.NewSource(opts.Seed))
+	start := opts.Start
+	if start.IsZero() {
+		start = time.Date(2024, time.January, 1, 0, 0, 0, 0, time.UTC)
+	}
+
+	// Zipf-like weights so a few departments dominate, as in real feeds
+	names := make([]string, opts.Departments)
+	cumulative := make([]float64, opts.Departments)
+	typical := make([]float64, opts.Departments)
+	total := 0.0
+	for i := range names {
+		names[i] = sampleDepartmentName(i)
+		total += 1 / float64(i+1)
+		cumulative[i] = total
+		typical[i] = 20 + rng.Float64()*180
+	}
+
+	out := bufio.NewWriter(w)
+	if _, err := out.WriteString("Date,Store,Department Name,Number of Sales\n"); err != nil {
+		return err
+	}
+	line := make([]byte, 0, 64)
+	for i := 0; i < opts.Rows; i++ {
+		dept := pickWeighted(cumulative, rng.Float64()*total)
+		sales := int(math.Round(typical[dept] * math.Exp(rng.NormFloat64()*0.5)))
+		date := start.AddDate(0, 0, rng.Intn(90))
+
+		line = line[:0]
+		line = date.AppendFormat(line, "2006-01-02")
+		line = append(line, ',')
+		line = append(line, sampleStores[rng.Intn(len(sampleStores))]...)
+		line = append(line, ',')
+		line = append(line, quoteCSVField(names[dept])...)
+		line = append(line, ',')
+		line = strconv.AppendInt(line, int64(sales), 10)
+		line = append(line, '\n')
+		if _, err := out.Write(line); err != nil {
+			return err
+		}
+	}
+	return out.Flush()
+}
+
+// sampleDepartmentName returns the i-th generated department name
+func sampleDepartmentName(i int) string {
+	name := sampleDepartmentNames[i%len(sampleDepartmentNames)]
+	if round := i / len(sampleDepartmentNames); round > 0 {
+		name += " " + strconv.Itoa(round+1)
+	}
+	return name
+}
+
+// pickWeighted returns the index of the first cumulative weight above target
+func pickWeighted(cumulative []float64, target float64) int {
+	lo, hi := 0, len(cumulative)-1
+	for lo < hi {
+		mid := (lo + hi) / 2
+		if cumulative[mid] <= target {
+			lo = mid + 1
+		} else {
+			hi = mid
+		}
+	}
+	return lo
+}
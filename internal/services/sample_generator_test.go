@@ -0,0 +1,66 @@
+package services
+
+import (
+	"bytes"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/sirupsen/logrus"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestGenerateSampleCSV(t *testing.T) {
+	var first, second bytes.Buffer
+	opts := SampleOptions{Rows: 500, Departments: 25, Seed: 42}
+	require.NoError(t, GenerateSampleCSV(&first, opts))
+	require.NoError(t, GenerateSampleCSV(&second, opts))
+	assert.Equal(t, first.String(), second.String(), "the same seed must give the same file")
+
+	lines := strings.Split(strings.TrimSuffix(first.String(), "\n"), "\n")
+	assert.Equal(t, "Date,Store,Department Name,Number of Sales", lines[0])
+	assert.Len(t, lines, 501)
+
+	// The generated file processes cleanly
+	logger := logrus.New()
+	logger.SetLevel(logrus.ErrorLevel)
+	path := filepath.Join(t.TempDir(), "sample.csv")
+	require.NoError(t, os.WriteFile(path, first.Bytes(), 0600))
+	result, err := NewCSVService(logger).Process(path, ProcessOptions{})
+	require.NoError(t, err)
+	assert.Equal(t, 500, result.Rows.Processed)
+	assert.LessOrEqual(t, len(result.Summaries), 25)
+	assert.Greater(t, len(result.Summaries), 10)
+
+	assert.Error(t, GenerateSampleCSV(&first, SampleOptions{Rows: 0, Departments: 1}))
+	assert.Error(t, GenerateSampleCSV(&first, SampleOptions{Rows: 1, Departments: MaxSampleDepartments + 1}))
+	assert.Equal(t, "Books 2", sampleDepartmentName(len(sampleDepartmentNames)+3))
+}
+
+// benchmarkProcess processes a generated file with the given strategy
+func benchmarkProcess(b *testing.B, rows int, strategy string) {
+	path := filepath.Join(b.TempDir(), "bench.csv")
+	file, err := os.Create(path)
+	require.NoError(b, err)
+	require.NoError(b, GenerateSampleCSV(file, SampleOptions{Rows: rows, Departments: 50, Seed: 1}))
+	require.NoError(b, file.Close())
+	info, err := os.Stat(path)
+	require.NoError(b, err)
+
+	logger := logrus.New()
+	logger.SetLevel(logrus.ErrorLevel)
+	cs := NewCSVService(logger)
+	b.SetBytes(info.Size())
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		if _, err := cs.Process(path, ProcessOptions{Strategy: strategy}); err != nil {
+			b.Fatal(err)
+		}
+	}
+}
+
+func BenchmarkProcessInMemory(b *testing.B)  { benchmarkProcess(b, 100000, StrategyInMemory) }
+func BenchmarkProcessStreaming(b *testing.B) { benchmarkProcess(b, 100000, StrategyStreaming) }
+func BenchmarkProcessParallel(b *testing.B)  { benchmarkProcess(b, 100000, StrategyParallel) }
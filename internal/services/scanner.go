@@ -0,0 +1,212 @@
+package services
+
+import (
+	"bufio"
+	"context"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+)
+
+// Upload scanners
+const (
+	ScannerOff    = "off"
+	ScannerNoop   = "noop"
+	ScannerClamAV = "clamav"
+)
+
+// ErrUploadInfected is returned when a scanner finds a threat in an upload
+var ErrUploadInfected = errors.New("upload failed content scanning")
+
+// ErrScanFailed is returned when an upload couldn't be scanned, in which
+// case it is rejected rather than processed unscanned
+var ErrScanFailed = errors.New("upload could not be scanned")
+
+// ScanVerdict is the outcome of scanning a file
+type ScanVerdict struct {
+	Clean bool
+	// Threat names what was found in a file that isn't clean
+	Threat string
+}
+
+// Scanner inspects uploads for malware or disallowed content before they
+// are processed
+type Scanner interface {
+	Scan(ctx context.Context, r io.Reader) (ScanVerdict, error)
+}
+
+// NoopScanner passes every file. Uploads still go through quarantine, so it
+// exercises the flow without a scanning service.
+type NoopScanner struct{}
+
+// Scan reports the file clean without reading it
+func (NoopScanner) Scan(ctx context.Context, r io.Reader) (ScanVerdict, error) {
+	return ScanVerdict{Clean: true}, nil
+}
+
+// clamAVChunkBytes is the size of the chunks a file is streamed to clamd in
+const clamAVChunkBytes = 32 << 10
+
+// ClamAVScanner scans files with a clamd daemon over TCP using its INSTREAM
+// command. Files larger than clamd's StreamMaxLength fail to scan.
+type ClamAVScanner struct {
+	address string
+	timeout time.Duration
+}
+
+// NewClamAVScanner creates a ClamAVScanner for the clamd at address, e.g.
+// 127.0.0.1:3310, failing scans that take longer than timeout
+func NewClamAVScanner(address string, timeout time.Duration) *ClamAVScanner {
+	return &ClamAVScanner{address: address, timeout: timeout}
+}
+
+// Scan streams r to clamd and parses its verdict
+func (s *ClamAVScanner) Scan(ctx context.Context, r io.Reader) (ScanVerdict, error) {
+	var dialer net.Dialer
+	conn, err := dialer.DialContext(ctx, "tcp", s.address)
+	if err != nil {
+		return ScanVerdict{}, fmt.Errorf("failed to connect to clamd: %w", err)
+	}
+	defer conn.Close()
+	if s.timeout > 0 {
+		conn.SetDeadline(time.Now().Add(s.timeout))
+	}
+
+	writeErr := streamToClamAV(conn, r)
+	// clamd answers before the stream ends when it refuses it, e.g. for
+	// exceeding StreamMaxLength, so its reply is read even if writing failed
+	reply, err := bufio.NewReader(conn).ReadString(0)
+	if err != nil {
+		if writeErr != nil {
+			return ScanVerdict{}, fmt.Errorf("failed to send file to clamd: %w", writeErr)
+		}
+		return ScanVerdict{}, fmt.Errorf("failed to read clamd reply: %w", err)
+	}
+	return parseClamAVReply(strings.TrimSuffix(reply, "\x00"))
+}
+
+// streamToClamAV sends the INSTREAM command followed by r in length-prefixed
+// chunks and the zero-length chunk that ends the stream
+func streamToClamAV(w io.Writer, r io.Reader) error {
+	if _, err := io.WriteString(w, "zINSTREAM\x00"); err != nil {
+		return err
+	}
+	buf := make([]byte, 4+clamAVChunkBytes)
+	for {
+		n, err := r.Read(buf[4:])
+		if n > 0 {
+			binary.BigEndian.PutUint32(buf, uint32(n))
+			if _, err := w.Write(buf[:4+n]); err != nil {
+				return err
+			}
+		}
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return err
+		}
+	}
+	_, err := w.Write([]byte{0, 0, 0, 0})
+	return err
+}
+
+// parseClamAVReply reads a reply such as "stream: OK" or
+// "stream: Eicar-Signature FOUND"
+func parseClamAVReply(reply string) (ScanVerdict, error) {
+	result := strings.TrimPrefix(reply, "stream: ")
+	switch {
+	case result == "OK":
+		return ScanVerdict{Clean: true}, nil
+	case strings.HasSuffix(result, " FOUND"):
+		return ScanVerdict{Threat: strings.TrimSuffix(result, " FOUND")}, nil
+	}
+	return ScanVerdict{}, fmt.Errorf("clamd: %s", reply)
+}
+
+// SetScanner makes uploads wait in quarantineDir until scanner passes them;
+// uploads it doesn't pass are deleted and rejected
+func (fs *FileService) SetScanner(scanner Scanner, quarantineDir string) error {
+	if err := os.MkdirAll(quarantineDir, 0700); err != nil {
+		return fmt.Errorf("failed to create quarantine directory: %w", err)
+	}
+	fs.scanner, fs.quarantineDir = scanner, quarantineDir
+	return nil
+}
+
+// Scanning reports whether uploads are scanned before they are processed
+func (fs *FileService) Scanning() bool {
+	return fs.scanner != nil
+}
+
+// release scans a quarantined upload and moves it to filePath if it is
+// clean, deleting it otherwise
+func (fs *FileService) release(ctx context.Context, quarantined, filePath string) error {
+	if err := fs.checkUpload(ctx, quarantined); err != nil {
+		os.Remove(quarantined)
+		return err
+	}
+	if err := moveFile(quarantined, filePath); err != nil {
+		os.Remove(quarantined)
+		return fmt.Errorf("failed to release upload from quarantine: %w", err)
+	}
+	return nil
+}
+
+// checkUpload scans a file, failing with ErrScanFailed when it can't be
+// scanned and with ErrUploadInfected when the scanner doesn't pass it
+func (fs *FileService) checkUpload(ctx context.Context, path string) error {
+	verdict, err := fs.scanFile(ctx, path)
+	if err != nil {
+		fs.logger.Errorf("Failed to scan %s: %v", filepath.Base(path), err)
+		return fmt.Errorf("%w: %v", ErrScanFailed, err)
+	}
+	if !verdict.Clean {
+		fs.logger.Warnf("Rejected %s: scanner found %s", filepath.Base(path), verdict.Threat)
+		return fmt.Errorf("%w: %s found", ErrUploadInfected, verdict.Threat)
+	}
+	return nil
+}
+
+// scanFile runs the scanner over a file
+func (fs *FileService) scanFile(ctx context.Context, path string) (ScanVerdict, error) {
+	file, err := os.Open(path)
+	if err != nil {
+		return ScanVerdict{}, err
+	}
+	defer file.Close()
+	return fs.scanner.Scan(ctx, file)
+}
+
+// moveFile renames a file, copying it when the directories are on
+// different file systems
+func moveFile(src, dst string) error {
+	if err := os.Rename(src, dst); err == nil {
+		return nil
+	}
+	in, err := os.Open(src)
+	if err != nil {
+		return err
+	}
+	defer in.Close()
+	out, err := os.Create(dst)
+	if err != nil {
+		return err
+	}
+	if _, err := io.Copy(out, in); err != nil {
+		out.Close()
+		os.Remove(dst)
+		return err
+	}
+	if err := out.Close(); err != nil {
+		os.Remove(dst)
+		return err
+	}
+	return os.Remove(src)
+}
@@ -0,0 +1,117 @@
+package services
+
+import (
+	"bytes"
+	"context"
+	"encoding/binary"
+	"io"
+	"net"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/sirupsen/logrus"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// fakeClamd answers INSTREAM commands, finding Test-Signature in streams
+// that contain "EICAR" and replying with an error to streams containing
+// "BROKEN"
+func fakeClamd(t *testing.T) string {
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	require.NoError(t, err)
+	t.Cleanup(func() { listener.Close() })
+
+	go func() {
+		for {
+			conn, err := listener.Accept()
+			if err != nil {
+				return
+			}
+			go func(conn net.Conn) {
+				defer conn.Close()
+				command := make([]byte, len("zINSTREAM\x00"))
+				if _, err := io.ReadFull(conn, command); err != nil || string(command) != "zINSTREAM\x00" {
+					return
+				}
+				var stream bytes.Buffer
+				for {
+					var size uint32
+					if err := binary.Read(conn, binary.BigEndian, &size); err != nil {
+						return
+					}
+					if size == 0 {
+						break
+					}
+					if _, err := io.CopyN(&stream, conn, int64(size)); err != nil {
+						return
+					}
+				}
+				reply := "stream: OK"
+				if strings.Contains(stream.String(), "EICAR") {
+					reply = "stream: Test-Signature FOUND"
+				} else if strings.Contains(stream.String(), "BROKEN") {
+					reply = "INSTREAM size limit exceeded. ERROR"
+				}
+				conn.Write([]byte(reply + "\x00"))
+			}(conn)
+		}
+	}()
+	return listener.Addr().String()
+}
+
+func TestClamAVScanner(t *testing.T) {
+	scanner := NewClamAVScanner(fakeClamd(t), 5*time.Second)
+	ctx := context.Background()
+
+	verdict, err := scanner.Scan(ctx, strings.NewReader("Department,Sales\nBooks,10\n"))
+	require.NoError(t, err)
+	assert.True(t, verdict.Clean)
+
+	// Streams span several chunks
+	verdict, err = scanner.Scan(ctx, strings.NewReader(strings.Repeat("x", 3*clamAVChunkBytes)+"EICAR"))
+	require.NoError(t, err)
+	assert.False(t, verdict.Clean)
+	assert.Equal(t, "Test-Signature", verdict.Threat)
+
+	_, err = scanner.Scan(ctx, strings.NewReader("BROKEN"))
+	assert.ErrorContains(t, err, "size limit exceeded")
+
+	_, err = NewClamAVScanner("127.0.0.1:1", time.Second).Scan(ctx, strings.NewReader("a"))
+	assert.Error(t, err)
+}
+
+func TestSaveUploadScanning(t *testing.T) {
+	logger := logrus.New()
+	logger.SetLevel(logrus.FatalLevel)
+	uploadsDir, quarantineDir := t.TempDir(), t.TempDir()
+	fs := NewFileService(uploadsDir, logger)
+	require.NoError(t, fs.SetScanner(NewClamAVScanner(fakeClamd(t), 5*time.Second), quarantineDir))
+	assert.True(t, fs.Scanning())
+	ctx := context.Background()
+
+	filePath, err := fs.SaveUpload(ctx, "sales.csv", strings.NewReader("Department,Sales\nBooks,10\n"))
+	require.NoError(t, err)
+	assert.Equal(t, uploadsDir, filepath.Dir(filePath))
+	data, err := os.ReadFile(filePath)
+	require.NoError(t, err)
+	assert.Equal(t, "Department,Sales\nBooks,10\n", string(data))
+
+	_, err = fs.SaveUpload(ctx, "sales.csv", strings.NewReader("EICAR"))
+	assert.ErrorIs(t, err, ErrUploadInfected)
+	assert.ErrorContains(t, err, "Test-Signature")
+
+	_, err = fs.SaveUpload(ctx, "sales.csv", strings.NewReader("BROKEN"))
+	assert.ErrorIs(t, err, ErrScanFailed)
+
+	// Only the clean upload is kept and nothing is left in quarantine
+	uploads, err := os.ReadDir(uploadsDir)
+	require.NoError(t, err)
+	assert.Len(t, uploads, 1)
+	quarantined, err := os.ReadDir(quarantineDir)
+	require.NoError(t, err)
+	assert.Empty(t, quarantined)
+}
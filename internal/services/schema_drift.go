@@ -0,0 +1,188 @@
+package services
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/csv"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"sync"
+	"time"
+
+	"github.com/mussietl/csv-sales-api/internal/models"
+	"github.com/sirupsen/logrus"
+)
+
+// MaxFeedNameLength bounds the length of a feed name
+const MaxFeedNameLength = 128
+
+// SchemaFingerprint is the header shape of a feed's most recent upload
+type SchemaFingerprint struct {
+	Columns   []string  `json:"columns"`
+	Delimiter string    `json:"delimiter"`
+	UploadID  string    `json:"upload_id"`
+	SeenAt    time.Time `json:"seen_at"`
+}
+
+// SchemaDriftService remembers the header of each tenant's feeds and reports
+// how a new upload's header differs from the previous one
+type SchemaDriftService struct {
+	path         string
+	limits       CSVLimits
+	mu           sync.Mutex
+	fingerprints map[string]SchemaFingerprint
+	logger       *logrus.Logger
+}
+
+// NewSchemaDriftService creates a SchemaDriftService, loading fingerprints from path
+func NewSchemaDriftService(path string, limits CSVLimits, logger *logrus.Logger) (*SchemaDriftService, error) {
+	ds := &SchemaDriftService{
+		path:         path,
+		limits:       limits,
+		fingerprints: make(map[string]SchemaFingerprint),
+		logger:       logger,
+	}
+
+	data, err := os.ReadFile(path)
+	if err != nil && !errors.Is(err, os.ErrNotExist) {
+		return nil, fmt.Errorf("failed to read schema fingerprints: %w", err)
+	}
+	if err == nil {
+		if err := json.Unmarshal(data, &ds.fingerprints); err != nil {
+			return nil, fmt.Errorf("failed to decode schema fingerprints: %w", err)
+		}
+	}
+	return ds, nil
+}
+
+// Check fingerprints the header of a CSV upload, compares it with the feed's
+// previous upload and records it as the feed's latest. It returns nil when
+// the feed has no previous upload or the file is not a plain CSV file.
+func (ds *SchemaDriftService) Check(tenant, feed, uploadID, filePath string) (*models.SchemaDrift, error) {
+	if IsXLSX(filePath) || IsCompressed(filePath) {
+		return nil, nil
+	}
+
+	current, err := ds.fingerprint(filePath)
+	if err != nil {
+		return nil, err
+	}
+	current.UploadID = uploadID
+	current.SeenAt = time.Now().UTC()
+
+	key := tenant + "/" + feed
+	ds.mu.Lock()
+	defer ds.mu.Unlock()
+	previous, ok := ds.fingerprints[key]
+	ds.fingerprints[key] = *current
+	if err := ds.save(); err != nil {
+		if ok {
+			ds.fingerprints[key] = previous
+		} else {
+			delete(ds.fingerprints, key)
+		}
+		return nil, err
+	}
+	if !ok {
+		return nil, nil
+	}
+
+	drift := CompareSchemas(previous, *current)
+	drift.Feed = feed
+	if drift.Detected {
+		ds.logger.Warnf("Schema drift in feed %s of tenant %s: +%v -%v renamed %v", feed, tenant, drift.Added, drift.Removed, drift.Renamed)
+	}
+	return drift, nil
+}
+
+// CompareSchemas reports the columns added, removed and renamed between two
+// fingerprints, and any delimiter change. Columns are compared by their
+// normalized names; a removed column whose position is now held by an added
+// column is reported as renamed.
+func CompareSchemas(previous, current SchemaFingerprint) *models.SchemaDrift {
+	drift := &models.SchemaDrift{
+		PreviousUploadID: previous.UploadID,
+		PreviousSeenAt:   previous.SeenAt.Format(time.RFC3339),
+		Added:            []string{},
+		Removed:          []string{},
+		Renamed:          []models.ColumnRename{},
+	}
+
+	previousNames := make(map[string]bool)
+	for _, col := range previous.Columns {
+		previousNames[DefaultHeaderNormalization.Apply(col)] = true
+	}
+	currentNames := make(map[string]bool)
+	for _, col := range current.Columns {
+		currentNames[DefaultHeaderNormalization.Apply(col)] = true
+	}
+
+	added := make(map[int]bool)
+	for i, col := range current.Columns {
+		if !previousNames[DefaultHeaderNormalization.Apply(col)] {
+			added[i] = true
+		}
+	}
+	for i, col := range previous.Columns {
+		if currentNames[DefaultHeaderNormalization.Apply(col)] {
+			continue
+		}
+		if added[i] {
+			drift.Renamed = append(drift.Renamed, models.ColumnRename{From: col, To: current.Columns[i]})
+			delete(added, i)
+			continue
+		}
+		drift.Removed = append(drift.Removed, col)
+	}
+	for i, col := range current.Columns {
+		if added[i] {
+			drift.Added = append(drift.Added, col)
+		}
+	}
+
+	if previous.Delimiter != current.Delimiter {
+		drift.Delimiter = &models.DelimiterChange{From: previous.Delimiter, To: current.Delimiter}
+	}
+	drift.Detected = len(drift.Added) > 0 || len(drift.Removed) > 0 || len(drift.Renamed) > 0 || drift.Delimiter != nil
+	return drift
+}
+
+// fingerprint reads a file's header row and detects its delimiter
+func (ds *SchemaDriftService) fingerprint(filePath string) (*SchemaFingerprint, error) {
+	file, err := os.Open(filePath)
+	if err != nil {
+		return nil, fmt.Errorf("failed to open file: %w", err)
+	}
+	defer file.Close()
+
+	buffered, _, err := decodeCharset(bufio.NewReader(file))
+	if err != nil {
+		return nil, fmt.Errorf("failed to read CSV header: %w", err)
+	}
+	buffered = newLineEndingReader(buffered)
+	headerLine, err := readHeaderLine(buffered, ds.limits.MaxHeaderBytes)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read CSV header: %w", err)
+	}
+	delimiter := detectDelimiter(headerLine)
+
+	reader := csv.NewReader(bytes.NewReader(headerLine))
+	reader.Comma = rune(delimiter)
+	reader.LazyQuotes = true
+	columns, err := reader.Read()
+	if err != nil {
+		return nil, fmt.Errorf("failed to read CSV header: %w", err)
+	}
+	return &SchemaFingerprint{Columns: columns, Delimiter: string(delimiter)}, nil
+}
+
+// save persists the fingerprints. Callers must hold ds.mu.
+func (ds *SchemaDriftService) save() error {
+	data, err := json.MarshalIndent(ds.fingerprints, "", "  ")
+	if err != nil {
+		return fmt.Errorf("failed to encode schema fingerprints: %w", err)
+	}
+	return writeFileAtomic(ds.path, data, 0600)
+}
@@ -0,0 +1,85 @@
+package services
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/mussietl/csv-sales-api/internal/models"
+	"github.com/sirupsen/logrus"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestCompareSchemas(t *testing.T) {
+	previous := SchemaFingerprint{Columns: []string{"Department Name", "Number of Sales", "Region", "Store"}, Delimiter: ",", UploadID: "u1"}
+
+	// Case and spacing changes are not drift
+	drift := CompareSchemas(previous, SchemaFingerprint{Columns: []string{"department name", " Number of Sales", "REGION", "Store"}, Delimiter: ","})
+	assert.False(t, drift.Detected)
+	assert.Equal(t, "u1", drift.PreviousUploadID)
+
+	drift = CompareSchemas(previous, SchemaFingerprint{Columns: []string{"Department Name", "Number of Sales", "Area", "Channel", "Date"}, Delimiter: ";"})
+	assert.True(t, drift.Detected)
+	assert.Equal(t, []models.ColumnRename{{From: "Region", To: "Area"}, {From: "Store", To: "Channel"}}, drift.Renamed)
+	assert.Equal(t, []string{"Date"}, drift.Added)
+	assert.Empty(t, drift.Removed)
+	assert.Equal(t, &models.DelimiterChange{From: ",", To: ";"}, drift.Delimiter)
+
+	// A column dropped from the middle shifts the others rather than renaming them
+	drift = CompareSchemas(previous, SchemaFingerprint{Columns: []string{"Department Name", "Region", "Store"}, Delimiter: ","})
+	assert.Equal(t, []string{"Number of Sales"}, drift.Removed)
+	assert.Empty(t, drift.Renamed)
+	assert.Empty(t, drift.Added)
+}
+
+func TestDetectDelimiter(t *testing.T) {
+	assert.Equal(t, byte(','), detectDelimiter([]byte("a,b,c")))
+	assert.Equal(t, byte(';'), detectDelimiter([]byte("a;b;c")))
+	assert.Equal(t, byte('\t'), detectDelimiter([]byte("a\tb\tc")))
+	assert.Equal(t, byte(';'), detectDelimiter([]byte(`"a,b,c";d;e`)))
+	assert.Equal(t, byte(','), detectDelimiter([]byte("single")))
+}
+
+func TestSchemaDriftServiceCheck(t *testing.T) {
+	logger := logrus.New()
+	logger.SetLevel(logrus.ErrorLevel)
+	dir := t.TempDir()
+	storePath := filepath.Join(dir, "schemas.json")
+	ds, err := NewSchemaDriftService(storePath, DefaultCSVLimits, logger)
+	require.NoError(t, err)
+
+	write := func(name, content string) string {
+		path := filepath.Join(dir, name)
+		require.NoError(t, os.WriteFile(path, []byte(content), 0600))
+		return path
+	}
+
+	// The first upload of a feed has nothing to compare against
+	drift, err := ds.Check("acme", "daily", "u1", write("a.csv", "Department Name,Number of Sales\nBooks,5\n"))
+	require.NoError(t, err)
+	assert.Nil(t, drift)
+
+	// Feeds and tenants are tracked separately
+	drift, err = ds.Check("other", "daily", "u2", write("b.csv", "Department;Sales\n"))
+	require.NoError(t, err)
+	assert.Nil(t, drift)
+
+	drift, err = ds.Check("acme", "daily", "u3", write("c.csv", "Department Name;Number of Sales;Region\nBooks;5;North\n"))
+	require.NoError(t, err)
+	require.NotNil(t, drift)
+	assert.True(t, drift.Detected)
+	assert.Equal(t, "daily", drift.Feed)
+	assert.Equal(t, "u1", drift.PreviousUploadID)
+	assert.Equal(t, []string{"Region"}, drift.Added)
+	assert.Equal(t, &models.DelimiterChange{From: ",", To: ";"}, drift.Delimiter)
+
+	// Fingerprints survive a restart, and the latest upload becomes the baseline
+	reloaded, err := NewSchemaDriftService(storePath, DefaultCSVLimits, logger)
+	require.NoError(t, err)
+	drift, err = reloaded.Check("acme", "daily", "u4", write("d.csv", "Department Name;Number of Sales;Region\n"))
+	require.NoError(t, err)
+	require.NotNil(t, drift)
+	assert.False(t, drift.Detected)
+	assert.Equal(t, "u3", drift.PreviousUploadID)
+}
@@ -0,0 +1,412 @@
+package services
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/mussietl/csv-sales-api/internal/models"
+	"gopkg.in/yaml.v3"
+)
+
+// ErrSchemaNotFound is returned for schema profiles that aren't registered
+var ErrSchemaNotFound = errors.New("schema profile not found")
+
+// ErrSchemaViolation is wrapped by SchemaError
+var ErrSchemaViolation = errors.New("upload does not match its schema profile")
+
+// MaxSchemaViolations is the number of violations after which validation
+// stops reading the upload
+const MaxSchemaViolations = 100
+
+// Column types of schema profiles
+const (
+	SchemaTypeString  = "string"
+	SchemaTypeInteger = "integer"
+	SchemaTypeNumber  = "number"
+	SchemaTypeDate    = "date"
+)
+
+// Rules broken by schema violations
+const (
+	RuleMissingColumn = "missing_column"
+	RuleRequired      = "required"
+	RuleType          = "type"
+	RuleMin           = "min"
+	RuleMax           = "max"
+	RuleAllowed       = "allowed"
+	RuleDepartment    = "department"
+	// Header rules
+	RuleDuplicateColumn  = "duplicate_column"
+	RuleUnexpectedColumn = "unexpected_column"
+	RuleColumnOrder      = "column_order"
+)
+
+// How a profile treats a header naming one of its columns more than once
+const (
+	// DuplicatesReject reports the column as a violation
+	DuplicatesReject = "reject"
+	// DuplicatesFirst and DuplicatesLast validate the first or last of them
+	DuplicatesFirst = "first"
+	DuplicatesLast  = "last"
+)
+
+// SchemaProfile describes the uploads of one kind: the columns they must
+// have, the values those columns may hold and the departments they may name
+type SchemaProfile struct {
+	Name        string             `json:"name" yaml:"name"`
+	Description string             `json:"description,omitempty" yaml:"description"`
+	Columns     []SchemaColumnRule `json:"columns" yaml:"columns"`
+	// Departments lists the allowed department names; empty allows any
+	Departments []string `json:"departments,omitempty" yaml:"departments"`
+	// Strict requires the header to hold exactly Columns, in order. Otherwise
+	// columns may come in any order and other columns are ignored.
+	Strict bool `json:"strict,omitempty" yaml:"strict"`
+	// Duplicates is one of the Duplicates values; empty is DuplicatesReject.
+	// Strict profiles always reject duplicates.
+	Duplicates string `json:"duplicates,omitempty" yaml:"duplicates"`
+}
+
+// SchemaColumnRule constrains one column, matched by header name. A required
+// column must be present and have a value in every row; the values of an
+// optional column are only checked where they are given.
+type SchemaColumnRule struct {
+	Name     string `json:"name" yaml:"name"`
+	Required bool   `json:"required,omitempty" yaml:"required"`
+	// Type is one of the SchemaType values; empty is SchemaTypeString
+	Type string `json:"type,omitempty" yaml:"type"`
+	// Min and Max bound integer and number values
+	Min *float64 `json:"min,omitempty" yaml:"min"`
+	Max *float64 `json:"max,omitempty" yaml:"max"`
+	// Allowed lists the values the column may hold, compared case-insensitively
+	Allowed []string `json:"allowed,omitempty" yaml:"allowed"`
+}
+
+// SchemaError lists the violations of an upload. Truncated is set when
+// validation stopped at MaxSchemaViolations.
+type SchemaError struct {
+	Profile    string
+	Violations []models.SchemaViolation
+	Truncated  bool
+}
+
+func (e *SchemaError) Error() string {
+	count := fmt.Sprintf("%d violations", len(e.Violations))
+	switch {
+	case e.Truncated:
+		count = "at least " + count
+	case len(e.Violations) == 1:
+		count = "1 violation"
+	}
+	return fmt.Sprintf("%v %q: %s, first: %s", ErrSchemaViolation, e.Profile, count, e.Violations[0].Message)
+}
+
+func (e *SchemaError) Unwrap() error { return ErrSchemaViolation }
+
+// SchemaRegistry holds the schema profiles uploads can be validated against
+type SchemaRegistry struct {
+	profiles map[string]*SchemaProfile
+}
+
+// LoadSchemaProfiles reads every .yaml, .yml and .json file in dir as a
+// schema profile, named by its name field or else by its file name. Names
+// are case-insensitive.
+func LoadSchemaProfiles(dir string) (*SchemaRegistry, error) {
+	entries, err := os.ReadDir(dir)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read schema directory: %w", err)
+	}
+	registry := &SchemaRegistry{profiles: make(map[string]*SchemaProfile)}
+	for _, entry := range entries {
+		ext := strings.ToLower(filepath.Ext(entry.Name()))
+		if entry.IsDir() || (ext != ".yaml" && ext != ".yml" && ext != ".json") {
+			continue
+		}
+		data, err := os.ReadFile(filepath.Join(dir, entry.Name()))
+		if err != nil {
+			return nil, fmt.Errorf("failed to read schema %s: %w", entry.Name(), err)
+		}
+		profile, err := ParseSchemaProfile(data, ext == ".json")
+		if err != nil {
+			return nil, fmt.Errorf("invalid schema %s: %w", entry.Name(), err)
+		}
+		if profile.Name == "" {
+			profile.Name = strings.TrimSuffix(entry.Name(), filepath.Ext(entry.Name()))
+		}
+		key := strings.ToLower(profile.Name)
+		if _, ok := registry.profiles[key]; ok {
+			return nil, fmt.Errorf("invalid schema %s: profile %q is defined twice", entry.Name(), profile.Name)
+		}
+		registry.profiles[key] = profile
+	}
+	return registry, nil
+}
+
+// ParseSchemaProfile parses and checks a profile written in YAML, or in JSON
+// when isJSON is set. Unknown fields are rejected, so misspelled rules don't
+// go unnoticed.
+func ParseSchemaProfile(data []byte, isJSON bool) (*SchemaProfile, error) {
+	var profile SchemaProfile
+	if isJSON {
+		decoder := json.NewDecoder(bytes.NewReader(data))
+		decoder.DisallowUnknownFields()
+		if err := decoder.Decode(&profile); err != nil {
+			return nil, err
+		}
+	} else {
+		decoder := yaml.NewDecoder(bytes.NewReader(data))
+		decoder.KnownFields(true)
+		if err := decoder.Decode(&profile); err != nil {
+			return nil, err
+		}
+	}
+	if err := profile.validate(); err != nil {
+		return nil, err
+	}
+	return &profile, nil
+}
+
+// validate checks the rules of a profile and fills in default types
+func (p *SchemaProfile) validate() error {
+	p.Name = strings.TrimSpace(p.Name)
+	p.Duplicates = strings.ToLower(p.Duplicates)
+	switch p.Duplicates {
+	case "":
+		p.Duplicates = DuplicatesReject
+	case DuplicatesReject, DuplicatesFirst, DuplicatesLast:
+	default:
+		return fmt.Errorf("unknown duplicates %q: expected reject, first or last", p.Duplicates)
+	}
+	seen := make(map[string]bool)
+	for i := range p.Columns {
+		rule := &p.Columns[i]
+		rule.Name = strings.TrimSpace(rule.Name)
+		if rule.Name == "" {
+			return fmt.Errorf("column %d has no name", i+1)
+		}
+		if seen[strings.ToLower(rule.Name)] {
+			return fmt.Errorf("column %q is listed twice", rule.Name)
+		}
+		seen[strings.ToLower(rule.Name)] = true
+
+		rule.Type = strings.ToLower(rule.Type)
+		switch rule.Type {
+		case "":
+			rule.Type = SchemaTypeString
+		case SchemaTypeString, SchemaTypeInteger, SchemaTypeNumber, SchemaTypeDate:
+		default:
+			return fmt.Errorf("column %q has unknown type %q: expected string, integer, number or date", rule.Name, rule.Type)
+		}
+		numeric := rule.Type == SchemaTypeInteger || rule.Type == SchemaTypeNumber
+		if (rule.Min != nil || rule.Max != nil) && !numeric {
+			return fmt.Errorf("column %q: min and max apply to integer and number columns", rule.Name)
+		}
+		if rule.Min != nil && rule.Max != nil && *rule.Min > *rule.Max {
+			return fmt.Errorf("column %q: min is greater than max", rule.Name)
+		}
+	}
+	return nil
+}
+
+// Get returns a profile by name
+func (r *SchemaRegistry) Get(name string) (*SchemaProfile, error) {
+	profile, ok := r.profiles[strings.ToLower(strings.TrimSpace(name))]
+	if !ok {
+		return nil, fmt.Errorf("%w: %s", ErrSchemaNotFound, name)
+	}
+	return profile, nil
+}
+
+// List returns the profiles sorted by name
+func (r *SchemaRegistry) List() []*SchemaProfile {
+	profiles := make([]*SchemaProfile, 0, len(r.profiles))
+	for _, profile := range r.profiles {
+		profiles = append(profiles, profile)
+	}
+	sort.Slice(profiles, func(i, j int) bool {
+		return strings.ToLower(profiles[i].Name) < strings.ToLower(profiles[j].Name)
+	})
+	return profiles
+}
+
+// schemaLayout is a profile's rules resolved against an upload's header
+type schemaLayout struct {
+	profile *SchemaProfile
+	// indices holds the header index of each column rule, or -1
+	indices []int
+	// departments holds the lowercased allowed departments, if limited
+	departments map[string]bool
+}
+
+// resolveSchema finds the profile's columns in the header, matching names
+// normalized the same way as the header. Header violations, such as missing
+// required columns, are found before any row is read. In strict mode the
+// header must hold exactly the profile's columns in the profile's order.
+func resolveSchema(profile *SchemaProfile, header, normalizedHeader []string, norm Normalization) (*schemaLayout, []models.SchemaViolation) {
+	layout := &schemaLayout{profile: profile, indices: make([]int, len(profile.Columns))}
+	var violations []models.SchemaViolation
+	headerViolation := func(column, rule, message string) {
+		violations = append(violations, models.SchemaViolation{Row: 1, Column: column, Rule: rule, Message: message})
+	}
+
+	matched := make([]bool, len(header))
+	for i, rule := range profile.Columns {
+		var found []int
+		for j, col := range normalizedHeader {
+			if col == norm.Apply(rule.Name) {
+				found = append(found, j)
+				matched[j] = true
+			}
+		}
+		layout.indices[i] = -1
+		switch {
+		case len(found) == 0:
+			if rule.Required || profile.Strict {
+				headerViolation(rule.Name, RuleMissingColumn, fmt.Sprintf("required column %q is missing", rule.Name))
+			}
+			continue
+		case len(found) > 1 && (profile.Strict || profile.Duplicates == DuplicatesReject):
+			headerViolation(rule.Name, RuleDuplicateColumn, fmt.Sprintf("column %q appears %d times, as columns %s", rule.Name, len(found), columnPositions(found)))
+			continue
+		case profile.Duplicates == DuplicatesLast:
+			layout.indices[i] = found[len(found)-1]
+		default:
+			layout.indices[i] = found[0]
+		}
+	}
+
+	if profile.Strict {
+		for j, ok := range matched {
+			if !ok {
+				headerViolation(header[j], RuleUnexpectedColumn, fmt.Sprintf("unexpected column %q at column %d", header[j], j+1))
+			}
+		}
+		violations = append(violations, columnOrderViolations(profile, layout.indices)...)
+	}
+
+	if len(profile.Departments) > 0 {
+		layout.departments = make(map[string]bool, len(profile.Departments))
+		for _, department := range profile.Departments {
+			layout.departments[strings.ToLower(strings.TrimSpace(department))] = true
+		}
+	}
+	return layout, violations
+}
+
+// columnOrderViolations compares the order of the profile's columns found in
+// the header with the profile's order, reporting each place that holds
+// another column than expected
+func columnOrderViolations(profile *SchemaProfile, indices []int) []models.SchemaViolation {
+	var expected []int
+	for i, index := range indices {
+		if index >= 0 {
+			expected = append(expected, i)
+		}
+	}
+	actual := append([]int(nil), expected...)
+	sort.Slice(actual, func(a, b int) bool { return indices[actual[a]] < indices[actual[b]] })
+
+	var violations []models.SchemaViolation
+	for k := range expected {
+		if expected[k] == actual[k] {
+			continue
+		}
+		want, got := profile.Columns[expected[k]].Name, profile.Columns[actual[k]].Name
+		violations = append(violations, models.SchemaViolation{
+			Row:      1,
+			Column:   want,
+			Rule:     RuleColumnOrder,
+			Expected: want,
+			Actual:   got,
+			Message:  fmt.Sprintf("column %d is %q, expected %q", indices[actual[k]]+1, got, want),
+		})
+	}
+	return violations
+}
+
+// columnPositions lists zero-based header indices as column numbers: "2, 5"
+func columnPositions(indices []int) string {
+	positions := make([]string, len(indices))
+	for i, index := range indices {
+		positions[i] = strconv.Itoa(index + 1)
+	}
+	return strings.Join(positions, ", ")
+}
+
+// checkSchema validates a row against the schema profile, stopping the
+// upload once MaxSchemaViolations are found. fieldValue returns the raw
+// field at a header index.
+func (a *rowAggregator) checkSchema(rowNumber int, fieldValue func(index int) string) error {
+	schema := a.layout.schema
+	for i, rule := range schema.profile.Columns {
+		index := schema.indices[i]
+		if index < 0 {
+			continue
+		}
+		value := strings.TrimSpace(fieldValue(index))
+		if value == "" {
+			if rule.Required {
+				a.schemaViolation(rowNumber, rule.Name, RuleRequired, value, fmt.Sprintf("row %d: %q is required", rowNumber, rule.Name))
+			}
+			continue
+		}
+		if broken, message := checkSchemaValue(rule, value, a.opts); broken != "" {
+			a.schemaViolation(rowNumber, rule.Name, broken, value, fmt.Sprintf("row %d: %q %s", rowNumber, rule.Name, message))
+		}
+	}
+	if schema.departments != nil {
+		department := a.valueNorm.Apply(fieldValue(a.layout.department))
+		if department != "" && !schema.departments[strings.ToLower(department)] && (a.opts.KeepTotalRows || !isTotalRow(department)) {
+			a.schemaViolation(rowNumber, "", RuleDepartment, department, fmt.Sprintf("row %d: department %q is not allowed", rowNumber, department))
+		}
+	}
+	if len(a.schemaViolations) >= MaxSchemaViolations {
+		return &SchemaError{Profile: schema.profile.Name, Violations: a.schemaViolations[:MaxSchemaViolations], Truncated: true}
+	}
+	return nil
+}
+
+func (a *rowAggregator) schemaViolation(row int, column, rule, value, message string) {
+	a.schemaViolations = append(a.schemaViolations, models.SchemaViolation{Row: row, Column: column, Rule: rule, Value: value, Message: message})
+}
+
+// checkSchemaValue checks a non-empty value against a column rule, returning
+// the broken rule and why, or "" when the value is valid. Numbers are read
+// the same way as sales values.
+func checkSchemaValue(rule SchemaColumnRule, value string, opts ProcessOptions) (string, string) {
+	switch rule.Type {
+	case SchemaTypeInteger, SchemaTypeNumber:
+		amount, err := parseSales(value, opts)
+		if err != nil {
+			return RuleType, "is not a number"
+		}
+		if rule.Type == SchemaTypeInteger && strings.Contains(amount.String(), ".") {
+			return RuleType, "is not an integer"
+		}
+		if rule.Min != nil && amount.Float64() < *rule.Min {
+			return RuleMin, fmt.Sprintf("is less than %g", *rule.Min)
+		}
+		if rule.Max != nil && amount.Float64() > *rule.Max {
+			return RuleMax, fmt.Sprintf("is greater than %g", *rule.Max)
+		}
+	case SchemaTypeDate:
+		if _, err := parseDate(value); err != nil {
+			return RuleType, "is not a date"
+		}
+	}
+	if len(rule.Allowed) > 0 {
+		for _, allowed := range rule.Allowed {
+			if strings.EqualFold(value, strings.TrimSpace(allowed)) {
+				return "", ""
+			}
+		}
+		return RuleAllowed, "is not one of " + strings.Join(rule.Allowed, ", ")
+	}
+	return "", ""
+}
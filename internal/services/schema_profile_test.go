@@ -0,0 +1,190 @@
+package services
+
+import (
+	"errors"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/mussietl/csv-sales-api/internal/models"
+	"github.com/sirupsen/logrus"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+const retailSchema = `name: Retail
+description: Daily store exports
+columns:
+  - name: Department Name
+    required: true
+  - name: Number of Sales
+    required: true
+    type: number
+    min: 0
+    max: 1000000
+  - name: Units
+    type: integer
+  - name: Sold On
+    type: date
+  - name: Channel
+    allowed: [store, online]
+departments: [Books, Electronics, Toys]
+`
+
+func TestLoadSchemaProfiles(t *testing.T) {
+	dir := t.TempDir()
+	require.NoError(t, os.WriteFile(filepath.Join(dir, "retail.yaml"), []byte(retailSchema), 0644))
+	require.NoError(t, os.WriteFile(filepath.Join(dir, "wholesale.json"), []byte(`{"columns": [{"name": "Region", "required": true}]}`), 0644))
+	require.NoError(t, os.WriteFile(filepath.Join(dir, "README.md"), []byte("not a profile"), 0644))
+
+	registry, err := LoadSchemaProfiles(dir)
+	require.NoError(t, err)
+	profiles := registry.List()
+	require.Len(t, profiles, 2)
+	assert.Equal(t, "Retail", profiles[0].Name)
+	assert.Equal(t, "wholesale", profiles[1].Name, "named after the file")
+
+	retail, err := registry.Get("retail")
+	require.NoError(t, err)
+	assert.Len(t, retail.Columns, 5)
+	assert.Equal(t, SchemaTypeString, retail.Columns[0].Type)
+	assert.Equal(t, 1000000.0, *retail.Columns[1].Max)
+	_, err = registry.Get("unknown")
+	assert.ErrorIs(t, err, ErrSchemaNotFound)
+
+	for _, invalid := range []string{
+		"columns:\n  - name: A\n    typ: number\n",
+		"columns:\n  - name: A\n    type: money\n",
+		"columns:\n  - name: A\n  - name: a\n",
+		"columns:\n  - name: A\n    min: 1\n",
+		"columns:\n  - name: A\n    type: number\n    min: 2\n    max: 1\n",
+		"columns:\n  - required: true\n",
+	} {
+		_, err := ParseSchemaProfile([]byte(invalid), false)
+		assert.Error(t, err, invalid)
+	}
+	_, err = ParseSchemaProfile([]byte(`{"colums": []}`), true)
+	assert.Error(t, err)
+}
+
+func TestProcessSchemaValidation(t *testing.T) {
+	logger := logrus.New()
+	logger.SetLevel(logrus.PanicLevel)
+	cs := NewCSVService(logger)
+	profile, err := ParseSchemaProfile([]byte(retailSchema), false)
+	require.NoError(t, err)
+	violations := func(csv string, parser string) []models.SchemaViolation {
+		_, err := cs.ProcessStream(strings.NewReader(csv), ProcessOptions{Schema: profile, Parser: parser})
+		var schemaErr *SchemaError
+		require.True(t, errors.As(err, &schemaErr), "%v", err)
+		assert.ErrorIs(t, err, ErrSchemaViolation)
+		return schemaErr.Violations
+	}
+
+	valid := "Channel,Department Name,Number of Sales,Units,Sold On\n" +
+		"store,Books,10.50,1,2024-03-04\n" +
+		"ONLINE,electronics,\"1,000\",,\n" +
+		"store,Total,1010.50,1,\n"
+	result, err := cs.ProcessStream(strings.NewReader(valid), ProcessOptions{Schema: profile})
+	require.NoError(t, err)
+	assert.Len(t, result.Summaries, 2)
+	// A TOTAL row followed by data is a department, which must be allowed
+	subtotal := violations("Department Name,Number of Sales\nBooks,1\nTotal,1\nToys,2\n", "")
+	assert.Equal(t, []models.SchemaViolation{{Row: 3, Rule: RuleDepartment, Value: "Total", Message: `row 3: department "Total" is not allowed`}}, subtotal)
+
+	// Missing required columns fail before any row is read
+	header := violations("Department Name,Sales\nBooks,1\n", "")
+	assert.Equal(t, []models.SchemaViolation{{
+		Row: 1, Column: "Number of Sales", Rule: RuleMissingColumn, Message: `required column "Number of Sales" is missing`,
+	}}, header)
+
+	invalid := "Department Name,Number of Sales,Units,Sold On,Channel\n" +
+		"Books,-1,1.5,someday,phone\n" +
+		"Garden,5,2,2024-03-04,store\n" +
+		"Toys,,x,,\n"
+	for _, parser := range []string{ParserStandard, ParserFast} {
+		rows := violations(invalid, parser)
+		rules := make([]string, len(rows))
+		for i, v := range rows {
+			rules[i] = v.Column + ":" + v.Rule
+		}
+		assert.Equal(t, []string{
+			"Number of Sales:min", "Units:type", "Sold On:type", "Channel:allowed",
+			":department",
+			"Number of Sales:required", "Units:type",
+		}, rules, parser)
+		assert.Equal(t, models.SchemaViolation{Row: 3, Rule: RuleDepartment, Value: "Garden", Message: `row 3: department "Garden" is not allowed`}, rows[4])
+	}
+
+	// Validation stops once enough violations are found
+	var many strings.Builder
+	many.WriteString("Department Name,Number of Sales\n")
+	for i := 0; i < 3*MaxSchemaViolations; i++ {
+		many.WriteString("Books,-1\n")
+	}
+	_, err = cs.ProcessStream(strings.NewReader(many.String()), ProcessOptions{Schema: profile})
+	var schemaErr *SchemaError
+	require.True(t, errors.As(err, &schemaErr))
+	assert.True(t, schemaErr.Truncated)
+	assert.Len(t, schemaErr.Violations, MaxSchemaViolations)
+	assert.Contains(t, err.Error(), "at least 100 violations")
+}
+
+func TestSchemaHeaderRules(t *testing.T) {
+	logger := logrus.New()
+	logger.SetLevel(logrus.PanicLevel)
+	cs := NewCSVService(logger)
+	profile, err := ParseSchemaProfile([]byte(`columns:
+  - name: Department Name
+    required: true
+  - name: Number of Sales
+    required: true
+    type: number
+  - name: Units
+    type: integer
+`), false)
+	require.NoError(t, err)
+	assert.Equal(t, DuplicatesReject, profile.Duplicates)
+	violations := func(profile *SchemaProfile, csv string) []models.SchemaViolation {
+		_, err := cs.ProcessStream(strings.NewReader(csv), ProcessOptions{Schema: profile})
+		if err == nil {
+			return nil
+		}
+		var schemaErr *SchemaError
+		require.True(t, errors.As(err, &schemaErr), "%v", err)
+		return schemaErr.Violations
+	}
+
+	// Columns may be reordered and others added unless the profile is strict
+	shuffled := "Units,Notes,Number of Sales,Department Name\n1,x,10,Books\n"
+	assert.Empty(t, violations(profile, shuffled))
+	strict := *profile
+	strict.Strict = true
+	assert.Equal(t, []models.SchemaViolation{
+		{Row: 1, Column: "Notes", Rule: RuleUnexpectedColumn, Message: `unexpected column "Notes" at column 2`},
+		{Row: 1, Column: "Department Name", Rule: RuleColumnOrder, Expected: "Department Name", Actual: "Units", Message: `column 1 is "Units", expected "Department Name"`},
+		{Row: 1, Column: "Units", Rule: RuleColumnOrder, Expected: "Units", Actual: "Department Name", Message: `column 4 is "Department Name", expected "Units"`},
+	}, violations(&strict, shuffled))
+	assert.Empty(t, violations(&strict, "Department Name,Number of Sales,Units\nBooks,10,1\n"))
+	missing := violations(&strict, "Department Name,Number of Sales\nBooks,10\n")
+	require.Len(t, missing, 1)
+	assert.Equal(t, RuleMissingColumn, missing[0].Rule, "strict profiles need every column")
+
+	// A duplicated column is rejected, or the first or last one is validated
+	duplicated := "Department Name,Units,Number of Sales,Units\nBooks,1,10,x\n"
+	assert.Equal(t, []models.SchemaViolation{
+		{Row: 1, Column: "Units", Rule: RuleDuplicateColumn, Message: `column "Units" appears 2 times, as columns 2, 4`},
+	}, violations(profile, duplicated))
+	first := *profile
+	first.Duplicates = DuplicatesFirst
+	assert.Empty(t, violations(&first, duplicated))
+	last := *profile
+	last.Duplicates = DuplicatesLast
+	rows := violations(&last, duplicated)
+	require.Len(t, rows, 1)
+	assert.Equal(t, "x", rows[0].Value)
+
+	_, err = ParseSchemaProfile([]byte("duplicates: merge\ncolumns: []\n"), false)
+	assert.ErrorContains(t, err, "unknown duplicates")
+}
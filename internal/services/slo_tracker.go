@@ -0,0 +1,206 @@
+package services
+
+import (
+	"fmt"
+	"sync"
+	"time"
+)
+
+// sloRetention is how much history the tracker keeps, in one-minute buckets
+const sloRetention = 24 * time.Hour
+
+// sloWindows are the rolling windows reported by Summary
+var sloWindows = []time.Duration{5 * time.Minute, time.Hour, 6 * time.Hour, 24 * time.Hour}
+
+// latencyBounds are the upper bounds of the latency histogram buckets; the
+// last bucket holds everything slower
+var latencyBounds = [...]time.Duration{
+	10 * time.Millisecond, 25 * time.Millisecond, 50 * time.Millisecond,
+	100 * time.Millisecond, 250 * time.Millisecond, 500 * time.Millisecond,
+	time.Second, 2500 * time.Millisecond, 5 * time.Second,
+	10 * time.Second, 30 * time.Second, time.Minute,
+}
+
+// SLOObjectives are the service level objectives requests are measured against
+type SLOObjectives struct {
+	// Availability is the target fraction of requests that must not fail
+	Availability float64 `json:"availability"`
+	// LatencyThresholdMs is the duration a request must finish within to count as fast
+	LatencyThresholdMs int64 `json:"latency_threshold_ms"`
+	// LatencyTarget is the target fraction of requests that must be fast
+	LatencyTarget float64 `json:"latency_target"`
+}
+
+// DefaultSLOObjectives are 99% availability and 99% of requests within 5s
+var DefaultSLOObjectives = SLOObjectives{Availability: 0.99, LatencyThresholdMs: 5000, LatencyTarget: 0.99}
+
+// Validate checks that targets are fractions below 1 and the threshold is positive
+func (o SLOObjectives) Validate() error {
+	if o.Availability <= 0 || o.Availability >= 1 {
+		return fmt.Errorf("availability target must be between 0 and 1")
+	}
+	if o.LatencyTarget <= 0 || o.LatencyTarget >= 1 {
+		return fmt.Errorf("latency target must be between 0 and 1")
+	}
+	if o.LatencyThresholdMs <= 0 {
+		return fmt.Errorf("latency threshold must be positive")
+	}
+	return nil
+}
+
+// SLOWindow summarizes the requests of one rolling window
+type SLOWindow struct {
+	Window      string  `json:"window"`
+	Requests    int     `json:"requests"`
+	Failures    int     `json:"failures"`
+	SuccessRate float64 `json:"success_rate"`
+	// ErrorBudgetBurnRate is the failure rate divided by the allowed failure
+	// rate: 1 spends the budget exactly over the SLO period, above 1 faster
+	ErrorBudgetBurnRate float64          `json:"error_budget_burn_rate"`
+	SlowRequests        int              `json:"slow_requests"`
+	LatencyBurnRate     float64          `json:"latency_burn_rate"`
+	LatencyMs           map[string]int64 `json:"latency_ms"`
+}
+
+// SLOSummary is the report returned by SLOTracker.Summary
+type SLOSummary struct {
+	Objectives SLOObjectives `json:"objectives"`
+	Since      time.Time     `json:"since"`
+	Windows    []SLOWindow   `json:"windows"`
+}
+
+// sloBucket holds the requests that finished within one minute
+type sloBucket struct {
+	minute   int64
+	requests int
+	failures int
+	slow     int
+	latency  [len(latencyBounds) + 1]int
+}
+
+// SLOTracker records request outcomes and latencies in one-minute buckets
+// covering the last 24 hours. History is kept in memory only.
+type SLOTracker struct {
+	objectives SLOObjectives
+	mu         sync.Mutex
+	buckets    []sloBucket
+	since      time.Time
+	now        func() time.Time
+}
+
+// NewSLOTracker creates a new SLOTracker measuring against objectives
+func NewSLOTracker(objectives SLOObjectives) *SLOTracker {
+	return &SLOTracker{
+		objectives: objectives,
+		buckets:    make([]sloBucket, int(sloRetention/time.Minute)),
+		since:      time.Now().UTC(),
+		now:        time.Now,
+	}
+}
+
+// Record adds a finished request. Failed requests are those the server is
+// responsible for, not client errors.
+func (st *SLOTracker) Record(duration time.Duration, failed bool) {
+	minute := st.now().Unix() / 60
+
+	st.mu.Lock()
+	defer st.mu.Unlock()
+	bucket := &st.buckets[minute%int64(len(st.buckets))]
+	if bucket.minute != minute {
+		*bucket = sloBucket{minute: minute}
+	}
+
+	bucket.requests++
+	if failed {
+		bucket.failures++
+	}
+	if duration > time.Duration(st.objectives.LatencyThresholdMs)*time.Millisecond {
+		bucket.slow++
+	}
+	bucket.latency[latencyBucket(duration)]++
+}
+
+// Summary reports every rolling window ending now
+func (st *SLOTracker) Summary() SLOSummary {
+	current := st.now().Unix() / 60
+
+	st.mu.Lock()
+	defer st.mu.Unlock()
+
+	summary := SLOSummary{Objectives: st.objectives, Since: st.since}
+	for _, window := range sloWindows {
+		minutes := int64(window / time.Minute)
+		var total sloBucket
+		for i := range st.buckets {
+			bucket := &st.buckets[i]
+			if bucket.requests == 0 || bucket.minute <= current-minutes || bucket.minute > current {
+				continue
+			}
+			total.requests += bucket.requests
+			total.failures += bucket.failures
+			total.slow += bucket.slow
+			for j, count := range bucket.latency {
+				total.latency[j] += count
+			}
+		}
+		summary.Windows = append(summary.Windows, st.windowSummary(formatWindow(window), &total))
+	}
+	return summary
+}
+
+// windowSummary derives rates and percentiles from a window's totals
+func (st *SLOTracker) windowSummary(name string, total *sloBucket) SLOWindow {
+	window := SLOWindow{
+		Window:       name,
+		Requests:     total.requests,
+		Failures:     total.failures,
+		SlowRequests: total.slow,
+		SuccessRate:  1,
+		LatencyMs:    make(map[string]int64),
+	}
+	if total.requests == 0 {
+		return window
+	}
+
+	failureRate := float64(total.failures) / float64(total.requests)
+	window.SuccessRate = 1 - failureRate
+	window.ErrorBudgetBurnRate = failureRate / (1 - st.objectives.Availability)
+	slowRate := float64(total.slow) / float64(total.requests)
+	window.LatencyBurnRate = slowRate / (1 - st.objectives.LatencyTarget)
+	for _, p := range []int{50, 90, 95, 99} {
+		window.LatencyMs[fmt.Sprintf("p%d", p)] = latencyPercentile(total.latency[:], total.requests, p).Milliseconds()
+	}
+	return window
+}
+
+// latencyBucket returns the histogram bucket for a duration
+func latencyBucket(d time.Duration) int {
+	for i, bound := range latencyBounds {
+		if d <= bound {
+			return i
+		}
+	}
+	return len(latencyBounds)
+}
+
+// latencyPercentile returns the upper bound of the bucket holding the p-th
+// percentile; percentiles in the overflow bucket report the largest bound
+func latencyPercentile(histogram []int, total, p int) time.Duration {
+	rank := (total*p + 99) / 100
+	seen := 0
+	for i, count := range histogram {
+		seen += count
+		if seen >= rank && i < len(latencyBounds) {
+			return latencyBounds[i]
+		}
+	}
+	return latencyBounds[len(latencyBounds)-1]
+}
+
+// formatWindow renders a window as "5m", "1h" or "24h"
+func formatWindow(d time.Duration) string {
+	if d < time.Hour {
+		return fmt.Sprintf("%dm", int(d/time.Minute))
+	}
+	return fmt.Sprintf("%dh", int(d/time.Hour))
+}
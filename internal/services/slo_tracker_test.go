@@ -0,0 +1,64 @@
+package services
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestSLOTrackerWindows(t *testing.T) {
+	now := time.Date(2024, 1, 15, 12, 0, 30, 0, time.UTC)
+	tracker := NewSLOTracker(SLOObjectives{Availability: 0.9, LatencyThresholdMs: 1000, LatencyTarget: 0.9})
+	tracker.now = func() time.Time { return now }
+
+	// Two hours ago: one slow failure
+	now = now.Add(-2 * time.Hour)
+	tracker.Record(3*time.Second, true)
+
+	// Now: nine fast successes and one failure
+	now = now.Add(2 * time.Hour)
+	for i := 0; i < 9; i++ {
+		tracker.Record(40*time.Millisecond, false)
+	}
+	tracker.Record(200*time.Millisecond, true)
+
+	summary := tracker.Summary()
+	require.Len(t, summary.Windows, 4)
+
+	recent := summary.Windows[0]
+	assert.Equal(t, "5m", recent.Window)
+	assert.Equal(t, 10, recent.Requests)
+	assert.Equal(t, 1, recent.Failures)
+	assert.InDelta(t, 0.9, recent.SuccessRate, 1e-9)
+	assert.InDelta(t, 1.0, recent.ErrorBudgetBurnRate, 1e-9)
+	assert.Equal(t, 0, recent.SlowRequests)
+	assert.Equal(t, int64(50), recent.LatencyMs["p50"])
+	assert.Equal(t, int64(250), recent.LatencyMs["p99"])
+
+	assert.Equal(t, "1h", summary.Windows[1].Window)
+	assert.Equal(t, 10, summary.Windows[1].Requests)
+
+	day := summary.Windows[3]
+	assert.Equal(t, "24h", day.Window)
+	assert.Equal(t, 11, day.Requests)
+	assert.Equal(t, 2, day.Failures)
+	assert.Equal(t, 1, day.SlowRequests)
+	assert.InDelta(t, (1.0/11)/0.1, day.LatencyBurnRate, 1e-9)
+	assert.Equal(t, int64(5000), day.LatencyMs["p99"])
+
+	// A day later the old buckets have expired
+	now = now.Add(25 * time.Hour)
+	for _, window := range tracker.Summary().Windows {
+		assert.Equal(t, 0, window.Requests)
+		assert.Equal(t, 1.0, window.SuccessRate)
+	}
+}
+
+func TestSLOObjectivesValidate(t *testing.T) {
+	assert.NoError(t, DefaultSLOObjectives.Validate())
+	assert.Error(t, SLOObjectives{Availability: 1, LatencyThresholdMs: 1, LatencyTarget: 0.9}.Validate())
+	assert.Error(t, SLOObjectives{Availability: 0.9, LatencyThresholdMs: 0, LatencyTarget: 0.9}.Validate())
+	assert.Error(t, SLOObjectives{Availability: 0.9, LatencyThresholdMs: 1, LatencyTarget: 0}.Validate())
+}
@@ -0,0 +1,157 @@
+package services
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path"
+	"path/filepath"
+	"strings"
+	"time"
+)
+
+// Storage backends
+const (
+	StorageLocal = "local"
+	StorageS3    = "s3"
+)
+
+// ErrObjectNotFound is returned when a storage key holds no object
+var ErrObjectNotFound = errors.New("object not found")
+
+// Storage keeps uploaded, result and output files by key: the file's name,
+// prefixed with tenants/<tenant>/ for files of tenants other than the
+// default one
+type Storage interface {
+	// Save stores the contents of r under key, replacing any existing object
+	Save(key string, r io.Reader) error
+	// Open returns the object stored under key, or ErrObjectNotFound
+	Open(key string) (io.ReadCloser, error)
+	// Delete removes the object stored under key; a missing object is not an error
+	Delete(key string) error
+	// SignedURL returns a URL clients can download the object from until expiry
+	SignedURL(key string, expiry time.Duration) (string, error)
+}
+
+// LocalStorage keeps files in a directory whose files the API serves itself
+type LocalStorage struct {
+	dir string
+}
+
+// NewLocalStorage creates a LocalStorage in dir
+func NewLocalStorage(dir string) *LocalStorage {
+	return &LocalStorage{dir: dir}
+}
+
+// path returns the file of a key, rejecting keys with elements that are
+// empty or start with a dot, so keys can't leave the directory
+func (ls *LocalStorage) path(key string) (string, error) {
+	for _, element := range strings.Split(key, "/") {
+		if element == "" || strings.HasPrefix(element, ".") || strings.ContainsRune(element, filepath.Separator) {
+			return "", fmt.Errorf("invalid storage key %q", key)
+		}
+	}
+	return filepath.Join(ls.dir, filepath.FromSlash(key)), nil
+}
+
+// Save writes r to the key's file. Saving a file onto itself, as happens
+// when the working directory is the storage directory, does nothing.
+func (ls *LocalStorage) Save(key string, r io.Reader) error {
+	path, err := ls.path(key)
+	if err != nil {
+		return err
+	}
+	if file, ok := r.(*os.File); ok {
+		if src, err := file.Stat(); err == nil {
+			if dst, err := os.Stat(path); err == nil && os.SameFile(src, dst) {
+				return nil
+			}
+		}
+	}
+
+	if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+		return fmt.Errorf("failed to create %s: %w", key, err)
+	}
+	tmp, err := os.CreateTemp(filepath.Dir(path), "."+filepath.Base(path)+".tmp-*")
+	if err != nil {
+		return fmt.Errorf("failed to create %s: %w", key, err)
+	}
+	defer os.Remove(tmp.Name())
+	if _, err := io.Copy(tmp, r); err != nil {
+		tmp.Close()
+		return fmt.Errorf("failed to write %s: %w", key, err)
+	}
+	if err := tmp.Close(); err != nil {
+		return fmt.Errorf("failed to write %s: %w", key, err)
+	}
+	if err := os.Rename(tmp.Name(), path); err != nil {
+		return fmt.Errorf("failed to save %s: %w", key, err)
+	}
+	return nil
+}
+
+// Open opens the key's file
+func (ls *LocalStorage) Open(key string) (io.ReadCloser, error) {
+	path, err := ls.path(key)
+	if err != nil {
+		return nil, err
+	}
+	file, err := os.Open(path)
+	if errors.Is(err, os.ErrNotExist) {
+		return nil, fmt.Errorf("%w: %s", ErrObjectNotFound, key)
+	}
+	return file, err
+}
+
+// Delete removes the key's file
+func (ls *LocalStorage) Delete(key string) error {
+	path, err := ls.path(key)
+	if err != nil {
+		return err
+	}
+	if err := os.Remove(path); err != nil && !errors.Is(err, os.ErrNotExist) {
+		return fmt.Errorf("failed to delete %s: %w", key, err)
+	}
+	return nil
+}
+
+// SignedURL returns the download route of the key's file. Local files are
+// served by the API itself, so the URL carries no signature or expiry.
+func (ls *LocalStorage) SignedURL(key string, expiry time.Duration) (string, error) {
+	if _, err := ls.path(key); err != nil {
+		return "", err
+	}
+	return LocalDownloadURL(key), nil
+}
+
+// LocalDownloadURL returns the API route that serves a stored file: the
+// result download route for results, the file download route otherwise.
+// Both find a tenant's files from the tenant of the request.
+func LocalDownloadURL(key string) string {
+	name := path.Base(key)
+	if resultID, ok := resultIDOf(name); ok {
+		return "/api/v1/results/" + resultID + "/download"
+	}
+	return "/api/v1/files/" + name
+}
+
+// StorageConfig selects and configures a storage backend
+type StorageConfig struct {
+	// Backend is StorageLocal or StorageS3
+	Backend string
+	S3      S3Config
+}
+
+// NewStorage creates the configured storage backend. Local storage keeps
+// files in dir.
+func NewStorage(config StorageConfig, dir string) (Storage, error) {
+	switch config.Backend {
+	case "", StorageLocal:
+		return NewLocalStorage(dir), nil
+	case StorageS3:
+		return NewS3Storage(config.S3)
+	default:
+		return nil, fmt.Errorf("invalid storage backend %q: expected local or s3", config.Backend)
+	}
+}
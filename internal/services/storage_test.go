@@ -0,0 +1,97 @@
+package services
+
+import (
+	"errors"
+	"github.com/mussietl/csv-sales-api/internal/models"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/sirupsen/logrus"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestLocalStorage(t *testing.T) {
+	dir := t.TempDir()
+	ls := NewLocalStorage(dir)
+
+	require.NoError(t, ls.Save("result_a.csv", strings.NewReader("a,1\n")))
+	r, err := ls.Open("result_a.csv")
+	require.NoError(t, err)
+	data, err := io.ReadAll(r)
+	r.Close()
+	require.NoError(t, err)
+	assert.Equal(t, "a,1\n", string(data))
+
+	// Saving a file onto itself leaves it intact
+	file, err := os.Open(filepath.Join(dir, "result_a.csv"))
+	require.NoError(t, err)
+	require.NoError(t, ls.Save("result_a.csv", file))
+	file.Close()
+	data, err = os.ReadFile(filepath.Join(dir, "result_a.csv"))
+	require.NoError(t, err)
+	assert.Equal(t, "a,1\n", string(data))
+
+	url, err := ls.SignedURL("result_a.csv", time.Hour)
+	require.NoError(t, err)
+	assert.Equal(t, "/api/v1/results/a/download", url)
+
+	require.NoError(t, ls.Delete("result_a.csv"))
+	require.NoError(t, ls.Delete("result_a.csv"), "deleting a missing object is not an error")
+	_, err = ls.Open("result_a.csv")
+	assert.True(t, errors.Is(err, ErrObjectNotFound))
+
+	// Keys may have a tenant prefix
+	require.NoError(t, ls.Save("tenants/acme/result_b.csv", strings.NewReader("b,2\n")))
+	_, err = os.Stat(filepath.Join(dir, "tenants", "acme", "result_b.csv"))
+	require.NoError(t, err)
+	url, err = ls.SignedURL("tenants/acme/result_b.csv", time.Hour)
+	require.NoError(t, err)
+	assert.Equal(t, "/api/v1/results/b/download", url)
+
+	for _, key := range []string{"", "../escape.csv", "tenants/../../escape.csv", "tenants//dir.csv", ".hidden", "tenants/.acme/a.csv"} {
+		assert.Error(t, ls.Save(key, strings.NewReader("x")), key)
+	}
+
+	_, err = NewStorage(StorageConfig{Backend: "ftp"}, dir)
+	assert.Error(t, err)
+	_, err = NewStorage(StorageConfig{Backend: StorageS3}, dir)
+	assert.Error(t, err, "s3 requires a bucket")
+}
+
+func TestFileServiceRestoresFromStorage(t *testing.T) {
+	logger := logrus.New()
+	logger.SetLevel(logrus.ErrorLevel)
+	workDir, remoteDir := t.TempDir(), t.TempDir()
+	fs := NewFileService(workDir, logger)
+	fs.SetStorage(NewLocalStorage(remoteDir), 0)
+
+	resultPath, err := fs.SaveResultFile([]DepartmentSummary{{Department: "Books", TotalSales: models.WholeAmount(3)}})
+	require.NoError(t, err)
+	_, err = os.Stat(filepath.Join(remoteDir, filepath.Base(resultPath)))
+	require.NoError(t, err, "results are saved to storage")
+
+	// A lost working copy, e.g. after a container restart, is restored
+	require.NoError(t, os.Remove(resultPath))
+	restored, err := fs.ResultPath(fs.ResultID(resultPath))
+	require.NoError(t, err)
+	data, err := os.ReadFile(restored)
+	require.NoError(t, err)
+	assert.Equal(t, "Department Name,Total Number of Sales\nBooks,3\n", string(data))
+
+	uploadID := "0b6d5c3e-9a7b-4b7a-8d0e-2f1c3a4b5c6d"
+	require.NoError(t, os.WriteFile(filepath.Join(remoteDir, "upload_"+uploadID+".xlsx"), []byte("xlsx"), 0644))
+	uploadPath, err := fs.UploadPath(uploadID)
+	require.NoError(t, err)
+	assert.Equal(t, filepath.Join(workDir, "upload_"+uploadID+".xlsx"), uploadPath)
+
+	require.NoError(t, fs.DeleteUpload(uploadID))
+	_, err = os.Stat(filepath.Join(remoteDir, "upload_"+uploadID+".xlsx"))
+	assert.True(t, errors.Is(err, os.ErrNotExist), "deleting an upload removes it from storage")
+	_, err = fs.UploadPath(uploadID)
+	assert.True(t, errors.Is(err, ErrUploadNotFound))
+}
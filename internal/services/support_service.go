@@ -0,0 +1,186 @@
+package services
+
+import (
+	"bufio"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"time"
+	"unicode"
+
+	"github.com/google/uuid"
+	"github.com/sirupsen/logrus"
+)
+
+// ErrBundleNotFound is returned when a support bundle does not exist
+var ErrBundleNotFound = errors.New("support bundle not found")
+
+// maxSampleLineBytes bounds each line copied into a support bundle sample
+const maxSampleLineBytes = 4096
+
+// SupportBundle captures what is needed to reproduce a processing request
+type SupportBundle struct {
+	ID             string            `json:"id"`
+	CreatedAt      time.Time         `json:"created_at"`
+	Tenant         string            `json:"tenant"`
+	UploadID       string            `json:"upload_id"`
+	Filename       string            `json:"filename"`
+	Size           int64             `json:"size"`
+	Params         map[string]string `json:"params"`
+	Headers        map[string]string `json:"headers"`
+	Sample         string            `json:"sample"`
+	SampleRows     int               `json:"sample_rows"`
+	ResponseStatus int               `json:"response_status"`
+	Response       json.RawMessage   `json:"response,omitempty"`
+}
+
+// SupportBundleInfo is the listing form of a bundle
+type SupportBundleInfo struct {
+	ID             string    `json:"id"`
+	CreatedAt      time.Time `json:"created_at"`
+	Tenant         string    `json:"tenant"`
+	Filename       string    `json:"filename"`
+	ResponseStatus int       `json:"response_status"`
+}
+
+// SupportService stores support bundles for admins to retrieve
+type SupportService struct {
+	dir        string
+	sampleRows int
+	logger     *logrus.Logger
+}
+
+// NewSupportService creates a new SupportService storing bundles in dir
+func NewSupportService(dir string, sampleRows int, logger *logrus.Logger) *SupportService {
+	return &SupportService{
+		dir:        dir,
+		sampleRows: sampleRows,
+		logger:     logger,
+	}
+}
+
+// Sample returns the header plus the first data rows of a file with every
+// data value redacted, preserving its shape (delimiters, quotes, whitespace,
+// digit and letter positions) so parsing issues can still be reproduced
+func (ss *SupportService) Sample(filePath string) (string, int, error) {
+	file, err := os.Open(filePath)
+	if err != nil {
+		return "", 0, fmt.Errorf("failed to open file: %w", err)
+	}
+	defer file.Close()
+
+	reader := bufio.NewReader(file)
+	var sample strings.Builder
+	lines := 0
+	for lines <= ss.sampleRows {
+		line, err := reader.ReadString('\n')
+		if len(line) > maxSampleLineBytes {
+			line = line[:maxSampleLineBytes] + "…\n"
+		}
+		if line != "" {
+			if lines == 0 {
+				sample.WriteString(line)
+			} else {
+				sample.WriteString(RedactSample(line))
+			}
+			lines++
+		}
+		if err != nil {
+			break
+		}
+	}
+	return sample.String(), lines - 1, nil
+}
+
+// RedactSample masks letters and digits while keeping punctuation, whitespace
+// and character classes: letters become x/X and digits become 9
+func RedactSample(s string) string {
+	return strings.Map(func(r rune) rune {
+		switch {
+		case unicode.IsDigit(r):
+			return '9'
+		case unicode.IsUpper(r):
+			return 'X'
+		case unicode.IsLetter(r):
+			return 'x'
+		default:
+			return r
+		}
+	}, s)
+}
+
+// Save stores a bundle, assigning its creation time and, if unset, its ID
+func (ss *SupportService) Save(bundle *SupportBundle) error {
+	if bundle.ID == "" {
+		bundle.ID = uuid.New().String()
+	}
+	bundle.CreatedAt = time.Now().UTC()
+
+	data, err := json.MarshalIndent(bundle, "", "  ")
+	if err != nil {
+		return fmt.Errorf("failed to encode support bundle: %w", err)
+	}
+	if err := writeFileAtomic(ss.bundlePath(bundle.ID), data, 0600); err != nil {
+		return err
+	}
+
+	ss.logger.Infof("Recorded support bundle %s for %s", bundle.ID, bundle.Filename)
+	return nil
+}
+
+// Get loads a bundle by ID
+func (ss *SupportService) Get(id string) (*SupportBundle, error) {
+	if _, err := uuid.Parse(id); err != nil {
+		return nil, ErrBundleNotFound
+	}
+
+	data, err := os.ReadFile(ss.bundlePath(id))
+	if errors.Is(err, os.ErrNotExist) {
+		return nil, ErrBundleNotFound
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to read support bundle: %w", err)
+	}
+
+	var bundle SupportBundle
+	if err := json.Unmarshal(data, &bundle); err != nil {
+		return nil, fmt.Errorf("failed to decode support bundle: %w", err)
+	}
+	return &bundle, nil
+}
+
+// List returns all bundles, newest first
+func (ss *SupportService) List() ([]SupportBundleInfo, error) {
+	matches, err := filepath.Glob(filepath.Join(ss.dir, "*.json"))
+	if err != nil {
+		return nil, fmt.Errorf("failed to list support bundles: %w", err)
+	}
+
+	bundles := make([]SupportBundleInfo, 0, len(matches))
+	for _, path := range matches {
+		bundle, err := ss.Get(strings.TrimSuffix(filepath.Base(path), ".json"))
+		if err != nil {
+			ss.logger.Warnf("Skipping unreadable support bundle %s: %v", path, err)
+			continue
+		}
+		bundles = append(bundles, SupportBundleInfo{
+			ID:             bundle.ID,
+			CreatedAt:      bundle.CreatedAt,
+			Tenant:         bundle.Tenant,
+			Filename:       bundle.Filename,
+			ResponseStatus: bundle.ResponseStatus,
+		})
+	}
+	sort.Slice(bundles, func(i, j int) bool {
+		return bundles[i].CreatedAt.After(bundles[j].CreatedAt)
+	})
+	return bundles, nil
+}
+
+func (ss *SupportService) bundlePath(id string) string {
+	return filepath.Join(ss.dir, id+".json")
+}
@@ -0,0 +1,61 @@
+package services
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/sirupsen/logrus"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestRedactSample(t *testing.T) {
+	assert.Equal(t, "Xxxxx, \"Xxx 99\",99.9\n", RedactSample("Books, \"Vol 12\",45.6\n"))
+	assert.Equal(t, "", RedactSample(""))
+}
+
+func TestSupportServiceSample(t *testing.T) {
+	logger := logrus.New()
+	logger.SetLevel(logrus.ErrorLevel)
+	ss := NewSupportService(t.TempDir(), 2, logger)
+
+	path := filepath.Join(t.TempDir(), "sales.csv")
+	require.NoError(t, os.WriteFile(path, []byte("Department Name,Number of Sales\nBooks,10\nToys,20\nGames,30\n"), 0644))
+
+	sample, rows, err := ss.Sample(path)
+	require.NoError(t, err)
+	assert.Equal(t, 2, rows)
+	assert.Equal(t, "Department Name,Number of Sales\nXxxxx,99\nXxxx,99\n", sample)
+
+	_, _, err = ss.Sample(filepath.Join(t.TempDir(), "missing.csv"))
+	assert.Error(t, err)
+}
+
+func TestSupportServiceSaveGetList(t *testing.T) {
+	logger := logrus.New()
+	logger.SetLevel(logrus.ErrorLevel)
+	ss := NewSupportService(t.TempDir(), 20, logger)
+
+	first := &SupportBundle{Filename: "a.csv", ResponseStatus: 200}
+	require.NoError(t, ss.Save(first))
+	assert.NotEmpty(t, first.ID)
+
+	second := &SupportBundle{ID: "7f1c2a34-5b6d-4e8f-9a0b-1c2d3e4f5a6b", Filename: "b.csv", ResponseStatus: 400, Params: map[string]string{"order": "first_seen"}}
+	require.NoError(t, ss.Save(second))
+
+	got, err := ss.Get(second.ID)
+	require.NoError(t, err)
+	assert.Equal(t, "b.csv", got.Filename)
+	assert.Equal(t, "first_seen", got.Params["order"])
+
+	list, err := ss.List()
+	require.NoError(t, err)
+	require.Len(t, list, 2)
+	assert.Equal(t, second.ID, list[0].ID)
+
+	_, err = ss.Get("../api_keys")
+	assert.ErrorIs(t, err, ErrBundleNotFound)
+	_, err = ss.Get("00000000-0000-0000-0000-000000000000")
+	assert.ErrorIs(t, err, ErrBundleNotFound)
+}
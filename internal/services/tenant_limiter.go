@@ -0,0 +1,175 @@
+package services
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"sort"
+	"sync"
+
+	"github.com/sirupsen/logrus"
+)
+
+// TenantConcurrency describes a tenant's concurrency cap and current usage
+type TenantConcurrency struct {
+	Tenant string `json:"tenant"`
+	Limit  int    `json:"limit"`
+	Active int    `json:"active"`
+	// Override is true when the limit was set for this tenant rather than inherited
+	Override bool `json:"override"`
+}
+
+// TenantLimiter caps the number of simultaneous processing jobs per tenant.
+// A limit of 0 means unlimited.
+type TenantLimiter struct {
+	path         string
+	defaultLimit int
+	mu           sync.Mutex
+	limits       map[string]int
+	active       map[string]int
+	logger       *logrus.Logger
+}
+
+// NewTenantLimiter creates a TenantLimiter, loading per-tenant overrides from path
+func NewTenantLimiter(path string, defaultLimit int, logger *logrus.Logger) (*TenantLimiter, error) {
+	tl := &TenantLimiter{
+		path:         path,
+		defaultLimit: defaultLimit,
+		limits:       make(map[string]int),
+		active:       make(map[string]int),
+		logger:       logger,
+	}
+
+	data, err := os.ReadFile(path)
+	if err != nil && !errors.Is(err, os.ErrNotExist) {
+		return nil, fmt.Errorf("failed to read tenant limits: %w", err)
+	}
+	if err == nil {
+		if err := json.Unmarshal(data, &tl.limits); err != nil {
+			return nil, fmt.Errorf("failed to decode tenant limits: %w", err)
+		}
+	}
+	return tl, nil
+}
+
+// TryAcquire reserves a processing slot for tenant. It returns a release
+// function and true on success, or false when the tenant is at its cap.
+func (tl *TenantLimiter) TryAcquire(tenant string) (func(), bool) {
+	tl.mu.Lock()
+	defer tl.mu.Unlock()
+
+	limit := tl.limitFor(tenant)
+	if limit > 0 && tl.active[tenant] >= limit {
+		return nil, false
+	}
+	tl.active[tenant]++
+
+	var once sync.Once
+	return func() {
+		once.Do(func() {
+			tl.mu.Lock()
+			defer tl.mu.Unlock()
+			tl.active[tenant]--
+			if tl.active[tenant] <= 0 {
+				delete(tl.active, tenant)
+			}
+		})
+	}, true
+}
+
+// SetLimit overrides a tenant's cap and persists it
+func (tl *TenantLimiter) SetLimit(tenant string, limit int) error {
+	if limit < 0 {
+		return fmt.Errorf("limit must be zero (unlimited) or positive")
+	}
+
+	tl.mu.Lock()
+	defer tl.mu.Unlock()
+	previous, existed := tl.limits[tenant]
+	tl.limits[tenant] = limit
+	if err := tl.save(); err != nil {
+		if existed {
+			tl.limits[tenant] = previous
+		} else {
+			delete(tl.limits, tenant)
+		}
+		return err
+	}
+	tl.logger.Infof("Set concurrency limit for tenant %s to %d", tenant, limit)
+	return nil
+}
+
+// ResetLimit removes a tenant's override so it inherits the default cap
+func (tl *TenantLimiter) ResetLimit(tenant string) error {
+	tl.mu.Lock()
+	defer tl.mu.Unlock()
+	previous, existed := tl.limits[tenant]
+	if !existed {
+		return nil
+	}
+	delete(tl.limits, tenant)
+	if err := tl.save(); err != nil {
+		tl.limits[tenant] = previous
+		return err
+	}
+	tl.logger.Infof("Reset concurrency limit for tenant %s", tenant)
+	return nil
+}
+
+// Get returns the cap and usage of a single tenant
+func (tl *TenantLimiter) Get(tenant string) TenantConcurrency {
+	tl.mu.Lock()
+	defer tl.mu.Unlock()
+	_, override := tl.limits[tenant]
+	return TenantConcurrency{
+		Tenant:   tenant,
+		Limit:    tl.limitFor(tenant),
+		Active:   tl.active[tenant],
+		Override: override,
+	}
+}
+
+// List returns every tenant that has an override or active jobs
+func (tl *TenantLimiter) List() []TenantConcurrency {
+	tl.mu.Lock()
+	tenants := make(map[string]bool)
+	for tenant := range tl.limits {
+		tenants[tenant] = true
+	}
+	for tenant := range tl.active {
+		tenants[tenant] = true
+	}
+	tl.mu.Unlock()
+
+	list := make([]TenantConcurrency, 0, len(tenants))
+	for tenant := range tenants {
+		list = append(list, tl.Get(tenant))
+	}
+	sort.Slice(list, func(i, j int) bool {
+		return list[i].Tenant < list[j].Tenant
+	})
+	return list
+}
+
+// DefaultLimit returns the cap applied to tenants without an override
+func (tl *TenantLimiter) DefaultLimit() int {
+	return tl.defaultLimit
+}
+
+// limitFor returns the effective cap for tenant. Callers must hold tl.mu.
+func (tl *TenantLimiter) limitFor(tenant string) int {
+	if limit, ok := tl.limits[tenant]; ok {
+		return limit
+	}
+	return tl.defaultLimit
+}
+
+// save persists the overrides. Callers must hold tl.mu.
+func (tl *TenantLimiter) save() error {
+	data, err := json.MarshalIndent(tl.limits, "", "  ")
+	if err != nil {
+		return fmt.Errorf("failed to encode tenant limits: %w", err)
+	}
+	return writeFileAtomic(tl.path, data, 0600)
+}
@@ -0,0 +1,56 @@
+package services
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/sirupsen/logrus"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestTenantLimiter(t *testing.T) {
+	tempDir, err := os.MkdirTemp("", "test_limits")
+	require.NoError(t, err)
+	defer os.RemoveAll(tempDir)
+
+	logger := logrus.New()
+	logger.SetLevel(logrus.ErrorLevel)
+	path := filepath.Join(tempDir, "tenant_limits.json")
+
+	limiter, err := NewTenantLimiter(path, 2, logger)
+	require.NoError(t, err)
+
+	// Default cap applies to every tenant independently
+	releaseA1, ok := limiter.TryAcquire("a")
+	require.True(t, ok)
+	_, ok = limiter.TryAcquire("a")
+	require.True(t, ok)
+	_, ok = limiter.TryAcquire("a")
+	assert.False(t, ok)
+	_, ok = limiter.TryAcquire("b")
+	assert.True(t, ok)
+
+	releaseA1()
+	releaseA1() // releasing twice must not free a second slot
+	assert.Equal(t, 1, limiter.Get("a").Active)
+	_, ok = limiter.TryAcquire("a")
+	assert.True(t, ok)
+
+	// Overrides persist and zero means unlimited
+	require.NoError(t, limiter.SetLimit("b", 0))
+	for i := 0; i < 10; i++ {
+		_, ok = limiter.TryAcquire("b")
+		require.True(t, ok)
+	}
+	assert.Error(t, limiter.SetLimit("b", -1))
+
+	reloaded, err := NewTenantLimiter(path, 2, logger)
+	require.NoError(t, err)
+	assert.Equal(t, TenantConcurrency{Tenant: "b", Limit: 0, Override: true}, reloaded.Get("b"))
+
+	require.NoError(t, limiter.ResetLimit("b"))
+	assert.Equal(t, 2, limiter.Get("b").Limit)
+	assert.Len(t, limiter.List(), 2)
+}
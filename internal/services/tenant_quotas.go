@@ -0,0 +1,282 @@
+package services
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"sync"
+	"time"
+
+	"github.com/sirupsen/logrus"
+)
+
+// ErrQuotaExceeded is returned when a tenant has used up one of its quotas
+var ErrQuotaExceeded = errors.New("tenant quota exceeded")
+
+// Quotas a tenant can exceed
+const (
+	QuotaStorage     = "storage"
+	QuotaMonthlyRows = "monthly_rows"
+)
+
+// quotaMonthLayout names the calendar month (UTC) rows are counted in
+const quotaMonthLayout = "2006-01"
+
+// TenantQuota caps what a tenant may use. Zero fields are unlimited.
+type TenantQuota struct {
+	// StorageBytes caps the size of the tenant's stored files
+	StorageBytes int64 `json:"storage_bytes"`
+	// MonthlyRows caps the CSV rows processed for the tenant per calendar month
+	MonthlyRows int64 `json:"monthly_rows"`
+}
+
+// QuotaError describes the quota a tenant has used up
+type QuotaError struct {
+	Tenant string
+	// Quota is QuotaStorage or QuotaMonthlyRows
+	Quota string
+	Limit int64
+	Used  int64
+	// ResetAt is when a monthly quota starts over
+	ResetAt time.Time
+}
+
+func (e *QuotaError) Error() string {
+	return fmt.Sprintf("tenant %s has used %d of its %s quota of %d", e.Tenant, e.Used, e.Quota, e.Limit)
+}
+
+func (e *QuotaError) Unwrap() error {
+	return ErrQuotaExceeded
+}
+
+// TenantQuotaStatus describes a tenant's quotas and what it has used
+type TenantQuotaStatus struct {
+	Tenant string      `json:"tenant"`
+	Quota  TenantQuota `json:"quota"`
+	// Override is true when the quotas were set for this tenant rather than inherited
+	Override     bool   `json:"override"`
+	StorageBytes int64  `json:"storage_bytes"`
+	Month        string `json:"month"`
+	MonthlyRows  int64  `json:"monthly_rows"`
+}
+
+// monthlyRows counts the rows processed for a tenant in a month
+type monthlyRows struct {
+	Month string `json:"month"`
+	Rows  int64  `json:"rows"`
+}
+
+// tenantQuotaState is what TenantQuotas persists
+type tenantQuotaState struct {
+	Quotas map[string]TenantQuota `json:"quotas"`
+	Rows   map[string]monthlyRows `json:"rows"`
+}
+
+// TenantQuotas enforces per-tenant quotas on stored bytes and on rows
+// processed per calendar month. Overrides and the rows counted this month
+// are persisted to a JSON file; storage is measured from the tenant's files.
+type TenantQuotas struct {
+	path     string
+	defaults TenantQuota
+	files    *FileService
+	mu       sync.Mutex
+	state    tenantQuotaState
+	now      func() time.Time
+	logger   *logrus.Logger
+}
+
+// NewTenantQuotas creates a TenantQuotas applying defaults to tenants
+// without overrides, loading overrides and row counts from path
+func NewTenantQuotas(path string, defaults TenantQuota, files *FileService, logger *logrus.Logger) (*TenantQuotas, error) {
+	tq := &TenantQuotas{
+		path:     path,
+		defaults: defaults,
+		files:    files,
+		state:    tenantQuotaState{Quotas: make(map[string]TenantQuota), Rows: make(map[string]monthlyRows)},
+		now:      time.Now,
+		logger:   logger,
+	}
+
+	data, err := os.ReadFile(path)
+	if err != nil && !errors.Is(err, os.ErrNotExist) {
+		return nil, fmt.Errorf("failed to read tenant quotas: %w", err)
+	}
+	if err == nil {
+		if err := json.Unmarshal(data, &tq.state); err != nil {
+			return nil, fmt.Errorf("failed to decode tenant quotas: %w", err)
+		}
+		if tq.state.Quotas == nil {
+			tq.state.Quotas = make(map[string]TenantQuota)
+		}
+		if tq.state.Rows == nil {
+			tq.state.Rows = make(map[string]monthlyRows)
+		}
+	}
+	return tq, nil
+}
+
+// Defaults returns the quotas of tenants without overrides
+func (tq *TenantQuotas) Defaults() TenantQuota {
+	return tq.defaults
+}
+
+// HandleEvent counts the rows of a completed processing run against its
+// tenant's monthly quota; subscribe it to EventProcessingCompleted
+func (tq *TenantQuotas) HandleEvent(e Event) {
+	if e.Type != EventProcessingCompleted || e.Rows == nil || e.Rows.Total == 0 {
+		return
+	}
+	tenant := e.Tenant
+	if tenant == "" {
+		tenant = DefaultTenantID
+	}
+
+	tq.mu.Lock()
+	defer tq.mu.Unlock()
+	month := tq.now().UTC().Format(quotaMonthLayout)
+	counted := tq.state.Rows[tenant]
+	if counted.Month != month {
+		counted = monthlyRows{Month: month}
+	}
+	counted.Rows += int64(e.Rows.Total)
+	tq.state.Rows[tenant] = counted
+	if err := tq.save(); err != nil {
+		tq.logger.Errorf("Failed to save rows processed for tenant %s: %v", tenant, err)
+	}
+}
+
+// Check returns a *QuotaError when the tenant has used up its monthly rows,
+// or when storing incomingBytes more would take it over its storage quota.
+// Open upload sessions count against storage at their declared size.
+func (tq *TenantQuotas) Check(tenant string, incomingBytes int64) error {
+	tq.mu.Lock()
+	quota := tq.quotaFor(tenant)
+	now := tq.now().UTC()
+	rows := tq.rowsFor(tenant, now)
+	tq.mu.Unlock()
+
+	if quota.MonthlyRows > 0 && rows >= quota.MonthlyRows {
+		return &QuotaError{
+			Tenant:  tenant,
+			Quota:   QuotaMonthlyRows,
+			Limit:   quota.MonthlyRows,
+			Used:    rows,
+			ResetAt: time.Date(now.Year(), now.Month()+1, 1, 0, 0, 0, 0, time.UTC),
+		}
+	}
+	if quota.StorageBytes > 0 {
+		used, err := tq.storageUsed(tenant)
+		if err != nil {
+			return err
+		}
+		if used+incomingBytes > quota.StorageBytes {
+			return &QuotaError{Tenant: tenant, Quota: QuotaStorage, Limit: quota.StorageBytes, Used: used}
+		}
+	}
+	return nil
+}
+
+// Get returns a tenant's quotas and usage
+func (tq *TenantQuotas) Get(tenant string) (TenantQuotaStatus, error) {
+	tq.mu.Lock()
+	_, override := tq.state.Quotas[tenant]
+	now := tq.now().UTC()
+	status := TenantQuotaStatus{
+		Tenant:      tenant,
+		Quota:       tq.quotaFor(tenant),
+		Override:    override,
+		Month:       now.Format(quotaMonthLayout),
+		MonthlyRows: tq.rowsFor(tenant, now),
+	}
+	tq.mu.Unlock()
+
+	used, err := tq.storageUsed(tenant)
+	if err != nil {
+		return status, err
+	}
+	status.StorageBytes = used
+	return status, nil
+}
+
+// storageUsed returns the size of a tenant's stored files plus the declared
+// size of its open upload sessions
+func (tq *TenantQuotas) storageUsed(tenant string) (int64, error) {
+	files := tq.files.ForTenant(tenant)
+	usage, err := files.StorageUsage()
+	if err != nil {
+		return 0, fmt.Errorf("failed to measure storage of tenant %s: %w", tenant, err)
+	}
+	sessions, err := files.UploadSessionBytes()
+	if err != nil {
+		return 0, fmt.Errorf("failed to measure storage of tenant %s: %w", tenant, err)
+	}
+	return usage.Bytes + sessions, nil
+}
+
+// SetQuota overrides a tenant's quotas and persists them
+func (tq *TenantQuotas) SetQuota(tenant string, quota TenantQuota) error {
+	if quota.StorageBytes < 0 || quota.MonthlyRows < 0 {
+		return fmt.Errorf("quotas must be zero (unlimited) or positive")
+	}
+
+	tq.mu.Lock()
+	defer tq.mu.Unlock()
+	previous, existed := tq.state.Quotas[tenant]
+	tq.state.Quotas[tenant] = quota
+	if err := tq.save(); err != nil {
+		if existed {
+			tq.state.Quotas[tenant] = previous
+		} else {
+			delete(tq.state.Quotas, tenant)
+		}
+		return err
+	}
+	tq.logger.Infof("Set quotas of tenant %s to %d stored bytes and %d rows a month", tenant, quota.StorageBytes, quota.MonthlyRows)
+	return nil
+}
+
+// ResetQuota removes a tenant's override so it inherits the default quotas
+func (tq *TenantQuotas) ResetQuota(tenant string) error {
+	tq.mu.Lock()
+	defer tq.mu.Unlock()
+	previous, existed := tq.state.Quotas[tenant]
+	if !existed {
+		return nil
+	}
+	delete(tq.state.Quotas, tenant)
+	if err := tq.save(); err != nil {
+		tq.state.Quotas[tenant] = previous
+		return err
+	}
+	tq.logger.Infof("Reset quotas of tenant %s", tenant)
+	return nil
+}
+
+// quotaFor returns a tenant's override or the default quotas; the caller
+// must hold mu
+func (tq *TenantQuotas) quotaFor(tenant string) TenantQuota {
+	if quota, ok := tq.state.Quotas[tenant]; ok {
+		return quota
+	}
+	return tq.defaults
+}
+
+// rowsFor returns the rows processed for a tenant in the month of now; the
+// caller must hold mu
+func (tq *TenantQuotas) rowsFor(tenant string, now time.Time) int64 {
+	counted := tq.state.Rows[tenant]
+	if counted.Month != now.Format(quotaMonthLayout) {
+		return 0
+	}
+	return counted.Rows
+}
+
+// save persists the state; the caller must hold mu
+func (tq *TenantQuotas) save() error {
+	data, err := json.MarshalIndent(tq.state, "", "  ")
+	if err != nil {
+		return fmt.Errorf("failed to encode tenant quotas: %w", err)
+	}
+	return writeFileAtomic(tq.path, data, 0600)
+}
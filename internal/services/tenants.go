@@ -0,0 +1,98 @@
+package services
+
+import (
+	"os"
+	"path/filepath"
+	"regexp"
+)
+
+// DefaultTenantID is the tenant of requests that do not name one. Its files
+// stay in the uploads directory itself.
+const DefaultTenantID = "default"
+
+// tenantsDir is the directory below the uploads directory that holds a
+// directory per tenant, and the storage key prefix of their files
+const tenantsDir = "tenants"
+
+// tenantIDPattern restricts tenant IDs to names that are safe as directory
+// names and storage key prefixes
+var tenantIDPattern = regexp.MustCompile(`^[A-Za-z0-9][A-Za-z0-9_.-]{0,63}$`)
+
+// ValidTenantID reports whether id can name a tenant
+func ValidTenantID(id string) bool {
+	return tenantIDPattern.MatchString(id)
+}
+
+// ForTenant returns a FileService sharing the storage backend whose files
+// are those of one tenant: new files are written to the tenant's directory,
+// tenants/<tenant> below the uploads directory, and saved under the same
+// prefix in storage, and upload and result IDs of other tenants are not
+// found. The default tenant keeps the uploads directory itself. tenant must
+// be a ValidTenantID; empty means the default tenant.
+func (fs *FileService) ForTenant(tenant string) *FileService {
+	if tenant == "" {
+		tenant = DefaultTenantID
+	}
+	scoped := *fs
+	scoped.tenant, scoped.uploadsDir = tenant, TenantDir(fs.root, tenant)
+	if err := os.MkdirAll(scoped.uploadsDir, 0755); err != nil {
+		fs.logger.Errorf("Failed to create directory of tenant %s: %v", tenant, err)
+	}
+	return &scoped
+}
+
+// TenantDir returns the directory of a tenant's files below the uploads
+// directory
+func TenantDir(uploadsDir, tenant string) string {
+	if tenant == "" || tenant == DefaultTenantID {
+		return uploadsDir
+	}
+	return filepath.Join(uploadsDir, tenantsDir, tenant)
+}
+
+// dirs returns the directories the service's files are in: its own, and
+// every tenant's when the service isn't scoped to a tenant
+func (fs *FileService) dirs() []string {
+	dirs := []string{fs.uploadsDir}
+	if fs.tenant != "" {
+		return dirs
+	}
+	entries, err := os.ReadDir(filepath.Join(fs.root, tenantsDir))
+	if err != nil {
+		return dirs
+	}
+	for _, entry := range entries {
+		if entry.IsDir() && ValidTenantID(entry.Name()) {
+			dirs = append(dirs, filepath.Join(fs.root, tenantsDir, entry.Name()))
+		}
+	}
+	return dirs
+}
+
+// tenantOf returns the tenant owning a directory of dirs, or empty for the
+// default tenant
+func (fs *FileService) tenantOf(dir string) string {
+	if dir == fs.root {
+		return ""
+	}
+	return filepath.Base(dir)
+}
+
+// storageKey returns the storage key of a file below the uploads directory:
+// its path relative to the shared directory, so each tenant's files share
+// the tenants/<tenant>/ prefix
+func (fs *FileService) storageKey(filePath string) string {
+	if rel, err := filepath.Rel(fs.root, filePath); err == nil && filepath.IsLocal(rel) {
+		return filepath.ToSlash(rel)
+	}
+	return filepath.Base(filePath)
+}
+
+// storedName returns a file's path relative to the service's directory, as
+// DeleteStoredFile expects
+func (fs *FileService) storedName(filePath string) string {
+	if rel, err := filepath.Rel(fs.uploadsDir, filePath); err == nil && filepath.IsLocal(rel) {
+		return rel
+	}
+	return filepath.Base(filePath)
+}
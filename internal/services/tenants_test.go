@@ -0,0 +1,171 @@
+package services
+
+import (
+	"context"
+	"errors"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/mussietl/csv-sales-api/internal/models"
+	"github.com/sirupsen/logrus"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestValidTenantID(t *testing.T) {
+	for _, id := range []string{"default", "acme", "Acme-2.eu_west"} {
+		assert.True(t, ValidTenantID(id), id)
+	}
+	for _, id := range []string{"", ".", "..", "-acme", "a/b", "a b", strings.Repeat("a", 65)} {
+		assert.False(t, ValidTenantID(id), id)
+	}
+}
+
+func TestFileServiceForTenant(t *testing.T) {
+	logger := logrus.New()
+	logger.SetLevel(logrus.FatalLevel)
+	workDir, remoteDir := t.TempDir(), t.TempDir()
+	fs := NewFileService(workDir, logger)
+	fs.SetStorage(NewLocalStorage(remoteDir), 0)
+	ctx := context.Background()
+	acme, globex := fs.ForTenant("acme"), fs.ForTenant("globex")
+
+	uploadPath, err := acme.SaveUpload(ctx, "sales.csv", strings.NewReader("Department,Sales\nBooks,10\n"))
+	require.NoError(t, err)
+	assert.Equal(t, filepath.Join(workDir, "tenants", "acme"), filepath.Dir(uploadPath))
+	_, err = os.Stat(filepath.Join(remoteDir, "tenants", "acme", filepath.Base(uploadPath)))
+	require.NoError(t, err, "tenant files are stored under the tenant's prefix")
+	resultPath, err := acme.SaveResultFile([]DepartmentSummary{{Department: "Books", TotalSales: models.WholeAmount(10)}})
+	require.NoError(t, err)
+	uploadID, resultID := acme.UploadID(uploadPath), acme.ResultID(resultPath)
+	assert.True(t, strings.HasSuffix(acme.GetDownloadURL(resultPath), "/results/"+resultID+"/download"))
+
+	// Files of other tenants are not found
+	_, err = globex.UploadPath(uploadID)
+	assert.True(t, errors.Is(err, ErrUploadNotFound))
+	_, err = globex.ResultPath(resultID)
+	assert.True(t, errors.Is(err, ErrResultNotFound))
+	_, err = fs.ForTenant("").ResultPath(resultID)
+	assert.True(t, errors.Is(err, ErrResultNotFound), "the default tenant is scoped too")
+
+	// The unscoped service sees every tenant's files
+	path, err := fs.ResultPath(resultID)
+	require.NoError(t, err)
+	assert.Equal(t, resultPath, path)
+	_, err = fs.SaveResultFile([]DepartmentSummary{{Department: "Toys", TotalSales: models.WholeAmount(1)}})
+	require.NoError(t, err)
+	files, err := fs.StoredFiles()
+	require.NoError(t, err)
+	tenants := make(map[string]int)
+	for _, file := range files {
+		tenants[file.Tenant]++
+	}
+	assert.Equal(t, map[string]int{"": 1, "acme": 2}, tenants)
+	usage, err := fs.StorageUsage()
+	require.NoError(t, err)
+	assert.Equal(t, int64(3), usage.Files)
+	assert.Equal(t, int64(2), usage.Tenants["acme"].Files)
+	usage, err = acme.StorageUsage()
+	require.NoError(t, err)
+	assert.Equal(t, int64(2), usage.Files)
+	assert.Nil(t, usage.Tenants)
+
+	// Lost working copies are restored into the tenant's directory
+	require.NoError(t, os.Remove(resultPath))
+	path, err = acme.ResultPath(resultID)
+	require.NoError(t, err)
+	assert.Equal(t, resultPath, path)
+
+	_, err = acme.DeleteResult(resultID)
+	require.NoError(t, err)
+	_, err = os.Stat(filepath.Join(remoteDir, "tenants", "acme", filepath.Base(resultPath)))
+	assert.True(t, errors.Is(err, os.ErrNotExist), "deleting a result removes it from storage")
+}
+
+func TestTenantQuotas(t *testing.T) {
+	logger := logrus.New()
+	logger.SetLevel(logrus.FatalLevel)
+	dir := t.TempDir()
+	fs := NewFileService(filepath.Join(dir, "uploads"), logger)
+	path := filepath.Join(dir, "tenant_quotas.json")
+	quotas, err := NewTenantQuotas(path, TenantQuota{MonthlyRows: 100}, fs, logger)
+	require.NoError(t, err)
+	now := time.Date(2024, 3, 31, 12, 0, 0, 0, time.UTC)
+	quotas.now = func() time.Time { return now }
+
+	require.NoError(t, quotas.Check("acme", 0))
+	quotas.HandleEvent(Event{Type: EventProcessingCompleted, Tenant: "acme", Rows: &models.RowStats{Total: 60}})
+	quotas.HandleEvent(Event{Type: EventProcessingStarted, Tenant: "acme", Rows: &models.RowStats{Total: 60}})
+	require.NoError(t, quotas.Check("acme", 0))
+	quotas.HandleEvent(Event{Type: EventProcessingCompleted, Tenant: "acme", Rows: &models.RowStats{Total: 40}})
+
+	err = quotas.Check("acme", 0)
+	var quotaErr *QuotaError
+	require.True(t, errors.As(err, &quotaErr))
+	assert.True(t, errors.Is(err, ErrQuotaExceeded))
+	assert.Equal(t, QuotaMonthlyRows, quotaErr.Quota)
+	assert.Equal(t, int64(100), quotaErr.Used)
+	assert.Equal(t, time.Date(2024, 4, 1, 0, 0, 0, 0, time.UTC), quotaErr.ResetAt)
+	require.NoError(t, quotas.Check("globex", 0), "quotas are per tenant")
+
+	// Overrides and row counts survive a restart
+	_, err = fs.ForTenant("acme").SaveUpload(context.Background(), "sales.csv", strings.NewReader("Department,Sales\nBooks,10\n"))
+	require.NoError(t, err)
+	require.NoError(t, quotas.SetQuota("acme", TenantQuota{StorageBytes: 30}))
+	assert.Error(t, quotas.SetQuota("acme", TenantQuota{MonthlyRows: -1}))
+	quotas, err = NewTenantQuotas(path, TenantQuota{MonthlyRows: 100}, fs, logger)
+	require.NoError(t, err)
+	quotas.now = func() time.Time { return now }
+
+	status, err := quotas.Get("acme")
+	require.NoError(t, err)
+	assert.True(t, status.Override)
+	assert.Equal(t, "2024-03", status.Month)
+	assert.Equal(t, int64(100), status.MonthlyRows)
+	assert.Equal(t, int64(26), status.StorageBytes)
+	require.NoError(t, quotas.Check("acme", 4))
+	err = quotas.Check("acme", 5)
+	require.True(t, errors.As(err, &quotaErr))
+	assert.Equal(t, QuotaStorage, quotaErr.Quota)
+
+	// Rows are counted again from the next month
+	require.NoError(t, quotas.ResetQuota("acme"))
+	now = now.AddDate(0, 0, 1)
+	require.NoError(t, quotas.Check("acme", 0))
+	status, err = quotas.Get("acme")
+	require.NoError(t, err)
+	assert.False(t, status.Override)
+	assert.Equal(t, int64(0), status.MonthlyRows)
+}
+
+func TestTenantQuotasCountUploadSessions(t *testing.T) {
+	logger := logrus.New()
+	logger.SetLevel(logrus.FatalLevel)
+	dir := t.TempDir()
+	fs := NewFileService(filepath.Join(dir, "uploads"), logger)
+	require.NoError(t, fs.SetUploadSessions(filepath.Join(dir, "sessions"), time.Hour))
+	quotas, err := NewTenantQuotas(filepath.Join(dir, "tenant_quotas.json"), TenantQuota{StorageBytes: 100}, fs, logger)
+	require.NoError(t, err)
+
+	// A session reserves its declared size before any bytes arrive
+	acme := fs.ForTenant("acme")
+	session, err := acme.CreateUploadSession("sales.csv", 80)
+	require.NoError(t, err)
+	require.NoError(t, quotas.Check("acme", 20))
+	err = quotas.Check("acme", 21)
+	var quotaErr *QuotaError
+	require.True(t, errors.As(err, &quotaErr))
+	assert.Equal(t, QuotaStorage, quotaErr.Quota)
+	assert.Equal(t, int64(80), quotaErr.Used)
+	require.NoError(t, quotas.Check("globex", 100), "sessions count against their own tenant")
+
+	status, err := quotas.Get("acme")
+	require.NoError(t, err)
+	assert.Equal(t, int64(80), status.StorageBytes)
+
+	require.NoError(t, acme.DeleteUploadSession(session.ID))
+	require.NoError(t, quotas.Check("acme", 100))
+}
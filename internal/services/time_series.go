@@ -0,0 +1,187 @@
+package services
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/mussietl/csv-sales-api/internal/models"
+)
+
+// Time buckets of a sales time series
+const (
+	BucketDay     = "day"
+	BucketWeek    = "week"
+	BucketMonth   = "month"
+	BucketQuarter = "quarter"
+)
+
+// dateColumnNames are the normalized header names tried, in order, when a
+// time series is requested without naming the date column
+var dateColumnNames = []string{
+	"date", "sale date", "sale_date", "order date", "order_date",
+	"transaction date", "transaction_date", "sold on", "sold_on",
+}
+
+// dateLayouts are the accepted date formats besides timestampLayouts
+var dateLayouts = []string{
+	"2006-01-02",
+	"2006/01/02",
+	"1/2/2006",
+}
+
+// parseDate parses a sales date, or a timestamp whose time of day is ignored
+func parseDate(value string) (time.Time, error) {
+	for _, layout := range dateLayouts {
+		if t, err := time.Parse(layout, value); err == nil {
+			return t, nil
+		}
+	}
+	if t, err := parseTimestamp(value); err == nil {
+		return t, nil
+	}
+	return time.Time{}, fmt.Errorf("unrecognized date %q", value)
+}
+
+// bucketLabel names the bucket containing t: 2024-03-04 for a day, the ISO
+// week 2024-W10, 2024-03 for a month and 2024-Q1 for a calendar quarter.
+// With a fiscal calendar other than the calendar year, quarters are fiscal
+// quarters such as FY2025-Q1, and under a retail pattern weeks and months are
+// the weeks and periods of the retail year, such as FY2025-W05 and
+// FY2025-P02. Labels sort in time order.
+func bucketLabel(t time.Time, bucket string, calendar *FiscalCalendar) string {
+	if calendar != nil && !calendar.isCalendarYear() {
+		switch period := calendar.PeriodOf(t); {
+		case bucket == BucketQuarter:
+			return fmt.Sprintf("FY%d-Q%d", period.Year, period.Quarter)
+		case bucket == BucketMonth && calendar.Pattern != "":
+			return fmt.Sprintf("FY%d-P%02d", period.Year, period.Period)
+		case bucket == BucketWeek && calendar.Pattern != "":
+			return fmt.Sprintf("FY%d-W%02d", period.Year, period.Week)
+		}
+	}
+	switch bucket {
+	case BucketWeek:
+		year, week := t.ISOWeek()
+		return fmt.Sprintf("%d-W%02d", year, week)
+	case BucketMonth:
+		return t.Format("2006-01")
+	case BucketQuarter:
+		return fmt.Sprintf("%d-Q%d", t.Year(), (int(t.Month())-1)/3+1)
+	}
+	return t.Format("2006-01-02")
+}
+
+// findDateColumn returns the index of the column named column or, when
+// column is empty, of the first header cell with a date-like name
+func (cs *CSVService) findDateColumn(normalizedHeader []string, column string, norm Normalization) (int, error) {
+	if column != "" {
+		indices, err := cs.findColumns(normalizedHeader, []string{column}, norm, "date")
+		if err != nil {
+			return -1, err
+		}
+		return indices[0], nil
+	}
+	for _, names := range [][]string{dateColumnNames, timestampColumnNames} {
+		for _, name := range names {
+			for i, col := range normalizedHeader {
+				if strings.EqualFold(col, name) {
+					return i, nil
+				}
+			}
+		}
+	}
+	return -1, fmt.Errorf("time series needs a date column: none of %s found in CSV header; name it with date_column", strings.Join(dateColumnNames, ", "))
+}
+
+// timeBucket holds one department's sales in one bucket
+type timeBucket struct {
+	sales models.Amount
+	count int
+}
+
+// timeSeriesAggregator accumulates department sales per time bucket
+type timeSeriesAggregator struct {
+	bucket      string
+	calendar    *FiscalCalendar
+	departments map[string]map[string]*timeBucket
+	undated     int
+}
+
+func newTimeSeriesAggregator(bucket string, calendar *FiscalCalendar) *timeSeriesAggregator {
+	return &timeSeriesAggregator{bucket: bucket, calendar: calendar, departments: make(map[string]map[string]*timeBucket)}
+}
+
+// add counts a row's sales in the bucket of its date
+func (ts *timeSeriesAggregator) add(department, date string, sales models.Amount) {
+	t, err := parseDate(strings.TrimSpace(date))
+	if err != nil {
+		ts.undated++
+		return
+	}
+	ts.addToBucket(department, bucketLabel(t, ts.bucket, ts.calendar), timeBucket{sales: sales, count: 1})
+}
+
+func (ts *timeSeriesAggregator) addToBucket(department, label string, value timeBucket) {
+	buckets, ok := ts.departments[department]
+	if !ok {
+		buckets = make(map[string]*timeBucket)
+		ts.departments[department] = buckets
+	}
+	b, ok := buckets[label]
+	if !ok {
+		b = &timeBucket{}
+		buckets[label] = b
+	}
+	b.sales = b.sales.Add(value.sales)
+	b.count += value.count
+}
+
+// merge adds the buckets of an aggregator that consumed another part of the file
+func (ts *timeSeriesAggregator) merge(other *timeSeriesAggregator) {
+	for department, buckets := range other.departments {
+		for label, b := range buckets {
+			ts.addToBucket(department, label, *b)
+		}
+	}
+	ts.undated += other.undated
+}
+
+// result converts the buckets to a SalesTimeSeries with departments in the
+// order of summaries. Every department lists every bucket that has sales in
+// any department, in time order, so the series line up.
+func (ts *timeSeriesAggregator) result(column string, summaries []DepartmentSummary) *models.SalesTimeSeries {
+	labels := make(map[string]bool)
+	for _, buckets := range ts.departments {
+		for label := range buckets {
+			labels[label] = true
+		}
+	}
+	series := &models.SalesTimeSeries{
+		DateColumn:  column,
+		Bucket:      ts.bucket,
+		Buckets:     make([]string, 0, len(labels)),
+		Departments: make([]models.DepartmentTimeSeries, len(summaries)),
+		UndatedRows: ts.undated,
+	}
+	for label := range labels {
+		series.Buckets = append(series.Buckets, label)
+	}
+	sort.Strings(series.Buckets)
+
+	for i, summary := range summaries {
+		department := models.DepartmentTimeSeries{
+			Department: summary.Department,
+			Buckets:    make([]models.TimeBucket, len(series.Buckets)),
+		}
+		for j, label := range series.Buckets {
+			department.Buckets[j] = models.TimeBucket{Bucket: label}
+			if b := ts.departments[summary.Department][label]; b != nil {
+				department.Buckets[j].Sales, department.Buckets[j].Count = b.sales, b.count
+			}
+		}
+		series.Departments[i] = department
+	}
+	return series
+}
@@ -0,0 +1,166 @@
+package services
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/mussietl/csv-sales-api/internal/models"
+	"github.com/sirupsen/logrus"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+const timeSeriesCSV = "Order Date,Department,Sales\n" +
+	"2024-01-31,Books,10\n" +
+	"2024-02-01 09:15,Books,5.5\n" +
+	"4/2/2024,Books,2\n" +
+	"soon,Books,7\n" +
+	"2024/02/29,Toys,3\n"
+
+func TestBucketLabel(t *testing.T) {
+	day := time.Date(2024, 12, 30, 0, 0, 0, 0, time.UTC)
+	assert.Equal(t, "2024-12-30", bucketLabel(day, BucketDay, nil))
+	assert.Equal(t, "2025-W01", bucketLabel(day, BucketWeek, nil), "ISO weeks may start in the previous year")
+	assert.Equal(t, "2024-12", bucketLabel(day, BucketMonth, nil))
+	assert.Equal(t, "2024-Q4", bucketLabel(day, BucketQuarter, nil))
+	assert.Equal(t, "2024-Q1", bucketLabel(time.Date(2024, 3, 31, 0, 0, 0, 0, time.UTC), BucketQuarter, nil))
+	assert.Equal(t, "2024-Q4", bucketLabel(day, BucketQuarter, &DefaultFiscalCalendar))
+}
+
+func TestBucketLabelFiscalCalendar(t *testing.T) {
+	february, err := ParseFiscalCalendar("start_month=2")
+	require.NoError(t, err)
+	day := time.Date(2024, 12, 30, 0, 0, 0, 0, time.UTC)
+	assert.Equal(t, "FY2025-Q4", bucketLabel(day, BucketQuarter, &february))
+	assert.Equal(t, "FY2024-Q4", bucketLabel(time.Date(2024, 1, 31, 0, 0, 0, 0, time.UTC), BucketQuarter, &february))
+	// Without a retail pattern, fiscal weeks and months are calendar ones
+	assert.Equal(t, "2025-W01", bucketLabel(day, BucketWeek, &february))
+	assert.Equal(t, "2024-12", bucketLabel(day, BucketMonth, &february))
+	assert.Equal(t, "2024-12-30", bucketLabel(day, BucketDay, &february))
+
+	// The NRF 4-5-4 year starting 2024-02-04 follows the 53-week 2023 one
+	nrf, err := ParseFiscalCalendar("start_month=2,pattern=4-5-4,year_end=nearest")
+	require.NoError(t, err)
+	march := time.Date(2024, 3, 4, 0, 0, 0, 0, time.UTC)
+	assert.Equal(t, "FY2025-W05", bucketLabel(march, BucketWeek, &nrf))
+	assert.Equal(t, "FY2025-P02", bucketLabel(march, BucketMonth, &nrf))
+	assert.Equal(t, "FY2025-Q1", bucketLabel(march, BucketQuarter, &nrf))
+	lastDay := time.Date(2024, 2, 3, 0, 0, 0, 0, time.UTC)
+	assert.Equal(t, "FY2024-W53", bucketLabel(lastDay, BucketWeek, &nrf))
+	assert.Equal(t, "FY2024-P12", bucketLabel(lastDay, BucketMonth, &nrf))
+	assert.Equal(t, "FY2024-Q4", bucketLabel(lastDay, BucketQuarter, &nrf))
+}
+
+func TestProcessTimeSeries(t *testing.T) {
+	logger := logrus.New()
+	logger.SetLevel(logrus.ErrorLevel)
+	cs := NewCSVService(logger)
+
+	result, err := cs.ProcessStream(strings.NewReader(timeSeriesCSV), ProcessOptions{Bucket: BucketMonth})
+	require.NoError(t, err)
+	series := result.TimeSeries
+	require.NotNil(t, series)
+	assert.Equal(t, "Order Date", series.DateColumn)
+	assert.Equal(t, BucketMonth, series.Bucket)
+	assert.Equal(t, []string{"2024-01", "2024-02", "2024-04"}, series.Buckets)
+	assert.Equal(t, 1, series.UndatedRows)
+	require.Len(t, series.Departments, 2)
+
+	books := series.Departments[0]
+	assert.Equal(t, "Books", books.Department)
+	assert.Equal(t, []models.TimeBucket{
+		{Bucket: "2024-01", Sales: models.WholeAmount(10), Count: 1},
+		{Bucket: "2024-02", Sales: amount(t, "5.5"), Count: 1},
+		{Bucket: "2024-04", Sales: models.WholeAmount(2), Count: 1},
+	}, books.Buckets)
+	// Departments list every bucket, with zeros where they had no sales
+	assert.Equal(t, []models.TimeBucket{
+		{Bucket: "2024-01"},
+		{Bucket: "2024-02", Sales: models.WholeAmount(3), Count: 1},
+		{Bucket: "2024-04"},
+	}, series.Departments[1].Buckets)
+	// Undated rows still count towards the department totals
+	assert.Equal(t, amount(t, "24.5"), result.Summaries[0].TotalSales)
+
+	result, err = cs.ProcessStream(strings.NewReader(timeSeriesCSV), ProcessOptions{Bucket: BucketQuarter})
+	require.NoError(t, err)
+	assert.Equal(t, []string{"2024-Q1", "2024-Q2"}, result.TimeSeries.Buckets)
+
+	february, err := ParseFiscalCalendar("start_month=2")
+	require.NoError(t, err)
+	result, err = cs.ProcessStream(strings.NewReader(timeSeriesCSV), ProcessOptions{Bucket: BucketQuarter, FiscalCalendar: &february})
+	require.NoError(t, err)
+	assert.Equal(t, []string{"FY2024-Q4", "FY2025-Q1"}, result.TimeSeries.Buckets)
+
+	result, err = cs.ProcessStream(strings.NewReader(timeSeriesCSV), ProcessOptions{})
+	require.NoError(t, err)
+	assert.Nil(t, result.TimeSeries)
+}
+
+func TestTimeSeriesDateColumn(t *testing.T) {
+	logger := logrus.New()
+	logger.SetLevel(logrus.ErrorLevel)
+	cs := NewCSVService(logger)
+
+	csv := "Shipped,Department,Sales,Date\n2024-03-04,Books,1,2024-03-05\n"
+	result, err := cs.ProcessStream(strings.NewReader(csv), ProcessOptions{Bucket: BucketDay})
+	require.NoError(t, err)
+	assert.Equal(t, "Date", result.TimeSeries.DateColumn)
+	assert.Equal(t, []string{"2024-03-05"}, result.TimeSeries.Buckets)
+
+	result, err = cs.ProcessStream(strings.NewReader(csv), ProcessOptions{Bucket: BucketDay, DateColumn: "shipped"})
+	require.NoError(t, err)
+	assert.Equal(t, []string{"2024-03-04"}, result.TimeSeries.Buckets)
+
+	_, err = cs.ProcessStream(strings.NewReader(csv), ProcessOptions{Bucket: BucketDay, DateColumn: "closed"})
+	assert.ErrorContains(t, err, `date column "closed" not found`)
+
+	_, err = cs.ProcessStream(strings.NewReader("Department,Sales\nBooks,1\n"), ProcessOptions{Bucket: BucketWeek})
+	assert.ErrorContains(t, err, "time series needs a date column")
+}
+
+func TestTimeSeriesParallel(t *testing.T) {
+	logger := logrus.New()
+	logger.SetLevel(logrus.ErrorLevel)
+	cs := NewCSVService(logger)
+
+	var buf strings.Builder
+	buf.WriteString("Department,Sales,Date\n")
+	for i := 0; i < 3000; i++ {
+		fmt.Fprintf(&buf, "Dept %d,%d,2024-%02d-%02d\n", i%5, i, 1+i%12, 1+i%28)
+	}
+	path := filepath.Join(t.TempDir(), "sales.csv")
+	require.NoError(t, os.WriteFile(path, []byte(buf.String()), 0644))
+
+	opts := ProcessOptions{Bucket: BucketWeek, Strategy: StrategyStreaming}
+	streaming, err := cs.Process(path, opts)
+	require.NoError(t, err)
+	assert.Zero(t, streaming.TimeSeries.UndatedRows)
+
+	cs.SetStrategyThresholds(StrategyThresholds{Workers: 4})
+	opts.Strategy = StrategyParallel
+	parallel, err := cs.Process(path, opts)
+	require.NoError(t, err)
+	assert.Equal(t, streaming.TimeSeries, parallel.TimeSeries)
+}
+
+func TestFileServiceSaveTimeSeriesFile(t *testing.T) {
+	logger := logrus.New()
+	logger.SetLevel(logrus.ErrorLevel)
+	fs := NewFileService(t.TempDir(), logger)
+	cs := NewCSVService(logger)
+
+	result, err := cs.ProcessStream(strings.NewReader(timeSeriesCSV), ProcessOptions{Bucket: BucketMonth})
+	require.NoError(t, err)
+	path, err := fs.SaveTimeSeriesFile(result.TimeSeries, DefaultNumberFormat)
+	require.NoError(t, err)
+	assert.True(t, strings.HasPrefix(filepath.Base(path), "timeseries_"))
+
+	data, err := os.ReadFile(path)
+	require.NoError(t, err)
+	assert.Equal(t, "Department Name,2024-01,2024-02,2024-04\nBooks,10,5.5,2\nToys,0,3,0\n", string(data))
+}
@@ -0,0 +1,114 @@
+package services
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"sync"
+	"time"
+
+	"github.com/mussietl/csv-sales-api/internal/models"
+	"github.com/sirupsen/logrus"
+)
+
+// MaxTrendPoints is the number of uploads kept per feed, and so the longest trend
+const MaxTrendPoints = 52
+
+// DefaultTrendPoints is the trend length returned when a client doesn't ask for one
+const DefaultTrendPoints = 10
+
+// FeedSnapshot holds the department totals of one upload of a feed
+type FeedSnapshot struct {
+	UploadID string    `json:"upload_id"`
+	At       time.Time `json:"at"`
+	// Totals maps department keys to total sales
+	Totals map[string]models.Amount `json:"totals"`
+}
+
+// TrendStore keeps the department totals of each tenant's recent feed uploads
+// so results can include per-department trends
+type TrendStore struct {
+	path   string
+	mu     sync.Mutex
+	feeds  map[string][]FeedSnapshot
+	logger *logrus.Logger
+}
+
+// NewTrendStore creates a TrendStore, loading history from path
+func NewTrendStore(path string, logger *logrus.Logger) (*TrendStore, error) {
+	ts := &TrendStore{
+		path:   path,
+		feeds:  make(map[string][]FeedSnapshot),
+		logger: logger,
+	}
+
+	data, err := os.ReadFile(path)
+	if err != nil && !errors.Is(err, os.ErrNotExist) {
+		return nil, fmt.Errorf("failed to read trends: %w", err)
+	}
+	if err == nil {
+		if err := json.Unmarshal(data, &ts.feeds); err != nil {
+			return nil, fmt.Errorf("failed to decode trends: %w", err)
+		}
+	}
+	return ts, nil
+}
+
+// Record appends an upload's department totals to the feed's history,
+// keeping the most recent MaxTrendPoints uploads
+func (ts *TrendStore) Record(tenant, feed, uploadID string, summaries []DepartmentSummary, at time.Time) error {
+	snapshot := FeedSnapshot{UploadID: uploadID, At: at.UTC(), Totals: make(map[string]models.Amount, len(summaries))}
+	for _, summary := range summaries {
+		key := departmentKey(summary.Department)
+		snapshot.Totals[key] = snapshot.Totals[key].Add(summary.TotalSales)
+	}
+
+	key := tenant + "/" + feed
+	ts.mu.Lock()
+	defer ts.mu.Unlock()
+	previous := ts.feeds[key]
+	history := append(append([]FeedSnapshot{}, previous...), snapshot)
+	if len(history) > MaxTrendPoints {
+		history = history[len(history)-MaxTrendPoints:]
+	}
+	ts.feeds[key] = history
+	if err := ts.save(); err != nil {
+		if previous == nil {
+			delete(ts.feeds, key)
+		} else {
+			ts.feeds[key] = previous
+		}
+		return err
+	}
+	return nil
+}
+
+// Fill sets each summary's Trend to its totals over the feed's last points
+// uploads, oldest first. A department absent from an upload counts as 0.
+func (ts *TrendStore) Fill(tenant, feed string, summaries []DepartmentSummary, points int) {
+	ts.mu.Lock()
+	defer ts.mu.Unlock()
+	history := ts.feeds[tenant+"/"+feed]
+	if len(history) > points {
+		history = history[len(history)-points:]
+	}
+
+	for i := range summaries {
+		key := departmentKey(summaries[i].Department)
+		trend := make([]models.Amount, len(history))
+		for j, snapshot := range history {
+			trend[j] = snapshot.Totals[key]
+		}
+		summaries[i].Trend = trend
+	}
+}
+
+// save persists the history. Callers must hold ts.mu.
+func (ts *TrendStore) save() error {
+	data, err := json.Marshal(ts.feeds)
+	if err != nil {
+		return fmt.Errorf("failed to encode trends: %w", err)
+	}
+	return writeFileAtomic(ts.path, data, 0600)
+}
@@ -0,0 +1,58 @@
+package services
+
+import (
+	"github.com/mussietl/csv-sales-api/internal/models"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/sirupsen/logrus"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestTrendStore(t *testing.T) {
+	logger := logrus.New()
+	logger.SetLevel(logrus.ErrorLevel)
+	path := filepath.Join(t.TempDir(), "trends.json")
+
+	ts, err := NewTrendStore(path, logger)
+	require.NoError(t, err)
+	now := time.Now()
+	require.NoError(t, ts.Record("acme", "pos", "u1", []DepartmentSummary{{Department: "Books", TotalSales: models.WholeAmount(10)}, {Department: "Toys", TotalSales: models.WholeAmount(5)}}, now))
+	require.NoError(t, ts.Record("acme", "pos", "u2", []DepartmentSummary{{Department: "books", TotalSales: models.WholeAmount(12)}}, now))
+	require.NoError(t, ts.Record("acme", "other", "u3", []DepartmentSummary{{Department: "Books", TotalSales: models.WholeAmount(99)}}, now))
+
+	// History survives a restart
+	ts, err = NewTrendStore(path, logger)
+	require.NoError(t, err)
+	current := []DepartmentSummary{{Department: "Books", TotalSales: models.WholeAmount(15)}, {Department: "Toys", TotalSales: models.WholeAmount(7)}, {Department: "Games", TotalSales: models.WholeAmount(1)}}
+	require.NoError(t, ts.Record("acme", "pos", "u4", current, now))
+
+	ts.Fill("acme", "pos", current, DefaultTrendPoints)
+	assert.Equal(t, wholeAmounts(10, 12, 15), current[0].Trend, "departments match case-insensitively")
+	assert.Equal(t, wholeAmounts(5, 0, 7), current[1].Trend, "absent departments count as 0")
+	assert.Equal(t, wholeAmounts(0, 0, 1), current[2].Trend)
+
+	ts.Fill("acme", "pos", current, 2)
+	assert.Equal(t, wholeAmounts(12, 15), current[0].Trend)
+
+	// Only the most recent uploads are kept
+	for i := 0; i < MaxTrendPoints+5; i++ {
+		require.NoError(t, ts.Record("acme", "daily", "u", []DepartmentSummary{{Department: "Books", TotalSales: models.WholeAmount(i)}}, now))
+	}
+	daily := []DepartmentSummary{{Department: "Books"}}
+	ts.Fill("acme", "daily", daily, MaxTrendPoints)
+	require.Len(t, daily[0].Trend, MaxTrendPoints)
+	assert.Equal(t, models.WholeAmount(5), daily[0].Trend[0])
+	assert.Equal(t, models.WholeAmount(MaxTrendPoints+4), daily[0].Trend[MaxTrendPoints-1])
+}
+
+// wholeAmounts converts whole numbers to amounts
+func wholeAmounts(values ...int) []models.Amount {
+	amounts := make([]models.Amount, len(values))
+	for i, value := range values {
+		amounts[i] = models.WholeAmount(value)
+	}
+	return amounts
+}
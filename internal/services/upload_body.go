@@ -0,0 +1,71 @@
+package services
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"os"
+)
+
+// ErrUploadInterrupted is returned when the client disconnects before the
+// upload has been received
+var ErrUploadInterrupted = errors.New("upload interrupted: client disconnected")
+
+// ErrUploadTimedOut is returned when the upload isn't received within the
+// upload deadline
+var ErrUploadTimedOut = errors.New("upload timed out")
+
+// contextReader fails reads once its context is done
+type contextReader struct {
+	ctx context.Context
+	r   io.Reader
+}
+
+func (r contextReader) Read(p []byte) (int, error) {
+	if err := r.ctx.Err(); err != nil {
+		return 0, uploadReadError(err)
+	}
+	return r.r.Read(p)
+}
+
+// uploadBody reads a request body, reporting a broken or expired stream as
+// ErrUploadInterrupted or ErrUploadTimedOut rather than as truncated content
+type uploadBody struct {
+	contextReader
+	body io.Closer
+}
+
+// NewUploadBody wraps the body of an upload request so reads fail with
+// ErrUploadInterrupted once the client has disconnected, and with
+// ErrUploadTimedOut once the connection's read deadline has passed.
+// Whatever parses the body can then tell a broken upload from a bad file.
+func NewUploadBody(ctx context.Context, body io.ReadCloser) io.ReadCloser {
+	return &uploadBody{contextReader: contextReader{ctx: ctx, r: body}, body: body}
+}
+
+func (b *uploadBody) Read(p []byte) (int, error) {
+	n, err := b.contextReader.Read(p)
+	if err != nil && !errors.Is(err, io.EOF) {
+		err = uploadReadError(err)
+	}
+	return n, err
+}
+
+func (b *uploadBody) Close() error {
+	return b.body.Close()
+}
+
+// uploadReadError classifies an error reading an upload. Bodies that are too
+// large keep their own error.
+func uploadReadError(err error) error {
+	var tooLarge *http.MaxBytesError
+	switch {
+	case errors.As(err, &tooLarge), errors.Is(err, ErrUploadInterrupted), errors.Is(err, ErrUploadTimedOut):
+		return err
+	case errors.Is(err, os.ErrDeadlineExceeded), errors.Is(err, context.DeadlineExceeded):
+		return fmt.Errorf("%w: %v", ErrUploadTimedOut, err)
+	}
+	return fmt.Errorf("%w: %v", ErrUploadInterrupted, err)
+}
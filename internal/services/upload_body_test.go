@@ -0,0 +1,65 @@
+package services
+
+import (
+	"bytes"
+	"context"
+	"io"
+	"mime/multipart"
+	"os"
+	"strings"
+	"testing"
+	"testing/iotest"
+
+	"github.com/sirupsen/logrus"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestUploadBody(t *testing.T) {
+	body := NewUploadBody(context.Background(), io.NopCloser(strings.NewReader("Department Name,Total Sales\n")))
+	data, err := io.ReadAll(body)
+	require.NoError(t, err)
+	assert.Equal(t, "Department Name,Total Sales\n", string(data))
+
+	broken := io.MultiReader(strings.NewReader("Department Name,Total"), iotest.ErrReader(io.ErrUnexpectedEOF))
+	_, err = io.ReadAll(NewUploadBody(context.Background(), io.NopCloser(broken)))
+	assert.ErrorIs(t, err, ErrUploadInterrupted)
+
+	_, err = io.ReadAll(NewUploadBody(context.Background(), io.NopCloser(iotest.ErrReader(os.ErrDeadlineExceeded))))
+	assert.ErrorIs(t, err, ErrUploadTimedOut)
+
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	_, err = io.ReadAll(NewUploadBody(ctx, io.NopCloser(strings.NewReader("Department Name"))))
+	assert.ErrorIs(t, err, ErrUploadInterrupted)
+}
+
+func TestFileServiceSaveUploadedFileDisconnect(t *testing.T) {
+	logger := logrus.New()
+	logger.SetLevel(logrus.PanicLevel)
+	dir := t.TempDir()
+	fs := NewFileService(dir, logger)
+
+	var buf bytes.Buffer
+	writer := multipart.NewWriter(&buf)
+	part, err := writer.CreateFormFile("file", "sales.csv")
+	require.NoError(t, err)
+	_, err = part.Write([]byte("Department Name,Total Sales\nBooks,300\n"))
+	require.NoError(t, err)
+	require.NoError(t, writer.Close())
+	form, err := multipart.NewReader(&buf, writer.Boundary()).ReadForm(1 << 20)
+	require.NoError(t, err)
+	file := form.File["file"][0]
+
+	path, err := fs.SaveUploadedFile(context.Background(), file)
+	require.NoError(t, err)
+	assert.FileExists(t, path)
+
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	_, err = fs.SaveUploadedFile(ctx, file)
+	assert.ErrorIs(t, err, ErrUploadInterrupted)
+	entries, err := os.ReadDir(dir)
+	require.NoError(t, err)
+	assert.Len(t, entries, 1, "the partial upload should be removed")
+}
@@ -0,0 +1,353 @@
+package services
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Errors of resumable uploads
+var (
+	ErrUploadSessionNotFound = errors.New("upload session not found")
+	ErrUploadOffsetMismatch  = errors.New("upload offset mismatch")
+	ErrUploadSessionBusy     = errors.New("upload session is busy")
+	ErrUploadIncomplete      = errors.New("upload is incomplete")
+)
+
+// DefaultUploadSessionTTL is how long an upload session is kept after its
+// last chunk unless configured
+const DefaultUploadSessionTTL = 24 * time.Hour
+
+// UploadSession is a resumable upload whose content is sent in chunks and
+// assembled in the sessions directory until it is committed
+type UploadSession struct {
+	ID       string `json:"id"`
+	Tenant   string `json:"tenant"`
+	Filename string `json:"filename"`
+	// Size is the length of the whole upload, declared when it was created
+	Size int64 `json:"size"`
+	// Offset is the number of bytes received so far; the next chunk starts here
+	Offset    int64     `json:"offset"`
+	CreatedAt time.Time `json:"created_at"`
+	ExpiresAt time.Time `json:"expires_at"`
+}
+
+// uploadSessions keeps the state and content of upload sessions in a
+// directory: <id>.json and <id>.part per session. It is shared by the
+// tenant-scoped copies of a FileService.
+type uploadSessions struct {
+	dir string
+	ttl time.Duration
+	now func() time.Time
+
+	mu sync.Mutex
+	// busy holds the sessions a chunk is being written to or that are
+	// being committed or deleted
+	busy map[string]bool
+}
+
+// SetUploadSessions enables resumable uploads, assembling them in dir and
+// deleting sessions that receive nothing for ttl
+func (fs *FileService) SetUploadSessions(dir string, ttl time.Duration) error {
+	if err := os.MkdirAll(dir, 0700); err != nil {
+		return fmt.Errorf("failed to create upload sessions directory: %w", err)
+	}
+	if ttl <= 0 {
+		ttl = DefaultUploadSessionTTL
+	}
+	fs.sessions = &uploadSessions{dir: dir, ttl: ttl, now: time.Now, busy: make(map[string]bool)}
+	fs.expireUploadSessions()
+	return nil
+}
+
+// CreateUploadSession starts a resumable upload of size bytes for the
+// service's tenant
+func (fs *FileService) CreateUploadSession(filename string, size int64) (*UploadSession, error) {
+	if fs.sessions == nil {
+		return nil, errors.New("resumable uploads are not enabled")
+	}
+	if err := ValidateFilename(filename); err != nil {
+		return nil, err
+	}
+	if size <= 0 {
+		return nil, errors.New("size must be positive")
+	}
+	fs.expireUploadSessions()
+
+	id, err := fs.newID()
+	if err != nil {
+		return nil, err
+	}
+	tenant := fs.tenant
+	if tenant == "" {
+		tenant = DefaultTenantID
+	}
+	now := fs.sessions.now().UTC()
+	session := &UploadSession{
+		ID:        id,
+		Tenant:    tenant,
+		Filename:  filepath.Base(filename),
+		Size:      size,
+		CreatedAt: now,
+		ExpiresAt: now.Add(fs.sessions.ttl),
+	}
+	if err := os.WriteFile(fs.sessions.partPath(id), nil, 0600); err != nil {
+		return nil, fmt.Errorf("failed to create upload session: %w", err)
+	}
+	if err := fs.sessions.save(session); err != nil {
+		os.Remove(fs.sessions.partPath(id))
+		return nil, err
+	}
+	fs.logger.Infof("Created upload session %s for %s (%d bytes)", id, session.Filename, size)
+	return session, nil
+}
+
+// UploadSession returns a session of the service's tenant
+func (fs *FileService) UploadSession(id string) (*UploadSession, error) {
+	if fs.sessions == nil {
+		return nil, ErrUploadSessionNotFound
+	}
+	return fs.loadUploadSession(id)
+}
+
+// WriteUploadChunk appends a chunk read from r to a session. offset must be
+// the session's offset; bytes beyond the declared size are not read. The
+// bytes received before a read error are kept, so the client can resume
+// from the returned session's offset.
+func (fs *FileService) WriteUploadChunk(ctx context.Context, id string, offset int64, r io.Reader) (*UploadSession, error) {
+	release, err := fs.acquireUploadSession(id)
+	if err != nil {
+		return nil, err
+	}
+	defer release()
+	session, err := fs.loadUploadSession(id)
+	if err != nil {
+		return nil, err
+	}
+	if offset != session.Offset {
+		return session, fmt.Errorf("%w: upload continues at byte %d", ErrUploadOffsetMismatch, session.Offset)
+	}
+
+	part, err := os.OpenFile(fs.sessions.partPath(id), os.O_WRONLY, 0600)
+	if err != nil {
+		return nil, fmt.Errorf("failed to open upload session: %w", err)
+	}
+	// Bytes past the recorded offset were written by a request that
+	// didn't get to record them
+	if err := part.Truncate(offset); err != nil {
+		part.Close()
+		return nil, fmt.Errorf("failed to open upload session: %w", err)
+	}
+	if _, err := part.Seek(offset, io.SeekStart); err != nil {
+		part.Close()
+		return nil, fmt.Errorf("failed to open upload session: %w", err)
+	}
+	written, copyErr := io.Copy(part, contextReader{ctx: ctx, r: io.LimitReader(r, session.Size-offset)})
+	if err := part.Close(); err != nil && copyErr == nil {
+		copyErr = err
+	}
+
+	session.Offset += written
+	session.ExpiresAt = fs.sessions.now().UTC().Add(fs.sessions.ttl)
+	if err := fs.sessions.save(session); err != nil {
+		return nil, err
+	}
+	if copyErr != nil {
+		fs.logger.Warnf("Upload session %s stopped at byte %d of %d: %v", id, session.Offset, session.Size, copyErr)
+		return session, fmt.Errorf("failed to write chunk: %w", copyErr)
+	}
+	return session, nil
+}
+
+// CommitUploadSession moves a complete upload to the tenant's uploads
+// directory like SaveUpload and ends the session. With a scanner set, the
+// assembled file is scanned first: an infected upload ends the session,
+// while one that couldn't be scanned can be committed again.
+func (fs *FileService) CommitUploadSession(ctx context.Context, id string) (string, *UploadSession, error) {
+	release, err := fs.acquireUploadSession(id)
+	if err != nil {
+		return "", nil, err
+	}
+	defer release()
+	session, err := fs.loadUploadSession(id)
+	if err != nil {
+		return "", nil, err
+	}
+	if session.Offset < session.Size {
+		return "", session, fmt.Errorf("%w: %d of %d bytes received", ErrUploadIncomplete, session.Offset, session.Size)
+	}
+
+	partPath := fs.sessions.partPath(id)
+	if fs.scanner != nil {
+		if err := fs.checkUpload(ctx, partPath); err != nil {
+			if errors.Is(err, ErrUploadInfected) {
+				fs.sessions.remove(id)
+			}
+			return "", session, err
+		}
+	}
+	files := fs.ForTenant(session.Tenant)
+	filePath, err := files.newUploadPath(session.Filename)
+	if err != nil {
+		return "", session, err
+	}
+	if err := moveFile(partPath, filePath); err != nil {
+		return "", session, fmt.Errorf("failed to move assembled upload: %w", err)
+	}
+	fs.sessions.remove(id)
+	if filePath, err = files.storeUpload(filePath); err != nil {
+		return "", session, err
+	}
+	return filePath, session, nil
+}
+
+// DeleteUploadSession abandons a session and the bytes received for it
+func (fs *FileService) DeleteUploadSession(id string) error {
+	release, err := fs.acquireUploadSession(id)
+	if err != nil {
+		return err
+	}
+	defer release()
+	if _, err := fs.loadUploadSession(id); err != nil {
+		return err
+	}
+	fs.sessions.remove(id)
+	fs.logger.Infof("Deleted upload session %s", id)
+	return nil
+}
+
+// UploadSessionBytes returns the declared size of the service's tenant's
+// open sessions, the storage they will take once committed
+func (fs *FileService) UploadSessionBytes() (int64, error) {
+	if fs.sessions == nil {
+		return 0, nil
+	}
+	entries, err := os.ReadDir(fs.sessions.dir)
+	if err != nil {
+		return 0, fmt.Errorf("failed to list upload sessions: %w", err)
+	}
+	var total int64
+	for _, entry := range entries {
+		id, ok := strings.CutSuffix(entry.Name(), ".json")
+		if !ok {
+			continue
+		}
+		if session, err := fs.loadUploadSession(id); err == nil {
+			total += session.Size
+		}
+	}
+	return total, nil
+}
+
+// acquireUploadSession marks a session busy, failing with
+// ErrUploadSessionBusy while another request works on it
+func (fs *FileService) acquireUploadSession(id string) (func(), error) {
+	if fs.sessions == nil || !ValidID(id) {
+		return nil, ErrUploadSessionNotFound
+	}
+	s := fs.sessions
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.busy[id] {
+		return nil, ErrUploadSessionBusy
+	}
+	s.busy[id] = true
+	return func() {
+		s.mu.Lock()
+		delete(s.busy, id)
+		s.mu.Unlock()
+	}, nil
+}
+
+// loadUploadSession reads a session, which is not found when it has
+// expired or belongs to a tenant other than the service's
+func (fs *FileService) loadUploadSession(id string) (*UploadSession, error) {
+	if !ValidID(id) {
+		return nil, ErrUploadSessionNotFound
+	}
+	session, err := fs.sessions.load(id)
+	if err != nil {
+		return nil, err
+	}
+	if fs.tenant != "" && session.Tenant != fs.tenant {
+		return nil, ErrUploadSessionNotFound
+	}
+	if fs.sessions.now().After(session.ExpiresAt) {
+		return nil, ErrUploadSessionNotFound
+	}
+	return session, nil
+}
+
+// expireUploadSessions deletes the sessions that have expired and aren't busy
+func (fs *FileService) expireUploadSessions() {
+	s := fs.sessions
+	entries, err := os.ReadDir(s.dir)
+	if err != nil {
+		fs.logger.Errorf("Failed to list upload sessions: %v", err)
+		return
+	}
+	now := s.now()
+	for _, entry := range entries {
+		id, ok := strings.CutSuffix(entry.Name(), ".json")
+		if !ok {
+			continue
+		}
+		session, err := s.load(id)
+		if err != nil || now.Before(session.ExpiresAt) {
+			continue
+		}
+		s.mu.Lock()
+		if !s.busy[id] {
+			s.remove(id)
+			fs.logger.Infof("Deleted upload session %s, which expired at byte %d of %d", id, session.Offset, session.Size)
+		}
+		s.mu.Unlock()
+	}
+}
+
+func (s *uploadSessions) statePath(id string) string {
+	return filepath.Join(s.dir, id+".json")
+}
+
+func (s *uploadSessions) partPath(id string) string {
+	return filepath.Join(s.dir, id+".part")
+}
+
+func (s *uploadSessions) load(id string) (*UploadSession, error) {
+	data, err := os.ReadFile(s.statePath(id))
+	if errors.Is(err, os.ErrNotExist) {
+		return nil, ErrUploadSessionNotFound
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to read upload session: %w", err)
+	}
+	var session UploadSession
+	if err := json.Unmarshal(data, &session); err != nil {
+		return nil, fmt.Errorf("failed to decode upload session: %w", err)
+	}
+	return &session, nil
+}
+
+func (s *uploadSessions) save(session *UploadSession) error {
+	data, err := json.Marshal(session)
+	if err != nil {
+		return fmt.Errorf("failed to encode upload session: %w", err)
+	}
+	if err := writeFileAtomic(s.statePath(session.ID), data, 0600); err != nil {
+		return fmt.Errorf("failed to save upload session: %w", err)
+	}
+	return nil
+}
+
+// remove deletes a session's state and content
+func (s *uploadSessions) remove(id string) {
+	os.Remove(s.statePath(id))
+	os.Remove(s.partPath(id))
+}
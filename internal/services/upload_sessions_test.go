@@ -0,0 +1,135 @@
+package services
+
+import (
+	"context"
+	"errors"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/sirupsen/logrus"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// failingReader returns its data, then an error instead of EOF
+type failingReader struct {
+	data string
+}
+
+func (r *failingReader) Read(p []byte) (int, error) {
+	if r.data == "" {
+		return 0, errors.New("connection reset")
+	}
+	n := copy(p, r.data)
+	r.data = r.data[n:]
+	return n, nil
+}
+
+func TestUploadSessions(t *testing.T) {
+	logger := logrus.New()
+	logger.SetLevel(logrus.FatalLevel)
+	uploadsDir, sessionsDir := t.TempDir(), t.TempDir()
+	fs := NewFileService(uploadsDir, logger)
+	require.NoError(t, fs.SetUploadSessions(sessionsDir, time.Hour))
+	ctx := context.Background()
+	content := "Department,Sales\nBooks,10\nToys,5\n"
+	acme := fs.ForTenant("acme")
+
+	_, err := acme.CreateUploadSession("sales.exe", 10)
+	assert.Error(t, err)
+	session, err := acme.CreateUploadSession("sales.csv", int64(len(content)))
+	require.NoError(t, err)
+	assert.Equal(t, "acme", session.Tenant)
+
+	_, err = fs.ForTenant("globex").UploadSession(session.ID)
+	assert.ErrorIs(t, err, ErrUploadSessionNotFound, "sessions of other tenants are not found")
+
+	// A chunk cut off part-way keeps the bytes that arrived
+	session, err = acme.WriteUploadChunk(ctx, session.ID, 0, &failingReader{data: content[:10]})
+	assert.Error(t, err)
+	require.NotNil(t, session)
+	assert.Equal(t, int64(10), session.Offset)
+
+	_, err = acme.WriteUploadChunk(ctx, session.ID, 0, strings.NewReader(content))
+	assert.ErrorIs(t, err, ErrUploadOffsetMismatch)
+	_, _, err = acme.CommitUploadSession(ctx, session.ID)
+	assert.ErrorIs(t, err, ErrUploadIncomplete)
+
+	// Bytes past the declared size are not read
+	session, err = acme.WriteUploadChunk(ctx, session.ID, 10, strings.NewReader(content[10:]+"extra"))
+	require.NoError(t, err)
+	assert.Equal(t, session.Size, session.Offset)
+
+	filePath, committed, err := acme.CommitUploadSession(ctx, session.ID)
+	require.NoError(t, err)
+	assert.Equal(t, "sales.csv", committed.Filename)
+	assert.Equal(t, filepath.Join(uploadsDir, "tenants", "acme"), filepath.Dir(filePath))
+	assert.Equal(t, ".csv", filepath.Ext(filePath))
+	data, err := os.ReadFile(filePath)
+	require.NoError(t, err)
+	assert.Equal(t, content, string(data))
+
+	_, err = acme.UploadSession(session.ID)
+	assert.ErrorIs(t, err, ErrUploadSessionNotFound, "committing ends the session")
+	entries, err := os.ReadDir(sessionsDir)
+	require.NoError(t, err)
+	assert.Empty(t, entries)
+}
+
+func TestUploadSessionsExpire(t *testing.T) {
+	logger := logrus.New()
+	logger.SetLevel(logrus.FatalLevel)
+	sessionsDir := t.TempDir()
+	fs := NewFileService(t.TempDir(), logger)
+	require.NoError(t, fs.SetUploadSessions(sessionsDir, time.Hour))
+	now := time.Now()
+	fs.sessions.now = func() time.Time { return now }
+
+	stale, err := fs.CreateUploadSession("old.csv", 100)
+	require.NoError(t, err)
+	_, err = fs.WriteUploadChunk(context.Background(), stale.ID, 0, strings.NewReader("Department,Sales\n"))
+	require.NoError(t, err)
+	now = now.Add(90 * time.Minute)
+	_, err = fs.UploadSession(stale.ID)
+	assert.ErrorIs(t, err, ErrUploadSessionNotFound)
+
+	// Creating a session deletes the expired ones
+	fresh, err := fs.CreateUploadSession("new.csv", 100)
+	require.NoError(t, err)
+	require.NoError(t, fs.DeleteUploadSession(fresh.ID))
+	entries, err := os.ReadDir(sessionsDir)
+	require.NoError(t, err)
+	assert.Empty(t, entries)
+}
+
+func TestCommitUploadSessionScanning(t *testing.T) {
+	logger := logrus.New()
+	logger.SetLevel(logrus.FatalLevel)
+	uploadsDir := t.TempDir()
+	fs := NewFileService(uploadsDir, logger)
+	require.NoError(t, fs.SetUploadSessions(t.TempDir(), time.Hour))
+	ctx := context.Background()
+
+	// Commits can be retried while the scanner is unreachable
+	require.NoError(t, fs.SetScanner(NewClamAVScanner("127.0.0.1:1", time.Second), t.TempDir()))
+	session, err := fs.CreateUploadSession("sales.csv", 5)
+	require.NoError(t, err)
+	_, err = fs.WriteUploadChunk(ctx, session.ID, 0, strings.NewReader("EICAR"))
+	require.NoError(t, err)
+	_, _, err = fs.CommitUploadSession(ctx, session.ID)
+	assert.ErrorIs(t, err, ErrScanFailed)
+	_, err = fs.UploadSession(session.ID)
+	require.NoError(t, err)
+
+	require.NoError(t, fs.SetScanner(NewClamAVScanner(fakeClamd(t), 5*time.Second), t.TempDir()))
+	_, _, err = fs.CommitUploadSession(ctx, session.ID)
+	assert.ErrorIs(t, err, ErrUploadInfected)
+	_, err = fs.UploadSession(session.ID)
+	assert.ErrorIs(t, err, ErrUploadSessionNotFound, "infected uploads end the session")
+	uploads, err := os.ReadDir(uploadsDir)
+	require.NoError(t, err)
+	assert.Empty(t, uploads)
+}
@@ -0,0 +1,454 @@
+package services
+
+import (
+	"archive/zip"
+	"encoding/csv"
+	"encoding/xml"
+	"fmt"
+	"io"
+	"os"
+	"path"
+	"strconv"
+	"strings"
+
+	"github.com/mussietl/csv-sales-api/internal/models"
+)
+
+// Sheet selections
+const (
+	SheetsFirst = ""
+	SheetsAll   = "all"
+)
+
+// maxXLSXPartBytes bounds the decompressed size of each workbook part read
+const maxXLSXPartBytes = 512 << 20
+
+// maxXLSXColumns is the number of columns in an Excel worksheet (A to XFD)
+const maxXLSXColumns = 16384
+
+// IsXLSX reports whether a file is handled as an Excel workbook
+func IsXLSX(filePath string) bool {
+	return strings.EqualFold(path.Ext(filePath), ".xlsx")
+}
+
+// ValidateSheetSelection checks a sheet selection: SheetsFirst, SheetsAll or
+// a name pattern in path.Match syntax such as "Store *"
+func ValidateSheetSelection(selection string) error {
+	if selection == SheetsFirst || selection == SheetsAll {
+		return nil
+	}
+	if _, err := path.Match(selection, ""); err != nil {
+		return fmt.Errorf("invalid sheet pattern %q: %w", selection, err)
+	}
+	return nil
+}
+
+// workbookSheet is a sheet listed in xl/workbook.xml and the part holding it
+type workbookSheet struct {
+	Name string
+	Part string
+}
+
+// convertXLSX writes the selected sheets of a workbook to w as a single CSV.
+// The first selected sheet's header row becomes the CSV header; later sheets'
+// columns are matched to it by normalized header name, and columns it lacks
+// are dropped. Rows with no values are ignored.
+func convertXLSX(filePath string, selection string, w io.Writer) ([]models.SheetStats, error) {
+	archive, err := zip.OpenReader(filePath)
+	if err != nil {
+		return nil, fmt.Errorf("failed to open workbook: %w", err)
+	}
+	defer archive.Close()
+
+	parts := make(map[string]*zip.File, len(archive.File))
+	for _, f := range archive.File {
+		parts[f.Name] = f
+	}
+
+	sheets, err := readWorkbookSheets(parts)
+	if err != nil {
+		return nil, err
+	}
+	selected, err := selectSheets(sheets, selection)
+	if err != nil {
+		return nil, err
+	}
+	sharedStrings, err := readSharedStrings(parts)
+	if err != nil {
+		return nil, err
+	}
+
+	writer := csv.NewWriter(w)
+	var baseHeader []string
+	stats := make([]models.SheetStats, 0, len(selected))
+	for _, sheet := range selected {
+		part, ok := parts[sheet.Part]
+		if !ok {
+			return nil, fmt.Errorf("sheet %q is missing from the workbook", sheet.Name)
+		}
+
+		var mapping []int
+		sheetStats := models.SheetStats{Name: sheet.Name}
+		err := readSheetRows(part, sharedStrings, func(row []string, header bool) error {
+			switch {
+			case header && baseHeader == nil:
+				baseHeader = append([]string(nil), row...)
+				mapping = identityMapping(len(baseHeader))
+				return writer.Write(row)
+			case header:
+				mapping = mapSheetColumns(baseHeader, row)
+				return nil
+			}
+			sheetStats.Rows++
+			return writer.Write(remapRow(row, mapping, len(baseHeader)))
+		})
+		if err != nil {
+			return nil, fmt.Errorf("failed to read sheet %q: %w", sheet.Name, err)
+		}
+		stats = append(stats, sheetStats)
+	}
+
+	writer.Flush()
+	if err := writer.Error(); err != nil {
+		return nil, fmt.Errorf("failed to write converted sheet rows: %w", err)
+	}
+	return stats, nil
+}
+
+// selectSheets applies a sheet selection to the workbook's sheets
+func selectSheets(sheets []workbookSheet, selection string) ([]workbookSheet, error) {
+	if len(sheets) == 0 {
+		return nil, fmt.Errorf("workbook has no sheets")
+	}
+	switch selection {
+	case SheetsFirst:
+		return sheets[:1], nil
+	case SheetsAll:
+		return sheets, nil
+	}
+
+	var selected []workbookSheet
+	for _, sheet := range sheets {
+		if ok, _ := path.Match(selection, sheet.Name); ok {
+			selected = append(selected, sheet)
+		}
+	}
+	if len(selected) == 0 {
+		return nil, fmt.Errorf("no sheet matches %q", selection)
+	}
+	return selected, nil
+}
+
+// mapSheetColumns maps each column of header to its index in baseHeader, or -1
+func mapSheetColumns(baseHeader, header []string) []int {
+	mapping := make([]int, len(header))
+	for i, col := range header {
+		mapping[i] = -1
+		name := DefaultHeaderNormalization.Apply(col)
+		for j, base := range baseHeader {
+			if name != "" && DefaultHeaderNormalization.Apply(base) == name {
+				mapping[i] = j
+				break
+			}
+		}
+	}
+	return mapping
+}
+
+func identityMapping(n int) []int {
+	mapping := make([]int, n)
+	for i := range mapping {
+		mapping[i] = i
+	}
+	return mapping
+}
+
+// remapRow places row's values at the mapped positions of a width-wide record
+func remapRow(row []string, mapping []int, width int) []string {
+	out := make([]string, width)
+	for i, value := range row {
+		if i < len(mapping) && mapping[i] >= 0 && mapping[i] < width {
+			out[mapping[i]] = value
+		}
+	}
+	return out
+}
+
+// readWorkbookSheets lists the workbook's sheets in tab order with their part names
+func readWorkbookSheets(parts map[string]*zip.File) ([]workbookSheet, error) {
+	var workbook struct {
+		Sheets []struct {
+			Name string `xml:"name,attr"`
+			RID  string `xml:"http://schemas.openxmlformats.org/officeDocument/2006/relationships id,attr"`
+		} `xml:"sheets>sheet"`
+	}
+	if err := decodeXLSXPart(parts, "xl/workbook.xml", &workbook); err != nil {
+		return nil, err
+	}
+
+	var rels struct {
+		Relationships []struct {
+			ID     string `xml:"Id,attr"`
+			Target string `xml:"Target,attr"`
+		} `xml:"Relationship"`
+	}
+	if err := decodeXLSXPart(parts, "xl/_rels/workbook.xml.rels", &rels); err != nil {
+		return nil, err
+	}
+	targets := make(map[string]string, len(rels.Relationships))
+	for _, rel := range rels.Relationships {
+		if strings.HasPrefix(rel.Target, "/") {
+			targets[rel.ID] = strings.TrimPrefix(rel.Target, "/")
+		} else {
+			targets[rel.ID] = path.Join("xl", rel.Target)
+		}
+	}
+
+	sheets := make([]workbookSheet, 0, len(workbook.Sheets))
+	for _, sheet := range workbook.Sheets {
+		sheets = append(sheets, workbookSheet{Name: sheet.Name, Part: targets[sheet.RID]})
+	}
+	return sheets, nil
+}
+
+// readSharedStrings loads the shared string table; workbooks without one have no shared strings
+func readSharedStrings(parts map[string]*zip.File) ([]string, error) {
+	part, ok := parts["xl/sharedStrings.xml"]
+	if !ok {
+		return nil, nil
+	}
+	rc, err := openXLSXPart(part)
+	if err != nil {
+		return nil, err
+	}
+	defer rc.Close()
+
+	var stringsTable []string
+	var current strings.Builder
+	inText, phonetic := false, 0
+	decoder := xml.NewDecoder(rc)
+	for {
+		token, err := decoder.Token()
+		if err == io.EOF {
+			return stringsTable, nil
+		}
+		if err != nil {
+			return nil, fmt.Errorf("failed to read shared strings: %w", err)
+		}
+		switch t := token.(type) {
+		case xml.StartElement:
+			switch t.Name.Local {
+			case "si":
+				current.Reset()
+			case "rPh":
+				phonetic++
+			case "t":
+				inText = phonetic == 0
+			}
+		case xml.EndElement:
+			switch t.Name.Local {
+			case "si":
+				stringsTable = append(stringsTable, current.String())
+			case "rPh":
+				phonetic--
+			case "t":
+				inText = false
+			}
+		case xml.CharData:
+			if inText {
+				current.Write(t)
+			}
+		}
+	}
+}
+
+// readSheetRows streams a worksheet, calling emit with each non-empty row;
+// header is true for the first one. The row slice is reused between calls.
+func readSheetRows(part *zip.File, sharedStrings []string, emit func(row []string, header bool) error) error {
+	rc, err := openXLSXPart(part)
+	if err != nil {
+		return err
+	}
+	defer rc.Close()
+
+	var row []string
+	var cellType string
+	var cellIndex int
+	var value strings.Builder
+	inValue := false
+	headerSeen := false
+	decoder := xml.NewDecoder(rc)
+	for {
+		token, err := decoder.Token()
+		if err == io.EOF {
+			return nil
+		}
+		if err != nil {
+			return err
+		}
+		switch t := token.(type) {
+		case xml.StartElement:
+			switch t.Name.Local {
+			case "row":
+				row = row[:0]
+			case "c":
+				cellType, cellIndex = "", len(row)
+				for _, attr := range t.Attr {
+					switch attr.Name.Local {
+					case "t":
+						cellType = attr.Value
+					case "r":
+						if index, ok := cellColumnIndex(attr.Value); ok {
+							cellIndex = index
+						}
+					}
+				}
+				value.Reset()
+			case "v", "t":
+				inValue = true
+			}
+		case xml.EndElement:
+			switch t.Name.Local {
+			case "v", "t":
+				inValue = false
+			case "c":
+				cell, err := cellValue(cellType, value.String(), sharedStrings)
+				if err != nil {
+					return err
+				}
+				for len(row) <= cellIndex {
+					row = append(row, "")
+				}
+				row[cellIndex] = cell
+			case "row":
+				if isEmptyRow(row) {
+					continue
+				}
+				if err := emit(row, !headerSeen); err != nil {
+					return err
+				}
+				headerSeen = true
+			}
+		case xml.CharData:
+			if inValue {
+				value.Write(t)
+			}
+		}
+	}
+}
+
+// cellValue resolves a cell's raw value according to its type
+func cellValue(cellType, raw string, sharedStrings []string) (string, error) {
+	switch cellType {
+	case "s":
+		index, err := strconv.Atoi(strings.TrimSpace(raw))
+		if err != nil || index < 0 || index >= len(sharedStrings) {
+			return "", fmt.Errorf("invalid shared string reference %q", raw)
+		}
+		return sharedStrings[index], nil
+	case "b":
+		if raw == "1" {
+			return "TRUE", nil
+		}
+		return "FALSE", nil
+	default:
+		return raw, nil
+	}
+}
+
+// cellColumnIndex returns the zero-based column of a cell reference such as "AB12"
+func cellColumnIndex(ref string) (int, bool) {
+	column := 0
+	for _, r := range ref {
+		if r < 'A' || r > 'Z' {
+			break
+		}
+		column = column*26 + int(r-'A'+1)
+		if column > maxXLSXColumns {
+			return 0, false
+		}
+	}
+	return column - 1, column > 0
+}
+
+// processWorkbook converts the selected sheets of a workbook to a temporary
+// CSV file and processes it
+func (cs *CSVService) processWorkbook(filePath string, opts ProcessOptions) (*ProcessResult, error) {
+	converted, err := os.CreateTemp("", "workbook_*.csv")
+	if err != nil {
+		return nil, fmt.Errorf("failed to create temp file: %w", err)
+	}
+	defer os.Remove(converted.Name())
+
+	sheets, err := convertXLSX(filePath, opts.Sheets, converted)
+	if closeErr := converted.Close(); err == nil && closeErr != nil {
+		err = fmt.Errorf("failed to write converted sheet rows: %w", closeErr)
+	}
+	if err != nil {
+		cs.log(opts).Errorf("Failed to read workbook: %v", err)
+		return nil, err
+	}
+	for _, sheet := range sheets {
+		cs.log(opts).Infof("Read %d rows from sheet %q", sheet.Rows, sheet.Name)
+	}
+
+	// The converted rows are comma-separated whatever delimiter was requested
+	opts.Delimiter = ","
+	result, err := cs.processCSV(converted.Name(), opts)
+	if err != nil {
+		return nil, err
+	}
+	result.Sheets = sheets
+	return result, nil
+}
+
+func isEmptyRow(row []string) bool {
+	for _, value := range row {
+		if strings.TrimSpace(value) != "" {
+			return false
+		}
+	}
+	return true
+}
+
+// decodeXLSXPart unmarshals a required workbook part
+func decodeXLSXPart(parts map[string]*zip.File, name string, v interface{}) error {
+	part, ok := parts[name]
+	if !ok {
+		return fmt.Errorf("workbook is missing %s", name)
+	}
+	rc, err := openXLSXPart(part)
+	if err != nil {
+		return err
+	}
+	defer rc.Close()
+	if err := xml.NewDecoder(rc).Decode(v); err != nil {
+		return fmt.Errorf("failed to read %s: %w", name, err)
+	}
+	return nil
+}
+
+// openXLSXPart opens a workbook part, failing reads past maxXLSXPartBytes
+func openXLSXPart(part *zip.File) (io.ReadCloser, error) {
+	rc, err := part.Open()
+	if err != nil {
+		return nil, fmt.Errorf("failed to open %s: %w", part.Name, err)
+	}
+	return &limitedPart{ReadCloser: rc, name: part.Name, remaining: maxXLSXPartBytes}, nil
+}
+
+// limitedPart errors once more than remaining bytes have been read
+type limitedPart struct {
+	io.ReadCloser
+	name      string
+	remaining int64
+}
+
+func (l *limitedPart) Read(p []byte) (int, error) {
+	n, err := l.ReadCloser.Read(p)
+	l.remaining -= int64(n)
+	if l.remaining < 0 {
+		return n, fmt.Errorf("%w: %s decompresses to more than %d bytes", ErrCSVLimitExceeded, l.name, int64(maxXLSXPartBytes))
+	}
+	return n, err
+}
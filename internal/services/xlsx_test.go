@@ -0,0 +1,128 @@
+package services
+
+import (
+	"archive/zip"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/mussietl/csv-sales-api/internal/models"
+	"github.com/sirupsen/logrus"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// writeTestWorkbook writes a minimal XLSX with two store sheets and a notes sheet
+func writeTestWorkbook(t *testing.T, path string) {
+	parts := map[string]string{
+		"xl/workbook.xml": `<?xml version="1.0" encoding="UTF-8"?>
+<workbook xmlns="http://schemas.openxmlformats.org/spreadsheetml/2006/main" xmlns:r="http://schemas.openxmlformats.org/officeDocument/2006/relationships">
+<sheets>
+<sheet name="Store North" sheetId="1" r:id="rId1"/>
+<sheet name="Store South" sheetId="2" r:id="rId2"/>
+<sheet name="Notes" sheetId="3" r:id="rId3"/>
+</sheets>
+</workbook>`,
+		"xl/_rels/workbook.xml.rels": `<?xml version="1.0" encoding="UTF-8"?>
+<Relationships xmlns="http://schemas.openxmlformats.org/package/2006/relationships">
+<Relationship Id="rId1" Type="worksheet" Target="worksheets/sheet1.xml"/>
+<Relationship Id="rId2" Type="worksheet" Target="/xl/worksheets/sheet2.xml"/>
+<Relationship Id="rId3" Type="worksheet" Target="worksheets/sheet3.xml"/>
+</Relationships>`,
+		"xl/sharedStrings.xml": `<?xml version="1.0" encoding="UTF-8"?>
+<sst xmlns="http://schemas.openxmlformats.org/spreadsheetml/2006/main">
+<si><t>Department Name</t></si>
+<si><t>Number of Sales</t></si>
+<si><r><t>Elec</t></r><r><t>tronics</t></r><rPh><t>ignored</t></rPh></si>
+<si><t>Books</t></si>
+</sst>`,
+		// Columns A and C, with an empty row in between
+		"xl/worksheets/sheet1.xml": `<?xml version="1.0" encoding="UTF-8"?>
+<worksheet xmlns="http://schemas.openxmlformats.org/spreadsheetml/2006/main"><sheetData>
+<row r="1"><c r="A1" t="s"><v>0</v></c><c r="C1" t="s"><v>1</v></c></row>
+<row r="2"><c r="A2" t="s"><v>2</v></c><c r="C2"><v>100</v></c></row>
+<row r="3"></row>
+<row r="4"><c r="A4" t="s"><v>3</v></c><c r="C4"><v>50</v></c></row>
+</sheetData></worksheet>`,
+		// Same columns in a different order, plus one the first sheet lacks
+		"xl/worksheets/sheet2.xml": `<?xml version="1.0" encoding="UTF-8"?>
+<worksheet xmlns="http://schemas.openxmlformats.org/spreadsheetml/2006/main"><sheetData>
+<row r="1"><c r="A1" t="inlineStr"><is><t>number of sales</t></is></c><c r="B1" t="inlineStr"><is><t>Region</t></is></c><c r="C1" t="s"><v>0</v></c></row>
+<row r="2"><c r="A2"><v>25</v></c><c r="B2" t="inlineStr"><is><t>South</t></is></c><c r="C2" t="s"><v>2</v></c></row>
+</sheetData></worksheet>`,
+		"xl/worksheets/sheet3.xml": `<?xml version="1.0" encoding="UTF-8"?>
+<worksheet xmlns="http://schemas.openxmlformats.org/spreadsheetml/2006/main"><sheetData>
+<row r="1"><c r="A1" t="inlineStr"><is><t>Prepared by finance</t></is></c></row>
+</sheetData></worksheet>`,
+	}
+
+	file, err := os.Create(path)
+	require.NoError(t, err)
+	defer file.Close()
+	archive := zip.NewWriter(file)
+	for name, content := range parts {
+		w, err := archive.Create(name)
+		require.NoError(t, err)
+		_, err = w.Write([]byte(content))
+		require.NoError(t, err)
+	}
+	require.NoError(t, archive.Close())
+}
+
+func TestProcessWorkbookSheetSelection(t *testing.T) {
+	logger := logrus.New()
+	logger.SetLevel(logrus.ErrorLevel)
+	cs := NewCSVService(logger)
+
+	path := filepath.Join(t.TempDir(), "regions.xlsx")
+	writeTestWorkbook(t, path)
+
+	totals := func(result *ProcessResult) map[string]string {
+		out := make(map[string]string)
+		for _, summary := range result.Summaries {
+			out[summary.Department] = summary.TotalSales.String()
+		}
+		return out
+	}
+
+	// The first sheet is processed by default
+	result, err := cs.Process(path, ProcessOptions{})
+	require.NoError(t, err)
+	assert.Equal(t, map[string]string{"Electronics": "100", "Books": "50"}, totals(result))
+	assert.Equal(t, []models.SheetStats{{Name: "Store North", Rows: 2}}, result.Sheets)
+
+	// A pattern concatenates matching sheets, aligning columns by header name
+	result, err = cs.Process(path, ProcessOptions{Sheets: "Store *"})
+	require.NoError(t, err)
+	assert.Equal(t, map[string]string{"Electronics": "125", "Books": "50"}, totals(result))
+	assert.Equal(t, []models.SheetStats{{Name: "Store North", Rows: 2}, {Name: "Store South", Rows: 1}}, result.Sheets)
+	assert.Equal(t, 3, result.Rows.Total)
+
+	// The notes sheet's only row is taken as its header, so it adds no data rows
+	result, err = cs.Process(path, ProcessOptions{Sheets: SheetsAll})
+	require.NoError(t, err)
+	assert.Len(t, result.Sheets, 3)
+	assert.Equal(t, 0, result.Sheets[2].Rows)
+
+	_, err = cs.Process(path, ProcessOptions{Sheets: "Warehouse*"})
+	assert.ErrorContains(t, err, "no sheet matches")
+}
+
+func TestValidateSheetSelection(t *testing.T) {
+	assert.NoError(t, ValidateSheetSelection(""))
+	assert.NoError(t, ValidateSheetSelection("all"))
+	assert.NoError(t, ValidateSheetSelection("Store ?"))
+	assert.Error(t, ValidateSheetSelection("Store ["))
+}
+
+func TestCellColumnIndex(t *testing.T) {
+	for ref, want := range map[string]int{"A1": 0, "C7": 2, "Z2": 25, "AA10": 26, "XFD1": 16383} {
+		got, ok := cellColumnIndex(ref)
+		assert.True(t, ok, ref)
+		assert.Equal(t, want, got, ref)
+	}
+	_, ok := cellColumnIndex("12")
+	assert.False(t, ok)
+	_, ok = cellColumnIndex("XFE1")
+	assert.False(t, ok)
+}
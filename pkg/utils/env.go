@@ -1,6 +1,10 @@
 package utils
 
-import "os"
+import (
+	"os"
+	"strconv"
+	"strings"
+)
 
 // GetEnv gets an environment variable with a fallback default value
 func GetEnv(key, defaultValue string) string {
@@ -9,3 +13,38 @@ func GetEnv(key, defaultValue string) string {
 	}
 	return defaultValue
 }
+
+// GetEnvBool gets a boolean environment variable with a fallback default value
+func GetEnvBool(key string, defaultValue bool) bool {
+	if value, err := strconv.ParseBool(strings.TrimSpace(os.Getenv(key))); err == nil {
+		return value
+	}
+	return defaultValue
+}
+
+// GetEnvInt gets an integer environment variable with a fallback default value
+func GetEnvInt(key string, defaultValue int) int {
+	if value, err := strconv.Atoi(strings.TrimSpace(os.Getenv(key))); err == nil {
+		return value
+	}
+	return defaultValue
+}
+
+// GetEnvList gets a comma-separated environment variable as a slice, skipping empty entries
+func GetEnvList(key string) []string {
+	var values []string
+	for _, value := range strings.Split(os.Getenv(key), ",") {
+		if value = strings.TrimSpace(value); value != "" {
+			values = append(values, value)
+		}
+	}
+	return values
+}
+
+// GetEnvFloat gets a floating-point environment variable with a fallback default value
+func GetEnvFloat(key string, defaultValue float64) float64 {
+	if value, err := strconv.ParseFloat(strings.TrimSpace(os.Getenv(key)), 64); err == nil {
+		return value
+	}
+	return defaultValue
+}